@@ -0,0 +1,23 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api holds the OpenAPI specification of the REST API
+package api
+
+import _ "embed"
+
+// Spec is the OpenAPI document of the API server, in YAML
+//
+//go:embed openusp.yaml
+var Spec []byte
@@ -16,6 +16,9 @@ package main
 
 import (
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/n4-networks/openusp/internal/apiserver"
 )
@@ -28,6 +31,19 @@ func main() {
 	if err := as.Init(); err != nil {
 		log.Println("Error:", err)
 	}
+
+	// Handle graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		log.Println("Shutting down API Server...")
+		if err := as.Stop(); err != nil {
+			log.Println("Error during shutdown:", err)
+		}
+	}()
+
 	log.Println("Starting API Server...")
 	if err := as.Server(); err != nil {
 		log.Println("Error: API Server is exiting...Err:", err)
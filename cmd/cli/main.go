@@ -15,18 +15,16 @@
 package main
 
 import (
-	"log"
+	"os"
 
 	"github.com/n4-networks/openusp/internal/cli"
 )
 
+// The interactive shell is started without arguments, commands can be run
+// one-shot for automation, e.g.
+// openusp-cli --quiet cwmp devices show
+// openusp-cli completion bash > /etc/bash_completion.d/openusp-cli
 func main() {
-
-	cli := &cli.Cli{}
-	if err := cli.Init(); err != nil {
-		log.Println("Error in initializing shell, exiting...:", err)
-		return
-	}
-
-	cli.Run()
+	c := &cli.Cli{}
+	os.Exit(c.Execute())
 }
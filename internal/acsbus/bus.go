@@ -0,0 +1,253 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acsbus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Transports of the ACS bus
+const (
+	TransportStomp = "stomp"
+	TransportMqtt  = "mqtt"
+)
+
+// Default destinations of events and commands
+const (
+	DefaultEventTopic   = "/queue/cwmp-events"
+	DefaultCommandTopic = "/queue/cwmp-commands"
+)
+
+// Types of the events published by the ACS
+const (
+	EventInform     = "inform"
+	EventParameters = "parameters"
+	EventTransfer   = "transfer"
+	// EventObject reports an object instance added or deleted by a device
+	EventObject = "object"
+)
+
+// RPC methods the ACS accepts as commands
+const (
+	MethodGetParameterValues = "GetParameterValues"
+	MethodSetParameterValues = "SetParameterValues"
+	MethodReboot             = "Reboot"
+	MethodFactoryReset       = "FactoryReset"
+	MethodGetRPCMethods      = "GetRPCMethods"
+	MethodGetParameterNames  = "GetParameterNames"
+	MethodAddObject          = "AddObject"
+	MethodDeleteObject       = "DeleteObject"
+	MethodDownload           = "Download"
+	MethodUpload             = "Upload"
+	MethodGetQueuedTransfers = "GetQueuedTransfers"
+	// MethodGetAllQueuedTransfers also lists the transfers the device did
+	// not receive from the ACS
+	MethodGetAllQueuedTransfers = "GetAllQueuedTransfers"
+	MethodChangeDUState         = "ChangeDUState"
+	// MethodConnectionRequest asks the ACS to send a connection request
+	MethodConnectionRequest = "ConnectionRequest"
+	// MethodRotateCredentials asks the ACS to rotate the connection request
+	// credentials of a device
+	MethodRotateCredentials = "RotateCredentials"
+)
+
+// DeviceInfo identifies the device an Inform came from
+type DeviceInfo struct {
+	Manufacturer string `json:"manufacturer"`
+	OUI          string `json:"oui"`
+	ProductClass string `json:"product_class"`
+	SerialNumber string `json:"serial_number"`
+}
+
+// Parameter is a parameter value reported by or sent to a device
+type Parameter struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Type  string `json:"type,omitempty"`
+}
+
+// Transfer describes the file of a Download or Upload command
+type Transfer struct {
+	FileType       string `json:"file_type"`
+	URL            string `json:"url"`
+	FileID         string `json:"file_id,omitempty"` // file of the ACS file server, replaces URL
+	Username       string `json:"username,omitempty"`
+	Password       string `json:"password,omitempty"`
+	FileSize       uint32 `json:"file_size,omitempty"`
+	TargetFileName string `json:"target_file_name,omitempty"`
+	DelaySeconds   uint32 `json:"delay_seconds,omitempty"`
+	SuccessURL     string `json:"success_url,omitempty"`
+	FailureURL     string `json:"failure_url,omitempty"`
+}
+
+// Event is published by the ACS when a device informs, reports parameter
+// values, adds or deletes an object or completes a transfer
+type Event struct {
+	Type       string      `json:"type"`
+	DeviceID   string      `json:"device_id"`
+	Device     *DeviceInfo `json:"device,omitempty"`
+	Events     []string    `json:"events,omitempty"`
+	Parameters []Parameter `json:"parameters,omitempty"`
+	CommandKey string      `json:"command_key,omitempty"`
+	// ObjectName and InstanceNumber identify the instance of an object
+	// event, the instance number is 0 for a deleted object
+	ObjectName     string `json:"object_name,omitempty"`
+	InstanceNumber uint32 `json:"instance_number,omitempty"`
+	// FaultCode and FaultString report a failed transfer
+	FaultCode   uint32    `json:"fault_code,omitempty"`
+	FaultString string    `json:"fault_string,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"` // request of the command answered
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// DUOperation is the ChangeDUState operation of a command, Type is install,
+// update or uninstall
+type DUOperation struct {
+	Type            string `json:"type"`
+	URL             string `json:"url,omitempty"`
+	UUID            string `json:"uuid,omitempty"`
+	Version         string `json:"version,omitempty"`
+	Username        string `json:"username,omitempty"`
+	Password        string `json:"password,omitempty"`
+	ExecutionEnvRef string `json:"execution_env_ref,omitempty"`
+}
+
+// Command asks the ACS to queue an RPC for a device
+type Command struct {
+	DeviceID       string       `json:"device_id"`
+	Method         string       `json:"method"`
+	ParameterNames []string     `json:"parameter_names,omitempty"`
+	ParameterPath  string       `json:"parameter_path,omitempty"`
+	NextLevel      bool         `json:"next_level,omitempty"`
+	ObjectName     string       `json:"object_name,omitempty"`
+	Transfer       *Transfer    `json:"transfer,omitempty"`
+	DUOperation    *DUOperation `json:"du_operation,omitempty"`
+	Parameters     []Parameter  `json:"parameters,omitempty"`
+	ParameterKey   string       `json:"parameter_key,omitempty"`
+	CommandKey     string       `json:"command_key,omitempty"`
+	RotationID     string       `json:"rotation_id,omitempty"` // credential rotation of a RotateCredentials command
+	RequestID      string       `json:"request_id,omitempty"`
+	TTL            int          `json:"ttl,omitempty"` // seconds to wait for an offline device
+	Timestamp      time.Time    `json:"timestamp"`
+	// HoldRequests keeps the CPE from sending requests of its own until it
+	// answered the command and the held commands queued before it
+	HoldRequests bool `json:"hold_requests,omitempty"`
+}
+
+// transport moves raw messages over a broker
+type transport interface {
+	publish(topic string, payload []byte) error
+	subscribe(topic string, handler func(payload []byte)) error
+	close() error
+}
+
+// Client publishes and consumes ACS events and commands
+type Client struct {
+	tr           transport
+	eventTopic   string
+	commandTopic string
+}
+
+// Connect connects to the broker of the transport selected in the ACS bus
+// configuration
+func Connect(cfg *config.MessageBusConfig, clientID string) (*Client, error) {
+	c := &Client{
+		eventTopic:   cfg.ACS.EventTopic,
+		commandTopic: cfg.ACS.CommandTopic,
+	}
+	if c.eventTopic == "" {
+		c.eventTopic = DefaultEventTopic
+	}
+	if c.commandTopic == "" {
+		c.commandTopic = DefaultCommandTopic
+	}
+
+	var err error
+	switch cfg.ACS.Transport {
+	case TransportStomp, "":
+		c.tr, err = connectStomp(&cfg.STOMP)
+	case TransportMqtt:
+		c.tr, err = connectMqtt(&cfg.MQTT, clientID)
+	default:
+		return nil, fmt.Errorf("unknown ACS bus transport: %s", cfg.ACS.Transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Connected to ACS bus, events: %s, commands: %s", c.eventTopic, c.commandTopic)
+	return c, nil
+}
+
+// PublishEvent publishes an ACS event
+func (c *Client) PublishEvent(e *Event) error {
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now()
+	}
+	return c.publish(c.eventTopic, e)
+}
+
+// SendCommand sends an RPC command to the ACS
+func (c *Client) SendCommand(cmd *Command) error {
+	if cmd.DeviceID == "" || cmd.Method == "" {
+		return errors.New("command requires a device and a method")
+	}
+	if cmd.Timestamp.IsZero() {
+		cmd.Timestamp = time.Now()
+	}
+	return c.publish(c.commandTopic, cmd)
+}
+
+// SubscribeEvents calls fn for every ACS event received
+func (c *Client) SubscribeEvents(fn func(*Event)) error {
+	return c.tr.subscribe(c.eventTopic, func(payload []byte) {
+		var e Event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			log.Printf("Dropping invalid ACS event: %v", err)
+			return
+		}
+		fn(&e)
+	})
+}
+
+// SubscribeCommands calls fn for every RPC command received
+func (c *Client) SubscribeCommands(fn func(*Command)) error {
+	return c.tr.subscribe(c.commandTopic, func(payload []byte) {
+		var cmd Command
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			log.Printf("Dropping invalid ACS command: %v", err)
+			return
+		}
+		fn(&cmd)
+	})
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	return c.tr.close()
+}
+
+func (c *Client) publish(topic string, msg interface{}) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.tr.publish(topic, payload)
+}
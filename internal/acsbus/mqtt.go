@@ -0,0 +1,72 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acsbus
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+type mqttTransport struct {
+	client mqtt.Client
+}
+
+func connectMqtt(cfg *config.MqttConfig, clientID string) (*mqttTransport, error) {
+	scheme := "tcp://"
+	if cfg.EnableTLS {
+		scheme = "ssl://"
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	if cfg.ClientID != "" {
+		clientID = cfg.ClientID
+	}
+	opts := mqtt.NewClientOptions().AddBroker(scheme + addr).SetClientID(clientID)
+	opts.SetUsername(cfg.Username)
+	opts.SetPassword(cfg.Password)
+	opts.SetAutoReconnect(true)
+	// Subscriptions are restored by the broker on reconnect
+	opts.SetCleanSession(false)
+
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, fmt.Errorf("failed to connect to MQTT broker %s: %w", addr, token.Error())
+	}
+	return &mqttTransport{client: client}, nil
+}
+
+func (m *mqttTransport) publish(topic string, payload []byte) error {
+	token := m.client.Publish(topic, 1, false, payload)
+	token.Wait()
+	return token.Error()
+}
+
+func (m *mqttTransport) subscribe(topic string, handler func(payload []byte)) error {
+	token := m.client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		handler(msg.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		return fmt.Errorf("could not subscribe to %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
+func (m *mqttTransport) close() error {
+	m.client.Disconnect(250)
+	return nil
+}
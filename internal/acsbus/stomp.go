@@ -0,0 +1,94 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acsbus
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gmallard/stompngo"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+type stompTransport struct {
+	conn *stompngo.Connection
+}
+
+func connectStomp(cfg *config.StompConfig) (*stompTransport, error) {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	if cfg.EnableTLS {
+		addr = net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.TLSPort))
+	}
+
+	var n net.Conn
+	var err error
+	for i := 0; i <= cfg.ConnRetry; i++ {
+		if cfg.EnableTLS {
+			n, err = tls.Dial("tcp", addr, &tls.Config{ServerName: cfg.Host})
+		} else {
+			n, err = net.DialTimeout("tcp", addr, time.Minute)
+		}
+		if err == nil {
+			break
+		}
+		log.Printf("Connection to STOMP server %s failed (%v of %v): %v", addr, i, cfg.ConnRetry, err)
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to STOMP server %s: %w", addr, err)
+	}
+
+	h := stompngo.Headers{"accept-version", "1.1", "host", cfg.Host}
+	if cfg.Username != "" {
+		h = h.Add("login", cfg.Username).Add("passcode", cfg.Password)
+	}
+	conn, err := stompngo.Connect(n, h)
+	if err != nil {
+		n.Close()
+		return nil, fmt.Errorf("failed to connect to STOMP server %s: %w", addr, err)
+	}
+	return &stompTransport{conn: conn}, nil
+}
+
+func (s *stompTransport) publish(topic string, payload []byte) error {
+	h := stompngo.Headers{"destination", topic, "content-type", "application/json"}
+	return s.conn.SendBytes(h, payload)
+}
+
+func (s *stompTransport) subscribe(topic string, handler func(payload []byte)) error {
+	h := stompngo.Headers{"destination", topic, "id", stompngo.Uuid()}
+	sub, err := s.conn.Subscribe(h)
+	if err != nil {
+		return fmt.Errorf("could not subscribe to %s: %w", topic, err)
+	}
+	go func() {
+		for md := range sub {
+			if md.Error != nil {
+				log.Printf("STOMP error on %s: %v", topic, md.Error)
+				continue
+			}
+			handler(md.Message.Body)
+		}
+	}()
+	return nil
+}
+
+func (s *stompTransport) close() error {
+	return s.conn.Disconnect(stompngo.Headers{})
+}
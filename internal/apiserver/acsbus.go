@@ -0,0 +1,52 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+)
+
+// connectAcsBus connects to the ACS bus to send RPC commands to the ACS
+// directly, the outcome of the RPCs is read back from the CWMP database
+func (as *ApiServer) connectAcsBus() error {
+	if as.config == nil || !as.config.MessageBus.ACS.Enabled {
+		log.Println("ACS bus is disabled, CWMP RPCs cannot be sent")
+		return nil
+	}
+	bus, err := acsbus.Connect(&as.config.MessageBus, "openusp-apiserver")
+	if err != nil {
+		return err
+	}
+	as.bus = bus
+	return nil
+}
+
+// sendAcsCommand sends an RPC command to the ACS
+func (as *ApiServer) sendAcsCommand(cmd *acsbus.Command) error {
+	if as.bus == nil {
+		return errUnavailable("ACS bus is not connected")
+	}
+	if err := as.bus.SendCommand(cmd); err != nil {
+		return errUnavailable("failed to send %s command to the ACS: %v", cmd.Method, err)
+	}
+	return nil
+}
+
+// errAcsFault reports an RPC the device answered with a fault
+func errAcsFault(method string, deviceId string, code string, reason string) error {
+	return errControllerFailure("device %s answered %s with fault %s: %s", deviceId, method, code, reason)
+}
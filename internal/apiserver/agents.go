@@ -15,13 +15,12 @@
 package apiserver
 
 import (
-	"errors"
 	"log"
 )
 
 func (as *ApiServer) getAgentIds() ([]string, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	agentIds, err := as.dbH.uspIntf.GetAllEndpoints()
 	if err != nil {
@@ -0,0 +1,135 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+const (
+	defaultAnalyticsInterval = time.Hour
+	defaultTrendDays         = 30
+	maxTrendDays             = 366
+)
+
+// startAnalyticsJobs backfills missing daily rollups and then periodically
+// refreshes the rollups of today and yesterday
+func (as *ApiServer) startAnalyticsJobs() {
+	cfg := as.config.Analytics
+	if !cfg.Enabled {
+		log.Println("Analytics aggregation jobs are disabled")
+		return
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultAnalyticsInterval
+	}
+
+	as.goBackground(func() { as.backfillRollups(cfg.BackfillDays) })
+	as.every(interval, func() {
+		now := time.Now()
+		as.refreshRollup(now.Add(-24 * time.Hour))
+		as.refreshRollup(now)
+	})
+	log.Println("Analytics aggregation jobs scheduled every", interval)
+}
+
+// backfillRollups builds the rollups of the last days which are missing
+func (as *ApiServer) backfillRollups(days int) {
+	if as.dbH.cwmpIntf == nil {
+		return
+	}
+	now := time.Now()
+	for i := days; i > 0; i-- {
+		day := now.Add(-time.Duration(i) * 24 * time.Hour)
+		if _, err := as.dbH.cwmpIntf.GetCwmpDailyRollup(day.UTC().Format(db.RollupDateFormat)); err == nil {
+			continue
+		}
+		as.refreshRollup(day)
+	}
+	as.refreshRollup(now)
+}
+
+func (as *ApiServer) refreshRollup(day time.Time) {
+	if as.dbH.cwmpIntf == nil {
+		return
+	}
+	rollup, err := as.dbH.cwmpIntf.BuildCwmpDailyRollup(day)
+	if err != nil {
+		log.Printf("Error aggregating statistics of %s: %v", day.UTC().Format(db.RollupDateFormat), err)
+		return
+	}
+	if err := as.dbH.cwmpIntf.UpsertCwmpDailyRollup(rollup); err != nil {
+		log.Printf("Error storing statistics of %s: %v", rollup.ID, err)
+	}
+}
+
+// getStatsTrends returns the daily rollups of the requested period. The period
+// is given either as days=N (ending today) or as from/to dates (YYYY-MM-DD).
+func (as *ApiServer) getStatsTrends(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-(defaultTrendDays - 1) * 24 * time.Hour)
+
+	q := r.URL.Query()
+	if daysStr := q.Get("days"); daysStr != "" {
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days <= 0 || days > maxTrendDays {
+			httpSendRes(w, nil, errBadRequest("invalid days: %s", daysStr))
+			return
+		}
+		from = to.Add(-time.Duration(days-1) * 24 * time.Hour)
+	}
+	if fromStr := q.Get("from"); fromStr != "" {
+		t, err := time.Parse(db.RollupDateFormat, fromStr)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid from date: %s", fromStr))
+			return
+		}
+		from = t
+	}
+	if toStr := q.Get("to"); toStr != "" {
+		t, err := time.Parse(db.RollupDateFormat, toStr)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid to date: %s", toStr))
+			return
+		}
+		to = t
+	}
+
+	rollups, err := as.dbH.cwmpIntf.GetCwmpDailyRollups(from, to)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve trends: %w", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"from":  from.Format(db.RollupDateFormat),
+		"to":    to.Format(db.RollupDateFormat),
+		"days":  rollups,
+		"count": len(rollups),
+	}
+	httpSendRes(w, response, nil)
+}
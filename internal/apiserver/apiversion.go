@@ -0,0 +1,83 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// API_V1 prefixes the routes of version 1 of the REST API. A breaking change
+// ships under a new prefix, with its own subrouter, while the routes of the
+// previous version keep being served and are marked deprecated.
+const API_V1 = "/api/v1"
+
+// unversionedRoutes are the service endpoints served outside of the API
+// versions
+var unversionedRoutes = map[string]bool{
+	HEALTH: true,
+}
+
+// setLegacyRoutesHandlers serves the v1 routes under their original,
+// unversioned paths, as deprecated aliases for the existing clients
+func (as *ApiServer) setLegacyRoutesHandlers() error {
+	count := 0
+	err := as.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, API_V1+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		legacy := strings.TrimPrefix(tmpl, API_V1)
+		if unversionedRoutes[legacy] {
+			return nil
+		}
+		as.rootRouter.Handle(legacy, deprecatedBy(API_V1, "")(route.GetHandler())).Methods(methods...)
+		count++
+		return nil
+	})
+	log.Printf("Serving %d legacy API routes as deprecated aliases of %s", count, API_V1)
+	return err
+}
+
+// deprecatedBy marks the responses of a deprecated route, pointing to the
+// same path with the successor prefix in place of the route prefix
+func deprecatedBy(successorPrefix string, prefix string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			successor := successorPrefix + strings.TrimPrefix(r.URL.Path, prefix)
+			w.Header().Set("Deprecation", "true")
+			w.Header().Add("Link", "<"+successor+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiPath returns the path of a request without its API version prefix
+func apiPath(path string) string {
+	return strings.TrimPrefix(path, API_V1)
+}
+
+// isHealthCheck reports whether a request is a health check, which is
+// served without authentication
+func isHealthCheck(r *http.Request) bool {
+	return r.URL.Path == HEALTH || r.URL.Path == API_V1+HEALTH
+}
@@ -0,0 +1,300 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const AUDIT = "/audit"
+
+const (
+	// auditMaxPayload bounds the request body kept in an audit entry
+	auditMaxPayload = 16 << 10
+	// auditMaxError bounds the error response read for the audit entry
+	auditMaxError = 4 << 10
+	// auditRedacted replaces the credentials in the audited payloads
+	auditRedacted = "[REDACTED]"
+)
+
+// auditedGetPrefixes are the legacy USP routes which change the controller
+// or the database through a GET
+var auditedGetPrefixes = []string{DELETE_INSTANCES, DELETE_DBCOLL, RECONNECT_MTP, RECONNECT_DB}
+
+// auditExemptRoutes are the POST routes which only read
+var auditExemptRoutes = map[string]bool{
+	CWMP_SEARCH_DEVICES: true,
+	GRAPHQL:             true,
+}
+
+// auditSensitiveKeys mark the JSON members, or parameter names, whose value
+// is redacted from the audited payloads
+var auditSensitiveKeys = []string{"password", "passwd", "secret", "token", "passphrase",
+	"presharedkey", "wepkey", "privatekey", "private_key", "apikey", "api_key"}
+
+func (as *ApiServer) setAuditRoutesHandlers() {
+	as.router.HandleFunc(AUDIT, as.getAuditEntries).Methods("GET")
+}
+
+// auditRecorder captures the status and error response of an audited
+// request
+type auditRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *auditRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *auditRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if rec.status >= 400 && rec.body.Len() < auditMaxError {
+		rec.body.Write(b[:min(len(b), auditMaxError-rec.body.Len())])
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *auditRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// isAuditedRequest reports whether a request changes devices, the
+// controller or the stored configuration
+func isAuditedRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil && auditExemptRoutes[apiPath(tmpl)] {
+				return false
+			}
+		}
+		return true
+	case http.MethodGet:
+		for _, prefix := range auditedGetPrefixes {
+			if strings.HasPrefix(apiPath(r.URL.Path), prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// middlewareAudit records the mutating requests of the authenticated users,
+// including the ones refused by the rate limits and quotas
+func (as *ApiServer) middlewareAudit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAuditedRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		var payload []byte
+		if r.Body != nil {
+			payload, _ = io.ReadAll(io.LimitReader(r.Body, auditMaxPayload))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(payload), r.Body), r.Body}
+		}
+		rec := &auditRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		username := requestUser(r)
+		entry := &db.AuditEntry{
+			Timestamp:  start,
+			User:       username,
+			SourceIP:   clientIP(r),
+			RequestID:  logging.RequestID(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Payload:    auditPayload(r.Header.Get("Content-Type"), payload),
+			Status:     rec.status,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		entry.Action = r.Method + " " + apiPath(r.URL.Path)
+		if route := mux.CurrentRoute(r); route != nil {
+			if tmpl, err := route.GetPathTemplate(); err == nil {
+				entry.Action = r.Method + " " + apiPath(tmpl)
+			}
+		}
+		if vars := mux.Vars(r); len(vars) > 0 {
+			entry.Params = vars
+			entry.DeviceID = vars["deviceId"]
+			if entry.DeviceID == "" {
+				entry.DeviceID = vars["epId"]
+			}
+		}
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Outcome = db.AuditOutcomeSuccess
+		if entry.Status >= 400 {
+			entry.Outcome = db.AuditOutcomeFailure
+			entry.Error = auditError(rec.body.Bytes())
+		}
+		as.recordAuditEntry(r, entry)
+	})
+}
+
+// recordAuditEntry stores an audit entry, and logs it when it cannot be
+// stored so that the trail is not lost
+func (as *ApiServer) recordAuditEntry(r *http.Request, entry *db.AuditEntry) {
+	logger := logging.FromContext(r.Context())
+	if as.dbH.cwmpIntf != nil {
+		err := as.dbH.cwmpIntf.InsertAuditEntry(entry)
+		if err == nil {
+			return
+		}
+		logger.Errorf("Error storing audit entry: %v", err)
+	}
+	logger.Warnf("Audit: %s by user %q from %s on device %q: %s %d",
+		entry.Action, entry.User, entry.SourceIP, entry.DeviceID, entry.Outcome, entry.Status)
+}
+
+// auditPayload returns the JSON body with its credentials redacted, other
+// bodies such as file uploads are only described
+func auditPayload(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		if contentType == "" {
+			contentType = "unknown content"
+		}
+		return "<" + contentType + ">"
+	}
+	b, err := json.Marshal(redactAuditValue(v))
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// redactAuditValue redacts the members with a sensitive name, and the value
+// of the {"name": ..., "value": ...} parameters with a sensitive name
+func redactAuditValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, member := range v {
+			if isSensitiveAuditKey(k) {
+				v[k] = auditRedacted
+			} else {
+				v[k] = redactAuditValue(member)
+			}
+		}
+		for _, nameKey := range []string{"name", "path", "param"} {
+			if name, ok := v[nameKey].(string); ok && isSensitiveAuditKey(name) {
+				if _, ok := v["value"]; ok {
+					v["value"] = auditRedacted
+				}
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactAuditValue(v[i])
+		}
+	}
+	return v
+}
+
+func isSensitiveAuditKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range auditSensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditError returns the detail of a problem response, or the start of any
+// other error response
+func auditError(body []byte) string {
+	var p Problem
+	if err := json.Unmarshal(body, &p); err == nil && (p.Detail != "" || p.Title != "") {
+		if p.Detail != "" {
+			return p.Detail
+		}
+		return p.Title
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// getAuditEntries returns the audit trail, newest first
+func (as *ApiServer) getAuditEntries(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := bson.M{}
+	for param, field := range map[string]string{
+		"user":       "user",
+		"device_id":  "device_id",
+		"method":     "method",
+		"outcome":    "outcome",
+		"source_ip":  "source_ip",
+		"request_id": "request_id",
+	} {
+		if value := query.Get(param); value != "" {
+			filter[field] = value
+		}
+	}
+	if action := query.Get("action"); action != "" {
+		filter["action"] = bson.M{"$regex": regexp.QuoteMeta(action), "$options": "i"}
+	}
+	window := bson.M{}
+	for param, op := range map[string]string{"since": "$gte", "until": "$lt"} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				httpSendRes(w, nil, errBadRequest("invalid %s, expected RFC3339: %s", param, value))
+				return
+			}
+			window[op] = t
+		}
+	}
+	if len(window) > 0 {
+		filter["timestamp"] = window
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	entries, err := as.dbH.cwmpIntf.GetAuditEntries(filter, limit)
+	httpSendRes(w, entries, err)
+}
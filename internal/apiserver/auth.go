@@ -15,21 +15,310 @@
 package apiserver
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Lockout defaults, used when the auth config leaves them unset
+const (
+	defaultMaxFailedLogins = 5
+	defaultFailureWindow   = 15 * time.Minute
+	defaultLockoutDuration = 15 * time.Minute
 )
 
-var users = map[string]string{
-	"n4admin": "n4defaultpass",
+// verifiedLoginTTL is how long a verified username and password are accepted
+// without comparing the password with its bcrypt hash again
+const verifiedLoginTTL = time.Minute
+
+// APIKeyHeader carries the API key of a client authenticating with a key
+// instead of a username and password
+const APIKeyHeader = "X-API-Key"
+
+// users maps the API users to the bcrypt hash of their password
+var users = map[string][]byte{}
+
+// apiKeys maps the SHA-256 digest of the API keys to the user they act as
+var apiKeys = map[[sha256.Size]byte]string{}
+
+// userKey is the context key of the authenticated user
+type userKey struct{}
+
+// dummyHash is compared against for unknown users, so that a failed login
+// takes the same time whether the user exists or not
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("openusp-unknown-user"), bcrypt.DefaultCost)
+
+var logins = newLoginGuard(defaultMaxFailedLogins, defaultFailureWindow, defaultLockoutDuration)
+
+var verifiedLogins = newLoginCache()
+
+// configureAuth sets up the API users and keys from the auth config. The
+// passwords may be given as bcrypt hashes, plaintext passwords are hashed on
+// load.
+func configureAuth(cfg config.AuthConfig) error {
+	configured := cfg.Users
+	if cfg.Username != "" || cfg.Password != "" {
+		configured = append([]config.UserConfig{{Username: cfg.Username, Password: cfg.Password}}, configured...)
+	}
+	if len(configured) == 0 && len(cfg.APIKeys) == 0 {
+		return errors.New("authentication credentials not configured")
+	}
+
+	newUsers := map[string][]byte{}
+	for _, u := range configured {
+		if u.Username == "" || u.Password == "" {
+			return errors.New("authentication credentials not configured")
+		}
+		hash := []byte(u.Password)
+		if _, err := bcrypt.Cost(hash); err != nil {
+			if hash, err = bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost); err != nil {
+				return err
+			}
+		}
+		newUsers[u.Username] = hash
+	}
+	newKeys := map[[sha256.Size]byte]string{}
+	for _, k := range cfg.APIKeys {
+		digest, err := apiKeyDigest(k.Key)
+		if err != nil {
+			return fmt.Errorf("API key %q: %w", k.Name, err)
+		}
+		if k.Name == "" {
+			return errors.New("API key without a name")
+		}
+		newKeys[digest] = k.Name
+	}
+	users, apiKeys = newUsers, newKeys
+	verifiedLogins = newLoginCache()
+
+	maxFailures, window, lockout := cfg.MaxFailedLogins, cfg.FailureWindow, cfg.LockoutDuration
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxFailedLogins
+	}
+	if window <= 0 {
+		window = defaultFailureWindow
+	}
+	if lockout <= 0 {
+		lockout = defaultLockoutDuration
+	}
+	logins = newLoginGuard(maxFailures, window, lockout)
+	return nil
+}
+
+// apiKeyDigest returns the SHA-256 digest of a configured API key, given in
+// plaintext or as sha256:<hex digest>
+func apiKeyDigest(key string) ([sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+	if hexDigest, ok := strings.CutPrefix(key, "sha256:"); ok {
+		b, err := hex.DecodeString(hexDigest)
+		if err != nil || len(b) != sha256.Size {
+			return digest, errors.New("invalid sha256 digest")
+		}
+		copy(digest[:], b)
+		return digest, nil
+	}
+	if key == "" {
+		return digest, errors.New("empty key")
+	}
+	return sha256.Sum256([]byte(key)), nil
+}
+
+// apiKeyUser returns the user an API key acts as
+func apiKeyUser(key string) (string, bool) {
+	name, ok := apiKeys[sha256.Sum256([]byte(key))]
+	return name, ok
+}
+
+// withUser returns a copy of ctx carrying the authenticated user
+func withUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, userKey{}, username)
 }
 
+// requestUser returns the authenticated user of a request, empty for the
+// requests served without authentication
+func requestUser(r *http.Request) string {
+	username, _ := r.Context().Value(userKey{}).(string)
+	return username
+}
+
+// isAuthorized checks the password of a user. Only the first request with a
+// password and the failed logins cost a bcrypt comparison, a password which
+// matched is accepted for verifiedLoginTTL.
 func isAuthorized(username, password string) bool {
-	pass, ok := users[username]
+	if verifiedLogins.verified(username, password) {
+		return true
+	}
+	hash, ok := users[username]
 	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
 		return false
 	}
-	return pass == password
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+	verifiedLogins.add(username, password)
+	return true
+}
+
+// loginCache remembers the logins verified recently. The usernames and
+// passwords are keyed by their HMAC with a random key, the passwords are not
+// kept.
+type loginCache struct {
+	mu      sync.Mutex
+	key     []byte
+	expires map[[sha256.Size]byte]time.Time
+}
+
+func newLoginCache() *loginCache {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		log.Println("Error generating the login cache key:", err)
+	}
+	return &loginCache{key: key, expires: map[[sha256.Size]byte]time.Time{}}
+}
+
+func (c *loginCache) digest(username, password string) [sha256.Size]byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(username))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	var digest [sha256.Size]byte
+	copy(digest[:], mac.Sum(nil))
+	return digest
+}
+
+// verified reports whether a login was verified within verifiedLoginTTL
+func (c *loginCache) verified(username, password string) bool {
+	digest := c.digest(username, password)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires, ok := c.expires[digest]
+	return ok && time.Now().Before(expires)
+}
+
+// add remembers a verified login for verifiedLoginTTL
+func (c *loginCache) add(username, password string) {
+	digest := c.digest(username, password)
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for d, expires := range c.expires {
+		if !now.Before(expires) {
+			delete(c.expires, d)
+		}
+	}
+	c.expires[digest] = now.Add(verifiedLoginTTL)
+}
+
+// loginFailures counts the failed logins of a client IP or user
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// loginGuard locks out the client IPs and users with too many failed logins
+// within the failure window
+type loginGuard struct {
+	mu          sync.Mutex
+	maxFailures int
+	window      time.Duration
+	lockout     time.Duration
+	failures    map[string]*loginFailures // keyed by ip:<addr> and user:<name>
+}
+
+func newLoginGuard(maxFailures int, window time.Duration, lockout time.Duration) *loginGuard {
+	return &loginGuard{
+		maxFailures: maxFailures,
+		window:      window,
+		lockout:     lockout,
+		failures:    map[string]*loginFailures{},
+	}
+}
+
+// lockedOut returns how long the first locked out key remains locked
+func (g *loginGuard) lockedOut(keys ...string) (time.Duration, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for _, key := range keys {
+		if f, ok := g.failures[key]; ok && now.Before(f.lockedUntil) {
+			return f.lockedUntil.Sub(now), true
+		}
+	}
+	return 0, false
+}
+
+// fail records a failed login and returns the keys it locked out
+func (g *loginGuard) fail(keys ...string) []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var locked []string
+	for _, key := range keys {
+		f, ok := g.failures[key]
+		if !ok || now.Sub(f.first) > g.window {
+			f = &loginFailures{first: now}
+			g.failures[key] = f
+		}
+		f.count++
+		if f.count >= g.maxFailures && !now.Before(f.lockedUntil) {
+			f.lockedUntil = now.Add(g.lockout)
+			f.count = 0
+			f.first = now
+			locked = append(locked, key)
+		}
+	}
+	g.prune(now)
+	return locked
+}
+
+// succeed clears the failures of the keys after a successful login
+func (g *loginGuard) succeed(keys ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range keys {
+		delete(g.failures, key)
+	}
+}
+
+// prune drops the expired entries so that scanning clients cannot grow the
+// map without bound
+func (g *loginGuard) prune(now time.Time) {
+	for key, f := range g.failures {
+		if now.Sub(f.first) > g.window && !now.Before(f.lockedUntil) {
+			delete(g.failures, key)
+		}
+	}
+}
+
+// clientIP returns the address of the client, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
+// auditAuthEvent logs an authentication event for the security audit trail
+func auditAuthEvent(r *http.Request, event string, username string, detail string) {
+	logging.FromContext(r.Context()).Warnf("Audit: %s: user %q from %s: %s", event, username, clientIP(r), detail)
 }
 
 func middlewareUserAuth(next http.Handler) http.Handler {
@@ -40,32 +329,72 @@ func middlewareUserAuth(next http.Handler) http.Handler {
 		}
 		
 		// Skip authentication for health endpoint
-		if r.RequestURI == "/health" {
+		if isHealthCheck(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 		
 		log.Println(r.RequestURI)
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			authenticateAPIKey(w, r, key, next)
+			return
+		}
 		username, password, ok := r.BasicAuth()
 		if !ok {
 			w.Header().Add("WWW-Authenticate", `Basic realm="Give username and password"`)
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message" : "No basic auth present"}`))
+			httpSendProblem(w, newProblem(http.StatusUnauthorized, ProblemUnauthorized, "No basic auth present"))
 			//w.Header().Set("Access-Control-Allow-Origin", "*")  // require for UI to avoid CORS Policy
 			//w.Header().Set("Access-Control-Allow-Headers", "*") // require for UI to avoid CORS Policy
 			log.Println("No basic auth present")
 			return
 		}
+		keys := []string{"ip:" + clientIP(r), "user:" + username}
+		if wait, locked := logins.lockedOut(keys...); locked {
+			// Locked out clients are refused without checking the password
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			httpSendProblem(w, newProblem(http.StatusTooManyRequests, ProblemRateLimited,
+				"too many failed logins, try again later"))
+			auditAuthEvent(r, "login_refused", username, "locked out")
+			return
+		}
 		if !isAuthorized(username, password) {
 			w.Header().Add("WWW-Authenticate", `Basic realm="Give username and password"`)
-			w.WriteHeader(http.StatusUnauthorized)
-			w.Write([]byte(`{"message" : "Invalid username and password"}`))
+			httpSendProblem(w, newProblem(http.StatusUnauthorized, ProblemUnauthorized, "Invalid username and password"))
 			//w.Header().Set("Access-Control-Allow-Origin", "*")  // require for UI to avoid CORS Policy
 			//w.Header().Set("Access-Control-Allow-Headers", "*") // require for UI to avoid CORS Policy
-			log.Println("Invalid username and password")
+			auditAuthEvent(r, "login_failed", username, "invalid username or password")
+			if locked := logins.fail(keys...); len(locked) > 0 {
+				auditAuthEvent(r, "lockout", username, "locked out "+strings.Join(locked, ", ")+" for "+logins.lockout.String())
+			}
 			return
 		}
+		// The failures of the client IP are kept, a valid account must not
+		// reset the lockout of a client guessing the passwords of others
+		logins.succeed("user:" + username)
 		log.Println("Passed Authorization test")
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(withUser(r.Context(), username)))
 	})
 }
+
+// authenticateAPIKey serves a request authenticated with an API key, failed
+// attempts count towards the lockout of the client IP
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, key string, next http.Handler) {
+	keys := []string{"ip:" + clientIP(r)}
+	if wait, locked := logins.lockedOut(keys...); locked {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		httpSendProblem(w, newProblem(http.StatusTooManyRequests, ProblemRateLimited,
+			"too many failed logins, try again later"))
+		auditAuthEvent(r, "login_refused", "", "locked out")
+		return
+	}
+	username, ok := apiKeyUser(key)
+	if !ok {
+		httpSendProblem(w, newProblem(http.StatusUnauthorized, ProblemUnauthorized, "Invalid API key"))
+		auditAuthEvent(r, "login_failed", "", "invalid API key")
+		if locked := logins.fail(keys...); len(locked) > 0 {
+			auditAuthEvent(r, "lockout", "", "locked out "+strings.Join(locked, ", ")+" for "+logins.lockout.String())
+		}
+		return
+	}
+	next.ServeHTTP(w, r.WithContext(withUser(r.Context(), username)))
+}
@@ -0,0 +1,240 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	CWMP_BULK_SET_PARAMS = "/cwmp/bulk/set-params"
+	CWMP_BULK_REBOOT     = "/cwmp/bulk/reboot"
+	CWMP_BULK_PREVIEW    = "/cwmp/bulk/previews/{previewId}"
+)
+
+// Bulk operations
+const (
+	bulkOpSetParams = "set-params"
+	bulkOpReboot    = "reboot"
+)
+
+// bulkPreviewTTL is how long a dry run preview can be confirmed
+const bulkPreviewTTL = 15 * time.Minute
+
+// CwmpDeviceSelector selects the target devices of a bulk operation. The
+// devices must match every criterion which is set, Group takes the members
+// of a device group and Search the conditions of a device search.
+type CwmpDeviceSelector struct {
+	DeviceIds       []string          `json:"device_ids,omitempty"`
+	Tags            []string          `json:"tags,omitempty"`
+	Manufacturer    string            `json:"manufacturer,omitempty"`
+	ModelName       string            `json:"model_name,omitempty"`
+	ProductClass    string            `json:"product_class,omitempty"`
+	SoftwareVersion string            `json:"software_version,omitempty"`
+	Group           string            `json:"group,omitempty"`
+	Search          *CwmpDeviceSearch `json:"search,omitempty"`
+}
+
+// CwmpBulkRequest is the request of a bulk operation. A dry run resolves the
+// selector into a preview, the operation is executed by sending the preview
+// ID. Concurrency lowers the number of devices the job submits RPCs for at
+// the same time.
+type CwmpBulkRequest struct {
+	Selector     CwmpDeviceSelector          `json:"selector"`
+	Parameters   []cwmp.ParameterValueStruct `json:"parameters,omitempty"`
+	ParameterKey string                      `json:"parameter_key,omitempty"`
+	CommandKey   string                      `json:"command_key,omitempty"`
+	PreviewId    string                      `json:"preview_id,omitempty"`
+	Concurrency  int                         `json:"concurrency,omitempty"`
+}
+
+func (as *ApiServer) bulkSetCwmpParams(w http.ResponseWriter, r *http.Request) {
+	as.handleBulkOperation(w, r, bulkOpSetParams)
+}
+
+func (as *ApiServer) bulkRebootCwmpDevices(w http.ResponseWriter, r *http.Request) {
+	as.handleBulkOperation(w, r, bulkOpReboot)
+}
+
+// handleBulkOperation previews the operation with dry_run=true, or starts a
+// bulk job executing a previously returned preview. Requests without a
+// preview are rejected so that a selector never fans out to the fleet unseen.
+func (as *ApiServer) handleBulkOperation(w http.ResponseWriter, r *http.Request, operation string) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req CwmpBulkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+
+	if r.URL.Query().Get("dry_run") == "true" {
+		preview, err := as.previewBulkOperation(r, operation, &req)
+		httpSendRes(w, preview, err)
+		return
+	}
+	if req.PreviewId == "" {
+		httpSendRes(w, nil, errBadRequest("bulk %s must be previewed with dry_run=true and confirmed with its preview_id", operation))
+		return
+	}
+	if req.Concurrency < 0 {
+		httpSendRes(w, nil, errBadRequest("invalid concurrency: %d", req.Concurrency))
+		return
+	}
+
+	preview, err := as.dbH.cwmpIntf.ConfirmBulkPreview(req.PreviewId, operation)
+	if err != nil {
+		if errors.Is(err, db.ErrBulkPreviewUnusable) {
+			err = errConflict("%w", err)
+		}
+		httpSendRes(w, nil, err)
+		return
+	}
+	username := requestUser(r)
+	job, err := as.startBulkJob(preview, req.Concurrency, username)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, job, nil)
+}
+
+// previewBulkOperation resolves the target devices and their planned RPCs
+// and stores them as a preview
+func (as *ApiServer) previewBulkOperation(r *http.Request, operation string, req *CwmpBulkRequest) (*db.BulkPreview, error) {
+	var rpcs []db.PlannedRPC
+	switch operation {
+	case bulkOpSetParams:
+		if len(req.Parameters) == 0 {
+			return nil, errBadRequest("parameters are required")
+		}
+		args := map[string]string{}
+		for _, p := range req.Parameters {
+			args[p.Name] = p.Value
+		}
+		if req.ParameterKey != "" {
+			args["ParameterKey"] = req.ParameterKey
+		}
+		rpcs = []db.PlannedRPC{{Method: "SetParameterValues", Arguments: args}}
+	case bulkOpReboot:
+		rpcs = []db.PlannedRPC{{Method: "Reboot", Arguments: map[string]string{"CommandKey": req.CommandKey}}}
+	}
+
+	filter, err := as.deviceSelectorFilter(&req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := as.dbH.cwmpIntf.GetCwmpDevicesByFilter(withoutArchived(filter))
+	if err != nil {
+		return nil, err
+	}
+
+	username := requestUser(r)
+	now := time.Now()
+	preview := &db.BulkPreview{
+		Operation: operation,
+		Devices:   []db.BulkPlannedDevice{},
+		CreatedBy: username,
+		CreatedAt: now,
+		ExpiresAt: now.Add(bulkPreviewTTL),
+	}
+	for _, d := range devices {
+		preview.Devices = append(preview.Devices, db.BulkPlannedDevice{DeviceID: d.ID, RPCs: rpcs})
+	}
+	if err := as.dbH.cwmpIntf.InsertBulkPreview(preview); err != nil {
+		return nil, err
+	}
+	log.Printf("Bulk %s preview %s by %q targets %d device(s)", operation, preview.ID, username, preview.DeviceCount)
+	return preview, nil
+}
+
+// getBulkPreview returns a stored bulk preview
+func (as *ApiServer) getBulkPreview(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	preview, err := as.dbH.cwmpIntf.GetBulkPreview(mux.Vars(r)["previewId"])
+	httpSendRes(w, preview, err)
+}
+
+// deviceSelectorFilter converts a device selector into a device filter. An
+// empty selector is rejected rather than matching every device.
+func (as *ApiServer) deviceSelectorFilter(s *CwmpDeviceSelector) (bson.M, error) {
+	filter := bson.M{}
+	if len(s.DeviceIds) > 0 {
+		filter["_id"] = bson.M{"$in": s.DeviceIds}
+	}
+	if len(s.Tags) > 0 {
+		filter["tags"] = bson.M{"$all": s.Tags}
+	}
+	if s.Manufacturer != "" {
+		filter["manufacturer"] = s.Manufacturer
+	}
+	if s.ModelName != "" {
+		filter["model_name"] = s.ModelName
+	}
+	if s.ProductClass != "" {
+		filter["product_class"] = s.ProductClass
+	}
+	if s.SoftwareVersion != "" {
+		filter["software_version"] = s.SoftwareVersion
+	}
+	conds := bson.A{}
+	if len(filter) > 0 {
+		conds = append(conds, filter)
+	}
+	if s.Group != "" {
+		group, err := as.deviceGroupFilter(s.Group)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errBadRequest("unknown group: %s", s.Group)
+		}
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, group)
+	}
+	if s.Search != nil {
+		search, err := as.compileDeviceSearch(s.Search)
+		if err != nil {
+			return nil, err
+		}
+		if len(search) > 0 {
+			conds = append(conds, search)
+		}
+	}
+	switch len(conds) {
+	case 0:
+		return nil, errBadRequest("selector must name device_ids, tags, device attributes, a group or a search")
+	case 1:
+		filter = conds[0].(bson.M)
+	default:
+		filter = andFilter(conds)
+	}
+	return filter, nil
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_BULK_JOBS        = "/cwmp/bulk/jobs"
+	CWMP_BULK_JOB         = "/cwmp/bulk/jobs/{jobId}"
+	CWMP_BULK_JOB_RESULTS = "/cwmp/bulk/jobs/{jobId}/results"
+)
+
+// bulkJobConcurrency is the default number of devices a bulk job submits
+// RPCs for at the same time, overridden by the bulk section of the config
+const bulkJobConcurrency = 10
+
+// maxBulkJobConcurrency returns the configured concurrency limit of the bulk
+// jobs
+func (as *ApiServer) maxBulkJobConcurrency() int {
+	if as.config != nil && as.config.Bulk.Concurrency > 0 {
+		return as.config.Bulk.Concurrency
+	}
+	return bulkJobConcurrency
+}
+
+// startBulkJob stores a job for a confirmed preview and runs it in the
+// background. A concurrency of zero or above the configured limit runs the
+// job at the limit. The job holds a job slot of the tenants of its devices
+// until it completes.
+func (as *ApiServer) startBulkJob(preview *db.BulkPreview, concurrency int, username string) (*db.BulkJob, error) {
+	if limit := as.maxBulkJobConcurrency(); concurrency <= 0 || concurrency > limit {
+		concurrency = limit
+	}
+	release, err := as.acquireDevicesJob(bulkPreviewFilter(preview))
+	if err != nil {
+		return nil, err
+	}
+	job := &db.BulkJob{Concurrency: concurrency, CreatedBy: username}
+	if err := as.dbH.cwmpIntf.InsertBulkJob(job, preview); err != nil {
+		release()
+		return nil, err
+	}
+	log.Printf("Bulk %s job %s started by %q on %d device(s)", job.Operation, job.ID, username, job.DeviceCount)
+	as.goBackground(func() {
+		defer release()
+		as.runBulkJob(job)
+	})
+	return job, nil
+}
+
+// bulkPreviewFilter matches the devices of a preview
+func bulkPreviewFilter(preview *db.BulkPreview) bson.M {
+	ids := make(bson.A, 0, len(preview.Devices))
+	for _, d := range preview.Devices {
+		ids = append(ids, d.DeviceID)
+	}
+	return bson.M{"_id": bson.M{"$in": ids}}
+}
+
+// resumeBulkJobs runs the pending devices of the jobs which were running
+// when the API server stopped
+func (as *ApiServer) resumeBulkJobs() {
+	if as.dbH.cwmpIntf == nil {
+		return
+	}
+	jobs, err := as.dbH.cwmpIntf.GetBulkJobs(bson.M{"status": db.BulkJobRunning}, 0)
+	if err != nil {
+		log.Printf("Error loading running bulk jobs: %v", err)
+		return
+	}
+	for i := range jobs {
+		log.Printf("Resuming bulk %s job %s", jobs[i].Operation, jobs[i].ID)
+		job := &jobs[i]
+		release := as.resumeBulkJobSlot(job)
+		as.goBackground(func() {
+			defer release()
+			as.runBulkJob(job)
+		})
+	}
+}
+
+// resumeBulkJobSlot takes the job slot of a resumed job on the tenants of
+// its pending devices. The job was admitted before the restart, it runs
+// without a slot if a tenant is at its limit now.
+func (as *ApiServer) resumeBulkJobSlot(job *db.BulkJob) func() {
+	pending, err := as.dbH.cwmpIntf.GetBulkJobResults(bson.M{"job_id": job.ID, "status": db.BulkResultPending}, 0)
+	if err != nil {
+		return func() {}
+	}
+	ids := make(bson.A, 0, len(pending))
+	for _, result := range pending {
+		ids = append(ids, result.DeviceID)
+	}
+	release, err := as.acquireDevicesJob(bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Printf("Bulk job %s resumed without a job slot: %v", job.ID, err)
+		return func() {}
+	}
+	return release
+}
+
+// runBulkJob submits the RPCs of the pending devices of a job, at most
+// job.Concurrency devices at a time, and completes the job once every
+// device is settled
+func (as *ApiServer) runBulkJob(job *db.BulkJob) {
+	cwmpDb := as.dbH.cwmpIntf
+	filter := bson.M{"job_id": job.ID, "status": db.BulkResultPending}
+	pending, err := cwmpDb.GetBulkJobResults(filter, 0)
+	if err != nil {
+		log.Printf("Error loading the devices of bulk job %s: %v", job.ID, err)
+		return
+	}
+
+	concurrency := job.Concurrency
+	if concurrency <= 0 {
+		concurrency = as.maxBulkJobConcurrency()
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range pending {
+		// The devices not submitted yet are resumed at the next start
+		if as.isStopping() {
+			break
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(result *db.BulkJobResult) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			as.submitBulkJobResult(job, result)
+			if err := cwmpDb.SettleBulkJobResult(result); err != nil {
+				log.Printf("Error storing the result of device %s in bulk job %s: %v", result.DeviceID, job.ID, err)
+			}
+		}(&pending[i])
+	}
+	wg.Wait()
+	if as.isStopping() {
+		log.Printf("Bulk job %s interrupted by the shutdown", job.ID)
+		return
+	}
+
+	done, err := cwmpDb.CompleteBulkJob(job.ID)
+	if err != nil {
+		log.Printf("Error completing bulk job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Bulk %s job %s completed, %d submitted, %d failed", done.Operation, done.ID, done.Submitted, done.Failed)
+}
+
+// submitBulkJobResult sends the planned RPCs of a device to the controller
+// and records a task for each of them. The device fails on the first RPC
+// the controller does not take.
+func (as *ApiServer) submitBulkJobResult(job *db.BulkJob, result *db.BulkJobResult) {
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	result.RequestID = logging.RequestID(ctx)
+	result.Status = db.BulkResultSubmitted
+	for _, rpc := range result.RPCs {
+		if err := as.sendPlannedRPC(ctx, job, result.DeviceID, rpc); err != nil {
+			result.Status = db.BulkResultFailed
+			result.Error = err.Error()
+			return
+		}
+		if taskID := as.createCwmpTask(result.DeviceID, rpc.Method, result.RequestID); taskID != "" {
+			result.TaskIDs = append(result.TaskIDs, taskID)
+		}
+	}
+}
+
+// sendPlannedRPC sends an RPC planned by a bulk preview to the controller
+func (as *ApiServer) sendPlannedRPC(ctx context.Context, job *db.BulkJob, deviceId string, rpc db.PlannedRPC) error {
+	switch rpc.Method {
+	case acsbus.MethodSetParameterValues:
+		var params []cwmp.ParameterValueStruct
+		for name, value := range rpc.Arguments {
+			if name != "ParameterKey" {
+				params = append(params, cwmp.ParameterValueStruct{Name: name, Value: value})
+			}
+		}
+		sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+		return as.CntlrCwmpSetParamsReq(ctx, deviceId, params, rpc.Arguments["ParameterKey"])
+	case acsbus.MethodReboot:
+		cmdKey := rpc.Arguments["CommandKey"]
+		if cmdKey == "" {
+			cmdKey = "bulk:" + job.ID
+		}
+		return as.CntlrCwmpRebootReq(ctx, deviceId, cmdKey)
+	}
+	return errBadRequest("unsupported bulk RPC %s", rpc.Method)
+}
+
+// getBulkJobs lists the bulk jobs, newest first, filtered by operation,
+// status or created_by
+func (as *ApiServer) getBulkJobs(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	for _, key := range []string{"operation", "status", "created_by"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	jobs, err := as.dbH.cwmpIntf.GetBulkJobs(filter, limit)
+	httpSendRes(w, jobs, err)
+}
+
+// getBulkJob returns a bulk job with the counts of its settled devices
+func (as *ApiServer) getBulkJob(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	job, err := as.dbH.cwmpIntf.GetBulkJob(mux.Vars(r)["jobId"])
+	httpSendRes(w, job, err)
+}
+
+// getBulkJobResults lists the per-device results of a bulk job, filtered by
+// status or device_id
+func (as *ApiServer) getBulkJobResults(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	jobId := mux.Vars(r)["jobId"]
+	if _, err := as.dbH.cwmpIntf.GetBulkJob(jobId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	filter := bson.M{"job_id": jobId}
+	for _, key := range []string{"status", "device_id"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 1000)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	results, err := as.dbH.cwmpIntf.GetBulkJobResults(filter, limit)
+	httpSendRes(w, results, err)
+}
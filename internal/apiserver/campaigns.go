@@ -0,0 +1,522 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	FIRMWARE_CAMPAIGNS        = "/firmware/campaigns/"
+	FIRMWARE_CAMPAIGN         = "/firmware/campaigns/{id}"
+	FIRMWARE_CAMPAIGN_DEVICES = "/firmware/campaigns/{id}/devices"
+	FIRMWARE_CAMPAIGN_PAUSE   = "/firmware/campaigns/{id}/pause"
+	FIRMWARE_CAMPAIGN_RESUME  = "/firmware/campaigns/{id}/resume"
+)
+
+const (
+	// campaignInterval is how often the campaigns start devices and collect
+	// the outcome of their upgrades
+	campaignInterval = 30 * time.Second
+	// campaignConcurrency is the default number of devices a campaign
+	// upgrades at a time
+	campaignConcurrency = 10
+	// campaignDeviceTimeout is how long a device may take to install and
+	// verify the image before it counts as failed
+	campaignDeviceTimeout = 2 * time.Hour
+)
+
+// FirmwareCampaignRequest creates or updates a firmware campaign
+type FirmwareCampaignRequest struct {
+	Name           string     `json:"name"`
+	Group          string     `json:"group"`
+	FirmwareID     string     `json:"firmware_id"`
+	WindowStart    *time.Time `json:"window_start,omitempty"`
+	WindowEnd      *time.Time `json:"window_end,omitempty"`
+	Concurrency    int        `json:"concurrency,omitempty"`
+	AbortThreshold int        `json:"abort_threshold,omitempty"`
+}
+
+func (as *ApiServer) setFirmwareCampaignRoutesHandlers() {
+	as.router.HandleFunc(FIRMWARE_CAMPAIGNS, as.getFirmwareCampaigns).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGNS, as.addFirmwareCampaign).Methods("POST")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN, as.getFirmwareCampaign).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN, as.updateFirmwareCampaign).Methods("PUT")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN, as.deleteFirmwareCampaign).Methods("DELETE")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN_DEVICES, as.getFirmwareCampaignDevices).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN_PAUSE, as.pauseFirmwareCampaign).Methods("POST")
+	as.router.HandleFunc(FIRMWARE_CAMPAIGN_RESUME, as.resumeFirmwareCampaign).Methods("POST")
+}
+
+// getFirmwareCampaigns lists the campaigns, newest first, filtered by status,
+// group or firmware_id
+func (as *ApiServer) getFirmwareCampaigns(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	for _, key := range []string{"status", "group", "firmware_id"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	campaigns, err := as.dbH.cwmpIntf.GetFirmwareCampaigns(filter, limit)
+	httpSendRes(w, campaigns, err)
+}
+
+// getFirmwareCampaign returns a campaign with the counts of its devices
+func (as *ApiServer) getFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	campaign, err := as.dbH.cwmpIntf.GetFirmwareCampaign(mux.Vars(r)["id"])
+	httpSendRes(w, campaign, err)
+}
+
+// addFirmwareCampaign creates a pending campaign, it starts when its window
+// opens
+func (as *ApiServer) addFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req FirmwareCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	campaign := &db.FirmwareCampaign{}
+	if err := as.applyFirmwareCampaignRequest(campaign, &req); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	campaign.CreatedBy = requestUser(r)
+	if err := as.dbH.cwmpIntf.InsertFirmwareCampaign(campaign); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Firmware campaign %s %q created for group %s", campaign.ID, campaign.Name, campaign.Group)
+	httpSendRes(w, campaign, nil)
+}
+
+// updateFirmwareCampaign changes a campaign which is pending or paused. The
+// group and the image can only be changed before the campaign started.
+func (as *ApiServer) updateFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	campaign, err := as.dbH.cwmpIntf.GetFirmwareCampaign(mux.Vars(r)["id"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	var req FirmwareCampaignRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if campaign.StartedAt != nil && (req.Group != campaign.Group || req.FirmwareID != campaign.FirmwareID) {
+		httpSendRes(w, nil, errConflict("the group and firmware of campaign %s cannot change once it started", campaign.ID))
+		return
+	}
+	if err := as.applyFirmwareCampaignRequest(campaign, &req); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	set := bson.M{
+		"name":            campaign.Name,
+		"group":           campaign.Group,
+		"firmware_id":     campaign.FirmwareID,
+		"target_version":  campaign.TargetVersion,
+		"window_start":    campaign.WindowStart,
+		"window_end":      campaign.WindowEnd,
+		"concurrency":     campaign.Concurrency,
+		"abort_threshold": campaign.AbortThreshold,
+	}
+	updated, err := as.dbH.cwmpIntf.UpdateFirmwareCampaign(campaign.ID, []string{db.CampaignPending, db.CampaignPaused}, set)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errConflict("campaign %s is %s, only pending and paused campaigns can be changed", campaign.ID, campaign.Status)
+	}
+	httpSendRes(w, updated, err)
+}
+
+// applyFirmwareCampaignRequest validates a request and sets it on campaign
+func (as *ApiServer) applyFirmwareCampaignRequest(campaign *db.FirmwareCampaign, req *FirmwareCampaignRequest) error {
+	if req.Name == "" || req.Group == "" || req.FirmwareID == "" {
+		return errBadRequest("name, group and firmware_id are required")
+	}
+	if req.WindowStart != nil && req.WindowEnd != nil && !req.WindowEnd.After(*req.WindowStart) {
+		return errBadRequest("window_end must be after window_start")
+	}
+	if req.Concurrency < 0 {
+		return errBadRequest("invalid concurrency: %d", req.Concurrency)
+	}
+	if req.AbortThreshold < 0 || req.AbortThreshold > 100 {
+		return errBadRequest("abort_threshold is a percentage: %d", req.AbortThreshold)
+	}
+
+	if _, err := as.dbH.cwmpIntf.GetDeviceGroup(req.Group); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errBadRequest("unknown group %s", req.Group)
+		}
+		return err
+	}
+	image, err := as.dbH.cwmpIntf.GetFirmwareImage(req.FirmwareID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return errBadRequest("unknown firmware_id %s", req.FirmwareID)
+		}
+		return err
+	}
+
+	campaign.Name = req.Name
+	campaign.Group = req.Group
+	campaign.FirmwareID = image.ID
+	campaign.TargetVersion = image.Version
+	campaign.WindowStart = req.WindowStart
+	campaign.WindowEnd = req.WindowEnd
+	campaign.Concurrency = req.Concurrency
+	if campaign.Concurrency == 0 {
+		campaign.Concurrency = campaignConcurrency
+	}
+	campaign.AbortThreshold = req.AbortThreshold
+	return nil
+}
+
+// deleteFirmwareCampaign removes a campaign which is not active
+func (as *ApiServer) deleteFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	states := []string{db.CampaignPending, db.CampaignPaused, db.CampaignDone, db.CampaignAborted}
+	if err := as.dbH.cwmpIntf.DeleteFirmwareCampaign(id, states); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			if _, gErr := as.dbH.cwmpIntf.GetFirmwareCampaign(id); gErr == nil {
+				err = errConflict("campaign %s is active, pause it first", id)
+			}
+		}
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"id": id, "status": "deleted"}, nil)
+}
+
+// getFirmwareCampaignDevices lists the devices of a campaign with the
+// outcome of their upgrade, filtered by status or device_id
+func (as *ApiServer) getFirmwareCampaignDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{"campaign_id": mux.Vars(r)["id"]}
+	for _, key := range []string{"status", "device_id"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	devices, err := as.dbH.cwmpIntf.GetCampaignDevices(filter, limit)
+	httpSendRes(w, devices, err)
+}
+
+// pauseFirmwareCampaign stops a campaign from starting more devices, the
+// upgrades in progress go on
+func (as *ApiServer) pauseFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	as.setFirmwareCampaignStatus(w, r, []string{db.CampaignPending, db.CampaignActive}, db.CampaignPaused)
+}
+
+// resumeFirmwareCampaign resumes a paused campaign
+func (as *ApiServer) resumeFirmwareCampaign(w http.ResponseWriter, r *http.Request) {
+	as.setFirmwareCampaignStatus(w, r, []string{db.CampaignPaused}, "")
+}
+
+// setFirmwareCampaignStatus moves a campaign from one of the states from to
+// status. An empty status resumes the campaign as active if it started,
+// pending otherwise.
+func (as *ApiServer) setFirmwareCampaignStatus(w http.ResponseWriter, r *http.Request, from []string, status string) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	campaign, err := as.dbH.cwmpIntf.GetFirmwareCampaign(mux.Vars(r)["id"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if status == "" {
+		status = db.CampaignPending
+		if campaign.StartedAt != nil {
+			status = db.CampaignActive
+		}
+	}
+	updated, err := as.dbH.cwmpIntf.UpdateFirmwareCampaign(campaign.ID, from, bson.M{"status": status})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = errConflict("campaign %s is %s", campaign.ID, campaign.Status)
+	}
+	if err == nil {
+		logging.FromContext(r.Context()).Infof("Firmware campaign %s is %s", campaign.ID, status)
+	}
+	httpSendRes(w, updated, err)
+}
+
+// startFirmwareCampaigns periodically runs the pending and active campaigns
+func (as *ApiServer) startFirmwareCampaigns() {
+	as.every(campaignInterval, func() {
+		if as.dbH.cwmpIntf == nil {
+			return
+		}
+		filter := bson.M{"status": bson.M{"$in": bson.A{db.CampaignPending, db.CampaignActive}}}
+		campaigns, err := as.dbH.cwmpIntf.GetFirmwareCampaigns(filter, 0)
+		if err != nil {
+			log.Printf("Error loading firmware campaigns: %v", err)
+			return
+		}
+		running := make(map[string]bool, len(campaigns))
+		for i := range campaigns {
+			running[campaigns[i].ID] = true
+			if err := as.runFirmwareCampaign(&campaigns[i]); err != nil {
+				log.Printf("Error running firmware campaign %s: %v", campaigns[i].ID, err)
+			}
+		}
+		// Paused, finished and deleted campaigns free their job slots
+		as.releaseCampaignJobs(running)
+	})
+}
+
+// runFirmwareCampaign activates a pending campaign whose window opened,
+// settles the devices of an active one and starts new devices up to its
+// concurrency. A campaign holds a job slot of the tenants of its devices
+// from its activation on and waits while one of them is at its limit.
+func (as *ApiServer) runFirmwareCampaign(campaign *db.FirmwareCampaign) error {
+	cwmpDb := as.dbH.cwmpIntf
+	now := time.Now()
+	windowClosed := campaign.WindowEnd != nil && now.After(*campaign.WindowEnd)
+
+	if campaign.Status == db.CampaignPending {
+		if campaign.WindowStart != nil && now.Before(*campaign.WindowStart) {
+			return nil
+		}
+		if windowClosed {
+			return as.finishFirmwareCampaign(campaign, db.CampaignDone, "window closed before the campaign started")
+		}
+		if !as.holdCampaignJob(campaign) {
+			return nil
+		}
+		return as.activateFirmwareCampaign(campaign)
+	}
+	if !as.holdCampaignJob(campaign) {
+		return nil
+	}
+
+	inProgress, err := cwmpDb.GetCampaignDevices(bson.M{"campaign_id": campaign.ID, "status": db.CampaignDeviceInProgress}, 0)
+	if err != nil {
+		return err
+	}
+	for i := range inProgress {
+		as.settleCampaignDevice(&inProgress[i], now)
+	}
+
+	counts, err := cwmpDb.CountCampaignDevices(campaign.ID)
+	if err != nil {
+		return err
+	}
+	campaign.Counts = counts
+	switch {
+	case campaign.AbortThreshold > 0 && counts.Failed*100 > campaign.AbortThreshold*counts.Total:
+		return as.finishFirmwareCampaign(campaign, db.CampaignAborted,
+			fmt.Sprintf("%d of %d devices failed, above the abort threshold of %d%%", counts.Failed, counts.Total, campaign.AbortThreshold))
+	case counts.Pending == 0 && counts.InProgress == 0:
+		return as.finishFirmwareCampaign(campaign, db.CampaignDone, "")
+	case windowClosed && counts.InProgress == 0:
+		return as.finishFirmwareCampaign(campaign, db.CampaignDone, fmt.Sprintf("window closed with %d devices pending", counts.Pending))
+	}
+
+	if !windowClosed && counts.InProgress < campaign.Concurrency {
+		filter := bson.M{"campaign_id": campaign.ID, "status": db.CampaignDevicePending}
+		pending, err := cwmpDb.GetCampaignDevices(filter, int64(campaign.Concurrency-counts.InProgress))
+		if err != nil {
+			return err
+		}
+		for i := range pending {
+			as.startCampaignDevice(campaign, &pending[i])
+		}
+		if campaign.Counts, err = cwmpDb.CountCampaignDevices(campaign.ID); err != nil {
+			return err
+		}
+	}
+	_, err = cwmpDb.UpdateFirmwareCampaign(campaign.ID, []string{db.CampaignActive}, bson.M{"counts": campaign.Counts})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Paused meanwhile, the counts are refreshed when it resumes
+		return nil
+	}
+	return err
+}
+
+// activateFirmwareCampaign adds the current members of the group of the
+// campaign as its devices and activates it
+func (as *ApiServer) activateFirmwareCampaign(campaign *db.FirmwareCampaign) error {
+	cwmpDb := as.dbH.cwmpIntf
+	filter, err := as.deviceGroupFilter(campaign.Group)
+	if err != nil {
+		return err
+	}
+	devices, err := cwmpDb.GetCwmpDevicesByFilter(filter)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+	if err := cwmpDb.InsertCampaignDevices(campaign.ID, ids); err != nil {
+		return err
+	}
+	counts, err := cwmpDb.CountCampaignDevices(campaign.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	set := bson.M{"status": db.CampaignActive, "started_at": now, "counts": counts}
+	if _, err := cwmpDb.UpdateFirmwareCampaign(campaign.ID, []string{db.CampaignPending}, set); err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	log.Printf("Firmware campaign %s started on %d device(s) of group %s", campaign.ID, len(ids), campaign.Group)
+	return nil
+}
+
+// finishFirmwareCampaign closes an active or pending campaign
+func (as *ApiServer) finishFirmwareCampaign(campaign *db.FirmwareCampaign, status string, reason string) error {
+	set := bson.M{"status": status, "status_reason": reason, "counts": campaign.Counts, "completed_at": time.Now()}
+	_, err := as.dbH.cwmpIntf.UpdateFirmwareCampaign(campaign.ID, []string{db.CampaignPending, db.CampaignActive}, set)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err == nil {
+		log.Printf("Firmware campaign %s is %s: %d succeeded, %d failed %s", campaign.ID, status,
+			campaign.Counts.Succeeded, campaign.Counts.Failed, reason)
+	}
+	return err
+}
+
+// startCampaignDevice sends the Download of the campaign image to a device.
+// The device fails right away if the image does not fit it.
+func (as *ApiServer) startCampaignDevice(campaign *db.FirmwareCampaign, device *db.CampaignDevice) {
+	now := time.Now()
+	device.StartedAt = &now
+	device.Status = db.CampaignDeviceInProgress
+
+	err := as.sendCampaignDownload(campaign, device)
+	if err != nil {
+		device.Status = db.CampaignDeviceFailed
+		device.Error = err.Error()
+		device.CompletedAt = &now
+	}
+	if sErr := as.dbH.cwmpIntf.SaveCampaignDevice(device); sErr != nil {
+		log.Printf("Error storing device %s of firmware campaign %s: %v", device.DeviceID, campaign.ID, sErr)
+	}
+}
+
+func (as *ApiServer) sendCampaignDownload(campaign *db.FirmwareCampaign, device *db.CampaignDevice) error {
+	cwmpDevice, err := as.loadCwmpDevice(device.DeviceID)
+	if err != nil {
+		return err
+	}
+	req := &CwmpDownloadRequest{FirmwareID: campaign.FirmwareID, CommandKey: "campaign:" + campaign.ID}
+	if err := as.resolveFirmwareImage(req, cwmpDevice); err != nil {
+		return err
+	}
+	if err := as.checkFirmwareCompat(device.DeviceID, req.FirmwareVersion, false); err != nil {
+		return err
+	}
+
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
+	transfer, cmd := newDownloadTransfer(device.DeviceID, req)
+	if _, err := as.submitCwmpTransfer(ctx, transfer, cmd); err != nil {
+		return err
+	}
+	device.TransferID = transfer.ID
+	return nil
+}
+
+// settleCampaignDevice completes a device of a campaign once the firmware job
+// of its transfer is verified or failed, or it took too long
+func (as *ApiServer) settleCampaignDevice(device *db.CampaignDevice, now time.Time) {
+	jobs, err := as.dbH.cwmpIntf.GetFirmwareJobs(bson.M{"transfer_id": device.TransferID}, 1)
+	if err != nil {
+		log.Printf("Error loading the firmware job of device %s: %v", device.DeviceID, err)
+		return
+	}
+
+	if len(jobs) > 0 {
+		job := &jobs[0]
+		device.FirmwareJobID = job.ID
+		switch job.Status {
+		case db.FirmwareJobVerified:
+			device.Status = db.CampaignDeviceSucceeded
+		case db.FirmwareJobFailed, db.FirmwareJobMismatch:
+			device.Status = db.CampaignDeviceFailed
+			device.Error = job.Status
+			if job.FaultString != "" {
+				device.Error += ": " + job.FaultString
+			}
+		}
+	}
+	if device.Status == db.CampaignDeviceInProgress && device.StartedAt != nil && now.Sub(*device.StartedAt) > campaignDeviceTimeout {
+		device.Status = db.CampaignDeviceFailed
+		device.Error = "upgrade not verified within " + campaignDeviceTimeout.String()
+	}
+	if device.Status == db.CampaignDeviceInProgress {
+		return
+	}
+
+	device.CompletedAt = &now
+	if err := as.dbH.cwmpIntf.SaveCampaignDevice(device); err != nil {
+		log.Printf("Error storing device %s of firmware campaign %s: %v", device.DeviceID, device.CampaignID, err)
+	}
+}
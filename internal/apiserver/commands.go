@@ -0,0 +1,60 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_GET_COMMANDS = "/cwmp/commands/"
+	CWMP_GET_COMMAND  = "/cwmp/commands/{id}"
+)
+
+// getCwmpCommands lists the commands queued by the ACS for devices, newest
+// first
+func (as *ApiServer) getCwmpCommands(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	query := r.URL.Query()
+	for _, key := range []string{"device_id", "status", "method", "request_id"} {
+		if value := query.Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	cmds, err := as.dbH.cwmpIntf.GetCwmpCommands(filter, limit)
+	httpSendRes(w, cmds, err)
+}
+
+func (as *ApiServer) getCwmpCommand(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	cmd, err := as.dbH.cwmpIntf.GetCwmpCommand(mux.Vars(r)["id"])
+	httpSendRes(w, cmd, err)
+}
@@ -0,0 +1,280 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"compress/gzip"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// defaultCompressMinSize is the size from which responses are compressed
+// when the config does not set it
+const defaultCompressMinSize = 1024
+
+// responseCompressor compresses the responses with the encoding preferred by
+// the client among gzip and, when enabled, zstd
+type responseCompressor struct {
+	minSize   int
+	encodings []string // by server preference
+	gzipPool  sync.Pool
+	zstdPool  sync.Pool
+}
+
+func newResponseCompressor(cfg config.CompressionConfig) *responseCompressor {
+	c := &responseCompressor{minSize: cfg.MinSize, encodings: []string{"gzip"}}
+	if c.minSize <= 0 {
+		c.minSize = defaultCompressMinSize
+	}
+	level := cfg.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		log.Printf("Invalid gzip level %d, using the default level", cfg.Level)
+		level = gzip.DefaultCompression
+	}
+	c.gzipPool.New = func() interface{} {
+		w, _ := gzip.NewWriterLevel(io.Discard, level)
+		return w
+	}
+	if cfg.Zstd {
+		c.encodings = []string{"zstd", "gzip"}
+		c.zstdPool.New = func() interface{} {
+			w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return w
+		}
+	}
+	return c
+}
+
+// middlewareCompress compresses the responses negotiated by Accept-Encoding.
+// WebSocket upgrades, ranges and HEAD requests are passed through.
+func (c *responseCompressor) middlewareCompress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), c.encodings)
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, compressor: c, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality
+// in an Accept-Encoding header, ties going to the server preference
+func negotiateEncoding(header string, supported []string) string {
+	if header == "" {
+		return ""
+	}
+	quality := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		quality[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := quality[encoding]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressible tells whether a response of the content type is worth
+// compressing. Event streams would be delayed, media and archives are
+// compressed already.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "json"), strings.HasSuffix(mediaType, "xml"):
+		return true
+	case mediaType == "application/javascript", mediaType == "application/yaml",
+		mediaType == "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// compressWriter holds back the start of a response until it reaches the
+// minimum size, then compresses it or sends it as is. A flush sends the
+// response held back uncompressed, streams are not delayed.
+type compressWriter struct {
+	http.ResponseWriter
+	compressor *responseCompressor
+	encoding   string
+	status     int
+	buf        []byte
+	started    bool
+	enc        io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		if !cw.started {
+			cw.ResponseWriter.WriteHeader(status)
+		}
+		return
+	}
+	if cw.started || cw.status != 0 {
+		return
+	}
+	cw.status = status
+	h := cw.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Encoding") != "" {
+		cw.start(false)
+		return
+	}
+	if length, err := strconv.Atoi(h.Get("Content-Length")); err == nil {
+		cw.start(length >= cw.compressor.minSize)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started && cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.started {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.compressor.minSize {
+		if err := cw.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the header and the response held back, compressed when
+// compress is set and the content type is compressible
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if compressible(h.Get("Content-Type")) && h.Get("Content-Encoding") == "" {
+		h.Add("Vary", "Accept-Encoding")
+		if compress {
+			h.Del("Content-Length")
+			h.Set("Content-Encoding", cw.encoding)
+			cw.enc = cw.compressor.newEncoder(cw.encoding, cw.ResponseWriter)
+		}
+	}
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start(false)
+	}
+	if f, ok := cw.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a response smaller than the minimum size as is and ends the
+// compressed stream
+func (cw *compressWriter) close() {
+	if !cw.started {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+		cw.start(false)
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Close(); err != nil {
+			log.Printf("Error completing a %s response: %v", cw.encoding, err)
+		}
+		cw.compressor.releaseEncoder(cw.encoding, cw.enc)
+		cw.enc = nil
+	}
+}
+
+func (c *responseCompressor) newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == "zstd" {
+		enc := c.zstdPool.Get().(*zstd.Encoder)
+		enc.Reset(w)
+		return enc
+	}
+	enc := c.gzipPool.Get().(*gzip.Writer)
+	enc.Reset(w)
+	return enc
+}
+
+func (c *responseCompressor) releaseEncoder(encoding string, enc io.WriteCloser) {
+	if encoding == "zstd" {
+		c.zstdPool.Put(enc)
+		return
+	}
+	c.gzipPool.Put(enc)
+}
@@ -0,0 +1,77 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/handlers"
+	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+var (
+	// corsDefaultMethods and corsDefaultHeaders are allowed when the config
+	// does not list any
+	corsDefaultMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsDefaultHeaders = []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", APIKeyHeader, logging.RequestIDHeader}
+)
+
+// corsHandler answers the CORS preflight requests and sets the CORS headers
+// of the responses to the allowed origins. It wraps the router, so that the
+// preflight requests are answered before the routes and the authentication.
+func corsHandler(cfg config.CORSConfig, h http.Handler) http.Handler {
+	if !cfg.Enabled {
+		log.Println("CORS is disabled")
+		return h
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		log.Println("CORS is enabled without allowed origins, cross-origin requests are refused")
+		return h
+	}
+
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = corsDefaultMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = corsDefaultHeaders
+	}
+	opts := []handlers.CORSOption{
+		handlers.AllowedOrigins(cfg.AllowedOrigins),
+		handlers.AllowedMethods(methods),
+		handlers.AllowedHeaders(headers),
+	}
+	if len(cfg.ExposedHeaders) > 0 {
+		opts = append(opts, handlers.ExposedHeaders(cfg.ExposedHeaders))
+	}
+	if cfg.AllowCredentials {
+		for _, origin := range cfg.AllowedOrigins {
+			if origin == "*" {
+				log.Println("CORS credentials need the allowed origins to be listed, browsers refuse them with \"*\"")
+				break
+			}
+		}
+		opts = append(opts, handlers.AllowCredentials())
+	}
+	if cfg.MaxAge > 0 {
+		opts = append(opts, handlers.MaxAge(int(cfg.MaxAge.Seconds())))
+	}
+
+	log.Println("CORS allowed origins:", cfg.AllowedOrigins)
+	return handlers.CORS(opts...)(h)
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	CWMP_ROTATE_CREDENTIALS = "/cwmp/device/{deviceId}/credentials/rotate"
+	CWMP_CRED_ROTATIONS     = "/cwmp/credential-rotations/"
+	CWMP_CRED_ROTATION      = "/cwmp/credential-rotations/{id}"
+)
+
+func (as *ApiServer) setCwmpCredRotationRoutesHandlers() {
+	as.router.HandleFunc(CWMP_ROTATE_CREDENTIALS, as.rotateCwmpCredentials).Methods("POST")
+	as.router.HandleFunc(CWMP_CRED_ROTATIONS, as.getCwmpCredentialRotations).Methods("GET")
+	as.router.HandleFunc(CWMP_CRED_ROTATION, as.getCwmpCredentialRotation).Methods("GET")
+}
+
+// rotateCwmpCredentials starts the rotation of the connection request
+// credentials of a device. The ACS pushes the new credentials and commits
+// them to the device record once a connection request with them succeeds.
+func (as *ApiServer) rotateCwmpCredentials(w http.ResponseWriter, r *http.Request) {
+	deviceId := mux.Vars(r)["deviceId"]
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	if _, err := as.loadCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	active, err := as.dbH.cwmpIntf.GetActiveCredentialRotation(deviceId)
+	if err == nil {
+		httpSendRes(w, nil, errConflict("device %s has credential rotation %s %s", deviceId, active.ID, active.Status))
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	rotation := &db.CredentialRotation{DeviceID: deviceId, RequestID: requestID}
+	if err := as.dbH.cwmpIntf.InsertCredentialRotation(rotation); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	cmd := &acsbus.Command{
+		DeviceID:   deviceId,
+		Method:     acsbus.MethodRotateCredentials,
+		RotationID: rotation.ID,
+		RequestID:  requestID,
+	}
+	if err := as.sendAcsCommand(cmd); err != nil {
+		if _, tErr := as.dbH.cwmpIntf.TransitionCredentialRotation(rotation.ID, db.CredRotationRequested, db.CredRotationFailed, err.Error()); tErr != nil {
+			log.Printf("Error failing credential rotation %s: %v", rotation.ID, tErr)
+		}
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Requested credential rotation %s for device %s", rotation.ID, deviceId)
+	httpSendRes(w, rotation, nil)
+}
+
+// getCwmpCredentialRotations lists credential rotations, newest first
+func (as *ApiServer) getCwmpCredentialRotations(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	query := r.URL.Query()
+	if deviceId := query.Get("device_id"); deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if status := query.Get("status"); status != "" {
+		filter["status"] = status
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	rotations, err := as.dbH.cwmpIntf.GetCredentialRotations(filter, limit)
+	httpSendRes(w, rotations, err)
+}
+
+func (as *ApiServer) getCwmpCredentialRotation(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	rotation, err := as.dbH.cwmpIntf.GetCredentialRotation(mux.Vars(r)["id"])
+	httpSendRes(w, rotation, err)
+}
@@ -15,14 +15,25 @@
 package apiserver
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
 	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // TR-069 CWMP API endpoints
@@ -37,7 +48,14 @@ const (
 	CWMP_DOWNLOAD           = "/cwmp/device/{deviceId}/download"
 	CWMP_UPLOAD             = "/cwmp/device/{deviceId}/upload"
 	CWMP_CONNECTION_REQUEST = "/cwmp/device/{deviceId}/connection-request"
-	CWMP_POPULATE_SAMPLE    = "/cwmp/populate-sample-data"
+	CWMP_GET_CONNECTION_REQUEST = "/cwmp/device/{deviceId}/connection-request/{id}"
+	CWMP_GET_IP_HISTORY     = "/cwmp/device/{deviceId}/ip-history"
+	CWMP_GET_EVENTS         = "/cwmp/device/{deviceId}/events"
+	CWMP_GET_TIMELINE       = "/cwmp/device/{deviceId}/timeline"
+	CWMP_GET_SESSIONS       = "/cwmp/sessions/"
+	CWMP_GET_TRANSFERS      = "/cwmp/transfers/"
+	CWMP_GET_TRANSFER       = "/cwmp/transfers/{id}"
+	CWMP_GET_DEVICE_TRANSFERS = "/cwmp/device/{deviceId}/transfers"
 )
 
 // CwmpDeviceInfo represents device information for API responses
@@ -53,6 +71,54 @@ type CwmpDeviceInfo struct {
 	IsOnline         bool              `json:"is_online"`
 	ParameterCount   int               `json:"parameter_count"`
 	ConnectionRequestURL string        `json:"connection_request_url"`
+	IPAddress        string            `json:"ip_address"`
+	Geo              *db.DeviceGeo     `json:"geo,omitempty"`
+	Tags             []string          `json:"tags,omitempty"`
+	ArchivedAt       *time.Time        `json:"archived_at,omitempty"`
+	Tenant           string            `json:"tenant,omitempty"`
+}
+
+// CwmpSessionInfo represents CWMP session information for API responses
+type CwmpSessionInfo struct {
+	SessionId            string    `json:"session_id"`
+	DeviceId             string    `json:"device_id"`
+	State                string    `json:"state"`
+	CurrentRPC           string    `json:"current_rpc"`
+	PendingRPCs          []string  `json:"pending_rpcs"`
+	PendingRPCCount      int       `json:"pending_rpc_count"`
+	LastActivity         time.Time `json:"last_activity"`
+	CreatedAt            time.Time `json:"created_at"`
+	ConnectionRequestURL string    `json:"connection_request_url"`
+	CwmpVersion          string    `json:"cwmp_version,omitempty"`
+	CloseReason          string     `json:"close_reason,omitempty"`
+	ClosedAt             *time.Time `json:"closed_at,omitempty"`
+}
+
+// CwmpTransferInfo represents a CWMP download/upload for API responses
+type CwmpTransferInfo struct {
+	Id             string     `json:"id"`
+	DeviceId       string     `json:"device_id"`
+	CommandKey     string     `json:"command_key"`
+	FileType       string     `json:"file_type"`
+	URL            string     `json:"url"`
+	FileID         string     `json:"file_id,omitempty"`
+	TargetFileName string     `json:"target_file_name,omitempty"`
+	FileSize       int64      `json:"file_size"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	StartTime      *time.Time `json:"start_time,omitempty"`
+	CompleteTime   *time.Time `json:"complete_time,omitempty"`
+	// DurationSeconds is the time from the start to the completion of the
+	// transfer, or to now while it is in progress
+	DurationSeconds *float64  `json:"duration_seconds,omitempty"`
+	FaultCode      string     `json:"fault_code,omitempty"`
+	FaultString    string     `json:"fault_string,omitempty"`
+	CpeState       string     `json:"cpe_state,omitempty"`
+	CheckedAt      *time.Time `json:"checked_at,omitempty"`
+	Autonomous     bool       `json:"autonomous"`
+	Upload         bool       `json:"upload,omitempty"`
+	AnnounceURL    string     `json:"announce_url,omitempty"`
+	TaskID         string     `json:"task_id,omitempty"`
 }
 
 // CwmpParameterRequest represents parameter operation request
@@ -69,16 +135,20 @@ type CwmpRebootRequest struct {
 
 // CwmpDownloadRequest represents download request
 type CwmpDownloadRequest struct {
-	CommandKey     string `json:"command_key"`
-	FileType       string `json:"file_type"`
-	URL            string `json:"url"`
-	Username       string `json:"username"`
-	Password       string `json:"password"`
-	FileSize       uint32 `json:"file_size"`
-	TargetFileName string `json:"target_filename"`
-	DelaySeconds   uint32 `json:"delay_seconds"`
-	SuccessURL     string `json:"success_url"`
-	FailureURL     string `json:"failure_url"`
+	CommandKey      string `json:"command_key"`
+	FileType        string `json:"file_type"`
+	URL             string `json:"url"`
+	FileID          string `json:"file_id,omitempty"` // file of the file store, replaces url
+	FirmwareID      string `json:"firmware_id,omitempty"` // image of the firmware catalog, replaces url and file_type
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	FileSize        uint32 `json:"file_size"`
+	TargetFileName  string `json:"target_filename"`
+	DelaySeconds    uint32 `json:"delay_seconds"`
+	SuccessURL      string `json:"success_url"`
+	FailureURL      string `json:"failure_url"`
+	FirmwareVersion string `json:"firmware_version,omitempty"` // checked against the compatibility matrix
+	Force           bool   `json:"force,omitempty"`            // skip the compatibility check, for experts only
 }
 
 // CwmpUploadRequest represents upload request
@@ -95,41 +165,98 @@ type CwmpUploadRequest struct {
 func (as *ApiServer) setCwmpRoutesHandlers() {
 	// Device management endpoints
 	as.router.HandleFunc(CWMP_GET_DEVICES, as.getCwmpDevices).Methods("GET")
+	as.router.HandleFunc(CWMP_EXPORT_DEVICES, as.exportCwmpDevices).Methods("GET")
+	as.router.HandleFunc(CWMP_SEARCH_DEVICES, as.searchCwmpDevices).Methods("POST")
 	as.router.HandleFunc(CWMP_GET_DEVICE, as.getCwmpDevice).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_DEVICE, as.deleteCwmpDevice).Methods("DELETE")
 	as.router.HandleFunc(CWMP_GET_DEVICE_INFO, as.getCwmpDeviceInfo).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_IP_HISTORY, as.getCwmpIPHistory).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_EVENTS, as.getCwmpEvents).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_TIMELINE, as.getCwmpTimeline).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_SESSIONS, as.getCwmpSessions).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_TRANSFERS, as.getCwmpTransfers).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_TRANSFER, as.getCwmpTransfer).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_DEVICE_TRANSFERS, as.getCwmpTransfers).Methods("GET")
 	
 	// Parameter management endpoints
 	as.router.HandleFunc(CWMP_GET_PARAMS, as.getCwmpParams).Methods("GET")
 	as.router.HandleFunc(CWMP_SET_PARAMS, as.setCwmpParams).Methods("POST")
+	as.router.HandleFunc(CWMP_GET_DATAMODEL, as.getCwmpDataModel).Methods("GET")
 	
 	// Device control endpoints
 	as.router.HandleFunc(CWMP_REBOOT_DEVICE, as.rebootCwmpDevice).Methods("POST")
 	as.router.HandleFunc(CWMP_FACTORY_RESET, as.factoryResetCwmpDevice).Methods("POST")
 	as.router.HandleFunc(CWMP_CONNECTION_REQUEST, as.connectionRequestCwmpDevice).Methods("POST")
+	as.router.HandleFunc(CWMP_GET_CONNECTION_REQUEST, as.getCwmpConnectionRequest).Methods("GET")
 	
 	// File transfer endpoints
 	as.router.HandleFunc(CWMP_DOWNLOAD, as.downloadCwmpDevice).Methods("POST")
 	as.router.HandleFunc(CWMP_UPLOAD, as.uploadCwmpDevice).Methods("POST")
-	
-	// Sample data endpoint (for testing/demo)
-	as.router.HandleFunc(CWMP_POPULATE_SAMPLE, as.populateSampleCwmpData).Methods("POST")
+	as.router.HandleFunc(CWMP_QUEUED_TRANSFERS, as.getCwmpQueuedTransfers).Methods("POST")
+
+	// Bulk operation endpoints
+	as.router.HandleFunc(CWMP_BULK_SET_PARAMS, as.bulkSetCwmpParams).Methods("POST")
+	as.router.HandleFunc(CWMP_BULK_REBOOT, as.bulkRebootCwmpDevices).Methods("POST")
+	as.router.HandleFunc(CWMP_BULK_PREVIEW, as.getBulkPreview).Methods("GET")
+	as.router.HandleFunc(CWMP_BULK_JOBS, as.getBulkJobs).Methods("GET")
+	as.router.HandleFunc(CWMP_BULK_JOB, as.getBulkJob).Methods("GET")
+	as.router.HandleFunc(CWMP_BULK_JOB_RESULTS, as.getBulkJobResults).Methods("GET")
+
+	// SOAP trace endpoints
+	as.router.HandleFunc(CWMP_DEVICE_TRACE, as.getCwmpTrace).Methods("GET")
+	as.router.HandleFunc(CWMP_DEVICE_TRACE, as.setCwmpTrace).Methods("PUT")
+	as.router.HandleFunc(CWMP_TRACE_STREAM, as.streamCwmpTrace).Methods("GET")
+
+	// Bootstrap synchronization endpoints
+	as.router.HandleFunc(CWMP_GET_SYNC_JOBS, as.getCwmpSyncJobs).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_SYNC_JOB, as.getCwmpSyncJob).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_SNAPSHOT, as.getCwmpSnapshot).Methods("GET")
+
+	// Outbound command queue endpoints
+	as.router.HandleFunc(CWMP_GET_COMMANDS, as.getCwmpCommands).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_COMMAND, as.getCwmpCommand).Methods("GET")
 }
 
-// getCwmpDevices returns all CWMP devices
+// getCwmpDevices returns a page of the CWMP devices matching the query
 func (as *ApiServer) getCwmpDevices(w http.ResponseWriter, r *http.Request) {
 	// Check database connection
 	if as.dbH.cwmpIntf == nil {
-		httpSendRes(w, nil, fmt.Errorf("CWMP database not connected"))
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter, err := cwmpDeviceFilter(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
 		return
 	}
+	as.sendCwmpDeviceList(w, r, filter)
+}
+
+// cwmpDeviceFilter builds the device filter of the query parameters of the
+// device listing and export
+func cwmpDeviceFilter(r *http.Request) (bson.M, error) {
+	return cwmpDeviceQueryFilter(r.URL.Query())
+}
 
+// cwmpDeviceQueryFilter builds the device filter of the device listing query
+// parameters
+func cwmpDeviceQueryFilter(query url.Values) (bson.M, error) {
 	// Get query parameters for filtering
-	manufacturer := r.URL.Query().Get("manufacturer")
-	productClass := r.URL.Query().Get("product_class")
-	onlineOnly := r.URL.Query().Get("online_only") == "true"
-	
-	// Build database filter
-	filter := bson.M{}
+	manufacturer := query.Get("manufacturer")
+	productClass := query.Get("product_class")
+	onlineOnly := query.Get("online_only") == "true"
+	ipCidr := query.Get("ip_cidr")
+	ipFrom := query.Get("ip_from")
+	ipTo := query.Get("ip_to")
+	country := query.Get("country")
+	region := query.Get("region")
+	asn := query.Get("asn")
+	dataModel := query.Get("data_model")
+	tenant := query.Get("tenant")
+
+	// Build database filter, archived devices are only listed on request
+	filter := bson.M{"archived_at": bson.M{"$exists": query.Get("archived") == "true"}}
 	if manufacturer != "" {
 		filter["manufacturer"] = bson.M{
 			"$regex":   manufacturer,
@@ -149,37 +276,46 @@ func (as *ApiServer) getCwmpDevices(w http.ResponseWriter, r *http.Request) {
 			"$gte": fiveMinutesAgo,
 		}
 	}
-	
-	// Get devices from database
-	dbDevices, err := as.dbH.cwmpIntf.GetCwmpDevicesByFilter(filter)
-	if err != nil {
-		httpSendRes(w, nil, fmt.Errorf("failed to retrieve devices: %w", err))
-		return
+	if ipCidr != "" {
+		// Match devices within the subnet, e.g. ip_cidr=100.64.0.0/10
+		ipFilter, err := db.IPCidrFilter(ipCidr)
+		if err != nil {
+			return nil, errBadRequest("invalid ip_cidr: %w", err)
+		}
+		filter["ip_key"] = ipFilter
+	} else if ipFrom != "" || ipTo != "" {
+		// Match devices within an address range, e.g. ip_from=10.0.0.1&ip_to=10.0.0.99
+		ipFilter, err := db.IPRangeFilter(ipFrom, ipTo)
+		if err != nil {
+			return nil, errBadRequest("%w", err)
+		}
+		filter["ip_key"] = ipFilter
 	}
-	
-	// Convert to API response format
-	var devices []CwmpDeviceInfo
-	for _, dbDevice := range dbDevices {
-		// Determine if device is online (last inform within 5 minutes)
-		isOnline := time.Since(dbDevice.LastInform) <= 5*time.Minute
-		
-		device := CwmpDeviceInfo{
-			DeviceId:        dbDevice.ID,
-			Manufacturer:    dbDevice.Manufacturer,
-			OUI:            dbDevice.OUI,
-			ProductClass:   dbDevice.ProductClass,
-			SerialNumber:   dbDevice.SerialNumber,
-			SoftwareVersion: dbDevice.SoftwareVersion,
-			HardwareVersion: dbDevice.HardwareVersion,
-			LastInformTime:  dbDevice.LastInform.Format(time.RFC3339),
-			IsOnline:       isOnline,
-			ParameterCount: len(dbDevice.Parameters),
-			ConnectionRequestURL: dbDevice.ConnectionRequestURL,
+	if country != "" {
+		filter["geo.country"] = strings.ToUpper(country)
+	}
+	if region != "" {
+		filter["geo.region"] = region
+	}
+	if asn != "" {
+		asNumber, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(asn), "AS"), 10, 32)
+		if err != nil {
+			return nil, errBadRequest("invalid asn: %s", asn)
 		}
-		devices = append(devices, device)
+		filter["geo.asn"] = asNumber
 	}
-	
-	httpSendRes(w, devices, nil)
+	if dataModel != "" {
+		filter["data_model"] = strings.ToUpper(dataModel)
+	}
+	if tenant != "" {
+		filter["tenant"] = tenant
+	}
+	if tags := query["tag"]; len(tags) > 0 {
+		// Match devices carrying every tag, e.g. tag=residential&tag=fiber
+		filter["tags"] = bson.M{"$all": tags}
+	}
+
+	return filter, nil
 }
 
 // getCwmpDevice returns specific CWMP device information
@@ -188,41 +324,33 @@ func (as *ApiServer) getCwmpDevice(w http.ResponseWriter, r *http.Request) {
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	// Check database connection
 	if as.dbH.cwmpIntf == nil {
-		httpSendRes(w, nil, fmt.Errorf("CWMP database not connected"))
+		httpSendRes(w, nil, errCwmpDbNotConnected)
 		return
 	}
 	
 	// Get device from database
-	dbDevice, err := as.dbH.cwmpIntf.GetCwmpDeviceByID(deviceId)
+	dbDevice, err := as.loadCwmpDevice(deviceId)
 	if err != nil {
-		httpSendRes(w, nil, fmt.Errorf("device not found: %w", err))
+		httpSendRes(w, nil, err)
 		return
 	}
 	
 	// Determine if device is online (last inform within 5 minutes)
 	isOnline := time.Since(dbDevice.LastInform) <= 5*time.Minute
-	
-	// Convert to API response format
-	device := CwmpDeviceInfo{
-		DeviceId:        dbDevice.ID,
-		Manufacturer:    dbDevice.Manufacturer,
-		OUI:            dbDevice.OUI,
-		ProductClass:   dbDevice.ProductClass,
-		SerialNumber:   dbDevice.SerialNumber,
-		SoftwareVersion: dbDevice.SoftwareVersion,
-		HardwareVersion: dbDevice.HardwareVersion,
-		LastInformTime:  dbDevice.LastInform.Format(time.RFC3339),
-		IsOnline:       isOnline,
-		ParameterCount: len(dbDevice.Parameters),
-		ConnectionRequestURL: dbDevice.ConnectionRequestURL,
+	if notModified(w, r, resourceETag(dbDevice.ID, dbDevice.UpdatedAt.UnixNano(), isOnline)) {
+		return
 	}
 	
+	// Convert to API response format, online as in the ETag
+	device := newCwmpDeviceInfo(dbDevice)
+	device.IsOnline = isOnline
+	
 	httpSendRes(w, device, nil)
 }
 
@@ -232,25 +360,28 @@ func (as *ApiServer) getCwmpDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	// Check database connection
 	if as.dbH.cwmpIntf == nil {
-		httpSendRes(w, nil, fmt.Errorf("CWMP database not connected"))
+		httpSendRes(w, nil, errCwmpDbNotConnected)
 		return
 	}
 	
 	// Get device from database
-	dbDevice, err := as.dbH.cwmpIntf.GetCwmpDeviceByID(deviceId)
+	dbDevice, err := as.loadCwmpDevice(deviceId)
 	if err != nil {
-		httpSendRes(w, nil, fmt.Errorf("device not found: %w", err))
+		httpSendRes(w, nil, err)
 		return
 	}
 	
 	// Determine if device is online
 	isOnline := time.Since(dbDevice.LastInform) <= 5*time.Minute
+	if notModified(w, r, resourceETag("info", dbDevice.ID, dbDevice.UpdatedAt.UnixNano(), isOnline)) {
+		return
+	}
 	
 	// Calculate uptime in human-readable format
 	uptimeSeconds := dbDevice.UpTime
@@ -258,22 +389,13 @@ func (as *ApiServer) getCwmpDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	uptimeHours := (uptimeSeconds % (24 * 3600)) / 3600
 	uptimeStr := fmt.Sprintf("%d days, %d hours", uptimeDays, uptimeHours)
 	
+	basicInfo := newCwmpDeviceInfo(dbDevice)
+	basicInfo.IsOnline = isOnline
+
 	// Build detailed device info including all available data
 	deviceInfo := map[string]interface{}{
-		"device_id": deviceId,
-		"basic_info": CwmpDeviceInfo{
-			DeviceId:        dbDevice.ID,
-			Manufacturer:    dbDevice.Manufacturer,
-			OUI:            dbDevice.OUI,
-			ProductClass:   dbDevice.ProductClass,
-			SerialNumber:   dbDevice.SerialNumber,
-			SoftwareVersion: dbDevice.SoftwareVersion,
-			HardwareVersion: dbDevice.HardwareVersion,
-			LastInformTime:  dbDevice.LastInform.Format(time.RFC3339),
-			IsOnline:       isOnline,
-			ParameterCount: len(dbDevice.Parameters),
-			ConnectionRequestURL: dbDevice.ConnectionRequestURL,
-		},
+		"device_id":  deviceId,
+		"basic_info": basicInfo,
 		"capabilities": []string{"Download", "Upload", "Reboot", "FactoryReset"},
 		"statistics": map[string]interface{}{
 			"uptime":       uptimeStr,
@@ -296,24 +418,289 @@ func (as *ApiServer) getCwmpDeviceInfo(w http.ResponseWriter, r *http.Request) {
 	httpSendRes(w, deviceInfo, nil)
 }
 
+// getCwmpIPHistory returns the external address history of a CWMP device
+func (as *ApiServer) getCwmpIPHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deviceId := vars["deviceId"]
+
+	if deviceId == "" {
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
+		return
+	}
+
+	// Check database connection
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	history, err := as.dbH.cwmpIntf.GetCwmpIPHistory(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve IP history: %w", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"device_id": deviceId,
+		"history":   history,
+		"count":     len(history),
+	}
+
+	httpSendRes(w, response, nil)
+}
+
+// getCwmpEvents returns the event history of a CWMP device, newest first
+func (as *ApiServer) getCwmpEvents(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deviceId := vars["deviceId"]
+
+	if deviceId == "" {
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
+		return
+	}
+
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{"device_id": deviceId}
+	if code := r.URL.Query().Get("event_code"); code != "" {
+		filter["event_code"] = code
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid since, expected RFC3339: %s", sinceStr))
+			return
+		}
+		filter["timestamp"] = bson.M{"$gte": since}
+	}
+	limit := int64(100)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || l <= 0 {
+			httpSendRes(w, nil, errBadRequest("invalid limit: %s", limitStr))
+			return
+		}
+		limit = l
+	}
+
+	events, err := as.dbH.cwmpIntf.GetCwmpDeviceEvents(filter, limit)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve events: %w", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"device_id": deviceId,
+		"events":    events,
+		"count":     len(events),
+	}
+
+	httpSendRes(w, response, nil)
+}
+
+// getCwmpTimeline returns the merged activity feed of a CWMP device, newest
+// first. Pages are requested with the next_before cursor of the previous page.
+func (as *ApiServer) getCwmpTimeline(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deviceId := vars["deviceId"]
+
+	if deviceId == "" {
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
+		return
+	}
+
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	before := time.Now()
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		t, err := time.Parse(time.RFC3339Nano, beforeStr)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid before, expected RFC3339: %s", beforeStr))
+			return
+		}
+		before = t
+	}
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l <= 0 || l > 500 {
+			httpSendRes(w, nil, errBadRequest("invalid limit, expected 1-500: %s", limitStr))
+			return
+		}
+		limit = l
+	}
+
+	entries, err := as.dbH.cwmpIntf.GetCwmpDeviceTimeline(deviceId, before, limit)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve timeline: %w", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"device_id": deviceId,
+		"entries":   entries,
+		"count":     len(entries),
+	}
+	if len(entries) == limit {
+		response["next_before"] = entries[len(entries)-1].Timestamp.Format(time.RFC3339Nano)
+	}
+
+	httpSendRes(w, response, nil)
+}
+
+// getCwmpSessions lists the active and recent CWMP sessions, optionally
+// filtered by device ID and state
+func (as *ApiServer) getCwmpSessions(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if deviceId := r.URL.Query().Get("device_id"); deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if state := r.URL.Query().Get("state"); state != "" {
+		filter["state"] = state
+	}
+	if r.URL.Query().Get("active_only") == "true" {
+		filter["state"] = bson.M{"$ne": cwmp.SessionStateNameClosed}
+	}
+	limit := int64(100)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || l <= 0 {
+			httpSendRes(w, nil, errBadRequest("invalid limit: %s", limitStr))
+			return
+		}
+		limit = l
+	}
+
+	dbSessions, err := as.dbH.cwmpIntf.GetCwmpSessions(filter, limit)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve sessions: %w", err))
+		return
+	}
+
+	sessions := []CwmpSessionInfo{}
+	for _, s := range dbSessions {
+		sessions = append(sessions, CwmpSessionInfo{
+			SessionId:            s.SessionID,
+			DeviceId:             s.DeviceID,
+			State:                s.State,
+			CurrentRPC:           s.CurrentRPCMethod,
+			PendingRPCs:          s.PendingRPCs,
+			PendingRPCCount:      len(s.PendingRPCs),
+			LastActivity:         s.LastActivity,
+			CreatedAt:            s.CreatedAt,
+			ConnectionRequestURL: s.ConnectionRequestURL,
+			CwmpVersion:          s.CwmpVersion,
+			CloseReason:          s.CloseReason,
+			ClosedAt:             s.ClosedAt,
+		})
+	}
+	httpSendRes(w, sessions, nil)
+}
+
+// getCwmpTransfers lists downloads and uploads across the fleet, or of the
+// device of the path, optionally filtered by device ID, status and file type
+func (as *ApiServer) getCwmpTransfers(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if deviceId := r.URL.Query().Get("device_id"); deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if deviceId := mux.Vars(r)["deviceId"]; deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if fileType := r.URL.Query().Get("file_type"); fileType != "" {
+		filter["file_type"] = fileType
+	}
+	if commandKey := r.URL.Query().Get("command_key"); commandKey != "" {
+		filter["command_key"] = commandKey
+	}
+	if cpeState := r.URL.Query().Get("cpe_state"); cpeState != "" {
+		filter["cpe_state"] = cpeState
+	}
+	switch autonomous := r.URL.Query().Get("autonomous"); autonomous {
+	case "":
+	case "true":
+		filter["autonomous"] = true
+	case "false":
+		filter["autonomous"] = bson.M{"$ne": true}
+	default:
+		httpSendRes(w, nil, errBadRequest("invalid autonomous: %s", autonomous))
+		return
+	}
+	limit := int64(100)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || l <= 0 {
+			httpSendRes(w, nil, errBadRequest("invalid limit: %s", limitStr))
+			return
+		}
+		limit = l
+	}
+
+	dbTransfers, err := as.dbH.cwmpIntf.GetCwmpFileTransfers(filter, limit)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve file transfers: %w", err))
+		return
+	}
+
+	transfers := make([]CwmpTransferInfo, 0, len(dbTransfers))
+	for i := range dbTransfers {
+		transfers = append(transfers, toCwmpTransferInfo(&dbTransfers[i]))
+	}
+	httpSendRes(w, transfers, nil)
+}
+
 // getCwmpParams gets parameter values from CWMP device
 func (as *ApiServer) getCwmpParams(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	// Check database connection
 	if as.dbH.cwmpIntf == nil {
-		httpSendRes(w, nil, fmt.Errorf("CWMP database not connected"))
+		httpSendRes(w, nil, errCwmpDbNotConnected)
 		return
 	}
 	
 	// Get parameter names from query
 	parameterNames := r.URL.Query()["parameters"]
+	if r.URL.Query().Get("live") == "true" {
+		as.getCwmpLiveParams(w, r, deviceId, parameterNames)
+		return
+	}
+
+	// The parameters are only loaded if they changed since the ETag of the
+	// request was computed
+	count, latest, err := as.dbH.cwmpIntf.CwmpParametersVersion(deviceId, parameterNames)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve parameters: %w", err))
+		return
+	}
+	if notModified(w, r, resourceETag(deviceId, parameterNames, count, latest.UnixNano())) {
+		return
+	}
 	
 	var parameters []cwmp.ParameterValueStruct
 	
@@ -383,32 +770,39 @@ func (as *ApiServer) setCwmpParams(w http.ResponseWriter, r *http.Request) {
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	var req CwmpParameterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpSendRes(w, nil, fmt.Errorf("invalid request body: %w", err))
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
 		return
 	}
 	
 	if len(req.Parameters) == 0 {
-		httpSendRes(w, nil, fmt.Errorf("parameters are required"))
+		httpSendRes(w, nil, errBadRequest("parameters are required"))
 		return
 	}
 	
-	// In real implementation, send to controller
-	// err := as.controller.SetCwmpParameters(deviceId, req.Parameters, req.ParameterKey)
-	
-	response := map[string]interface{}{
-		"device_id":     deviceId,
-		"status":       "success",
-		"message":      fmt.Sprintf("Set %d parameters", len(req.Parameters)),
-		"parameter_key": req.ParameterKey,
-		"timestamp":    "2023-12-01T10:00:00Z",
+	if err := as.checkCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
 	}
-	
+	release, err := as.acquireDeviceJob(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.CntlrCwmpSetParamsReq(r.Context(), deviceId, req.Parameters, req.ParameterKey); err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	response := as.newCwmpCommandSubmission(r.Context(), deviceId, acsbus.MethodSetParameterValues, fmt.Sprintf("Set %d parameters", len(req.Parameters)))
+	as.releaseWithTasks(release, response.TaskID)
+	response.ParameterKey = req.ParameterKey
 	httpSendRes(w, response, nil)
 }
 
@@ -418,27 +812,42 @@ func (as *ApiServer) rebootCwmpDevice(w http.ResponseWriter, r *http.Request) {
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
+	// The body is optional, the command key is generated if missing
 	var req CwmpRebootRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpSendRes(w, nil, fmt.Errorf("invalid request body: %w", err))
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
 		return
 	}
-	
-	// In real implementation, send to controller
-	// err := as.controller.RebootCwmpDevice(deviceId, req.CommandKey)
-	
-	response := map[string]interface{}{
-		"device_id":    deviceId,
-		"status":      "success",
-		"message":     "Reboot command sent",
-		"command_key": req.CommandKey,
-		"timestamp":   "2023-12-01T10:00:00Z",
+	if err := as.checkCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
 	}
-	
+
+	ctx := r.Context()
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	}
+	if req.CommandKey == "" {
+		req.CommandKey = "reboot:" + logging.RequestID(ctx)
+	}
+	release, err := as.acquireDeviceJob(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.CntlrCwmpRebootReq(ctx, deviceId, req.CommandKey); err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	response := as.newCwmpCommandSubmission(ctx, deviceId, acsbus.MethodReboot, "Reboot command sent")
+	as.releaseWithTasks(release, response.TaskID)
+	response.CommandKey = req.CommandKey
 	httpSendRes(w, response, nil)
 }
 
@@ -448,116 +857,351 @@ func (as *ApiServer) factoryResetCwmpDevice(w http.ResponseWriter, r *http.Reque
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
-	// In real implementation, send to controller
-	// err := as.controller.FactoryResetCwmpDevice(deviceId)
-	
-	response := map[string]interface{}{
-		"device_id": deviceId,
-		"status":   "success",
-		"message":  "Factory reset command sent",
-		"timestamp": "2023-12-01T10:00:00Z",
+	if err := as.checkCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
 	}
-	
+	release, err := as.acquireDeviceJob(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.CntlrCwmpFactoryResetReq(r.Context(), deviceId); err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	response := as.newCwmpCommandSubmission(r.Context(), deviceId, acsbus.MethodFactoryReset, "Factory reset command sent")
+	as.releaseWithTasks(release, response.TaskID)
 	httpSendRes(w, response, nil)
 }
 
-// connectionRequestCwmpDevice initiates connection request to CWMP device
+// checkCwmpDevice fails unless the device is registered
+func (as *ApiServer) checkCwmpDevice(deviceId string) error {
+	if as.dbH.cwmpIntf == nil {
+		return errCwmpDbNotConnected
+	}
+	_, err := as.loadCwmpDevice(deviceId)
+	return err
+}
+
+// loadCwmpDevice loads a device, reporting a device which is not registered
+// as DEVICE_NOT_FOUND
+func (as *ApiServer) loadCwmpDevice(deviceId string) (*db.CwmpDevice, error) {
+	device, err := as.dbH.cwmpIntf.GetCwmpDeviceByID(deviceId)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errDeviceNotFound(deviceId)
+	}
+	return device, err
+}
+
+// connectionRequestCwmpDevice sends a connection request to the CWMP device
+// and reports its outcome, which is also stored on the device. The ID of the
+// outcome is used to follow the request with getCwmpConnectionRequest.
 func (as *ApiServer) connectionRequestCwmpDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
-	
-	// In real implementation, send connection request to device
-	// err := as.controller.SendConnectionRequest(deviceId)
-	
-	response := map[string]interface{}{
-		"device_id": deviceId,
-		"status":   "success",
-		"message":  "Connection request sent",
-		"timestamp": "2023-12-01T10:00:00Z",
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	outcome, err := cwmp.SendConnectionRequest(r.Context(), as.dbH.cwmpIntf, as.connReq, deviceId)
+	if err != nil {
+		if errors.Is(err, cwmp.ErrNoConnectionRequestURL) {
+			err = errConflict("device %s has not reported a ConnectionRequestURL", deviceId)
+		}
+		httpSendRes(w, nil, err)
+		return
+	}
+	switch outcome.Status {
+	case db.ConnReqDelivered, db.ConnReqSent:
+		httpSendRes(w, outcome, nil)
+	case db.ConnReqTimeout, db.ConnReqUnreachable:
+		httpSendRes(w, nil, errUnavailable("connection request %s to device %s: %s after %d attempt(s): %s", outcome.ID, deviceId, outcome.Status, outcome.Attempts, outcome.Error))
+	default:
+		httpSendRes(w, nil, errControllerFailure("connection request %s to device %s: %s: %s", outcome.ID, deviceId, outcome.Status, outcome.Error))
 	}
-	
-	httpSendRes(w, response, nil)
 }
 
-// downloadCwmpDevice initiates download to CWMP device
+// getCwmpConnectionRequest reports whether a connection request was
+// delivered, whether the device informed afterwards and how long it took
+func (as *ApiServer) getCwmpConnectionRequest(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	vars := mux.Vars(r)
+	record, err := as.dbH.cwmpIntf.GetCwmpConnRequest(vars["id"])
+	if err == nil && record.DeviceID != vars["deviceId"] {
+		err = errNotFound("connection request %s not found for device %s", vars["id"], vars["deviceId"])
+	}
+	httpSendRes(w, record, err)
+}
+
+// downloadCwmpDevice sends a Download to the CWMP device and returns the
+// transfer tracking it until the device reports TransferComplete
 func (as *ApiServer) downloadCwmpDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	var req CwmpDownloadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpSendRes(w, nil, fmt.Errorf("invalid request body: %w", err))
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
 		return
 	}
+	if req.FirmwareID != "" {
+		if as.dbH.cwmpIntf == nil {
+			httpSendRes(w, nil, errCwmpDbNotConnected)
+			return
+		}
+		device, err := as.loadCwmpDevice(deviceId)
+		if err == nil {
+			err = as.resolveFirmwareImage(&req, device)
+		}
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
 	
-	if req.URL == "" || req.FileType == "" {
-		httpSendRes(w, nil, fmt.Errorf("URL and file_type are required"))
+	if (req.URL == "" && req.FileID == "") || req.FileType == "" {
+		httpSendRes(w, nil, errBadRequest("URL or file_id and file_type are required"))
 		return
 	}
-	
-	// In real implementation, send to controller
-	// err := as.controller.DownloadToCwmpDevice(deviceId, req)
-	
-	response := map[string]interface{}{
-		"device_id":    deviceId,
-		"status":      "success",
-		"message":     "Download command sent",
-		"command_key": req.CommandKey,
-		"file_type":   req.FileType,
-		"url":        req.URL,
-		"timestamp":   "2023-12-01T10:00:00Z",
+	if req.FileID != "" {
+		if as.dbH.cwmpIntf == nil {
+			httpSendRes(w, nil, errCwmpDbNotConnected)
+			return
+		}
+		file, err := as.dbH.cwmpIntf.GetFile(req.FileID)
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		req.URL = ""
+		if req.FileSize == 0 {
+			req.FileSize = uint32(file.Length)
+		}
+		if req.TargetFileName == "" {
+			req.TargetFileName = file.Name
+		}
 	}
-	
-	httpSendRes(w, response, nil)
+
+	if req.FileType == cwmp.FileTypeFirmwareUpgrade {
+		if err := as.checkFirmwareCompat(deviceId, req.FirmwareVersion, req.Force); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+
+	transfer, cmd := newDownloadTransfer(deviceId, &req)
+	as.startCwmpTransfer(w, r, transfer, cmd)
 }
 
-// uploadCwmpDevice initiates upload from CWMP device
+// newDownloadTransfer returns the transfer tracking a Download and the
+// command sending it
+func newDownloadTransfer(deviceId string, req *CwmpDownloadRequest) (*db.CwmpFileTransfer, *acsbus.Command) {
+	transfer := &db.CwmpFileTransfer{
+		DeviceID:       deviceId,
+		CommandKey:     req.CommandKey,
+		FileType:       req.FileType,
+		URL:            req.URL,
+		FileID:         req.FileID,
+		Username:       req.Username,
+		FileSize:       int64(req.FileSize),
+		TargetFileName: req.TargetFileName,
+		DelaySeconds:   int(req.DelaySeconds),
+		SuccessURL:     req.SuccessURL,
+		FailureURL:     req.FailureURL,
+	}
+	if req.FileType == cwmp.FileTypeFirmwareUpgrade {
+		transfer.FirmwareVersion = req.FirmwareVersion
+	}
+	return transfer, &acsbus.Command{
+		Method: acsbus.MethodDownload,
+		Transfer: &acsbus.Transfer{
+			FileType:       req.FileType,
+			URL:            req.URL,
+			FileID:         req.FileID,
+			Username:       req.Username,
+			Password:       req.Password,
+			FileSize:       req.FileSize,
+			TargetFileName: req.TargetFileName,
+			DelaySeconds:   req.DelaySeconds,
+			SuccessURL:     req.SuccessURL,
+			FailureURL:     req.FailureURL,
+		},
+	}
+}
+
+// uploadCwmpDevice sends an Upload to the CWMP device and returns the
+// transfer tracking it until the device reports TransferComplete
 func (as *ApiServer) uploadCwmpDevice(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deviceId := vars["deviceId"]
 	
 	if deviceId == "" {
-		httpSendRes(w, nil, fmt.Errorf("device ID is required"))
+		httpSendRes(w, nil, errBadRequest("device ID is required"))
 		return
 	}
 	
 	var req CwmpUploadRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		httpSendRes(w, nil, fmt.Errorf("invalid request body: %w", err))
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
 		return
 	}
 	
 	if req.URL == "" || req.FileType == "" {
-		httpSendRes(w, nil, fmt.Errorf("URL and file_type are required"))
+		httpSendRes(w, nil, errBadRequest("URL and file_type are required"))
 		return
 	}
-	
-	// In real implementation, send to controller
-	// err := as.controller.UploadFromCwmpDevice(deviceId, req)
-	
-	response := map[string]interface{}{
-		"device_id":    deviceId,
-		"status":      "success",
-		"message":     "Upload command sent",
-		"command_key": req.CommandKey,
-		"file_type":   req.FileType,
-		"url":        req.URL,
-		"timestamp":   "2023-12-01T10:00:00Z",
+
+	transfer := &db.CwmpFileTransfer{
+		DeviceID:     deviceId,
+		CommandKey:   req.CommandKey,
+		FileType:     req.FileType,
+		URL:          req.URL,
+		Username:     req.Username,
+		DelaySeconds: int(req.DelaySeconds),
 	}
-	
-	httpSendRes(w, response, nil)
-}
\ No newline at end of file
+	cmd := &acsbus.Command{
+		Method: acsbus.MethodUpload,
+		Transfer: &acsbus.Transfer{
+			FileType:     req.FileType,
+			URL:          req.URL,
+			Username:     req.Username,
+			Password:     req.Password,
+			DelaySeconds: req.DelaySeconds,
+		},
+	}
+	as.startCwmpTransfer(w, r, transfer, cmd)
+}
+
+// startCwmpTransfer stores a transfer and sends its Download or Upload to
+// the ACS through the controller. The CommandKey correlates the
+// TransferComplete of the device with the transfer, one is generated if the
+// request has none.
+func (as *ApiServer) startCwmpTransfer(w http.ResponseWriter, r *http.Request, transfer *db.CwmpFileTransfer, cmd *acsbus.Command) {
+	if err := as.checkCwmpDevice(transfer.DeviceID); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	release, err := as.acquireDeviceJob(transfer.DeviceID)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	info, err := as.submitCwmpTransfer(r.Context(), transfer, cmd)
+	if err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.releaseWithTasks(release, info.TaskID)
+	httpSendRes(w, info, nil)
+}
+
+// submitCwmpTransfer stores a transfer and sends its command to the
+// controller. The transfer fails if the command could not be sent.
+func (as *ApiServer) submitCwmpTransfer(ctx context.Context, transfer *db.CwmpFileTransfer, cmd *acsbus.Command) (*CwmpTransferInfo, error) {
+	requestID := logging.RequestID(ctx)
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+		ctx = logging.WithRequestID(ctx, requestID)
+	}
+	if transfer.CommandKey == "" {
+		transfer.CommandKey = strings.ToLower(cmd.Method) + ":" + requestID
+	}
+	if err := as.dbH.cwmpIntf.InsertCwmpFileTransfer(transfer); err != nil {
+		return nil, fmt.Errorf("failed to store transfer: %w", err)
+	}
+
+	cmd.DeviceID = transfer.DeviceID
+	cmd.CommandKey = transfer.CommandKey
+	cmd.RequestID = requestID
+	if err := as.CntlrCwmpTransferReq(ctx, cmd); err != nil {
+		update := db.TransferUpdate{Status: db.TransferStatusFailed, CompleteTime: time.Now(), FaultString: err.Error()}
+		if _, uErr := as.dbH.cwmpIntf.UpdateCwmpFileTransfer(transfer.DeviceID, transfer.CommandKey, update); uErr != nil {
+			log.Printf("Error failing transfer %s: %v", transfer.ID, uErr)
+		}
+		return nil, err
+	}
+	logging.FromContext(ctx).Infof("Sent %s %q of %s to device %s", cmd.Method, transfer.CommandKey, transfer.URL, transfer.DeviceID)
+	info := toCwmpTransferInfo(transfer)
+	info.TaskID = as.createCwmpTask(transfer.DeviceID, cmd.Method, requestID)
+	return &info, nil
+}
+
+// getCwmpTransfer returns a file transfer, whose status tells whether the
+// device completed it
+func (as *ApiServer) getCwmpTransfer(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	transfer, err := as.dbH.cwmpIntf.GetCwmpFileTransfer(mux.Vars(r)["id"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, toCwmpTransferInfo(transfer), nil)
+}
+
+// toCwmpTransferInfo converts a stored file transfer for the API
+func toCwmpTransferInfo(t *db.CwmpFileTransfer) CwmpTransferInfo {
+	info := CwmpTransferInfo{
+		Id:             t.ID,
+		DeviceId:       t.DeviceID,
+		CommandKey:     t.CommandKey,
+		FileType:       t.FileType,
+		URL:            t.URL,
+		FileID:         t.FileID,
+		TargetFileName: t.TargetFileName,
+		FileSize:       t.FileSize,
+		Status:         t.Status,
+		CreatedAt:      t.CreatedAt,
+		FaultCode:      t.FaultCode,
+		FaultString:    t.FaultString,
+		CpeState:       t.CpeState,
+		CheckedAt:      t.CheckedAt,
+		Autonomous:     t.Autonomous,
+		Upload:         t.Upload,
+		AnnounceURL:    t.AnnounceURL,
+	}
+	// Zero times mean the transfer has not started or completed yet
+	if !t.StartTime.IsZero() {
+		startTime := t.StartTime
+		info.StartTime = &startTime
+	}
+	if !t.CompleteTime.IsZero() {
+		completeTime := t.CompleteTime
+		info.CompleteTime = &completeTime
+	}
+	if info.StartTime != nil {
+		end := time.Now()
+		if info.CompleteTime != nil {
+			end = *info.CompleteTime
+		}
+		duration := end.Sub(*info.StartTime).Seconds()
+		info.DurationSeconds = &duration
+	}
+	return info
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"github.com/n4-networks/openusp/pkg/pb/cntlrgrpc"
+)
+
+// CwmpCommandSubmission reports a command the controller handed to the ACS,
+// which holds it until the device connects. Its progress is polled through
+// the task TaskID.
+type CwmpCommandSubmission struct {
+	DeviceId     string    `json:"device_id"`
+	Method       string    `json:"method"`
+	Status       string    `json:"status"`
+	Message      string    `json:"message"`
+	CommandKey   string    `json:"command_key,omitempty"`
+	ParameterKey string    `json:"parameter_key,omitempty"`
+	RequestID    string    `json:"request_id,omitempty"`
+	TaskID       string    `json:"task_id,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+func (as *ApiServer) newCwmpCommandSubmission(ctx context.Context, deviceId string, method string, message string) *CwmpCommandSubmission {
+	requestID := logging.RequestID(ctx)
+	return &CwmpCommandSubmission{
+		DeviceId:  deviceId,
+		Method:    method,
+		Status:    "submitted",
+		Message:   message,
+		RequestID: requestID,
+		TaskID:    as.createCwmpTask(deviceId, method, requestID),
+		Timestamp: time.Now(),
+	}
+}
+
+func (as *ApiServer) CntlrCwmpSetParamsReq(ctx context.Context, deviceId string, params []cwmp.ParameterValueStruct, paramKey string) error {
+	if as.grpcH.intf == nil {
+		return errCntlrNotConnected
+	}
+	in := &cntlrgrpc.CwmpSetParamsReqData{DeviceId: deviceId, ParamKey: paramKey}
+	for _, p := range params {
+		in.Params = append(in.Params, &cntlrgrpc.CwmpSetParamsReqData_Param{Name: p.Name, Value: p.Value, Type: p.Type})
+	}
+	logging.FromContext(ctx).Infof("Sending CWMP set params request to Controller, device: %s", deviceId)
+	return cwmpCmdError(as.grpcH.intf.CwmpSetParamsReq(ctx, in))
+}
+
+func (as *ApiServer) CntlrCwmpRebootReq(ctx context.Context, deviceId string, cmdKey string) error {
+	if as.grpcH.intf == nil {
+		return errCntlrNotConnected
+	}
+	logging.FromContext(ctx).Infof("Sending CWMP reboot request to Controller, device: %s", deviceId)
+	return cwmpCmdError(as.grpcH.intf.CwmpRebootReq(ctx, &cntlrgrpc.CwmpRebootReqData{DeviceId: deviceId, CmdKey: cmdKey}))
+}
+
+func (as *ApiServer) CntlrCwmpFactoryResetReq(ctx context.Context, deviceId string) error {
+	if as.grpcH.intf == nil {
+		return errCntlrNotConnected
+	}
+	logging.FromContext(ctx).Infof("Sending CWMP factory reset request to Controller, device: %s", deviceId)
+	return cwmpCmdError(as.grpcH.intf.CwmpFactoryResetReq(ctx, &cntlrgrpc.CwmpFactoryResetReqData{DeviceId: deviceId}))
+}
+
+// CntlrCwmpTransferReq sends the Download or Upload of a transfer command
+func (as *ApiServer) CntlrCwmpTransferReq(ctx context.Context, cmd *acsbus.Command) error {
+	if as.grpcH.intf == nil {
+		return errCntlrNotConnected
+	}
+	t := cmd.Transfer
+	in := &cntlrgrpc.CwmpTransferReqData{
+		DeviceId:       cmd.DeviceID,
+		CmdKey:         cmd.CommandKey,
+		FileType:       t.FileType,
+		Url:            t.URL,
+		FileId:         t.FileID,
+		Username:       t.Username,
+		Password:       t.Password,
+		FileSize:       t.FileSize,
+		TargetFileName: t.TargetFileName,
+		DelaySeconds:   t.DelaySeconds,
+		SuccessUrl:     t.SuccessURL,
+		FailureUrl:     t.FailureURL,
+	}
+	logging.FromContext(ctx).Infof("Sending CWMP %s request to Controller, device: %s", cmd.Method, cmd.DeviceID)
+	if cmd.Method == acsbus.MethodUpload {
+		return cwmpCmdError(as.grpcH.intf.CwmpUploadReq(ctx, in))
+	}
+	return cwmpCmdError(as.grpcH.intf.CwmpDownloadReq(ctx, in))
+}
+
+// cwmpCmdError maps the outcome of a CWMP command sent to the controller
+func cwmpCmdError(res *cntlrgrpc.CwmpCmdResult, err error) error {
+	if err != nil {
+		log.Println("gRPC error: ", err)
+		return errUnavailable("failed to reach the controller: %v", err)
+	}
+	if !res.GetIsSuccess() {
+		return errControllerFailure("%s %s: %s", res.GetMethod(), res.GetDeviceId(), res.GetErrMsg())
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"io/fs"
+	"net/http"
+
+	"github.com/n4-networks/openusp/web"
+)
+
+const DASHBOARD = "/dashboard/"
+
+// setDashboardRoutesHandlers serves the embedded web dashboard, the router
+// middlewares apply so it sits behind the API authentication
+func (as *ApiServer) setDashboardRoutesHandlers() error {
+	files, err := fs.Sub(web.Dashboard, "dashboard")
+	if err != nil {
+		return err
+	}
+	as.rootRouter.Handle("/dashboard", http.RedirectHandler(DASHBOARD, http.StatusMovedPermanently)).Methods("GET")
+	as.rootRouter.PathPrefix(DASHBOARD).Handler(http.StripPrefix(DASHBOARD, http.FileServer(http.FS(files)))).Methods("GET")
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const CWMP_GET_DATAMODEL = "/cwmp/device/{deviceId}/datamodel"
+
+// getCwmpDataModel returns the data model nodes discovered on a device with
+// GetParameterNames. The path query parameter selects a subtree and
+// next_level=true limits the result to its direct children.
+func (as *ApiServer) getCwmpDataModel(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	query := r.URL.Query()
+	nextLevel := false
+	if v := query.Get("next_level"); v != "" {
+		var err error
+		if nextLevel, err = strconv.ParseBool(v); err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid next_level: %s", v))
+			return
+		}
+	}
+	nodes, err := as.dbH.cwmpIntf.GetCwmpDataModel(mux.Vars(r)["deviceId"], query.Get("path"), nextLevel)
+	httpSendRes(w, nodes, err)
+}
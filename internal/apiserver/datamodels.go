@@ -15,7 +15,6 @@
 package apiserver
 
 import (
-	"errors"
 	"log"
 
 	"github.com/n4-networks/openusp/internal/db"
@@ -23,7 +22,7 @@ import (
 
 func (as *ApiServer) getDmObjs(d *uspData) ([]*db.DmObject, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Error: DB interface has not been initilized")
+		return nil, errUnavailable("Error: DB interface has not been initilized")
 	}
 	dmObj, err := as.dbH.uspIntf.GetDmByRegex(d.epId, d.path)
 	if err != nil {
@@ -34,7 +33,7 @@ func (as *ApiServer) getDmObjs(d *uspData) ([]*db.DmObject, error) {
 }
 
 func (as *ApiServer) updateDmObjs(d *uspData) error {
-	if err := as.CntlrGetDatamodelReq(d.epId, d.path); err != nil {
+	if err := as.CntlrGetDatamodelReq(d.ctx, d.epId, d.path); err != nil {
 		log.Println("updateDm error:", err)
 		return err
 	}
@@ -16,7 +16,6 @@ package apiserver
 
 import (
 	"context"
-	"errors"
 	"log"
 
 	"github.com/n4-networks/openusp/internal/db"
@@ -64,7 +63,7 @@ func (as *ApiServer) dbDeleteColl(collName string) error {
 	if collName != "datamodel" && collName != "instances" && collName != "params" &&
 		collName != "cfginstances" && collName != "cfgparams" {
 		log.Println("Invalid db/collection name.", collName)
-		return errors.New("Invalid collection name")
+		return errBadRequest("Invalid collection name")
 	}
 	if err := as.dbH.uspIntf.DeleteCollection(collName); err != nil {
 		log.Printf("Error in deleteing db/collection: %v, err: %v\n", collName, err)
@@ -77,7 +76,7 @@ func (as *ApiServer) dbDeleteColl(collName string) error {
 func (as *ApiServer) dbGetParamsByRegex(agentId string, path string) (map[string]string, error) {
 	//log.Println("Path:", path)
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbParams, err := as.dbH.uspIntf.GetParamsByRegex(agentId, path)
 	if err != nil {
@@ -93,7 +92,7 @@ func (as *ApiServer) dbGetParamsByRegex(agentId string, path string) (map[string
 func (as *ApiServer) dbGetParams(agentId string, path string) (map[string]string, error) {
 	//log.Println("Path:", path)
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbParams, err := as.dbH.uspIntf.GetParams(agentId, path)
 	if err != nil {
@@ -108,7 +107,7 @@ func (as *ApiServer) dbGetParams(agentId string, path string) (map[string]string
 
 func (as *ApiServer) dbGetDmByRegex(agentId string, path string) ([]*DmObject, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDmObjects, err := as.dbH.uspIntf.GetDmByRegex(agentId, path)
 	if err != nil {
@@ -154,7 +153,7 @@ func (as *ApiServer) dbGetDmByRegex(agentId string, path string) ([]*DmObject, e
 
 func (as *ApiServer) dbGetDm(agentId string, path string) (*DmObject, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDmObj, err := as.dbH.uspIntf.GetDm(agentId, path)
 	if err != nil {
@@ -197,7 +196,7 @@ func (as *ApiServer) dbGetDm(agentId string, path string) (*DmObject, error) {
 
 func (as *ApiServer) dbGetInstancesByRegex(agentId string, path string) ([]*Instance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbInstances, err := as.dbH.uspIntf.GetInstancesByRegex(agentId, path)
 	if err != nil {
@@ -215,7 +214,7 @@ func (as *ApiServer) dbGetInstancesByRegex(agentId string, path string) ([]*Inst
 
 func (as *ApiServer) dbGetInstances(agentId string, path string) ([]*Instance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbInstances, err := as.dbH.uspIntf.GetInstances(agentId, path)
 	if err != nil {
@@ -233,7 +232,7 @@ func (as *ApiServer) dbGetInstances(agentId string, path string) ([]*Instance, e
 
 func (as *ApiServer) dbGetInstanceByAlias(agentId string, aliasName string) (*Instance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbInsts, err := as.dbH.uspIntf.GetInstancesByUniqueKeys(agentId, "Alias", aliasName)
 	if err != nil {
@@ -246,7 +245,7 @@ func (as *ApiServer) dbGetInstanceByAlias(agentId string, aliasName string) (*In
 }
 func (as *ApiServer) dbDeleteInstances(agentId string, paths []*string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	for _, path := range paths {
 		log.Println("Affected path:", path)
@@ -259,7 +258,7 @@ func (as *ApiServer) dbDeleteInstances(agentId string, paths []*string) error {
 }
 func (as *ApiServer) dbDeleteInstanceByAlias(agentId string, value string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	return as.dbH.uspIntf.DeleteInstanceByUniqueKey(agentId, "Alias", value)
 }
@@ -271,7 +270,7 @@ type agentInfo struct {
 
 func (as *ApiServer) dbWriteCfgInstance(agent agentInfo, path string, level int, key string, params map[string]string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	inst := &db.CfgInstance{}
 	inst.Dev.ProductClass = agent.dev.productClass
@@ -286,7 +285,7 @@ func (as *ApiServer) dbWriteCfgInstance(agent agentInfo, path string, level int,
 
 func (as *ApiServer) dbGetCfgInstancesByPath(agent agentInfo, path string) ([]*cfgInstance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDevInfo := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -310,7 +309,7 @@ func (as *ApiServer) dbGetCfgInstancesByPath(agent agentInfo, path string) ([]*c
 
 func (as *ApiServer) dbGetCfgInstancesByRegex(agent agentInfo, path string) ([]*cfgInstance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDevInfo := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -335,7 +334,7 @@ func (as *ApiServer) dbGetCfgInstancesByRegex(agent agentInfo, path string) ([]*
 
 func (as *ApiServer) dbGetCfgParams(agent agentInfo, path string) (map[string]string, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDevInfo := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -347,7 +346,7 @@ func (as *ApiServer) dbGetCfgParams(agent agentInfo, path string) (map[string]st
 
 func (as *ApiServer) dbGetCfgParamNodesByRegex(agent agentInfo, path string) ([]*cfgParamNode, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	dbDevInfo := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -369,7 +368,7 @@ func (as *ApiServer) dbGetCfgParamNodesByRegex(agent agentInfo, path string) ([]
 }
 func (as *ApiServer) dbWriteCfgParamNode(agent agentInfo, path string, params map[string]string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	dbNode := &db.CfgParamNode{}
 	dbNode.Dev.ProductClass = agent.dev.productClass
@@ -382,7 +381,7 @@ func (as *ApiServer) dbWriteCfgParamNode(agent agentInfo, path string, params ma
 
 func (as *ApiServer) dbDeleteCfgInstancesByRegex(agent agentInfo, path string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	dbDev := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -398,7 +397,7 @@ func (as *ApiServer) dbDeleteCfgInstancesByRegex(agent agentInfo, path string) e
 
 func (as *ApiServer) dbDeleteCfgParamNodesByRegex(agent agentInfo, path string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	dbDev := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -414,7 +413,7 @@ func (as *ApiServer) dbDeleteCfgParamNodesByRegex(agent agentInfo, path string)
 
 func (as *ApiServer) dbDeleteCfgInstanceByKey(agent agentInfo, path string, key string) error {
 	if as.dbH.uspIntf == nil {
-		return errors.New("Not connected to DB")
+		return errDbNotConnected
 	}
 	dbDev := &db.DevType{
 		ProductClass: agent.dev.productClass,
@@ -430,7 +429,7 @@ func (as *ApiServer) dbDeleteCfgInstanceByKey(agent agentInfo, path string, key
 
 func (as *ApiServer) dbGetAllEndpoints() ([]string, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Not connected to DB")
+		return nil, errDbNotConnected
 	}
 	epIds, err := as.dbH.uspIntf.GetAllEndpoints()
 	if err != nil {
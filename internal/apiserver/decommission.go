@@ -0,0 +1,71 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// notArchived matches the devices which were not archived
+var notArchived = bson.M{"archived_at": bson.M{"$exists": false}}
+
+// deleteCwmpDevice decommissions a device. It is removed with its
+// parameters, sessions, transfers and history, or only archived with
+// archive=true. A device which informs again is registered anew, or
+// restored if it was archived.
+func (as *ApiServer) deleteCwmpDevice(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	deviceId := mux.Vars(r)["deviceId"]
+	var deletion *db.CwmpDeviceDeletion
+	var err error
+	if r.URL.Query().Get("archive") == "true" {
+		deletion, err = as.dbH.cwmpIntf.ArchiveCwmpDevice(deviceId)
+	} else {
+		deletion, err = as.dbH.cwmpIntf.DeleteCwmpDevice(deviceId)
+	}
+	if deletion == nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	log := logging.FromContext(r.Context())
+	if err != nil {
+		// The device is gone, only some of its data is left behind
+		log.Errorf("Error deleting the data of device %s: %v", deviceId, err)
+	}
+	if deletion.Archived {
+		log.Infof("Archived device %s", deviceId)
+	} else {
+		log.Infof("Deleted device %s: %v", deviceId, deletion.Deleted)
+	}
+	httpSendRes(w, deletion, nil)
+}
+
+// withoutArchived narrows a device filter to the devices which were not
+// archived
+func withoutArchived(filter bson.M) bson.M {
+	if len(filter) == 0 {
+		return notArchived
+	}
+	return andFilter(bson.A{filter, notArchived})
+}
@@ -0,0 +1,183 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+const CWMP_EXPORT_DEVICES = "/cwmp/devices/export"
+
+// defaultExportFields are the columns exported when none are selected, the
+// parameter count and the nested geo data are only exported on request
+var defaultExportFields = []string{
+	"device_id", "manufacturer", "oui", "product_class", "serial_number",
+	"software_version", "hardware_version", "last_inform_time", "is_online",
+	"ip_address", "connection_request_url",
+}
+
+// deviceExporter writes the exported devices in one format
+type deviceExporter interface {
+	writeHeader(fields []string) error
+	writeDevice(values map[string]interface{}) error
+	flush() error
+}
+
+// exportCwmpDevices streams the devices matching the filters of the device
+// listing as CSV or NDJSON. The devices are read from a DB cursor, so the
+// inventory is never held in memory.
+func (as *ApiServer) exportCwmpDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	filter, err := cwmpDeviceFilter(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	scopeDeviceFilter(r.Context(), filter)
+	names := defaultExportFields
+	if fieldsStr := r.URL.Query().Get("fields"); fieldsStr != "" {
+		names = strings.Split(fieldsStr, ",")
+	}
+	fields, sources, err := parseDeviceFields(names)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	var contentType string
+	switch format {
+	case "", "csv":
+		format, contentType = "csv", "text/csv; charset=utf-8"
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	default:
+		httpSendRes(w, nil, errBadRequest("invalid format %q, expected csv or ndjson", format))
+		return
+	}
+
+	// The export outlasts the write timeout of the server
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	filename := "cwmp-devices-" + time.Now().UTC().Format("20060102") + "." + format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	bw := bufio.NewWriter(w)
+	var exporter deviceExporter = &ndjsonExporter{w: bw}
+	if format == "csv" {
+		exporter = &csvExporter{w: csv.NewWriter(bw)}
+	}
+	if err := exporter.writeHeader(fields); err != nil {
+		return
+	}
+	count := 0
+	opts := db.DeviceListOptions{Fields: sources}
+	err = as.dbH.cwmpIntf.EachCwmpDevice(r.Context(), filter, opts, func(device *db.CwmpDevice) error {
+		selected, err := selectDeviceFields([]CwmpDeviceInfo{newCwmpDeviceInfo(device)}, fields)
+		if err != nil {
+			return err
+		}
+		count++
+		return exporter.writeDevice(selected[0])
+	})
+	if err == nil {
+		err = exporter.flush()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		// The status is sent already, the client gets a truncated export
+		logging.FromContext(r.Context()).Errorf("Device export aborted after %d devices: %v", count, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Exported %d devices as %s", count, format)
+}
+
+// csvExporter writes a header row and a row per device. Objects such as geo
+// are written as JSON.
+type csvExporter struct {
+	w      *csv.Writer
+	fields []string
+}
+
+func (e *csvExporter) writeHeader(fields []string) error {
+	e.fields = fields
+	return e.w.Write(fields)
+}
+
+func (e *csvExporter) writeDevice(values map[string]interface{}) error {
+	row := make([]string, len(e.fields))
+	for i, name := range e.fields {
+		row[i] = csvValue(values[name])
+	}
+	return e.w.Write(row)
+}
+
+func (e *csvExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvValue formats a cell. Strings starting like a formula are prefixed
+// with a quote, so that device-reported values are not evaluated by a
+// spreadsheet.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// ndjsonExporter writes a JSON object per line and device
+type ndjsonExporter struct {
+	w io.Writer
+}
+
+func (e *ndjsonExporter) writeHeader(fields []string) error {
+	return nil
+}
+
+func (e *ndjsonExporter) writeDevice(values map[string]interface{}) error {
+	return json.NewEncoder(e.w).Encode(values)
+}
+
+func (e *ndjsonExporter) flush() error {
+	return nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Page sizes of the device listing
+const (
+	defaultDeviceListLimit = 100
+	maxDeviceListLimit     = 1000
+)
+
+// CwmpDeviceList is a page of the device listing. Devices holds
+// CwmpDeviceInfo values, or only the selected fields of each device.
+type CwmpDeviceList struct {
+	Devices interface{} `json:"devices"`
+	Total   int64       `json:"total"`
+	Offset  int64       `json:"offset"`
+	Limit   int64       `json:"limit"`
+}
+
+// deviceListFields maps the fields of CwmpDeviceInfo to the device document
+// fields they are built from
+var deviceListFields = map[string][]string{
+	"device_id":              {"_id"},
+	"manufacturer":           {"manufacturer"},
+	"oui":                    {"oui"},
+	"product_class":          {"product_class"},
+	"serial_number":          {"serial_number"},
+	"software_version":       {"software_version"},
+	"hardware_version":       {"hardware_version"},
+	"last_inform_time":       {"last_inform"},
+	"is_online":              {"last_inform"},
+	"parameter_count":        {"parameters"},
+	"connection_request_url": {"connection_request_url"},
+	"ip_address":             {"ip_address"},
+	"geo":                    {"geo"},
+	"tags":                   {"tags"},
+	"tenant":                 {"tenant"},
+}
+
+// deviceSortFields maps the fields the device listing sorts by to the
+// device document fields, addresses sort by their numeric key
+var deviceSortFields = map[string]string{
+	"device_id":        "_id",
+	"manufacturer":     "manufacturer",
+	"oui":              "oui",
+	"product_class":    "product_class",
+	"serial_number":    "serial_number",
+	"software_version": "software_version",
+	"hardware_version": "hardware_version",
+	"last_inform_time": "last_inform",
+	"ip_address":       "ip_key",
+}
+
+// deviceListOptions parses the limit, offset, sort_by, order and fields
+// query parameters of the device listing, and returns the selected fields
+func deviceListOptions(r *http.Request) (db.DeviceListOptions, []string, error) {
+	query := r.URL.Query()
+	opts := db.DeviceListOptions{}
+
+	limit, err := queryLimit(r, defaultDeviceListLimit)
+	if err != nil {
+		return opts, nil, err
+	}
+	if limit > maxDeviceListLimit {
+		return opts, nil, errBadRequest("limit must not exceed %d", maxDeviceListLimit)
+	}
+	opts.Limit = limit
+
+	if opts.Offset, err = queryOffset(r); err != nil {
+		return opts, nil, err
+	}
+
+	if sortBy := query.Get("sort_by"); sortBy != "" {
+		field, ok := deviceSortFields[sortBy]
+		if !ok {
+			return opts, nil, errBadRequest("cannot sort by %s", sortBy)
+		}
+		opts.SortBy = field
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, nil, errBadRequest("invalid order: %s", order)
+	}
+
+	var fields []string
+	if fieldsStr := query.Get("fields"); fieldsStr != "" {
+		if fields, opts.Fields, err = parseDeviceFields(strings.Split(fieldsStr, ",")); err != nil {
+			return opts, nil, err
+		}
+	}
+	return opts, fields, nil
+}
+
+// parseDeviceFields returns the selected fields and the device document
+// fields they are built from
+func parseDeviceFields(names []string) ([]string, []string, error) {
+	var fields, sources []string
+	projected := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		docFields, ok := deviceListFields[name]
+		if !ok {
+			return nil, nil, errBadRequest("unknown field: %s", name)
+		}
+		fields = append(fields, name)
+		for _, source := range docFields {
+			if !projected[source] {
+				projected[source] = true
+				sources = append(sources, source)
+			}
+		}
+	}
+	return fields, sources, nil
+}
+
+// selectDeviceFields keeps the selected fields of each device
+func selectDeviceFields(devices []CwmpDeviceInfo, fields []string) ([]map[string]interface{}, error) {
+	selected := make([]map[string]interface{}, 0, len(devices))
+	for _, device := range devices {
+		data, err := json.Marshal(device)
+		if err != nil {
+			return nil, err
+		}
+		all := map[string]interface{}{}
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		values := make(map[string]interface{}, len(fields))
+		for _, name := range fields {
+			if value, ok := all[name]; ok {
+				values[name] = value
+			}
+		}
+		selected = append(selected, values)
+	}
+	return selected, nil
+}
+
+// newCwmpDeviceInfo converts a device to its API response format
+func newCwmpDeviceInfo(dbDevice *db.CwmpDevice) CwmpDeviceInfo {
+	return CwmpDeviceInfo{
+		DeviceId:        dbDevice.ID,
+		Manufacturer:    dbDevice.Manufacturer,
+		OUI:             dbDevice.OUI,
+		ProductClass:    dbDevice.ProductClass,
+		SerialNumber:    dbDevice.SerialNumber,
+		SoftwareVersion: dbDevice.SoftwareVersion,
+		HardwareVersion: dbDevice.HardwareVersion,
+		LastInformTime:  dbDevice.LastInform.Format(time.RFC3339),
+		// Determine if device is online (last inform within 5 minutes)
+		IsOnline:             time.Since(dbDevice.LastInform) <= 5*time.Minute,
+		ParameterCount:       len(dbDevice.Parameters),
+		ConnectionRequestURL: dbDevice.ConnectionRequestURL,
+		IPAddress:            dbDevice.IPAddress,
+		Geo:                  dbDevice.Geo,
+		Tags:                 dbDevice.Tags,
+		ArchivedAt:           dbDevice.ArchivedAt,
+		Tenant:               dbDevice.Tenant,
+	}
+}
+
+// sendCwmpDeviceList answers with the page of the devices matching filter
+// selected by the query parameters
+func (as *ApiServer) sendCwmpDeviceList(w http.ResponseWriter, r *http.Request, filter bson.M) {
+	opts, fields, err := deviceListOptions(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	scopeDeviceFilter(r.Context(), filter)
+
+	// Get a page of devices from database
+	dbDevices, total, err := as.dbH.cwmpIntf.ListCwmpDevices(filter, opts)
+	if err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to retrieve devices: %w", err))
+		return
+	}
+
+	// Convert to API response format
+	devices := []CwmpDeviceInfo{}
+	for i := range dbDevices {
+		devices = append(devices, newCwmpDeviceInfo(&dbDevices[i]))
+	}
+
+	list := &CwmpDeviceList{Devices: devices, Total: total, Offset: opts.Offset, Limit: opts.Limit}
+	if len(fields) > 0 {
+		if list.Devices, err = selectDeviceFields(devices, fields); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+	httpSendRes(w, list, nil)
+}
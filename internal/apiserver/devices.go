@@ -0,0 +1,228 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const DEVICES = "/devices"
+
+// Protocols of the unified device listing
+const (
+	DeviceProtocolUSP  = "usp"
+	DeviceProtocolCWMP = "cwmp"
+)
+
+// Device is a USP agent or a CWMP device in the unified device listing
+type Device struct {
+	ID              string     `json:"id"`
+	Protocol        string     `json:"protocol"`
+	Manufacturer    string     `json:"manufacturer,omitempty"`
+	OUI             string     `json:"oui,omitempty"`
+	ProductClass    string     `json:"product_class,omitempty"`
+	SerialNumber    string     `json:"serial_number,omitempty"`
+	ModelName       string     `json:"model_name,omitempty"`
+	SoftwareVersion string     `json:"software_version,omitempty"`
+	HardwareVersion string     `json:"hardware_version,omitempty"`
+	Online          bool       `json:"online"`
+	LastSeen        *time.Time `json:"last_seen,omitempty"` // last Inform of a CWMP device
+	Tenant          string     `json:"tenant,omitempty"`
+}
+
+// DeviceList is a page of the unified device listing, the USP agents come
+// first
+type DeviceList struct {
+	Devices []Device `json:"devices"`
+	Total   int64    `json:"total"`
+	Offset  int64    `json:"offset"`
+	Limit   int64    `json:"limit"`
+}
+
+// uspDeviceInfoParams are the agent parameters the USP devices are built from
+var uspDeviceInfoParams = map[string]func(d *Device, value string){
+	"Device.DeviceInfo.Manufacturer":    func(d *Device, v string) { d.Manufacturer = v },
+	"Device.DeviceInfo.ManufacturerOUI": func(d *Device, v string) { d.OUI = v },
+	"Device.DeviceInfo.ProductClass":    func(d *Device, v string) { d.ProductClass = v },
+	"Device.DeviceInfo.SerialNumber":    func(d *Device, v string) { d.SerialNumber = v },
+	"Device.DeviceInfo.ModelName":       func(d *Device, v string) { d.ModelName = v },
+	"Device.DeviceInfo.SoftwareVersion": func(d *Device, v string) { d.SoftwareVersion = v },
+	"Device.DeviceInfo.HardwareVersion": func(d *Device, v string) { d.HardwareVersion = v },
+}
+
+func (as *ApiServer) setDeviceRoutesHandlers() {
+	as.router.HandleFunc(DEVICES, as.getDevices).Methods("GET")
+}
+
+// getDevices lists the USP agents and the CWMP devices with their identity,
+// software version and online state. protocol=usp|cwmp lists one of them,
+// online_only=true the online devices only.
+func (as *ApiServer) getDevices(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	protocol := query.Get("protocol")
+	if protocol != "" && protocol != DeviceProtocolUSP && protocol != DeviceProtocolCWMP {
+		httpSendRes(w, nil, errBadRequest("invalid protocol: %s", protocol))
+		return
+	}
+	onlineOnly := query.Get("online_only") == "true"
+	limit, err := queryLimit(r, defaultDeviceListLimit)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if limit > maxDeviceListLimit {
+		httpSendRes(w, nil, errBadRequest("limit must not exceed %d", maxDeviceListLimit))
+		return
+	}
+	offset, err := queryOffset(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	// USP agents are not assigned to tenants
+	uspDevices := []Device{}
+	if protocol != DeviceProtocolCWMP && requestTenant(r.Context()) == "" {
+		if uspDevices, err = as.uspDevices(r, onlineOnly); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+	list := &DeviceList{Devices: []Device{}, Total: int64(len(uspDevices)), Offset: offset, Limit: limit}
+	if offset < int64(len(uspDevices)) {
+		list.Devices = append(list.Devices, uspDevices[offset:min(offset+limit, int64(len(uspDevices)))]...)
+	}
+
+	if protocol != DeviceProtocolUSP {
+		devices, total, err := as.cwmpDevices(r, onlineOnly,
+			max(offset-int64(len(uspDevices)), 0), limit-int64(len(list.Devices)))
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		list.Devices = append(list.Devices, devices...)
+		list.Total += total
+	}
+	httpSendRes(w, list, nil)
+}
+
+// uspDevices returns the USP agents known to the DB sorted by ID, online
+// while connected to the controller
+func (as *ApiServer) uspDevices(r *http.Request, onlineOnly bool) ([]Device, error) {
+	if as.dbH.uspIntf == nil {
+		return nil, errDbNotConnected
+	}
+	paths := make([]string, 0, len(uspDeviceInfoParams))
+	for path := range uspDeviceInfoParams {
+		paths = append(paths, path)
+	}
+	params, err := as.dbH.uspIntf.GetParamsByPaths(paths)
+	if err != nil {
+		return nil, err
+	}
+	online := map[string]bool{}
+	if agentIds, err := as.CntlrGetAgents(r.Context()); err == nil {
+		for _, id := range agentIds {
+			online[id] = true
+		}
+	} else {
+		logging.FromContext(r.Context()).Warnf("Could not get the connected USP agents, listing them offline: %v", err)
+	}
+
+	byId := map[string]*Device{}
+	for _, param := range params {
+		d, ok := byId[param.EndpointId]
+		if !ok {
+			d = &Device{ID: param.EndpointId, Protocol: DeviceProtocolUSP, Online: online[param.EndpointId]}
+			byId[param.EndpointId] = d
+		}
+		uspDeviceInfoParams[param.Path](d, param.Value)
+	}
+	devices := []Device{}
+	for _, d := range byId {
+		if !onlineOnly || d.Online {
+			devices = append(devices, *d)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices, nil
+}
+
+// cwmpDevices returns a page of the CWMP devices which are not archived
+// sorted by ID, and their number
+func (as *ApiServer) cwmpDevices(r *http.Request, onlineOnly bool, offset int64, limit int64) ([]Device, int64, error) {
+	if as.dbH.cwmpIntf == nil {
+		return nil, 0, errCwmpDbNotConnected
+	}
+	filter := bson.M{"archived_at": bson.M{"$exists": false}}
+	if onlineOnly {
+		filter["last_inform"] = bson.M{"$gte": time.Now().Add(-deviceOnlineWindow)}
+	}
+	scopeDeviceFilter(r.Context(), filter)
+	if limit <= 0 {
+		// The page is full of USP agents already
+		total, err := as.dbH.cwmpIntf.CountCwmpDevices(filter)
+		return nil, total, err
+	}
+
+	opts := db.DeviceListOptions{Offset: offset, Limit: limit, Fields: []string{"_id", "manufacturer", "oui",
+		"product_class", "serial_number", "model_name", "software_version", "hardware_version", "last_inform", "tenant"}}
+	dbDevices, total, err := as.dbH.cwmpIntf.ListCwmpDevices(filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	devices := make([]Device, 0, len(dbDevices))
+	for i := range dbDevices {
+		d := &dbDevices[i]
+		device := Device{
+			ID:              d.ID,
+			Protocol:        DeviceProtocolCWMP,
+			Manufacturer:    d.Manufacturer,
+			OUI:             d.OUI,
+			ProductClass:    d.ProductClass,
+			SerialNumber:    d.SerialNumber,
+			ModelName:       d.ModelName,
+			SoftwareVersion: d.SoftwareVersion,
+			HardwareVersion: d.HardwareVersion,
+			Online:          time.Since(d.LastInform) <= deviceOnlineWindow,
+			Tenant:          d.Tenant,
+		}
+		if !d.LastInform.IsZero() {
+			device.LastSeen = &d.LastInform
+		}
+		devices = append(devices, device)
+	}
+	return devices, total, nil
+}
+
+// queryOffset parses the offset query parameter, zero when it is not set
+func queryOffset(r *http.Request) (int64, error) {
+	offsetStr := r.URL.Query().Get("offset")
+	if offsetStr == "" {
+		return 0, nil
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errBadRequest("invalid offset: %s", offsetStr)
+	}
+	return offset, nil
+}
@@ -0,0 +1,328 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const CWMP_SEARCH_DEVICES = "/cwmp/devices/search"
+
+// CwmpDeviceSearch is a device search. Each condition which is set narrows
+// the search, a search without conditions matches every device.
+type CwmpDeviceSearch struct {
+	DeviceIds       []string                 `json:"device_ids,omitempty"`
+	Manufacturer    string                   `json:"manufacturer,omitempty"`
+	OUI             string                   `json:"oui,omitempty"`
+	ProductClass    string                   `json:"product_class,omitempty"`
+	ModelName       string                   `json:"model_name,omitempty"`
+	HardwareVersion string                   `json:"hardware_version,omitempty"`
+	DataModel       string                   `json:"data_model,omitempty"`
+	Profile         string                   `json:"profile,omitempty"`
+	Tags            *CwmpTagCondition        `json:"tags,omitempty"`
+	SoftwareVersion *CwmpVersionCondition    `json:"software_version,omitempty"`
+	LastInform      *CwmpInformWindow        `json:"last_inform,omitempty"`
+	Subnets         []string                 `json:"subnets,omitempty"`
+	Parameters      []CwmpParameterCondition `json:"parameters,omitempty"`
+}
+
+// CwmpTagCondition matches devices carrying all, any or none of the tags
+type CwmpTagCondition struct {
+	All  []string `json:"all,omitempty"`
+	Any  []string `json:"any,omitempty"`
+	None []string `json:"none,omitempty"`
+}
+
+// CwmpVersionCondition matches software versions against a pattern such as
+// "1.5.x" or "1.5.*", and against an inclusive range
+type CwmpVersionCondition struct {
+	Match string `json:"match,omitempty"`
+	Min   string `json:"min,omitempty"`
+	Max   string `json:"max,omitempty"`
+}
+
+// CwmpInformWindow matches the time of the last Inform of devices. OlderThan
+// and Within are durations relative to now, such as "24h".
+type CwmpInformWindow struct {
+	After     *time.Time `json:"after,omitempty"`
+	Before    *time.Time `json:"before,omitempty"`
+	OlderThan string     `json:"older_than,omitempty"`
+	Within    string     `json:"within,omitempty"`
+}
+
+// CwmpParameterCondition matches devices by the stored value of a parameter
+type CwmpParameterCondition struct {
+	Path  string `json:"path"`
+	Op    string `json:"op,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// searchCwmpDevices answers with a page of the devices matching the search
+// of the request body, paged like the device listing
+func (as *ApiServer) searchCwmpDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var search CwmpDeviceSearch
+	if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	filter, err := as.compileDeviceSearch(&search)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.sendCwmpDeviceList(w, r, withoutArchived(filter))
+}
+
+// compileDeviceSearch converts a device search into a device filter.
+// Parameter conditions and version ranges are resolved first into the
+// device IDs and versions they match.
+func (as *ApiServer) compileDeviceSearch(s *CwmpDeviceSearch) (bson.M, error) {
+	conds := bson.A{}
+	if len(s.DeviceIds) > 0 {
+		conds = append(conds, bson.M{"_id": bson.M{"$in": s.DeviceIds}})
+	}
+	for field, value := range map[string]string{
+		"manufacturer":     s.Manufacturer,
+		"oui":              s.OUI,
+		"product_class":    s.ProductClass,
+		"model_name":       s.ModelName,
+		"hardware_version": s.HardwareVersion,
+		"profile":          s.Profile,
+	} {
+		if value != "" {
+			conds = append(conds, bson.M{field: bson.M{"$regex": "^" + regexp.QuoteMeta(value) + "$", "$options": "i"}})
+		}
+	}
+	if s.DataModel != "" {
+		conds = append(conds, bson.M{"data_model": strings.ToUpper(s.DataModel)})
+	}
+
+	if t := s.Tags; t != nil {
+		if len(t.All) > 0 {
+			conds = append(conds, bson.M{"tags": bson.M{"$all": t.All}})
+		}
+		if len(t.Any) > 0 {
+			conds = append(conds, bson.M{"tags": bson.M{"$in": t.Any}})
+		}
+		if len(t.None) > 0 {
+			conds = append(conds, bson.M{"tags": bson.M{"$nin": t.None}})
+		}
+	}
+
+	if w := s.LastInform; w != nil {
+		cond, err := informWindowFilter(w)
+		if err != nil {
+			return nil, err
+		}
+		if len(cond) > 0 {
+			conds = append(conds, bson.M{"last_inform": cond})
+		}
+	}
+
+	if len(s.Subnets) > 0 {
+		subnets := bson.A{}
+		for _, cidr := range s.Subnets {
+			ipFilter, err := db.IPCidrFilter(cidr)
+			if err != nil {
+				return nil, errBadRequest("invalid subnet %s: %w", cidr, err)
+			}
+			subnets = append(subnets, bson.M{"ip_key": ipFilter})
+		}
+		conds = append(conds, bson.M{"$or": subnets})
+	}
+
+	for _, p := range s.Parameters {
+		paramFilter, err := parameterConditionFilter(p)
+		if err != nil {
+			return nil, err
+		}
+		ids, err := as.dbH.cwmpIntf.CwmpParameterDeviceIDs(paramFilter)
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, bson.M{"_id": bson.M{"$in": ids}})
+	}
+
+	// Versions are not ordered by the database, the versions of the devices
+	// matching the other conditions are compared here
+	if v := s.SoftwareVersion; v != nil && (v.Match != "" || v.Min != "" || v.Max != "") {
+		pattern := versionPattern(v.Match)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errBadRequest("invalid software version pattern: %s", v.Match)
+		}
+		versions, err := as.dbH.cwmpIntf.DistinctCwmpDeviceValues("software_version", andFilter(conds))
+		if err != nil {
+			return nil, err
+		}
+		matched := []string{}
+		for _, version := range versions {
+			if ok, _ := path.Match(pattern, version); !ok {
+				continue
+			}
+			if v.Min != "" && compareVersions(version, v.Min) < 0 {
+				continue
+			}
+			if v.Max != "" && compareVersions(version, v.Max) > 0 {
+				continue
+			}
+			matched = append(matched, version)
+		}
+		conds = append(conds, bson.M{"software_version": bson.M{"$in": matched}})
+	}
+	return andFilter(conds), nil
+}
+
+// andFilter combines filter conditions
+func andFilter(conds bson.A) bson.M {
+	if len(conds) == 0 {
+		return bson.M{}
+	}
+	return bson.M{"$and": conds}
+}
+
+// informWindowFilter converts an Inform window into a condition on the time
+// of the last Inform
+func informWindowFilter(w *CwmpInformWindow) (bson.M, error) {
+	cond := bson.M{}
+	before, after := w.Before, w.After
+	if w.OlderThan != "" {
+		d, err := time.ParseDuration(w.OlderThan)
+		if err != nil {
+			return nil, errBadRequest("invalid older_than: %s", w.OlderThan)
+		}
+		t := time.Now().Add(-d)
+		if before == nil || t.Before(*before) {
+			before = &t
+		}
+	}
+	if w.Within != "" {
+		d, err := time.ParseDuration(w.Within)
+		if err != nil {
+			return nil, errBadRequest("invalid within: %s", w.Within)
+		}
+		t := time.Now().Add(-d)
+		if after == nil || t.After(*after) {
+			after = &t
+		}
+	}
+	if before != nil {
+		cond["$lt"] = *before
+	}
+	if after != nil {
+		cond["$gte"] = *after
+	}
+	return cond, nil
+}
+
+// parameterConditionFilter converts a parameter condition into a filter on
+// the parameter collection. Ordering operators compare numerically when the
+// value is a number.
+func parameterConditionFilter(p CwmpParameterCondition) (bson.M, error) {
+	if p.Path == "" {
+		return nil, errBadRequest("parameter condition must have a path")
+	}
+	filter := bson.M{"path": p.Path}
+	switch p.Op {
+	case "", "eq":
+		filter["value"] = p.Value
+	case "ne":
+		filter["value"] = bson.M{"$ne": p.Value}
+	case "contains":
+		filter["value"] = bson.M{"$regex": regexp.QuoteMeta(p.Value), "$options": "i"}
+	case "regex":
+		if _, err := regexp.Compile(p.Value); err != nil {
+			return nil, errBadRequest("invalid regex for %s: %w", p.Path, err)
+		}
+		filter["value"] = bson.M{"$regex": p.Value}
+	case "exists":
+	case "gt", "gte", "lt", "lte":
+		op := "$" + p.Op
+		if n, err := strconv.ParseFloat(p.Value, 64); err == nil {
+			value := bson.M{"$convert": bson.M{"input": "$value", "to": "double", "onError": nil, "onNull": nil}}
+			filter["$expr"] = bson.M{"$and": bson.A{
+				bson.M{"$ne": bson.A{value, nil}},
+				bson.M{op: bson.A{value, n}},
+			}}
+		} else {
+			filter["value"] = bson.M{op: p.Value}
+		}
+	default:
+		return nil, errBadRequest("unknown operator %s for %s", p.Op, p.Path)
+	}
+	return filter, nil
+}
+
+// versionPattern converts a version pattern such as "1.5.x" into a shell
+// pattern, an empty pattern matches every version
+func versionPattern(match string) string {
+	if match == "" {
+		return "*"
+	}
+	segments := strings.Split(match, ".")
+	for i, segment := range segments {
+		if segment == "x" || segment == "X" {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// compareVersions compares two versions by their numeric segments, such as
+// "1.5.10" and "1.5.9". Segments which are not numbers compare as strings.
+func compareVersions(a string, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	}
+	as, bs := split(a), split(b)
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		if i >= len(as) {
+			return -1
+		}
+		if i >= len(bs) {
+			return 1
+		}
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,305 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	CWMP_DIAGNOSTICS        = "/cwmp/device/{deviceId}/diagnostics/{test:ping|traceroute|download|upload}"
+	CWMP_DIAGNOSTICS_RESULT = "/cwmp/device/{deviceId}/diagnostics/{test:ping|traceroute|download|upload}/{id}"
+)
+
+// diagnosticsPendingTimeout is how long a requested test blocks a new test
+// on the same device. Devices which never report 8 DIAGNOSTICS COMPLETE do
+// not block it forever.
+const diagnosticsPendingTimeout = 10 * time.Minute
+
+// diagnosticsTest describes a TR-143 test of the diagnostics API
+type diagnosticsTest struct {
+	diagType string
+	// tr098Object is the result object on InternetGatewayDevice. devices
+	tr098Object string
+	// target is the parameter holding the host or URL to test against
+	target string
+	byURL  bool
+}
+
+var diagnosticsTests = map[string]diagnosticsTest{
+	"ping":       {diagType: "IPPing", tr098Object: "InternetGatewayDevice.IPPingDiagnostics.", target: "Host"},
+	"traceroute": {diagType: "TraceRoute", tr098Object: "InternetGatewayDevice.TraceRouteDiagnostics.", target: "Host"},
+	"download":   {diagType: "DownloadDiagnostics", tr098Object: "InternetGatewayDevice.DownloadDiagnostics.", target: "DownloadURL", byURL: true},
+	"upload":     {diagType: "UploadDiagnostics", tr098Object: "InternetGatewayDevice.UploadDiagnostics.", target: "UploadURL", byURL: true},
+}
+
+// CwmpDiagnosticsRequest holds the settings of a diagnostics test. Settings
+// a test does not support are ignored, unset ones keep the device default.
+type CwmpDiagnosticsRequest struct {
+	Host           string `json:"host,omitempty"`
+	URL            string `json:"url,omitempty"`
+	Interface      string `json:"interface,omitempty"`
+	Repetitions    uint32 `json:"repetitions,omitempty"`
+	Tries          uint32 `json:"tries,omitempty"`
+	TimeoutMs      uint32 `json:"timeout_ms,omitempty"`
+	DataBlockSize  uint32 `json:"data_block_size,omitempty"`
+	MaxHops        uint32 `json:"max_hops,omitempty"`
+	DSCP           uint32 `json:"dscp,omitempty"`
+	Connections    uint32 `json:"connections,omitempty"`
+	TestFileLength uint32 `json:"test_file_length,omitempty"`
+}
+
+// CwmpDiagnosticsResult is a diagnostics test with its results, keyed by
+// their name in the diagnostics object
+type CwmpDiagnosticsResult struct {
+	ID          string            `json:"id"`
+	DeviceId    string            `json:"device_id"`
+	Test        string            `json:"test"`
+	Status      string            `json:"status"`
+	ObjectPath  string            `json:"object_path"`
+	Parameters  map[string]string `json:"parameters,omitempty"`
+	Results     map[string]string `json:"results,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+	RequestID   string            `json:"request_id,omitempty"`
+	TaskID      string            `json:"task_id,omitempty"`
+}
+
+func (as *ApiServer) setCwmpDiagnosticsRoutesHandlers() {
+	as.router.HandleFunc(CWMP_DIAGNOSTICS, as.startCwmpDiagnostics).Methods("POST")
+	as.router.HandleFunc(CWMP_DIAGNOSTICS, as.getLastCwmpDiagnostics).Methods("GET")
+	as.router.HandleFunc(CWMP_DIAGNOSTICS_RESULT, as.getCwmpDiagnostics).Methods("GET")
+}
+
+// startCwmpDiagnostics sets the test settings and DiagnosticsState
+// Requested on the diagnostics object of the device. The ACS collects the
+// results when the device informs 8 DIAGNOSTICS COMPLETE.
+func (as *ApiServer) startCwmpDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	vars := mux.Vars(r)
+	deviceId := vars["deviceId"]
+	test := diagnosticsTests[vars["test"]]
+
+	var req CwmpDiagnosticsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	settings, err := test.settings(&req)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	pending, err := as.dbH.cwmpIntf.GetPendingCwmpDiagnostic(deviceId)
+	if err == nil && time.Since(pending.CreatedAt) < diagnosticsPendingTimeout {
+		httpSendRes(w, nil, errConflict("diagnostics %s of device %s is still running", pending.ID, deviceId))
+		return
+	} else if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	job := &db.CwmpDiagnostic{
+		DeviceID:       deviceId,
+		DiagnosticType: test.diagType,
+		ObjectPath:     test.objectPath(device),
+		Parameters:     settings,
+	}
+	release, err := as.acquireDeviceJob(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.dbH.cwmpIntf.InsertCwmpDiagnostic(job); err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	ctx := r.Context()
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	}
+	if err := as.CntlrCwmpSetParamsReq(ctx, deviceId, diagnosticsParams(job), "diag:"+job.ID); err != nil {
+		if cErr := as.dbH.cwmpIntf.CompleteCwmpDiagnostic(job.ID, db.DiagnosticStatusError, nil); cErr != nil {
+			logging.FromContext(ctx).Errorf("Error closing diagnostics %s of device %s: %v", job.ID, deviceId, cErr)
+		}
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	submission := as.newCwmpCommandSubmission(ctx, deviceId, acsbus.MethodSetParameterValues, "Diagnostics "+vars["test"]+" requested")
+	as.releaseWithTasks(release, submission.TaskID)
+	result := toCwmpDiagnosticsResult(job)
+	result.RequestID = submission.RequestID
+	result.TaskID = submission.TaskID
+	httpSendRes(w, result, nil)
+}
+
+// getLastCwmpDiagnostics returns the latest test of a type run on a device
+func (as *ApiServer) getLastCwmpDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	vars := mux.Vars(r)
+	filter := bson.M{"device_id": vars["deviceId"], "diagnostic_type": diagnosticsTests[vars["test"]].diagType}
+	jobs, err := as.dbH.cwmpIntf.GetCwmpDiagnostics(filter, 1)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if len(jobs) == 0 {
+		httpSendRes(w, nil, errNotFound("no %s diagnostics run on device %s", vars["test"], vars["deviceId"]))
+		return
+	}
+	httpSendRes(w, toCwmpDiagnosticsResult(&jobs[0]), nil)
+}
+
+// getCwmpDiagnostics returns a diagnostics test of a device
+func (as *ApiServer) getCwmpDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	vars := mux.Vars(r)
+	job, err := as.dbH.cwmpIntf.GetCwmpDiagnostic(vars["id"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if job.DeviceID != vars["deviceId"] || job.DiagnosticType != diagnosticsTests[vars["test"]].diagType {
+		httpSendRes(w, nil, errNotFound("%s diagnostics %s not found for device %s", vars["test"], vars["id"], vars["deviceId"]))
+		return
+	}
+	httpSendRes(w, toCwmpDiagnosticsResult(job), nil)
+}
+
+// settings maps the request to the parameters of the diagnostics object
+func (t diagnosticsTest) settings(req *CwmpDiagnosticsRequest) (map[string]string, error) {
+	settings := map[string]string{}
+	if t.byURL {
+		if req.URL == "" {
+			return nil, errBadRequest("url is required")
+		}
+		settings[t.target] = req.URL
+	} else {
+		if req.Host == "" {
+			return nil, errBadRequest("host is required")
+		}
+		settings[t.target] = req.Host
+	}
+	if req.Interface != "" {
+		settings["Interface"] = req.Interface
+	}
+
+	var numbers map[string]uint32
+	switch t.diagType {
+	case "IPPing":
+		numbers = map[string]uint32{"NumberOfRepetitions": req.Repetitions, "Timeout": req.TimeoutMs, "DataBlockSize": req.DataBlockSize, "DSCP": req.DSCP}
+	case "TraceRoute":
+		numbers = map[string]uint32{"NumberOfTries": req.Tries, "Timeout": req.TimeoutMs, "DataBlockSize": req.DataBlockSize, "MaxHopCount": req.MaxHops, "DSCP": req.DSCP}
+	case "DownloadDiagnostics":
+		numbers = map[string]uint32{"NumberOfConnections": req.Connections, "DSCP": req.DSCP}
+	case "UploadDiagnostics":
+		numbers = map[string]uint32{"NumberOfConnections": req.Connections, "TestFileLength": req.TestFileLength, "DSCP": req.DSCP}
+	}
+	for name, value := range numbers {
+		if value > 0 {
+			settings[name] = strconv.FormatUint(uint64(value), 10)
+		}
+	}
+	return settings, nil
+}
+
+// objectPath returns the diagnostics object of the data model of the device
+func (t diagnosticsTest) objectPath(device *db.CwmpDevice) string {
+	if device.DataModelRoot == "InternetGatewayDevice." {
+		return t.tr098Object
+	}
+	return cwmp.DiagnosticsObjects[t.diagType]
+}
+
+// diagnosticsParams returns the SetParameterValues parameters starting a
+// diagnostics job, DiagnosticsState last
+func diagnosticsParams(job *db.CwmpDiagnostic) []cwmp.ParameterValueStruct {
+	params := make([]cwmp.ParameterValueStruct, 0, len(job.Parameters)+1)
+	for name, value := range job.Parameters {
+		paramType := "xsd:unsignedInt"
+		switch name {
+		case "Host", "DownloadURL", "UploadURL", "Interface":
+			paramType = "xsd:string"
+		}
+		params = append(params, cwmp.ParameterValueStruct{Name: job.ObjectPath + name, Value: value, Type: paramType})
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Name < params[j].Name })
+	return append(params, cwmp.ParameterValueStruct{Name: job.ObjectPath + "DiagnosticsState", Value: "Requested", Type: "xsd:string"})
+}
+
+// toCwmpDiagnosticsResult converts a stored diagnostics job for the API
+func toCwmpDiagnosticsResult(job *db.CwmpDiagnostic) *CwmpDiagnosticsResult {
+	result := &CwmpDiagnosticsResult{
+		ID:         job.ID,
+		DeviceId:   job.DeviceID,
+		Status:     job.Status,
+		ObjectPath: job.ObjectPath,
+		Parameters: job.Parameters,
+		CreatedAt:  job.CreatedAt,
+	}
+	for name, test := range diagnosticsTests {
+		if test.diagType == job.DiagnosticType {
+			result.Test = name
+		}
+	}
+	if len(job.Results) > 0 {
+		result.Results = make(map[string]string, len(job.Results))
+		for path, value := range job.Results {
+			result.Results[strings.TrimPrefix(path, job.ObjectPath)] = value
+		}
+	}
+	if !job.CompletedAt.IsZero() {
+		completedAt := job.CompletedAt
+		result.CompletedAt = &completedAt
+	}
+	return result
+}
@@ -0,0 +1,447 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const WS_EVENTS = "/ws/events"
+
+// Types of the events of the event stream. The lifecycle events the ACS
+// records, such as device.registered, are streamed with their event code as
+// type.
+const (
+	StreamEventOnline           = "device.online"
+	StreamEventOffline          = "device.offline"
+	StreamEventInform           = "inform"
+	StreamEventParameterChanged = "parameter.changed"
+	StreamEventTransferComplete = "transfer.complete"
+	StreamEventTaskFinished     = "task.finished"
+	StreamEventFirmwareJobDone  = "firmware.job_completed"
+)
+
+const (
+	// eventPollInterval is how often the stored events are polled
+	eventPollInterval = time.Second
+	// eventPollOverlap is how far back each poll looks again, events stored
+	// by the ACS may reach the database slightly out of order
+	eventPollOverlap = 5 * time.Second
+	// deviceOnlineWindow is how long a device stays online after an Inform
+	deviceOnlineWindow = 5 * time.Minute
+	// eventSubscriberBuffer is the number of events a slow client may lag
+	// behind before events are dropped for it
+	eventSubscriberBuffer = 256
+	// eventBacklogSize is the number of recent events kept for clients
+	// resuming the stream
+	eventBacklogSize = 1024
+	// eventResumeGrace is how long events are still polled after the last
+	// client left, so that a reconnecting client does not miss any
+	eventResumeGrace = time.Minute
+)
+
+// StreamEvent is an event of the event stream. Its ID is unique for the
+// lifetime of the API server and orders the events.
+type StreamEvent struct {
+	ID         string            `json:"id"`
+	Type       string            `json:"type"`
+	DeviceID   string            `json:"device_id"`
+	EventCode  string            `json:"event_code,omitempty"`
+	CommandKey string            `json:"command_key,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+	Task       *db.CwmpTask      `json:"task,omitempty"`
+	Job        *db.FirmwareJob   `json:"firmware_job,omitempty"`
+	Timestamp  time.Time         `json:"timestamp"`
+
+	seq uint64
+}
+
+// StreamFilter selects the events sent to a client, an empty list selects
+// every device or type. Clients set it with the device_id and type query
+// parameters and may replace it by sending it as a message.
+type StreamFilter struct {
+	DeviceIds []string `json:"device_ids"`
+	Types     []string `json:"types"`
+}
+
+func (f *StreamFilter) matches(event *StreamEvent) bool {
+	return matchesAny(f.DeviceIds, event.DeviceID) && matchesAny(f.Types, event.Type)
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// eventSubscriber is a client of the event stream
+type eventSubscriber struct {
+	events chan *StreamEvent
+	mu     sync.Mutex
+	filter StreamFilter
+}
+
+func (s *eventSubscriber) setFilter(filter StreamFilter) {
+	s.mu.Lock()
+	s.filter = filter
+	s.mu.Unlock()
+}
+
+func (s *eventSubscriber) wants(event *StreamEvent) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter.matches(event)
+}
+
+// eventHub polls the database for device events and fans them out to the
+// clients of the event stream. It only polls while clients are connected,
+// and keeps the recent events for the clients resuming the stream.
+type eventHub struct {
+	mu       sync.Mutex
+	subs     map[*eventSubscriber]bool
+	lastLeft time.Time
+	epoch    string // prefixes the event IDs, which restart with the API server
+	seq      uint64
+	backlog  []*StreamEvent
+
+	primed   bool
+	lastPoll time.Time
+	seen     map[string]time.Time // events streamed within the poll overlap
+	offline  map[string]bool      // devices whose last Inform is outside the online window
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs:  map[*eventSubscriber]bool{},
+		epoch: strconv.FormatInt(time.Now().Unix(), 36),
+	}
+}
+
+// subscribe adds a client and returns the backlog events after lastEventID
+// matching its filter. A client without lastEventID gets no backlog, one
+// whose lastEventID is from a previous run of the API server gets all of it.
+func (h *eventHub) subscribe(filter StreamFilter, lastEventID string) (*eventSubscriber, []*StreamEvent) {
+	sub := &eventSubscriber{events: make(chan *StreamEvent, eventSubscriberBuffer), filter: filter}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[sub] = true
+
+	if lastEventID == "" {
+		return sub, nil
+	}
+	var after uint64
+	if epoch, seq, ok := strings.Cut(lastEventID, "-"); ok && epoch == h.epoch {
+		after, _ = strconv.ParseUint(seq, 10, 64)
+	}
+	var missed []*StreamEvent
+	for _, event := range h.backlog {
+		if event.seq > after && filter.matches(event) {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed
+}
+
+func (h *eventHub) unsubscribe(sub *eventSubscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.lastLeft = time.Now()
+	h.mu.Unlock()
+}
+
+// hasSubscribers reports whether clients are connected, or one left within
+// the resume grace period
+func (h *eventHub) hasSubscribers() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs) > 0 || time.Since(h.lastLeft) < eventResumeGrace
+}
+
+// publish numbers an event, keeps it in the backlog and sends it to the
+// clients whose filter matches it
+func (h *eventHub) publish(event *StreamEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	event.seq = h.seq
+	event.ID = h.epoch + "-" + strconv.FormatUint(h.seq, 10)
+	if len(h.backlog) == eventBacklogSize {
+		copy(h.backlog, h.backlog[1:])
+		h.backlog = h.backlog[:eventBacklogSize-1]
+	}
+	h.backlog = append(h.backlog, event)
+
+	for sub := range h.subs {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Dropping %s event of device %s for a slow event stream client", event.Type, event.DeviceID)
+		}
+	}
+}
+
+// startEventStream polls the events streamed on /ws/events
+func (as *ApiServer) startEventStream() {
+	as.events = newEventHub()
+	as.every(eventPollInterval, as.pollStreamEvents)
+}
+
+// pollStreamEvents publishes the events stored since the last poll
+func (as *ApiServer) pollStreamEvents() {
+	h := as.events
+	cwmpDb := as.dbH.cwmpIntf
+	if !h.hasSubscribers() || cwmpDb == nil {
+		h.primed = false
+		return
+	}
+
+	now := time.Now()
+	if !h.primed {
+		// Devices already offline are only reported once they come online
+		cutoff := now.Add(-deviceOnlineWindow)
+		ids, err := cwmpDb.DistinctCwmpDeviceValues("_id", bson.M{"last_inform": bson.M{"$lte": cutoff}})
+		if err != nil {
+			log.Printf("Error loading offline devices for the event stream: %v", err)
+			return
+		}
+		h.offline = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			h.offline[id] = true
+		}
+		h.seen = map[string]time.Time{}
+		h.lastPoll = now
+		h.primed = true
+		return
+	}
+
+	since := h.lastPoll
+	after := primitive.NewObjectIDFromTimestamp(since.Add(-eventPollOverlap)).Hex()
+	events, err := cwmpDb.GetCwmpDeviceEventsAfter(after, 0)
+	if err != nil {
+		log.Printf("Error polling events for the event stream: %v", err)
+		return
+	}
+	for i := range events {
+		if _, ok := h.seen[events[i].ID]; ok {
+			continue
+		}
+		h.seen[events[i].ID] = now
+		as.publishDeviceEvent(&events[i])
+	}
+	for id, at := range h.seen {
+		if now.Sub(at) > 2*eventPollOverlap {
+			delete(h.seen, id)
+		}
+	}
+
+	// Devices whose last Inform left the online window since the last poll
+	filter := bson.M{"last_inform": bson.M{"$gt": since.Add(-deviceOnlineWindow), "$lte": now.Add(-deviceOnlineWindow)}}
+	if ids, err := cwmpDb.DistinctCwmpDeviceValues("_id", filter); err == nil {
+		for _, id := range ids {
+			h.offline[id] = true
+			h.publish(&StreamEvent{Type: StreamEventOffline, DeviceID: id, Timestamp: now})
+		}
+	} else {
+		log.Printf("Error polling offline devices for the event stream: %v", err)
+	}
+
+	as.publishFinishedTasks(since, now)
+	as.publishCompletedFirmwareJobs(since, now)
+	h.lastPoll = now
+}
+
+// publishDeviceEvent publishes a stored device event, preceded by the device
+// coming online if it informed while offline
+func (as *ApiServer) publishDeviceEvent(event *db.CwmpEvent) {
+	h := as.events
+	eventType := streamEventType(event.EventCode)
+	if eventType == StreamEventInform || eventType == StreamEventTransferComplete {
+		if h.offline[event.DeviceID] {
+			delete(h.offline, event.DeviceID)
+			h.publish(&StreamEvent{Type: StreamEventOnline, DeviceID: event.DeviceID, Timestamp: event.Timestamp})
+		}
+	}
+	h.publish(&StreamEvent{
+		Type:       eventType,
+		DeviceID:   event.DeviceID,
+		EventCode:  event.EventCode,
+		CommandKey: event.CommandKey,
+		Details:    event.Details,
+		Timestamp:  event.Timestamp,
+	})
+}
+
+// publishFinishedTasks publishes the tasks whose command was answered,
+// failed or expired between since and now
+func (as *ApiServer) publishFinishedTasks(since time.Time, now time.Time) {
+	window := bson.M{"$gt": since, "$lte": now}
+	filter := bson.M{"$or": bson.A{
+		bson.M{"answered_at": window},
+		bson.M{"status": db.CommandStatusExpired, "expires_at": window},
+	}}
+	cmds, err := as.dbH.cwmpIntf.GetCwmpCommands(filter, 0)
+	if err != nil {
+		log.Printf("Error polling commands for the event stream: %v", err)
+		return
+	}
+	for _, cmd := range cmds {
+		if cmd.RequestID == "" || cmd.Status == db.CommandStatusQueued {
+			continue
+		}
+		taskFilter := bson.M{"request_id": cmd.RequestID, "device_id": cmd.DeviceID, "method": cmd.Method}
+		tasks, err := as.dbH.cwmpIntf.GetCwmpTasks(taskFilter, 1)
+		if err != nil || len(tasks) == 0 {
+			continue
+		}
+		as.events.publish(&StreamEvent{
+			Type:      StreamEventTaskFinished,
+			DeviceID:  cmd.DeviceID,
+			Task:      &tasks[0],
+			Timestamp: tasks[0].UpdatedAt,
+		})
+	}
+}
+
+// publishCompletedFirmwareJobs publishes the firmware jobs which were
+// verified, mismatched or failed between since and now
+func (as *ApiServer) publishCompletedFirmwareJobs(since time.Time, now time.Time) {
+	filter := bson.M{"completed_at": bson.M{"$gt": since, "$lte": now}}
+	jobs, err := as.dbH.cwmpIntf.GetFirmwareJobs(filter, 0)
+	if err != nil {
+		log.Printf("Error polling firmware jobs for the event stream: %v", err)
+		return
+	}
+	for i := range jobs {
+		as.events.publish(&StreamEvent{
+			Type:      StreamEventFirmwareJobDone,
+			DeviceID:  jobs[i].DeviceID,
+			Job:       &jobs[i],
+			Timestamp: *jobs[i].CompletedAt,
+		})
+	}
+}
+
+// streamEventType returns the stream event type of a stored event code
+func streamEventType(code string) string {
+	switch {
+	case code == cwmp.EventTransferComplete || code == cwmp.EventAutonomousTransferComplete:
+		return StreamEventTransferComplete
+	case code == cwmp.DeviceEventParametersChanged:
+		return StreamEventParameterChanged
+	case strings.HasPrefix(code, "device."):
+		return code
+	}
+	// TR-069 event codes, such as 2 PERIODIC or M Reboot
+	return StreamEventInform
+}
+
+var eventUpgrader = websocket.Upgrader{}
+
+func (as *ApiServer) setEventRoutesHandlers() {
+	as.router.HandleFunc(WS_EVENTS, as.streamEvents).Methods("GET")
+	as.router.HandleFunc(SSE_EVENTS, as.streamServerSentEvents).Methods("GET")
+}
+
+// streamEvents streams device events as JSON messages on a WebSocket. The
+// client may send a StreamFilter message at any time to change its filter.
+func (as *ApiServer) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	filter := StreamFilter{
+		DeviceIds: splitQueryList(r.URL.Query()["device_id"]),
+		Types:     splitQueryList(r.URL.Query()["type"]),
+	}
+
+	conn, err := eventUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Event stream upgrade failed for %s: %v", r.RemoteAddr, err)
+		return
+	}
+	defer conn.Close()
+
+	sub, _ := as.events.subscribe(filter, "")
+	defer as.events.unsubscribe(sub)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var f StreamFilter
+			if err := json.Unmarshal(msg, &f); err != nil {
+				log.Printf("Ignoring invalid event stream filter from %s: %v", r.RemoteAddr, err)
+				continue
+			}
+			sub.setFilter(f)
+		}
+	}()
+
+	log.Printf("Streaming events to %s", r.RemoteAddr)
+	for {
+		select {
+		case <-done:
+			return
+		case <-as.stopping:
+			msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+			return
+		case event := <-sub.events:
+			if err := conn.WriteJSON(event); err != nil {
+				log.Printf("Event stream to %s ended: %v", r.RemoteAddr, err)
+				return
+			}
+		}
+	}
+}
+
+// splitQueryList returns the values of a repeated or comma-separated query
+// parameter
+func splitQueryList(params []string) []string {
+	var values []string
+	for _, param := range params {
+		for _, value := range strings.Split(param, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,111 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	FILES = "/files/"
+	FILE  = "/files/{id}"
+)
+
+// maxFileUploadSize limits the size of a file uploaded to the file store
+const maxFileUploadSize = 1 << 30
+
+func (as *ApiServer) setFileRoutesHandlers() {
+	as.router.HandleFunc(FILES, as.getFiles).Methods("GET")
+	as.router.HandleFunc(FILES, as.uploadFile).Methods("POST")
+	as.router.HandleFunc(FILE, as.getFile).Methods("GET")
+	as.router.HandleFunc(FILE, as.deleteFile).Methods("DELETE")
+}
+
+// getFiles lists the firmware images and configuration files of the file
+// store
+func (as *ApiServer) getFiles(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	files, err := as.dbH.cwmpIntf.GetFiles()
+	httpSendRes(w, files, err)
+}
+
+func (as *ApiServer) getFile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	file, err := as.dbH.cwmpIntf.GetFile(mux.Vars(r)["id"])
+	httpSendRes(w, file, err)
+}
+
+// uploadFile stores the file of a multipart form in the file store, its ID
+// can then be given as file_id of a Download
+func (as *ApiServer) uploadFile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		httpSendRes(w, nil, errBadRequest("expected a multipart form: %w", err))
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("no file part in the form"))
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			continue
+		}
+
+		contentType := part.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		file, err := as.dbH.cwmpIntf.PutFile(path.Base(part.FileName()), contentType, part)
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		log.Printf("Stored file %s (%s, %d bytes)", file.ID.Hex(), file.Name, file.Length)
+		httpSendRes(w, file, nil)
+		return
+	}
+}
+
+func (as *ApiServer) deleteFile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := as.dbH.cwmpIntf.DeleteFile(id); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"id": id, "status": "deleted"}, nil)
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	FIRMWARE_COMPAT      = "/firmware/compatibility/"
+	FIRMWARE_COMPAT_RULE = "/firmware/compatibility/{ruleId}"
+	FIRMWARE_JOBS        = "/firmware/jobs/"
+	FIRMWARE_JOB         = "/firmware/jobs/{id}"
+)
+
+// getFirmwareCompatRules lists the firmware compatibility matrix
+func (as *ApiServer) getFirmwareCompatRules(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if manufacturer := r.URL.Query().Get("manufacturer"); manufacturer != "" {
+		filter["manufacturer"] = manufacturer
+	}
+	if modelName := r.URL.Query().Get("model_name"); modelName != "" {
+		filter["model_name"] = modelName
+	}
+	rules, err := as.dbH.cwmpIntf.GetFirmwareCompatRules(filter)
+	httpSendRes(w, rules, err)
+}
+
+// setFirmwareCompatRule creates or replaces the rule of a model and hardware version
+func (as *ApiServer) setFirmwareCompatRule(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var rule db.FirmwareCompatRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if rule.Manufacturer == "" || rule.ModelName == "" || len(rule.AllowedVersions) == 0 {
+		httpSendRes(w, nil, errBadRequest("manufacturer, model_name and allowed_versions are required"))
+		return
+	}
+
+	if err := as.dbH.cwmpIntf.UpsertFirmwareCompatRule(&rule); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, rule, nil)
+}
+
+// deleteFirmwareCompatRule removes a rule from the compatibility matrix
+func (as *ApiServer) deleteFirmwareCompatRule(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	ruleId := mux.Vars(r)["ruleId"]
+	if err := as.dbH.cwmpIntf.DeleteFirmwareCompatRule(ruleId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"id": ruleId, "status": "deleted"}, nil)
+}
+
+// getFirmwareJobs lists firmware jobs, newest first, filtered by device_id,
+// status or transfer_id
+func (as *ApiServer) getFirmwareJobs(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	for _, key := range []string{"device_id", "status", "transfer_id"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	jobs, err := as.dbH.cwmpIntf.GetFirmwareJobs(filter, limit)
+	httpSendRes(w, jobs, err)
+}
+
+// getFirmwareJob returns a firmware job with its state transitions
+func (as *ApiServer) getFirmwareJob(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	job, err := as.dbH.cwmpIntf.GetFirmwareJob(mux.Vars(r)["id"])
+	httpSendRes(w, job, err)
+}
+
+// checkFirmwareCompat rejects pushing a firmware version to a device it is
+// not compatible with, unless force is set
+func (as *ApiServer) checkFirmwareCompat(deviceId string, version string, force bool) error {
+	if force {
+		log.Printf("Firmware compatibility check overridden for device %s, version %q", deviceId, version)
+		return nil
+	}
+	if as.dbH.cwmpIntf == nil {
+		return errCwmpDbNotConnected
+	}
+
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		return err
+	}
+	if err := as.dbH.cwmpIntf.CheckFirmwareCompat(device, version); err != nil {
+		if errors.Is(err, db.ErrFirmwareIncompatible) {
+			return errConflict("%v, set force to override", err)
+		}
+		return err
+	}
+	return nil
+}
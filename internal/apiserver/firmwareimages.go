@@ -0,0 +1,247 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	FIRMWARE_IMAGES = "/firmware/images/"
+	FIRMWARE_IMAGE  = "/firmware/images/{id}"
+)
+
+// maxFirmwareFieldSize limits the size of the metadata fields of a
+// multipart firmware upload
+const maxFirmwareFieldSize = 64 << 10
+
+func (as *ApiServer) setFirmwareImageRoutesHandlers() {
+	as.router.HandleFunc(FIRMWARE_IMAGES, as.getFirmwareImages).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_IMAGES, as.addFirmwareImage).Methods("POST")
+	as.router.HandleFunc(FIRMWARE_IMAGE, as.getFirmwareImage).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_IMAGE, as.deleteFirmwareImage).Methods("DELETE")
+}
+
+// getFirmwareImages lists the firmware catalog, newest first, filtered by
+// vendor, product_class or version
+func (as *ApiServer) getFirmwareImages(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	for _, key := range []string{"vendor", "product_class", "version"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	images, err := as.dbH.cwmpIntf.GetFirmwareImages(filter, limit)
+	httpSendRes(w, images, err)
+}
+
+func (as *ApiServer) getFirmwareImage(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	image, err := as.dbH.cwmpIntf.GetFirmwareImage(mux.Vars(r)["id"])
+	httpSendRes(w, image, err)
+}
+
+// addFirmwareImage registers a firmware image in the catalog. A JSON body
+// references an external url or a file of the file store, a multipart form
+// uploads the image to the file store with the metadata as form fields.
+func (as *ApiServer) addFirmwareImage(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var image db.FirmwareImage
+	var uploaded *db.StoredFile
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		var err error
+		if uploaded, err = as.readFirmwareUpload(w, r, &image); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+
+	err := as.registerFirmwareImage(r, &image, uploaded)
+	if err != nil && uploaded != nil {
+		if dErr := as.dbH.cwmpIntf.DeleteFile(uploaded.ID.Hex()); dErr != nil {
+			log.Printf("Error removing file %s of rejected firmware image: %v", uploaded.ID.Hex(), dErr)
+		}
+	}
+	if errors.Is(err, db.ErrFirmwareImageExists) {
+		err = errConflict("firmware %s %s %s is already registered", image.Vendor, image.ProductClass, image.Version)
+	}
+	httpSendRes(w, image, err)
+}
+
+// readFirmwareUpload stores the file part of a multipart firmware upload in
+// the file store and reads the other fields into image
+func (as *ApiServer) readFirmwareUpload(w http.ResponseWriter, r *http.Request, image *db.FirmwareImage) (*db.StoredFile, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileUploadSize)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errBadRequest("expected a multipart form: %w", err)
+	}
+
+	fields := map[string]*string{
+		"vendor":        &image.Vendor,
+		"product_class": &image.ProductClass,
+		"version":       &image.Version,
+		"sha256":        &image.SHA256,
+		"release_notes": &image.ReleaseNotes,
+	}
+	var file *db.StoredFile
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return file, errBadRequest("invalid multipart form: %w", err)
+		}
+
+		if part.FormName() == "file" && part.FileName() != "" && file == nil {
+			contentType := part.Header.Get("Content-Type")
+			if contentType == "" {
+				contentType = "application/octet-stream"
+			}
+			if file, err = as.dbH.cwmpIntf.PutFile(path.Base(part.FileName()), contentType, part); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if field, ok := fields[part.FormName()]; ok {
+			value, err := io.ReadAll(io.LimitReader(part, maxFirmwareFieldSize))
+			if err != nil {
+				return file, errBadRequest("invalid field %s: %w", part.FormName(), err)
+			}
+			*field = strings.TrimSpace(string(value))
+		}
+	}
+	if file == nil {
+		return nil, errBadRequest("no file part in the form")
+	}
+	return file, nil
+}
+
+// registerFirmwareImage validates an image and adds it to the catalog. The
+// size and checksum of stored files are taken from the file store.
+func (as *ApiServer) registerFirmwareImage(r *http.Request, image *db.FirmwareImage, uploaded *db.StoredFile) error {
+	if image.Vendor == "" || image.ProductClass == "" || image.Version == "" {
+		return errBadRequest("vendor, product_class and version are required")
+	}
+	image.SHA256 = strings.ToLower(image.SHA256)
+
+	file := uploaded
+	if file != nil {
+		image.URL = ""
+		image.FileID = file.ID.Hex()
+	} else if image.FileID != "" {
+		if image.URL != "" {
+			return errBadRequest("url and file_id are exclusive")
+		}
+		var err error
+		if file, err = as.dbH.cwmpIntf.GetFile(image.FileID); err != nil {
+			return err
+		}
+	} else if image.URL == "" {
+		return errBadRequest("url, file_id or a file upload is required")
+	}
+
+	if file != nil {
+		if image.SHA256 != "" && image.SHA256 != file.SHA256 {
+			return errBadRequest("sha256 %s does not match the file, its digest is %s", image.SHA256, file.SHA256)
+		}
+		image.SHA256 = file.SHA256
+		image.FileSize = file.Length
+		image.FileName = file.Name
+	}
+	image.CreatedBy = requestUser(r)
+	return as.dbH.cwmpIntf.InsertFirmwareImage(image)
+}
+
+// deleteFirmwareImage removes an image from the catalog along with its file
+// in the file store
+func (as *ApiServer) deleteFirmwareImage(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	image, err := as.dbH.cwmpIntf.DeleteFirmwareImage(id)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if image.FileID != "" {
+		if err := as.dbH.cwmpIntf.DeleteFile(image.FileID); err != nil {
+			log.Printf("Error removing file %s of firmware image %s: %v", image.FileID, id, err)
+		}
+	}
+	httpSendRes(w, map[string]string{"id": id, "status": "deleted"}, nil)
+}
+
+// resolveFirmwareImage fills a Download with the firmware image of the
+// catalog it references. The image has to be built for the product class
+// of the device, unless force is set.
+func (as *ApiServer) resolveFirmwareImage(req *CwmpDownloadRequest, device *db.CwmpDevice) error {
+	image, err := as.dbH.cwmpIntf.GetFirmwareImage(req.FirmwareID)
+	if err != nil {
+		return err
+	}
+	if !req.Force && (image.Vendor != device.Manufacturer || image.ProductClass != device.ProductClass) {
+		return errConflict("firmware %s is for %s %s, device %s is a %s %s, set force to override",
+			image.ID, image.Vendor, image.ProductClass, device.ID, device.Manufacturer, device.ProductClass)
+	}
+
+	req.FileType = db.FileTypeFirmwareImage
+	req.URL = image.URL
+	req.FileID = image.FileID
+	req.FirmwareVersion = image.Version
+	if req.FileSize == 0 {
+		req.FileSize = uint32(image.FileSize)
+	}
+	if req.TargetFileName == "" {
+		req.TargetFileName = image.FileName
+	}
+	return nil
+}
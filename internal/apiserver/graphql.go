@@ -0,0 +1,462 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/graphql"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const GRAPHQL = "/graphql"
+
+// Page sizes of the lists nested in a device
+const defaultGraphQLNestedLimit = 20
+
+// graphqlDeviceFilters are the members of the devices filter argument, the
+// device listing query parameters in camel case
+var graphqlDeviceFilters = map[string]bool{
+	"manufacturer": true, "productClass": true, "onlineOnly": true, "ipCidr": true, "ipFrom": true,
+	"ipTo": true, "country": true, "region": true, "asn": true, "dataModel": true, "tags": true,
+	"archived": true, "tenant": true,
+}
+
+func (as *ApiServer) setGraphQLRoutesHandlers() {
+	schema := as.graphqlSchema()
+	as.router.HandleFunc(GRAPHQL, func(w http.ResponseWriter, r *http.Request) {
+		as.serveGraphQL(w, r, schema)
+	}).Methods("GET", "POST")
+}
+
+// serveGraphQL runs the query posted as JSON, or of the query and variables
+// query parameters of a GET. A GET without query returns the schema.
+func (as *ApiServer) serveGraphQL(w http.ResponseWriter, r *http.Request, schema *graphql.Schema) {
+	var req graphql.Request
+	if r.Method == http.MethodGet {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if req.Query == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			fmt.Fprint(w, schema.String())
+			return
+		}
+		if vars := r.URL.Query().Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				httpSendRes(w, nil, errBadRequest("invalid variables: %w", err))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	result := schema.Execute(r.Context(), req)
+	if len(result.Errors) > 0 {
+		logging.FromContext(r.Context()).Warnf("GraphQL query answered with %d error(s), first: %s", len(result.Errors), result.Errors[0].Message)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Executed() {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// graphqlSchema exposes the devices with their parameters, tasks and events
+func (as *ApiServer) graphqlSchema() *graphql.Schema {
+	parameter := &graphql.Object{Name: "Parameter", Fields: map[string]*graphql.Field{}}
+	for name, get := range map[string]func(p *db.CwmpParameter) interface{}{
+		"path":       func(p *db.CwmpParameter) interface{} { return p.Path },
+		"value":      func(p *db.CwmpParameter) interface{} { return p.Value },
+		"type":       func(p *db.CwmpParameter) interface{} { return p.Type },
+		"writable":   func(p *db.CwmpParameter) interface{} { return p.Writable },
+		"lastUpdate": func(p *db.CwmpParameter) interface{} { return p.LastUpdate },
+	} {
+		get := get
+		parameter.Fields[name] = &graphql.Field{Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*db.CwmpParameter)), nil
+		}}
+	}
+
+	task := &graphql.Object{Name: "Task", Fields: map[string]*graphql.Field{}}
+	for name, get := range map[string]func(t *db.CwmpTask) interface{}{
+		"id":          func(t *db.CwmpTask) interface{} { return t.ID },
+		"deviceId":    func(t *db.CwmpTask) interface{} { return t.DeviceID },
+		"method":      func(t *db.CwmpTask) interface{} { return t.Method },
+		"requestId":   func(t *db.CwmpTask) interface{} { return t.RequestID },
+		"status":      func(t *db.CwmpTask) interface{} { return t.Status },
+		"faultCode":   func(t *db.CwmpTask) interface{} { return t.FaultCode },
+		"faultString": func(t *db.CwmpTask) interface{} { return t.FaultString },
+		"createdAt":   func(t *db.CwmpTask) interface{} { return t.CreatedAt },
+		"updatedAt":   func(t *db.CwmpTask) interface{} { return t.UpdatedAt },
+	} {
+		get := get
+		task.Fields[name] = &graphql.Field{Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*db.CwmpTask)), nil
+		}}
+	}
+
+	event := &graphql.Object{Name: "Event", Fields: map[string]*graphql.Field{}}
+	for name, get := range map[string]func(e *db.CwmpEvent) interface{}{
+		"id":         func(e *db.CwmpEvent) interface{} { return e.ID },
+		"deviceId":   func(e *db.CwmpEvent) interface{} { return e.DeviceID },
+		"eventCode":  func(e *db.CwmpEvent) interface{} { return e.EventCode },
+		"commandKey": func(e *db.CwmpEvent) interface{} { return e.CommandKey },
+		"details":    func(e *db.CwmpEvent) interface{} { return e.Details },
+		"timestamp":  func(e *db.CwmpEvent) interface{} { return e.Timestamp },
+	} {
+		get := get
+		event.Fields[name] = &graphql.Field{Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*db.CwmpEvent)), nil
+		}}
+	}
+
+	device := &graphql.Object{Name: "Device", Fields: map[string]*graphql.Field{}}
+	for name, get := range map[string]func(d *CwmpDeviceInfo) interface{}{
+		"id":                   func(d *CwmpDeviceInfo) interface{} { return d.DeviceId },
+		"manufacturer":         func(d *CwmpDeviceInfo) interface{} { return d.Manufacturer },
+		"oui":                  func(d *CwmpDeviceInfo) interface{} { return d.OUI },
+		"productClass":         func(d *CwmpDeviceInfo) interface{} { return d.ProductClass },
+		"serialNumber":         func(d *CwmpDeviceInfo) interface{} { return d.SerialNumber },
+		"softwareVersion":      func(d *CwmpDeviceInfo) interface{} { return d.SoftwareVersion },
+		"hardwareVersion":      func(d *CwmpDeviceInfo) interface{} { return d.HardwareVersion },
+		"lastInformTime":       func(d *CwmpDeviceInfo) interface{} { return d.LastInformTime },
+		"isOnline":             func(d *CwmpDeviceInfo) interface{} { return d.IsOnline },
+		"parameterCount":       func(d *CwmpDeviceInfo) interface{} { return d.ParameterCount },
+		"connectionRequestUrl": func(d *CwmpDeviceInfo) interface{} { return d.ConnectionRequestURL },
+		"ipAddress":            func(d *CwmpDeviceInfo) interface{} { return d.IPAddress },
+		"geo":                  func(d *CwmpDeviceInfo) interface{} { return d.Geo },
+		"tags":                 func(d *CwmpDeviceInfo) interface{} { return d.Tags },
+		"archivedAt":           func(d *CwmpDeviceInfo) interface{} { return d.ArchivedAt },
+		"tenant":               func(d *CwmpDeviceInfo) interface{} { return d.Tenant },
+	} {
+		get := get
+		device.Fields[name] = &graphql.Field{Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return get(p.Source.(*CwmpDeviceInfo)), nil
+		}}
+	}
+	device.Fields["params"] = &graphql.Field{Type: parameter, Args: []string{"prefix", "limit"},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return as.graphqlParameters(p.Source.(*CwmpDeviceInfo).DeviceId, p.Args)
+		}}
+	device.Fields["tasks"] = &graphql.Field{Type: task, Args: []string{"status", "method", "limit"},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			filter := bson.M{"device_id": p.Source.(*CwmpDeviceInfo).DeviceId}
+			return as.graphqlTasks(filter, p.Args, defaultGraphQLNestedLimit)
+		}}
+	device.Fields["events"] = &graphql.Field{Type: event, Args: []string{"eventCode", "limit"},
+		Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			filter := bson.M{"device_id": p.Source.(*CwmpDeviceInfo).DeviceId}
+			return as.graphqlEvents(filter, p.Args, defaultGraphQLNestedLimit)
+		}}
+	deviceOf := &graphql.Field{Type: device, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		var id string
+		switch source := p.Source.(type) {
+		case *db.CwmpTask:
+			id = source.DeviceID
+		case *db.CwmpEvent:
+			id = source.DeviceID
+		}
+		return as.graphqlDevice(p.Context, id)
+	}}
+	task.Fields["device"] = deviceOf
+	event.Fields["device"] = deviceOf
+
+	query := &graphql.Object{Name: "Query", Fields: map[string]*graphql.Field{
+		"devices": {Type: device, Args: []string{"filter", "limit", "offset", "sortBy", "order"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return as.graphqlDevices(p.Context, p.Args)
+			}},
+		"device": {Type: device, Args: []string{"id"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				id, err := graphqlString(p.Args, "id")
+				if err != nil {
+					return nil, err
+				}
+				return as.graphqlDevice(p.Context, id)
+			}},
+		"tasks": {Type: task, Args: []string{"deviceId", "status", "method", "limit"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				filter, err := as.graphqlDeviceIDFilter(p)
+				if err != nil {
+					return nil, err
+				}
+				return as.graphqlTasks(filter, p.Args, 100)
+			}},
+		"events": {Type: event, Args: []string{"deviceId", "eventCode", "limit"},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				filter, err := as.graphqlDeviceIDFilter(p)
+				if err != nil {
+					return nil, err
+				}
+				return as.graphqlEvents(filter, p.Args, 100)
+			}},
+	}}
+	return &graphql.Schema{Query: query}
+}
+
+// graphqlDevices lists the devices matching the filter argument with the
+// paging and sorting of the device listing
+func (as *ApiServer) graphqlDevices(ctx context.Context, args map[string]interface{}) ([]CwmpDeviceInfo, error) {
+	values := url.Values{}
+	filterArg, _ := args["filter"].(map[string]interface{})
+	if args["filter"] != nil && filterArg == nil {
+		return nil, errors.New("filter must be an object")
+	}
+	for key, value := range filterArg {
+		if !graphqlDeviceFilters[key] {
+			return nil, fmt.Errorf("unknown device filter %s", key)
+		}
+		name := snakeCase(key)
+		if key == "tags" {
+			name = "tag"
+		}
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				values.Add(name, fmt.Sprint(item))
+			}
+		case nil:
+		default:
+			values.Set(name, fmt.Sprint(v))
+		}
+	}
+	filter, err := cwmpDeviceQueryFilter(values)
+	if err != nil {
+		return nil, err
+	}
+	scopeDeviceFilter(ctx, filter)
+
+	opts := db.DeviceListOptions{}
+	if opts.Limit, err = graphqlLimit(args, defaultDeviceListLimit); err != nil {
+		return nil, err
+	}
+	if opts.Limit > maxDeviceListLimit {
+		return nil, fmt.Errorf("limit must not exceed %d", maxDeviceListLimit)
+	}
+	if opts.Offset, err = graphqlInt(args, "offset", 0); err != nil {
+		return nil, err
+	}
+	sortBy, err := graphqlString(args, "sortBy")
+	if err != nil {
+		return nil, err
+	}
+	if sortBy != "" {
+		field, ok := deviceSortFields[snakeCase(sortBy)]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %s", sortBy)
+		}
+		opts.SortBy = field
+	}
+	order, err := graphqlString(args, "order")
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(order) {
+	case "", "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", order)
+	}
+
+	dbDevices, _, err := as.dbH.cwmpIntf.ListCwmpDevices(filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]CwmpDeviceInfo, 0, len(dbDevices))
+	for i := range dbDevices {
+		devices = append(devices, newCwmpDeviceInfo(&dbDevices[i]))
+	}
+	return devices, nil
+}
+
+// graphqlDevice returns a device, or nil when it does not exist or belongs
+// to another tenant
+func (as *ApiServer) graphqlDevice(ctx context.Context, id string) (*CwmpDeviceInfo, error) {
+	if id == "" {
+		return nil, errors.New("id is required")
+	}
+	dbDevice, err := as.dbH.cwmpIntf.GetCwmpDeviceByID(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tenant := requestTenant(ctx); tenant != "" && dbDevice.Tenant != tenant {
+		return nil, nil
+	}
+	device := newCwmpDeviceInfo(dbDevice)
+	return &device, nil
+}
+
+// graphqlParameters returns the parameters of a device sorted by path,
+// those under the prefix argument only
+func (as *ApiServer) graphqlParameters(deviceId string, args map[string]interface{}) ([]db.CwmpParameter, error) {
+	prefix, err := graphqlString(args, "prefix")
+	if err != nil {
+		return nil, err
+	}
+	var limit int64
+	if args["limit"] != nil {
+		if limit, err = graphqlLimit(args, 0); err != nil {
+			return nil, err
+		}
+	}
+	all, err := as.dbH.cwmpIntf.GetCwmpParametersByDeviceID(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	params := []db.CwmpParameter{}
+	for _, param := range all {
+		if strings.HasPrefix(param.Path, prefix) {
+			params = append(params, param)
+		}
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].Path < params[j].Path })
+	if limit > 0 && int64(len(params)) > limit {
+		params = params[:limit]
+	}
+	return params, nil
+}
+
+// graphqlDeviceIDFilter filters the tasks or events of the deviceId argument,
+// or of all the devices of the tenant of the request without it
+func (as *ApiServer) graphqlDeviceIDFilter(p graphql.ResolveParams) (bson.M, error) {
+	deviceId, err := graphqlString(p.Args, "deviceId")
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{}
+	if deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if err := as.scopeDeviceIDFilter(p.Context, filter); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// graphqlTasks returns the tasks matching filter and the arguments, newest
+// first
+func (as *ApiServer) graphqlTasks(filter bson.M, args map[string]interface{}, defaultLimit int64) ([]db.CwmpTask, error) {
+	for _, key := range []string{"status", "method"} {
+		value, err := graphqlString(args, key)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := graphqlLimit(args, defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	return as.dbH.cwmpIntf.GetCwmpTasks(filter, limit)
+}
+
+// graphqlEvents returns the events matching filter and the arguments,
+// newest first
+func (as *ApiServer) graphqlEvents(filter bson.M, args map[string]interface{}, defaultLimit int64) ([]db.CwmpEvent, error) {
+	eventCode, err := graphqlString(args, "eventCode")
+	if err != nil {
+		return nil, err
+	}
+	if eventCode != "" {
+		filter["event_code"] = eventCode
+	}
+	limit, err := graphqlLimit(args, defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+	return as.dbH.cwmpIntf.GetCwmpDeviceEvents(filter, limit)
+}
+
+// graphqlString returns a string argument, empty when it is not set
+func graphqlString(args map[string]interface{}, name string) (string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("%s must be a string", name)
+}
+
+// graphqlInt returns a non-negative integer argument, literal or from the JSON
+// variables
+func graphqlInt(args map[string]interface{}, name string, defaultValue int64) (int64, error) {
+	var n int64
+	switch v := args[name].(type) {
+	case nil:
+		return defaultValue, nil
+	case int64:
+		n = v
+	case float64:
+		n = int64(v)
+		if float64(n) != v {
+			return 0, fmt.Errorf("%s must be an integer", name)
+		}
+	default:
+		return 0, fmt.Errorf("%s must be an integer", name)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("%s must not be negative", name)
+	}
+	return n, nil
+}
+
+// graphqlLimit returns the limit argument, which must be positive
+func graphqlLimit(args map[string]interface{}, defaultLimit int64) (int64, error) {
+	limit, err := graphqlInt(args, "limit", defaultLimit)
+	if err == nil && limit == 0 {
+		err = errors.New("limit must be positive")
+	}
+	return limit, err
+}
+
+// snakeCase converts a camel case name to the snake case of the query
+// parameters, e.g. productClass to product_class
+func snakeCase(name string) string {
+	var sb strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,224 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	GROUPS        = "/groups/"
+	GROUP         = "/groups/{name}"
+	GROUP_DEVICES = "/groups/{name}/devices"
+)
+
+// groupSyncInterval is how often the members of the groups are synced to
+// the groups field of the devices the presets match
+const groupSyncInterval = time.Minute
+
+// DeviceGroupRequest defines a group by its device IDs, or dynamically by a
+// device search
+type DeviceGroupRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	DeviceIds   []string          `json:"device_ids,omitempty"`
+	Filter      *CwmpDeviceSearch `json:"filter,omitempty"`
+}
+
+func (as *ApiServer) setGroupRoutesHandlers() {
+	as.router.HandleFunc(GROUPS, as.getDeviceGroups).Methods("GET")
+	as.router.HandleFunc(GROUPS, as.setDeviceGroup).Methods("POST")
+	as.router.HandleFunc(GROUP, as.getDeviceGroup).Methods("GET")
+	as.router.HandleFunc(GROUP, as.deleteDeviceGroup).Methods("DELETE")
+	as.router.HandleFunc(GROUP_DEVICES, as.getDeviceGroupMembers).Methods("GET")
+}
+
+func (as *ApiServer) getDeviceGroups(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	groups, err := as.dbH.cwmpIntf.GetDeviceGroups()
+	httpSendRes(w, groups, err)
+}
+
+func (as *ApiServer) getDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	group, err := as.dbH.cwmpIntf.GetDeviceGroup(mux.Vars(r)["name"])
+	httpSendRes(w, group, err)
+}
+
+// setDeviceGroup creates or replaces a group and syncs its members. The
+// filter of a dynamic group is compiled before it is stored.
+func (as *ApiServer) setDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req DeviceGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	// Group names have the format of tags, presets match them alike
+	if !tagRe.MatchString(req.Name) {
+		httpSendRes(w, nil, errBadRequest("invalid group name %q, names are up to 64 letters, digits, '_', '.', ':' or '-'", req.Name))
+		return
+	}
+	if (len(req.DeviceIds) > 0) == (req.Filter != nil) {
+		httpSendRes(w, nil, errBadRequest("a group is defined by either device_ids or a filter"))
+		return
+	}
+
+	group := &db.DeviceGroup{Name: req.Name, Description: req.Description, DeviceIDs: req.DeviceIds}
+	if req.Filter != nil {
+		filter, err := as.compileDeviceSearch(req.Filter)
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		if len(filter) == 0 {
+			httpSendRes(w, nil, errBadRequest("group filter must have a condition"))
+			return
+		}
+		if group.Filter, err = searchDocument(req.Filter); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+	if err := as.dbH.cwmpIntf.UpsertDeviceGroup(group); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.syncDeviceGroup(group); err != nil {
+		// The next periodic sync catches up
+		log.Printf("Error syncing the members of group %s: %v", group.Name, err)
+	}
+	httpSendRes(w, group, nil)
+}
+
+func (as *ApiServer) deleteDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := as.dbH.cwmpIntf.DeleteDeviceGroup(name); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"name": name, "status": "deleted"}, nil)
+}
+
+// getDeviceGroupMembers answers with a page of the current members of a
+// group, paged like the device listing
+func (as *ApiServer) getDeviceGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	filter, err := as.deviceGroupFilter(mux.Vars(r)["name"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.sendCwmpDeviceList(w, r, filter)
+}
+
+// deviceGroupFilter returns the filter of the devices which are currently
+// members of a group. Archived devices are no members.
+func (as *ApiServer) deviceGroupFilter(name string) (bson.M, error) {
+	group, err := as.dbH.cwmpIntf.GetDeviceGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	return as.groupMemberFilter(group)
+}
+
+func (as *ApiServer) groupMemberFilter(group *db.DeviceGroup) (bson.M, error) {
+	if group.Filter == nil {
+		return withoutArchived(bson.M{"_id": bson.M{"$in": group.DeviceIDs}}), nil
+	}
+	var search CwmpDeviceSearch
+	data, err := json.Marshal(group.Filter)
+	if err == nil {
+		err = json.Unmarshal(data, &search)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter of group %s: %w", group.Name, err)
+	}
+	filter, err := as.compileDeviceSearch(&search)
+	if err != nil {
+		return nil, err
+	}
+	return withoutArchived(filter), nil
+}
+
+// searchDocument converts a device search into the document stored as the
+// filter of a group
+func searchDocument(search *CwmpDeviceSearch) (bson.M, error) {
+	data, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// syncDeviceGroup marks the current members of a group with it
+func (as *ApiServer) syncDeviceGroup(group *db.DeviceGroup) error {
+	filter, err := as.groupMemberFilter(group)
+	if err != nil {
+		return err
+	}
+	group.MemberCount, err = as.dbH.cwmpIntf.SyncDeviceGroupMembers(group.Name, filter)
+	return err
+}
+
+// startGroupSync periodically syncs the members of every group, the members
+// of dynamic groups change with the devices
+func (as *ApiServer) startGroupSync() {
+	as.every(groupSyncInterval, func() {
+		if as.dbH.cwmpIntf == nil {
+			return
+		}
+		groups, err := as.dbH.cwmpIntf.GetDeviceGroups()
+		if err != nil {
+			log.Printf("Error loading device groups: %v", err)
+			return
+		}
+		for i := range groups {
+			if err := as.syncDeviceGroup(&groups[i]); err != nil {
+				log.Printf("Error syncing the members of group %s: %v", groups[i].Name, err)
+			}
+		}
+	})
+}
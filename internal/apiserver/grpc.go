@@ -16,12 +16,13 @@ package apiserver
 
 import (
 	"context"
-	"errors"
 	"log"
 	"strconv"
 
+	"github.com/n4-networks/openusp/pkg/logging"
 	"github.com/n4-networks/openusp/pkg/pb/cntlrgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 var resultStr = map[bool]string{
@@ -45,7 +46,8 @@ func (as *ApiServer) connectToController() error {
 
 	ctx, cancel := context.WithTimeout(context.Background(), as.cfg.connTimeout)
 	defer cancel()
-	conn, err := grpc.DialContext(ctx, as.cfg.cntlrAddr, grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := grpc.DialContext(ctx, as.cfg.cntlrAddr, grpc.WithInsecure(), grpc.WithBlock(),
+		grpc.WithChainUnaryInterceptor(requestIDInterceptor, as.metrics.grpcInterceptor))
 	if err != nil {
 		return err
 	}
@@ -54,6 +56,16 @@ func (as *ApiServer) connectToController() error {
 	return nil
 }
 
+// requestIDInterceptor forwards the request ID of the API call to the
+// controller in the gRPC metadata
+func requestIDInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := logging.RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, logging.RequestIDMetadataKey, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
 type CntlrInfo struct {
 	Version string `json:"version"`
 }
@@ -64,7 +76,7 @@ func (as *ApiServer) getCntlrInfoObj() (*CntlrInfo, error) {
 
 func (as *ApiServer) GetCntlrInfo() (*CntlrInfo, error) {
 	if as.grpcH.intf == nil {
-		return nil, errors.New("Controller is not connected")
+		return nil, errCntlrNotConnected
 	}
 	var none cntlrgrpc.None
 	res, err := as.grpcH.intf.GetInfo(context.Background(), &none)
@@ -77,9 +89,22 @@ func (as *ApiServer) GetCntlrInfo() (*CntlrInfo, error) {
 	return info, nil
 }
 
-func (as *ApiServer) CntlrSetParamReq(epId string, path string, params map[string]string) error {
+// CntlrGetAgents returns the IDs of the agents connected to the controller
+func (as *ApiServer) CntlrGetAgents(ctx context.Context) ([]string, error) {
+	if as.grpcH.intf == nil {
+		return nil, errCntlrNotConnected
+	}
+	var none cntlrgrpc.None
+	res, err := as.grpcH.intf.GetAgents(ctx, &none)
+	if err != nil {
+		return nil, err
+	}
+	return res.GetAgentIds(), nil
+}
+
+func (as *ApiServer) CntlrSetParamReq(ctx context.Context, epId string, path string, params map[string]string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var paramName, paramValue string
 	for k, v := range params {
@@ -93,64 +118,64 @@ func (as *ApiServer) CntlrSetParamReq(epId string, path string, params map[strin
 	in.Path = path
 	in.Param = paramName
 	in.Value = paramValue
-	log.Println("Sending setparam request to Controller, path:", path)
+	logging.FromContext(ctx).Infof("Sending setparam request to Controller, path: %s", path)
 	log.Printf("%v: %v\n", paramName, paramValue)
-	res, err := as.grpcH.intf.SetParamReq(context.Background(), &in)
+	res, err := as.grpcH.intf.SetParamReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !res.GetIsSuccess() {
 		log.Println("Error in executing CntlrSetParamReq")
-		return errors.New(res.GetErrMsg())
+		return errControllerFailure("%s", res.GetErrMsg())
 	}
 	return err
 }
 
-func (as *ApiServer) CntlrGetParamReq(epId string, path string) error {
+func (as *ApiServer) CntlrGetParamReq(ctx context.Context, epId string, path string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var in cntlrgrpc.GetParamReqData
 	in.AgentId = epId
 	in.MsgId = "GET" + strconv.FormatUint(as.grpcH.incTxMsgCnt(), 10)
 	in.Path = path
-	log.Println("Sending getparam request to Controller , path:", path)
-	out, err := as.grpcH.intf.GetParamReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending getparam request to Controller, path: %s", path)
+	out, err := as.grpcH.intf.GetParamReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		errMsg := out.GetErrMsg()
 		log.Printf("Result: %v\n", errMsg)
-		return errors.New(errMsg)
+		return errControllerFailure("%s", errMsg)
 	}
 	return nil
 }
 
-func (as *ApiServer) CntlrGetInstancesReq(epId string, objPath string, firstLevelOnly bool) error {
+func (as *ApiServer) CntlrGetInstancesReq(ctx context.Context, epId string, objPath string, firstLevelOnly bool) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var in cntlrgrpc.GetInstancesReqData
 	in.AgentId = epId
 	in.MsgId = "GET_INST" + strconv.FormatUint(as.grpcH.incTxMsgCnt(), 10)
 	in.Path = objPath
 	in.FirstLevelOnly = firstLevelOnly
-	log.Println("Sending GetInstance request to Controller, path:", objPath)
-	out, err := as.grpcH.intf.GetInstancesReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending GetInstance request to Controller, path: %s", objPath)
+	out, err := as.grpcH.intf.GetInstancesReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		log.Printf("Error: %v", out.GetErrMsg())
-		return errors.New(out.GetErrMsg())
+		return errControllerFailure("%s", out.GetErrMsg())
 	}
 	return nil
 }
 
-func (as *ApiServer) CntlrAddInstanceReq(epId string, objs []*object) ([]*Instance, error) {
+func (as *ApiServer) CntlrAddInstanceReq(ctx context.Context, epId string, objs []*object) ([]*Instance, error) {
 	if as.grpcH.intf == nil {
-		return nil, errors.New("Controller is not connected")
+		return nil, errCntlrNotConnected
 	}
 	var in cntlrgrpc.AddInstanceReqData
 
@@ -164,8 +189,8 @@ func (as *ApiServer) CntlrAddInstanceReq(epId string, objs []*object) ([]*Instan
 	}
 
 	var instances []*Instance
-	log.Println("Sending AddInstance request to Controller")
-	out, err := as.grpcH.intf.AddInstanceReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending AddInstance request to Controller")
+	out, err := as.grpcH.intf.AddInstanceReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 		return nil, err
@@ -180,15 +205,15 @@ func (as *ApiServer) CntlrAddInstanceReq(epId string, objs []*object) ([]*Instan
 			}
 		} else {
 			log.Println("Error from Agent:", out.GetErrMsg())
-			return nil, errors.New(out.GetErrMsg())
+			return nil, errControllerFailure("%s", out.GetErrMsg())
 		}
 	}
 	return instances, nil
 }
 
-func (as *ApiServer) CntlrOperateReq(epId string, cmd string, cmdKey string, resp bool, inputs map[string]string) error {
+func (as *ApiServer) CntlrOperateReq(ctx context.Context, epId string, cmd string, cmdKey string, resp bool, inputs map[string]string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var in cntlrgrpc.OperateReqData
 	in.AgentId = epId
@@ -197,21 +222,21 @@ func (as *ApiServer) CntlrOperateReq(epId string, cmd string, cmdKey string, res
 	in.CmdKey = cmdKey
 	in.Resp = resp
 	in.Inputs = inputs
-	log.Println("Sending Operate request to Controller, cmd:", cmd)
-	out, err := as.grpcH.intf.OperateReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending Operate request to Controller, cmd: %s", cmd)
+	out, err := as.grpcH.intf.OperateReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		log.Printf("Error: %v", out.GetErrMsg())
-		return errors.New(out.GetErrMsg())
+		return errControllerFailure("%s", out.GetErrMsg())
 	}
 	return nil
 }
 
-func (as *ApiServer) CntlrGetDatamodelReq(epId string, path string) error {
+func (as *ApiServer) CntlrGetDatamodelReq(ctx context.Context, epId string, path string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var in cntlrgrpc.GetDatamodelReqData
 	in.AgentId = epId
@@ -220,53 +245,53 @@ func (as *ApiServer) CntlrGetDatamodelReq(epId string, path string) error {
 	in.RetCmd = true
 	in.RetEvents = true
 	in.RetParams = true
-	log.Println("Sending Get Datamodel request to Controller, path:", path)
-	out, err := as.grpcH.intf.GetDatamodelReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending Get Datamodel request to Controller, path: %s", path)
+	out, err := as.grpcH.intf.GetDatamodelReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		log.Printf("Error: %v", out.GetErrMsg())
-		return errors.New(out.GetErrMsg())
+		return errControllerFailure("%s", out.GetErrMsg())
 	}
 	return nil
 }
 
-func (as *ApiServer) CntlrDeleteInstanceReq(epId string, objPath string) error {
+func (as *ApiServer) CntlrDeleteInstanceReq(ctx context.Context, epId string, objPath string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 
 	var in cntlrgrpc.DeleteInstanceReqData
 	in.AgentId = epId
 	in.MsgId = "DELETE_" + strconv.FormatUint(as.grpcH.incTxMsgCnt(), 10)
 	in.ObjPath = objPath
-	log.Println("Sending Delete Instance request to Controller")
-	out, err := as.grpcH.intf.DeleteInstanceReq(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending Delete Instance request to Controller")
+	out, err := as.grpcH.intf.DeleteInstanceReq(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		log.Printf("Error: %v", out.GetErrMsg())
-		return errors.New(out.GetErrMsg())
+		return errControllerFailure("%s", out.GetErrMsg())
 	}
 	return nil
 }
 
-func (as *ApiServer) CntlrGetAgentMsgs(epId string) error {
+func (as *ApiServer) CntlrGetAgentMsgs(ctx context.Context, epId string) error {
 	if as.grpcH.intf == nil {
-		return errors.New("Controller is not connected")
+		return errCntlrNotConnected
 	}
 	var in cntlrgrpc.GetAgentMsgsData
 	in.AgentId = epId
-	log.Println("Sending get agent msg request to Controller")
-	out, err := as.grpcH.intf.GetAgentMsgs(context.Background(), &in)
+	logging.FromContext(ctx).Infof("Sending get agent msg request to Controller")
+	out, err := as.grpcH.intf.GetAgentMsgs(ctx, &in)
 	if err != nil {
 		log.Println("gRPC error: ", err)
 	}
 	if !out.GetIsSuccess() {
 		log.Printf("Error: %v", out.GetErrMsg())
-		return errors.New(out.GetErrMsg())
+		return errControllerFailure("%s", out.GetErrMsg())
 	}
 	return nil
 }
@@ -15,8 +15,8 @@
 package apiserver
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 
@@ -24,6 +24,7 @@ import (
 )
 
 type uspData struct {
+	ctx    context.Context // carries the request ID to the controller
 	epId   string
 	path   string
 	params map[string]string
@@ -61,7 +62,7 @@ func (as *ApiServer) operateCmd(w http.ResponseWriter, r *http.Request) {
 	if d, err := parseUspReq(r); err != nil {
 		httpSendRes(w, nil, err)
 	} else {
-		err := as.CntlrOperateReq(d.epId, d.path, "none", true, d.params)
+		err := as.CntlrOperateReq(d.ctx, d.epId, d.path, "none", true, d.params)
 		httpSendRes(w, nil, err)
 	}
 }
@@ -125,7 +126,7 @@ func (as *ApiServer) deleteDbColl(w http.ResponseWriter, r *http.Request) {
 
 	if collName, ok = vars["coll"]; !ok {
 		log.Println("Collection name not found in the request")
-		httpSendRes(w, nil, errors.New("Collection name not found in the request"))
+		httpSendRes(w, nil, errBadRequest("Collection name not found in the request"))
 	}
 	log.Println("Collection to be deleted:", collName)
 	err := as.dbDeleteColl(collName)
@@ -151,6 +152,7 @@ func (as *ApiServer) getParams(w http.ResponseWriter, r *http.Request) {
 	d, err := parseUspReq(r)
 	if err != nil {
 		httpSendRes(w, nil, err)
+		return
 	}
 	objs, err := as.getMultipleObjParams(d)
 	httpSendRes(w, objs, err)
@@ -168,17 +170,17 @@ func (as *ApiServer) getInstances(w http.ResponseWriter, r *http.Request) {
 func parseUspReq(r *http.Request) (*uspData, error) {
 	vars := mux.Vars(r)
 	var ok bool
-	usp := &uspData{}
+	usp := &uspData{ctx: r.Context()}
 
 	if usp.epId, ok = vars["epId"]; !ok {
 		log.Println("EpId not found in the request")
-		return nil, errors.New("EpId not found in the request")
+		return nil, errBadRequest("EpId not found in the request")
 	}
 	log.Println("getDm EpId:", usp.epId)
 
 	if usp.path, ok = vars["path"]; !ok {
 		log.Println("Path not found in the request")
-		return nil, errors.New("Path not found in the request")
+		return nil, errBadRequest("Path not found in the request")
 	}
 	if r.Method == "POST" {
 		decoder := json.NewDecoder(r.Body)
@@ -194,7 +196,7 @@ func httpSendRes(w http.ResponseWriter, objs interface{}, err error) {
 	//w.Header().Set("Access-Control-Allow-Headers", "*") // require for UI to avoid CORS Policy
 
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		httpSendProblem(w, problemFromError(err))
 		return
 	}
 
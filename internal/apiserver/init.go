@@ -15,16 +15,21 @@
 package apiserver
 
 import (
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
 	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/debugserver"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"github.com/n4-networks/openusp/internal/cwmp"
 	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/quota"
 	"github.com/n4-networks/openusp/pkg/pb/cntlrgrpc"
 	"go.mongodb.org/mongo-driver/mongo"
 	"google.golang.org/grpc"
@@ -32,13 +37,15 @@ import (
 
 type apiServerCfg struct {
 	httpPort    string
+	httpsPort   string
+	isHttpOn    bool
 	isTlsOn     bool
+	tlsFiles    tlsFiles
 	cntlrAddr   string
 	dbAddr      string
 	dbUserName  string
 	dbPasswd    string
 	connTimeout time.Duration
-	logSetting  string
 }
 
 type grpcHandle struct {
@@ -63,10 +70,41 @@ type ApiServer struct {
 	dbH    dbHandle
 	cfg    apiServerCfg
 	config *config.Config
-	router *mux.Router
+	// rootRouter serves the service endpoints and the API versions,
+	// router the v1 API
+	rootRouter *mux.Router
+	router     *mux.Router
+	quota  *quota.Manager
+	// metrics are served on /metrics
+	metrics *apiMetrics
+	// rateLimits limits the request rate of the client IPs and users
+	rateLimits *rateLimits
+	// connReq sends the connection requests triggered through the API
+	connReq *cwmp.ConnRequestClient
+	// bus sends the CWMP RPCs requested through the API to the ACS
+	bus *acsbus.Client
+	// events fans out the device events streamed on /ws/events
+	events *eventHub
+	// webhooks delivers the device events to the subscribed webhooks
+	webhooks *webhookDispatcher
+	// campaignJobs holds the quota job slots of the running campaigns
+	campaignJobsMu sync.Mutex
+	campaignJobs   map[string]func()
+
+	// stopping is closed when Stop is called, stopped once it returned
+	stopping    chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+	stoppedOnce sync.Once
+	serversMu   sync.Mutex
+	servers     []*http.Server
+	// background tracks the jobs Stop waits for
+	background sync.WaitGroup
 }
 
 func (as *ApiServer) Init() error {
+	as.stopping = make(chan struct{})
+	as.stopped = make(chan struct{})
 
 	log.Println("Running Api Server version:", getVer())
 
@@ -76,17 +114,41 @@ func (as *ApiServer) Init() error {
 		return err
 	}
 
-	// Initialize logging
-	log.Println("Initializing logging module...")
-	if err := as.loggingInit(); err != nil {
-		log.Println("Logging settings could not be applied")
-	}
+	// Register the metrics before the clients they instrument connect
+	as.initMetrics()
+
 	// Connect o Db
 	log.Println("Connecting to DB server @", as.cfg.dbAddr)
 	if err := as.connectDb(); err != nil {
 		log.Println("Error in connecting to DB:", err)
 	}
 
+	// Set up per-tenant quotas
+	as.initQuota()
+	as.initRateLimits()
+
+	as.connReq = cwmp.NewConnRequestClient(as.config.Protocols.CWMP.ConnectionRequest)
+
+	log.Println("Connecting to ACS bus...")
+	if err := as.connectAcsBus(); err != nil {
+		log.Println("Error in connecting to ACS bus:", err)
+	}
+
+	// Schedule daily analytics rollups
+	as.startAnalyticsJobs()
+
+	// Poll device events for the clients of the event stream
+	as.startEventStream()
+
+	// Deliver device events to the webhooks
+	as.startWebhooks()
+
+	// Keep the group members of the devices in sync for the presets
+	as.startGroupSync()
+
+	// Run the firmware campaigns
+	as.startFirmwareCampaigns()
+
 	// Connect to Controller
 	log.Println("Connecting to Controller @", as.cfg.cntlrAddr)
 	if err := as.connectToController(); err != nil {
@@ -95,6 +157,9 @@ func (as *ApiServer) Init() error {
 		log.Println("Connection to Controller...Success")
 	}
 
+	// Resume the bulk jobs a restart interrupted
+	as.resumeBulkJobs()
+
 	// Initialize Router
 	if err := as.initRouter(); err != nil {
 		log.Println("Error in initializing Router:", err)
@@ -115,9 +180,28 @@ func (as *ApiServer) loadConfig() error {
 	
 	as.config = cfg
 
+	if err := logging.Setup(cfg.Logging); err != nil {
+		log.Printf("Error applying logging config: %v", err)
+	}
+	if err := debugserver.Start(cfg.Debug, "apiserver"); err != nil {
+		log.Printf("Error starting debug server: %v", err)
+	}
+
 	// Map YAML config to legacy apiServerCfg struct for backward compatibility
 	as.cfg.httpPort = strconv.Itoa(cfg.Protocols.HTTP.Port)
 	as.cfg.isTlsOn = cfg.Protocols.HTTP.EnableTLS
+	// Plain HTTP is turned off only when HTTPS serves the API instead
+	as.cfg.isHttpOn = cfg.Protocols.HTTP.Enabled || !as.cfg.isTlsOn
+	as.cfg.httpsPort = strconv.Itoa(cfg.Protocols.HTTP.TLSPort)
+	if cfg.Protocols.HTTP.TLSPort == 0 {
+		as.cfg.httpsPort = "8443"
+	}
+	if as.cfg.isTlsOn {
+		if as.cfg.tlsFiles, err = newTLSFiles(cfg); err != nil {
+			log.Println("Error in TLS configuration:", err)
+			return err
+		}
+	}
 	as.cfg.cntlrAddr = cfg.GetGRPCAddress()
 	as.cfg.dbAddr = fmt.Sprintf("%s:%d", cfg.Database.Host, cfg.Database.Port)
 	as.cfg.dbUserName = cfg.Database.Username
@@ -128,31 +212,12 @@ func (as *ApiServer) loadConfig() error {
 	} else {
 		as.cfg.connTimeout = 10 * time.Second
 	}
-	as.cfg.logSetting = cfg.Logging.Level
 
 	// Set up authentication users from config
-	if cfg.Security.Auth.Username == "" || cfg.Security.Auth.Password == "" {
-		log.Println("Authentication credentials are not set in config")
-		return errors.New("authentication credentials not configured")
+	if err := configureAuth(cfg.Security.Auth); err != nil {
+		log.Println("Authentication credentials are not set in config:", err)
+		return err
 	}
-	users[cfg.Security.Auth.Username] = cfg.Security.Auth.Password
 
 	return nil
 }
-
-func (as *ApiServer) loggingInit() error {
-	log.SetPrefix("OpenUSP: ")
-	switch as.cfg.logSetting {
-	case "short":
-		log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime)
-	case "long":
-		log.SetFlags(log.Llongfile | log.Ldate | log.Ltime)
-	case "all":
-		log.Println("Setting log for all")
-		log.SetFlags(log.Lshortfile | log.Llongfile | log.Ldate | log.Ltime)
-	default:
-		log.SetFlags(0)
-		log.SetOutput(ioutil.Discard)
-	}
-	return nil
-}
@@ -0,0 +1,75 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestLogPrintfToConfiguredFile checks that once the config is loaded, as in
+// Init, the log.Printf calls of the API server reach the configured log file
+func TestLogPrintfToConfiguredFile(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "logs", "apiserver.log")
+	cfg := `security:
+  auth:
+    username: "admin"
+    password: "admin"
+logging:
+  level: "info"
+  format: "text"
+  output: "file"
+  file: "` + logFile + `"
+`
+	if err := os.MkdirAll(filepath.Join(dir, "configs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "configs", "apiserver.yaml"), []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	out, flags, prefix := log.Writer(), log.Flags(), log.Prefix()
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		log.SetOutput(out)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	})
+
+	as := &ApiServer{}
+	if err := as.loadConfig(); err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	log.Printf("Connecting to DB server @ %s", as.cfg.dbAddr)
+
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("reading log file: %v", err)
+	}
+	if !strings.Contains(string(data), "INFO  init_test.go:") || !strings.Contains(string(data), "Connecting to DB server @") {
+		t.Errorf("log file does not have the log.Printf record:\n%s", data)
+	}
+}
@@ -15,7 +15,6 @@
 package apiserver
 
 import (
-	"errors"
 	"log"
 )
 
@@ -26,7 +25,7 @@ type Instance struct {
 
 func (as *ApiServer) getInstanceObjs(epId string, objPath string) ([]*Instance, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Error: DB interface has not been initilized")
+		return nil, errUnavailable("Error: DB interface has not been initilized")
 	}
 	dmPath := getDmPathFromAbsPath(objPath)
 	dm, err := as.dbH.uspIntf.GetDm(epId, dmPath)
@@ -35,7 +34,7 @@ func (as *ApiServer) getInstanceObjs(epId string, objPath string) ([]*Instance,
 		return nil, err
 	}
 	if !dm.MultiInstance {
-		return nil, errors.New("Not a multi instance object")
+		return nil, errBadRequest("Not a multi instance object")
 	}
 
 	regexPath := objPath + "[0-9]+."
@@ -62,7 +61,7 @@ func (as *ApiServer) addInstanceObj(d *uspData) (*Instance, error) {
 	obj.path = d.path
 	obj.params = d.params
 	objs = append(objs, obj)
-	insts, err := as.CntlrAddInstanceReq(d.epId, objs)
+	insts, err := as.CntlrAddInstanceReq(d.ctx, d.epId, objs)
 	if err != nil {
 		return nil, err
 	}
@@ -72,9 +71,9 @@ func (as *ApiServer) addInstanceObj(d *uspData) (*Instance, error) {
 }
 
 func (as *ApiServer) updateInstancesObjs(d *uspData) error {
-	return as.CntlrGetInstancesReq(d.epId, d.path, false)
+	return as.CntlrGetInstancesReq(d.ctx, d.epId, d.path, false)
 }
 
 func (as *ApiServer) deleteInstancesObjs(d *uspData) error {
-	return as.CntlrDeleteInstanceReq(d.epId, d.path)
+	return as.CntlrDeleteInstanceReq(d.ctx, d.epId, d.path)
 }
@@ -0,0 +1,120 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// Bounds of the timeout of a live parameter read
+const (
+	defaultLiveReadTimeout = 30 * time.Second
+	maxLiveReadTimeout     = 5 * time.Minute
+)
+
+// queryTimeout parses the timeout query parameter of a live read, a
+// duration such as 30s
+func queryTimeout(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("timeout")
+	if s == "" {
+		return defaultLiveReadTimeout, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout <= 0 {
+		return 0, errBadRequest("invalid timeout: %s", s)
+	}
+	if timeout > maxLiveReadTimeout {
+		timeout = maxLiveReadTimeout
+	}
+	return timeout, nil
+}
+
+// getCwmpLiveParams reads the parameters from the device rather than
+// answering from the database: a GetParameterValues is sent, the device
+// being asked to connect if it has no session open, and the values it
+// answered are returned once the ACS stored them. Without parameter names
+// the whole data model is read.
+func (as *ApiServer) getCwmpLiveParams(w http.ResponseWriter, r *http.Request, deviceId string, names []string) {
+	timeout, err := queryTimeout(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if len(names) == 0 {
+		if device.DataModelRoot == "" {
+			httpSendRes(w, nil, errBadRequest("the data model of device %s is not known yet, list the parameters to read", deviceId))
+			return
+		}
+		names = []string{device.DataModelRoot}
+	}
+
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	cmd := &acsbus.Command{
+		DeviceID:       deviceId,
+		Method:         acsbus.MethodGetParameterValues,
+		ParameterNames: names,
+		RequestID:      requestID,
+	}
+	if err := as.sendAcsCommand(cmd); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Reading %d parameter(s) live from device %s", len(names), deviceId)
+
+	event, err := as.waitCwmpDeviceEvent(r.Context(), deviceId, cwmp.DeviceEventParametersRead, requestID, timeout)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if event == nil {
+		httpSendRes(w, nil, errDeviceTimeout("device %s did not answer within %s, the read stays queued for its next session", deviceId, timeout))
+		return
+	}
+	if event.Details["result"] == cwmp.ReadResultFault {
+		httpSendRes(w, nil, errAcsFault(acsbus.MethodGetParameterValues, deviceId, event.Details["fault_code"], event.Details["fault_string"]))
+		return
+	}
+	dbParams, err := as.dbH.cwmpIntf.GetCwmpParametersByName(deviceId, names)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	parameters := make([]cwmp.ParameterValueStruct, 0, len(dbParams))
+	for _, p := range dbParams {
+		parameters = append(parameters, cwmp.ParameterValueStruct{Name: p.Path, Value: p.Value, Type: p.Type})
+	}
+
+	httpSendRes(w, map[string]interface{}{
+		"device_id":  deviceId,
+		"parameters": parameters,
+		"timestamp":  event.Timestamp.Format(time.RFC3339),
+		"count":      len(parameters),
+		"source":     "device",
+		"request_id": requestID,
+	}, nil)
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/metrics"
+	"go.mongodb.org/mongo-driver/bson"
+	mongoevent "go.mongodb.org/mongo-driver/event"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const METRICS = "/metrics"
+
+// deviceGaugeTTL is how long the device counts are cached between scrapes
+const deviceGaugeTTL = 30 * time.Second
+
+// apiMetrics are the metrics of the API server served on /metrics
+type apiMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.CounterVec
+	requestDuration *metrics.HistogramVec
+	inFlight        *metrics.GaugeVec
+	grpcCalls       *metrics.CounterVec
+	grpcDuration    *metrics.HistogramVec
+	dbCommands      *metrics.HistogramVec
+	// dbCollections maps the request ID of the running DB commands to
+	// their collection, which the finished events do not carry
+	dbCollections sync.Map
+
+	mu            sync.Mutex
+	countedAt     time.Time
+	devicesTotal  float64
+	devicesOnline float64
+}
+
+// initMetrics registers the metrics and instruments the DB client, it runs
+// before the DB and controller connections are made
+func (as *ApiServer) initMetrics() {
+	r := metrics.NewRegistry()
+	m := &apiMetrics{
+		registry: r,
+		requests: r.NewCounterVec("openusp_api_requests_total",
+			"API requests by method, route and status code", "method", "route", "code"),
+		requestDuration: r.NewHistogramVec("openusp_api_request_duration_seconds",
+			"API request latency by method and route", metrics.DefBuckets, "method", "route"),
+		inFlight: r.NewGaugeVec("openusp_api_requests_in_flight",
+			"API requests being served"),
+		grpcCalls: r.NewCounterVec("openusp_grpc_client_calls_total",
+			"Controller gRPC calls by method and status code", "method", "code"),
+		grpcDuration: r.NewHistogramVec("openusp_grpc_client_call_duration_seconds",
+			"Controller gRPC call latency by method", metrics.DefBuckets, "method"),
+		dbCommands: r.NewHistogramVec("openusp_db_command_duration_seconds",
+			"Database command latency by command, collection and outcome", metrics.DefBuckets,
+			"command", "collection", "outcome"),
+	}
+	r.NewGaugeFunc("openusp_cwmp_devices", "CWMP devices known to the ACS", func() float64 {
+		total, _ := as.cwmpDeviceCounts()
+		return total
+	})
+	r.NewGaugeFunc("openusp_cwmp_devices_online",
+		"CWMP devices whose last Inform is within the online window", func() float64 {
+			_, online := as.cwmpDeviceCounts()
+			return online
+		})
+	r.NewGaugeVec("openusp_build_info", "Version of the API server", "version").Set(1, getVer())
+	r.NewGaugeFunc("go_goroutines", "Number of goroutines", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	as.metrics = m
+
+	db.SetCommandMonitor(&mongoevent.CommandMonitor{
+		Started: func(_ context.Context, e *mongoevent.CommandStartedEvent) {
+			m.dbCollections.Store(e.RequestID, commandCollection(e))
+		},
+		Succeeded: func(_ context.Context, e *mongoevent.CommandSucceededEvent) {
+			m.observeDbCommand(e.CommandFinishedEvent, "success")
+		},
+		Failed: func(_ context.Context, e *mongoevent.CommandFailedEvent) {
+			m.observeDbCommand(e.CommandFinishedEvent, "failure")
+		},
+	})
+}
+
+func (as *ApiServer) setMetricsRoutesHandlers() {
+	as.rootRouter.Handle(METRICS, as.metrics.registry.Handler()).Methods("GET")
+}
+
+// commandCollection returns the collection a DB command operates on
+func commandCollection(e *mongoevent.CommandStartedEvent) string {
+	if coll, ok := e.Command.Lookup(e.CommandName).StringValueOK(); ok {
+		return coll
+	}
+	// getMore names the collection apart from the cursor ID
+	if coll, ok := e.Command.Lookup("collection").StringValueOK(); ok {
+		return coll
+	}
+	return ""
+}
+
+func (m *apiMetrics) observeDbCommand(e mongoevent.CommandFinishedEvent, outcome string) {
+	coll, _ := m.dbCollections.LoadAndDelete(e.RequestID)
+	collName, _ := coll.(string)
+	m.dbCommands.Observe(e.Duration.Seconds(), e.CommandName, collName, outcome)
+}
+
+// grpcInterceptor times the calls to the controller
+func (m *apiMetrics) grpcInterceptor(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	m.grpcCalls.Inc(method, status.Code(err).String())
+	m.grpcDuration.Observe(time.Since(start).Seconds(), method)
+	return err
+}
+
+// cwmpDeviceCounts returns the total and online CWMP devices, counted at
+// most once per deviceGaugeTTL
+func (as *ApiServer) cwmpDeviceCounts() (float64, float64) {
+	m := as.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cwmpDb := as.dbH.cwmpIntf
+	if time.Since(m.countedAt) < deviceGaugeTTL || cwmpDb == nil {
+		return m.devicesTotal, m.devicesOnline
+	}
+
+	total, err := cwmpDb.CountCwmpDevices(bson.M{})
+	if err != nil {
+		log.Printf("Error counting CWMP devices for metrics: %v", err)
+		return m.devicesTotal, m.devicesOnline
+	}
+	online, err := cwmpDb.CountCwmpDevices(bson.M{"last_inform": bson.M{"$gt": time.Now().Add(-deviceOnlineWindow)}})
+	if err != nil {
+		log.Printf("Error counting online CWMP devices for metrics: %v", err)
+		return m.devicesTotal, m.devicesOnline
+	}
+	m.devicesTotal, m.devicesOnline = float64(total), float64(online)
+	m.countedAt = time.Now()
+	return m.devicesTotal, m.devicesOnline
+}
+
+// statusRecorder captures the status code of a response. It hijacks and
+// flushes through to the connection, for the WebSocket and SSE streams.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	http.NewResponseController(rec.ResponseWriter).Flush()
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil && rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middlewareMetrics counts and times the requests by route template, so that
+// the device IDs in the paths do not multiply the series
+func (as *ApiServer) middlewareMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := as.metrics
+		route := "unmatched"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tmpl, err := cr.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.requests.Inc(r.Method, route, strconv.Itoa(rec.status))
+		m.requestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
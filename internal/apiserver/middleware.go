@@ -17,19 +17,70 @@ package apiserver
 import (
 	"log"
 	"net/http"
+
+	"github.com/n4-networks/openusp/pkg/logging"
 )
 
+// maxRequestIDLen bounds the length of a request ID supplied by a client
+const maxRequestIDLen = 128
+
 func (as *ApiServer) setMiddlewares() error {
+	if cfg := as.config.Protocols.HTTP.Compression; cfg.Enabled {
+		log.Println("Registering middleware response compression")
+		as.rootRouter.Use(newResponseCompressor(cfg).middlewareCompress)
+	}
+	log.Println("Registering middleware metrics")
+	as.rootRouter.Use(as.middlewareMetrics)
+	log.Println("Registering middleware request ID")
+	as.rootRouter.Use(middlewareRequestID)
 	log.Println("Registering middleware logging")
-	as.router.Use(middlewareLogging)
+	as.rootRouter.Use(middlewareLogging)
+	log.Println("Registering middleware client rate limit")
+	as.rootRouter.Use(as.middlewareRateLimitIP)
 	log.Println("Registering middleware access control")
-	as.router.Use(middlewareUserAuth)
+	as.rootRouter.Use(middlewareUserAuth)
+	log.Println("Registering middleware audit")
+	as.rootRouter.Use(as.middlewareAudit)
+	log.Println("Registering middleware tenant scope")
+	as.rootRouter.Use(as.middlewareTenantScope)
+	log.Println("Registering middleware user rate limit")
+	as.rootRouter.Use(as.middlewareRateLimitUser)
+	log.Println("Registering middleware tenant quota")
+	as.rootRouter.Use(as.middlewareQuota)
 	return nil
 }
 
+// middlewareRequestID tags the request with the X-Request-ID supplied by the
+// client, or a generated one, and returns it in the response. The ID follows
+// the operator action to the controller and the ACS.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(logging.RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
+
+// validRequestID accepts IDs of printable ASCII characters only, so that a
+// client cannot inject log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
 func middlewareLogging(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("Middleware Logging: %v, %v\n", r.Method, r.RequestURI)
+		logging.FromContext(r.Context()).Infof("Middleware Logging: %v, %v", r.Method, r.RequestURI)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,199 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+const (
+	CWMP_GET_WIFI = "/cwmp/device/{deviceId}/wifi"
+	CWMP_GET_WAN  = "/cwmp/device/{deviceId}/wan"
+)
+
+// CwmpWiFiNetwork is an SSID of a device with the settings of its radio and
+// access point
+type CwmpWiFiNetwork struct {
+	Instance          int    `json:"instance"`
+	SSID              string `json:"ssid"`
+	Enable            string `json:"enable,omitempty"`
+	Status            string `json:"status,omitempty"`
+	BSSID             string `json:"bssid,omitempty"`
+	Channel           string `json:"channel,omitempty"`
+	AutoChannelEnable string `json:"auto_channel_enable,omitempty"`
+	Standards         string `json:"standards,omitempty"`
+	SecurityMode      string `json:"security_mode,omitempty"`
+	Advertised        string `json:"advertised,omitempty"`
+	AssociatedDevices string `json:"associated_devices,omitempty"`
+}
+
+// CwmpWiFiStatus lists the WiFi networks of a device
+type CwmpWiFiStatus struct {
+	DeviceID  string            `json:"device_id"`
+	DataModel string            `json:"data_model"`
+	Networks  []CwmpWiFiNetwork `json:"networks"`
+}
+
+// CwmpWANStatus is the status of the WAN connection of a device
+type CwmpWANStatus struct {
+	DeviceID       string `json:"device_id"`
+	DataModel      string `json:"data_model"`
+	Status         string `json:"status,omitempty"`
+	IPAddress      string `json:"ip_address,omitempty"`
+	SubnetMask     string `json:"subnet_mask,omitempty"`
+	AddressingType string `json:"addressing_type,omitempty"`
+	DefaultGateway string `json:"default_gateway,omitempty"`
+	MACAddress     string `json:"mac_address,omitempty"`
+	LastChange     string `json:"last_change,omitempty"` // seconds since the status changed
+}
+
+func (as *ApiServer) setCwmpNetStatusRoutesHandlers() {
+	as.router.HandleFunc(CWMP_GET_WIFI, as.getCwmpWiFi).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_WAN, as.getCwmpWAN).Methods("GET")
+}
+
+// getCwmpWiFi returns the WiFi networks of a device from its stored
+// parameters, whichever data model the device reports
+func (as *ApiServer) getCwmpWiFi(w http.ResponseWriter, r *http.Request) {
+	device, params, err := as.normalizedCwmpParams(mux.Vars(r)["deviceId"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	status := &CwmpWiFiStatus{DeviceID: device.ID, DataModel: device.DataModel, Networks: []CwmpWiFiNetwork{}}
+	for _, i := range paramInstances(params, "Device.WiFi.SSID.") {
+		ssid := "Device.WiFi.SSID." + strconv.Itoa(i) + "."
+		network := CwmpWiFiNetwork{
+			Instance: i,
+			SSID:     params[ssid+"SSID"],
+			Enable:   params[ssid+"Enable"],
+			Status:   params[ssid+"Status"],
+			BSSID:    params[ssid+"BSSID"],
+		}
+		// TR-181 links an SSID to its radio with LowerLayers and to its
+		// access point with SSIDReference, TR-098 devices share the instance
+		radio := "Device.WiFi.Radio." + strconv.Itoa(i) + "."
+		if lower := params[ssid+"LowerLayers"]; strings.HasPrefix(lower, "Device.WiFi.Radio.") {
+			radio = strings.TrimSuffix(strings.Split(lower, ",")[0], ".") + "."
+		}
+		ap := "Device.WiFi.AccessPoint." + strconv.Itoa(i) + "."
+		for _, j := range paramInstances(params, "Device.WiFi.AccessPoint.") {
+			candidate := "Device.WiFi.AccessPoint." + strconv.Itoa(j) + "."
+			if strings.TrimSuffix(params[candidate+"SSIDReference"], ".")+"." == ssid {
+				ap = candidate
+				break
+			}
+		}
+		network.Channel = params[radio+"Channel"]
+		network.AutoChannelEnable = params[radio+"AutoChannelEnable"]
+		network.Standards = params[radio+"OperatingStandards"]
+		network.SecurityMode = params[ap+"Security.ModeEnabled"]
+		network.Advertised = params[ap+"SSIDAdvertisementEnabled"]
+		network.AssociatedDevices = params[ap+"AssociatedDeviceNumberOfEntries"]
+		status.Networks = append(status.Networks, network)
+	}
+	httpSendRes(w, status, nil)
+}
+
+// getCwmpWAN returns the status of the WAN connection of a device from its
+// stored parameters, whichever data model the device reports
+func (as *ApiServer) getCwmpWAN(w http.ResponseWriter, r *http.Request) {
+	device, params, err := as.normalizedCwmpParams(mux.Vars(r)["deviceId"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	const ip = "Device.IP.Interface.1."
+	httpSendRes(w, &CwmpWANStatus{
+		DeviceID:       device.ID,
+		DataModel:      device.DataModel,
+		Status:         params[ip+"Status"],
+		IPAddress:      params[ip+"IPv4Address.1.IPAddress"],
+		SubnetMask:     params[ip+"IPv4Address.1.SubnetMask"],
+		AddressingType: params[ip+"IPv4Address.1.AddressingType"],
+		DefaultGateway: params["Device.Routing.Router.1.IPv4Forwarding.1.GatewayIPAddress"],
+		MACAddress:     params["Device.Ethernet.Link.1.MACAddress"],
+		LastChange:     params[ip+"LastChange"],
+	}, nil)
+}
+
+// normalizedCwmpParams returns a device with its parameters translated to
+// TR-181 paths and values, those received in Informs and those read with
+// GetParameterValues
+func (as *ApiServer) normalizedCwmpParams(deviceId string) (*db.CwmpDevice, map[string]string, error) {
+	if as.dbH.cwmpIntf == nil {
+		return nil, nil, errCwmpDbNotConnected
+	}
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		return nil, nil, err
+	}
+	stored, err := as.dbH.cwmpIntf.GetCwmpParametersByDeviceID(deviceId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := map[string]string{}
+	var paths []string
+	add := func(path string, value string) {
+		paths = append(paths, path)
+		if path, value, ok := datamodel.Normalize(path, value); ok {
+			params[path] = value
+		}
+	}
+	for path, value := range device.Parameters {
+		add(path, value)
+	}
+	for _, p := range stored {
+		add(p.Path, p.Value)
+	}
+	if device.DataModel == "" {
+		// Devices registered before the data model was recorded
+		device.DataModel = datamodel.Model(datamodel.DetectRoot(paths))
+	}
+	return device, params, nil
+}
+
+// paramInstances returns the instance numbers of the parameters under the
+// multi-instance object prefix, in ascending order
+func paramInstances(params map[string]string, prefix string) []int {
+	seen := map[int]bool{}
+	for path := range params {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		rest := path[len(prefix):]
+		if end := strings.IndexByte(rest, '.'); end > 0 {
+			if i, err := strconv.Atoi(rest[:end]); err == nil {
+				seen[i] = true
+			}
+		}
+	}
+	list := make([]int, 0, len(seen))
+	for i := range seen {
+		list = append(list, i)
+	}
+	sort.Ints(list)
+	return list
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const CWMP_DEVICE_OBJECT = "/cwmp/device/{deviceId}/object"
+
+// Default and maximum time an object request waits for the device to answer
+const (
+	defaultObjectWait = 30 * time.Second
+	maxObjectWait     = 5 * time.Minute
+)
+
+// objectPollInterval is how often the outcome of an object request is checked
+const objectPollInterval = 500 * time.Millisecond
+
+// CwmpObjectRequest asks to add an instance of a multi-instance object
+type CwmpObjectRequest struct {
+	ObjectName   string `json:"object_name"`
+	ParameterKey string `json:"parameter_key"`
+}
+
+// CwmpObjectResult is the outcome of an AddObject or DeleteObject, its
+// status is queued when the device did not answer within the wait time
+type CwmpObjectResult struct {
+	DeviceID       string `json:"device_id"`
+	ObjectName     string `json:"object_name"`
+	Instance       string `json:"instance,omitempty"`
+	InstanceNumber uint32 `json:"instance_number,omitempty"`
+	Status         string `json:"status"`
+	RequestID      string `json:"request_id"`
+	TaskID         string `json:"task_id,omitempty"`
+}
+
+func (as *ApiServer) setCwmpObjectRoutesHandlers() {
+	as.router.HandleFunc(CWMP_DEVICE_OBJECT, as.addCwmpObject).Methods("POST")
+	as.router.HandleFunc(CWMP_DEVICE_OBJECT, as.deleteCwmpObject).Methods("DELETE")
+}
+
+// addCwmpObject creates an instance of a multi-instance object, such as
+// Device.WiFi.SSID., and reports the instance number the device assigned
+func (as *ApiServer) addCwmpObject(w http.ResponseWriter, r *http.Request) {
+	var req CwmpObjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if !strings.HasSuffix(req.ObjectName, ".") || isInstancePath(req.ObjectName) {
+		httpSendRes(w, nil, errBadRequest("object_name must be a multi-instance object path ending with a dot, e.g. Device.WiFi.SSID."))
+		return
+	}
+	as.runCwmpObjectRPC(w, r, acsbus.MethodAddObject, req)
+}
+
+// deleteCwmpObject deletes the object instance given by the object_name
+// query parameter, such as Device.WiFi.SSID.2.
+func (as *ApiServer) deleteCwmpObject(w http.ResponseWriter, r *http.Request) {
+	req := CwmpObjectRequest{
+		ObjectName:   r.URL.Query().Get("object_name"),
+		ParameterKey: r.URL.Query().Get("parameter_key"),
+	}
+	if !isInstancePath(req.ObjectName) {
+		httpSendRes(w, nil, errBadRequest("object_name must be an object instance path ending with a dot, e.g. Device.WiFi.SSID.2."))
+		return
+	}
+	as.runCwmpObjectRPC(w, r, acsbus.MethodDeleteObject, req)
+}
+
+// runCwmpObjectRPC sends an AddObject or DeleteObject to the ACS and waits
+// for the device to answer it
+func (as *ApiServer) runCwmpObjectRPC(w http.ResponseWriter, r *http.Request, method string, req CwmpObjectRequest) {
+	deviceId := mux.Vars(r)["deviceId"]
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	wait, err := queryWait(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if _, err := as.loadCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	cmd := &acsbus.Command{
+		DeviceID:     deviceId,
+		Method:       method,
+		ObjectName:   req.ObjectName,
+		ParameterKey: req.ParameterKey,
+		RequestID:    requestID,
+	}
+	release, err := as.acquireDeviceJob(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if err := as.sendAcsCommand(cmd); err != nil {
+		release()
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Sent %s of %s to device %s", method, req.ObjectName, deviceId)
+
+	result := &CwmpObjectResult{
+		DeviceID:   deviceId,
+		ObjectName: req.ObjectName,
+		Status:     "queued",
+		RequestID:  requestID,
+		TaskID:     as.createCwmpTask(deviceId, method, requestID),
+	}
+	as.releaseWithTasks(release, result.TaskID)
+	eventCode := cwmp.DeviceEventObjectAdded
+	if method == acsbus.MethodDeleteObject {
+		eventCode = cwmp.DeviceEventObjectDeleted
+	}
+	event, err := as.waitCwmpDeviceEvent(r.Context(), deviceId, eventCode, requestID, wait)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if event == nil {
+		httpSendRes(w, result, nil)
+		return
+	}
+	if event.Details["result"] == cwmp.ObjectResultFault {
+		httpSendRes(w, nil, errAcsFault(method, deviceId, event.Details["fault_code"], event.Details["fault_string"]))
+		return
+	}
+	result.Status = event.Details["result"]
+	if n, err := strconv.ParseUint(event.Details["instance_number"], 10, 32); err == nil {
+		result.InstanceNumber = uint32(n)
+		result.Instance = event.Details["instance"]
+	}
+	httpSendRes(w, result, nil)
+}
+
+// waitCwmpDeviceEvent waits up to wait for the ACS to record the event
+// eventCode of the request requestID, it returns nil if it was not recorded
+// in time
+func (as *ApiServer) waitCwmpDeviceEvent(ctx context.Context, deviceId string, eventCode string, requestID string, wait time.Duration) (*db.CwmpEvent, error) {
+	filter := bson.M{
+		"device_id":          deviceId,
+		"event_code":         eventCode,
+		"details.request_id": requestID,
+	}
+	deadline := time.Now().Add(wait)
+	ticker := time.NewTicker(objectPollInterval)
+	defer ticker.Stop()
+	for {
+		events, err := as.dbH.cwmpIntf.GetCwmpDeviceEvents(filter, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) > 0 {
+			return &events[0], nil
+		}
+		if time.Now().After(deadline) {
+			return nil, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// queryWait parses the wait query parameter, the number of seconds a
+// request waits for the device to answer
+func queryWait(r *http.Request) (time.Duration, error) {
+	s := r.URL.Query().Get("wait")
+	if s == "" {
+		return defaultObjectWait, nil
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil || secs < 0 {
+		return 0, errBadRequest("invalid wait: %s", s)
+	}
+	wait := time.Duration(secs) * time.Second
+	if wait > maxObjectWait {
+		wait = maxObjectWait
+	}
+	return wait, nil
+}
+
+// isInstancePath reports whether path is an object instance path, i.e. ends
+// with an instance number followed by a dot
+func isInstancePath(path string) bool {
+	if !strings.HasSuffix(path, ".") {
+		return false
+	}
+	trimmed := strings.TrimSuffix(path, ".")
+	last := trimmed[strings.LastIndex(trimmed, ".")+1:]
+	_, err := strconv.ParseUint(last, 10, 32)
+	return err == nil
+}
@@ -0,0 +1,317 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/api"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/graphql"
+	"gopkg.in/yaml.v3"
+)
+
+const OPENAPI_SPEC = "/openapi.json"
+
+// openapiSchemas binds the component schemas of the OpenAPI document to the
+// Go types the API sends and receives
+var openapiSchemas = map[string]interface{}{
+	"Error":                    Problem{},
+	"CwmpDevice":               CwmpDeviceInfo{},
+	"CwmpDeviceSearch":         CwmpDeviceSearch{},
+	"CwmpDeviceList":           CwmpDeviceList{},
+	"Device":                   Device{},
+	"DeviceList":               DeviceList{},
+	"DeviceDeletion":           db.CwmpDeviceDeletion{},
+	"TagCount":                 db.TagCount{},
+	"DeviceTags":               CwmpDeviceTags{},
+	"TagRequest":               CwmpTagRequest{},
+	"DeviceTenant":             CwmpDeviceTenant{},
+	"TenantRequest":            CwmpTenantRequest{},
+	"DeviceGroupRequest":       DeviceGroupRequest{},
+	"DeviceGroup":              db.DeviceGroup{},
+	"CwmpCommandSubmission":    CwmpCommandSubmission{},
+	"CwmpObjectResult":         CwmpObjectResult{},
+	"CwmpQueuedTransfers":      CwmpQueuedTransfersResult{},
+	"FileTransfer":             CwmpTransferInfo{},
+	"ParameterValue":           CwmpParameterValue{},
+	"RebootRequest":            CwmpRebootRequest{},
+	"BulkRequest":              CwmpBulkRequest{},
+	"BulkPreview":              db.BulkPreview{},
+	"BulkJob":                  db.BulkJob{},
+	"BulkJobResult":            db.BulkJobResult{},
+	"PlannedRPC":               db.PlannedRPC{},
+	"CwmpCommand":              db.CwmpCommand{},
+	"CwmpTask":                 db.CwmpTask{},
+	"StreamEvent":              StreamEvent{},
+	"StreamFilter":             StreamFilter{},
+	"WebhookRequest":           WebhookRequest{},
+	"Webhook":                  db.Webhook{},
+	"WebhookDelivery":          db.WebhookDelivery{},
+	"AuditEntry":               db.AuditEntry{},
+	"StoredFile":               db.StoredFile{},
+	"PreRegistration":          db.CwmpPreRegistration{},
+	"ZtpTemplate":              db.ZtpTemplate{},
+	"Profile":                  db.CwmpProfile{},
+	"Preset":                   db.CwmpPreset{},
+	"ApplyRequest":             CwmpApplyRequest{},
+	"ApplyResult":              CwmpApplyResult{},
+	"SyncJob":                  db.CwmpSyncJob{},
+	"CredentialRotation":       db.CredentialRotation{},
+	"ConnectionRequestOutcome": db.ConnRequestOutcome{},
+	"ConnectionRequest":        db.CwmpConnRequest{},
+	"DiagnosticsRequest":       CwmpDiagnosticsRequest{},
+	"DiagnosticsResult":        CwmpDiagnosticsResult{},
+	"DeploymentUnit":           db.CwmpDeploymentUnit{},
+	"FirmwareJob":              db.FirmwareJob{},
+	"FirmwareCompatRule":       db.FirmwareCompatRule{},
+	"FirmwareImage":            db.FirmwareImage{},
+	"FirmwareCampaign":         db.FirmwareCampaign{},
+	"FirmwareCampaignRequest":  FirmwareCampaignRequest{},
+	"CampaignDevice":           db.CampaignDevice{},
+	"GraphQLRequest":           graphql.Request{},
+}
+
+var pathParamRe = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+// setOpenAPIRoutesHandlers serves the OpenAPI document of the API. It is
+// set last so that every route is registered when the document is built.
+func (as *ApiServer) setOpenAPIRoutesHandlers() error {
+	spec, err := as.buildOpenAPISpec()
+	if err != nil {
+		return err
+	}
+	as.rootRouter.HandleFunc(OPENAPI_SPEC, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}).Methods("GET")
+	return nil
+}
+
+// buildOpenAPISpec returns the OpenAPI document in JSON. The schemas bound
+// to Go types list the fields of the types, keeping the documentation of the
+// fields which are described, and the routes which are not described are
+// added so that the document covers every route.
+func (as *ApiServer) buildOpenAPISpec() ([]byte, error) {
+	var doc interface{}
+	if err := yaml.Unmarshal(api.Spec, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	spec, ok := jsonValue(doc).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid OpenAPI document")
+	}
+
+	components := childObject(spec, "components")
+	schemas := childObject(components, "schemas")
+	names := map[reflect.Type]string{}
+	for name, value := range openapiSchemas {
+		names[reflect.TypeOf(value)] = name
+	}
+	for name, value := range openapiSchemas {
+		generated := goTypeSchema(reflect.TypeOf(value), names, true)
+		if existing, ok := schemas[name].(map[string]interface{}); ok {
+			mergeSchema(existing, generated)
+		} else {
+			schemas[name] = generated
+		}
+	}
+
+	paths := childObject(spec, "paths")
+	err := as.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, API_V1+"/") {
+			return nil
+		}
+		// Paths are relative to the /api/v1 server URL
+		tmpl = apiPath(tmpl)
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		item := childObject(paths, pathParamRe.ReplaceAllString(tmpl, "{$1}"))
+		for _, method := range methods {
+			method = strings.ToLower(method)
+			if _, ok := item[method]; !ok {
+				item[method] = undocumentedOperation(tmpl, method)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(spec)
+}
+
+// undocumentedOperation describes a route missing from the OpenAPI document
+func undocumentedOperation(tmpl string, method string) map[string]interface{} {
+	params := []interface{}{}
+	for _, m := range pathParamRe.FindAllStringSubmatch(tmpl, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     m[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	return map[string]interface{}{
+		"summary":    strings.ToUpper(method) + " " + tmpl,
+		"parameters": params,
+		"responses": map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Response",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": map[string]interface{}{}},
+				},
+			},
+		},
+	}
+}
+
+// mergeSchema sets the properties of a described schema to those of the
+// generated schema, keeping the description of the properties it kept
+func mergeSchema(existing map[string]interface{}, generated map[string]interface{}) {
+	genProps, _ := generated["properties"].(map[string]interface{})
+	if genProps == nil {
+		return
+	}
+	props, _ := existing["properties"].(map[string]interface{})
+	merged := map[string]interface{}{}
+	for name, prop := range genProps {
+		if described, ok := props[name]; ok {
+			merged[name] = described
+		} else {
+			merged[name] = prop
+		}
+	}
+	existing["properties"] = merged
+	if required, ok := existing["required"].([]interface{}); ok {
+		kept := []interface{}{}
+		for _, name := range required {
+			if _, ok := merged[fmt.Sprint(name)]; ok {
+				kept = append(kept, name)
+			}
+		}
+		if len(kept) > 0 {
+			existing["required"] = kept
+		} else {
+			delete(existing, "required")
+		}
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// goTypeSchema returns the schema of a Go type as encoded in JSON. Named
+// types bound to a component schema are referenced, except at the top.
+func goTypeSchema(t reflect.Type, names map[reflect.Type]string, top bool) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if name, ok := names[t]; ok && !top {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return map[string]interface{}{"type": "array", "items": goTypeSchema(t.Elem(), names, false)}
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": goTypeSchema(t.Elem(), names, false)}
+	case t.Kind() == reflect.Struct:
+		props := map[string]interface{}{}
+		addStructProperties(t, names, props)
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	return map[string]interface{}{}
+}
+
+// addStructProperties adds the JSON fields of a struct, including those of
+// its embedded structs
+func addStructProperties(t reflect.Type, names map[reflect.Type]string, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addStructProperties(ft, names, props)
+				continue
+			}
+		}
+		if name == "" {
+			name = field.Name
+		}
+		props[name] = goTypeSchema(field.Type, names, false)
+	}
+}
+
+// jsonValue converts a decoded YAML value into values encoding/json accepts
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonValue(value)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonValue(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonValue(value)
+		}
+		return v
+	}
+	return v
+}
+
+// childObject returns the object under key, which is created if missing
+func childObject(parent map[string]interface{}, key string) map[string]interface{} {
+	if child, ok := parent[key].(map[string]interface{}); ok {
+		return child
+	}
+	child := map[string]interface{}{}
+	parent[key] = child
+	return child
+}
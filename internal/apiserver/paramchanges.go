@@ -0,0 +1,133 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_GET_PARAM_CHANGES = "/cwmp/device/{deviceId}/param-changes"
+	CWMP_GET_PARAM_HISTORY = "/cwmp/device/{deviceId}/params/history"
+)
+
+func (as *ApiServer) setCwmpParamChangeRoutesHandlers() {
+	as.router.HandleFunc(CWMP_GET_PARAM_CHANGES, as.getCwmpParameterChanges).Methods("GET")
+	as.router.HandleFunc(CWMP_GET_PARAM_HISTORY, as.getCwmpParameterHistory).Methods("GET")
+}
+
+// CwmpParameterValue is a value of a parameter at a point in time
+type CwmpParameterValue struct {
+	Path      string    `json:"path"`
+	Value     string    `json:"value"`
+	Type      string    `json:"type,omitempty"`
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// getCwmpParameterChanges returns the parameter history of a device, newest
+// first, optionally limited to a path prefix and to changes since a time
+func (as *ApiServer) getCwmpParameterChanges(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{"device_id": mux.Vars(r)["deviceId"]}
+	if path := r.URL.Query().Get("path"); path != "" {
+		filter["path"] = bson.M{"$regex": "^" + regexp.QuoteMeta(path)}
+	}
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		since, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid since, expected RFC3339: %s", sinceStr))
+			return
+		}
+		filter["changed_at"] = bson.M{"$gte": since}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	changes, err := as.dbH.cwmpIntf.GetCwmpParameterChanges(filter, limit)
+	httpSendRes(w, changes, err)
+}
+
+// getCwmpParameterHistory returns the values a parameter took over time,
+// newest first. A path ending with "." selects all parameters of the object.
+func (as *ApiServer) getCwmpParameterHistory(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		httpSendRes(w, nil, errBadRequest("path is required"))
+		return
+	}
+	filter := bson.M{"device_id": mux.Vars(r)["deviceId"], "path": path}
+	if strings.HasSuffix(path, ".") {
+		filter["path"] = bson.M{"$regex": "^" + regexp.QuoteMeta(path)}
+	}
+
+	changedAt := bson.M{}
+	for _, bound := range []struct{ name, op string }{{"from", "$gte"}, {"to", "$lte"}} {
+		value := query.Get(bound.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid %s, expected RFC3339: %s", bound.name, value))
+			return
+		}
+		changedAt[bound.op] = t
+	}
+	if len(changedAt) > 0 {
+		filter["changed_at"] = changedAt
+	}
+
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	changes, err := as.dbH.cwmpIntf.GetCwmpParameterChanges(filter, limit)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	history := make([]CwmpParameterValue, 0, len(changes))
+	for _, c := range changes {
+		history = append(history, CwmpParameterValue{
+			Path:      c.Path,
+			Value:     c.NewValue,
+			Type:      c.Type,
+			Source:    c.Source,
+			Timestamp: c.ChangedAt,
+		})
+	}
+	httpSendRes(w, history, nil)
+}
@@ -15,11 +15,11 @@
 package apiserver
 
 import (
-	"errors"
 	"log"
 	"regexp"
 
 	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/parser"
 )
 
 type ObjParam struct {
@@ -34,7 +34,15 @@ type Param struct {
 
 func (as *ApiServer) getMultipleObjParams(d *uspData) ([]*ObjParam, error) {
 	if as.dbH.uspIntf == nil {
-		return nil, errors.New("Error: DB interface has not been initilized")
+		return nil, errUnavailable("Error: DB interface has not been initilized")
+	}
+
+	expr, err := parser.ParsePathExpr(d.path)
+	if err != nil {
+		return nil, errBadRequest("%v", err)
+	}
+	if expr.IsSearch() || expr.Param != "" {
+		return as.getSearchPathParams(d, expr)
 	}
 
 	dmPath := getDmPathFromAbsPath(d.path)
@@ -87,7 +95,7 @@ func (as *ApiServer) getMultipleObjParams(d *uspData) ([]*ObjParam, error) {
 		}
 		return objs, nil
 	}
-	return nil, errors.New("Invalid path")
+	return nil, errBadRequest("Invalid path")
 }
 
 func (as *ApiServer) getSingleObjParams(epId string, path string, dm *db.DmObject) ([]*Param, error) {
@@ -114,9 +122,15 @@ func (as *ApiServer) getSingleObjParams(epId string, path string, dm *db.DmObjec
 }
 
 func (as *ApiServer) setParamsObj(d *uspData) error {
-	return as.CntlrSetParamReq(d.epId, d.path, d.params)
+	return as.CntlrSetParamReq(d.ctx, d.epId, d.path, d.params)
 }
 
+// updateParamsObjs requests the parameters of a path from the agent, which
+// resolves wildcards and search expressions itself
 func (as *ApiServer) updateParamsObjs(d *uspData) error {
-	return as.CntlrGetParamReq(d.epId, d.path)
+	expr, err := parser.ParsePathExpr(d.path)
+	if err != nil {
+		return errBadRequest("%v", err)
+	}
+	return as.CntlrGetParamReq(d.ctx, d.epId, expr.String())
 }
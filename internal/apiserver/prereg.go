@@ -0,0 +1,287 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_PREREG_IMPORT  = "/cwmp/preregistrations/import"
+	CWMP_GET_PREREGS    = "/cwmp/preregistrations/"
+	CWMP_DELETE_PREREG  = "/cwmp/preregistrations/{id}"
+	CWMP_GET_PENDING    = "/cwmp/pending/"
+	CWMP_DELETE_PENDING = "/cwmp/pending/{deviceId}"
+	CWMP_PROFILES       = "/cwmp/profiles/"
+	CWMP_PROFILE        = "/cwmp/profiles/{name}"
+)
+
+// maxPreRegImportSize limits the size of an uploaded pre-registration CSV
+const maxPreRegImportSize = 16 << 20
+
+// CwmpPreRegImportResult reports the outcome of a pre-registration import
+type CwmpPreRegImportResult struct {
+	Imported int      `json:"imported"`
+	Rejected []string `json:"rejected"`
+}
+
+func (as *ApiServer) setCwmpPreRegRoutesHandlers() {
+	as.router.HandleFunc(CWMP_PREREG_IMPORT, as.importCwmpPreRegistrations).Methods("POST")
+	as.router.HandleFunc(CWMP_GET_PREREGS, as.getCwmpPreRegistrations).Methods("GET")
+	as.router.HandleFunc(CWMP_DELETE_PREREG, as.deleteCwmpPreRegistration).Methods("DELETE")
+	as.router.HandleFunc(CWMP_GET_PENDING, as.getCwmpPendingDevices).Methods("GET")
+	as.router.HandleFunc(CWMP_DELETE_PENDING, as.deleteCwmpPendingDevice).Methods("DELETE")
+	as.router.HandleFunc(CWMP_PROFILES, as.getCwmpProfiles).Methods("GET")
+	as.router.HandleFunc(CWMP_PROFILES, as.setCwmpProfile).Methods("POST")
+	as.router.HandleFunc(CWMP_PROFILE, as.getCwmpProfile).Methods("GET")
+	as.router.HandleFunc(CWMP_PROFILE, as.deleteCwmpProfile).Methods("DELETE")
+	as.router.HandleFunc(CWMP_PROFILE_APPLY, as.applyCwmpProfile).Methods("POST")
+}
+
+// importCwmpPreRegistrations loads expected devices from a CSV document. The
+// header must name the oui and serial_number columns; product_class and
+// profile are optional and any other column is stored as subscriber metadata.
+func (as *ApiServer) importCwmpPreRegistrations(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	reader := csv.NewReader(http.MaxBytesReader(w, r.Body, maxPreRegImportSize))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid CSV header: %w", err))
+		return
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["oui"]; !ok {
+		httpSendRes(w, nil, errBadRequest("CSV header must contain an oui column"))
+		return
+	}
+	if _, ok := columns["serial_number"]; !ok {
+		httpSendRes(w, nil, errBadRequest("CSV header must contain a serial_number column"))
+		return
+	}
+
+	profiles := map[string]bool{}
+	result := &CwmpPreRegImportResult{Rejected: []string{}}
+	var regs []db.CwmpPreRegistration
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid CSV: %w", err))
+			return
+		}
+
+		reg := db.CwmpPreRegistration{Subscriber: map[string]string{}}
+		for name, i := range columns {
+			value := strings.TrimSpace(record[i])
+			switch name {
+			case "oui":
+				reg.OUI = strings.ToUpper(value)
+			case "serial_number":
+				reg.SerialNumber = value
+			case "product_class":
+				reg.ProductClass = value
+			case "profile":
+				reg.Profile = value
+			default:
+				if value != "" {
+					reg.Subscriber[name] = value
+				}
+			}
+		}
+		if reg.OUI == "" || reg.SerialNumber == "" {
+			result.Rejected = append(result.Rejected, fmt.Sprintf("line %d: oui and serial_number are required", line))
+			continue
+		}
+		if reg.Profile != "" {
+			if _, checked := profiles[reg.Profile]; !checked {
+				_, err := as.dbH.cwmpIntf.GetCwmpProfile(reg.Profile)
+				profiles[reg.Profile] = err == nil
+			}
+			if !profiles[reg.Profile] {
+				result.Rejected = append(result.Rejected, fmt.Sprintf("line %d: unknown profile %s", line, reg.Profile))
+				continue
+			}
+		}
+		regs = append(regs, reg)
+	}
+
+	if err := as.dbH.cwmpIntf.UpsertCwmpPreRegistrations(regs); err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to store pre-registrations: %w", err))
+		return
+	}
+	result.Imported = len(regs)
+	log.Printf("Imported %d CWMP pre-registration(s), rejected %d", result.Imported, len(result.Rejected))
+	httpSendRes(w, result, nil)
+}
+
+// getCwmpPreRegistrations lists pre-registrations, optionally by status
+func (as *ApiServer) getCwmpPreRegistrations(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	regs, err := as.dbH.cwmpIntf.GetCwmpPreRegistrations(filter, limit)
+	httpSendRes(w, regs, err)
+}
+
+func (as *ApiServer) deleteCwmpPreRegistration(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if err := as.dbH.cwmpIntf.DeleteCwmpPreRegistration(id); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"id": id, "status": "deleted"}, nil)
+}
+
+// getCwmpPendingDevices lists the devices which informed without being pre-registered
+func (as *ApiServer) getCwmpPendingDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	pending, err := as.dbH.cwmpIntf.GetCwmpPendingDevices(limit)
+	httpSendRes(w, pending, err)
+}
+
+func (as *ApiServer) deleteCwmpPendingDevice(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	deviceId := mux.Vars(r)["deviceId"]
+	if err := as.dbH.cwmpIntf.DeleteCwmpPendingDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"device_id": deviceId, "status": "deleted"}, nil)
+}
+
+func (as *ApiServer) getCwmpProfiles(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	profiles, err := as.dbH.cwmpIntf.GetCwmpProfiles()
+	httpSendRes(w, profiles, err)
+}
+
+func (as *ApiServer) getCwmpProfile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	profile, err := as.dbH.cwmpIntf.GetCwmpProfile(mux.Vars(r)["name"])
+	httpSendRes(w, profile, err)
+}
+
+// setCwmpProfile creates or replaces a provisioning profile
+func (as *ApiServer) setCwmpProfile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var profile db.CwmpProfile
+	if err := json.NewDecoder(r.Body).Decode(&profile); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if profile.Name == "" {
+		httpSendRes(w, nil, errBadRequest("profile name is required"))
+		return
+	}
+	for _, p := range profile.Parameters {
+		if p.Path == "" {
+			httpSendRes(w, nil, errBadRequest("profile parameters require a path"))
+			return
+		}
+	}
+
+	if err := as.dbH.cwmpIntf.UpsertCwmpProfile(&profile); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, profile, nil)
+}
+
+func (as *ApiServer) deleteCwmpProfile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := as.dbH.cwmpIntf.DeleteCwmpProfile(name); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"name": name, "status": "deleted"}, nil)
+}
+
+// queryLimit parses the limit query parameter
+func queryLimit(r *http.Request, defaultLimit int64) (int64, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultLimit, nil
+	}
+	l, err := strconv.ParseInt(limitStr, 10, 64)
+	if err != nil || l <= 0 {
+		return 0, errBadRequest("invalid limit: %s", limitStr)
+	}
+	return l, nil
+}
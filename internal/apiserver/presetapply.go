@@ -0,0 +1,385 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/logging"
+	"github.com/n4-networks/openusp/internal/parser"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_PRESET_DEVICES = "/cwmp/presets/{name}/devices"
+	CWMP_PRESET_APPLY   = "/cwmp/presets/{name}/apply"
+	CWMP_PROFILE_APPLY  = "/cwmp/profiles/{name}/apply"
+)
+
+// maxApplyDevices bounds the devices a preset or profile is applied to on
+// demand, larger sets go through bulk operations
+const maxApplyDevices = 1000
+
+// Condition keys of presets matching device attributes rather than
+// parameters
+var presetDeviceFields = map[string]string{
+	"tag":                   "tags",
+	"group":                 "groups",
+	"DeviceID.Manufacturer": "manufacturer",
+	"DeviceID.OUI":          "oui",
+	"DeviceID.ProductClass": "product_class",
+	"DeviceID.SerialNumber": "serial_number",
+}
+
+// presetFilterOps maps the operators of preset conditions to those of
+// parameter conditions
+var presetFilterOps = map[string]string{
+	parser.OpEqual:        "eq",
+	parser.OpNotEqual:     "ne",
+	parser.OpLess:         "lt",
+	parser.OpGreater:      "gt",
+	parser.OpLessEqual:    "lte",
+	parser.OpGreaterEqual: "gte",
+}
+
+// Outcomes of applying parameters on demand
+const (
+	applyStatusSubmitted  = "submitted"
+	applyStatusUnchanged  = "unchanged"
+	applyStatusNotMatched = "not_matched"
+	applyStatusFailed     = "failed"
+	applyParamSet         = "set"
+	applyParamUnchanged   = "unchanged"
+	applyParamUnsupported = "unsupported"
+)
+
+// CwmpApplyRequest names the device or the group a preset or profile is
+// applied to. The devices not matching the condition of a preset are
+// skipped unless IgnoreCondition is set.
+type CwmpApplyRequest struct {
+	DeviceId        string `json:"device_id,omitempty"`
+	Group           string `json:"group,omitempty"`
+	IgnoreCondition bool   `json:"ignore_condition,omitempty"`
+}
+
+// CwmpApplyResult is the outcome of applying a preset or profile
+type CwmpApplyResult struct {
+	Name      string                  `json:"name"`
+	Submitted int                     `json:"submitted"`
+	Devices   []CwmpApplyDeviceResult `json:"devices"`
+}
+
+// CwmpApplyDeviceResult is the outcome for one device, the task follows the
+// SetParameterValues submitted to it
+type CwmpApplyDeviceResult struct {
+	DeviceId     string                 `json:"device_id"`
+	Status       string                 `json:"status"`
+	Error        string                 `json:"error,omitempty"`
+	ParameterKey string                 `json:"parameter_key,omitempty"`
+	TaskID       string                 `json:"task_id,omitempty"`
+	Parameters   []CwmpApplyParamResult `json:"parameters,omitempty"`
+}
+
+// CwmpApplyParamResult is the outcome for one parameter. DevicePath is the
+// path in the data model of the device, Current its stored value.
+type CwmpApplyParamResult struct {
+	Path       string `json:"path"`
+	DevicePath string `json:"device_path,omitempty"`
+	Value      string `json:"value"`
+	Current    string `json:"current,omitempty"`
+	Status     string `json:"status"`
+}
+
+// getCwmpPresetDevices answers with a page of the devices matching the
+// condition of a preset. The events of the preset are not considered as
+// they depend on the Inform.
+func (as *ApiServer) getCwmpPresetDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	preset, err := as.dbH.cwmpIntf.GetCwmpPreset(mux.Vars(r)["name"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	filter, err := as.presetConditionFilter(preset.Condition)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.sendCwmpDeviceList(w, r, withoutArchived(filter))
+}
+
+// presetConditionFilter converts the condition of a preset into a device
+// filter. Conditions on TR-181 parameters also match the TR-098 equivalent,
+// as the ACS evaluates them against normalized values.
+func (as *ApiServer) presetConditionFilter(condition string) (bson.M, error) {
+	if condition == "" {
+		return bson.M{}, nil
+	}
+	filters, err := parser.ParseConditions(condition)
+	if err != nil {
+		return nil, errBadRequest("invalid condition: %w", err)
+	}
+
+	conds := bson.A{}
+	for _, f := range filters {
+		op := presetFilterOps[f.Op]
+		if field, ok := presetDeviceFields[f.Key]; ok {
+			mongoOp := "$" + op
+			if field == "tags" || field == "groups" {
+				conds = append(conds, bson.M{field: bson.M{"$elemMatch": bson.M{mongoOp: f.Value}}})
+			} else {
+				conds = append(conds, bson.M{field: bson.M{mongoOp: f.Value}})
+			}
+			continue
+		}
+
+		paths := []CwmpParameterCondition{{Path: f.Key, Op: op, Value: f.Value}}
+		if datamodel.RootOf(f.Key) == datamodel.RootTR181 {
+			if path, value, ok := datamodel.TranslateParam(f.Key, f.Value, datamodel.RootTR098); ok {
+				paths = append(paths, CwmpParameterCondition{Path: path, Op: op, Value: value})
+			}
+		}
+		var ids []string
+		for _, p := range paths {
+			paramFilter, err := parameterConditionFilter(p)
+			if err != nil {
+				return nil, err
+			}
+			matched, err := as.dbH.cwmpIntf.CwmpParameterDeviceIDs(paramFilter)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, matched...)
+		}
+		conds = append(conds, bson.M{"_id": bson.M{"$in": ids}})
+	}
+	return andFilter(conds), nil
+}
+
+// applyCwmpPreset applies the parameters of a preset on demand to a device
+// or to the members of a group matching its condition
+func (as *ApiServer) applyCwmpPreset(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	req, err := decodeApplyRequest(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	preset, err := as.dbH.cwmpIntf.GetCwmpPreset(mux.Vars(r)["name"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	condition := bson.M{}
+	if !req.IgnoreCondition {
+		if condition, err = as.presetConditionFilter(preset.Condition); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+	result, err := as.applyParameters(r.Context(), req, preset.Name, preset.Parameters, condition)
+	httpSendRes(w, result, err)
+}
+
+// applyCwmpProfile applies the parameters of a profile on demand to a
+// device or to the members of a group
+func (as *ApiServer) applyCwmpProfile(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	req, err := decodeApplyRequest(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	profile, err := as.dbH.cwmpIntf.GetCwmpProfile(mux.Vars(r)["name"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	result, err := as.applyParameters(r.Context(), req, profile.Name, profile.Parameters, bson.M{})
+	httpSendRes(w, result, err)
+}
+
+func decodeApplyRequest(r *http.Request) (*CwmpApplyRequest, error) {
+	var req CwmpApplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest("invalid request body: %w", err)
+	}
+	if (req.DeviceId == "") == (req.Group == "") {
+		return nil, errBadRequest("either device_id or group is required")
+	}
+	return &req, nil
+}
+
+// applyParameters submits a SetParameterValues with the parameters to each
+// target device matching condition, translated to its data model and
+// leaving out the values it already has
+func (as *ApiServer) applyParameters(ctx context.Context, req *CwmpApplyRequest, name string, params []db.ProfileParameter, condition bson.M) (*CwmpApplyResult, error) {
+	var target bson.M
+	if req.DeviceId != "" {
+		if err := as.checkCwmpDevice(req.DeviceId); err != nil {
+			return nil, err
+		}
+		target = bson.M{"_id": req.DeviceId}
+	} else {
+		filter, err := as.deviceGroupFilter(req.Group)
+		if err != nil {
+			return nil, errBadRequest("unknown group: %s", req.Group)
+		}
+		target = filter
+	}
+	scopeDeviceFilter(ctx, target)
+
+	count, err := as.dbH.cwmpIntf.CountCwmpDevices(target)
+	if err != nil {
+		return nil, err
+	}
+	if count > maxApplyDevices {
+		return nil, errBadRequest("%d devices exceed the %d devices applied to on demand, use a bulk operation", count, maxApplyDevices)
+	}
+	devices, err := as.dbH.cwmpIntf.GetCwmpDevicesByFilter(target)
+	if err != nil {
+		return nil, err
+	}
+	matched := map[string]bool{}
+	if len(condition) > 0 {
+		ids, err := as.dbH.cwmpIntf.DistinctCwmpDeviceValues("_id", andFilter(bson.A{target, condition}))
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			matched[id] = true
+		}
+	}
+
+	// The apply holds one job slot of each tenant of its devices until
+	// their tasks are final
+	release, err := as.acquireDevicesJob(target)
+	if err != nil {
+		return nil, err
+	}
+	var taskIDs []string
+	defer func() { as.releaseWithTasks(release, taskIDs...) }()
+
+	if logging.RequestID(ctx) == "" {
+		ctx = logging.WithRequestID(ctx, logging.NewRequestID())
+	}
+	result := &CwmpApplyResult{Name: name, Devices: []CwmpApplyDeviceResult{}}
+	for i := range devices {
+		device := &devices[i]
+		if len(condition) > 0 && !matched[device.ID] {
+			result.Devices = append(result.Devices, CwmpApplyDeviceResult{DeviceId: device.ID, Status: applyStatusNotMatched})
+			continue
+		}
+		deviceResult := as.applyDeviceParameters(ctx, device, name, params)
+		if deviceResult.Status == applyStatusSubmitted {
+			result.Submitted++
+			taskIDs = append(taskIDs, deviceResult.TaskID)
+		}
+		result.Devices = append(result.Devices, deviceResult)
+	}
+	logging.FromContext(ctx).Infof("Applied %s to %d of %d device(s)", name, result.Submitted, len(devices))
+	return result, nil
+}
+
+// applyDeviceParameters submits the parameters to one device
+func (as *ApiServer) applyDeviceParameters(ctx context.Context, device *db.CwmpDevice, name string, params []db.ProfileParameter) CwmpApplyDeviceResult {
+	result := CwmpApplyDeviceResult{DeviceId: device.ID}
+	root := device.DataModelRoot
+	if root == "" {
+		root = datamodel.RootTR181
+	}
+
+	var paths []string
+	translated := make([]CwmpApplyParamResult, 0, len(params))
+	for _, p := range params {
+		param := CwmpApplyParamResult{Path: p.Path, Value: p.Value, Status: applyParamSet}
+		path, value, ok := datamodel.TranslateParam(p.Path, p.Value, root)
+		if !ok {
+			param.Status = applyParamUnsupported
+		} else {
+			param.DevicePath, param.Value = path, value
+			paths = append(paths, path)
+		}
+		translated = append(translated, param)
+	}
+	current := map[string]string{}
+	if len(paths) > 0 {
+		stored, err := as.dbH.cwmpIntf.GetCwmpParametersByPath(device.ID, paths)
+		if err != nil {
+			result.Status, result.Error = applyStatusFailed, err.Error()
+			return result
+		}
+		for _, p := range stored {
+			current[p.Path] = p.Value
+		}
+	}
+
+	var values []cwmp.ParameterValueStruct
+	for i, param := range translated {
+		if param.Status != applyParamSet {
+			continue
+		}
+		if value, ok := current[param.DevicePath]; ok {
+			translated[i].Current = value
+			if value == param.Value {
+				translated[i].Status = applyParamUnchanged
+				continue
+			}
+		}
+		values = append(values, cwmp.ParameterValueStruct{Name: param.DevicePath, Value: param.Value, Type: params[i].Type})
+	}
+	result.Parameters = translated
+	if len(values) == 0 {
+		result.Status = applyStatusUnchanged
+		return result
+	}
+
+	result.ParameterKey = applyParameterKey(name)
+	if err := as.CntlrCwmpSetParamsReq(ctx, device.ID, values, result.ParameterKey); err != nil {
+		result.Status, result.Error = applyStatusFailed, err.Error()
+		return result
+	}
+	submission := as.newCwmpCommandSubmission(ctx, device.ID, acsbus.MethodSetParameterValues, fmt.Sprintf("Apply %s", name))
+	result.Status, result.TaskID = applyStatusSubmitted, submission.TaskID
+	return result
+}
+
+// applyParameterKey returns the ParameterKey naming what was applied,
+// truncated to the 32 characters CPEs store
+func applyParameterKey(name string) string {
+	key := "apply:" + name
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	return key
+}
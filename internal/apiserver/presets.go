@@ -0,0 +1,111 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/parser"
+)
+
+const (
+	CWMP_PRESETS = "/cwmp/presets/"
+	CWMP_PRESET  = "/cwmp/presets/{name}"
+)
+
+func (as *ApiServer) setCwmpPresetRoutesHandlers() {
+	as.router.HandleFunc(CWMP_PRESETS, as.getCwmpPresets).Methods("GET")
+	as.router.HandleFunc(CWMP_PRESETS, as.setCwmpPreset).Methods("POST")
+	as.router.HandleFunc(CWMP_PRESET, as.getCwmpPreset).Methods("GET")
+	as.router.HandleFunc(CWMP_PRESET, as.deleteCwmpPreset).Methods("DELETE")
+	as.router.HandleFunc(CWMP_PRESET_DEVICES, as.getCwmpPresetDevices).Methods("GET")
+	as.router.HandleFunc(CWMP_PRESET_APPLY, as.applyCwmpPreset).Methods("POST")
+}
+
+func (as *ApiServer) getCwmpPresets(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	presets, err := as.dbH.cwmpIntf.GetCwmpPresets()
+	httpSendRes(w, presets, err)
+}
+
+func (as *ApiServer) getCwmpPreset(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	preset, err := as.dbH.cwmpIntf.GetCwmpPreset(mux.Vars(r)["name"])
+	httpSendRes(w, preset, err)
+}
+
+// setCwmpPreset creates or replaces a preset, its condition is checked
+// before it is stored
+func (as *ApiServer) setCwmpPreset(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var preset db.CwmpPreset
+	if err := json.NewDecoder(r.Body).Decode(&preset); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if preset.Name == "" {
+		httpSendRes(w, nil, errBadRequest("preset name is required"))
+		return
+	}
+	if preset.Condition != "" {
+		if _, err := parser.ParseConditions(preset.Condition); err != nil {
+			httpSendRes(w, nil, errBadRequest("invalid condition: %w", err))
+			return
+		}
+	}
+	if len(preset.Parameters) == 0 {
+		httpSendRes(w, nil, errBadRequest("preset parameters are required"))
+		return
+	}
+	for _, p := range preset.Parameters {
+		if p.Path == "" {
+			httpSendRes(w, nil, errBadRequest("preset parameters require a path"))
+			return
+		}
+	}
+
+	if err := as.dbH.cwmpIntf.UpsertCwmpPreset(&preset); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, preset, nil)
+}
+
+func (as *ApiServer) deleteCwmpPreset(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := as.dbH.cwmpIntf.DeleteCwmpPreset(name); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"name": name, "status": "deleted"}, nil)
+}
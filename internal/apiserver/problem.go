@@ -0,0 +1,197 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Problem error codes, returned in the "code" member of a problem document
+const (
+	ProblemInvalidRequest     = "INVALID_REQUEST"
+	ProblemUnauthorized       = "UNAUTHORIZED"
+	ProblemForbidden          = "FORBIDDEN"
+	ProblemNotFound           = "NOT_FOUND"
+	ProblemDeviceNotFound     = "DEVICE_NOT_FOUND"
+	ProblemConflict           = "CONFLICT"
+	ProblemRateLimited        = "RATE_LIMITED"
+	ProblemInternal           = "INTERNAL_ERROR"
+	ProblemControllerFailure  = "CONTROLLER_FAILURE"
+	ProblemServiceUnavailable = "SERVICE_UNAVAILABLE"
+	ProblemDeviceTimeout      = "DEVICE_TIMEOUT"
+)
+
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the problem code to form the problem type URI
+const problemTypeBase = "urn:openusp:problem:"
+
+// Problem is an RFC 7807 problem details document. Clients branch on Code,
+// Detail is the message for humans, Details the values the error is about
+// and RequestID the X-Request-ID correlating it with the server logs.
+type Problem struct {
+	Type      string                 `json:"type"`
+	Title     string                 `json:"title"`
+	Status    int                    `json:"status"`
+	Detail    string                 `json:"detail,omitempty"`
+	Code      string                 `json:"code"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// problemError is an error carrying the HTTP status and code it is reported with
+type problemError struct {
+	status  int
+	code    string
+	err     error
+	details map[string]interface{}
+}
+
+func (e *problemError) Error() string {
+	return e.err.Error()
+}
+
+func (e *problemError) Unwrap() error {
+	return e.err
+}
+
+func newProblemError(status int, code string, format string, args ...interface{}) error {
+	return &problemError{status: status, code: code, err: fmt.Errorf(format, args...)}
+}
+
+// errDeviceNotFound reports a device which is not registered
+func errDeviceNotFound(deviceId string) error {
+	return &problemError{
+		status:  http.StatusNotFound,
+		code:    ProblemDeviceNotFound,
+		err:     fmt.Errorf("device %s not found", deviceId),
+		details: map[string]interface{}{"device_id": deviceId},
+	}
+}
+
+// errBadRequest reports invalid input of the client
+func errBadRequest(format string, args ...interface{}) error {
+	return newProblemError(http.StatusBadRequest, ProblemInvalidRequest, format, args...)
+}
+
+// errForbidden reports a request the authenticated user is not allowed to make
+func errForbidden(format string, args ...interface{}) error {
+	return newProblemError(http.StatusForbidden, ProblemForbidden, format, args...)
+}
+
+// errNotFound reports a missing device, agent or object
+func errNotFound(format string, args ...interface{}) error {
+	return newProblemError(http.StatusNotFound, ProblemNotFound, format, args...)
+}
+
+// errConflict reports a request that conflicts with the state of the target
+func errConflict(format string, args ...interface{}) error {
+	return newProblemError(http.StatusConflict, ProblemConflict, format, args...)
+}
+
+// errUnavailable reports a backend (DB, controller) that can't be reached
+func errUnavailable(format string, args ...interface{}) error {
+	return newProblemError(http.StatusServiceUnavailable, ProblemServiceUnavailable, format, args...)
+}
+
+// errControllerFailure reports a request the controller failed to execute
+func errControllerFailure(format string, args ...interface{}) error {
+	return newProblemError(http.StatusBadGateway, ProblemControllerFailure, format, args...)
+}
+
+// errDeviceTimeout reports a device which did not answer in time
+func errDeviceTimeout(format string, args ...interface{}) error {
+	return newProblemError(http.StatusGatewayTimeout, ProblemDeviceTimeout, format, args...)
+}
+
+var (
+	errDbNotConnected     = errUnavailable("Not connected to DB")
+	errCwmpDbNotConnected = errUnavailable("CWMP database not connected")
+	errCntlrNotConnected  = errUnavailable("Controller is not connected")
+)
+
+// problemFromError maps an error to its problem document. Errors without an
+// explicit status are mapped from well known DB and gRPC errors, anything
+// else is an internal error.
+func problemFromError(err error) *Problem {
+	httpStatus, code := http.StatusInternalServerError, ProblemInternal
+
+	var pErr *problemError
+	if errors.As(err, &pErr) {
+		p := newProblem(pErr.status, pErr.code, err.Error())
+		p.Details = pErr.details
+		return p
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		httpStatus, code = http.StatusNotFound, ProblemNotFound
+	} else if mongo.IsDuplicateKeyError(err) {
+		httpStatus, code = http.StatusConflict, ProblemConflict
+	} else if s, ok := status.FromError(err); ok {
+		// Errors the controller returns without a code are failures of the
+		// request it executed
+		httpStatus, code = grpcProblemStatus(s.Code())
+	}
+
+	return newProblem(httpStatus, code, err.Error())
+}
+
+// newProblem creates a problem document
+func newProblem(httpStatus int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypeBase + code,
+		Title:  http.StatusText(httpStatus),
+		Status: httpStatus,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func grpcProblemStatus(c codes.Code) (int, string) {
+	switch c {
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest, ProblemInvalidRequest
+	case codes.NotFound:
+		return http.StatusNotFound, ProblemNotFound
+	case codes.AlreadyExists, codes.Aborted, codes.FailedPrecondition:
+		return http.StatusConflict, ProblemConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden, ProblemForbidden
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests, ProblemRateLimited
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return http.StatusServiceUnavailable, ProblemServiceUnavailable
+	default:
+		return http.StatusBadGateway, ProblemControllerFailure
+	}
+}
+
+// httpSendProblem writes a problem+json response, with the request ID set
+// by the request ID middleware
+func httpSendProblem(w http.ResponseWriter, p *Problem) {
+	if p.RequestID == "" {
+		p.RequestID = w.Header().Get(logging.RequestIDHeader)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
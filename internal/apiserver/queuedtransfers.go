@@ -0,0 +1,114 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/cwmp"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+const CWMP_QUEUED_TRANSFERS = "/cwmp/device/{deviceId}/queued-transfers"
+
+// CwmpQueuedTransfersResult is the transfer queue of a device reconciled
+// with the transfer records, its status is queued when the device did not
+// answer within the wait time
+type CwmpQueuedTransfersResult struct {
+	DeviceID  string                `json:"device_id"`
+	Status    string                `json:"status"`
+	RequestID string                `json:"request_id"`
+	Transfers []cwmp.QueuedTransfer `json:"transfers"`
+	// Stuck lists the IDs of the transfers in progress the device no
+	// longer lists, Orphaned the CommandKeys of listed transfers without a
+	// record
+	Stuck    []string `json:"stuck"`
+	Orphaned []string `json:"orphaned"`
+}
+
+// getCwmpQueuedTransfers asks the device for its transfer queue with
+// GetQueuedTransfers, or GetAllQueuedTransfers if all is set, and reports
+// the stuck and orphaned transfers
+func (as *ApiServer) getCwmpQueuedTransfers(w http.ResponseWriter, r *http.Request) {
+	deviceId := mux.Vars(r)["deviceId"]
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	wait, err := queryWait(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if _, err := as.loadCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	method := acsbus.MethodGetQueuedTransfers
+	if r.URL.Query().Get("all") == "true" {
+		method = acsbus.MethodGetAllQueuedTransfers
+	}
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	cmd := &acsbus.Command{
+		DeviceID:  deviceId,
+		Method:    method,
+		RequestID: requestID,
+	}
+	if err := as.sendAcsCommand(cmd); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Sent %s to device %s", method, deviceId)
+
+	result := &CwmpQueuedTransfersResult{
+		DeviceID:  deviceId,
+		Status:    "queued",
+		RequestID: requestID,
+		Transfers: []cwmp.QueuedTransfer{},
+		Stuck:     []string{},
+		Orphaned:  []string{},
+	}
+	event, err := as.waitCwmpDeviceEvent(r.Context(), deviceId, cwmp.DeviceEventTransfersReconciled, requestID, wait)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if event == nil {
+		httpSendRes(w, result, nil)
+		return
+	}
+	result.Status = "complete"
+	if list := event.Details["transfers"]; list != "" {
+		if err := json.Unmarshal([]byte(list), &result.Transfers); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+	}
+	if stuck := event.Details["stuck"]; stuck != "" {
+		result.Stuck = strings.Split(stuck, ",")
+	}
+	if orphaned := event.Details["orphaned"]; orphaned != "" {
+		result.Orphaned = strings.Split(orphaned, ",")
+	}
+	httpSendRes(w, result, nil)
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/quota"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	GET_QUOTAS = "/quota/"
+	GET_QUOTA  = "/quota/{tenant}"
+	GET_ALARMS = "/alarms/"
+)
+
+const defaultAlarmLimit = 100
+
+// taskJobPollInterval is how often the tasks holding a job slot are checked
+// for completion
+const taskJobPollInterval = 5 * time.Second
+
+// initQuota sets up the per-tenant quota manager from the tenants configuration
+func (as *ApiServer) initQuota() {
+	as.quota = quota.NewManager(as.config.Tenants)
+	as.quota.OnViolation = as.raiseQuotaAlarm
+	log.Printf("Quota enforcement configured for %d tenant(s)", len(as.config.Tenants))
+}
+
+// raiseQuotaAlarm stores an alarm for a quota violation
+func (as *ApiServer) raiseQuotaAlarm(v quota.Violation) {
+	log.Printf("Quota violation by tenant %s: %s", v.Tenant, v.Message)
+	if as.dbH.cwmpIntf == nil {
+		return
+	}
+	alarm := &db.Alarm{
+		Type:     v.Type,
+		Severity: db.AlarmSeverityWarning,
+		Tenant:   v.Tenant,
+		Source:   "apiserver",
+		Message:  v.Message,
+	}
+	if err := as.dbH.cwmpIntf.InsertAlarm(alarm); err != nil {
+		log.Println("Error storing quota alarm:", err)
+	}
+}
+
+// middlewareQuota enforces the request rate limit of the tenant the
+// authenticated user belongs to. The concurrent jobs limit is enforced by
+// the tasks, bulk jobs and campaigns for as long as they run.
+func (as *ApiServer) middlewareQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant := requestTenant(r.Context())
+		if tenant == "" || as.quota == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !as.quota.AllowRequest(tenant) {
+			httpSendProblem(w, newProblem(http.StatusTooManyRequests, ProblemRateLimited,
+				"request rate quota exceeded for tenant "+tenant))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireJobs takes a concurrent job slot of each tenant, all or none. The
+// returned release frees them once the job finished.
+func (as *ApiServer) acquireJobs(tenants []string) (func(), error) {
+	var releases []func()
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	if as.quota == nil {
+		return release, nil
+	}
+	for _, tenant := range tenants {
+		if tenant == "" {
+			continue
+		}
+		r, ok := as.quota.AcquireJob(tenant)
+		if !ok {
+			release()
+			return nil, newProblemError(http.StatusTooManyRequests, ProblemRateLimited,
+				"concurrent jobs quota exceeded for tenant %s", tenant)
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// acquireDevicesJob takes a job slot of each tenant owning devices matching
+// filter
+func (as *ApiServer) acquireDevicesJob(filter bson.M) (func(), error) {
+	if as.quota == nil || as.dbH.cwmpIntf == nil {
+		return func() {}, nil
+	}
+	tenants, err := as.dbH.cwmpIntf.DistinctCwmpDeviceValues("tenant", filter)
+	if err != nil {
+		return nil, err
+	}
+	return as.acquireJobs(tenants)
+}
+
+// acquireDeviceJob takes a job slot of the tenant of a device
+func (as *ApiServer) acquireDeviceJob(deviceId string) (func(), error) {
+	return as.acquireDevicesJob(bson.M{"_id": deviceId})
+}
+
+// releaseWithTasks holds a job slot until each task is final, tasks which
+// were not stored release it right away
+func (as *ApiServer) releaseWithTasks(release func(), taskIDs ...string) {
+	var pending []string
+	for _, id := range taskIDs {
+		if id != "" {
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) == 0 || as.dbH.cwmpIntf == nil {
+		release()
+		return
+	}
+	as.goBackground(func() {
+		defer release()
+		ticker := time.NewTicker(taskJobPollInterval)
+		defer ticker.Stop()
+		for len(pending) > 0 {
+			select {
+			case <-as.stopping:
+				return
+			case <-ticker.C:
+			}
+			task, err := as.dbH.cwmpIntf.GetCwmpTask(pending[0])
+			if err != nil || task.IsFinal() {
+				pending = pending[1:]
+			}
+		}
+	})
+}
+
+// holdCampaignJob takes the job slots of a running campaign on the tenants
+// owning devices of its group. It reports false while a tenant is at its
+// limit, the campaign waits for the next run then.
+func (as *ApiServer) holdCampaignJob(campaign *db.FirmwareCampaign) bool {
+	as.campaignJobsMu.Lock()
+	defer as.campaignJobsMu.Unlock()
+	if _, ok := as.campaignJobs[campaign.ID]; ok {
+		return true
+	}
+	filter, err := as.deviceGroupFilter(campaign.Group)
+	if err != nil {
+		return true
+	}
+	release, err := as.acquireDevicesJob(filter)
+	if err != nil {
+		log.Printf("Firmware campaign %s waits for a job slot: %v", campaign.ID, err)
+		return false
+	}
+	if as.campaignJobs == nil {
+		as.campaignJobs = make(map[string]func())
+	}
+	as.campaignJobs[campaign.ID] = release
+	return true
+}
+
+// releaseCampaignJobs frees the job slots of the campaigns which are no
+// longer running
+func (as *ApiServer) releaseCampaignJobs(running map[string]bool) {
+	as.campaignJobsMu.Lock()
+	defer as.campaignJobsMu.Unlock()
+	for id, release := range as.campaignJobs {
+		if !running[id] {
+			release()
+			delete(as.campaignJobs, id)
+		}
+	}
+}
+
+func (as *ApiServer) tenantQuotaStatus(tenant string) (*quota.Status, error) {
+	var devices int64
+	if as.dbH.cwmpIntf != nil {
+		count, err := as.dbH.cwmpIntf.CountCwmpDevicesByTenant(tenant)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count devices: %w", err)
+		}
+		devices = count
+	}
+	status, err := as.quota.Status(tenant, devices)
+	if errors.Is(err, quota.ErrUnknownTenant) {
+		return nil, errNotFound("%w", err)
+	}
+	return status, err
+}
+
+// getQuotas returns the quota status of all tenants
+func (as *ApiServer) getQuotas(w http.ResponseWriter, r *http.Request) {
+	if as.quota == nil {
+		httpSendRes(w, nil, errUnavailable("Quota enforcement not initialized"))
+		return
+	}
+	statuses := []*quota.Status{}
+	for _, tenant := range as.quota.Tenants() {
+		status, err := as.tenantQuotaStatus(tenant)
+		if err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		statuses = append(statuses, status)
+	}
+	httpSendRes(w, statuses, nil)
+}
+
+// getQuota returns the quota status of a tenant
+func (as *ApiServer) getQuota(w http.ResponseWriter, r *http.Request) {
+	if as.quota == nil {
+		httpSendRes(w, nil, errUnavailable("Quota enforcement not initialized"))
+		return
+	}
+	status, err := as.tenantQuotaStatus(mux.Vars(r)["tenant"])
+	httpSendRes(w, status, err)
+}
+
+// getAlarms returns the most recent alarms, optionally filtered by tenant and type
+func (as *ApiServer) getAlarms(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if tenant := r.URL.Query().Get("tenant"); tenant != "" {
+		filter["tenant"] = tenant
+	}
+	if deviceId := r.URL.Query().Get("device_id"); deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if alarmType := r.URL.Query().Get("type"); alarmType != "" {
+		filter["type"] = alarmType
+	}
+	limit := int64(defaultAlarmLimit)
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		l, err := strconv.ParseInt(limitStr, 10, 64)
+		if err != nil || l <= 0 {
+			httpSendRes(w, nil, errBadRequest("invalid limit: %s", limitStr))
+			return
+		}
+		limit = l
+	}
+
+	alarms, err := as.dbH.cwmpIntf.GetAlarms(filter, limit)
+	httpSendRes(w, alarms, err)
+}
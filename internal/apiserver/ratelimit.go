@@ -0,0 +1,194 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// rateLimitIdle is how long a full bucket is kept after its last request
+const rateLimitIdle = 10 * time.Minute
+
+// rateBucket is the token bucket of a client IP or user
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key
+type rateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     int
+	buckets   map[string]*rateBucket
+	lastPrune time.Time
+}
+
+// rateDecision is the outcome of a request against a limit, reported in the
+// RateLimit headers
+type rateDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration // until the bucket is full again
+	retryAfter time.Duration // until the next request is allowed
+}
+
+// newRateLimiter returns nil for an unlimited rate
+func newRateLimiter(limit config.RateLimit) *rateLimiter {
+	if limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Max(1, limit.RequestsPerSecond))
+	}
+	return &rateLimiter{
+		rate:    limit.RequestsPerSecond,
+		burst:   burst,
+		buckets: map[string]*rateBucket{},
+	}
+}
+
+// take consumes a token of the key's bucket if one is left
+func (l *rateLimiter) take(key string) rateDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(l.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	d := rateDecision{limit: l.burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.allowed = true
+	} else {
+		d.retryAfter = l.refillTime(1 - b.tokens)
+	}
+	d.remaining = int(b.tokens)
+	d.reset = l.refillTime(float64(l.burst) - b.tokens)
+
+	if now.Sub(l.lastPrune) > rateLimitIdle {
+		l.prune(now)
+	}
+	return d
+}
+
+func (l *rateLimiter) refillTime(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// prune drops the buckets which refilled while idle, so that scanning
+// clients cannot grow the map without bound
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// rateLimits holds the request rate limits of the client IPs and users
+type rateLimits struct {
+	perIP   *rateLimiter
+	perUser *rateLimiter
+}
+
+// initRateLimits sets up the rate limits from the security config
+func (as *ApiServer) initRateLimits() {
+	cfg := as.config.Security.RateLimit
+	if !cfg.Enabled {
+		log.Println("API rate limiting disabled")
+		return
+	}
+	as.rateLimits = &rateLimits{
+		perIP:   newRateLimiter(cfg.PerIP),
+		perUser: newRateLimiter(cfg.PerUser),
+	}
+	log.Printf("API rate limits: %.2f/s per IP, %.2f/s per user", cfg.PerIP.RequestsPerSecond, cfg.PerUser.RequestsPerSecond)
+}
+
+// middlewareRateLimitIP limits the requests of each client IP. It runs
+// before authentication so that floods do not reach the password check.
+func (as *ApiServer) middlewareRateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if as.rateLimits == nil || as.rateLimits.perIP == nil || isHealthCheck(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if !applyRateLimit(w, r, as.rateLimits.perIP.take(ip), "client "+ip) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareRateLimitUser limits the requests of each authenticated user,
+// whichever IPs they come from
+func (as *ApiServer) middlewareRateLimitUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if as.rateLimits == nil || as.rateLimits.perUser == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		username := requestUser(r)
+		if username == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !applyRateLimit(w, r, as.rateLimits.perUser.take(username), "user "+username) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// applyRateLimit sets the RateLimit headers of the most restrictive limit
+// applied to the request, and refuses the request when it is over the limit
+func applyRateLimit(w http.ResponseWriter, r *http.Request, d rateDecision, client string) bool {
+	h := w.Header()
+	if current, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err != nil || d.remaining <= current {
+		h.Set("RateLimit-Limit", strconv.Itoa(d.limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(d.reset)))
+	}
+	if d.allowed {
+		return true
+	}
+	h.Set("Retry-After", strconv.Itoa(ceilSeconds(d.retryAfter)))
+	httpSendProblem(w, newProblem(http.StatusTooManyRequests, ProblemRateLimited,
+		"request rate limit exceeded for "+client))
+	logging.FromContext(r.Context()).Warnf("Rate limited %s on %s %s", client, r.Method, r.URL.Path)
+	return false
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
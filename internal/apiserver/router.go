@@ -44,11 +44,14 @@ const (
 	GET_MTPINFO = "/get/mtpinfo/"
 
 	HEALTH = "/health"
+
+	STATS_TRENDS = "/stats/trends"
 )
 
 func (as *ApiServer) initRouter() error {
-	as.router = mux.NewRouter()
+	as.rootRouter = mux.NewRouter()
 	as.setMiddlewares()
+	as.router = as.rootRouter.PathPrefix(API_V1).Subrouter()
 	as.setRoutesHandlers()
 	return nil
 }
@@ -68,6 +71,17 @@ func (as *ApiServer) setRoutesHandlers() error {
 	as.router.HandleFunc(DELETE_DBCOLL+"{coll}", as.deleteDbColl).Methods("GET")
 	as.router.HandleFunc(RECONNECT_DB, as.reconnectDb).Methods("GET")
 	as.router.HandleFunc(RECONNECT_MTP, as.reconnectCntlr).Methods("GET")
+	as.router.HandleFunc(STATS_TRENDS, as.getStatsTrends).Methods("GET")
+	as.router.HandleFunc(GET_QUOTAS, as.getQuotas).Methods("GET")
+	as.router.HandleFunc(GET_QUOTA, as.getQuota).Methods("GET")
+	as.router.HandleFunc(GET_ALARMS, as.getAlarms).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_COMPAT, as.getFirmwareCompatRules).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_COMPAT, as.setFirmwareCompatRule).Methods("POST")
+	as.router.HandleFunc(FIRMWARE_COMPAT_RULE, as.deleteFirmwareCompatRule).Methods("DELETE")
+	as.router.HandleFunc(FIRMWARE_JOBS, as.getFirmwareJobs).Methods("GET")
+	as.router.HandleFunc(FIRMWARE_JOB, as.getFirmwareJob).Methods("GET")
+	as.setFirmwareImageRoutesHandlers()
+	as.setFirmwareCampaignRoutesHandlers()
 
 	as.router.HandleFunc(ADD_INSTANCES+"{epId}/{path}", as.addInstance).Methods("POST")
 	as.router.HandleFunc(OPERATE_CMD+"{epId}/{path}", as.operateCmd).Methods("POST")
@@ -75,8 +89,43 @@ func (as *ApiServer) setRoutesHandlers() error {
 
 	//router.HandleFunc("/network/{epId}/{type}", as.getNetwork).Methods("GET")
 
+	as.setDeviceRoutesHandlers()
+
 	// Set up CWMP/TR-069 routes
 	as.setCwmpRoutesHandlers()
+	as.setCwmpPreRegRoutesHandlers()
+	as.setCwmpObjectRoutesHandlers()
+	as.setCwmpSoftwareRoutesHandlers()
+	as.setCwmpParamChangeRoutesHandlers()
+	as.setCwmpCredRotationRoutesHandlers()
+	as.setCwmpNetStatusRoutesHandlers()
+	as.setCwmpPresetRoutesHandlers()
+	as.setCwmpTaskRoutesHandlers()
+	as.setCwmpDiagnosticsRoutesHandlers()
+	as.setCwmpTagRoutesHandlers()
+	as.setTenantRoutesHandlers()
+	as.setGroupRoutesHandlers()
+	as.setEventRoutesHandlers()
+	as.setWebhookRoutesHandlers()
+	as.setAuditRoutesHandlers()
+	as.setZtpRoutesHandlers()
+	as.setFileRoutesHandlers()
+	as.setGraphQLRoutesHandlers()
+
+	// Unversioned paths of the routes above, kept for the existing clients
+	if err := as.setLegacyRoutesHandlers(); err != nil {
+		log.Println("Error setting legacy API routes:", err)
+	}
+
+	// Service endpoints, outside of the API versions
+	as.rootRouter.HandleFunc(HEALTH, as.healthCheck).Methods("GET")
+	as.setMetricsRoutesHandlers()
+	if err := as.setDashboardRoutesHandlers(); err != nil {
+		log.Println("Error setting dashboard routes:", err)
+	}
+	if err := as.setOpenAPIRoutesHandlers(); err != nil {
+		log.Println("Error setting OpenAPI routes:", err)
+	}
 
 	return nil
 }
@@ -0,0 +1,121 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/parser"
+)
+
+// getSearchPathParams returns the parameters of the objects a path
+// expression resolves to. A parameter path returns that parameter only.
+func (as *ApiServer) getSearchPathParams(d *uspData, expr *parser.PathExpr) ([]*ObjParam, error) {
+	objPaths, err := as.resolveObjPaths(d.epId, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []*ObjParam{}
+	for _, objPath := range objPaths {
+		dm, err := as.dbH.uspIntf.GetDm(d.epId, getDmPathFromAbsPath(objPath))
+		if err != nil {
+			return nil, errNotFound("no datamodel for %s: %v", objPath, err)
+		}
+		params, err := as.getSingleObjParams(d.epId, objPath, dm)
+		if err != nil {
+			return nil, err
+		}
+		if expr.Param != "" {
+			params = filterParam(params, expr.Param)
+			if len(params) == 0 {
+				continue
+			}
+		}
+		objs = append(objs, &ObjParam{Path: objPath, Params: params})
+	}
+	return objs, nil
+}
+
+// resolveObjPaths expands the wildcards and search expressions of a path
+// with the instances stored for the endpoint
+func (as *ApiServer) resolveObjPaths(epId string, expr *parser.PathExpr) ([]string, error) {
+	paths := []string{""}
+	for _, seg := range expr.Segments {
+		var next []string
+		for _, prefix := range paths {
+			switch seg.Kind {
+			case parser.SegmentName, parser.SegmentInstance:
+				next = append(next, prefix+seg.Name+".")
+			case parser.SegmentWildcard, parser.SegmentSearch:
+				insts, err := as.dbH.uspIntf.GetInstancesByRegex(epId, "^"+regexp.QuoteMeta(prefix)+`\d+\.$`)
+				if err != nil {
+					// No instance stored under the prefix
+					continue
+				}
+				for _, inst := range insts {
+					if seg.Kind == parser.SegmentSearch {
+						match, err := as.matchInstance(epId, inst, seg)
+						if err != nil {
+							return nil, err
+						}
+						if !match {
+							continue
+						}
+					}
+					next = append(next, inst.Path)
+				}
+			}
+		}
+		paths = next
+	}
+	return paths, nil
+}
+
+// matchInstance evaluates a search expression on the parameters of an
+// instance, the unique keys stored with the instance save a lookup
+func (as *ApiServer) matchInstance(epId string, inst *db.Instance, seg parser.Segment) (bool, error) {
+	values := make(map[string]string, len(inst.UniqueKeys))
+	for k, v := range inst.UniqueKeys {
+		values[k] = v
+	}
+	var missing []string
+	for _, key := range seg.Keys() {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, regexp.QuoteMeta(key))
+		}
+	}
+	if len(missing) > 0 {
+		params, err := as.dbH.uspIntf.GetParamsByRegex(epId, "^"+regexp.QuoteMeta(inst.Path)+"("+strings.Join(missing, "|")+")$")
+		if err != nil {
+			return false, err
+		}
+		for _, p := range params {
+			values[strings.TrimPrefix(p.Path, inst.Path)] = p.Value
+		}
+	}
+	return seg.Match(values), nil
+}
+
+func filterParam(params []*Param, name string) []*Param {
+	for _, p := range params {
+		if p.Name == name {
+			return []*Param{p}
+		}
+	}
+	return nil
+}
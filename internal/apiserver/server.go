@@ -18,30 +18,55 @@ import (
 	"log"
 	"net/http"
 	"time"
-
-	"github.com/gorilla/handlers"
 )
 
 func (as *ApiServer) Server() error {
+	handler := corsHandler(as.config.Security.CORS, as.rootRouter)
+	errs := make(chan error, 2)
+	listeners := 0
 
-	// CORS handlers
-	headers := handlers.AllowedHeaders([]string{"content-type", "authorization"})
-	origins := handlers.AllowedOrigins([]string{"*"})
-	methods := handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "OPTIONS"})
+	if as.cfg.isTlsOn {
+		reloader, err := newCertReloader(as.cfg.tlsFiles)
+		if err != nil {
+			return err
+		}
+		defer reloader.close()
+		go reloader.watch()
 
-	srv := &http.Server{
-		Handler:      handlers.CORS(headers, origins, methods)(as.router),
-		Addr:         ":" + as.cfg.httpPort,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		srv := newApiHTTPServer(as.cfg.httpsPort, handler)
+		srv.TLSConfig = reloader.tlsConfig()
+		if err := as.addServer(srv); err != nil {
+			return err
+		}
+		log.Println("Starting HTTPS server at:", as.cfg.httpsPort)
+		go func() { errs <- srv.ListenAndServeTLS("", "") }()
+		listeners++
+	}
+	if as.cfg.isHttpOn {
+		srv := newApiHTTPServer(as.cfg.httpPort, handler)
+		if err := as.addServer(srv); err != nil {
+			return err
+		}
+		log.Println("Starting HTTP server at:", as.cfg.httpPort)
+		go func() { errs <- srv.ListenAndServe() }()
+		listeners++
 	}
 
-	log.Println("Starting HTTP server at:", as.cfg.httpPort)
-	if as.cfg.isTlsOn {
-		log.Println("Running server with TLS ...")
-		return srv.ListenAndServeTLS("ssl/server.csr", "ssl/server.key")
-	} else {
-		return srv.ListenAndServe()
+	for i := 0; i < listeners; i++ {
+		if err := <-errs; err != http.ErrServerClosed {
+			return err
+		}
+	}
+	// The listeners are closed, the requests in flight are still drained
+	<-as.stopped
+	return nil
+}
+
+func newApiHTTPServer(port string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		Addr:         ":" + port,
+		WriteTimeout: 15 * time.Second,
+		ReadTimeout:  15 * time.Second,
 	}
-	//return http.ListenAndServe(":8081", handlers.CORS(headers, origins, methods)(as.router))
 }
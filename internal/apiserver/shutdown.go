@@ -0,0 +1,149 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultShutdownTimeout is how long Stop waits for the requests in
+	// flight and the background work unless configured
+	defaultShutdownTimeout = 30 * time.Second
+	// disconnectTimeout bounds the disconnection from the database
+	disconnectTimeout = 5 * time.Second
+)
+
+// errShuttingDown is returned by Server when it is called after Stop
+var errShuttingDown = errors.New("API server is shutting down")
+
+// shutdownTimeout returns how long Stop waits for the requests in flight
+// and the background work
+func (as *ApiServer) shutdownTimeout() time.Duration {
+	if as.config != nil && as.config.Protocols.HTTP.ShutdownTimeout > 0 {
+		return as.config.Protocols.HTTP.ShutdownTimeout
+	}
+	return defaultShutdownTimeout
+}
+
+// isStopping reports whether Stop was called
+func (as *ApiServer) isStopping() bool {
+	select {
+	case <-as.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// addServer registers a listener for Stop to shut it down
+func (as *ApiServer) addServer(srv *http.Server) error {
+	as.serversMu.Lock()
+	defer as.serversMu.Unlock()
+	if as.isStopping() {
+		return errShuttingDown
+	}
+	as.servers = append(as.servers, srv)
+	return nil
+}
+
+// goBackground runs fn in the background, Stop waits for it to return
+func (as *ApiServer) goBackground(fn func()) {
+	as.background.Add(1)
+	go func() {
+		defer as.background.Done()
+		fn()
+	}()
+}
+
+// every runs fn at each interval until the API server stops, a run in
+// progress completes before the clients are closed
+func (as *ApiServer) every(interval time.Duration, fn func()) {
+	as.goBackground(func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-as.stopping:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	})
+}
+
+// Stop stops accepting requests and waits up to the shutdown timeout for
+// the requests in flight and the background work, such as running bulk
+// jobs and webhook deliveries, before closing the ACS bus, controller and
+// database clients. The event streams are ended at once.
+func (as *ApiServer) Stop() error {
+	as.stopOnce.Do(func() { close(as.stopping) })
+	defer as.stoppedOnce.Do(func() { close(as.stopped) })
+
+	timeout := as.shutdownTimeout()
+	log.Printf("Stopping API server, draining requests for up to %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	as.serversMu.Lock()
+	servers := as.servers
+	as.serversMu.Unlock()
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to drain the requests on %s: %w", srv.Addr, err))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		as.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		errs = append(errs, errors.New("background work still running at the shutdown deadline"))
+	}
+
+	if as.bus != nil {
+		if err := as.bus.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close the ACS bus: %w", err))
+		}
+	}
+	if as.grpcH.conn != nil {
+		if err := as.grpcH.conn.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close the controller connection: %w", err))
+		}
+	}
+	if as.dbH.client != nil {
+		dctx, dcancel := context.WithTimeout(context.Background(), disconnectTimeout)
+		defer dcancel()
+		if err := as.dbH.client.Disconnect(dctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to disconnect from the database: %w", err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	log.Println("API server stopped")
+	return nil
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const CWMP_SOFTWARE_MODULES = "/cwmp/device/{deviceId}/software-modules"
+
+// CwmpDUOperationRequest asks to install, update or uninstall a deployment
+// unit with ChangeDUState
+type CwmpDUOperationRequest struct {
+	Operation       string `json:"operation"`
+	URL             string `json:"url"`
+	UUID            string `json:"uuid"`
+	Version         string `json:"version"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	ExecutionEnvRef string `json:"execution_env_ref"`
+	CommandKey      string `json:"command_key"`
+}
+
+func (as *ApiServer) setCwmpSoftwareRoutesHandlers() {
+	as.router.HandleFunc(CWMP_SOFTWARE_MODULES, as.getCwmpSoftwareModules).Methods("GET")
+	as.router.HandleFunc(CWMP_SOFTWARE_MODULES, as.changeCwmpSoftwareModule).Methods("POST")
+}
+
+// getCwmpSoftwareModules lists the deployment units of a device with the
+// outcome of the last operation on each
+func (as *ApiServer) getCwmpSoftwareModules(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	dus, err := as.dbH.cwmpIntf.GetCwmpDeploymentUnits(mux.Vars(r)["deviceId"])
+	httpSendRes(w, dus, err)
+}
+
+// changeCwmpSoftwareModule stores the requested operation on a deployment
+// unit and sends its ChangeDUState to the ACS. The outcome is stored once
+// the device reports ChangeDUStateComplete.
+func (as *ApiServer) changeCwmpSoftwareModule(w http.ResponseWriter, r *http.Request) {
+	deviceId := mux.Vars(r)["deviceId"]
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req CwmpDUOperationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	switch req.Operation {
+	case db.DUOperationInstall:
+		if req.URL == "" {
+			httpSendRes(w, nil, errBadRequest("url is required to install a deployment unit"))
+			return
+		}
+	case db.DUOperationUpdate, db.DUOperationUninstall:
+		if req.UUID == "" {
+			httpSendRes(w, nil, errBadRequest("uuid is required to %s a deployment unit", req.Operation))
+			return
+		}
+	default:
+		httpSendRes(w, nil, errBadRequest("operation must be install, update or uninstall"))
+		return
+	}
+	if _, err := as.loadCwmpDevice(deviceId); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	du := &db.CwmpDeploymentUnit{DeviceID: deviceId}
+	if req.UUID != "" {
+		existing, err := as.dbH.cwmpIntf.GetCwmpDeploymentUnit(deviceId, req.UUID)
+		if err == nil {
+			du = existing
+		} else if !errors.Is(err, mongo.ErrNoDocuments) {
+			httpSendRes(w, nil, err)
+			return
+		}
+		if du.OperationStatus == db.DUOperationPending || du.OperationStatus == db.DUOperationInProgress {
+			httpSendRes(w, nil, errConflict("deployment unit %s has a %s operation %s", req.UUID, du.Operation, du.OperationStatus))
+			return
+		}
+	}
+
+	requestID := logging.RequestID(r.Context())
+	if requestID == "" {
+		requestID = logging.NewRequestID()
+	}
+	if req.CommandKey == "" {
+		req.CommandKey = "changedustate:" + requestID
+	}
+	du.UUID = req.UUID
+	if req.URL != "" {
+		du.URL = req.URL
+	}
+	if req.ExecutionEnvRef != "" {
+		du.ExecutionEnvRef = req.ExecutionEnvRef
+	}
+	du.Operation = req.Operation
+	du.OperationStatus = db.DUOperationPending
+	du.CommandKey = req.CommandKey
+	du.FaultCode, du.FaultString = "", ""
+	du.CompletedAt = nil
+	if err := as.dbH.cwmpIntf.UpsertCwmpDeploymentUnit(du); err != nil {
+		httpSendRes(w, nil, fmt.Errorf("failed to store deployment unit: %w", err))
+		return
+	}
+
+	cmd := &acsbus.Command{
+		DeviceID:   deviceId,
+		Method:     acsbus.MethodChangeDUState,
+		CommandKey: req.CommandKey,
+		RequestID:  requestID,
+		DUOperation: &acsbus.DUOperation{
+			Type:            req.Operation,
+			URL:             req.URL,
+			UUID:            req.UUID,
+			Version:         req.Version,
+			Username:        req.Username,
+			Password:        req.Password,
+			ExecutionEnvRef: req.ExecutionEnvRef,
+		},
+	}
+	if err := as.sendAcsCommand(cmd); err != nil {
+		now := time.Now()
+		du.OperationStatus = db.DUOperationFailed
+		du.FaultString = err.Error()
+		du.CompletedAt = &now
+		if uErr := as.dbH.cwmpIntf.UpsertCwmpDeploymentUnit(du); uErr != nil {
+			log.Printf("Error failing deployment unit operation %q: %v", du.CommandKey, uErr)
+		}
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Sent ChangeDUState %q (%s) to device %s", du.CommandKey, du.Operation, deviceId)
+	httpSendRes(w, du, nil)
+}
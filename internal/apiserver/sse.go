@@ -0,0 +1,107 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const SSE_EVENTS = "/events"
+
+// sseKeepAlive is how often a comment is sent on an idle stream, so that
+// proxies do not close it
+const sseKeepAlive = 15 * time.Second
+
+// streamServerSentEvents streams the events of /ws/events as Server-Sent
+// Events, filtered by the device_id and type query parameters. A client
+// reconnecting with Last-Event-ID, or the last_event_id query parameter,
+// first gets the recent events it missed.
+func (as *ApiServer) streamServerSentEvents(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	rc := http.NewResponseController(w)
+	// The stream outlives the write timeout of the server
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		httpSendRes(w, nil, errBadRequest("streaming is not supported on this connection"))
+		return
+	}
+
+	filter := StreamFilter{
+		DeviceIds: splitQueryList(r.URL.Query()["device_id"]),
+		Types:     splitQueryList(r.URL.Query()["type"]),
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+	sub, missed := as.events.subscribe(filter, lastEventID)
+	defer as.events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	log.Printf("Streaming server-sent events to %s, resuming %d event(s)", r.RemoteAddr, len(missed))
+	for _, event := range missed {
+		if err := writeServerSentEvent(w, event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-as.stopping:
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-sub.events:
+			err = writeServerSentEvent(w, event)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Printf("Server-sent event stream to %s ended: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// writeServerSentEvent writes an event with its ID, so that the client
+// resumes after it
+func writeServerSentEvent(w http.ResponseWriter, event *StreamEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", event.ID, data)
+	return err
+}
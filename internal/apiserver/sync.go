@@ -0,0 +1,74 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_GET_SYNC_JOBS = "/cwmp/sync-jobs/"
+	CWMP_GET_SYNC_JOB  = "/cwmp/sync-jobs/{jobId}"
+	CWMP_GET_SNAPSHOT  = "/cwmp/device/{deviceId}/snapshot"
+)
+
+// getCwmpSyncJobs lists the BOOTSTRAP synchronization jobs, newest first
+func (as *ApiServer) getCwmpSyncJobs(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	query := r.URL.Query()
+	if deviceId := query.Get("device_id"); deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if status := query.Get("status"); status != "" {
+		filter["status"] = status
+	}
+	if trigger := query.Get("trigger"); trigger != "" {
+		filter["trigger"] = trigger
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	jobs, err := as.dbH.cwmpIntf.GetCwmpSyncJobs(filter, limit)
+	httpSendRes(w, jobs, err)
+}
+
+func (as *ApiServer) getCwmpSyncJob(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	job, err := as.dbH.cwmpIntf.GetCwmpSyncJob(mux.Vars(r)["jobId"])
+	httpSendRes(w, job, err)
+}
+
+// getCwmpSnapshot returns the latest baseline parameter snapshot of a device
+func (as *ApiServer) getCwmpSnapshot(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	snapshot, err := as.dbH.cwmpIntf.GetLatestCwmpParameterSnapshot(mux.Vars(r)["deviceId"])
+	httpSendRes(w, snapshot, err)
+}
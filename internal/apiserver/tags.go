@@ -0,0 +1,127 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	CWMP_TAGS        = "/cwmp/tags"
+	CWMP_DEVICE_TAGS = "/cwmp/device/{deviceId}/tags"
+	CWMP_DEVICE_TAG  = "/cwmp/device/{deviceId}/tags/{tag}"
+)
+
+// tagRe is the format of device tags
+var tagRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,63}$`)
+
+// CwmpDeviceTags are the tags of a device
+type CwmpDeviceTags struct {
+	DeviceId string   `json:"device_id"`
+	Tags     []string `json:"tags"`
+}
+
+// CwmpTagRequest names the tags to add to a device
+type CwmpTagRequest struct {
+	Tags []string `json:"tags"`
+}
+
+func (as *ApiServer) setCwmpTagRoutesHandlers() {
+	as.router.HandleFunc(CWMP_TAGS, as.getCwmpTags).Methods("GET")
+	as.router.HandleFunc(CWMP_DEVICE_TAGS, as.getCwmpDeviceTags).Methods("GET")
+	as.router.HandleFunc(CWMP_DEVICE_TAGS, as.addCwmpDeviceTags).Methods("POST")
+	as.router.HandleFunc(CWMP_DEVICE_TAG, as.removeCwmpDeviceTag).Methods("DELETE")
+}
+
+// getCwmpTags lists the tags of the devices which are not archived with the
+// number of devices carrying each of them, most used first
+func (as *ApiServer) getCwmpTags(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	counts, err := as.dbH.cwmpIntf.GetCwmpTagCounts(notArchived)
+	httpSendRes(w, counts, err)
+}
+
+func (as *ApiServer) getCwmpDeviceTags(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	deviceId := mux.Vars(r)["deviceId"]
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	tags := device.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	httpSendRes(w, &CwmpDeviceTags{DeviceId: deviceId, Tags: tags}, nil)
+}
+
+// addCwmpDeviceTags adds the tags of the request to a device, tags it
+// carries already are kept once
+func (as *ApiServer) addCwmpDeviceTags(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req CwmpTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if len(req.Tags) == 0 {
+		httpSendRes(w, nil, errBadRequest("tags are required"))
+		return
+	}
+	for _, tag := range req.Tags {
+		if !tagRe.MatchString(tag) {
+			httpSendRes(w, nil, errBadRequest("invalid tag %q, tags are up to 64 letters, digits, '_', '.', ':' or '-'", tag))
+			return
+		}
+	}
+	deviceId := mux.Vars(r)["deviceId"]
+	tags, err := as.dbH.cwmpIntf.AddCwmpDeviceTags(deviceId, req.Tags)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, &CwmpDeviceTags{DeviceId: deviceId, Tags: tags}, nil)
+}
+
+// removeCwmpDeviceTag removes a tag from a device, removing a tag the
+// device does not carry is not an error
+func (as *ApiServer) removeCwmpDeviceTag(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	vars := mux.Vars(r)
+	tags, err := as.dbH.cwmpIntf.RemoveCwmpDeviceTags(vars["deviceId"], []string{vars["tag"]})
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, &CwmpDeviceTags{DeviceId: vars["deviceId"], Tags: tags}, nil)
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	CWMP_TASKS        = "/cwmp/tasks/"
+	CWMP_TASK         = "/cwmp/tasks/{id}"
+	CWMP_DEVICE_TASKS = "/cwmp/device/{deviceId}/tasks"
+)
+
+func (as *ApiServer) setCwmpTaskRoutesHandlers() {
+	as.router.HandleFunc(CWMP_TASKS, as.getCwmpTasks).Methods("GET")
+	as.router.HandleFunc(CWMP_TASK, as.getCwmpTask).Methods("GET")
+	as.router.HandleFunc(CWMP_DEVICE_TASKS, as.getCwmpTasks).Methods("GET")
+}
+
+// getCwmpTasks lists the tasks of devices, newest first, optionally those
+// of the device of the path
+func (as *ApiServer) getCwmpTasks(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	query := r.URL.Query()
+	for _, key := range []string{"device_id", "status", "method", "request_id"} {
+		if value := query.Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	if deviceId := mux.Vars(r)["deviceId"]; deviceId != "" {
+		filter["device_id"] = deviceId
+	}
+	if err := as.scopeDeviceIDFilter(r.Context(), filter); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	tasks, err := as.dbH.cwmpIntf.GetCwmpTasks(filter, limit)
+	httpSendRes(w, tasks, err)
+}
+
+func (as *ApiServer) getCwmpTask(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	task, err := as.dbH.cwmpIntf.GetCwmpTask(mux.Vars(r)["id"])
+	if err == nil && as.checkTenantDevice(r.Context(), task.DeviceID) != nil {
+		err = errNotFound("task %s not found", task.ID)
+	}
+	httpSendRes(w, task, err)
+}
+
+// createCwmpTask records a task for a command handed to the ACS and returns
+// its ID. The command was sent already, so a task which cannot be stored is
+// only logged and no ID is returned.
+func (as *ApiServer) createCwmpTask(deviceId string, method string, requestID string) string {
+	if as.dbH.cwmpIntf == nil || requestID == "" {
+		return ""
+	}
+	task := &db.CwmpTask{DeviceID: deviceId, Method: method, RequestID: requestID}
+	if err := as.dbH.cwmpIntf.InsertCwmpTask(task); err != nil {
+		logging.ForRequest(requestID).Errorf("Error storing %s task of device %s: %v", method, deviceId, err)
+		return ""
+	}
+	return task.ID
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const CWMP_DEVICE_TENANT = "/cwmp/device/{deviceId}/tenant"
+
+// tenantRoutes are the routes open to the users of a tenant besides the
+// routes of its devices, their handlers scope the results to the tenant
+var tenantRoutes = map[string]bool{
+	HEALTH:              true,
+	DEVICES:             true,
+	CWMP_GET_DEVICES:    true,
+	CWMP_SEARCH_DEVICES: true,
+	CWMP_EXPORT_DEVICES: true,
+	CWMP_TASKS:          true,
+	CWMP_TASK:           true,
+	GRAPHQL:             true,
+	GET_QUOTA:           true,
+}
+
+// tenantDeniedRoutes are the device routes reserved to the operators of the
+// instance
+var tenantDeniedRoutes = map[string]bool{
+	CWMP_DEVICE_TENANT: true,
+}
+
+// tenantKey is the context key of the tenant of the authenticated user
+type tenantKey struct{}
+
+// CwmpDeviceTenant is the tenant a device is assigned to
+type CwmpDeviceTenant struct {
+	DeviceId string `json:"device_id"`
+	Tenant   string `json:"tenant"`
+}
+
+// CwmpTenantRequest assigns a device to a tenant, an empty tenant leaves it
+// unassigned
+type CwmpTenantRequest struct {
+	Tenant string `json:"tenant"`
+}
+
+func (as *ApiServer) setTenantRoutesHandlers() {
+	as.router.HandleFunc(CWMP_DEVICE_TENANT, as.setCwmpDeviceTenant).Methods("PUT")
+}
+
+// requestTenant returns the tenant the authenticated user belongs to, empty
+// for the operators of the instance
+func requestTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// middlewareTenantScope restricts the users of a tenant to the routes of its
+// devices and to the listings scoped to the tenant. Users without a tenant
+// operate the whole instance.
+func (as *ApiServer) middlewareTenantScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username := requestUser(r)
+		if username == "" || as.quota == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tenant, ok := as.quota.TenantOfUser(username)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant))
+		if err := as.checkTenantRoute(r, tenant); err != nil {
+			logging.FromContext(r.Context()).Warnf("Refused %s %s to user %q of tenant %s: %v",
+				r.Method, r.URL.Path, username, tenant, err)
+			httpSendRes(w, nil, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkTenantRoute checks that the route of a request is open to the users
+// of tenant
+func (as *ApiServer) checkTenantRoute(r *http.Request, tenant string) error {
+	var path string
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			path = apiPath(tmpl)
+		}
+	}
+	switch {
+	case tenantDeniedRoutes[path]:
+	case strings.HasPrefix(path, CWMP_GET_DEVICE):
+		return as.checkTenantDevice(r.Context(), mux.Vars(r)["deviceId"])
+	case path == GET_QUOTA:
+		if mux.Vars(r)["tenant"] == tenant {
+			return nil
+		}
+	case tenantRoutes[path]:
+		return nil
+	}
+	return errForbidden("%s %s is not available to the users of tenant %s", r.Method, r.URL.Path, tenant)
+}
+
+// checkTenantDevice reports a device of another tenant as not found to the
+// users of a tenant
+func (as *ApiServer) checkTenantDevice(ctx context.Context, deviceId string) error {
+	tenant := requestTenant(ctx)
+	if tenant == "" {
+		return nil
+	}
+	if as.dbH.cwmpIntf == nil {
+		return errCwmpDbNotConnected
+	}
+	device, err := as.dbH.cwmpIntf.GetCwmpDeviceByID(deviceId)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if err != nil || device.Tenant != tenant {
+		return errDeviceNotFound(deviceId)
+	}
+	return nil
+}
+
+// scopeDeviceFilter restricts a device filter to the devices of the tenant
+// of the request
+func scopeDeviceFilter(ctx context.Context, filter bson.M) {
+	if tenant := requestTenant(ctx); tenant != "" {
+		filter["tenant"] = tenant
+	}
+}
+
+// scopeDeviceIDFilter restricts a task or event filter to the devices of the
+// tenant of the request
+func (as *ApiServer) scopeDeviceIDFilter(ctx context.Context, filter bson.M) error {
+	tenant := requestTenant(ctx)
+	if tenant == "" {
+		return nil
+	}
+	if deviceId, ok := filter["device_id"].(string); ok {
+		return as.checkTenantDevice(ctx, deviceId)
+	}
+	ids, err := as.dbH.cwmpIntf.DistinctCwmpDeviceValues("_id", bson.M{"tenant": tenant})
+	if err != nil {
+		return err
+	}
+	filter["device_id"] = bson.M{"$in": ids}
+	return nil
+}
+
+// setCwmpDeviceTenant assigns a device to a configured tenant, overriding
+// the assignment of the provisioning rules
+func (as *ApiServer) setCwmpDeviceTenant(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req CwmpTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if req.Tenant != "" && (as.quota == nil || !as.quota.HasTenant(req.Tenant)) {
+		httpSendRes(w, nil, errBadRequest("unknown tenant %s", req.Tenant))
+		return
+	}
+	deviceId := mux.Vars(r)["deviceId"]
+	if err := as.dbH.cwmpIntf.SetCwmpDeviceTenant(deviceId, req.Tenant); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	logging.FromContext(r.Context()).Infof("Device %s assigned to tenant %q", deviceId, req.Tenant)
+	httpSendRes(w, &CwmpDeviceTenant{DeviceId: deviceId, Tenant: req.Tenant}, nil)
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Client certificate modes of the HTTPS listener
+const (
+	clientAuthNone     = "none"
+	clientAuthOptional = "optional"
+	clientAuthRequire  = "require"
+)
+
+// certCheckInterval is how often the certificate files are checked for
+// changes
+const certCheckInterval = 10 * time.Second
+
+// tlsFiles are the files the TLS configuration of the API server is built
+// from
+type tlsFiles struct {
+	certFile     string
+	keyFile      string
+	clientAuth   string
+	clientCAFile string
+}
+
+// newTLSFiles takes the certificate settings of protocols.http, falling back
+// to the ones of security.tls
+func newTLSFiles(cfg *config.Config) (tlsFiles, error) {
+	httpCfg := cfg.Protocols.HTTP
+	files := tlsFiles{
+		certFile:     httpCfg.CertFile,
+		keyFile:      httpCfg.KeyFile,
+		clientAuth:   httpCfg.ClientAuth,
+		clientCAFile: httpCfg.ClientCAFile,
+	}
+	if files.certFile == "" {
+		files.certFile = cfg.Security.TLS.CertFile
+	}
+	if files.keyFile == "" {
+		files.keyFile = cfg.Security.TLS.KeyFile
+	}
+	if files.clientCAFile == "" {
+		files.clientCAFile = cfg.Security.TLS.CACertFile
+	}
+	if files.certFile == "" || files.keyFile == "" {
+		return files, errors.New("TLS requires a certificate and a key file")
+	}
+	switch files.clientAuth {
+	case "", clientAuthNone:
+	case clientAuthOptional, clientAuthRequire:
+		if files.clientCAFile == "" {
+			return files, fmt.Errorf("client auth %s requires a client CA file", files.clientAuth)
+		}
+	default:
+		return files, fmt.Errorf("invalid client auth mode: %s", files.clientAuth)
+	}
+	return files, nil
+}
+
+// certReloader serves the TLS configuration of the HTTPS listener and
+// reloads it when its files change or on SIGHUP, the connections already
+// established keep their certificate
+type certReloader struct {
+	files tlsFiles
+
+	mu      sync.RWMutex
+	current *tls.Config
+	modTime time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func newCertReloader(files tlsFiles) (*certReloader, error) {
+	cr := &certReloader{files: files, stop: make(chan struct{})}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// tlsConfig is the configuration given to the http.Server
+func (cr *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cr.mu.RLock()
+			defer cr.mu.RUnlock()
+			return cr.current, nil
+		},
+	}
+}
+
+// reload loads the certificate, key and client CA bundle, the previous
+// configuration is kept when one of them cannot be loaded
+func (cr *certReloader) reload() error {
+	modTime := cr.latestModTime()
+	cert, err := tls.LoadX509KeyPair(cr.files.certFile, cr.files.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	current := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	switch cr.files.clientAuth {
+	case clientAuthOptional:
+		current.ClientAuth = tls.VerifyClientCertIfGiven
+	case clientAuthRequire:
+		current.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if current.ClientAuth != tls.NoClientCert {
+		bundle, err := os.ReadFile(cr.files.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no certificate found in client CA file %s", cr.files.clientCAFile)
+		}
+		current.ClientCAs = pool
+	}
+
+	cr.mu.Lock()
+	cr.current = current
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// latestModTime is the modification time of the most recently changed file
+func (cr *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, name := range []string{cr.files.certFile, cr.files.keyFile, cr.files.clientCAFile} {
+		if name == "" {
+			continue
+		}
+		if fi, err := os.Stat(name); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+func (cr *certReloader) changed() bool {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.latestModTime().After(cr.modTime)
+}
+
+// watch reloads the certificates on SIGHUP and when their files change,
+// until close is called
+func (cr *certReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cr.stop:
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading TLS certificates")
+		case <-ticker.C:
+			if !cr.changed() {
+				continue
+			}
+			log.Println("TLS certificate files changed, reloading")
+		}
+		if err := cr.reload(); err != nil {
+			log.Println("Error reloading TLS certificates, keeping the current ones:", err)
+		} else {
+			log.Println("TLS certificates reloaded from", cr.files.certFile)
+		}
+	}
+}
+
+func (cr *certReloader) close() {
+	cr.once.Do(func() { close(cr.stop) })
+}
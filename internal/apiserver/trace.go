@@ -0,0 +1,168 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+const (
+	CWMP_DEVICE_TRACE = "/cwmp/device/{deviceId}/trace"
+	CWMP_TRACE_STREAM = "/cwmp/device/{deviceId}/trace/stream"
+)
+
+const (
+	defaultTraceDuration = time.Hour
+	maxTraceDuration     = 24 * time.Hour
+)
+
+// CwmpTraceRequest enables or disables SOAP tracing of a device
+type CwmpTraceRequest struct {
+	Enabled  bool   `json:"enabled"`
+	Duration string `json:"duration,omitempty"`
+}
+
+// CwmpTraceStatus reports whether SOAP tracing is enabled for a device
+type CwmpTraceStatus struct {
+	DeviceId string     `json:"device_id"`
+	Enabled  bool       `json:"enabled"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+var traceUpgrader = websocket.Upgrader{}
+
+func newCwmpTraceStatus(device *db.CwmpDevice) *CwmpTraceStatus {
+	status := &CwmpTraceStatus{DeviceId: device.ID, Enabled: device.TraceActive()}
+	if status.Enabled {
+		status.Until = device.TraceUntil
+	}
+	return status
+}
+
+func (as *ApiServer) getCwmpTrace(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	device, err := as.loadCwmpDevice(mux.Vars(r)["deviceId"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, newCwmpTraceStatus(device), nil)
+}
+
+// setCwmpTrace enables SOAP tracing of a device for a limited duration, or
+// disables it
+func (as *ApiServer) setCwmpTrace(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var req CwmpTraceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	var until *time.Time
+	if req.Enabled {
+		duration := defaultTraceDuration
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 || d > maxTraceDuration {
+				httpSendRes(w, nil, errBadRequest("duration must be a positive duration up to %s", maxTraceDuration))
+				return
+			}
+			duration = d
+		}
+		t := time.Now().Add(duration)
+		until = &t
+	}
+
+	deviceId := mux.Vars(r)["deviceId"]
+	if err := as.dbH.cwmpIntf.SetCwmpDeviceTrace(deviceId, until); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	log.Printf("SOAP tracing of device %s enabled: %v", deviceId, req.Enabled)
+	httpSendRes(w, &CwmpTraceStatus{DeviceId: deviceId, Enabled: req.Enabled, Until: until}, nil)
+}
+
+// streamCwmpTrace pushes the SOAP exchanges of a traced device to a
+// WebSocket client as they happen, until the client goes away or tracing
+// expires
+func (as *ApiServer) streamCwmpTrace(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	deviceId := mux.Vars(r)["deviceId"]
+	device, err := as.loadCwmpDevice(deviceId)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if !device.TraceActive() {
+		httpSendRes(w, nil, errConflict("tracing is not enabled for device %s", deviceId))
+		return
+	}
+
+	conn, err := traceUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Trace stream upgrade failed for device %s: %v", deviceId, err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithDeadline(context.Background(), *device.TraceUntil)
+	defer cancel()
+	go func() {
+		select {
+		case <-as.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// The client does not send anything, reading only detects that it left
+	go func() {
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	log.Printf("Streaming SOAP trace of device %s to %s", deviceId, r.RemoteAddr)
+	err = as.dbH.cwmpIntf.WatchCwmpTraces(ctx, deviceId, func(trace *db.CwmpTrace) error {
+		return conn.WriteJSON(trace)
+	})
+	if errors.Is(err, context.DeadlineExceeded) {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "tracing expired")
+		conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	} else if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Trace stream of device %s ended: %v", deviceId, err)
+	}
+}
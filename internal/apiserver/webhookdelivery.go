@@ -0,0 +1,276 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/config"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// Delivery defaults, overridden by the webhooks section of the config
+	webhookMaxAttempts = 8
+	webhookBackoff     = 30 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookTimeout     = 10 * time.Second
+
+	// webhookWorkerInterval is how often the due deliveries are sent
+	webhookWorkerInterval = time.Second
+	// webhookWorkerBatch bounds the deliveries sent per run of the worker
+	webhookWorkerBatch = 100
+	// webhookCacheTTL is how long the enabled webhooks are cached, CRUD
+	// through this API server invalidates the cache right away
+	webhookCacheTTL = 30 * time.Second
+)
+
+// webhookDispatcher queues a delivery for each event matching an enabled
+// webhook and sends the due deliveries
+type webhookDispatcher struct {
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	timeout     time.Duration
+
+	mu       sync.Mutex
+	hooks    []db.Webhook
+	loadedAt time.Time
+}
+
+func newWebhookDispatcher(cfg config.WebhookConfig) *webhookDispatcher {
+	d := &webhookDispatcher{
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     cfg.Backoff,
+		maxBackoff:  cfg.MaxBackoff,
+		timeout:     cfg.Timeout,
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = webhookMaxAttempts
+	}
+	if d.backoff <= 0 {
+		d.backoff = webhookBackoff
+	}
+	if d.maxBackoff <= 0 {
+		d.maxBackoff = webhookMaxBackoff
+	}
+	if d.timeout <= 0 {
+		d.timeout = webhookTimeout
+	}
+	d.client = &http.Client{Timeout: d.timeout}
+	return d
+}
+
+// invalidate drops the cached webhooks after they were changed
+func (d *webhookDispatcher) invalidate() {
+	d.mu.Lock()
+	d.loadedAt = time.Time{}
+	d.mu.Unlock()
+}
+
+// enabledHooks returns the enabled webhooks, reloading them once the cache
+// expired
+func (d *webhookDispatcher) enabledHooks(cwmpDb *db.CwmpDb) ([]db.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.loadedAt) < webhookCacheTTL {
+		return d.hooks, nil
+	}
+	hooks, err := cwmpDb.GetWebhooks(bson.M{"enabled": true})
+	if err != nil {
+		return nil, err
+	}
+	d.hooks = hooks
+	d.loadedAt = time.Now()
+	return hooks, nil
+}
+
+// retryDelay is the backoff after the given number of failed attempts
+func (d *webhookDispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < d.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.maxBackoff {
+		delay = d.maxBackoff
+	}
+	return delay
+}
+
+// startWebhooks subscribes the webhooks to the event stream and starts the
+// delivery worker
+func (as *ApiServer) startWebhooks() {
+	as.webhooks = newWebhookDispatcher(as.config.Webhooks)
+	sub, _ := as.events.subscribe(StreamFilter{}, "")
+	go func() {
+		for event := range sub.events {
+			as.queueWebhookDeliveries(event)
+		}
+	}()
+	as.every(webhookWorkerInterval, as.sendDueWebhookDeliveries)
+}
+
+// queueWebhookDeliveries stores a delivery of the event for every enabled
+// webhook whose filter matches it
+func (as *ApiServer) queueWebhookDeliveries(event *StreamEvent) {
+	cwmpDb := as.dbH.cwmpIntf
+	if cwmpDb == nil {
+		return
+	}
+	hooks, err := as.webhooks.enabledHooks(cwmpDb)
+	if err != nil {
+		log.Printf("Error loading webhooks: %v", err)
+		return
+	}
+	var payload []byte
+	for _, hook := range hooks {
+		filter := StreamFilter{DeviceIds: hook.DeviceIDs, Types: hook.Types}
+		if !filter.matches(event) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				log.Printf("Error encoding %s event for webhooks: %v", event.Type, err)
+				return
+			}
+		}
+		now := time.Now()
+		d := &db.WebhookDelivery{
+			WebhookID:     hook.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			DeviceID:      event.DeviceID,
+			Payload:       string(payload),
+			NextAttemptAt: &now,
+		}
+		if err := cwmpDb.InsertWebhookDelivery(d); err != nil {
+			log.Printf("Error queuing %s event for webhook %s: %v", event.Type, hook.ID, err)
+		}
+	}
+}
+
+// sendDueWebhookDeliveries sends the deliveries whose next attempt is due.
+// A delivery is leased for the request timeout before it is sent, so that
+// it is retried if the API server stops while sending it.
+func (as *ApiServer) sendDueWebhookDeliveries() {
+	cwmpDb := as.dbH.cwmpIntf
+	if cwmpDb == nil {
+		return
+	}
+	deliveries, err := cwmpDb.GetDueWebhookDeliveries(webhookWorkerBatch)
+	if err != nil {
+		log.Printf("Error loading due webhook deliveries: %v", err)
+		return
+	}
+	hooks := map[string]*db.Webhook{}
+	for i := range deliveries {
+		d := &deliveries[i]
+		hook, ok := hooks[d.WebhookID]
+		if !ok {
+			if hook, err = cwmpDb.GetWebhook(d.WebhookID); err != nil {
+				log.Printf("Error loading webhook %s of delivery %s: %v", d.WebhookID, d.ID, err)
+			}
+			hooks[d.WebhookID] = hook
+		}
+		if hook == nil {
+			continue
+		}
+
+		lease := time.Now().Add(as.webhooks.timeout)
+		d.NextAttemptAt = &lease
+		if err := cwmpDb.UpdateWebhookDelivery(d); err != nil {
+			log.Printf("Error leasing webhook delivery %s: %v", d.ID, err)
+			continue
+		}
+		as.webhooks.deliver(hook, d)
+		if err := cwmpDb.UpdateWebhookDelivery(d); err != nil {
+			log.Printf("Error updating webhook delivery %s: %v", d.ID, err)
+		}
+	}
+}
+
+// deliver posts a delivery to its webhook and updates its status, retry
+// time or dead-letters it
+func (d *webhookDispatcher) deliver(hook *db.Webhook, delivery *db.WebhookDelivery) {
+	delivery.Attempts++
+	status, err := d.post(hook, delivery)
+	delivery.LastStatus = status
+	now := time.Now()
+	if err == nil {
+		delivery.Status = db.WebhookDeliveryDelivered
+		delivery.LastError = ""
+		delivery.NextAttemptAt = nil
+		delivery.DeliveredAt = &now
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= d.maxAttempts {
+		log.Printf("Dead-lettering delivery %s to webhook %s after %d attempts: %v", delivery.ID, hook.ID, delivery.Attempts, err)
+		delivery.Status = db.WebhookDeliveryDead
+		delivery.NextAttemptAt = nil
+		return
+	}
+	next := now.Add(d.retryDelay(delivery.Attempts))
+	delivery.NextAttemptAt = &next
+}
+
+// post sends the payload signed with the webhook secret, any 2xx response
+// acknowledges it
+func (d *webhookDispatcher) post(hook *db.Webhook, delivery *db.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OpenUSP-Webhook/"+getVer())
+	req.Header.Set("X-OpenUSP-Event", delivery.EventType)
+	req.Header.Set("X-OpenUSP-Delivery", delivery.ID)
+	req.Header.Set("X-OpenUSP-Timestamp", timestamp)
+	req.Header.Set("X-OpenUSP-Signature", "sha256="+signWebhookPayload(hook.Secret, timestamp, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("webhook responded %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
+
+// signWebhookPayload returns the hex HMAC-SHA256 of the timestamp and
+// payload joined by a dot
+func signWebhookPayload(secret, timestamp, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	WEBHOOKS           = "/webhooks/"
+	WEBHOOK            = "/webhooks/{id}"
+	WEBHOOK_DELIVERIES = "/webhooks/{id}/deliveries"
+	WEBHOOK_REDELIVER  = "/webhooks/{id}/deliveries/{deliveryId}/redeliver"
+)
+
+// WebhookRequest creates or replaces a webhook. A webhook created without
+// secret gets a generated one, which is only returned by the creation.
+type WebhookRequest struct {
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Types     []string `json:"types,omitempty"`
+	DeviceIds []string `json:"device_ids,omitempty"`
+	Enabled   *bool    `json:"enabled,omitempty"`
+}
+
+// WebhookInfo is a webhook as returned by the API, the secret is only set
+// when it was generated
+type WebhookInfo struct {
+	*db.Webhook
+	Secret string `json:"secret,omitempty"`
+}
+
+func (as *ApiServer) setWebhookRoutesHandlers() {
+	as.router.HandleFunc(WEBHOOKS, as.getWebhooks).Methods("GET")
+	as.router.HandleFunc(WEBHOOKS, as.createWebhook).Methods("POST")
+	as.router.HandleFunc(WEBHOOK, as.getWebhook).Methods("GET")
+	as.router.HandleFunc(WEBHOOK, as.updateWebhook).Methods("PUT")
+	as.router.HandleFunc(WEBHOOK, as.deleteWebhook).Methods("DELETE")
+	as.router.HandleFunc(WEBHOOK_DELIVERIES, as.getWebhookDeliveries).Methods("GET")
+	as.router.HandleFunc(WEBHOOK_REDELIVER, as.redeliverWebhook).Methods("POST")
+}
+
+func (as *ApiServer) getWebhooks(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	hooks, err := as.dbH.cwmpIntf.GetWebhooks(bson.M{})
+	httpSendRes(w, hooks, err)
+}
+
+func (as *ApiServer) getWebhook(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	hook, err := as.dbH.cwmpIntf.GetWebhook(mux.Vars(r)["id"])
+	httpSendRes(w, hook, err)
+}
+
+func (as *ApiServer) createWebhook(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	req, err := decodeWebhookRequest(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	hook := &db.Webhook{URL: req.URL, Secret: req.Secret, Types: req.Types, DeviceIDs: req.DeviceIds, Enabled: true}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	info := &WebhookInfo{Webhook: hook}
+	if hook.Secret == "" {
+		if hook.Secret, err = newWebhookSecret(); err != nil {
+			httpSendRes(w, nil, err)
+			return
+		}
+		info.Secret = hook.Secret
+	}
+	if err := as.dbH.cwmpIntf.InsertWebhook(hook); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.webhooks.invalidate()
+	httpSendRes(w, info, nil)
+}
+
+// updateWebhook replaces a webhook, its secret is kept unless the request
+// sets one
+func (as *ApiServer) updateWebhook(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	hook, err := as.dbH.cwmpIntf.GetWebhook(mux.Vars(r)["id"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	req, err := decodeWebhookRequest(r)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+
+	hook.URL = req.URL
+	hook.Types = req.Types
+	hook.DeviceIDs = req.DeviceIds
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if req.Enabled != nil {
+		hook.Enabled = *req.Enabled
+	}
+	if err := as.dbH.cwmpIntf.UpdateWebhook(hook); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	as.webhooks.invalidate()
+	httpSendRes(w, hook, nil)
+}
+
+func (as *ApiServer) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	err := as.dbH.cwmpIntf.DeleteWebhook(mux.Vars(r)["id"])
+	if err == nil {
+		as.webhooks.invalidate()
+	}
+	httpSendRes(w, nil, err)
+}
+
+// getWebhookDeliveries lists the deliveries of a webhook, newest first. The
+// dead-lettered deliveries are listed with status=dead.
+func (as *ApiServer) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	filter := bson.M{"webhook_id": mux.Vars(r)["id"]}
+	if status := r.URL.Query().Get("status"); status != "" {
+		filter["status"] = status
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	deliveries, err := as.dbH.cwmpIntf.GetWebhookDeliveries(filter, limit)
+	httpSendRes(w, deliveries, err)
+}
+
+// redeliverWebhook queues a delivery again with a fresh set of attempts,
+// typically a dead-lettered one once the endpoint is fixed
+func (as *ApiServer) redeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	vars := mux.Vars(r)
+	d, err := as.dbH.cwmpIntf.GetWebhookDelivery(vars["deliveryId"])
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	if d.WebhookID != vars["id"] {
+		httpSendRes(w, nil, errNotFound("delivery %s of webhook %s not found", vars["deliveryId"], vars["id"]))
+		return
+	}
+	if d.Status == db.WebhookDeliveryPending {
+		httpSendRes(w, nil, errConflict("delivery %s is pending", d.ID))
+		return
+	}
+
+	now := time.Now()
+	d.Status = db.WebhookDeliveryPending
+	d.Attempts = 0
+	d.NextAttemptAt = &now
+	err = as.dbH.cwmpIntf.UpdateWebhookDelivery(d)
+	httpSendRes(w, d, err)
+}
+
+func decodeWebhookRequest(r *http.Request) (*WebhookRequest, error) {
+	var req WebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, errBadRequest("invalid request body: %w", err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errBadRequest("url must be an http or https URL")
+	}
+	return &req, nil
+}
+
+// newWebhookSecret returns a random secret signing the deliveries
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
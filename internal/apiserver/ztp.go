@@ -0,0 +1,159 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apiserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	ZTP_TEMPLATES = "/ztp/templates/"
+	ZTP_TEMPLATE  = "/ztp/templates/{name}"
+	ZTP_DEVICES   = "/ztp/devices/"
+)
+
+func (as *ApiServer) setZtpRoutesHandlers() {
+	as.router.HandleFunc(ZTP_TEMPLATES, as.getZtpTemplates).Methods("GET")
+	as.router.HandleFunc(ZTP_TEMPLATES, as.setZtpTemplate).Methods("POST")
+	as.router.HandleFunc(ZTP_TEMPLATE, as.getZtpTemplate).Methods("GET")
+	as.router.HandleFunc(ZTP_TEMPLATE, as.deleteZtpTemplate).Methods("DELETE")
+	as.router.HandleFunc(ZTP_DEVICES, as.getZtpDevices).Methods("GET")
+}
+
+// getZtpTemplates lists the zero-touch provisioning templates, optionally
+// those of a protocol
+func (as *ApiServer) getZtpTemplates(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	if protocol := r.URL.Query().Get("protocol"); protocol != "" {
+		filter["protocol"] = bson.M{"$in": bson.A{protocol, "", nil}}
+	}
+	templates, err := as.dbH.cwmpIntf.GetZtpTemplates(filter)
+	httpSendRes(w, templates, err)
+}
+
+func (as *ApiServer) getZtpTemplate(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+	template, err := as.dbH.cwmpIntf.GetZtpTemplate(mux.Vars(r)["name"])
+	httpSendRes(w, template, err)
+}
+
+// setZtpTemplate creates or replaces a zero-touch provisioning template
+func (as *ApiServer) setZtpTemplate(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	var template db.ZtpTemplate
+	if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+		httpSendRes(w, nil, errBadRequest("invalid request body: %w", err))
+		return
+	}
+	if template.Name == "" {
+		httpSendRes(w, nil, errBadRequest("template name is required"))
+		return
+	}
+	switch template.Protocol {
+	case "", db.ZtpProtocolCwmp, db.ZtpProtocolUsp:
+	default:
+		httpSendRes(w, nil, errBadRequest("invalid protocol %q, expected cwmp or usp", template.Protocol))
+		return
+	}
+	if template.SerialFrom != "" && template.SerialTo != "" && db.CompareSerials(template.SerialFrom, template.SerialTo) > 0 {
+		httpSendRes(w, nil, errBadRequest("serial_from must not be after serial_to"))
+		return
+	}
+	if len(template.Parameters) == 0 && len(template.Downloads) == 0 {
+		httpSendRes(w, nil, errBadRequest("template sets no parameter and downloads no file"))
+		return
+	}
+	for _, p := range template.Parameters {
+		if p.Path == "" {
+			httpSendRes(w, nil, errBadRequest("template parameters require a path"))
+			return
+		}
+	}
+	for i := range template.Downloads {
+		if template.Downloads[i].URL == "" {
+			httpSendRes(w, nil, errBadRequest("template downloads require a url"))
+			return
+		}
+		if template.Downloads[i].FileType == "" {
+			template.Downloads[i].FileType = db.ZtpFileTypeFirmware
+		}
+	}
+	if template.Parameters == nil {
+		template.Parameters = []db.ProfileParameter{}
+	}
+	if template.Downloads == nil {
+		template.Downloads = []db.ZtpDownload{}
+	}
+
+	if err := as.dbH.cwmpIntf.UpsertZtpTemplate(&template); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, template, nil)
+}
+
+func (as *ApiServer) deleteZtpTemplate(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := as.dbH.cwmpIntf.DeleteZtpTemplate(name); err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	httpSendRes(w, map[string]string{"name": name, "status": "deleted"}, nil)
+}
+
+// getZtpDevices lists the devices seen by zero-touch provisioning with the
+// template applied to each, filtered by device_id, protocol or template
+func (as *ApiServer) getZtpDevices(w http.ResponseWriter, r *http.Request) {
+	if as.dbH.cwmpIntf == nil {
+		httpSendRes(w, nil, errCwmpDbNotConnected)
+		return
+	}
+
+	filter := bson.M{}
+	for _, key := range []string{"device_id", "protocol", "template"} {
+		if value := r.URL.Query().Get(key); value != "" {
+			filter[key] = value
+		}
+	}
+	limit, err := queryLimit(r, 100)
+	if err != nil {
+		httpSendRes(w, nil, err)
+		return
+	}
+	devices, err := as.dbH.cwmpIntf.GetZtpDevices(filter, limit)
+	httpSendRes(w, devices, err)
+}
@@ -0,0 +1,265 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	aliasHelp   = "alias [name [command ...]] - list, show or define an alias, {1}..{n} are replaced by its arguments, {*} by all of them, ';' separates the commands of a macro"
+	unaliasHelp = "unalias <name> - remove an alias"
+
+	// aliasMaxDepth limits aliases calling other aliases
+	aliasMaxDepth = 8
+)
+
+// aliasPlaceholder matches the positional placeholders of an alias
+var aliasPlaceholder = regexp.MustCompile(`\{(\*|[0-9]+)\}`)
+
+// shellBuiltins are the commands provided by the shell itself
+var shellBuiltins = []string{"help", "exit", "clear"}
+
+func (cli *Cli) registerAliasCmds() {
+	cmds := []*ishell.Cmd{
+		{Name: "alias", Help: aliasHelp, Func: cli.alias},
+		{Name: "unalias", Help: unaliasHelp, Func: cli.unalias},
+	}
+	for _, cmd := range cmds {
+		cli.sh.shell.AddCmd(cmd)
+		cli.sh.cmds[cmd.Name] = cmd
+	}
+}
+
+// loadAliases registers the aliases of the configuration and of the alias
+// file, the latter overrides the former. An empty command in the alias file
+// removes an alias of the configuration.
+func (cli *Cli) loadAliases() {
+	cli.aliases = make(map[string]string)
+	aliases := make(map[string]string)
+	for name, line := range cli.config.CLI.Aliases {
+		aliases[name] = line
+	}
+
+	saved, err := readAliasFile(cli.cfg.aliasFile)
+	if err != nil {
+		log.Printf("Could not read alias file %s: %v", cli.cfg.aliasFile, err)
+	}
+	for name, line := range saved {
+		aliases[name] = line
+	}
+
+	for name, line := range aliases {
+		if line == "" {
+			continue
+		}
+		if err := cli.setAlias(name, line); err != nil {
+			log.Printf("Skipping alias %s: %v", name, err)
+		}
+	}
+}
+
+// setAlias defines or redefines an alias as a shell command
+func (cli *Cli) setAlias(name string, line string) error {
+	if name == "" || strings.ContainsAny(name, " \t.;") {
+		return fmt.Errorf("invalid alias name %q", name)
+	}
+	if _, isAlias := cli.aliases[name]; !isAlias {
+		if _, exists := cli.sh.cmds[name]; exists {
+			return fmt.Errorf("%s is a command", name)
+		}
+		for _, builtin := range shellBuiltins {
+			if name == builtin {
+				return fmt.Errorf("%s is a command", name)
+			}
+		}
+	}
+	if strings.TrimSpace(line) == "" {
+		return errors.New("alias command is empty")
+	}
+
+	cli.aliases[name] = line
+	cli.sh.shell.DeleteCmd(name)
+	cli.sh.shell.AddCmd(&ishell.Cmd{
+		Name: name,
+		Help: "alias: " + line,
+		Func: func(c *ishell.Context) {
+			cli.runAlias(c, name)
+		},
+	})
+	return nil
+}
+
+// runAlias expands an alias with the given arguments and runs its commands,
+// stopping at the first one which fails
+func (cli *Cli) runAlias(c *ishell.Context, name string) {
+	if cli.aliasDepth >= aliasMaxDepth {
+		cli.lastCmdErr = fmt.Errorf("alias %s: too many nested aliases", name)
+		c.Println("Error:", cli.lastCmdErr)
+		return
+	}
+	line, err := expandAlias(cli.aliases[name], c.Args)
+	if err != nil {
+		cli.lastCmdErr = &errUsage{fmt.Errorf("alias %s: %w", name, err)}
+		c.Println("Error:", cli.lastCmdErr)
+		return
+	}
+
+	cli.aliasDepth++
+	defer func() { cli.aliasDepth-- }()
+	for _, cmd := range strings.Split(line, ";") {
+		tok := strings.Fields(cmd)
+		if len(tok) == 0 {
+			continue
+		}
+		cli.lastCmdErr = nil
+		if err := cli.sh.shell.Process(tok...); err != nil {
+			cli.lastCmdErr = &errUsage{fmt.Errorf("alias %s: %w", name, err)}
+			c.Println("Error:", cli.lastCmdErr)
+			return
+		}
+		if cli.lastCmdErr != nil {
+			return
+		}
+	}
+}
+
+// expandAlias replaces the placeholders of an alias by its arguments. An
+// alias without placeholders gets the arguments appended.
+func expandAlias(line string, args []string) (string, error) {
+	if !aliasPlaceholder.MatchString(line) {
+		return strings.TrimSpace(line + " " + strings.Join(args, " ")), nil
+	}
+
+	var err error
+	expanded := aliasPlaceholder.ReplaceAllStringFunc(line, func(m string) string {
+		key := m[1 : len(m)-1]
+		if key == "*" {
+			return strings.Join(args, " ")
+		}
+		n, _ := strconv.Atoi(key)
+		if n < 1 || n > len(args) {
+			if err == nil {
+				err = fmt.Errorf("missing argument %s", m)
+			}
+			return m
+		}
+		return args[n-1]
+	})
+	return expanded, err
+}
+
+// alias lists the aliases, shows one or defines one
+func (cli *Cli) alias(c *ishell.Context) {
+	switch len(c.Args) {
+	case 0:
+		names := make([]string, 0, len(cli.aliases))
+		for name := range cli.aliases {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			c.Printf("%-20s '%s'\n", name, cli.aliases[name])
+		}
+	case 1:
+		line, ok := cli.aliases[c.Args[0]]
+		if !ok {
+			cli.lastCmdErr = fmt.Errorf("alias %s not found", c.Args[0])
+			c.Println("Error:", cli.lastCmdErr)
+			return
+		}
+		c.Printf("%-20s '%s'\n", c.Args[0], line)
+	default:
+		name := c.Args[0]
+		line := strings.Trim(strings.Join(c.Args[1:], " "), `'"`)
+		if err := cli.setAlias(name, line); err != nil {
+			cli.lastCmdErr = &errUsage{err}
+			c.Println("Error:", err)
+			return
+		}
+		cli.saveAliasChange(name, line)
+	}
+}
+
+func (cli *Cli) unalias(c *ishell.Context) {
+	if len(c.Args) != 1 {
+		cli.lastCmdErr = &errUsage{errors.New(unaliasHelp)}
+		c.Println(unaliasHelp)
+		return
+	}
+	name := c.Args[0]
+	if _, ok := cli.aliases[name]; !ok {
+		cli.lastCmdErr = fmt.Errorf("alias %s not found", name)
+		c.Println("Error:", cli.lastCmdErr)
+		return
+	}
+	delete(cli.aliases, name)
+	cli.sh.shell.DeleteCmd(name)
+	cli.saveAliasChange(name, "")
+}
+
+// saveAliasChange records an alias defined or removed at runtime in the
+// alias file, so that it persists between sessions
+func (cli *Cli) saveAliasChange(name string, line string) {
+	if cli.cfg.aliasFile == "" {
+		return
+	}
+	saved, err := readAliasFile(cli.cfg.aliasFile)
+	if err != nil {
+		log.Printf("Could not read alias file %s: %v", cli.cfg.aliasFile, err)
+		return
+	}
+	if _, configured := cli.config.CLI.Aliases[name]; line == "" && !configured {
+		delete(saved, name)
+	} else {
+		saved[name] = line
+	}
+
+	data, err := yaml.Marshal(saved)
+	if err == nil {
+		err = os.WriteFile(cli.cfg.aliasFile, data, 0600)
+	}
+	if err != nil {
+		log.Printf("Could not save alias file %s: %v", cli.cfg.aliasFile, err)
+	}
+}
+
+func readAliasFile(path string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	if path == "" {
+		return aliases, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	}
+	if err != nil {
+		return aliases, err
+	}
+	if err := yaml.Unmarshal(data, &aliases); err != nil {
+		return make(map[string]string), err
+	}
+	return aliases, nil
+}
@@ -15,10 +15,13 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -36,6 +39,7 @@ type cliCfg struct {
 	logSetting    string
 	authName      string
 	authPasswd    string
+	aliasFile     string
 }
 type restHandler struct {
 	client *http.Client
@@ -59,6 +63,21 @@ type Cli struct {
 	sh         shHandler
 	rest       restHandler
 	lastCmdErr error
+	// quiet suppresses the human readable output, only the last API
+	// response of a command is printed
+	quiet      bool
+	lastResult []byte
+	// output is the format of the commands, rendered is set once the last
+	// API response of a command was printed in it
+	output   string
+	rendered bool
+	// processed is set once a one-shot command was handed to the shell
+	processed  bool
+	// aliases maps user-defined commands to the command line they expand to
+	aliases    map[string]string
+	aliasDepth int
+	// completion caches the device IDs and parameter paths of tab-completion
+	completion *completionCache
 }
 
 func (cli *Cli) GetLastCmdErr() error {
@@ -94,15 +113,34 @@ func (cli *Cli) Init() error {
 	log.Println("CLI version:", getVer())
 
 	// Initialize shell
+	cli.initShell()
+	cli.sh.shell.SetHistoryPath(cli.sh.histFile)
+
+	// User-defined aliases, after the commands they must not shadow
+	cli.registerAliasCmds()
+	cli.loadAliases()
+
+	return nil
+}
+
+func (cli *Cli) Run() {
+	cli.sh.shell.Println("**************************************************************")
+	cli.sh.shell.Println("                          OpenUsp Cli")
+	cli.sh.shell.Println("**************************************************************")
+	cli.sh.shell.Run()
+}
+
+// initShell creates the shell and registers the built-in commands, it does
+// not need the configuration so that the command tree can be walked without it
+func (cli *Cli) initShell() {
+	if cli.sh.shell != nil {
+		return
+	}
 	cli.sh.shell = ishell.New()
 
 	// Set default Prompt
 	cli.sh.shell.SetPrompt("OpenUsp-Cli>> ")
 	cli.sh.histFile = "history"
-	cli.sh.shell.SetHistoryPath(cli.sh.histFile)
-
-	// Initialize shell Cmds
-	cli.sh.cmds = make(map[string]*ishell.Cmd)
 
 	// Initialize shell Cmds
 	cli.sh.cmds = make(map[string]*ishell.Cmd)
@@ -117,6 +155,7 @@ func (cli *Cli) Init() error {
 
 	// CLI related
 	cli.registerNounsHistory()
+	cli.registerNounsOutput()
 	cli.registerNounsLogging()
 	cli.registerNounsVersion()
 
@@ -135,20 +174,17 @@ func (cli *Cli) Init() error {
 	cli.registerNounsTime()
 	cli.registerNounsNw()
 
+	// TR-069 devices
+	cli.registerNounsCwmp()
+
 	// Basic low level
 	cli.registerNounsDatamodel()
 	cli.registerNounsCommand()
 	cli.registerNounsParam()
 	cli.registerNounsInstance()
 
-	return nil
-}
-
-func (cli *Cli) Run() {
-	cli.sh.shell.Println("**************************************************************")
-	cli.sh.shell.Println("                          OpenUsp Cli")
-	cli.sh.shell.Println("**************************************************************")
-	cli.sh.shell.Run()
+	// Tab-completion of device IDs and parameter paths
+	cli.registerCompleters()
 }
 
 func (cli *Cli) loadConfig() error {
@@ -162,13 +198,17 @@ func (cli *Cli) loadConfig() error {
 	cli.config = cfg
 
 	// Map YAML config to legacy cliCfg struct for backward compatibility
-	cli.cfg.apiServerAddr = fmt.Sprintf("http://%s", cfg.GetHTTPAddress())
+	cli.cfg.apiServerAddr = fmt.Sprintf("http://%s/api/v1", cfg.GetHTTPAddress())
 	cli.cfg.stompAddr = cfg.GetStompAddress()
 	cli.cfg.connTimeout = cfg.Database.Pool.Timeout
 	cli.cfg.histFile = "history" // Default history file
 	cli.cfg.logSetting = cfg.Logging.Level
 	cli.cfg.authName = cfg.Security.Auth.Username
 	cli.cfg.authPasswd = cfg.Security.Auth.Password
+	cli.cfg.aliasFile = cfg.CLI.AliasFile
+	if err := cli.SetOutput(cfg.CLI.Output); err != nil {
+		log.Println("Ignoring output format of the config:", err)
+	}
 	
 	// Agent ID from USP config
 	if cfg.Security.USP.AgentID != "" {
@@ -181,11 +221,36 @@ func (cli *Cli) loadConfig() error {
 	return nil
 }
 
+// ProcessCmd runs a single command in non interactive mode and returns the
+// error of the command, use ExitCode to map it to an exit code
 func (cli *Cli) ProcessCmd(args string) error {
 	log.Println("Running cli command in non interactive mode")
 	log.Println("Processing cmd:", args)
-	tok := strings.Split(args, " ")
-	return cli.sh.shell.Process(tok...)
+	tok := strings.Fields(args)
+	if len(tok) == 0 {
+		return &errUsage{errors.New("no command given")}
+	}
+
+	cli.lastCmdErr = nil
+	cli.lastResult = nil
+	cli.rendered = false
+	if err := cli.sh.shell.Process(tok...); err != nil {
+		return &errUsage{err}
+	}
+	if cli.quiet && !cli.rendered && cli.lastCmdErr == nil && cli.lastResult != nil {
+		os.Stdout.Write(cli.lastResult)
+		if !bytes.HasSuffix(cli.lastResult, []byte("\n")) {
+			os.Stdout.Write([]byte("\n"))
+		}
+	}
+	return cli.lastCmdErr
+}
+
+// SetQuiet turns off the human readable output, commands print only the
+// machine readable (JSON) response of the API server
+func (cli *Cli) SetQuiet(quiet bool) {
+	cli.quiet = quiet
+	cli.restoreOut()
 }
 
 func (cli *Cli) SetOut(writer io.Writer) error {
@@ -0,0 +1,149 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Command returns the command tree of one-shot invocations. The shell
+// commands "verb noun [subnoun]" are exposed noun first, e.g. the shell
+// command "show cwmp device <id>" runs as "openusp-cli cwmp device show <id>".
+// Without arguments the interactive shell is started, and a verb first
+// command line is still run as in the shell, which covers user aliases.
+func (cli *Cli) Command() *cobra.Command {
+	cli.initShell()
+
+	root := &cobra.Command{
+		Use:           "openusp-cli [verb noun ...]",
+		Short:         "OpenUsp command line interface",
+		Long:          "OpenUsp command line interface, runs the interactive shell when no command is given.",
+		Args:          cobra.ArbitraryArgs,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cli.initOneShot(cmd); err != nil {
+				return err
+			}
+			if len(args) == 0 {
+				cli.Run()
+				return nil
+			}
+			return cli.processOneShot(args)
+		},
+	}
+	root.PersistentFlags().BoolP("quiet", "q", false, "print only machine readable output of the command")
+	root.PersistentFlags().StringP("output", "o", "", "output format of the command: table, json or csv")
+	// Flags after the verb belong to the shell command line
+	root.Flags().SetInterspersed(false)
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &errUsage{err}
+	})
+
+	paths := make([]string, 0, len(cli.sh.cmds))
+	for path := range cli.sh.cmds {
+		if strings.Contains(path, ".") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	groups := map[string]*cobra.Command{"": root}
+	for _, path := range paths {
+		tok := strings.Split(path, ".")
+		nouns, verb := tok[1:], tok[0]
+
+		parent := root
+		for i := range nouns {
+			parent = cli.cobraGroup(groups, parent, strings.Join(nouns[:i+1], " "), nouns[i])
+		}
+		leaf := cli.cobraGroup(groups, parent, strings.Join(nouns, " ")+" "+verb, verb)
+		leaf.Short = firstLine(cli.sh.cmds[path].Help)
+		leaf.Args = cobra.ArbitraryArgs
+		shellCmd := strings.Join(tok, " ")
+		leaf.RunE = func(cmd *cobra.Command, args []string) error {
+			if err := cli.initOneShot(cmd); err != nil {
+				return err
+			}
+			return cli.processOneShot(append([]string{shellCmd}, args...))
+		}
+	}
+	return root
+}
+
+// Execute runs the command line of the process and returns its exit code
+func (cli *Cli) Execute() int {
+	err := cli.Command().Execute()
+	// The shell commands print their own errors unless quiet
+	if err != nil && (cli.quiet || !cli.processed) {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	return ExitCode(err)
+}
+
+// cobraGroup returns the command at key, creating it under parent. A group
+// run without one of its subcommands is a usage error.
+func (cli *Cli) cobraGroup(groups map[string]*cobra.Command, parent *cobra.Command, key string, name string) *cobra.Command {
+	if cmd, ok := groups[key]; ok {
+		return cmd
+	}
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: name + " commands",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				cmd.Help()
+				return &errUsage{fmt.Errorf("%s requires a subcommand", cmd.CommandPath())}
+			}
+			return &errUsage{fmt.Errorf("unknown command %q for %q", args[0], cmd.CommandPath())}
+		},
+	}
+	parent.AddCommand(cmd)
+	groups[key] = cmd
+	return cmd
+}
+
+// initOneShot loads the configuration before the first command is run
+func (cli *Cli) initOneShot(cmd *cobra.Command) error {
+	if err := cli.Init(); err != nil {
+		return &errInit{err}
+	}
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	cli.SetQuiet(quiet)
+	if cmd.Flags().Changed("output") {
+		output, _ := cmd.Flags().GetString("output")
+		if err := cli.SetOutput(output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cli *Cli) processOneShot(args []string) error {
+	cli.processed = true
+	return cli.ProcessCmd(strings.Join(args, " "))
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/abiosoft/ishell"
+)
+
+// completionTTL is how long the device IDs and parameter paths fetched for
+// tab-completion are reused before they are fetched again
+const completionTTL = time.Minute
+
+// completionLimit caps the number of device IDs fetched for completion
+const completionLimit = 1000
+
+// Kinds of the arguments following the device ID of a command
+const (
+	completeNone = iota
+	completeParams
+	completeSetParams
+	completeObjects
+)
+
+// deviceCompletion lists the commands taking a CWMP device ID as their
+// first argument and how the arguments after it are completed. repeat
+// completes every following argument instead of only the second one.
+var deviceCompletion = []struct {
+	path   string
+	next   int
+	repeat bool
+}{
+	{"show.cwmp.device", completeNone, false},
+	{"show.cwmp.software", completeNone, false},
+	{"show.cwmp.changes", completeObjects, false},
+	{"show.cwmp.datamodel", completeObjects, false},
+	{"get.cwmp", completeParams, true},
+	{"get.cwmp.params", completeParams, true},
+	{"get.cwmp.transfers", completeNone, false},
+	{"set.cwmp", completeSetParams, true},
+	{"set.cwmp.params", completeSetParams, true},
+	{"reboot.cwmp", completeNone, false},
+	{"reboot.cwmp.device", completeNone, false},
+	{"factory-reset.cwmp", completeNone, false},
+	{"factory-reset.cwmp.device", completeNone, false},
+	{"download.cwmp", completeNone, false},
+	{"download.cwmp.file", completeNone, false},
+	{"upload.cwmp", completeNone, false},
+	{"upload.cwmp.file", completeNone, false},
+	{"connection-request.cwmp", completeNone, false},
+	{"add.cwmp", completeObjects, false},
+	{"add.cwmp.object", completeObjects, false},
+	{"remove.cwmp", completeObjects, false},
+	{"remove.cwmp.object", completeObjects, false},
+}
+
+// completionCache holds what was fetched from the API server for
+// tab-completion
+type completionCache struct {
+	mu        sync.Mutex
+	devices   []string
+	devicesAt time.Time
+	params    map[string]*paramCompletion
+}
+
+// paramCompletion holds the parameter paths of a device, objects are the
+// partial paths ending with a dot
+type paramCompletion struct {
+	fetchedAt time.Time
+	params    []string
+	objects   []string
+}
+
+// registerCompleters adds the device ID and parameter path completion to
+// the CWMP commands
+func (cli *Cli) registerCompleters() {
+	cli.completion = &completionCache{params: make(map[string]*paramCompletion)}
+	for _, dc := range deviceCompletion {
+		cmd, ok := cli.sh.cmds[dc.path]
+		if !ok {
+			continue
+		}
+		cmd.Completer = cli.deviceCompleter(cmd, dc.next, dc.repeat)
+	}
+}
+
+// deviceCompleter completes the device ID as first argument of cmd and the
+// parameter paths of that device after it. The subcommands of cmd remain
+// completed in the place of the device ID.
+func (cli *Cli) deviceCompleter(cmd *ishell.Cmd, next int, repeat bool) func([]string) []string {
+	return func(args []string) []string {
+		if len(args) == 0 {
+			var words []string
+			for _, child := range cmd.Children() {
+				words = append(words, child.Name)
+			}
+			return append(words, cli.completeDeviceIds()...)
+		}
+		if next == completeNone || (len(args) > 1 && !repeat) {
+			return nil
+		}
+		params := cli.completeParamPaths(args[0])
+		if params == nil {
+			return nil
+		}
+		switch next {
+		case completeObjects:
+			return params.objects
+		case completeSetParams:
+			words := make([]string, 0, len(params.objects)+len(params.params))
+			words = append(words, params.objects...)
+			for _, p := range params.params {
+				words = append(words, p+"=")
+			}
+			return words
+		}
+		return append(append([]string{}, params.objects...), params.params...)
+	}
+}
+
+// completeDeviceIds returns the cached CWMP device IDs, fetching them from
+// the API server once the cache expired
+func (cli *Cli) completeDeviceIds() []string {
+	cli.completion.mu.Lock()
+	defer cli.completion.mu.Unlock()
+
+	if time.Since(cli.completion.devicesAt) < completionTTL {
+		return cli.completion.devices
+	}
+	// A failed fetch is cached as well so an unreachable API server does
+	// not stall every tab press
+	cli.completion.devices = nil
+	cli.completion.devicesAt = time.Now()
+
+	query := url.Values{}
+	query.Set("fields", "device_id")
+	query.Set("limit", strconv.Itoa(completionLimit))
+	data, err := cli.completionGet(cli.cfg.apiServerAddr + "/cwmp/devices/?" + query.Encode())
+	if err != nil {
+		return nil
+	}
+	var page struct {
+		Devices []struct {
+			DeviceID string `json:"device_id"`
+		} `json:"devices"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return nil
+	}
+	for _, d := range page.Devices {
+		if d.DeviceID != "" {
+			cli.completion.devices = append(cli.completion.devices, d.DeviceID)
+		}
+	}
+	sort.Strings(cli.completion.devices)
+	return cli.completion.devices
+}
+
+// completeParamPaths returns the cached parameter paths of a device,
+// fetching them from the API server once the cache expired
+func (cli *Cli) completeParamPaths(deviceId string) *paramCompletion {
+	cli.completion.mu.Lock()
+	defer cli.completion.mu.Unlock()
+
+	if params, ok := cli.completion.params[deviceId]; ok && time.Since(params.fetchedAt) < completionTTL {
+		return params
+	}
+	params := &paramCompletion{fetchedAt: time.Now()}
+	cli.completion.params[deviceId] = params
+
+	data, err := cli.completionGet(cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(deviceId) + "/params")
+	if err != nil {
+		return params
+	}
+	var response struct {
+		Parameters []struct {
+			Name string
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return params
+	}
+
+	objects := make(map[string]bool)
+	for _, p := range response.Parameters {
+		params.params = append(params.params, p.Name)
+		// Every partial path is completed as well so the completion
+		// advances one object at a time
+		for i := 0; i < len(p.Name)-1; i++ {
+			if p.Name[i] == '.' {
+				objects[p.Name[:i+1]] = true
+			}
+		}
+	}
+	for object := range objects {
+		params.objects = append(params.objects, object)
+	}
+	sort.Strings(params.params)
+	sort.Strings(params.objects)
+	return params
+}
+
+// completionGet fetches addr for the completion without replacing the last
+// response of the commands
+func (cli *Cli) completionGet(addr string) ([]byte, error) {
+	lastResult := cli.lastResult
+	defer func() { cli.lastResult = lastResult }()
+	return cli.restGet(addr)
+}
@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/abiosoft/ishell"
@@ -32,9 +34,21 @@ const (
 	setCwmpParamsHelp      = "set cwmp params <device_id> <param=value> [param2=value2] ... - Set parameter values on CWMP device"
 	rebootCwmpDeviceHelp   = "reboot cwmp device <device_id> [command_key] - Reboot CWMP device"
 	factoryResetCwmpDeviceHelp = "factory-reset cwmp device <device_id> - Factory reset CWMP device"
-	downloadCwmpFileHelp   = "download cwmp file <device_id> <url> <file_type> [target_filename] - Download file to CWMP device"
+	downloadCwmpFileHelp   = "download cwmp file <device_id> <url> <file_type> [target_filename] [version=<firmware_version>] [force] - Download file to CWMP device"
 	uploadCwmpFileHelp     = "upload cwmp file <device_id> <url> <file_type> - Upload file from CWMP device"
 	connectionRequestHelp  = "connection-request cwmp <device_id> - Send connection request to CWMP device"
+	showCwmpSessionsHelp   = "show cwmp sessions [device_id] - List active and recent CWMP sessions"
+	showCwmpTransfersHelp  = "show cwmp transfers [device_id] [status] - List file downloads/uploads"
+	getCwmpTransfersHelp   = "get cwmp transfers <device_id> [all] - Query the transfer queue of a CWMP device and detect stuck or orphaned transfers"
+	showCwmpSoftwareHelp   = "show cwmp software <device_id> - List the software modules (deployment units) of a CWMP device"
+	showCwmpChangesHelp    = "show cwmp changes <device_id> [path] - Show the parameter changes reported by a CWMP device"
+	showCwmpCommandsHelp   = "show cwmp commands [device_id] [queued|delivered|answered|expired] - List commands held for devices"
+	importCwmpPreRegHelp   = "import cwmp preregistrations <csv_file> - Pre-register expected devices (oui,serial_number,product_class,profile,...)"
+	showCwmpPreRegHelp     = "show cwmp preregistrations [expected|registered] - List pre-registered devices"
+	showCwmpPendingHelp    = "show cwmp pending - List devices which informed without being pre-registered"
+	showCwmpDataModelHelp  = "show cwmp datamodel <device_id> [path] - Browse the data model supported by a CWMP device"
+	addCwmpObjectHelp      = "add cwmp object <device_id> <object> [parameter_key] - Create an instance of a multi-instance object, e.g. Device.WiFi.SSID."
+	removeCwmpObjectHelp   = "remove cwmp object <device_id> <instance> [parameter_key] - Delete an object instance, e.g. Device.WiFi.SSID.2."
 )
 
 // registerNounsCwmp registers CWMP-related CLI commands
@@ -43,8 +57,19 @@ func (cli *Cli) registerNounsCwmp() {
 		{"show", "cwmp", showCwmpDevicesHelp, cli.showCwmpDevices},
 		{"show.cwmp", "devices", showCwmpDevicesHelp, cli.showCwmpDevices},
 		{"show.cwmp", "device", showCwmpDeviceHelp, cli.showCwmpDevice},
+		{"show.cwmp", "sessions", showCwmpSessionsHelp, cli.showCwmpSessions},
+		{"show.cwmp", "transfers", showCwmpTransfersHelp, cli.showCwmpTransfers},
+		{"show.cwmp", "commands", showCwmpCommandsHelp, cli.showCwmpCommands},
+		{"show.cwmp", "software", showCwmpSoftwareHelp, cli.showCwmpSoftware},
+		{"show.cwmp", "changes", showCwmpChangesHelp, cli.showCwmpChanges},
+		{"show.cwmp", "preregistrations", showCwmpPreRegHelp, cli.showCwmpPreRegistrations},
+		{"show.cwmp", "pending", showCwmpPendingHelp, cli.showCwmpPendingDevices},
+		{"show.cwmp", "datamodel", showCwmpDataModelHelp, cli.showCwmpDataModel},
+		{"import", "cwmp", importCwmpPreRegHelp, cli.importCwmpPreRegistrations},
+		{"import.cwmp", "preregistrations", importCwmpPreRegHelp, cli.importCwmpPreRegistrations},
 		{"get", "cwmp", getCwmpParamsHelp, cli.getCwmpParams},
 		{"get.cwmp", "params", getCwmpParamsHelp, cli.getCwmpParams},
+		{"get.cwmp", "transfers", getCwmpTransfersHelp, cli.getCwmpQueuedTransfers},
 		{"set", "cwmp", setCwmpParamsHelp, cli.setCwmpParams},
 		{"set.cwmp", "params", setCwmpParamsHelp, cli.setCwmpParams},
 		{"reboot", "cwmp", rebootCwmpDeviceHelp, cli.rebootCwmpDevice},
@@ -56,6 +81,10 @@ func (cli *Cli) registerNounsCwmp() {
 		{"upload", "cwmp", uploadCwmpFileHelp, cli.uploadCwmpFile},
 		{"upload.cwmp", "file", uploadCwmpFileHelp, cli.uploadCwmpFile},
 		{"connection-request", "cwmp", connectionRequestHelp, cli.connectionRequestCwmp},
+		{"add", "cwmp", addCwmpObjectHelp, cli.addCwmpObject},
+		{"add.cwmp", "object", addCwmpObjectHelp, cli.addCwmpObject},
+		{"remove", "cwmp", removeCwmpObjectHelp, cli.removeCwmpObject},
+		{"remove.cwmp", "object", removeCwmpObjectHelp, cli.removeCwmpObject},
 	}
 	cli.registerNouns(cwmpCmds)
 }
@@ -89,12 +118,16 @@ func (cli *Cli) showCwmpDevices(c *ishell.Context) {
 		return
 	}
 
-	var devices []map[string]interface{}
-	if err := json.Unmarshal(data, &devices); err != nil {
+	var page struct {
+		Devices []map[string]interface{} `json:"devices"`
+		Total   int64                    `json:"total"`
+	}
+	if err := json.Unmarshal(data, &page); err != nil {
 		c.Printf("Error parsing response: %v\n", err)
 		cli.lastCmdErr = err
 		return
 	}
+	devices := page.Devices
 
 	if len(devices) == 0 {
 		c.Println("No CWMP devices found")
@@ -103,7 +136,11 @@ func (cli *Cli) showCwmpDevices(c *ishell.Context) {
 	}
 
 	// Display device information
-	c.Printf("Found %d CWMP device(s):\n", len(devices))
+	if page.Total > int64(len(devices)) {
+		c.Printf("Found %d CWMP device(s), showing the first %d:\n", page.Total, len(devices))
+	} else {
+		c.Printf("Found %d CWMP device(s):\n", len(devices))
+	}
 	c.Println("==========================================")
 	
 	for i, device := range devices {
@@ -122,6 +159,436 @@ func (cli *Cli) showCwmpDevices(c *ishell.Context) {
 	cli.lastCmdErr = nil
 }
 
+// showCwmpSessions displays the active and recent CWMP sessions
+func (cli *Cli) showCwmpSessions(c *ishell.Context) {
+	url := cli.cfg.apiServerAddr + "/cwmp/sessions/"
+	if len(c.Args) > 0 {
+		url += "?device_id=" + c.Args[0]
+	}
+	data, err := cli.restGet(url)
+	if err != nil {
+		c.Printf("Error getting CWMP sessions: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var sessions []map[string]interface{}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(sessions) == 0 {
+		c.Println("No CWMP sessions found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d CWMP session(s):\n", len(sessions))
+	c.Println("==========================================")
+
+	for _, session := range sessions {
+		c.Printf("Session ID       : %v\n", session["session_id"])
+		c.Printf("  Device ID      : %v\n", session["device_id"])
+		c.Printf("  State          : %v\n", session["state"])
+		c.Printf("  Current RPC    : %v\n", session["current_rpc"])
+		c.Printf("  Pending RPCs   : %v\n", session["pending_rpc_count"])
+		c.Printf("  Last Activity  : %v\n", session["last_activity"])
+		c.Println("------------------------------------------")
+	}
+
+	cli.lastCmdErr = nil
+}
+
+// showCwmpTransfers displays file transfers, optionally for one device and status
+func (cli *Cli) showCwmpTransfers(c *ishell.Context) {
+	query := url.Values{}
+	if len(c.Args) > 0 && c.Args[0] != "all" {
+		query.Set("device_id", c.Args[0])
+	}
+	if len(c.Args) > 1 {
+		query.Set("status", c.Args[1])
+	}
+	reqUrl := cli.cfg.apiServerAddr + "/cwmp/transfers/"
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+	data, err := cli.restGet(reqUrl)
+	if err != nil {
+		c.Printf("Error getting CWMP file transfers: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var transfers []map[string]interface{}
+	if err := json.Unmarshal(data, &transfers); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(transfers) == 0 {
+		c.Println("No CWMP file transfers found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d CWMP file transfer(s):\n", len(transfers))
+	c.Println("==========================================")
+
+	for _, t := range transfers {
+		c.Printf("Command Key      : %v\n", t["command_key"])
+		c.Printf("  Device ID      : %v\n", t["device_id"])
+		c.Printf("  File Type      : %v\n", t["file_type"])
+		c.Printf("  Status         : %v\n", t["status"])
+		c.Printf("  Created        : %v\n", t["created_at"])
+		if start, ok := t["start_time"]; ok {
+			c.Printf("  Started        : %v\n", start)
+		}
+		if complete, ok := t["complete_time"]; ok {
+			c.Printf("  Completed      : %v\n", complete)
+		}
+		if fault, ok := t["fault_code"]; ok {
+			c.Printf("  Fault          : %v %v\n", fault, t["fault_string"])
+		}
+		c.Println("------------------------------------------")
+	}
+
+	cli.lastCmdErr = nil
+}
+
+// showCwmpCommands displays the commands held by the ACS for devices,
+// optionally for one device and status
+func (cli *Cli) showCwmpCommands(c *ishell.Context) {
+	query := url.Values{}
+	if len(c.Args) > 0 && c.Args[0] != "all" {
+		query.Set("device_id", c.Args[0])
+	}
+	if len(c.Args) > 1 {
+		query.Set("status", c.Args[1])
+	}
+	reqUrl := cli.cfg.apiServerAddr + "/cwmp/commands/"
+	if len(query) > 0 {
+		reqUrl += "?" + query.Encode()
+	}
+	data, err := cli.restGet(reqUrl)
+	if err != nil {
+		c.Printf("Error getting CWMP commands: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var cmds []map[string]interface{}
+	if err := json.Unmarshal(data, &cmds); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(cmds) == 0 {
+		c.Println("No CWMP commands found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d CWMP command(s):\n", len(cmds))
+	c.Println("==========================================")
+
+	for _, cmd := range cmds {
+		c.Printf("Command ID       : %v\n", cmd["id"])
+		c.Printf("  Device ID      : %v\n", cmd["device_id"])
+		c.Printf("  Method         : %v\n", cmd["method"])
+		c.Printf("  Status         : %v\n", cmd["status"])
+		c.Printf("  Created        : %v\n", cmd["created_at"])
+		c.Printf("  Expires        : %v\n", cmd["expires_at"])
+		if delivered, ok := cmd["delivered_at"]; ok {
+			c.Printf("  Delivered      : %v\n", delivered)
+		}
+		if answered, ok := cmd["answered_at"]; ok {
+			c.Printf("  Answered       : %v\n", answered)
+		}
+		if fault, ok := cmd["fault_code"]; ok {
+			c.Printf("  Fault          : %v %v\n", fault, cmd["fault_string"])
+		}
+		c.Println("------------------------------------------")
+	}
+
+	cli.lastCmdErr = nil
+}
+
+// showCwmpSoftware displays the deployment units of a device
+func (cli *Cli) showCwmpSoftware(c *ishell.Context) {
+	if len(c.Args) < 1 {
+		c.Println("Error: Device ID required")
+		c.Println(showCwmpSoftwareHelp)
+		cli.lastCmdErr = errors.New("device ID required")
+		return
+	}
+
+	data, err := cli.restGet(cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(c.Args[0]) + "/software-modules")
+	if err != nil {
+		c.Printf("Error getting CWMP software modules: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var dus []map[string]interface{}
+	if err := json.Unmarshal(data, &dus); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(dus) == 0 {
+		c.Println("No software modules found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d software module(s):\n", len(dus))
+	c.Println("==========================================")
+
+	for _, du := range dus {
+		c.Printf("UUID             : %v\n", du["uuid"])
+		c.Printf("  Version        : %v\n", du["version"])
+		c.Printf("  State          : %v\n", du["state"])
+		c.Printf("  Last Operation : %v (%v)\n", du["operation"], du["operation_status"])
+		if duURL, ok := du["url"]; ok {
+			c.Printf("  URL            : %v\n", duURL)
+		}
+		if fault, ok := du["fault_code"]; ok {
+			c.Printf("  Fault          : %v %v\n", fault, du["fault_string"])
+		}
+		c.Println("------------------------------------------")
+	}
+
+	cli.lastCmdErr = nil
+}
+
+// showCwmpChanges lists the parameter history of a device, newest first
+func (cli *Cli) showCwmpChanges(c *ishell.Context) {
+	if len(c.Args) < 1 {
+		c.Println("Error: Device ID required")
+		c.Println(showCwmpChangesHelp)
+		cli.lastCmdErr = &errUsage{errors.New("device ID required")}
+		return
+	}
+
+	query := url.Values{}
+	if len(c.Args) > 1 {
+		query.Set("path", c.Args[1])
+	}
+	changesURL := cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(c.Args[0]) + "/param-changes"
+	if len(query) > 0 {
+		changesURL += "?" + query.Encode()
+	}
+	data, err := cli.restGet(changesURL)
+	if err != nil {
+		c.Printf("Error getting CWMP parameter changes: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var changes []map[string]interface{}
+	if err := json.Unmarshal(data, &changes); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(changes) == 0 {
+		c.Println("No parameter changes found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d parameter change(s):\n", len(changes))
+	c.Println("==========================================")
+
+	for _, change := range changes {
+		c.Printf("%v  %v\n", change["changed_at"], change["path"])
+		if isNew, _ := change["new"].(bool); isNew {
+			c.Printf("  (new) -> %v\n", change["new_value"])
+		} else {
+			c.Printf("  %v -> %v\n", change["old_value"], change["new_value"])
+		}
+	}
+
+	cli.lastCmdErr = nil
+}
+
+// importCwmpPreRegistrations uploads a CSV file of expected devices
+func (cli *Cli) importCwmpPreRegistrations(c *ishell.Context) {
+	if len(c.Args) < 1 {
+		c.Println("Error: CSV file required")
+		c.Println(importCwmpPreRegHelp)
+		cli.lastCmdErr = &errUsage{errors.New("CSV file required")}
+		return
+	}
+
+	csvData, err := os.ReadFile(c.Args[0])
+	if err != nil {
+		c.Printf("Error reading %s: %v\n", c.Args[0], err)
+		cli.lastCmdErr = &errUsage{err}
+		return
+	}
+
+	data, err := cli.restPostContent(cli.cfg.apiServerAddr+"/cwmp/preregistrations/import", "text/csv", csvData)
+	if err != nil {
+		c.Printf("Error importing pre-registrations: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var result struct {
+		Imported int      `json:"imported"`
+		Rejected []string `json:"rejected"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	c.Printf("Imported %d device(s)\n", result.Imported)
+	for _, rejected := range result.Rejected {
+		c.Printf("  Rejected %s\n", rejected)
+	}
+	cli.lastCmdErr = nil
+}
+
+// showCwmpPreRegistrations displays the pre-registered devices
+func (cli *Cli) showCwmpPreRegistrations(c *ishell.Context) {
+	reqUrl := cli.cfg.apiServerAddr + "/cwmp/preregistrations/"
+	if len(c.Args) > 0 {
+		reqUrl += "?status=" + url.QueryEscape(c.Args[0])
+	}
+	data, err := cli.restGet(reqUrl)
+	if err != nil {
+		c.Printf("Error getting pre-registrations: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var regs []map[string]interface{}
+	if err := json.Unmarshal(data, &regs); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(regs) == 0 {
+		c.Println("No pre-registered devices found")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d pre-registered device(s):\n", len(regs))
+	c.Println("==========================================")
+	for _, reg := range regs {
+		c.Printf("OUI/Serial       : %v / %v\n", reg["oui"], reg["serial_number"])
+		c.Printf("  Status         : %v\n", reg["status"])
+		c.Printf("  Profile        : %v\n", reg["profile"])
+		if deviceId, ok := reg["device_id"]; ok {
+			c.Printf("  Device ID      : %v\n", deviceId)
+		}
+		if subscriber, ok := reg["subscriber"].(map[string]interface{}); ok {
+			for k, v := range subscriber {
+				c.Printf("  %-15s: %v\n", k, v)
+			}
+		}
+		c.Println("------------------------------------------")
+	}
+	cli.lastCmdErr = nil
+}
+
+// showCwmpPendingDevices displays the devices waiting in the pending queue
+func (cli *Cli) showCwmpPendingDevices(c *ishell.Context) {
+	data, err := cli.restGet(cli.cfg.apiServerAddr + "/cwmp/pending/")
+	if err != nil {
+		c.Printf("Error getting pending devices: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var pending []map[string]interface{}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(pending) == 0 {
+		c.Println("No pending devices")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Found %d pending device(s):\n", len(pending))
+	c.Println("==========================================")
+	for _, p := range pending {
+		c.Printf("Device ID        : %v\n", p["device_id"])
+		c.Printf("  IP Address     : %v\n", p["ip_address"])
+		c.Printf("  Informs        : %v\n", p["inform_count"])
+		c.Printf("  First Seen     : %v\n", p["first_seen"])
+		c.Printf("  Last Seen      : %v\n", p["last_seen"])
+		c.Println("------------------------------------------")
+	}
+	cli.lastCmdErr = nil
+}
+
+// showCwmpDataModel displays the direct children of a data model object of
+// a device, the root object by default
+func (cli *Cli) showCwmpDataModel(c *ishell.Context) {
+	if len(c.Args) < 1 {
+		c.Println("Error: Device ID required")
+		c.Println(showCwmpDataModelHelp)
+		cli.lastCmdErr = errors.New("device ID required")
+		return
+	}
+
+	path := ""
+	if len(c.Args) > 1 {
+		path = c.Args[1]
+		if !strings.HasSuffix(path, ".") {
+			path += "."
+		}
+	}
+	query := url.Values{"next_level": {"true"}}
+	if path != "" {
+		query.Set("path", path)
+	}
+	data, err := cli.restGet(cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(c.Args[0]) + "/datamodel?" + query.Encode())
+	if err != nil {
+		c.Printf("Error getting data model: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var nodes []map[string]interface{}
+	if err := json.Unmarshal(data, &nodes); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	if len(nodes) == 0 {
+		c.Println("No data model nodes discovered")
+		cli.lastCmdErr = nil
+		return
+	}
+
+	for _, node := range nodes {
+		access := "R "
+		if writable, _ := node["writable"].(bool); writable {
+			access = "RW"
+		}
+		c.Printf("%s  %v\n", access, node["path"])
+	}
+	cli.lastCmdErr = nil
+}
+
 // showCwmpDevice displays specific CWMP device information
 func (cli *Cli) showCwmpDevice(c *ishell.Context) {
 	if len(c.Args) < 1 {
@@ -292,6 +759,137 @@ func (cli *Cli) setCwmpParams(c *ishell.Context) {
 	cli.lastCmdErr = nil
 }
 
+// addCwmpObject creates an object instance on a CWMP device and displays the
+// instance number it was assigned
+func (cli *Cli) addCwmpObject(c *ishell.Context) {
+	if len(c.Args) < 2 {
+		c.Println("Error: Device ID and object required")
+		c.Println(addCwmpObjectHelp)
+		cli.lastCmdErr = errors.New("device ID and object required")
+		return
+	}
+
+	requestBody := map[string]string{"object_name": c.Args[1]}
+	if len(c.Args) > 2 {
+		requestBody["parameter_key"] = c.Args[2]
+	}
+	jsonData, err := json.Marshal(requestBody)
+	if err != nil {
+		c.Printf("Error creating request: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	data, err := cli.restPost(cli.cfg.apiServerAddr+"/cwmp/device/"+url.PathEscape(c.Args[0])+"/object", jsonData)
+	if err != nil {
+		c.Printf("Error adding CWMP object: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+	cli.lastCmdErr = printCwmpObjectResult(c, data)
+}
+
+// removeCwmpObject deletes an object instance of a CWMP device
+func (cli *Cli) removeCwmpObject(c *ishell.Context) {
+	if len(c.Args) < 2 {
+		c.Println("Error: Device ID and object instance required")
+		c.Println(removeCwmpObjectHelp)
+		cli.lastCmdErr = errors.New("device ID and object instance required")
+		return
+	}
+
+	query := url.Values{"object_name": {c.Args[1]}}
+	if len(c.Args) > 2 {
+		query.Set("parameter_key", c.Args[2])
+	}
+	data, err := cli.restDelete(cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(c.Args[0]) + "/object?" + query.Encode())
+	if err != nil {
+		c.Printf("Error removing CWMP object: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+	cli.lastCmdErr = printCwmpObjectResult(c, data)
+}
+
+func printCwmpObjectResult(c *ishell.Context, data []byte) error {
+	var result struct {
+		ObjectName string `json:"object_name"`
+		Instance   string `json:"instance"`
+		Status     string `json:"status"`
+		RequestID  string `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		return err
+	}
+	switch {
+	case result.Status == "queued":
+		c.Printf("%s queued, the device has not answered yet (request %s)\n", result.ObjectName, result.RequestID)
+	case result.Instance != "":
+		c.Printf("Created %s\n", result.Instance)
+	default:
+		c.Printf("Deleted %s\n", result.ObjectName)
+	}
+	return nil
+}
+
+// getCwmpQueuedTransfers queries the transfer queue of a device
+func (cli *Cli) getCwmpQueuedTransfers(c *ishell.Context) {
+	if len(c.Args) < 1 {
+		c.Println("Error: Device ID required")
+		c.Println(getCwmpTransfersHelp)
+		cli.lastCmdErr = errors.New("device ID required")
+		return
+	}
+
+	reqUrl := cli.cfg.apiServerAddr + "/cwmp/device/" + url.PathEscape(c.Args[0]) + "/queued-transfers"
+	if len(c.Args) > 1 && c.Args[1] == "all" {
+		reqUrl += "?all=true"
+	}
+	data, err := cli.restPost(reqUrl, nil)
+	if err != nil {
+		c.Printf("Error getting CWMP transfer queue: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+
+	var result struct {
+		Status    string `json:"status"`
+		RequestID string `json:"request_id"`
+		Transfers []struct {
+			CommandKey string `json:"command_key"`
+			State      string `json:"state"`
+			FileType   string `json:"file_type"`
+			TransferID string `json:"transfer_id"`
+		} `json:"transfers"`
+		Stuck    []string `json:"stuck"`
+		Orphaned []string `json:"orphaned"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		c.Printf("Error parsing response: %v\n", err)
+		cli.lastCmdErr = err
+		return
+	}
+	if result.Status == "queued" {
+		c.Printf("Transfer queue requested, the device has not answered yet (request %s)\n", result.RequestID)
+		cli.lastCmdErr = nil
+		return
+	}
+
+	c.Printf("Device lists %d transfer(s):\n", len(result.Transfers))
+	for _, t := range result.Transfers {
+		transferID := t.TransferID
+		if transferID == "" {
+			transferID = "orphaned"
+		}
+		c.Printf("  %-24q %-12s %-28s %s\n", t.CommandKey, t.State, t.FileType, transferID)
+	}
+	for _, id := range result.Stuck {
+		c.Printf("Stuck transfer: %s\n", id)
+	}
+	cli.lastCmdErr = nil
+}
+
 // rebootCwmpDevice reboots a CWMP device
 func (cli *Cli) rebootCwmpDevice(c *ishell.Context) {
 	if len(c.Args) < 1 {
@@ -384,8 +982,17 @@ func (cli *Cli) downloadCwmpFile(c *ishell.Context) {
 	url := c.Args[1]
 	fileType := c.Args[2]
 	targetFilename := ""
-	if len(c.Args) > 3 {
-		targetFilename = c.Args[3]
+	firmwareVersion := ""
+	force := false
+	for _, arg := range c.Args[3:] {
+		switch {
+		case strings.HasPrefix(arg, "version="):
+			firmwareVersion = strings.TrimPrefix(arg, "version=")
+		case arg == "force":
+			force = true
+		default:
+			targetFilename = arg
+		}
 	}
 
 	requestBody := map[string]interface{}{
@@ -394,6 +1001,8 @@ func (cli *Cli) downloadCwmpFile(c *ishell.Context) {
 		"url":            url,
 		"target_filename": targetFilename,
 		"delay_seconds":   0,
+		"firmware_version": firmwareVersion,
+		"force":           force,
 	}
 
 	jsonData, err := json.Marshal(requestBody)
@@ -418,8 +1027,8 @@ func (cli *Cli) downloadCwmpFile(c *ishell.Context) {
 		return
 	}
 
-	c.Printf("Download command result: %v\n", response["status"])
-	c.Printf("Message: %v\n", response["message"])
+	c.Printf("Download %v sent, transfer %v is %v\n", response["command_key"], response["id"], response["status"])
+	c.Println("Follow it with: show cwmp transfers <device_id>")
 	
 	cli.lastCmdErr = nil
 }
@@ -466,8 +1075,8 @@ func (cli *Cli) uploadCwmpFile(c *ishell.Context) {
 		return
 	}
 
-	c.Printf("Upload command result: %v\n", response["status"])
-	c.Printf("Message: %v\n", response["message"])
+	c.Printf("Upload %v sent, transfer %v is %v\n", response["command_key"], response["id"], response["status"])
+	c.Println("Follow it with: show cwmp transfers <device_id>")
 	
 	cli.lastCmdErr = nil
 }
@@ -499,7 +1108,7 @@ func (cli *Cli) connectionRequestCwmp(c *ishell.Context) {
 	}
 
 	c.Printf("Connection request result: %v\n", response["status"])
-	c.Printf("Message: %v\n", response["message"])
+	c.Printf("Attempts: %v, HTTP status: %v, duration: %vms\n", response["attempts"], response["http_status"], response["duration_ms"])
 	
 	cli.lastCmdErr = nil
 }
\ No newline at end of file
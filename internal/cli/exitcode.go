@@ -0,0 +1,101 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// Exit codes of the CLI in non-interactive mode
+const (
+	ExitOK        = 0 // command succeeded
+	ExitCmdFault  = 1 // command was executed but failed
+	ExitUsage     = 2 // unknown command or invalid arguments
+	ExitConnError = 3 // API server, DB or controller not reachable
+	ExitNotFound  = 4 // device, agent or object not found
+)
+
+// problemControllerFailure is the problem code of the API server for a
+// command the controller or device executed and failed
+const problemControllerFailure = "CONTROLLER_FAILURE"
+
+// errUsage wraps errors caused by a wrong command line
+type errUsage struct {
+	err error
+}
+
+func (e *errUsage) Error() string {
+	return e.err.Error()
+}
+
+func (e *errUsage) Unwrap() error {
+	return e.err
+}
+
+// errInit wraps errors of the CLI initialization, e.g. a missing configuration
+type errInit struct {
+	err error
+}
+
+func (e *errInit) Error() string {
+	return e.err.Error()
+}
+
+func (e *errInit) Unwrap() error {
+	return e.err
+}
+
+// ExitCode maps the result of ProcessCmd to the process exit code
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var usageErr *errUsage
+	if errors.As(err, &usageErr) {
+		return ExitUsage
+	}
+
+	var initErr *errInit
+	if errors.As(err, &initErr) {
+		return ExitConnError
+	}
+
+	var rErr *restError
+	if errors.As(err, &rErr) {
+		if rErr.Code == problemControllerFailure {
+			return ExitCmdFault
+		}
+		switch rErr.Status {
+		case http.StatusNotFound:
+			return ExitNotFound
+		case http.StatusBadRequest:
+			return ExitUsage
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return ExitConnError
+		}
+		return ExitCmdFault
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return ExitConnError
+	}
+	return ExitCmdFault
+}
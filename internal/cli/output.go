@@ -0,0 +1,255 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+)
+
+// Output formats of the commands. table is the human readable output of
+// the commands, json and csv render the response of the API server.
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputCSV   = "csv"
+)
+
+func validOutput(format string) bool {
+	return format == outputTable || format == outputJSON || format == outputCSV
+}
+
+// SetOutput sets the output format of the commands
+func (cli *Cli) SetOutput(format string) error {
+	if format == "" {
+		format = outputTable
+	}
+	if !validOutput(format) {
+		return &errUsage{fmt.Errorf("invalid output format %q, use table, json or csv", format)}
+	}
+	cli.output = format
+	return nil
+}
+
+// withOutput runs a command with the output format. In json and csv the
+// text of the command is only printed when it failed or did not call the
+// API server, otherwise its last API response is rendered instead.
+func (cli *Cli) withOutput(cb func(*ishell.Context)) func(*ishell.Context) {
+	return func(c *ishell.Context) {
+		if cli.output == "" || cli.output == outputTable {
+			cb(c)
+			return
+		}
+		var text bytes.Buffer
+		cli.lastResult = nil
+		cli.sh.shell.SetOut(&text)
+		defer cli.restoreOut()
+		cb(c)
+
+		if cli.lastCmdErr != nil || cli.lastResult == nil {
+			cli.restoreOut()
+			io.Copy(cli.out(), &text)
+			return
+		}
+		if err := renderOutput(os.Stdout, cli.output, cli.lastResult); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			cli.lastCmdErr = err
+		}
+		cli.rendered = true
+	}
+}
+
+// out is the writer of the text output of the commands
+func (cli *Cli) out() io.Writer {
+	if cli.quiet {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+func (cli *Cli) restoreOut() {
+	cli.sh.shell.SetOut(cli.out())
+}
+
+// renderOutput writes a JSON response of the API server in format
+func renderOutput(w io.Writer, format string, body []byte) error {
+	switch format {
+	case outputJSON:
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, bytes.TrimSpace(body), "", "  "); err != nil {
+			return fmt.Errorf("response is not JSON: %w", err)
+		}
+		indented.WriteByte('\n')
+		_, err := indented.WriteTo(w)
+		return err
+	case outputCSV:
+		return writeCSV(w, body)
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// writeCSV writes a JSON response as CSV, one row per element of a list.
+// An object holding a single list, such as the parameters of a device, is
+// written as that list, any other object as one row. Nested objects are
+// flattened into dotted columns and nested lists kept as JSON.
+func writeCSV(w io.Writer, body []byte) error {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("response is not JSON: %w", err)
+	}
+
+	var rows []interface{}
+	switch value := v.(type) {
+	case []interface{}:
+		rows = value
+	case map[string]interface{}:
+		rows = []interface{}{value}
+		if list, ok := singleList(value); ok {
+			rows = list
+		}
+	default:
+		rows = []interface{}{map[string]interface{}{"value": value}}
+	}
+
+	records := make([]map[string]string, 0, len(rows))
+	columns := map[string]bool{}
+	for _, row := range rows {
+		record := map[string]string{}
+		if obj, ok := row.(map[string]interface{}); ok {
+			flattenCSV(record, "", obj)
+		} else {
+			record["value"] = csvValue(row)
+		}
+		for k := range record {
+			columns[k] = true
+		}
+		records = append(records, record)
+	}
+	header := make([]string, 0, len(columns))
+	for k := range columns {
+		header = append(header, k)
+	}
+	sort.Strings(header)
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	for _, record := range records {
+		line := make([]string, len(header))
+		for i, k := range header {
+			line[i] = record[k]
+		}
+		cw.Write(line)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// singleList returns the list of an object whose only list member is a
+// list of objects
+func singleList(obj map[string]interface{}) ([]interface{}, bool) {
+	var found []interface{}
+	for _, v := range obj {
+		list, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = list
+	}
+	if found == nil {
+		return nil, false
+	}
+	for _, v := range found {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return found, true
+}
+
+func flattenCSV(record map[string]string, prefix string, obj map[string]interface{}) {
+	for k, v := range obj {
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenCSV(record, prefix+k+".", nested)
+			continue
+		}
+		record[prefix+k] = csvValue(v)
+	}
+}
+
+func csvValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+const showOutputHelp = "show output"
+
+func (cli *Cli) showOutput(c *ishell.Context) {
+	format := cli.output
+	if format == "" {
+		format = outputTable
+	}
+	c.Printf("  %-24s : %s\n", "Output Format", format)
+}
+
+const setOutputHelp = "set output table|json|csv"
+
+func (cli *Cli) setOutput(c *ishell.Context) {
+	if len(c.Args) != 1 {
+		c.Println("Wrong input.", setOutputHelp)
+		cli.lastCmdErr = &errUsage{fmt.Errorf("usage: %s", setOutputHelp)}
+		return
+	}
+	if err := cli.SetOutput(strings.ToLower(c.Args[0])); err != nil {
+		c.Println(err)
+		cli.lastCmdErr = err
+		return
+	}
+	c.Println("Output format set to", cli.output)
+}
+
+func (cli *Cli) registerNounsOutput() {
+	cmds := []noun{
+		{"show", "output", showOutputHelp, cli.showOutput},
+		{"set", "output", setOutputHelp, cli.setOutput},
+	}
+	cli.registerNouns(cmds)
+}
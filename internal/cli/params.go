@@ -54,7 +54,7 @@ func (cli *Cli) setParamCmd(c *ishell.Context) {
 	c.Println("Set param for:", path)
 }
 
-const updateParamHelp = "update param <path>"
+const updateParamHelp = "update param <path> - path may use wildcards and search expressions, e.g. Device.WiFi.SSID.*.SSID"
 
 func (cli *Cli) updateParamCmd(c *ishell.Context) {
 	var err error
@@ -62,7 +62,7 @@ func (cli *Cli) updateParamCmd(c *ishell.Context) {
 		c.Println(err)
 		return
 	}
-	path := getPath(c.Args)
+	path := getSearchPath(c.Args)
 
 	if err = cli.restUpdateParams(path); err != nil {
 		c.Println(err)
@@ -71,7 +71,7 @@ func (cli *Cli) updateParamCmd(c *ishell.Context) {
 	c.Println("Updated param for:", path)
 }
 
-const showParamHelp = "show param <path>"
+const showParamHelp = "show param <path> - path may use wildcards and search expressions, e.g. Device.IP.Interface.[Alias==\"lan\"]."
 
 func (cli *Cli) showParamCmd(c *ishell.Context) {
 	var err error
@@ -80,7 +80,7 @@ func (cli *Cli) showParamCmd(c *ishell.Context) {
 		return
 	}
 
-	path := getPath(c.Args)
+	path := getSearchPath(c.Args)
 
 	objParams, err := cli.restReadParams(path)
 	if err != nil {
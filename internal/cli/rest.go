@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	neturl "net/url"
+	"strings"
 )
 
 type RestObjParam struct {
@@ -121,7 +123,8 @@ func (cli *Cli) restReadParams(path string) ([]*RestObjParam, error) {
 	if !cli.agent.isSet.epId {
 		return nil, errors.New("agent endpoint id is not set")
 	}
-	url := cli.cfg.apiServerAddr + GET_PARAMS + cli.agent.epId + "/" + path
+	// Search expressions may contain quotes and spaces
+	url := cli.cfg.apiServerAddr + GET_PARAMS + cli.agent.epId + "/" + neturl.PathEscape(path)
 
 	data, err := cli.restGet(url)
 	if err != nil {
@@ -191,7 +194,7 @@ func (cli *Cli) restDeleteCollection(collName string) error {
 	return nil
 }
 func (cli *Cli) restUpdateParams(path string) error {
-	url := cli.cfg.apiServerAddr + UPDATE_PARAMS + cli.agent.epId + "/" + path
+	url := cli.cfg.apiServerAddr + UPDATE_PARAMS + cli.agent.epId + "/" + neturl.PathEscape(path)
 
 	if _, err := cli.restGet(url); err != nil {
 		log.Println("Error in RESt Params Update, err", err)
@@ -321,14 +324,19 @@ func (cli *Cli) restGet(url string) ([]byte, error) {
 
 	log.Println("HTTP Status:", resp.Status)
 	if resp.StatusCode != 200 {
-		errStr := string(bodyBytes)
-		log.Println("HTTP Error Msg:", errStr)
-		return nil, errors.New(errStr)
+		log.Println("HTTP Error Msg:", string(bodyBytes))
+		return nil, newRestError(resp, bodyBytes)
 	}
+	cli.lastResult = bodyBytes
 	return bodyBytes, nil
 }
 
 func (cli *Cli) restPost(url string, data []byte) ([]byte, error) {
+	return cli.restPostContent(url, "application/json", data)
+}
+
+// restPostContent posts data of the given content type, e.g. text/csv
+func (cli *Cli) restPostContent(url string, contentType string, data []byte) ([]byte, error) {
 	log.Println("Sending POST to:", url)
 	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
 	if err != nil {
@@ -336,7 +344,7 @@ func (cli *Cli) restPost(url string, data []byte) ([]byte, error) {
 		return nil, err
 	}
 	req.Header.Add("Accept", "application/json")
-	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Type", contentType)
 	//req.SetBasicAuth("n4admin", "n4defaultpass")
 	req.SetBasicAuth(cli.cfg.authName, cli.cfg.authPasswd)
 
@@ -347,15 +355,69 @@ func (cli *Cli) restPost(url string, data []byte) ([]byte, error) {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	log.Println("HTTP Status:", resp.Status)
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Println("restErr:", err)
+		return nil, err
+	}
 	if resp.StatusCode != 200 {
 		log.Println("HTTP Error code:", resp.Status)
-		return nil, errors.New(resp.Status)
+		return nil, newRestError(resp, bodyBytes)
+	}
+	cli.lastResult = bodyBytes
+	return bodyBytes, nil
+}
+
+func (cli *Cli) restDelete(url string) ([]byte, error) {
+	log.Println("Sending DELETE to:", url)
+	req, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		log.Println("restErr:", err)
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/json")
+	req.SetBasicAuth(cli.cfg.authName, cli.cfg.authPasswd)
+
+	resp, err := cli.rest.client.Do(req)
+	if err != nil {
+		log.Println("restErr:", err)
+		return nil, err
 	}
+	defer resp.Body.Close()
 	log.Println("HTTP Status:", resp.Status)
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		log.Println("restErr:", err)
 		return nil, err
 	}
+	if resp.StatusCode != 200 {
+		log.Println("HTTP Error code:", resp.Status)
+		return nil, newRestError(resp, bodyBytes)
+	}
+	cli.lastResult = bodyBytes
 	return bodyBytes, nil
 }
+
+// restError is an error response of the API server, decoded from its
+// problem+json body when present
+type restError struct {
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (e *restError) Error() string {
+	if e.Detail != "" {
+		return e.Detail
+	}
+	return http.StatusText(e.Status)
+}
+
+func newRestError(resp *http.Response, body []byte) error {
+	rErr := &restError{}
+	if err := json.Unmarshal(body, rErr); err != nil || rErr.Status == 0 {
+		rErr = &restError{Status: resp.StatusCode, Detail: strings.TrimSpace(string(body))}
+	}
+	return rErr
+}
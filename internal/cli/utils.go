@@ -207,6 +207,17 @@ func getPath(args []string) string {
 	}
 }
 
+// getSearchPath returns a path given with wildcards or a search expression
+// as typed, as it may end with a parameter name; the words of a search
+// expression split by the shell are joined back
+func getSearchPath(args []string) string {
+	path := strings.Join(args, " ")
+	if strings.ContainsAny(path, "*[") {
+		return path
+	}
+	return getPath(args)
+}
+
 func getMsgId(t MsgType) string {
 	switch t {
 	case MsgTypeGet:
@@ -281,7 +292,7 @@ func (cli *Cli) registerNouns(nouns []noun) {
 		cmd := &ishell.Cmd{
 			Name: n.name,
 			Help: n.help,
-			Func: n.cb,
+			Func: cli.withOutput(n.cb),
 		}
 		parent, ok = cli.sh.cmds[n.parent]
 		if !ok {
@@ -27,18 +27,25 @@ type verb struct {
 
 func (cli *Cli) registerVerbs() {
 	verbs := []verb{
-		{"add", []string{"bridging", "devinfo", "dhcpv4", "ip", "nat", "wifi", "instance"}},
+		{"add", []string{"bridging", "cwmp", "devinfo", "dhcpv4", "ip", "nat", "wifi", "instance"}},
 		{"addcfg", []string{"bridging", "dhcpv4", "ip", "nat", "wifi"}},
 		{"reconnect", []string{"db", "mtp", "stomp"}},
 		{"operate", []string{"bridging", "command", "device", "devinfo", "ip", "wifi", "param", "instance"}},
-		{"set", []string{"agent", "devinfo", "bridging", "history", "ip", "logging", "nat", "wifi", "param"}},
+		{"set", []string{"agent", "cwmp", "devinfo", "bridging", "history", "ip", "logging", "nat", "output", "wifi", "param"}},
 		{"setcfg", []string{"bridging", "devinfo", "ip", "nat", "wifi"}},
-		{"show", []string{"agent", "bridging", "devinfo", "eth", "dhcpv4", "history", "ip", "logging", "nat", "nw", "wifi", "datamodel", "param", "instance", "version"}},
+		{"show", []string{"agent", "cwmp", "bridging", "devinfo", "eth", "dhcpv4", "history", "ip", "logging", "nat", "nw", "output", "wifi", "datamodel", "param", "instance", "version"}},
 		{"showcfg", []string{"bridging", "devinfo", "eth", "dhcpv4", "ip", "nat", "wifi"}},
-		{"remove", []string{"bridging", "db", "devinfo", "dhcpv4", "history", "ip", "nat", "stomp", "wifi", "param", "instance"}},
+		{"remove", []string{"bridging", "cwmp", "db", "devinfo", "dhcpv4", "history", "ip", "nat", "stomp", "wifi", "param", "instance"}},
 		{"removecfg", []string{"bridging", "dhcpv4", "ip", "nat", "wifi"}},
 		{"update", []string{"bridging", "dhcpv4", "ip", "nat", "wifi", "datamodel", "param", "instance"}},
 		{"unset", []string{"agent"}},
+		{"get", []string{"cwmp"}},
+		{"reboot", []string{"cwmp"}},
+		{"factory-reset", []string{"cwmp"}},
+		{"download", []string{"cwmp"}},
+		{"upload", []string{"cwmp"}},
+		{"connection-request", []string{"cwmp"}},
+		{"import", []string{"cwmp"}},
 	}
 	cli.addVerbCmds(verbs)
 }
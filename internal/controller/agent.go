@@ -81,6 +81,9 @@ func (c *Cntlr) agentInitThread(initData *agentInitData) {
 	if err := c.setAgentDefaultConfig(agentId, params, mtpIntf); err != nil {
 		log.Println("Error in setting default config", err)
 	}
+	if err := c.applyZtpTemplate(agentId, params, mtpIntf); err != nil {
+		log.Println("Error in applying ZTP template", err)
+	}
 
 	// send get param to agent
 	msgId = "AGENT_REINIT_GET_PARAM_" + strconv.FormatUint(mtpIntf.GetMsgCnt(), 10)
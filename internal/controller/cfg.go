@@ -19,6 +19,8 @@ import (
 	"strconv"
 
 	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/debugserver"
+	"github.com/n4-networks/openusp/pkg/logging"
 )
 
 const (
@@ -58,6 +60,13 @@ func (c *Cntlr) loadConfig() error {
 	
 	c.config = cfg
 
+	if err := logging.Setup(cfg.Logging); err != nil {
+		log.Printf("Error applying logging config: %v", err)
+	}
+	if err := debugserver.Start(cfg.Debug, "controller"); err != nil {
+		log.Printf("Error starting debug server: %v", err)
+	}
+
 	// Map YAML config to legacy cntlrCfg struct for backward compatibility
 	c.cfg.cache.serverAddr = cfg.GetCacheAddress()
 	c.cfg.grpc.port = strconv.Itoa(cfg.Protocols.GRPC.Port)
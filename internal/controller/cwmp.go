@@ -22,8 +22,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/n4-networks/openusp/internal/acsbus"
 	"github.com/n4-networks/openusp/internal/cwmp"
 	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -48,7 +50,7 @@ type CwmpDevice struct {
 // CwmpManager handles TR-069 device management within the controller
 type CwmpManager struct {
 	devices    map[string]*CwmpDevice
-	acsServer  *cwmp.AcsServer
+	bus        *acsbus.Client // events from and commands to the ACS
 	mutex      sync.RWMutex
 	cfg        CwmpConfig
 	dbH        *db.CwmpDb
@@ -56,8 +58,6 @@ type CwmpManager struct {
 
 // CwmpConfig holds CWMP configuration
 type CwmpConfig struct {
-	EnableACS           bool
-	ACSPort            string
 	ConnectionRequestPort string
 	PeriodicInformInterval uint32
 	ConnectionRequestAuth  string
@@ -77,19 +77,20 @@ func (c *Cntlr) InitCwmp() error {
 		return fmt.Errorf("failed to load CWMP config: %w", err)
 	}
 	
-	// Initialize ACS server if enabled
-	if c.cwmpMgr.cfg.EnableACS {
-		c.cwmpMgr.acsServer = &cwmp.AcsServer{}
-		if err := c.cwmpMgr.acsServer.Init(); err != nil {
-			return fmt.Errorf("failed to initialize ACS server: %w", err)
+	// The ACS runs as its own service, it publishes device events on the
+	// ACS bus and consumes the RPC commands sent by the controller
+	if c.config != nil && c.config.MessageBus.ACS.Enabled {
+		bus, err := acsbus.Connect(&c.config.MessageBus, "openusp-controller")
+		if err != nil {
+			return fmt.Errorf("failed to connect to ACS bus: %w", err)
 		}
-		
-		// Start ACS server in background
-		go func() {
-			if err := c.cwmpMgr.acsServer.Start(); err != nil {
-				log.Printf("ACS server error: %v", err)
-			}
-		}()
+		if err := bus.SubscribeEvents(c.cwmpMgr.handleAcsEvent); err != nil {
+			bus.Close()
+			return fmt.Errorf("failed to subscribe to ACS events: %w", err)
+		}
+		c.cwmpMgr.bus = bus
+	} else {
+		log.Println("ACS bus is disabled, CWMP devices cannot be managed")
 	}
 	
 	log.Println("CWMP Manager initialized successfully")
@@ -101,8 +102,6 @@ func (cm *CwmpManager) loadConfig() error {
 	// Configuration loading logic would be implemented here
 	// For now, use defaults
 	cm.cfg = CwmpConfig{
-		EnableACS: true,
-		ACSPort:   "7547",
 		ConnectionRequestPort: "7548",
 		PeriodicInformInterval: 300,
 		ConnectionRequestAuth: "Basic",
@@ -112,6 +111,14 @@ func (cm *CwmpManager) loadConfig() error {
 
 // RegisterCwmpDevice registers a new TR-069 device
 func (cm *CwmpManager) RegisterCwmpDevice(deviceInfo *cwmp.DeviceIdStruct, parameterList []cwmp.ParameterValueStruct) error {
+	device := cm.addDevice(deviceInfo, parameterList)
+
+	// Store device in database
+	return cm.storeDeviceInDB(device)
+}
+
+// addDevice tracks a device which informed
+func (cm *CwmpManager) addDevice(deviceInfo *cwmp.DeviceIdStruct, parameterList []cwmp.ParameterValueStruct) *CwmpDevice {
 	cm.mutex.Lock()
 	defer cm.mutex.Unlock()
 	
@@ -151,9 +158,7 @@ func (cm *CwmpManager) RegisterCwmpDevice(deviceInfo *cwmp.DeviceIdStruct, param
 	
 	cm.devices[deviceId] = device
 	log.Printf("Registered CWMP device: %s", deviceId)
-	
-	// Store device in database
-	return cm.storeDeviceInDB(device)
+	return device
 }
 
 // GetCwmpDevice retrieves a CWMP device by ID
@@ -182,7 +187,7 @@ func (cm *CwmpManager) GetAllCwmpDevices() []*CwmpDevice {
 }
 
 // GetParameterValues requests parameter values from a CWMP device
-func (cm *CwmpManager) GetParameterValues(deviceId string, parameterNames []string) error {
+func (cm *CwmpManager) GetParameterValues(ctx context.Context, deviceId string, parameterNames []string) error {
 	device, err := cm.GetCwmpDevice(deviceId)
 	if err != nil {
 		return err
@@ -192,15 +197,15 @@ func (cm *CwmpManager) GetParameterValues(deviceId string, parameterNames []stri
 		return fmt.Errorf("device is offline: %s", deviceId)
 	}
 	
-	if cm.acsServer != nil {
-		return cm.acsServer.GetParameterValues(deviceId, parameterNames)
-	}
-	
-	return fmt.Errorf("ACS server not available")
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID:       deviceId,
+		Method:         acsbus.MethodGetParameterValues,
+		ParameterNames: parameterNames,
+	})
 }
 
 // SetParameterValues sets parameter values on a CWMP device
-func (cm *CwmpManager) SetParameterValues(deviceId string, parameters []cwmp.ParameterValueStruct, parameterKey string) error {
+func (cm *CwmpManager) SetParameterValues(ctx context.Context, deviceId string, parameters []cwmp.ParameterValueStruct, parameterKey string) error {
 	device, err := cm.GetCwmpDevice(deviceId)
 	if err != nil {
 		return err
@@ -210,15 +215,19 @@ func (cm *CwmpManager) SetParameterValues(deviceId string, parameters []cwmp.Par
 		return fmt.Errorf("device is offline: %s", deviceId)
 	}
 	
-	if cm.acsServer != nil {
-		return cm.acsServer.SetParameterValues(deviceId, parameters, parameterKey)
+	cmd := &acsbus.Command{
+		DeviceID:     deviceId,
+		Method:       acsbus.MethodSetParameterValues,
+		ParameterKey: parameterKey,
 	}
-	
-	return fmt.Errorf("ACS server not available")
+	for _, p := range parameters {
+		cmd.Parameters = append(cmd.Parameters, acsbus.Parameter{Name: p.Name, Value: p.Value, Type: p.Type})
+	}
+	return cm.sendCommand(ctx, cmd)
 }
 
 // RebootCwmpDevice reboots a CWMP device
-func (cm *CwmpManager) RebootCwmpDevice(deviceId string, commandKey string) error {
+func (cm *CwmpManager) RebootCwmpDevice(ctx context.Context, deviceId string, commandKey string) error {
 	device, err := cm.GetCwmpDevice(deviceId)
 	if err != nil {
 		return err
@@ -228,11 +237,151 @@ func (cm *CwmpManager) RebootCwmpDevice(deviceId string, commandKey string) erro
 		return fmt.Errorf("device is offline: %s", deviceId)
 	}
 	
-	if cm.acsServer != nil {
-		return cm.acsServer.RebootDevice(deviceId, commandKey)
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID:   deviceId,
+		Method:     acsbus.MethodReboot,
+		CommandKey: commandKey,
+	})
+}
+
+// GetRPCMethods asks a CWMP device for the RPC methods it supports, the ACS
+// stores them on the device
+func (cm *CwmpManager) GetRPCMethods(ctx context.Context, deviceId string) error {
+	if _, err := cm.GetCwmpDevice(deviceId); err != nil {
+		return err
+	}
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID: deviceId,
+		Method:   acsbus.MethodGetRPCMethods,
+	})
+}
+
+// GetParameterNames asks a CWMP device for the data model nodes under path,
+// the ACS stores them as the data model of the device
+func (cm *CwmpManager) GetParameterNames(ctx context.Context, deviceId string, path string, nextLevel bool) error {
+	if _, err := cm.GetCwmpDevice(deviceId); err != nil {
+		return err
+	}
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID:      deviceId,
+		Method:        acsbus.MethodGetParameterNames,
+		ParameterPath: path,
+		NextLevel:     nextLevel,
+	})
+}
+
+// AddObject asks a CWMP device to create an instance of objectName, a
+// multi-instance object path ending with a dot such as
+// Device.WiFi.SSID. The ACS reports the instance number it was assigned.
+func (cm *CwmpManager) AddObject(ctx context.Context, deviceId string, objectName string, parameterKey string) error {
+	if _, err := cm.GetCwmpDevice(deviceId); err != nil {
+		return err
+	}
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID:     deviceId,
+		Method:       acsbus.MethodAddObject,
+		ObjectName:   objectName,
+		ParameterKey: parameterKey,
+	})
+}
+
+// DeleteObject asks a CWMP device to delete the object instance objectName,
+// e.g. Device.WiFi.SSID.2.
+func (cm *CwmpManager) DeleteObject(ctx context.Context, deviceId string, objectName string, parameterKey string) error {
+	if _, err := cm.GetCwmpDevice(deviceId); err != nil {
+		return err
+	}
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID:     deviceId,
+		Method:       acsbus.MethodDeleteObject,
+		ObjectName:   objectName,
+		ParameterKey: parameterKey,
+	})
+}
+
+// SendConnectionRequest asks the ACS to send a connection request to a
+// device, e.g. to have the RPCs queued for it executed without waiting for
+// its next periodic Inform
+func (cm *CwmpManager) SendConnectionRequest(ctx context.Context, deviceId string) error {
+	if _, err := cm.GetCwmpDevice(deviceId); err != nil {
+		return err
+	}
+	return cm.sendCommand(ctx, &acsbus.Command{
+		DeviceID: deviceId,
+		Method:   acsbus.MethodConnectionRequest,
+	})
+}
+
+// sendCommand sends an RPC command to the ACS over the bus, tagged with the
+// request ID of ctx
+func (cm *CwmpManager) sendCommand(ctx context.Context, cmd *acsbus.Command) error {
+	if cm.bus == nil {
+		return fmt.Errorf("ACS bus not available")
+	}
+	cmd.RequestID = logging.RequestID(ctx)
+	logging.FromContext(ctx).Infof("Sending %s command for device %s to the ACS", cmd.Method, cmd.DeviceID)
+	return cm.bus.SendCommand(cmd)
+}
+
+// handleAcsEvent updates the devices from the events published by the ACS
+func (cm *CwmpManager) handleAcsEvent(event *acsbus.Event) {
+	params := make([]cwmp.ParameterValueStruct, 0, len(event.Parameters))
+	for _, p := range event.Parameters {
+		params = append(params, cwmp.ParameterValueStruct{Name: p.Name, Value: p.Value, Type: p.Type})
+	}
+
+	switch event.Type {
+	case acsbus.EventInform:
+		if _, err := cm.GetCwmpDevice(event.DeviceID); err != nil && event.Device != nil {
+			// The ACS registers the device, only track it here
+			cm.addDevice(&cwmp.DeviceIdStruct{
+				Manufacturer: event.Device.Manufacturer,
+				OUI:          event.Device.OUI,
+				ProductClass: event.Device.ProductClass,
+				SerialNumber: event.Device.SerialNumber,
+			}, params)
+			return
+		}
+		if err := cm.UpdateDeviceStatus(event.DeviceID, true); err != nil {
+			log.Printf("Error updating status of device %s: %v", event.DeviceID, err)
+			return
+		}
+		if device, err := cm.GetCwmpDevice(event.DeviceID); err == nil {
+			device.mutex.Lock()
+			for _, p := range params {
+				device.Parameters[p.Name] = p
+			}
+			device.mutex.Unlock()
+		}
+	case acsbus.EventParameters:
+		if err := cm.UpdateDeviceParameters(event.DeviceID, params); err != nil {
+			logging.ForRequest(event.RequestID).Errorf("Error updating parameters of device %s: %v", event.DeviceID, err)
+		}
+	case acsbus.EventObject:
+		if event.InstanceNumber != 0 {
+			logging.ForRequest(event.RequestID).Infof("Device %s added %s", event.DeviceID, event.ObjectName)
+			return
+		}
+		// The ACS removed the stored parameters of the deleted instance
+		if device, err := cm.GetCwmpDevice(event.DeviceID); err == nil {
+			device.mutex.Lock()
+			for name := range device.Parameters {
+				if strings.HasPrefix(name, event.ObjectName) {
+					delete(device.Parameters, name)
+				}
+			}
+			device.mutex.Unlock()
+		}
+		logging.ForRequest(event.RequestID).Infof("Device %s deleted %s", event.DeviceID, event.ObjectName)
+	case acsbus.EventTransfer:
+		if event.FaultCode != 0 {
+			log.Printf("Transfer %q failed on device %s: fault %d: %s", event.CommandKey, event.DeviceID, event.FaultCode, event.FaultString)
+			return
+		}
+		log.Printf("Transfer %q completed on device %s", event.CommandKey, event.DeviceID)
+	default:
+		log.Printf("Ignoring ACS event %s of device %s", event.Type, event.DeviceID)
 	}
-	
-	return fmt.Errorf("ACS server not available")
 }
 
 // UpdateDeviceStatus updates device online status
@@ -0,0 +1,99 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cntlr
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/pkg/pb/cntlrgrpc"
+)
+
+/* CWMP related services, the commands are sent to the ACS over the bus */
+func (c *Cntlr) CwmpSetParamsReq(ctx context.Context, p *cntlrgrpc.CwmpSetParamsReqData) (*cntlrgrpc.CwmpCmdResult, error) {
+	cmd := &acsbus.Command{
+		DeviceID:     p.DeviceId,
+		Method:       acsbus.MethodSetParameterValues,
+		ParameterKey: p.ParamKey,
+	}
+	for _, param := range p.Params {
+		cmd.Parameters = append(cmd.Parameters, acsbus.Parameter{Name: param.Name, Value: param.Value, Type: param.Type})
+	}
+	return c.submitCwmpCmd(ctx, cmd), nil
+}
+
+func (c *Cntlr) CwmpRebootReq(ctx context.Context, p *cntlrgrpc.CwmpRebootReqData) (*cntlrgrpc.CwmpCmdResult, error) {
+	return c.submitCwmpCmd(ctx, &acsbus.Command{
+		DeviceID:   p.DeviceId,
+		Method:     acsbus.MethodReboot,
+		CommandKey: p.CmdKey,
+	}), nil
+}
+
+func (c *Cntlr) CwmpFactoryResetReq(ctx context.Context, p *cntlrgrpc.CwmpFactoryResetReqData) (*cntlrgrpc.CwmpCmdResult, error) {
+	return c.submitCwmpCmd(ctx, &acsbus.Command{
+		DeviceID: p.DeviceId,
+		Method:   acsbus.MethodFactoryReset,
+	}), nil
+}
+
+func (c *Cntlr) CwmpDownloadReq(ctx context.Context, p *cntlrgrpc.CwmpTransferReqData) (*cntlrgrpc.CwmpCmdResult, error) {
+	return c.submitCwmpCmd(ctx, cwmpTransferCmd(acsbus.MethodDownload, p)), nil
+}
+
+func (c *Cntlr) CwmpUploadReq(ctx context.Context, p *cntlrgrpc.CwmpTransferReqData) (*cntlrgrpc.CwmpCmdResult, error) {
+	return c.submitCwmpCmd(ctx, cwmpTransferCmd(acsbus.MethodUpload, p)), nil
+}
+
+func cwmpTransferCmd(method string, p *cntlrgrpc.CwmpTransferReqData) *acsbus.Command {
+	return &acsbus.Command{
+		DeviceID:   p.DeviceId,
+		Method:     method,
+		CommandKey: p.CmdKey,
+		Transfer: &acsbus.Transfer{
+			FileType:       p.FileType,
+			URL:            p.Url,
+			FileID:         p.FileId,
+			Username:       p.Username,
+			Password:       p.Password,
+			FileSize:       p.FileSize,
+			TargetFileName: p.TargetFileName,
+			DelaySeconds:   p.DelaySeconds,
+			SuccessURL:     p.SuccessUrl,
+			FailureURL:     p.FailureUrl,
+		},
+	}
+}
+
+// submitCwmpCmd sends a command for a registered device to the ACS, which
+// holds it until the device connects. Failures are reported in the result.
+func (c *Cntlr) submitCwmpCmd(ctx context.Context, cmd *acsbus.Command) *cntlrgrpc.CwmpCmdResult {
+	ret := &cntlrgrpc.CwmpCmdResult{DeviceId: cmd.DeviceID, Method: cmd.Method}
+	if c.cwmpMgr == nil {
+		ret.ErrMsg = "CWMP manager is not initialized"
+		return ret
+	}
+	if _, err := c.dbH.GetCwmpDeviceByID(cmd.DeviceID); err != nil {
+		ret.ErrMsg = fmt.Sprintf("device %s is not registered: %v", cmd.DeviceID, err)
+		return ret
+	}
+	if err := c.cwmpMgr.sendCommand(ctx, cmd); err != nil {
+		ret.ErrMsg = err.Error()
+		return ret
+	}
+	ret.IsSuccess = true
+	return ret
+}
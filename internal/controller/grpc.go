@@ -19,12 +19,15 @@ import (
 	"errors"
 	"log"
 	"net"
+	"sort"
 	"time"
 
 	"github.com/n4-networks/openusp/internal/parser"
+	"github.com/n4-networks/openusp/pkg/logging"
 	"github.com/n4-networks/openusp/pkg/pb/bbf/usp_msg"
 	"github.com/n4-networks/openusp/pkg/pb/cntlrgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 type instanceResp struct {
@@ -40,7 +43,7 @@ func (c *Cntlr) GrpcServerThread(port string, exit chan int32) {
 		log.Fatalf("failed to listen: %v", err)
 	} else {
 		log.Printf("Starting Grpc Server at: %s", port)
-		grpcServer := grpc.NewServer()
+		grpcServer := grpc.NewServer(grpc.UnaryInterceptor(requestIDInterceptor))
 		cntlrgrpc.RegisterGrpcServer(grpcServer, c)
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Fatalf("Grpc server failed to serve: %v", err)
@@ -50,12 +53,40 @@ func (c *Cntlr) GrpcServerThread(port string, exit chan int32) {
 	exit <- GRPC_SERVER
 }
 
+// requestIDInterceptor restores the request ID forwarded by the API server
+// in the context of the call and logs which USP message serves it
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(logging.RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+			ctx = logging.WithRequestID(ctx, ids[0])
+			if m, ok := req.(interface{ GetMsgId() string }); ok && m.GetMsgId() != "" {
+				logging.FromContext(ctx).Infof("%s: MsgId: %s", info.FullMethod, m.GetMsgId())
+			} else {
+				logging.FromContext(ctx).Infof("%s", info.FullMethod)
+			}
+		}
+	}
+	return handler(ctx, req)
+}
+
 func (c *Cntlr) GetInfo(ctx context.Context, p *cntlrgrpc.None) (*cntlrgrpc.InfoData, error) {
 	ret := &cntlrgrpc.InfoData{}
 	ret.Version = getVer()
 	return ret, nil
 }
 
+// GetAgents returns the IDs of the agents which registered their MTP with
+// the controller, sorted
+func (c *Cntlr) GetAgents(ctx context.Context, p *cntlrgrpc.None) (*cntlrgrpc.AgentsData, error) {
+	ret := &cntlrgrpc.AgentsData{}
+	for agentId := range c.agentH.mtpMap {
+		ret.AgentIds = append(ret.AgentIds, agentId)
+	}
+	sort.Strings(ret.AgentIds)
+	return ret, nil
+}
+
 /* USP releated services */
 func (c *Cntlr) GetParamReq(ctx context.Context, p *cntlrgrpc.GetParamReqData) (*cntlrgrpc.ReqResult, error) {
 	log.Printf("GetParamReqTx: AgetnId: %v, MsgId: %v\n", p.AgentId, p.MsgId)
@@ -0,0 +1,108 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cntlr
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/mtp"
+	"github.com/n4-networks/openusp/internal/parser"
+	"github.com/n4-networks/openusp/pkg/pb/bbf/usp_msg"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// uspFirmwareDownloadCmd is the command ZTP firmware downloads are operated
+// with, the second image is the one not running on most agents
+const uspFirmwareDownloadCmd = "Device.DeviceInfo.FirmwareImage.2.Download()"
+
+// applyZtpTemplate sends the parameters and firmware downloads of the
+// zero-touch provisioning template matching an agent booting for the first
+// time. An agent is only considered once, whether a template matched it or
+// not.
+func (c *Cntlr) applyZtpTemplate(agentId string, params map[string]string, mtpIntf mtp.MtpIntf) error {
+	oui := params["Device.DeviceInfo.ManufacturerOUI"]
+	productClass := params["Device.DeviceInfo.ProductClass"]
+	serialNumber := params["Device.DeviceInfo.SerialNumber"]
+	template, err := c.dbH.MatchZtpTemplate(db.ZtpProtocolUsp, oui, productClass, serialNumber)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+
+	seen := &db.ZtpDevice{DeviceID: agentId, Protocol: db.ZtpProtocolUsp}
+	if template != nil {
+		seen.Template = template.Name
+	}
+	first, err := c.dbH.MarkZtpDevice(seen)
+	if err != nil || !first || template == nil {
+		return err
+	}
+	log.Printf("Applying ZTP template %s to agent %s", template.Name, agentId)
+
+	if len(template.Parameters) > 0 {
+		objs := map[string]*usp_msg.Set_UpdateObject{}
+		var order []*usp_msg.Set_UpdateObject
+		for _, p := range template.Parameters {
+			i := strings.LastIndex(p.Path, ".")
+			objPath, name := p.Path[:i+1], p.Path[i+1:]
+			obj, ok := objs[objPath]
+			if !ok {
+				obj = &usp_msg.Set_UpdateObject{ObjPath: objPath}
+				objs[objPath] = obj
+				order = append(order, obj)
+			}
+			obj.ParamSettings = append(obj.ParamSettings, &usp_msg.Set_UpdateParamSetting{
+				Param:    name,
+				Value:    p.Value,
+				Required: true,
+			})
+		}
+		msgId := "AGENT_ZTP_SET_PARAM_" + strconv.FormatUint(mtpIntf.GetMsgCnt(), 10)
+		uspMsg, err := parser.CreateUspSetReqMsg(order, msgId)
+		if err != nil {
+			return err
+		}
+		if err := c.sendUspMsgToAgent(agentId, uspMsg, mtpIntf); err != nil {
+			return err
+		}
+	}
+
+	for i, d := range template.Downloads {
+		if d.FileType != "" && d.FileType != db.ZtpFileTypeFirmware {
+			log.Printf("ZTP template %s: skipping download %s, USP agents only receive firmware images", template.Name, d.URL)
+			continue
+		}
+		args := map[string]string{"URL": d.URL, "AutoActivate": "true"}
+		if d.Username != "" {
+			args["Username"] = d.Username
+			args["Password"] = d.Password
+		}
+		if d.FileSize > 0 {
+			args["FileSize"] = strconv.FormatInt(d.FileSize, 10)
+		}
+		msgId := "AGENT_ZTP_DOWNLOAD_" + strconv.FormatUint(mtpIntf.GetMsgCnt(), 10)
+		uspMsg, err := parser.CreateUspOperateReqMsg(uspFirmwareDownloadCmd, "ztp:"+template.Name+":"+strconv.Itoa(i), false, msgId, args)
+		if err != nil {
+			return err
+		}
+		if err := c.sendUspMsgToAgent(agentId, uspMsg, mtpIntf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,22 +15,48 @@
 package cwmp
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/geoip"
+	"github.com/n4-networks/openusp/internal/quota"
+	"github.com/n4-networks/openusp/internal/stun"
 	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/debugserver"
+	"github.com/n4-networks/openusp/pkg/logging"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Modes of the plain HTTP listener, it runs next to the HTTPS listener when
+// TLS is enabled
+const (
+	HTTPModeServe    = "serve"
+	HTTPModeRedirect = "redirect"
+	HTTPModeOff      = "off"
+)
+
+// defaultMaxRequestSize bounds the SOAP requests of CPEs unless configured
+const defaultMaxRequestSize = 16 << 20
+
+// connRequestInterval is the least time between the connection requests
+// sent to an offline device for the RPCs queued for it
+const connRequestInterval = 10 * time.Second
+
 // AcsConfig holds ACS server configuration
 type AcsConfig struct {
 	httpPort     string
@@ -41,6 +67,8 @@ type AcsConfig struct {
 	dbAddr       string
 	sessionTimeout uint32
 	informInterval uint32
+	maxRequestSize int64
+	httpMode     string
 	logLevel     string
 }
 
@@ -49,9 +77,27 @@ type AcsServer struct {
 	cfg      AcsConfig
 	config   *config.Config
 	dbClient *mongo.Client
+	dbH      *db.CwmpDb
+	geo      *geoip.DB
+	quota    *quota.Manager
+	informs  *informLimiter
+	bus      *acsbus.Client
+	connReq  *ConnRequestClient
+	files    *fileServer
+	stun     *stun.Server
+	sim      *simulator
 	sessions map[string]*CwmpSession
-	mutex    sync.RWMutex
-	server   *http.Server
+	// connSessions binds a CPE's HTTP connection to its CWMP session
+	connSessions map[string]*CwmpSession
+	mutex        sync.RWMutex
+	server       *http.Server
+	tlsServer    *http.Server
+	// draining refuses new Informs while Stop waits for open sessions
+	draining atomic.Bool
+	// connRequests holds when a connection request was last sent to a
+	// device for the commands queued for it
+	connRequests   map[string]time.Time
+	connRequestsMu sync.Mutex
 }
 
 // CwmpSession represents a TR-069 CWMP session with a device
@@ -62,17 +108,40 @@ type CwmpSession struct {
 	LastActivity time.Time
 	HoldRequests bool
 	MaxEnvelopes uint32
+	CwmpVersion  int // minor version of the cwmp-1-x namespace used by the CPE
 	State        SessionState
 	PendingRPCs  []interface{}
+	CurrentRPC   interface{}
+	RequestIDs   []string // operator requests which queued RPCs in the session
+	ConnectionRequestURL string
+	rpcRequests  map[interface{}]string // queued RPC to the request ID it serves
+	rpcCommands  map[interface{}]string // queued RPC to the stored command it delivers
+	heldRPCs     map[interface{}]bool   // queued RPCs the CPE holds its requests for
+	currentRequestID string
+	currentCommandID string
+	diagnostic   *diagFollowUp
+	bootstrap    *bootstrapSync
+	provisioning *provisioningRun
+	refresh      *dataModelRefresh
+	presets      *presetRun
+	rotations    []string // credential rotations to verify once the session ends
+	trace        bool
+	cookie       string // token of the session cookie issued at Inform
+	remoteAddr   string // CPE connection the session is bound to
+	closeReason  string
 	mutex        sync.RWMutex
 }
 
 type SessionState int
 
+// Session states: an Inform opens the session, the ACS then sends its RPCs
+// one at a time, awaiting the response of the CPE to each, and closes the
+// session once neither side has anything more to send
 const (
 	SessionStateNew SessionState = iota
 	SessionStateInform
 	SessionStateActive
+	SessionStateAwaitingResponse
 	SessionStateClosed
 )
 
@@ -88,7 +157,36 @@ func (acs *AcsServer) Init() error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if err := acs.loadGeoIP(); err != nil {
+		return fmt.Errorf("failed to load GeoIP database: %w", err)
+	}
+
+	if err := acs.connectBus(); err != nil {
+		return fmt.Errorf("failed to connect to ACS bus: %w", err)
+	}
+
+	acs.quota = quota.NewManager(acs.config.Tenants)
+	acs.quota.OnViolation = acs.raiseQuotaAlarm
+	acs.initInformLimiter()
+
+	acs.connReq = NewConnRequestClient(acs.config.Protocols.CWMP.ConnectionRequest)
+	if err := acs.startSTUN(); err != nil {
+		return err
+	}
+
 	acs.sessions = make(map[string]*CwmpSession)
+	acs.connSessions = make(map[string]*CwmpSession)
+	if acs.dbH != nil {
+		if err := acs.initFileServer(acs.dbH); err != nil {
+			return err
+		}
+		acs.restoreSessions()
+		go acs.expireCommands()
+		if acs.refreshEnabled() {
+			go acs.scheduleRefreshes()
+		}
+	}
+	go acs.expireSessions()
 	
 	// Initialize HTTP routes
 	acs.initRoutes()
@@ -97,7 +195,8 @@ func (acs *AcsServer) Init() error {
 	return nil
 }
 
-// loadConfig loads configuration from environment variables
+// loadConfig loads the ACS configuration from the protocols.cwmp section of
+// cwmpacs.yaml, environment variables override it as for the other services
 func (acs *AcsServer) loadConfig() error {
 	// Load YAML configuration - try to find cwmpacs.yaml specifically
 	cfg, err := config.LoadConfig("./configs/cwmpacs.yaml")
@@ -108,85 +207,218 @@ func (acs *AcsServer) loadConfig() error {
 	
 	acs.config = cfg
 
+	if err := logging.Setup(cfg.Logging); err != nil {
+		log.Printf("Error applying logging config: %v", err)
+	}
+	if err := debugserver.Start(cfg.Debug, "cwmpacs"); err != nil {
+		log.Printf("Error starting debug server: %v", err)
+	}
+
 	// Map YAML config to legacy AcsConfig struct for backward compatibility
-	acs.cfg.httpPort = strconv.Itoa(cfg.Protocols.CWMP.Port)
-	acs.cfg.httpsPort = strconv.Itoa(cfg.Protocols.CWMP.TLSPort)
-	acs.cfg.isTlsEnabled = cfg.Protocols.HTTP.EnableTLS
-	acs.cfg.certFile = cfg.Security.TLS.CertFile
-	acs.cfg.keyFile = cfg.Security.TLS.KeyFile
+	cwmpCfg := cfg.Protocols.CWMP
+	acs.cfg.httpPort = strconv.Itoa(cwmpCfg.Port)
+	acs.cfg.httpsPort = strconv.Itoa(cwmpCfg.TLSPort)
+	acs.cfg.isTlsEnabled = cwmpCfg.EnableTLS
+	acs.cfg.certFile = cwmpCfg.CertFile
+	acs.cfg.keyFile = cwmpCfg.KeyFile
+	if acs.cfg.certFile == "" {
+		acs.cfg.certFile = cfg.Security.TLS.CertFile
+		acs.cfg.keyFile = cfg.Security.TLS.KeyFile
+	}
 	acs.cfg.dbAddr = fmt.Sprintf("%s:%d", cfg.Database.Host, cfg.Database.Port)
 	acs.cfg.sessionTimeout = 30     // Default session timeout
+	if cwmpCfg.SessionTimeout > 0 {
+		acs.cfg.sessionTimeout = uint32(cwmpCfg.SessionTimeout.Seconds())
+	}
 	acs.cfg.informInterval = 300    // Default inform interval
+	if cwmpCfg.InformInterval > 0 {
+		acs.cfg.informInterval = uint32(cwmpCfg.InformInterval.Seconds())
+	}
+	acs.cfg.maxRequestSize = defaultMaxRequestSize
+	if cwmpCfg.MaxRequestSize > 0 {
+		acs.cfg.maxRequestSize = cwmpCfg.MaxRequestSize
+	}
+	acs.cfg.httpMode = HTTPModeServe
+	if cwmpCfg.HTTPMode != "" {
+		acs.cfg.httpMode = cwmpCfg.HTTPMode
+	}
 	acs.cfg.logLevel = cfg.Logging.Level
 
+	if acs.cfg.isTlsEnabled && (acs.cfg.certFile == "" || acs.cfg.keyFile == "") {
+		return errors.New("TLS is enabled but no certificate or key file is configured")
+	}
+	if err := checkClientCertConfig(cwmpCfg.ClientCert, acs.cfg.isTlsEnabled); err != nil {
+		return err
+	}
+	switch acs.cfg.httpMode {
+	case HTTPModeServe:
+	case HTTPModeRedirect, HTTPModeOff:
+		if !acs.cfg.isTlsEnabled {
+			return fmt.Errorf("HTTP mode %s requires TLS to be enabled", acs.cfg.httpMode)
+		}
+	default:
+		return fmt.Errorf("invalid HTTP mode: %s", acs.cfg.httpMode)
+	}
+
 	log.Printf("CWMP ACS Config: %+v", acs.cfg)
 	return nil
 }
 
-// connectDB establishes database connection
+// connectDB establishes database connection with the database settings of
+// cwmpacs.yaml
 func (acs *AcsServer) connectDB() error {
-	// Database connection logic would be implemented here
-	// For now, we'll use a placeholder
+	client, err := db.ConnectWithConfig(acs.config)
+	if err != nil {
+		return err
+	}
+	cwmpDb := &db.CwmpDb{}
+	if err := cwmpDb.InitCwmp(client); err != nil {
+		return err
+	}
+	acs.dbClient = client
+	acs.dbH = cwmpDb
 	log.Println("Connected to database for CWMP ACS")
 	return nil
 }
 
-// initRoutes sets up HTTP routes for CWMP
+// initRoutes sets up HTTP routes for CWMP on the HTTP and HTTPS listeners.
+// In redirect mode CPEs connecting over HTTP are sent to the HTTPS port,
+// files are still served over both.
 func (acs *AcsServer) initRoutes() {
+	acs.server = newAcsHTTPServer(acs.cfg.httpPort, acs.routes(acs.handleCwmpRequest))
+	if acs.cfg.httpMode == HTTPModeRedirect {
+		acs.server.Handler = acs.routes(acs.redirectToTLS)
+	}
+	if acs.cfg.isTlsEnabled {
+		acs.tlsServer = newAcsHTTPServer(acs.cfg.httpsPort, acs.routes(acs.handleCwmpRequest))
+	}
+}
+
+func (acs *AcsServer) routes(cwmpHandler http.HandlerFunc) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", acs.handleCwmpRequest)
-	mux.HandleFunc("/tr069", acs.handleCwmpRequest)
-	mux.HandleFunc("/cwmp", acs.handleCwmpRequest)
-	
-	acs.server = &http.Server{
-		Addr:    ":" + acs.cfg.httpPort,
-		Handler: mux,
+	mux.HandleFunc("/", cwmpHandler)
+	mux.HandleFunc("/tr069", cwmpHandler)
+	mux.HandleFunc("/cwmp", cwmpHandler)
+	if acs.files != nil {
+		mux.HandleFunc(filesPath, acs.files.serveFile)
+	}
+	return mux
+}
+
+func newAcsHTTPServer(port string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         ":" + port,
+		Handler:      handler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 }
 
-// Start starts the ACS server
+// redirectToTLS sends a CPE connecting over HTTP to the HTTPS listener, 307
+// keeps the POST of the CPE
+func (acs *AcsServer) redirectToTLS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	target := "https://" + net.JoinHostPort(host, acs.cfg.httpsPort) + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusTemporaryRedirect)
+}
+
+// Start starts the HTTP and HTTPS listeners of the ACS, it returns when one
+// of them fails or both are stopped
 func (acs *AcsServer) Start() error {
-	log.Printf("Starting TR-069 ACS Server on port %s", acs.cfg.httpPort)
-	
-	if acs.cfg.isTlsEnabled {
-		// Load TLS certificate
+	errs := make(chan error, 2)
+	listeners := 0
+
+	if acs.tlsServer != nil {
 		cert, err := tls.LoadX509KeyPair(acs.cfg.certFile, acs.cfg.keyFile)
 		if err != nil {
 			return fmt.Errorf("failed to load TLS certificate: %w", err)
 		}
-		
-		acs.server.TLSConfig = &tls.Config{
+		acs.tlsServer.TLSConfig = &tls.Config{
 			Certificates: []tls.Certificate{cert},
 		}
-		acs.server.Addr = ":" + acs.cfg.httpsPort
-		return acs.server.ListenAndServeTLS("", "")
+		if err := acs.setClientAuth(acs.tlsServer.TLSConfig); err != nil {
+			return err
+		}
+		log.Printf("Starting TR-069 ACS Server with TLS on port %s", acs.cfg.httpsPort)
+		go func() { errs <- acs.tlsServer.ListenAndServeTLS("", "") }()
+		listeners++
 	}
-	
-	return acs.server.ListenAndServe()
+	if acs.cfg.httpMode != HTTPModeOff {
+		log.Printf("Starting TR-069 ACS Server on port %s (%s)", acs.cfg.httpPort, acs.cfg.httpMode)
+		go func() { errs <- acs.server.ListenAndServe() }()
+		listeners++
+	}
+	if err := acs.startSimulation(); err != nil {
+		return err
+	}
+
+	for i := 0; i < listeners; i++ {
+		if err := <-errs; err != http.ErrServerClosed {
+			return err
+		}
+	}
+	return nil
 }
 
-// Stop gracefully stops the ACS server
+// Stop gracefully stops the ACS server once its sessions are drained
 func (acs *AcsServer) Stop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if acs.bus != nil {
+		defer acs.bus.Close()
+	}
+	if acs.stun != nil {
+		defer acs.stun.Close()
+	}
+	acs.stopSimulation()
+	// The listeners keep serving the open sessions while they drain, the
+	// connections of a session are idle between its requests
+	acs.drainSessions(acs.drainTimeout())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	if acs.tlsServer != nil {
+		if err := acs.tlsServer.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
 	return acs.server.Shutdown(ctx)
 }
 
 // handleCwmpRequest handles incoming CWMP SOAP requests
 func (acs *AcsServer) handleCwmpRequest(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received CWMP request from %s", r.RemoteAddr)
+	logging.Debugf("Received CWMP request from %s", r.RemoteAddr)
 	
-	// Read request body
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Printf("Error reading request body: %v", err)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+	// Stream the request body, bounded by the configured maximum size. Only
+	// its beginning is kept for the trace stream.
+	r.Body = http.MaxBytesReader(w, r.Body, acs.cfg.maxRequestSize)
+	defer r.Body.Close()
+	var body traceBuffer
+	reader := bufio.NewReader(io.TeeReader(r.Body, &body))
+	_, err := reader.Peek(1)
+	empty := err == io.EOF
+	if err != nil && !empty {
+		if !acs.rejectTooLarge(w, err) {
+			log.Printf("Error reading request body: %v", err)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+		}
 		return
 	}
-	defer r.Body.Close()
+
+	// Keep the exchange for the trace stream if the device is traced. The
+	// session is bound during the Inform and unbound at the end of the
+	// session, so look it up on both sides.
+	session := acs.getConnSession(r)
+	tw := &traceWriter{ResponseWriter: w}
+	w = tw
+	defer func() {
+		if current := acs.getConnSession(r); current != nil {
+			session = current
+		}
+		acs.traceExchange(session, body.Bytes(), tw.body.Bytes())
+	}()
 
 	// Set SOAP headers
 	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
@@ -194,34 +426,92 @@ func (acs *AcsServer) handleCwmpRequest(w http.ResponseWriter, r *http.Request)
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Pragma", "no-cache")
 
-	// Handle empty body (HTTP POST without SOAP content)
-	if len(body) == 0 {
-		log.Println("Received empty request body, sending empty response")
-		acs.sendEmptyResponse(w)
+	// Handle empty body (HTTP POST without SOAP content), the CPE is ready
+	// to receive the RPCs queued for it
+	if empty {
+		if acs.rejectSessionless(w, r, session) {
+			return
+		}
+		acs.handleEmptyRequest(w, r)
 		return
 	}
 
 	// Parse SOAP envelope
-	var envelope SOAPEnvelope
-	if err := xml.Unmarshal(body, &envelope); err != nil {
+	req, err := decodeSOAPRequest(reader)
+	if err != nil {
+		if acs.rejectTooLarge(w, err) {
+			return
+		}
 		log.Printf("Error parsing SOAP envelope: %v", err)
-		acs.sendSOAPFault(w, FaultInvalidArguments, "Invalid SOAP envelope")
+		acs.sendSOAPFault(w, cwmpNamespace(defaultCwmpVersion), FaultInvalidArguments, "Invalid SOAP envelope")
+		return
+	}
+	if req.Method != "Inform" && acs.rejectSessionless(w, r, session) {
+		return
+	}
+	if req.Method == "" {
+		acs.handleEmptyRequest(w, r)
 		return
 	}
 
-	// Route to appropriate handler based on SOAP body content
-	response, err := acs.processSOAPRequest(&envelope, r)
+	// Route to the handler of the first body element
+	response, err := acs.processSOAPRequest(req, r)
 	if err != nil {
 		log.Printf("Error processing SOAP request: %v", err)
-		acs.sendSOAPFault(w, FaultInternalError, err.Error())
+		if acs.rejectTooLarge(w, err) {
+			return
+		}
+		if errors.Is(err, errShuttingDown) {
+			w.Header().Set("Retry-After", shutdownRetryAfter)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var limited *informRateLimitError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", limited.retryAfterSeconds())
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		namespace := cwmpNamespace(acs.sessionCwmpVersion(req, acs.getConnSession(r)))
+		var fault *AcsFault
+		if errors.As(err, &fault) {
+			acs.sendSOAPFault(w, namespace, fault.Code, fault.Message)
+			return
+		}
+		acs.sendSOAPFault(w, namespace, FaultInternalError, err.Error())
 		return
 	}
+	if response == nil {
+		// Nothing more to send to the CPE
+		acs.endSession(w, r, acs.getConnSession(r))
+		return
+	}
+	if response.session != nil {
+		session = response.session
+		acs.setSessionCookie(w, r, session)
+	}
+
+	acs.writeEnvelope(w, response)
+}
 
-	// Send response
-	responseXML, err := xml.MarshalIndent(response, "", "  ")
+// rejectTooLarge answers HTTP 413 if err reports a request exceeding the
+// maximum request size
+func (acs *AcsServer) rejectTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	log.Printf("Rejected CWMP request larger than %d bytes", tooLarge.Limit)
+	http.Error(w, "Request Entity Too Large", http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// writeEnvelope sends a SOAP envelope to the CPE
+func (acs *AcsServer) writeEnvelope(w http.ResponseWriter, envelope *SOAPEnvelope) {
+	responseXML, err := xml.MarshalIndent(envelope, "", "  ")
 	if err != nil {
 		log.Printf("Error marshaling response: %v", err)
-		acs.sendSOAPFault(w, FaultInternalError, "Error creating response")
+		acs.sendSOAPFault(w, envelope.CwmpNS, FaultInternalError, "Error creating response")
 		return
 	}
 
@@ -230,157 +520,462 @@ func (acs *AcsServer) handleCwmpRequest(w http.ResponseWriter, r *http.Request)
 	w.Write(responseXML)
 }
 
-// processSOAPRequest processes different types of SOAP requests
-func (acs *AcsServer) processSOAPRequest(envelope *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
-	// Create response envelope
-	response := &SOAPEnvelope{
+// newSOAPEnvelope creates an empty response envelope
+func newSOAPEnvelope() *SOAPEnvelope {
+	return &SOAPEnvelope{
 		SoapNS: "http://schemas.xmlsoap.org/soap/envelope/",
-		CwmpNS: "urn:dslforum-org:cwmp-1-2",
+		CwmpNS: cwmpNamespace(defaultCwmpVersion),
 		XsiNS:  "http://www.w3.org/2001/XMLSchema-instance",
 		XsdNS:  "http://www.w3.org/2001/XMLSchema",
 		Header: &SOAPHeader{},
 		Body:   SOAPBody{},
 	}
+}
 
-	// Extract body content and determine request type
-	bodyBytes, err := xml.Marshal(envelope.Body.Content)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling body content: %w", err)
+// processSOAPRequest dispatches a message of the CPE to its handler, the
+// response echoes the cwmp:ID of the message
+func (acs *AcsServer) processSOAPRequest(req *soapRequest, r *http.Request) (*SOAPEnvelope, error) {
+	handler := lookupSOAPHandler(req)
+	if handler == nil {
+		return nil, &AcsFault{Code: AcsFaultMethodNotSupported, Message: "Method not supported: " + req.Method}
 	}
-
-	// Check for Inform method
-	if strings.Contains(string(bodyBytes), "Inform") {
-		return acs.handleInform(envelope, response, r)
+	session := acs.getConnSession(r)
+	version := acs.sessionCwmpVersion(req, session)
+	if !supportsMethod(version, req.Method) {
+		return nil, &AcsFault{Code: AcsFaultMethodNotSupported, Message: "Method not supported in " + cwmpVersionName(version) + ": " + req.Method}
 	}
 
-	// Check for GetParameterValuesResponse
-	if strings.Contains(string(bodyBytes), "GetParameterValuesResponse") {
-		return acs.handleGetParameterValuesResponse(envelope, response)
+	if session != nil && strings.HasSuffix(req.Method, "Response") {
+		acs.markCommandAnswered(session, nil)
+		acs.completeProvisioningAction(session, nil)
 	}
-
-	// Check for SetParameterValuesResponse
-	if strings.Contains(string(bodyBytes), "SetParameterValuesResponse") {
-		return acs.handleSetParameterValuesResponse(envelope, response)
+	if session != nil && isCpeRequest(req) && session.holdingRequests() {
+		log.Printf("Device %s sent %s while its requests are held", session.DeviceId, req.Method)
+		return nil, &AcsFault{Code: AcsFaultRetryRequest, Message: "Requests are held by the ACS"}
 	}
 
-	// Default: send empty response
-	return response, nil
+	// Answer in the CWMP version the CPE uses
+	response := newSOAPEnvelope()
+	response.CwmpNS = cwmpNamespace(version)
+	response.Header.ID = req.Header.ID
+	return handler(acs, req, response, r)
 }
 
 // handleInform handles CWMP Inform requests
-func (acs *AcsServer) handleInform(envelope *SOAPEnvelope, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
-	log.Println("Processing Inform request")
+func (acs *AcsServer) handleInform(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing Inform request")
 
 	// Parse Inform message
 	var inform Inform
-	bodyBytes, _ := xml.Marshal(envelope.Body.Content)
-	if err := xml.Unmarshal(bodyBytes, &inform); err != nil {
+	if err := req.decode(&inform); err != nil {
 		return nil, fmt.Errorf("error parsing Inform message: %w", err)
 	}
 
 	// Create or update session
-	deviceId := fmt.Sprintf("%s-%s-%s-%s", 
-		inform.DeviceId.Manufacturer,
-		inform.DeviceId.OUI,
-		inform.DeviceId.ProductClass,
-		inform.DeviceId.SerialNumber)
+	deviceId := makeDeviceId(&inform.DeviceId)
 
-	session := acs.getOrCreateSession(deviceId)
-	session.State = SessionStateInform
-	session.LastActivity = time.Now()
+	if acs.draining.Load() {
+		return nil, errShuttingDown
+	}
+	if err := acs.checkInformRate(deviceId, &inform); err != nil {
+		return nil, err
+	}
+	if err := acs.checkClientCert(r, &inform.DeviceId); err != nil {
+		return nil, err
+	}
+	if err := acs.checkDeviceQuota(deviceId, &inform.DeviceId); err != nil {
+		return nil, err
+	}
+	if err := acs.checkSessionLimits(deviceId); err != nil {
+		return nil, err
+	}
+
+	session := acs.startSession(deviceId)
+	version := acs.sessionCwmpVersion(req, nil)
+	session.mutex.Lock()
+	session.CwmpVersion = version
+	session.mutex.Unlock()
+	acs.bindConnSession(r, session)
+	acs.setSessionTrace(session)
+	acs.loadQueuedCommands(session)
 
 	// Log device information
 	log.Printf("Device connected: %s (Events: %v)", deviceId, inform.Event)
 
-	// Store device parameters in database (implementation needed)
-	// acs.storeDeviceParameters(deviceId, inform.ParameterList)
+	acs.registerFirstContact(session, &inform, r.RemoteAddr)
+	acs.trackAddressChange(deviceId, &inform, r.RemoteAddr)
+	acs.trackUDPConnRequest(deviceId, &inform)
+	acs.trackConnRequestInform(deviceId, &inform)
+	acs.enrichDeviceGeo(deviceId, r.RemoteAddr)
+
+	if _, connReqURL := informAddress(&inform, r.RemoteAddr); connReqURL != "" {
+		session.mutex.Lock()
+		session.ConnectionRequestURL = connReqURL
+		session.mutex.Unlock()
+	}
+
+	acs.discoverRPCMethods(session, &inform)
+	acs.discoverDataModel(session, &inform)
+	acs.startDueRefresh(session)
+	acs.recordInformEvents(deviceId, inform.Event)
+	acs.recordValueChanges(deviceId, &inform)
+	acs.storeInform(deviceId, &inform)
+	acs.startInformTransfers(deviceId, &inform)
+	acs.verifyFirmwareJobs(deviceId, &inform)
+	acs.confirmCredentialRotation(deviceId, &inform)
+	acs.startInformDUStates(deviceId, &inform)
+	acs.publishInform(deviceId, &inform)
+	for _, event := range inform.Event {
+		if event.EventCode == EventDiagnosticsComplete {
+			acs.queueDiagnosticsFollowUp(session)
+		}
+	}
+	if acs.isBootstrapSync(&inform) {
+		acs.startBootstrapSync(session, &inform)
+	}
+	acs.startProvisioning(session, &inform)
+	acs.applyPresets(session, &inform)
+	acs.persistSession(session)
 
 	// Create InformResponse
 	informResponse := &InformResponse{
 		MaxEnvelopes: 1,
 	}
+	if supportsSessionTimeout(version) {
+		response.Header.SessionTimeout = acs.cfg.sessionTimeout
+	}
 
 	response.Body.Content = informResponse
-	response.Header.NoMoreRequests = true
+	response.session = session
+	// Held commands loaded for the session are sent before the requests
+	// of the CPE
+	session.applyHoldRequests(response)
 
 	return response, nil
 }
 
 // handleGetParameterValuesResponse handles response from device
-func (acs *AcsServer) handleGetParameterValuesResponse(envelope *SOAPEnvelope, response *SOAPEnvelope) (*SOAPEnvelope, error) {
-	log.Println("Processing GetParameterValuesResponse")
+func (acs *AcsServer) handleGetParameterValuesResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetParameterValuesResponse")
 	
 	// Parse response and store in database
 	var getParamResponse GetParameterValuesResponse
-	bodyBytes, _ := xml.Marshal(envelope.Body.Content)
-	if err := xml.Unmarshal(bodyBytes, &getParamResponse); err != nil {
+	if err := req.decode(&getParamResponse); err != nil {
 		return nil, fmt.Errorf("error parsing GetParameterValuesResponse: %w", err)
 	}
 
-	log.Printf("Received parameters: %v", getParamResponse.ParameterList)
-	
-	response.Header.NoMoreRequests = true
-	return response, nil
+	logging.Debugf("Received parameters: %v", getParamResponse.ParameterList)
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		// Record the history before the handlers below store the new values
+		acs.recordParameterValues(session.DeviceId, getParamResponse.ParameterList, db.ParamChangeSourceGetParameterValues)
+		acs.publishParameters(session.DeviceId, session.currentRequest(), getParamResponse.ParameterList)
+		acs.recordParametersRead(session, getParamResponse.ParameterList)
+		acs.completeDiagnostics(session, getParamResponse.ParameterList)
+		acs.completeSyncStep(session, &getParamResponse, nil)
+		acs.continueRefresh(session, &getParamResponse, nil)
+	}
+	return acs.continueSession(session, response), nil
 }
 
 // handleSetParameterValuesResponse handles response from device
-func (acs *AcsServer) handleSetParameterValuesResponse(envelope *SOAPEnvelope, response *SOAPEnvelope) (*SOAPEnvelope, error) {
-	log.Println("Processing SetParameterValuesResponse")
+func (acs *AcsServer) handleSetParameterValuesResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing SetParameterValuesResponse")
 	
 	var setParamResponse SetParameterValuesResponse
-	bodyBytes, _ := xml.Marshal(envelope.Body.Content)
-	if err := xml.Unmarshal(bodyBytes, &setParamResponse); err != nil {
+	if err := req.decode(&setParamResponse); err != nil {
 		return nil, fmt.Errorf("error parsing SetParameterValuesResponse: %w", err)
 	}
 
-	log.Printf("Set parameter status: %d", setParamResponse.Status)
-	
-	response.Header.NoMoreRequests = true
-	return response, nil
+	session := acs.getConnSession(r)
+	if session != nil {
+		logging.ForRequest(session.currentRequest()).Infof("Set parameter status of device %s: %d", session.DeviceId, setParamResponse.Status)
+		acs.recordParameterResults(session, nil)
+		acs.completeSyncStep(session, &setParamResponse, nil)
+		acs.completeCredentialRotation(session, nil)
+		acs.completePresets(session, nil)
+	} else {
+		log.Printf("Set parameter status: %d", setParamResponse.Status)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleSetParameterAttributesResponse handles response from device
+func (acs *AcsServer) handleSetParameterAttributesResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing SetParameterAttributesResponse")
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		acs.completeSyncStep(session, &SetParameterAttributesResponse{}, nil)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleRPCResponse handles the response to an ACS RPC which needs no
+// processing of its content
+func (acs *AcsServer) handleRPCResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing %s", req.Method)
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		logging.ForRequest(session.currentRequest()).Infof("Received %s from device %s", req.Method, session.DeviceId)
+		acs.completeSyncStep(session, nil, nil)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleCpeFault handles a fault returned by the device for the current RPC
+func (acs *AcsServer) handleCpeFault(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	var soapFault cpeFault
+	if err := req.decode(&soapFault); err != nil {
+		return nil, fmt.Errorf("error parsing SOAP fault: %w", err)
+	}
+	fault := &CWMPFault{FaultCode: FaultInternalError, FaultString: soapFault.FaultString}
+	if soapFault.Detail.Fault != nil {
+		fault = soapFault.Detail.Fault
+	}
+	session := acs.getConnSession(r)
+	if session != nil {
+		rlog := logging.ForRequest(session.currentRequest())
+		rlog.Warnf("Received CPE fault %d from device %s: %s", fault.FaultCode, session.DeviceId, fault.FaultString)
+		for _, f := range fault.SetParameterValuesFault {
+			rlog.Warnf("Device %s failed to set %s: fault %d: %s", session.DeviceId, f.ParameterName, f.FaultCode, f.FaultString)
+		}
+		acs.failCurrentRPC(session, fault)
+	} else {
+		log.Printf("Received CPE fault %d: %s", fault.FaultCode, fault.FaultString)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// failCurrentRPC records the fault of the RPC the device is answering
+func (acs *AcsServer) failCurrentRPC(session *CwmpSession, fault *CWMPFault) {
+	acs.recordParameterResults(session, fault)
+	if acs.markCommandAnswered(session, fault) {
+		// Only the final outcome of a retried command is recorded
+		return
+	}
+	acs.completeSyncStep(session, nil, fault)
+	acs.completeProvisioningAction(session, fault)
+	acs.failObjectRPC(session, fault)
+	acs.failParametersRead(session, fault)
+	acs.failTransfer(session, fault)
+	acs.failDUState(session, fault)
+	acs.completeCredentialRotation(session, fault)
+	acs.continueRefresh(session, nil, fault)
+	acs.completePresets(session, fault)
+}
+
+// continueSession answers a message of the CPE with the next RPC queued for
+// the session. It returns nil if nothing is pending, the session is then
+// ended with an empty response. RPCs the CWMP version of the device does
+// not define are failed without being sent.
+func (acs *AcsServer) continueSession(session *CwmpSession, response *SOAPEnvelope) *SOAPEnvelope {
+	if session == nil {
+		return nil
+	}
+	var rpc interface{}
+	var requestID string
+	for {
+		rpc, requestID = session.nextRPC()
+		if rpc == nil {
+			return nil
+		}
+		method := rpcMethodName(rpc)
+		version := session.cwmpVersion()
+		if supportsMethod(version, method) {
+			break
+		}
+		logging.ForRequest(requestID).Warnf("Device %s uses %s, dropping %s", session.DeviceId, cwmpVersionName(version), method)
+		acs.failCurrentRPC(session, &CWMPFault{
+			FaultCode:   FaultMethodNotSupported,
+			FaultString: method + " requires " + cwmpVersionName(minCwmpVersions[method]),
+		})
+	}
+	acs.persistSession(session)
+	acs.markCommandDelivered(session)
+	logging.ForRequest(requestID).Infof("Sending %T to device %s", rpc, session.DeviceId)
+	response.Body.Content = rpc
+	session.applyHoldRequests(response)
+	return response
+}
+
+// endSession closes the session bound to the CPE connection and sends the
+// empty response telling the CPE that the ACS has nothing more to send
+func (acs *AcsServer) endSession(w http.ResponseWriter, r *http.Request, session *CwmpSession) {
+	if session != nil {
+		session.mutex.Lock()
+		session.State = SessionStateClosed
+		session.closeReason = SessionCloseCompleted
+		session.CurrentRPC = nil
+		session.currentRequestID = ""
+		session.currentCommandID = ""
+		session.LastActivity = time.Now()
+		session.mutex.Unlock()
+		acs.persistSession(session)
+		acs.unbindConnSession(session)
+		acs.verifyCredentialRotations(session)
+		logging.Debugf("Closed session %s of device %s", session.SessionId, session.DeviceId)
+	}
+	acs.sendEmptyResponse(w)
 }
 
-// getOrCreateSession gets existing session or creates new one
-func (acs *AcsServer) getOrCreateSession(deviceId string) *CwmpSession {
+// startSession starts the session opened by an Inform of the device. The
+// RPCs queued while the device was offline are kept for the new session.
+func (acs *AcsServer) startSession(deviceId string) *CwmpSession {
+	// The abandoned session is stored, and the command it awaited retried,
+	// once the locks are released
+	var abandoned *db.CwmpSession
+	var droppedCommand string
+	defer func() {
+		if abandoned != nil {
+			acs.saveSessionRecord(abandoned)
+		}
+		if droppedCommand != "" {
+			acs.finishCommand(deviceId, droppedCommand, errSessionDropped)
+		}
+	}()
 	acs.mutex.Lock()
 	defer acs.mutex.Unlock()
 
-	if session, exists := acs.sessions[deviceId]; exists {
-		return session
+	session, exists := acs.sessions[deviceId]
+	if !exists {
+		session = &CwmpSession{
+			DeviceId:     deviceId,
+			MaxEnvelopes: 1,
+			CwmpVersion:  defaultCwmpVersion,
+			PendingRPCs:  make([]interface{}, 0),
+		}
+		acs.sessions[deviceId] = session
 	}
 
-	session := &CwmpSession{
-		DeviceId:     deviceId,
-		SessionId:    fmt.Sprintf("session-%d", time.Now().Unix()),
-		CreatedTime:  time.Now(),
-		LastActivity: time.Now(),
-		State:        SessionStateNew,
-		MaxEnvelopes: 1,
-		PendingRPCs:  make([]interface{}, 0),
+	now := time.Now()
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if exists && session.State != SessionStateClosed {
+		// A new Inform always starts a new session, the CPE gave up on the
+		// previous one
+		log.Printf("Device %s started a new session, abandoning session %s in state %s", deviceId, session.SessionId, session.State)
+		session.State = SessionStateClosed
+		session.closeReason = SessionCloseAbandoned
+		abandoned = session.record()
+		droppedCommand = session.currentCommandID
 	}
-
-	acs.sessions[deviceId] = session
-	log.Printf("Created new session for device: %s", deviceId)
-	
+	session.SessionId = fmt.Sprintf("session-%d", now.UnixNano())
+	session.CreatedTime = now
+	session.LastActivity = now
+	session.State = SessionStateInform
+	session.closeReason = ""
+	session.CurrentRPC = nil
+	session.currentRequestID = ""
+	session.currentCommandID = ""
+	session.RequestIDs = nil
+	// Queued commands are loaded again from the database
+	session.dropQueuedCommands()
+	for _, rpc := range session.PendingRPCs {
+		if id, ok := session.rpcRequests[rpc]; ok {
+			if n := len(session.RequestIDs); n == 0 || session.RequestIDs[n-1] != id {
+				session.RequestIDs = append(session.RequestIDs, id)
+			}
+		}
+	}
+	log.Printf("Started session %s for device %s with %d queued RPC(s)", session.SessionId, deviceId, len(session.PendingRPCs))
 	return session
 }
 
-// sendEmptyResponse sends an empty SOAP response
+// makeDeviceId builds the device identifier used across OpenUSP from the
+// Inform DeviceId
+func makeDeviceId(id *DeviceIdStruct) string {
+	return fmt.Sprintf("cwmp:%s:%s:%s:%s",
+		id.Manufacturer,
+		id.OUI,
+		id.ProductClass,
+		id.SerialNumber)
+}
+
+// nextRPC dequeues the next RPC to be sent to the device, along with the
+// request ID it serves, and awaits its response
+func (s *CwmpSession) nextRPC() (interface{}, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.LastActivity = time.Now()
+	s.currentRequestID = ""
+	s.currentCommandID = ""
+	if len(s.PendingRPCs) == 0 {
+		s.CurrentRPC = nil
+		s.State = SessionStateActive
+		return nil, ""
+	}
+	rpc := s.PendingRPCs[0]
+	s.PendingRPCs = s.PendingRPCs[1:]
+	s.CurrentRPC = rpc
+	s.State = SessionStateAwaitingResponse
+	if id, ok := s.rpcRequests[rpc]; ok {
+		s.currentRequestID = id
+		delete(s.rpcRequests, rpc)
+	}
+	if id, ok := s.rpcCommands[rpc]; ok {
+		s.currentCommandID = id
+		delete(s.rpcCommands, rpc)
+	}
+	return rpc, s.currentRequestID
+}
+
+// currentRequest returns the request ID of the RPC the device is answering
+func (s *CwmpSession) currentRequest() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.currentRequestID
+}
+
+// lastRequest returns the ID of the latest operator request queued in the
+// session, empty if the session only served ACS initiated RPCs
+func (s *CwmpSession) lastRequest() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.RequestIDs) == 0 {
+		return ""
+	}
+	return s.RequestIDs[len(s.RequestIDs)-1]
+}
+
+// handleEmptyRequest handles the empty POST by which the CPE tells it has
+// nothing more to send: the next queued RPC is sent, or the session is ended
+// with an empty response if nothing is pending
+func (acs *AcsServer) handleEmptyRequest(w http.ResponseWriter, r *http.Request) {
+	session := acs.getConnSession(r)
+	if session != nil {
+		session.mutex.RLock()
+		awaiting, rpc := session.State == SessionStateAwaitingResponse, session.CurrentRPC
+		session.mutex.RUnlock()
+		if awaiting {
+			log.Printf("Device %s sent an empty request instead of the response to %T", session.DeviceId, rpc)
+		}
+		envelope := newSOAPEnvelope()
+		envelope.CwmpNS = cwmpNamespace(session.cwmpVersion())
+		if response := acs.continueSession(session, envelope); response != nil {
+			acs.writeEnvelope(w, response)
+			return
+		}
+	}
+
+	logging.Debugf("Received empty request body, sending empty response")
+	acs.endSession(w, r, session)
+}
+
+// sendEmptyResponse sends the empty HTTP response ending the session
 func (acs *AcsServer) sendEmptyResponse(w http.ResponseWriter) {
-	response := `<?xml version="1.0" encoding="UTF-8"?>
-<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
-	<soap:Header/>
-	<soap:Body/>
-</soap:Envelope>`
-	
+	w.Header().Del("Content-Type")
 	w.WriteHeader(http.StatusNoContent)
-	w.Write([]byte(response))
 }
 
-// sendSOAPFault sends a SOAP fault response
-func (acs *AcsServer) sendSOAPFault(w http.ResponseWriter, faultCode uint32, faultString string) {
+// sendSOAPFault sends a SOAP fault response in the CWMP namespace of the CPE
+func (acs *AcsServer) sendSOAPFault(w http.ResponseWriter, namespace string, faultCode uint32, faultString string) {
 	fault := &SOAPEnvelope{
 		SoapNS: "http://schemas.xmlsoap.org/soap/envelope/",
-		CwmpNS: "urn:dslforum-org:cwmp-1-2",
+		CwmpNS: namespace,
 		Body: SOAPBody{
 			Fault: &SOAPFault{
 				FaultCode:   "Client",
@@ -406,45 +1001,87 @@ func (acs *AcsServer) sendSOAPFault(w http.ResponseWriter, faultCode uint32, fau
 	w.Write(faultXML)
 }
 
-// SendRPC sends an RPC request to a device
+// SendRPC sends an RPC request to a device, the RPC waits in memory for the
+// next session if the device is offline. Use SendCommand for RPCs which
+// must survive a restart of the ACS.
 func (acs *AcsServer) SendRPC(deviceId string, rpc interface{}) error {
+	return acs.queueRPC(deviceId, rpc, "")
+}
+
+// queueRPC queues an RPC in the session of a device, tagged with the ID of
+// the operator request it serves if any
+func (acs *AcsServer) queueRPC(deviceId string, rpc interface{}, requestID string) error {
 	acs.mutex.RLock()
 	session, exists := acs.sessions[deviceId]
 	acs.mutex.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("no active session for device: %s", deviceId)
+		// Hold the RPC in a closed session until the device connects
+		acs.mutex.Lock()
+		if session, exists = acs.sessions[deviceId]; !exists {
+			session = &CwmpSession{
+				DeviceId:     deviceId,
+				MaxEnvelopes: 1,
+				CwmpVersion:  defaultCwmpVersion,
+				State:        SessionStateClosed,
+				PendingRPCs:  make([]interface{}, 0),
+			}
+			acs.sessions[deviceId] = session
+		}
+		acs.mutex.Unlock()
 	}
 
 	session.mutex.Lock()
+	offline := session.State == SessionStateClosed
 	session.PendingRPCs = append(session.PendingRPCs, rpc)
+	if requestID != "" {
+		if session.rpcRequests == nil {
+			session.rpcRequests = map[interface{}]string{}
+		}
+		session.rpcRequests[rpc] = requestID
+		if n := len(session.RequestIDs); n == 0 || session.RequestIDs[n-1] != requestID {
+			session.RequestIDs = append(session.RequestIDs, requestID)
+		}
+	}
 	session.mutex.Unlock()
 
-	log.Printf("Queued RPC for device %s: %T", deviceId, rpc)
+	logging.ForRequest(requestID).Infof("Queued RPC for device %s: %T", deviceId, rpc)
+	if offline {
+		acs.wakeUpDevice(deviceId, requestID)
+	}
 	return nil
 }
 
 // GetParameterValues requests parameter values from a device
 func (acs *AcsServer) GetParameterValues(deviceId string, parameterNames []string) error {
-	rpc := &GetParameterValues{
+	_, err := acs.SendCommand(&acsbus.Command{
+		DeviceID:       deviceId,
+		Method:         acsbus.MethodGetParameterValues,
 		ParameterNames: parameterNames,
-	}
-	return acs.SendRPC(deviceId, rpc)
+		Timestamp:      time.Now(),
+	})
+	return err
 }
 
 // SetParameterValues sets parameter values on a device
 func (acs *AcsServer) SetParameterValues(deviceId string, parameters []ParameterValueStruct, parameterKey string) error {
-	rpc := &SetParameterValues{
-		ParameterList: parameters,
-		ParameterKey:  parameterKey,
-	}
-	return acs.SendRPC(deviceId, rpc)
+	_, err := acs.SendCommand(&acsbus.Command{
+		DeviceID:     deviceId,
+		Method:       acsbus.MethodSetParameterValues,
+		Parameters:   toBusParameters(parameters),
+		ParameterKey: parameterKey,
+		Timestamp:    time.Now(),
+	})
+	return err
 }
 
 // RebootDevice sends a reboot command to a device
 func (acs *AcsServer) RebootDevice(deviceId string, commandKey string) error {
-	rpc := &Reboot{
+	_, err := acs.SendCommand(&acsbus.Command{
+		DeviceID:   deviceId,
+		Method:     acsbus.MethodReboot,
 		CommandKey: commandKey,
-	}
-	return acs.SendRPC(deviceId, rpc)
-}
\ No newline at end of file
+		Timestamp:  time.Now(),
+	})
+	return err
+}
@@ -0,0 +1,316 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// bootstrapSync tracks the RPCs of the BOOTSTRAP synchronization queued in
+// the current session
+type bootstrapSync struct {
+	job      *db.CwmpSyncJob
+	steps    map[interface{}]string // queued RPC to the step it belongs to
+	username string
+	password string
+}
+
+// isBootstrapSync reports whether an Inform starts a BOOTSTRAP synchronization
+func (acs *AcsServer) isBootstrapSync(inform *Inform) bool {
+	if acs.config == nil || !acs.config.Protocols.CWMP.Bootstrap.Enabled {
+		return false
+	}
+	for _, event := range inform.Event {
+		if event.EventCode == EventBootstrap {
+			return true
+		}
+	}
+	return false
+}
+
+// startBootstrapSync queues the full synchronization of a device which
+// informed with "0 BOOTSTRAP": a crawl of its parameter tree, its profile,
+// connection request credentials and notification attributes. A baseline
+// snapshot is taken once the device answered all of them.
+func (acs *AcsServer) startBootstrapSync(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		log.Printf("Skipping bootstrap sync of unregistered device %s", session.DeviceId)
+		return
+	}
+
+	cfg := acs.config.Protocols.CWMP.Bootstrap
+	root := dataModelRoot(inform)
+	sync := &bootstrapSync{
+		job: &db.CwmpSyncJob{
+			DeviceID:  device.ID,
+			Trigger:   EventBootstrap,
+			Status:    db.SyncStatusRunning,
+			RequestID: session.lastRequest(),
+		},
+		steps: map[interface{}]string{},
+	}
+	var rpcs []interface{}
+	addStep := func(name string, rpc interface{}, err error) {
+		step := db.SyncStep{Name: name, Status: db.SyncStatusPending}
+		switch {
+		case err != nil:
+			finishSyncStep(&step, err)
+		case rpc == nil:
+			step.Status = db.SyncStatusSkipped
+		default:
+			sync.steps[rpc] = name
+			rpcs = append(rpcs, rpc)
+		}
+		sync.job.Steps = append(sync.job.Steps, step)
+	}
+
+	addStep(db.SyncStepCrawl, &GetParameterValues{ParameterNames: []string{root}}, nil)
+
+	if device.Profile != "" {
+		rpc, err := acs.profileRPC(device.Profile)
+		if rpc == nil {
+			// Keep the interface nil so that an empty profile is skipped
+			addStep(db.SyncStepProfile, nil, err)
+		} else {
+			addStep(db.SyncStepProfile, rpc, nil)
+		}
+	} else {
+		addStep(db.SyncStepProfile, nil, nil)
+	}
+
+	if cfg.ProvisionCredentials {
+		rpc, err := sync.credentialsRPC(device, root)
+		if rpc == nil {
+			addStep(db.SyncStepCredentials, nil, err)
+		} else {
+			addStep(db.SyncStepCredentials, rpc, nil)
+		}
+	} else {
+		addStep(db.SyncStepCredentials, nil, nil)
+	}
+
+	if len(cfg.NotifyParameters) > 0 {
+		rpc := &SetParameterAttributes{}
+		for _, name := range cfg.NotifyParameters {
+			rpc.ParameterList = append(rpc.ParameterList, SetParameterAttributesStruct{
+				Name:               root + strings.TrimPrefix(name, root),
+				NotificationChange: true,
+				Notification:       NotificationActive,
+			})
+		}
+		addStep(db.SyncStepNotifications, rpc, nil)
+	} else {
+		addStep(db.SyncStepNotifications, nil, nil)
+	}
+
+	sync.job.Steps = append(sync.job.Steps, db.SyncStep{Name: db.SyncStepSnapshot, Status: db.SyncStatusPending})
+
+	session.mutex.Lock()
+	previous := session.bootstrap
+	session.bootstrap = sync
+	session.PendingRPCs = append(session.PendingRPCs, rpcs...)
+	session.mutex.Unlock()
+
+	if previous != nil {
+		acs.abortBootstrapSync(previous, "superseded by a new BOOTSTRAP")
+	}
+	acs.saveSyncJob(sync.job)
+	log.Printf("Started bootstrap sync %s for device %s: %d RPC(s) queued", sync.job.ID, device.ID, len(rpcs))
+}
+
+// credentialsRPC generates new connection request credentials for the device
+// and builds the SetParameterValues provisioning them
+func (s *bootstrapSync) credentialsRPC(device *db.CwmpDevice, root string) (*SetParameterValues, error) {
+	rpc, username, password, err := connRequestCredentialsRPC(device, root, "bootstrap:credentials")
+	if err != nil {
+		return nil, err
+	}
+	s.username, s.password = username, password
+	return rpc, nil
+}
+
+// connRequestCredentialsRPC generates new connection request credentials for
+// the device and builds the SetParameterValues provisioning them
+func connRequestCredentialsRPC(device *db.CwmpDevice, root string, parameterKey string) (*SetParameterValues, string, string, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", "", fmt.Errorf("failed to generate connection request password: %w", err)
+	}
+	username := device.OUI + "-" + device.SerialNumber
+	password := hex.EncodeToString(secret)
+
+	return &SetParameterValues{
+		ParameterList: []ParameterValueStruct{
+			{Name: root + "ManagementServer.ConnectionRequestUsername", Value: username, Type: "xsd:string"},
+			{Name: root + "ManagementServer.ConnectionRequestPassword", Value: password, Type: "xsd:string"},
+		},
+		ParameterKey: parameterKey,
+	}, username, password, nil
+}
+
+// completeSyncStep records the outcome of the sync step the CPE answered,
+// either with the RPC response or with a fault, and finishes the job once
+// all its RPCs have been answered
+func (acs *AcsServer) completeSyncStep(session *CwmpSession, response interface{}, fault *CWMPFault) {
+	session.mutex.Lock()
+	sync := session.bootstrap
+	if sync == nil {
+		session.mutex.Unlock()
+		return
+	}
+	name, ok := sync.steps[session.CurrentRPC]
+	if !ok {
+		session.mutex.Unlock()
+		return
+	}
+	delete(sync.steps, session.CurrentRPC)
+	done := len(sync.steps) == 0
+	if done {
+		session.bootstrap = nil
+	}
+	session.mutex.Unlock()
+
+	var err error
+	if fault != nil {
+		err = fmt.Errorf("CPE fault %d: %s", fault.FaultCode, fault.FaultString)
+	} else {
+		switch name {
+		case db.SyncStepCrawl:
+			if r, ok := response.(*GetParameterValuesResponse); ok {
+				err = acs.dbH.UpsertCwmpParameters(toDbParameters(session.DeviceId, r.ParameterList))
+			}
+		case db.SyncStepCredentials:
+			err = acs.dbH.UpdateCwmpDeviceConnectionCredentials(session.DeviceId, sync.username, sync.password)
+		}
+	}
+	finishSyncStep(sync.job.Step(name), err)
+	if err != nil {
+		log.Printf("Bootstrap sync %s step %s failed for device %s: %v", sync.job.ID, name, session.DeviceId, err)
+	}
+
+	if done {
+		acs.takeBaselineSnapshot(sync.job)
+		acs.finishSyncJob(sync.job)
+	}
+	acs.saveSyncJob(sync.job)
+}
+
+// takeBaselineSnapshot stores the parameters of the device as the baseline
+// of the sync job
+func (acs *AcsServer) takeBaselineSnapshot(job *db.CwmpSyncJob) {
+	step := job.Step(db.SyncStepSnapshot)
+	if crawl := job.Step(db.SyncStepCrawl); crawl.Status != db.SyncStatusComplete {
+		finishSyncStep(step, fmt.Errorf("parameter tree crawl did not complete"))
+		return
+	}
+
+	params, err := acs.dbH.GetCwmpParametersByDeviceID(job.DeviceID)
+	if err != nil {
+		finishSyncStep(step, err)
+		return
+	}
+	snapshot := &db.CwmpParameterSnapshot{
+		DeviceID:   job.DeviceID,
+		JobID:      job.ID,
+		Parameters: make([]db.SnapshotParameter, 0, len(params)),
+	}
+	for _, p := range params {
+		snapshot.Parameters = append(snapshot.Parameters, db.SnapshotParameter{Path: p.Path, Value: p.Value, Type: p.Type})
+	}
+	if err := acs.dbH.InsertCwmpParameterSnapshot(snapshot); err != nil {
+		finishSyncStep(step, err)
+		return
+	}
+	job.SnapshotID = snapshot.ID
+	finishSyncStep(step, nil)
+}
+
+// abortBootstrapSync fails the steps of a sync job which are still pending
+func (acs *AcsServer) abortBootstrapSync(sync *bootstrapSync, reason string) {
+	for i := range sync.job.Steps {
+		if sync.job.Steps[i].Status == db.SyncStatusPending {
+			finishSyncStep(&sync.job.Steps[i], fmt.Errorf("%s", reason))
+		}
+	}
+	acs.finishSyncJob(sync.job)
+	acs.saveSyncJob(sync.job)
+}
+
+// finishSyncJob sets the final status of a sync job from its steps
+func (acs *AcsServer) finishSyncJob(job *db.CwmpSyncJob) {
+	now := time.Now()
+	job.Status = db.SyncStatusComplete
+	for _, step := range job.Steps {
+		if step.Status == db.SyncStatusFailed {
+			job.Status = db.SyncStatusFailed
+		}
+	}
+	job.CompletedAt = &now
+	log.Printf("Bootstrap sync %s for device %s finished with status: %s", job.ID, job.DeviceID, job.Status)
+	acs.emitDeviceEvent(job.DeviceID, DeviceEventSynchronized, map[string]string{"job_id": job.ID, "status": job.Status})
+}
+
+func (acs *AcsServer) saveSyncJob(job *db.CwmpSyncJob) {
+	if err := acs.dbH.UpsertCwmpSyncJob(job); err != nil {
+		log.Printf("Error storing sync job of device %s: %v", job.DeviceID, err)
+	}
+}
+
+// finishSyncStep marks a step complete, or failed if err is set
+func finishSyncStep(step *db.SyncStep, err error) {
+	now := time.Now()
+	step.Status = db.SyncStatusComplete
+	if err != nil {
+		step.Status = db.SyncStatusFailed
+		step.Error = err.Error()
+	}
+	step.CompletedAt = &now
+}
+
+// dataModelRoot returns the root object of the data model reported in an
+// Inform, TR-098 devices use InternetGatewayDevice
+func dataModelRoot(inform *Inform) string {
+	paths := make([]string, 0, len(inform.ParameterList))
+	for _, param := range inform.ParameterList {
+		paths = append(paths, param.Name)
+	}
+	return datamodel.DetectRoot(paths)
+}
+
+// toDbParameters converts the parameters received from a device for storage
+func toDbParameters(deviceId string, params []ParameterValueStruct) []db.CwmpParameter {
+	dbParams := make([]db.CwmpParameter, 0, len(params))
+	for _, p := range params {
+		dbParams = append(dbParams, db.CwmpParameter{
+			DeviceID: deviceId,
+			Path:     p.Name,
+			Value:    p.Value,
+			Type:     p.Type,
+		})
+	}
+	return dbParams
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// connectBus connects the ACS to the message bus shared with the
+// controller, which receives the ACS events and sends RPC commands back
+func (acs *AcsServer) connectBus() error {
+	if acs.config == nil || !acs.config.MessageBus.ACS.Enabled {
+		log.Println("ACS bus is disabled, events are not published")
+		return nil
+	}
+	bus, err := acsbus.Connect(&acs.config.MessageBus, "openusp-cwmpacs")
+	if err != nil {
+		return err
+	}
+	if err := bus.SubscribeCommands(acs.handleBusCommand); err != nil {
+		bus.Close()
+		return err
+	}
+	acs.bus = bus
+	return nil
+}
+
+// handleBusCommand queues the RPC requested by the controller, tagged with
+// the request ID of the operator action
+func (acs *AcsServer) handleBusCommand(cmd *acsbus.Command) {
+	if cmd.Method == acsbus.MethodConnectionRequest {
+		go acs.sendConnectionRequest(cmd.DeviceID, cmd.RequestID)
+		return
+	}
+	if cmd.Method == acsbus.MethodRotateCredentials {
+		acs.startCredentialRotation(cmd.RotationID, cmd.RequestID)
+		return
+	}
+	if _, err := acs.SendCommand(cmd); err != nil {
+		logging.ForRequest(cmd.RequestID).Errorf("Error handling %s command for device %s: %v", cmd.Method, cmd.DeviceID, err)
+	}
+}
+
+// transferRPC builds the Download or Upload of a transfer command
+func (acs *AcsServer) transferRPC(cmd *acsbus.Command) (interface{}, error) {
+	t := cmd.Transfer
+	if t == nil {
+		return nil, fmt.Errorf("%s command without transfer", cmd.Method)
+	}
+	if cmd.Method == acsbus.MethodUpload {
+		return &Upload{
+			CommandKey:   cmd.CommandKey,
+			FileType:     t.FileType,
+			URL:          t.URL,
+			Username:     t.Username,
+			Password:     t.Password,
+			DelaySeconds: t.DelaySeconds,
+		}, nil
+	}
+	// Stored files get a signed URL each time the RPC is built, so that a
+	// command waiting for its device does not carry an expired one
+	downloadURL := t.URL
+	if t.FileID != "" {
+		if acs.files == nil {
+			return nil, fmt.Errorf("file %s cannot be downloaded, the file server is disabled", t.FileID)
+		}
+		downloadURL = acs.files.signedURL(t.FileID)
+	}
+	return &Download{
+		CommandKey:     cmd.CommandKey,
+		FileType:       t.FileType,
+		URL:            downloadURL,
+		Username:       t.Username,
+		Password:       t.Password,
+		FileSize:       t.FileSize,
+		TargetFileName: t.TargetFileName,
+		DelaySeconds:   t.DelaySeconds,
+		SuccessURL:     t.SuccessURL,
+		FailureURL:     t.FailureURL,
+	}, nil
+}
+
+// sendConnectionRequest asks a device to open a session, the outcome is
+// recorded on the device
+func (acs *AcsServer) sendConnectionRequest(deviceId string, requestID string) {
+	if acs.dbH == nil {
+		return
+	}
+	ctx := logging.WithRequestID(context.Background(), requestID)
+	if _, err := SendConnectionRequest(ctx, acs.dbH, acs.connReq, deviceId); err != nil {
+		logging.ForRequest(requestID).Errorf("Error sending connection request to device %s: %v", deviceId, err)
+	}
+}
+
+// publishInform publishes the Inform of a device
+func (acs *AcsServer) publishInform(deviceId string, inform *Inform) {
+	if acs.bus == nil {
+		return
+	}
+	event := &acsbus.Event{
+		Type:     acsbus.EventInform,
+		DeviceID: deviceId,
+		Device: &acsbus.DeviceInfo{
+			Manufacturer: inform.DeviceId.Manufacturer,
+			OUI:          inform.DeviceId.OUI,
+			ProductClass: inform.DeviceId.ProductClass,
+			SerialNumber: inform.DeviceId.SerialNumber,
+		},
+		Parameters: toBusParameters(inform.ParameterList),
+	}
+	for _, e := range inform.Event {
+		event.Events = append(event.Events, e.EventCode)
+	}
+	acs.publishEvent(event)
+}
+
+// publishParameters publishes the parameter values reported by a device in
+// answer to the request requestID
+func (acs *AcsServer) publishParameters(deviceId string, requestID string, params []ParameterValueStruct) {
+	if acs.bus == nil {
+		return
+	}
+	acs.publishEvent(&acsbus.Event{
+		Type:       acsbus.EventParameters,
+		DeviceID:   deviceId,
+		Parameters: toBusParameters(params),
+		RequestID:  requestID,
+	})
+}
+
+func (acs *AcsServer) publishEvent(event *acsbus.Event) {
+	if err := acs.bus.PublishEvent(event); err != nil {
+		logging.ForRequest(event.RequestID).Errorf("Error publishing %s event of device %s: %v", event.Type, event.DeviceID, err)
+	}
+}
+
+func toBusParameters(params []ParameterValueStruct) []acsbus.Parameter {
+	busParams := make([]acsbus.Parameter, 0, len(params))
+	for _, p := range params {
+		busParams = append(busParams, acsbus.Parameter{Name: p.Name, Value: p.Value, Type: p.Type})
+	}
+	return busParams
+}
@@ -0,0 +1,149 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Client certificate modes of the ACS
+const (
+	ClientCertNone     = "none"
+	ClientCertOptional = "optional"
+	ClientCertRequire  = "require"
+)
+
+// Identities a client certificate is issued to, matched against its CN and
+// SANs
+const (
+	CertIdentitySerialNumber             = "serialNumber"
+	CertIdentityOUISerialNumber          = "oui-serialNumber"
+	CertIdentityOUIProductClassSerialNum = "oui-productClass-serialNumber"
+	CertIdentityDeviceId                 = "deviceId"
+)
+
+// checkClientCertConfig validates the client certificate settings
+func checkClientCertConfig(cfg config.ClientCertConfig, tlsEnabled bool) error {
+	switch cfg.Mode {
+	case "", ClientCertNone:
+		return nil
+	case ClientCertOptional, ClientCertRequire:
+	default:
+		return fmt.Errorf("invalid client certificate mode: %s", cfg.Mode)
+	}
+	if !tlsEnabled {
+		return fmt.Errorf("client certificate mode %s requires TLS to be enabled", cfg.Mode)
+	}
+	if cfg.CACertFile == "" {
+		return fmt.Errorf("client certificate mode %s requires a CA certificate file", cfg.Mode)
+	}
+	switch cfg.Identity {
+	case "", CertIdentitySerialNumber, CertIdentityOUISerialNumber, CertIdentityOUIProductClassSerialNum, CertIdentityDeviceId:
+		return nil
+	}
+	return fmt.Errorf("invalid client certificate identity: %s", cfg.Identity)
+}
+
+// setClientAuth makes the TLS server request the certificate of CPEs and
+// verify it against the configured CA bundle
+func (acs *AcsServer) setClientAuth(tlsConfig *tls.Config) error {
+	cfg := acs.config.Protocols.CWMP.ClientCert
+	switch cfg.Mode {
+	case ClientCertOptional:
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientCertRequire:
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil
+	}
+
+	bundle, err := os.ReadFile(cfg.CACertFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bundle) {
+		return fmt.Errorf("no certificate found in client CA file %s", cfg.CACertFile)
+	}
+	tlsConfig.ClientCAs = pool
+	log.Printf("Client certificates %s, verified against %s", cfg.Mode, cfg.CACertFile)
+	return nil
+}
+
+// checkClientCert rejects the Inform of a CPE whose client certificate was
+// issued to another device. The TLS handshake already verified the
+// certificate chain.
+func (acs *AcsServer) checkClientCert(r *http.Request, id *DeviceIdStruct) error {
+	if acs.config == nil {
+		return nil
+	}
+	cfg := acs.config.Protocols.CWMP.ClientCert
+	if cfg.Mode != ClientCertOptional && cfg.Mode != ClientCertRequire {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		if cfg.Mode == ClientCertRequire {
+			log.Printf("Rejecting Inform of device %s without a client certificate", makeDeviceId(id))
+			return &AcsFault{Code: AcsFaultRequestDenied, Message: "Client certificate required"}
+		}
+		return nil
+	}
+
+	cert := r.TLS.PeerCertificates[0]
+	identity := certIdentity(cfg.Identity, id)
+	for _, name := range certNames(cert) {
+		if strings.EqualFold(name, identity) {
+			return nil
+		}
+	}
+	log.Printf("Rejecting Inform of device %s, client certificate %q is not issued to %s",
+		makeDeviceId(id), cert.Subject.CommonName, identity)
+	return &AcsFault{Code: AcsFaultRequestDenied, Message: "Client certificate does not match the device"}
+}
+
+// certIdentity returns the name a certificate of the device must carry
+func certIdentity(kind string, id *DeviceIdStruct) string {
+	switch kind {
+	case CertIdentitySerialNumber:
+		return id.SerialNumber
+	case CertIdentityOUIProductClassSerialNum:
+		return id.OUI + "-" + id.ProductClass + "-" + id.SerialNumber
+	case CertIdentityDeviceId:
+		return makeDeviceId(id)
+	default:
+		return id.OUI + "-" + id.SerialNumber
+	}
+}
+
+// certNames returns the CN and the DNS and URI SANs of a certificate
+func certNames(cert *x509.Certificate) []string {
+	names := []string{}
+	if cert.Subject.CommonName != "" {
+		names = append(names, cert.Subject.CommonName)
+	}
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
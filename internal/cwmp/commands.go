@@ -0,0 +1,463 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// defaultCommandTTL is how long a command waits for its device when neither
+// the command nor the configuration set a TTL
+const defaultCommandTTL = 24 * time.Hour
+
+// commandExpiryInterval is how often queued commands are checked for expiry
+const commandExpiryInterval = time.Minute
+
+// Defaults of the retries of failed commands
+const (
+	defaultRPCMaxAttempts = 3
+	defaultRPCBackoff     = 30 * time.Second
+	defaultRPCMaxBackoff  = 10 * time.Minute
+)
+
+// errSessionDropped is the fault of an RPC the device did not answer before
+// its session expired or was abandoned, such RPCs are always retried
+var errSessionDropped = &CWMPFault{
+	FaultCode:   FaultInternalError,
+	FaultString: "session dropped before the device answered",
+}
+
+// commandRPC builds the RPC requested by a bus command
+func (acs *AcsServer) commandRPC(cmd *acsbus.Command) (interface{}, error) {
+	switch cmd.Method {
+	case acsbus.MethodGetParameterValues:
+		return &GetParameterValues{ParameterNames: cmd.ParameterNames}, nil
+	case acsbus.MethodSetParameterValues:
+		params := make([]ParameterValueStruct, 0, len(cmd.Parameters))
+		for _, p := range cmd.Parameters {
+			params = append(params, ParameterValueStruct{Name: p.Name, Value: p.Value, Type: p.Type})
+		}
+		return &SetParameterValues{ParameterList: params, ParameterKey: cmd.ParameterKey}, nil
+	case acsbus.MethodReboot:
+		return &Reboot{CommandKey: cmd.CommandKey}, nil
+	case acsbus.MethodFactoryReset:
+		return &FactoryReset{}, nil
+	case acsbus.MethodGetRPCMethods:
+		return &GetRPCMethods{}, nil
+	case acsbus.MethodGetParameterNames:
+		return &GetParameterNames{ParameterPath: cmd.ParameterPath, NextLevel: cmd.NextLevel}, nil
+	case acsbus.MethodAddObject:
+		return &AddObject{ObjectName: cmd.ObjectName, ParameterKey: cmd.ParameterKey}, nil
+	case acsbus.MethodDeleteObject:
+		return &DeleteObject{ObjectName: cmd.ObjectName, ParameterKey: cmd.ParameterKey}, nil
+	case acsbus.MethodDownload, acsbus.MethodUpload:
+		return acs.transferRPC(cmd)
+	case acsbus.MethodGetQueuedTransfers:
+		return &GetQueuedTransfers{}, nil
+	case acsbus.MethodGetAllQueuedTransfers:
+		return &GetAllQueuedTransfers{}, nil
+	case acsbus.MethodChangeDUState:
+		return changeDUStateRPC(cmd)
+	}
+	return nil, fmt.Errorf("unsupported method %s", cmd.Method)
+}
+
+// SendCommand stores a command in the outbound queue of its device. It is
+// sent right away if the device has a session open, otherwise the device is
+// asked to connect, at most once per connRequestInterval, and the command
+// waits for its next session until its TTL elapses.
+func (acs *AcsServer) SendCommand(cmd *acsbus.Command) (*db.CwmpCommand, error) {
+	rpc, err := acs.commandRPC(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if acs.dbH == nil {
+		return nil, acs.queueRPC(cmd.DeviceID, rpc, cmd.RequestID)
+	}
+
+	request, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	record := &db.CwmpCommand{
+		DeviceID:  cmd.DeviceID,
+		Method:    cmd.Method,
+		RequestID: cmd.RequestID,
+		Request:   string(request),
+		ExpiresAt: time.Now().Add(acs.commandTTL(cmd)),
+	}
+	if err := acs.dbH.InsertCwmpCommand(record); err != nil {
+		return nil, fmt.Errorf("failed to queue %s command: %w", cmd.Method, err)
+	}
+
+	rlog := logging.ForRequest(cmd.RequestID)
+	if acs.deliverCommand(cmd.DeviceID, record.ID, rpc, cmd.RequestID, cmd.HoldRequests) {
+		rlog.Infof("Queued %s command %s in the open session of device %s", cmd.Method, record.ID, cmd.DeviceID)
+		return record, nil
+	}
+	rlog.Infof("Queued %s command %s for offline device %s until %s", cmd.Method, record.ID, cmd.DeviceID, record.ExpiresAt.Format(time.RFC3339))
+	acs.wakeUpDevice(cmd.DeviceID, cmd.RequestID)
+	return record, nil
+}
+
+// wakeUpDevice sends a connection request to an offline device a command was
+// queued for. Commands queued earlier may be waiting for a connection request
+// the device did not answer, so every command asks the device to connect,
+// but at most once per connRequestInterval. It reports whether a connection
+// request was sent.
+func (acs *AcsServer) wakeUpDevice(deviceId string, requestID string) bool {
+	now := time.Now()
+	acs.connRequestsMu.Lock()
+	if last, ok := acs.connRequests[deviceId]; ok && now.Sub(last) < connRequestInterval {
+		acs.connRequestsMu.Unlock()
+		return false
+	}
+	if acs.connRequests == nil {
+		acs.connRequests = map[string]time.Time{}
+	}
+	// Forget the devices which may be asked again
+	for id, last := range acs.connRequests {
+		if now.Sub(last) >= connRequestInterval {
+			delete(acs.connRequests, id)
+		}
+	}
+	acs.connRequests[deviceId] = now
+	acs.connRequestsMu.Unlock()
+
+	go acs.sendConnectionRequest(deviceId, requestID)
+	return true
+}
+
+// commandTTL returns how long a command may wait for its device
+func (acs *AcsServer) commandTTL(cmd *acsbus.Command) time.Duration {
+	if cmd.TTL > 0 {
+		return time.Duration(cmd.TTL) * time.Second
+	}
+	if acs.config != nil && acs.config.Protocols.CWMP.CommandTTL > 0 {
+		return acs.config.Protocols.CWMP.CommandTTL
+	}
+	return defaultCommandTTL
+}
+
+// deliverCommand appends a command to the session of its device if one is
+// open, it reports false if the device is offline
+func (acs *AcsServer) deliverCommand(deviceId string, commandID string, rpc interface{}, requestID string, hold bool) bool {
+	acs.mutex.RLock()
+	session, exists := acs.sessions[deviceId]
+	acs.mutex.RUnlock()
+	if !exists {
+		return false
+	}
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if session.State == SessionStateClosed {
+		return false
+	}
+	session.queueCommand(commandID, rpc, requestID, hold)
+	return true
+}
+
+// loadQueuedCommands queues the commands which waited for the device in the
+// session it just opened
+func (acs *AcsServer) loadQueuedCommands(session *CwmpSession) {
+	if acs.dbH == nil {
+		return
+	}
+	cmds, err := acs.dbH.GetQueuedCwmpCommands(session.DeviceId)
+	if err != nil {
+		log.Printf("Error loading queued commands of device %s: %v", session.DeviceId, err)
+		return
+	}
+
+	loaded := 0
+	for _, c := range cmds {
+		var cmd acsbus.Command
+		if err := json.Unmarshal([]byte(c.Request), &cmd); err != nil {
+			log.Printf("Skipping invalid command %s of device %s: %v", c.ID, session.DeviceId, err)
+			continue
+		}
+		rpc, err := acs.commandRPC(&cmd)
+		if err != nil {
+			log.Printf("Skipping command %s of device %s: %v", c.ID, session.DeviceId, err)
+			continue
+		}
+		session.mutex.Lock()
+		if session.queueCommand(c.ID, rpc, c.RequestID, cmd.HoldRequests) {
+			loaded++
+		}
+		session.mutex.Unlock()
+	}
+	if loaded > 0 {
+		log.Printf("Loaded %d queued command(s) in session %s of device %s", loaded, session.SessionId, session.DeviceId)
+	}
+}
+
+// queueCommand appends the RPC of a queued command to the session, unless
+// the command is queued already. The requests of the CPE are held until a
+// held RPC is answered. The session mutex must be held.
+func (s *CwmpSession) queueCommand(commandID string, rpc interface{}, requestID string, hold bool) bool {
+	for _, id := range s.rpcCommands {
+		if id == commandID {
+			return false
+		}
+	}
+	if s.rpcCommands == nil {
+		s.rpcCommands = map[interface{}]string{}
+	}
+	s.rpcCommands[rpc] = commandID
+	s.PendingRPCs = append(s.PendingRPCs, rpc)
+	if hold {
+		if s.heldRPCs == nil {
+			s.heldRPCs = map[interface{}]bool{}
+		}
+		s.heldRPCs[rpc] = true
+	}
+	if requestID != "" {
+		if s.rpcRequests == nil {
+			s.rpcRequests = map[interface{}]string{}
+		}
+		s.rpcRequests[rpc] = requestID
+		if n := len(s.RequestIDs); n == 0 || s.RequestIDs[n-1] != requestID {
+			s.RequestIDs = append(s.RequestIDs, requestID)
+		}
+	}
+	return true
+}
+
+// dropQueuedCommands removes the RPCs of queued commands from the session,
+// they stay queued in the database and are loaded again by the next
+// session. The session mutex must be held.
+func (s *CwmpSession) dropQueuedCommands() {
+	if len(s.rpcCommands) == 0 {
+		return
+	}
+	pending := s.PendingRPCs[:0]
+	for _, rpc := range s.PendingRPCs {
+		if _, ok := s.rpcCommands[rpc]; ok {
+			delete(s.rpcRequests, rpc)
+			continue
+		}
+		pending = append(pending, rpc)
+	}
+	s.PendingRPCs = pending
+	s.rpcCommands = nil
+	s.heldRPCs = nil
+}
+
+// currentCommand returns the ID of the queued command the device is
+// answering, empty if the current RPC was not queued as a command
+func (s *CwmpSession) currentCommand() string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.currentCommandID
+}
+
+// markCommandDelivered records that the current RPC of the session was sent
+func (acs *AcsServer) markCommandDelivered(session *CwmpSession) {
+	id := session.currentCommand()
+	if id == "" || acs.dbH == nil {
+		return
+	}
+	if err := acs.dbH.MarkCwmpCommandDelivered(id); err != nil {
+		log.Printf("Error updating command %s of device %s: %v", id, session.DeviceId, err)
+	}
+}
+
+// markCommandAnswered records the answer of the device to the current RPC
+// of the session, fault is set if the device answered with a fault. It
+// reports true if the command is retried, the fault is then not final.
+func (acs *AcsServer) markCommandAnswered(session *CwmpSession, fault *CWMPFault) bool {
+	return acs.finishCommand(session.DeviceId, session.currentCommand(), fault)
+}
+
+// recordParameterResults stores the outcome of each parameter of the
+// SetParameterValues command the device answered. The parameters without a
+// SetParameterValuesFault are reported as applied, a fault which does not
+// detail the parameters leaves them unreported.
+func (acs *AcsServer) recordParameterResults(session *CwmpSession, fault *CWMPFault) {
+	id := session.currentCommand()
+	if id == "" || acs.dbH == nil {
+		return
+	}
+	if fault != nil && len(fault.SetParameterValuesFault) == 0 {
+		return
+	}
+	session.mutex.RLock()
+	rpc, ok := session.CurrentRPC.(*SetParameterValues)
+	session.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	failed := map[string]SetParameterValuesFault{}
+	if fault != nil {
+		for _, f := range fault.SetParameterValuesFault {
+			failed[f.ParameterName] = f
+		}
+	}
+	results := make([]db.ParameterResult, 0, len(rpc.ParameterList))
+	for _, p := range rpc.ParameterList {
+		result := db.ParameterResult{Path: p.Name, Status: db.ParameterStatusApplied}
+		if f, ok := failed[p.Name]; ok {
+			result.Status = db.ParameterStatusFailed
+			result.FaultCode = strconv.FormatUint(uint64(f.FaultCode), 10)
+			result.FaultString = f.FaultString
+		}
+		results = append(results, result)
+	}
+	if err := acs.dbH.SetCwmpCommandParameterResults(id, results); err != nil {
+		log.Printf("Error storing parameter results of command %s of device %s: %v", id, session.DeviceId, err)
+	}
+}
+
+// finishCommand records the outcome of a delivered command. A command which
+// failed with a retryable fault is queued again with exponential backoff
+// until it runs out of attempts.
+func (acs *AcsServer) finishCommand(deviceId string, id string, fault *CWMPFault) bool {
+	if id == "" || acs.dbH == nil {
+		return false
+	}
+	if fault == nil {
+		if err := acs.dbH.MarkCwmpCommandAnswered(id, "", ""); err != nil {
+			log.Printf("Error updating command %s of device %s: %v", id, deviceId, err)
+		}
+		return false
+	}
+
+	faultCode := strconv.FormatUint(uint64(fault.FaultCode), 10)
+	if !acs.isRetryableFault(fault) {
+		if err := acs.dbH.MarkCwmpCommandAnswered(id, faultCode, fault.FaultString); err != nil {
+			log.Printf("Error updating command %s of device %s: %v", id, deviceId, err)
+		}
+		return false
+	}
+	cmd, err := acs.dbH.GetCwmpCommand(id)
+	if err != nil {
+		log.Printf("Error loading command %s of device %s: %v", id, deviceId, err)
+		return false
+	}
+
+	rlog := logging.ForRequest(cmd.RequestID)
+	maxAttempts, backoff, maxBackoff := acs.rpcRetryPolicy()
+	attempt := db.CommandAttempt{
+		Attempt:     len(cmd.Attempts) + 1,
+		FaultCode:   faultCode,
+		FaultString: fault.FaultString,
+		At:          time.Now(),
+	}
+	if attempt.Attempt >= maxAttempts {
+		if err := acs.dbH.FailCwmpCommand(id, attempt); err != nil {
+			rlog.Errorf("Error failing command %s of device %s: %v", id, deviceId, err)
+		}
+		rlog.Warnf("%s command %s of device %s failed after %d attempt(s): %s", cmd.Method, id, deviceId, attempt.Attempt, fault.FaultString)
+		return false
+	}
+
+	delay := backoff << (attempt.Attempt - 1)
+	if delay <= 0 || delay > maxBackoff {
+		delay = maxBackoff
+	}
+	if err := acs.dbH.RetryCwmpCommand(id, attempt, attempt.At.Add(delay)); err != nil {
+		rlog.Errorf("Error requeueing command %s of device %s: %v", id, deviceId, err)
+		return false
+	}
+	rlog.Infof("Retrying %s command %s of device %s in %s, attempt %d failed: %s", cmd.Method, id, deviceId, delay, attempt.Attempt, fault.FaultString)
+	time.AfterFunc(delay, func() { acs.redeliverCommand(id) })
+	return true
+}
+
+// isRetryableFault reports whether a command failed with fault is retried
+func (acs *AcsServer) isRetryableFault(fault *CWMPFault) bool {
+	if fault == errSessionDropped {
+		return true
+	}
+	if acs.config == nil {
+		return false
+	}
+	for _, code := range acs.config.Protocols.CWMP.RPCRetry.FaultCodes {
+		if fault.FaultCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// rpcRetryPolicy returns the maximum attempts and backoff of failed commands
+func (acs *AcsServer) rpcRetryPolicy() (int, time.Duration, time.Duration) {
+	maxAttempts, backoff, maxBackoff := defaultRPCMaxAttempts, defaultRPCBackoff, defaultRPCMaxBackoff
+	if acs.config != nil {
+		cfg := acs.config.Protocols.CWMP.RPCRetry
+		if cfg.MaxAttempts > 0 {
+			maxAttempts = cfg.MaxAttempts
+		}
+		if cfg.Backoff > 0 {
+			backoff = cfg.Backoff
+		}
+		if cfg.MaxBackoff > 0 {
+			maxBackoff = cfg.MaxBackoff
+		}
+	}
+	return maxAttempts, backoff, maxBackoff
+}
+
+// redeliverCommand sends a retried command once its backoff elapsed, in the
+// open session of its device or after a connection request
+func (acs *AcsServer) redeliverCommand(id string) {
+	c, err := acs.dbH.GetCwmpCommand(id)
+	if err != nil || c.Status != db.CommandStatusQueued {
+		return
+	}
+	var cmd acsbus.Command
+	if err := json.Unmarshal([]byte(c.Request), &cmd); err != nil {
+		log.Printf("Skipping invalid command %s of device %s: %v", c.ID, c.DeviceID, err)
+		return
+	}
+	rpc, err := acs.commandRPC(&cmd)
+	if err != nil {
+		log.Printf("Skipping command %s of device %s: %v", c.ID, c.DeviceID, err)
+		return
+	}
+	if acs.deliverCommand(c.DeviceID, c.ID, rpc, c.RequestID, cmd.HoldRequests) {
+		logging.ForRequest(c.RequestID).Infof("Queued retry of %s command %s in the open session of device %s", c.Method, c.ID, c.DeviceID)
+		return
+	}
+	acs.sendConnectionRequest(c.DeviceID, c.RequestID)
+}
+
+// expireCommands periodically expires the queued commands whose TTL elapsed
+func (acs *AcsServer) expireCommands() {
+	ticker := time.NewTicker(commandExpiryInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := acs.dbH.ExpireCwmpCommands()
+		if err != nil {
+			log.Printf("Error expiring queued commands: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Expired %d queued command(s)", n)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+)
+
+// TestSendCommandWakesUpDevice checks that a command queued for an offline
+// device which already has queued commands asks it to connect, at most once
+// per connRequestInterval
+func TestSendCommandWakesUpDevice(t *testing.T) {
+	const deviceId = "00D09E-Router-SN1"
+
+	tests := []struct {
+		name string
+		// lastRequest is how long ago the last connection request was
+		// sent to the device, 0 if none was
+		lastRequest time.Duration
+		state       SessionState
+		wantRequest bool
+	}{
+		{name: "no connection request sent yet", state: SessionStateClosed, wantRequest: true},
+		{name: "earlier connection request unanswered", lastRequest: 2 * connRequestInterval, state: SessionStateClosed, wantRequest: true},
+		{name: "connection request just sent", lastRequest: time.Second, state: SessionStateClosed},
+		{name: "session open", state: SessionStateActive},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queued := &GetParameterValues{ParameterNames: []string{"Device.DeviceInfo."}}
+			acs := &AcsServer{sessions: map[string]*CwmpSession{
+				deviceId: {
+					DeviceId:    deviceId,
+					State:       tt.state,
+					PendingRPCs: []interface{}{queued},
+				},
+			}}
+			var last time.Time
+			if tt.lastRequest > 0 {
+				last = time.Now().Add(-tt.lastRequest)
+				acs.connRequests = map[string]time.Time{deviceId: last}
+			}
+
+			_, err := acs.SendCommand(&acsbus.Command{
+				DeviceID:       deviceId,
+				Method:         acsbus.MethodGetParameterValues,
+				ParameterNames: []string{"Device.ManagementServer.PeriodicInformInterval"},
+				RequestID:      "req-1",
+			})
+			if err != nil {
+				t.Fatalf("SendCommand: %v", err)
+			}
+
+			session := acs.sessions[deviceId]
+			if n := len(session.PendingRPCs); n != 2 {
+				t.Errorf("%d pending RPCs, want 2", n)
+			}
+			sent := acs.connRequests[deviceId]
+			if got := !sent.IsZero() && !sent.Equal(last); got != tt.wantRequest {
+				t.Errorf("connection request sent = %v, want %v", got, tt.wantRequest)
+			}
+			if tt.wantRequest && acs.wakeUpDevice(deviceId, "req-2") {
+				t.Error("second connection request sent within connRequestInterval")
+			}
+		})
+	}
+}
@@ -0,0 +1,479 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// Connection request defaults, used when the config leaves them unset
+const (
+	defaultConnReqTimeout = 10 * time.Second
+	defaultConnReqRetries = 2
+	defaultConnReqBackoff = 2 * time.Second
+)
+
+// UDP connection requests are not acknowledged, copies are sent in case
+// datagrams get lost
+const (
+	udpConnReqCopies   = 3
+	udpConnReqInterval = 200 * time.Millisecond
+)
+
+// DeviceEventConnectionRequest is recorded for each connection request sent
+const DeviceEventConnectionRequest = "device.connection_request"
+
+// connReqInformWindow is how long after a connection request an Inform with
+// "6 CONNECTION REQUEST" is taken as its answer
+const connReqInformWindow = 5 * time.Minute
+
+// ErrNoConnectionRequestURL is returned for a device which has not reported
+// its ConnectionRequestURL yet
+var ErrNoConnectionRequestURL = errors.New("no ConnectionRequestURL reported by the device")
+
+// UDPSender sends the datagrams of UDP connection requests
+type UDPSender interface {
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// ConnRequestClient sends TR-069 connection requests, an HTTP GET on the
+// ConnectionRequestURL of the CPE authenticated with Digest or Basic auth,
+// or a TR-111 UDP connection request to CPEs behind a NAT
+type ConnRequestClient struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration
+	udp     UDPSender
+	udpID   atomic.Uint32
+}
+
+// NewConnRequestClient creates a connection request client
+func NewConnRequestClient(cfg config.ConnectionRequestConfig) *ConnRequestClient {
+	c := &ConnRequestClient{
+		client:  &http.Client{Timeout: cfg.Timeout},
+		retries: cfg.Retries,
+		backoff: cfg.Backoff,
+	}
+	if c.client.Timeout <= 0 {
+		c.client.Timeout = defaultConnReqTimeout
+	}
+	// A negative number of retries disables them
+	if c.retries == 0 {
+		c.retries = defaultConnReqRetries
+	} else if c.retries < 0 {
+		c.retries = 0
+	}
+	if c.backoff <= 0 {
+		c.backoff = defaultConnReqBackoff
+	}
+	// The CPE must not be asked to follow redirects
+	c.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return c
+}
+
+// SetUDPSender sets the socket UDP connection requests are sent from, the
+// STUN server through which CPEs opened their NAT binding. Without one
+// they are sent from an ephemeral socket, which only passes full cone NATs.
+func (c *ConnRequestClient) SetUDPSender(sender UDPSender) {
+	c.udp = sender
+}
+
+// SendUDP sends a TR-111 UDP connection request to the host:port address
+// of the CPE. The request is signed with the connection request password
+// and not acknowledged, the outcome is at best sent.
+func (c *ConnRequestClient) SendUDP(ctx context.Context, address string, username string, password string) *db.ConnRequestOutcome {
+	outcome := &db.ConnRequestOutcome{
+		Transport: db.ConnReqTransportUDP,
+		URL:       "udp://" + address,
+		RequestID: logging.RequestID(ctx),
+		SentAt:    time.Now(),
+	}
+	defer func() {
+		outcome.DurationMs = time.Since(outcome.SentAt).Milliseconds()
+	}()
+
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		outcome.Status, outcome.Error = db.ConnReqUnreachable, err.Error()
+		return outcome
+	}
+	if username == "" {
+		outcome.Status, outcome.Error = db.ConnReqAuthFailed, "no connection request credentials for the device"
+		return outcome
+	}
+	msg := c.udpMessage(address, username, password)
+
+	sender := c.udp
+	if sender == nil {
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			outcome.Status, outcome.Error = db.ConnReqFailed, err.Error()
+			return outcome
+		}
+		defer conn.Close()
+		sender = conn
+	}
+	for outcome.Attempts < udpConnReqCopies {
+		if outcome.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+				return outcome
+			case <-time.After(udpConnReqInterval):
+			}
+		}
+		outcome.Attempts++
+		if _, err := sender.WriteToUDP(msg, addr); err != nil {
+			outcome.Status, outcome.Error = db.ConnReqFailed, err.Error()
+			return outcome
+		}
+		outcome.Status = db.ConnReqSent
+	}
+	return outcome
+}
+
+// udpMessage builds the HTTP GET carried by a UDP connection request, with
+// the timestamp, message ID, username, cnonce and HMAC-SHA1 signature of
+// TR-111 section 2.2.1
+func (c *ConnRequestClient) udpMessage(address string, username string, password string) []byte {
+	cnonce := make([]byte, 8)
+	rand.Read(cnonce)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	id := strconv.FormatUint(uint64(c.udpID.Add(1)), 10)
+	cn := hex.EncodeToString(cnonce)
+
+	mac := hmac.New(sha1.New, []byte(password))
+	mac.Write([]byte(ts + id + username + cn))
+	query := url.Values{}
+	query.Set("ts", ts)
+	query.Set("id", id)
+	query.Set("un", username)
+	query.Set("cn", cn)
+	query.Set("sig", hex.EncodeToString(mac.Sum(nil)))
+	return []byte(fmt.Sprintf("GET http://%s?%s HTTP/1.1\r\nHost: %s\r\n\r\n", address, query.Encode(), address))
+}
+
+// Send sends a connection request, retrying timeouts, unreachable CPEs and
+// busy (5xx) answers with exponential backoff. Failed authentication is not
+// retried.
+func (c *ConnRequestClient) Send(ctx context.Context, connReqURL string, username string, password string) *db.ConnRequestOutcome {
+	outcome := &db.ConnRequestOutcome{
+		Transport: db.ConnReqTransportHTTP,
+		URL:       connReqURL,
+		RequestID: logging.RequestID(ctx),
+		SentAt:    time.Now(),
+	}
+	defer func() {
+		outcome.DurationMs = time.Since(outcome.SentAt).Milliseconds()
+	}()
+
+	backoff := c.backoff
+	for {
+		outcome.Attempts++
+		retry := c.attempt(ctx, outcome, username, password)
+		if !retry || outcome.Attempts > c.retries {
+			return outcome
+		}
+		logging.FromContext(ctx).Debugf("Connection request to %s failed (%s), retrying in %v", connReqURL, outcome.Error, backoff)
+		select {
+		case <-ctx.Done():
+			outcome.Status, outcome.Error = connReqStatus(ctx.Err()), ctx.Err().Error()
+			return outcome
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends one connection request, answering an authentication
+// challenge, and reports whether the failure is worth retrying
+func (c *ConnRequestClient) attempt(ctx context.Context, outcome *db.ConnRequestOutcome, username string, password string) bool {
+	res, err := c.get(ctx, outcome.URL, "")
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		auth, authErr := authorization(challenge, outcome.URL, username, password)
+		if authErr != nil {
+			outcome.HTTPStatus = res.StatusCode
+			outcome.Status, outcome.Error = db.ConnReqAuthFailed, authErr.Error()
+			return false
+		}
+		res, err = c.get(ctx, outcome.URL, auth)
+	}
+	if err != nil {
+		outcome.Status, outcome.Error = connReqStatus(err), err.Error()
+		return outcome.Status != db.ConnReqFailed
+	}
+
+	outcome.HTTPStatus = res.StatusCode
+	outcome.Error = ""
+	switch {
+	case res.StatusCode == http.StatusOK || res.StatusCode == http.StatusNoContent:
+		outcome.Status = db.ConnReqDelivered
+		return false
+	case res.StatusCode == http.StatusUnauthorized:
+		outcome.Status, outcome.Error = db.ConnReqAuthFailed, "credentials rejected by the CPE"
+		return false
+	case res.StatusCode >= 500:
+		// 503 is answered by a CPE already in a session
+		outcome.Status, outcome.Error = db.ConnReqFailed, res.Status
+		return true
+	}
+	outcome.Status, outcome.Error = db.ConnReqFailed, res.Status
+	return false
+}
+
+func (c *ConnRequestClient) get(ctx context.Context, connReqURL string, auth string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, connReqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	res.Body.Close()
+	return res, nil
+}
+
+// connReqStatus classifies the error of a connection request
+func connReqStatus(err error) string {
+	var netErr net.Error
+	var urlErr *url.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return db.ConnReqTimeout
+	case errors.As(err, &urlErr):
+		return db.ConnReqUnreachable
+	}
+	return db.ConnReqFailed
+}
+
+// authorization answers a Digest or Basic challenge of the CPE
+func authorization(challenge string, connReqURL string, username string, password string) (string, error) {
+	if username == "" {
+		return "", errors.New("no connection request credentials for the device")
+	}
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "digest":
+		return digestAuthorization(params, connReqURL, username, password)
+	case "basic":
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	}
+	return "", fmt.Errorf("unsupported authentication challenge %q", scheme)
+}
+
+// digestAuthorization computes the RFC 2617 Digest response, MD5 with qop
+// auth or without qop
+func digestAuthorization(params map[string]string, connReqURL string, username string, password string) (string, error) {
+	if alg := params["algorithm"]; alg != "" && !strings.EqualFold(alg, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", alg)
+	}
+	u, err := url.Parse(connReqURL)
+	if err != nil {
+		return "", err
+	}
+	uri := u.RequestURI()
+	realm, nonce := params["realm"], params["nonce"]
+
+	ha1 := md5Hex(username + ":" + realm + ":" + password)
+	ha2 := md5Hex(http.MethodGet + ":" + uri)
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, username),
+		fmt.Sprintf(`realm="%s"`, realm),
+		fmt.Sprintf(`nonce="%s"`, nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+	}
+	qop := ""
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+	if qop != "" {
+		cnonce := make([]byte, 8)
+		rand.Read(cnonce)
+		nc, cn := "00000001", hex.EncodeToString(cnonce)
+		response := md5Hex(ha1 + ":" + nonce + ":" + nc + ":" + cn + ":" + qop + ":" + ha2)
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cn), fmt.Sprintf(`response="%s"`, response))
+	} else {
+		fields = append(fields, fmt.Sprintf(`response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2)))
+	}
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, opaque))
+	}
+	fields = append(fields, "algorithm=MD5")
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// parseChallenge splits a WWW-Authenticate header into its scheme and
+// parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	challenge = strings.TrimSpace(challenge)
+	scheme, rest := challenge, ""
+	if i := strings.IndexByte(challenge, ' '); i >= 0 {
+		scheme, rest = challenge[:i], challenge[i+1:]
+	}
+	params := map[string]string{}
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if end := strings.IndexByte(rest, ','); end >= 0 {
+			value, rest = rest[:end], rest[end+1:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	return scheme, params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// connReqTransport selects UDP connection requests for a device behind a
+// NAT which reported a UDP connection request address, HTTP otherwise
+func connReqTransport(device *db.CwmpDevice) string {
+	if device.UDPConnectionRequestAddress == "" {
+		return db.ConnReqTransportHTTP
+	}
+	if device.NATDetected || device.ConnectionRequestURL == "" || isPrivateURLHost(device.ConnectionRequestURL) {
+		return db.ConnReqTransportUDP
+	}
+	return db.ConnReqTransportHTTP
+}
+
+// cgnatRange is the shared address space of carrier grade NATs
+var cgnatRange = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPrivateURLHost reports whether a URL points to an address which is not
+// reachable from the ACS
+func isPrivateURLHost(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && (ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || cgnatRange.Contains(ip))
+}
+
+// SendConnectionRequest sends a connection request to a registered device
+// with its stored credentials and records the outcome on the device. Devices
+// behind a NAT get a UDP connection request. An error is returned if the
+// request could not be attempted.
+func SendConnectionRequest(ctx context.Context, dbH *db.CwmpDb, client *ConnRequestClient, deviceId string) (*db.ConnRequestOutcome, error) {
+	device, err := dbH.GetCwmpDeviceByID(deviceId)
+	if err != nil {
+		return nil, err
+	}
+	return sendConnectionRequestWith(ctx, dbH, client, device, device.ConnectionRequestUsername, device.ConnectionRequestPassword)
+}
+
+// sendConnectionRequestWith sends a connection request to a device with the
+// given credentials and records the outcome on the device
+func sendConnectionRequestWith(ctx context.Context, dbH *db.CwmpDb, client *ConnRequestClient, device *db.CwmpDevice, username string, password string) (*db.ConnRequestOutcome, error) {
+	deviceId := device.ID
+	var outcome *db.ConnRequestOutcome
+	switch {
+	case connReqTransport(device) == db.ConnReqTransportUDP:
+		outcome = client.SendUDP(ctx, device.UDPConnectionRequestAddress, username, password)
+	case device.ConnectionRequestURL != "":
+		outcome = client.Send(ctx, device.ConnectionRequestURL, username, password)
+	default:
+		return nil, ErrNoConnectionRequestURL
+	}
+	logger := logging.FromContext(ctx)
+	if outcome.Delivered() {
+		logger.Infof("Connection request to device %s delivered after %d attempt(s)", deviceId, outcome.Attempts)
+	} else if outcome.Status == db.ConnReqSent {
+		logger.Infof("UDP connection request sent to device %s at %s", deviceId, device.UDPConnectionRequestAddress)
+	} else {
+		logger.Warnf("Connection request to device %s failed: %s: %s", deviceId, outcome.Status, outcome.Error)
+	}
+
+	if err := dbH.InsertCwmpConnRequest(deviceId, outcome); err != nil {
+		logger.Errorf("Error storing connection request of device %s: %v", deviceId, err)
+	}
+	if err := dbH.UpdateCwmpDeviceConnRequest(deviceId, outcome); err != nil {
+		logger.Errorf("Error storing connection request outcome of device %s: %v", deviceId, err)
+	}
+	event := db.DeviceEvent{
+		EventCode: DeviceEventConnectionRequest,
+		Details:   map[string]string{"status": outcome.Status, "transport": outcome.Transport},
+	}
+	if outcome.ID != "" {
+		event.Details["id"] = outcome.ID
+	}
+	if outcome.RequestID != "" {
+		event.Details["request_id"] = outcome.RequestID
+	}
+	if err := dbH.AddCwmpDeviceEvent(deviceId, event); err != nil {
+		logger.Errorf("Error storing event %s for device %s: %v", event.EventCode, deviceId, err)
+	}
+	return outcome, nil
+}
+
+// trackConnRequestInform marks the connection requests recently sent to the
+// device as answered when it informs with "6 CONNECTION REQUEST"
+func (acs *AcsServer) trackConnRequestInform(deviceId string, inform *Inform) {
+	if acs.dbH == nil || !hasEvent(inform, EventConnectionRequest) {
+		return
+	}
+	now := time.Now()
+	if _, err := acs.dbH.SetCwmpConnRequestsInformed(deviceId, now.Add(-connReqInformWindow), now); err != nil {
+		logging.Errorf("Error tracking connection requests of device %s: %v", deviceId, err)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeviceEventCredentialsRotated is recorded when a credential rotation
+// finishes
+const DeviceEventCredentialsRotated = "device.credentials_rotated"
+
+// Parameter keys of the SetParameterValues pushing the credentials of a
+// rotation and restoring the previous ones
+const (
+	credRotationKeyPrefix = "rotation:"
+	credRollbackKeyPrefix = "rotation-rollback:"
+)
+
+// credRotationVerifyDelay lets the device close its session and apply the
+// new credentials before they are verified
+const credRotationVerifyDelay = 5 * time.Second
+
+// credRotationInformTimeout is how long a UDP verification waits for the
+// device to inform
+const credRotationInformTimeout = 2 * time.Minute
+
+// startCredentialRotation generates new connection request credentials for
+// the device of a requested rotation and queues the SetParameterValues
+// pushing them. The device record keeps the previous credentials until the
+// new ones are verified.
+func (acs *AcsServer) startCredentialRotation(id string, requestID string) {
+	rlog := logging.ForRequest(requestID)
+	if acs.dbH == nil {
+		rlog.Errorf("Cannot rotate credentials without a database")
+		return
+	}
+	rotation, err := acs.dbH.GetCredentialRotation(id)
+	if err != nil {
+		rlog.Errorf("Error loading credential rotation %s: %v", id, err)
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(rotation.DeviceID)
+	if err != nil {
+		acs.failCredentialRotation(rotation, db.CredRotationRequested, err)
+		return
+	}
+
+	rpc, username, password, err := connRequestCredentialsRPC(device, acs.deviceDataModelRoot(device.ID), credRotationKeyPrefix+rotation.ID)
+	if err != nil {
+		acs.failCredentialRotation(rotation, db.CredRotationRequested, err)
+		return
+	}
+	rotation.Status = db.CredRotationPushed
+	rotation.Username, rotation.Password = username, password
+	rotation.PreviousUsername = device.ConnectionRequestUsername
+	rotation.PreviousPassword = device.ConnectionRequestPassword
+	if err := acs.dbH.UpdateCredentialRotation(rotation); err != nil {
+		rlog.Errorf("Error storing credential rotation %s: %v", rotation.ID, err)
+		return
+	}
+
+	cmd := &acsbus.Command{
+		DeviceID:     device.ID,
+		Method:       acsbus.MethodSetParameterValues,
+		Parameters:   toBusParameters(rpc.ParameterList),
+		ParameterKey: rpc.ParameterKey,
+		RequestID:    requestID,
+	}
+	if _, err := acs.SendCommand(cmd); err != nil {
+		acs.failCredentialRotation(rotation, db.CredRotationPushed, err)
+		return
+	}
+	rlog.Infof("Pushing new connection request credentials to device %s, rotation %s", device.ID, rotation.ID)
+}
+
+// completeCredentialRotation records the answer of the device to the
+// SetParameterValues of a rotation. Accepted credentials are verified once
+// the session ends.
+func (acs *AcsServer) completeCredentialRotation(session *CwmpSession, fault *CWMPFault) {
+	session.mutex.RLock()
+	spv, ok := session.CurrentRPC.(*SetParameterValues)
+	session.mutex.RUnlock()
+	if !ok || !strings.HasPrefix(spv.ParameterKey, credRotationKeyPrefix) || acs.dbH == nil {
+		return
+	}
+	id := strings.TrimPrefix(spv.ParameterKey, credRotationKeyPrefix)
+
+	if fault != nil {
+		rotation, err := acs.dbH.GetCredentialRotation(id)
+		if err == nil {
+			acs.failCredentialRotation(rotation, db.CredRotationPushed, fmt.Errorf("CPE fault %d: %s", fault.FaultCode, fault.FaultString))
+		}
+		return
+	}
+	if _, err := acs.dbH.TransitionCredentialRotation(id, db.CredRotationPushed, db.CredRotationApplied, ""); err != nil {
+		logging.ForRequest(session.currentRequest()).Errorf("Error updating credential rotation %s of device %s: %v", id, session.DeviceId, err)
+		return
+	}
+	session.mutex.Lock()
+	session.rotations = append(session.rotations, id)
+	session.mutex.Unlock()
+}
+
+// verifyCredentialRotations verifies the credentials the device accepted
+// in the session which just ended
+func (acs *AcsServer) verifyCredentialRotations(session *CwmpSession) {
+	session.mutex.Lock()
+	rotations := session.rotations
+	session.rotations = nil
+	session.mutex.Unlock()
+
+	for _, id := range rotations {
+		go acs.verifyCredentialRotation(id)
+	}
+}
+
+// verifyCredentialRotation sends a connection request with the new
+// credentials of a rotation. An acknowledged request commits them, a UDP
+// request is confirmed by the Inform it triggers. The rotation is rolled
+// back otherwise.
+func (acs *AcsServer) verifyCredentialRotation(id string) {
+	time.Sleep(credRotationVerifyDelay)
+
+	rotation, err := acs.dbH.GetCredentialRotation(id)
+	if err != nil {
+		logging.Errorf("Error loading credential rotation %s: %v", id, err)
+		return
+	}
+	rlog := logging.ForRequest(rotation.RequestID)
+	device, err := acs.dbH.GetCwmpDeviceByID(rotation.DeviceID)
+	if err != nil {
+		acs.rollbackCredentialRotation(rotation, db.CredRotationApplied, err.Error())
+		return
+	}
+
+	from := db.CredRotationApplied
+	rotation.Transport = connReqTransport(device)
+	if rotation.Transport == db.ConnReqTransportUDP {
+		from = db.CredRotationVerifying
+		rotation.Status = db.CredRotationVerifying
+	}
+	if err := acs.dbH.UpdateCredentialRotation(rotation); err != nil {
+		rlog.Errorf("Error storing credential rotation %s: %v", rotation.ID, err)
+		return
+	}
+
+	ctx := logging.WithRequestID(context.Background(), rotation.RequestID)
+	outcome, err := sendConnectionRequestWith(ctx, acs.dbH, acs.connReq, device, rotation.Username, rotation.Password)
+	switch {
+	case err != nil:
+		acs.rollbackCredentialRotation(rotation, from, err.Error())
+	case outcome.Delivered():
+		acs.commitCredentialRotation(rotation, from)
+	case outcome.Status == db.ConnReqSent:
+		time.AfterFunc(credRotationInformTimeout, func() {
+			acs.rollbackCredentialRotation(rotation, db.CredRotationVerifying, "device did not inform after the UDP connection request")
+		})
+	default:
+		acs.rollbackCredentialRotation(rotation, from, fmt.Sprintf("connection request with the new credentials failed: %s", outcome.Status))
+	}
+}
+
+// confirmCredentialRotation commits the rotation verified with a UDP
+// connection request once the device informs because of it
+func (acs *AcsServer) confirmCredentialRotation(deviceId string, inform *Inform) {
+	if acs.dbH == nil || !hasEvent(inform, EventConnectionRequest) {
+		return
+	}
+	rotation, err := acs.dbH.GetActiveCredentialRotation(deviceId)
+	if err != nil || rotation.Status != db.CredRotationVerifying {
+		return
+	}
+	acs.commitCredentialRotation(rotation, db.CredRotationVerifying)
+}
+
+// commitCredentialRotation stores the verified credentials on the device
+func (acs *AcsServer) commitCredentialRotation(rotation *db.CredentialRotation, from string) {
+	rlog := logging.ForRequest(rotation.RequestID)
+	if _, err := acs.dbH.TransitionCredentialRotation(rotation.ID, from, db.CredRotationCommitted, ""); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			rlog.Errorf("Error committing credential rotation %s: %v", rotation.ID, err)
+		}
+		return
+	}
+	if err := acs.dbH.UpdateCwmpDeviceConnectionCredentials(rotation.DeviceID, rotation.Username, rotation.Password); err != nil {
+		rlog.Errorf("Error storing the credentials of device %s: %v", rotation.DeviceID, err)
+	}
+	rlog.Infof("Committed connection request credentials of device %s, rotation %s", rotation.DeviceID, rotation.ID)
+	acs.emitDeviceEvent(rotation.DeviceID, DeviceEventCredentialsRotated, map[string]string{"rotation_id": rotation.ID, "status": db.CredRotationCommitted})
+}
+
+// rollbackCredentialRotation queues the SetParameterValues restoring the
+// previous credentials of a rotation which could not be verified. The device
+// record still holds them.
+func (acs *AcsServer) rollbackCredentialRotation(rotation *db.CredentialRotation, from string, reason string) {
+	rlog := logging.ForRequest(rotation.RequestID)
+	if rotation.PreviousUsername == "" && rotation.PreviousPassword == "" {
+		acs.failCredentialRotation(rotation, from, fmt.Errorf("%s, no previous credentials to restore", reason))
+		return
+	}
+	if _, err := acs.dbH.TransitionCredentialRotation(rotation.ID, from, db.CredRotationRolledBack, reason); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			rlog.Errorf("Error rolling back credential rotation %s: %v", rotation.ID, err)
+		}
+		return
+	}
+
+	root := acs.deviceDataModelRoot(rotation.DeviceID)
+	cmd := &acsbus.Command{
+		DeviceID: rotation.DeviceID,
+		Method:   acsbus.MethodSetParameterValues,
+		Parameters: []acsbus.Parameter{
+			{Name: root + "ManagementServer.ConnectionRequestUsername", Value: rotation.PreviousUsername, Type: "xsd:string"},
+			{Name: root + "ManagementServer.ConnectionRequestPassword", Value: rotation.PreviousPassword, Type: "xsd:string"},
+		},
+		ParameterKey: credRollbackKeyPrefix + rotation.ID,
+		RequestID:    rotation.RequestID,
+	}
+	if _, err := acs.SendCommand(cmd); err != nil {
+		rlog.Errorf("Error restoring the credentials of device %s: %v", rotation.DeviceID, err)
+	}
+	rlog.Warnf("Rolled back credential rotation %s of device %s: %s", rotation.ID, rotation.DeviceID, reason)
+	acs.emitDeviceEvent(rotation.DeviceID, DeviceEventCredentialsRotated, map[string]string{"rotation_id": rotation.ID, "status": db.CredRotationRolledBack})
+}
+
+// failCredentialRotation fails a rotation, the device keeps its credentials
+func (acs *AcsServer) failCredentialRotation(rotation *db.CredentialRotation, from string, err error) {
+	rlog := logging.ForRequest(rotation.RequestID)
+	if _, tErr := acs.dbH.TransitionCredentialRotation(rotation.ID, from, db.CredRotationFailed, err.Error()); tErr != nil {
+		if !errors.Is(tErr, mongo.ErrNoDocuments) {
+			rlog.Errorf("Error failing credential rotation %s: %v", rotation.ID, tErr)
+		}
+		return
+	}
+	rlog.Warnf("Credential rotation %s of device %s failed: %v", rotation.ID, rotation.DeviceID, err)
+	acs.emitDeviceEvent(rotation.DeviceID, DeviceEventCredentialsRotated, map[string]string{"rotation_id": rotation.ID, "status": db.CredRotationFailed})
+}
+
+// deviceDataModelRoot returns the root object of the data model of a
+// registered device, as recorded from its Informs or else discovered with
+// GetParameterNames
+func (acs *AcsServer) deviceDataModelRoot(deviceId string) string {
+	if device, err := acs.dbH.GetCwmpDeviceByID(deviceId); err == nil && device.DataModelRoot != "" {
+		return device.DataModelRoot
+	}
+	if nodes, err := acs.dbH.GetCwmpDataModel(deviceId, datamodel.RootTR098, true); err == nil && len(nodes) > 0 {
+		return datamodel.RootTR098
+	}
+	return datamodel.RootTR181
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// handleGetParameterNamesResponse stores the data model nodes reported by
+// the device
+func (acs *AcsServer) handleGetParameterNamesResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetParameterNamesResponse")
+
+	var names GetParameterNamesResponse
+	if err := req.decode(&names); err != nil {
+		return nil, fmt.Errorf("error parsing GetParameterNamesResponse: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		root, subtree := "", true
+		session.mutex.RLock()
+		if rpc, ok := session.CurrentRPC.(*GetParameterNames); ok {
+			root, subtree = rpc.ParameterPath, !rpc.NextLevel
+		}
+		session.mutex.RUnlock()
+
+		logging.ForRequest(session.currentRequest()).Infof("Device %s reported %d data model node(s) under %q", session.DeviceId, len(names.ParameterList), root)
+		if acs.dbH != nil {
+			if err := acs.dbH.UpsertCwmpDataModel(session.DeviceId, root, toDataModelNodes(names.ParameterList), subtree); err != nil {
+				log.Printf("Error storing data model of device %s: %v", session.DeviceId, err)
+			}
+		}
+		acs.completeSyncStep(session, &names, nil)
+		acs.continueRefresh(session, &names, nil)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// discoverDataModel records the data model root reported in the Inform of a
+// registered device, and queues a GetParameterNames of the whole data model
+// if it is not known yet or the device informed with BOOTSTRAP
+func (acs *AcsServer) discoverDataModel(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		return
+	}
+	root := dataModelRoot(inform)
+	if device.DataModelRoot != root {
+		log.Printf("Device %s reports the %s data model", session.DeviceId, datamodel.Model(root))
+		if err := acs.dbH.UpdateCwmpDeviceDataModel(session.DeviceId, datamodel.Model(root), root); err != nil {
+			log.Printf("Error storing data model root of device %s: %v", session.DeviceId, err)
+		}
+	}
+	if !hasEvent(inform, EventBootstrap) {
+		nodes, err := acs.dbH.GetCwmpDataModel(session.DeviceId, root, true)
+		if err != nil || len(nodes) > 0 {
+			return
+		}
+	}
+
+	session.mutex.Lock()
+	session.PendingRPCs = append(session.PendingRPCs, &GetParameterNames{ParameterPath: root})
+	session.mutex.Unlock()
+	logging.Debugf("Queued GetParameterNames of %s for device %s", root, session.DeviceId)
+}
+
+// toDataModelNodes converts the parameter names reported by a device for
+// storage, object paths end with a dot
+func toDataModelNodes(params []ParameterInfoStruct) []db.CwmpDataModelNode {
+	nodes := make([]db.CwmpDataModelNode, 0, len(params))
+	for _, p := range params {
+		nodes = append(nodes, db.CwmpDataModelNode{
+			Path:     p.Name,
+			Object:   strings.HasSuffix(p.Name, "."),
+			Writable: p.Writable,
+		})
+	}
+	return nodes
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// DiagnosticsObjects maps diagnostics types to the TR-181 objects holding
+// their results
+var DiagnosticsObjects = map[string]string{
+	"IPPing":              "Device.IP.Diagnostics.IPPing.",
+	"TraceRoute":          "Device.IP.Diagnostics.TraceRoute.",
+	"DownloadDiagnostics": "Device.IP.Diagnostics.DownloadDiagnostics.",
+	"UploadDiagnostics":   "Device.IP.Diagnostics.UploadDiagnostics.",
+	"UDPEchoDiagnostics":  "Device.IP.Diagnostics.UDPEchoDiagnostics.",
+	"NSLookupDiagnostics": "Device.DNS.Diagnostics.NSLookupDiagnostics.",
+}
+
+// diagFollowUp tracks the GetParameterValues issued to collect the results
+// of a diagnostics job within the current session
+type diagFollowUp struct {
+	job *db.CwmpDiagnostic
+	rpc *GetParameterValues
+}
+
+// queueDiagnosticsFollowUp queues a GetParameterValues for the result object
+// of the device's pending diagnostics job, ahead of any other queued RPC
+func (acs *AcsServer) queueDiagnosticsFollowUp(session *CwmpSession) {
+	if acs.dbH == nil {
+		log.Println("Database not connected, skipping diagnostics follow-up")
+		return
+	}
+
+	job, err := acs.dbH.GetPendingCwmpDiagnostic(session.DeviceId)
+	if err != nil {
+		log.Printf("No pending diagnostics job for device %s: %v", session.DeviceId, err)
+		return
+	}
+
+	objPath := job.ObjectPath
+	if objPath == "" {
+		objPath = DiagnosticsObjects[job.DiagnosticType]
+	}
+	if objPath == "" {
+		log.Printf("Unknown diagnostics type %q for job %s", job.DiagnosticType, job.ID)
+		return
+	}
+
+	rpc := &GetParameterValues{ParameterNames: []string{objPath}}
+
+	session.mutex.Lock()
+	session.diagnostic = &diagFollowUp{job: job, rpc: rpc}
+	session.PendingRPCs = append([]interface{}{rpc}, session.PendingRPCs...)
+	session.mutex.Unlock()
+
+	log.Printf("Queued diagnostics follow-up for device %s: %s", session.DeviceId, objPath)
+}
+
+// completeDiagnostics stores the collected results in the diagnostics job if
+// the response belongs to the session's diagnostics follow-up
+func (acs *AcsServer) completeDiagnostics(session *CwmpSession, params []ParameterValueStruct) {
+	session.mutex.Lock()
+	followUp := session.diagnostic
+	if followUp == nil || session.CurrentRPC != followUp.rpc {
+		session.mutex.Unlock()
+		return
+	}
+	session.diagnostic = nil
+	session.mutex.Unlock()
+
+	status := db.DiagnosticStatusComplete
+	results := make(map[string]string)
+	for _, p := range params {
+		results[p.Name] = p.Value
+		if strings.HasSuffix(p.Name, ".DiagnosticsState") && strings.HasPrefix(p.Value, "Error") {
+			status = db.DiagnosticStatusError
+		}
+	}
+
+	if err := acs.dbH.CompleteCwmpDiagnostic(followUp.job.ID, status, results); err != nil {
+		log.Printf("Error completing diagnostics job %s: %v", followUp.job.ID, err)
+		return
+	}
+	if err := acs.dbH.UpsertCwmpParameters(toDbParameters(session.DeviceId, params)); err != nil {
+		log.Printf("Error storing diagnostics results for device %s: %v", session.DeviceId, err)
+	}
+	log.Printf("Diagnostics job %s for device %s completed with status: %s", followUp.job.ID, session.DeviceId, status)
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// soapEnvelopeNS is the namespace of the SOAP 1.1 envelope
+const soapEnvelopeNS = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// soapRequest is a SOAP message received from the CPE, decoded up to the
+// start of the first element of its body. The element is decoded from the
+// request stream by the handler, so large responses are never buffered.
+type soapRequest struct {
+	Header soapRequestHeader
+	// Method is the local name of the first body element, e.g. Inform or
+	// GetParameterValuesResponse, empty for an empty body
+	Method string
+	// Space is the namespace of the first body element
+	Space string
+	d     *xml.Decoder
+	start *xml.StartElement
+}
+
+// soapRequestHeader holds the CWMP header elements sent by the CPE
+type soapRequestHeader struct {
+	ID             string `xml:"ID"`
+	HoldRequests   bool   `xml:"HoldRequests"`
+	NoMoreRequests bool   `xml:"NoMoreRequests"`
+}
+
+// cpeFault is a SOAP fault sent by the CPE in answer to an ACS RPC
+type cpeFault struct {
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+	Detail      struct {
+		Fault *CWMPFault `xml:"Fault"`
+	} `xml:"detail"`
+}
+
+// soapHandler handles a message of the CPE and returns the envelope to
+// answer with
+type soapHandler func(acs *AcsServer, req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error)
+
+// soapHandlers dispatches the messages of the CPE on the name of the first
+// body element. Responses to ACS RPCs without a handler of their own are
+// handled by handleRPCResponse.
+var soapHandlers = map[string]soapHandler{
+	"Inform":                         (*AcsServer).handleInform,
+	"GetRPCMethods":                  (*AcsServer).handleGetRPCMethods,
+	"GetRPCMethodsResponse":          (*AcsServer).handleGetRPCMethodsResponse,
+	"AddObjectResponse":              (*AcsServer).handleAddObjectResponse,
+	"DeleteObjectResponse":           (*AcsServer).handleDeleteObjectResponse,
+	"DownloadResponse":               (*AcsServer).handleDownloadResponse,
+	"UploadResponse":                 (*AcsServer).handleUploadResponse,
+	"TransferComplete":               (*AcsServer).handleTransferComplete,
+	"AutonomousTransferComplete":     (*AcsServer).handleAutonomousTransferComplete,
+	"Kicked":                         (*AcsServer).handleKicked,
+	"RequestDownload":                (*AcsServer).handleRequestDownload,
+	"GetQueuedTransfersResponse":     (*AcsServer).handleGetQueuedTransfersResponse,
+	"GetAllQueuedTransfersResponse":  (*AcsServer).handleGetAllQueuedTransfersResponse,
+	"ChangeDUStateResponse":          (*AcsServer).handleChangeDUStateResponse,
+	"ChangeDUStateComplete":          (*AcsServer).handleChangeDUStateComplete,
+	"GetParameterNamesResponse":      (*AcsServer).handleGetParameterNamesResponse,
+	"GetParameterValuesResponse":     (*AcsServer).handleGetParameterValuesResponse,
+	"SetParameterValuesResponse":     (*AcsServer).handleSetParameterValuesResponse,
+	"SetParameterAttributesResponse": (*AcsServer).handleSetParameterAttributesResponse,
+}
+
+// lookupSOAPHandler returns the handler of a CPE message, nil if the ACS
+// does not support it
+func lookupSOAPHandler(req *soapRequest) soapHandler {
+	if req.Method == "Fault" && req.Space == soapEnvelopeNS {
+		return (*AcsServer).handleCpeFault
+	}
+	if handler, ok := soapHandlers[req.Method]; ok {
+		return handler
+	}
+	if strings.HasSuffix(req.Method, "Response") {
+		return (*AcsServer).handleRPCResponse
+	}
+	return nil
+}
+
+// decodeSOAPRequest reads the CWMP header and stops at the first body element
+// of a SOAP envelope. The element itself is decoded by the handler.
+func decodeSOAPRequest(r io.Reader) (*soapRequest, error) {
+	d := xml.NewDecoder(r)
+	req := &soapRequest{d: d}
+	depth := 0
+	inBody := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch {
+			case depth == 0:
+				if t.Name.Local != "Envelope" {
+					return nil, fmt.Errorf("expected SOAP Envelope, got %s", t.Name.Local)
+				}
+			case depth == 1 && t.Name.Local == "Header":
+				if err := d.DecodeElement(&req.Header, &t); err != nil {
+					return nil, fmt.Errorf("invalid SOAP header: %w", err)
+				}
+				continue
+			case depth == 1 && t.Name.Local == "Body":
+				inBody = true
+			case inBody:
+				req.Method, req.Space = t.Name.Local, t.Name.Space
+				start := t.Copy()
+				req.start = &start
+				return req, nil
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+			if inBody {
+				// Empty body
+				return req, nil
+			}
+		}
+	}
+	return nil, errors.New("SOAP envelope has no body")
+}
+
+// decode unmarshals the first body element into v, it can only be decoded
+// once as it is read from the request stream
+func (req *soapRequest) decode(v interface{}) error {
+	if req.start == nil {
+		return errors.New("empty or already decoded SOAP body")
+	}
+	start := *req.start
+	req.start = nil
+	// The RPC structs name their element with the cwmp prefix they are
+	// marshaled with, the decoder reports the bare local name
+	start.Name = xml.Name{Local: "cwmp:" + start.Name.Local}
+	return req.d.DecodeElement(v, &start)
+}
@@ -0,0 +1,384 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"encoding/xml"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCwmpNS = "urn:dslforum-org:cwmp-1-0"
+
+// testEnvelope wraps a CWMP header and body in a SOAP envelope using the
+// soap and cwmp prefixes
+func testEnvelope(header, body string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>` +
+		`<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `" xmlns:cwmp="` + testCwmpNS + `">` +
+		`<soap:Header>` + header + `</soap:Header>` +
+		`<soap:Body>` + body + `</soap:Body>` +
+		`</soap:Envelope>`
+}
+
+// handlerName returns the function name of a SOAP handler, empty for nil
+func handlerName(h soapHandler) string {
+	if h == nil {
+		return ""
+	}
+	return runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+}
+
+// cwmpName is the element name the RPC structs are decoded with
+func cwmpName(local string) xml.Name {
+	return xml.Name{Local: "cwmp:" + local}
+}
+
+func TestSOAPDispatch(t *testing.T) {
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	complete := start.Add(time.Minute)
+
+	tests := []struct {
+		name     string
+		envelope string
+		header   soapRequestHeader
+		method   string
+		space    string
+		handler  soapHandler
+		// decoded is a pointer to the expected decoded body element, nil
+		// if the body has no element to decode
+		decoded interface{}
+	}{
+		{
+			name: "Inform",
+			envelope: testEnvelope(`<cwmp:ID soap:mustUnderstand="1">1234</cwmp:ID>`,
+				`<cwmp:Inform>`+
+					`<DeviceId><Manufacturer>Acme</Manufacturer><OUI>00D09E</OUI>`+
+					`<ProductClass>Router</ProductClass><SerialNumber>SN1</SerialNumber></DeviceId>`+
+					`<Event><EventStruct><EventCode>1 BOOT</EventCode><CommandKey></CommandKey></EventStruct></Event>`+
+					`<MaxEnvelopes>1</MaxEnvelopes>`+
+					`<CurrentTime>2026-01-02T03:04:05Z</CurrentTime>`+
+					`<RetryCount>0</RetryCount>`+
+					`<ParameterList><ParameterValueStruct><Name>Device.DeviceInfo.SoftwareVersion</Name>`+
+					`<Value>1.0</Value></ParameterValueStruct></ParameterList>`+
+					`</cwmp:Inform>`),
+			header:  soapRequestHeader{ID: "1234"},
+			method:  "Inform",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleInform,
+			decoded: &Inform{
+				XMLName:      cwmpName("Inform"),
+				DeviceId:     DeviceIdStruct{Manufacturer: "Acme", OUI: "00D09E", ProductClass: "Router", SerialNumber: "SN1"},
+				Event:        []EventStruct{{EventCode: EventBoot}},
+				MaxEnvelopes: 1,
+				CurrentTime:  start,
+				ParameterList: []ParameterValueStruct{
+					{Name: "Device.DeviceInfo.SoftwareVersion", Value: "1.0"},
+				},
+			},
+		},
+		{
+			name: "GetParameterValuesResponse",
+			envelope: testEnvelope(`<cwmp:ID soap:mustUnderstand="1">5</cwmp:ID>`,
+				`<cwmp:GetParameterValuesResponse><ParameterList>`+
+					`<ParameterValueStruct><Name>Device.ManagementServer.PeriodicInformInterval</Name><Value>300</Value></ParameterValueStruct>`+
+					`<ParameterValueStruct><Name>Device.ManagementServer.PeriodicInformEnable</Name><Value>true</Value></ParameterValueStruct>`+
+					`</ParameterList></cwmp:GetParameterValuesResponse>`),
+			header:  soapRequestHeader{ID: "5"},
+			method:  "GetParameterValuesResponse",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleGetParameterValuesResponse,
+			decoded: &GetParameterValuesResponse{
+				XMLName: cwmpName("GetParameterValuesResponse"),
+				ParameterList: []ParameterValueStruct{
+					{Name: "Device.ManagementServer.PeriodicInformInterval", Value: "300"},
+					{Name: "Device.ManagementServer.PeriodicInformEnable", Value: "true"},
+				},
+			},
+		},
+		{
+			// GetParameterValues is only sent by the ACS
+			name: "GetParameterValues",
+			envelope: testEnvelope("",
+				`<cwmp:GetParameterValues><ParameterNames><string>Device.</string></ParameterNames></cwmp:GetParameterValues>`),
+			method: "GetParameterValues",
+			space:  testCwmpNS,
+		},
+		{
+			name: "SetParameterValuesResponse",
+			envelope: testEnvelope(`<cwmp:ID soap:mustUnderstand="1">6</cwmp:ID>`,
+				`<cwmp:SetParameterValuesResponse><Status>1</Status></cwmp:SetParameterValuesResponse>`),
+			header:  soapRequestHeader{ID: "6"},
+			method:  "SetParameterValuesResponse",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleSetParameterValuesResponse,
+			decoded: &SetParameterValuesResponse{
+				XMLName: cwmpName("SetParameterValuesResponse"),
+				Status:  1,
+			},
+		},
+		{
+			name:     "GetRPCMethods",
+			envelope: testEnvelope("", `<cwmp:GetRPCMethods/>`),
+			method:   "GetRPCMethods",
+			space:    testCwmpNS,
+			handler:  (*AcsServer).handleGetRPCMethods,
+			decoded:  &GetRPCMethods{XMLName: cwmpName("GetRPCMethods")},
+		},
+		{
+			name: "GetRPCMethodsResponse",
+			envelope: testEnvelope("",
+				`<cwmp:GetRPCMethodsResponse><MethodList>`+
+					`<string>GetRPCMethods</string><string>GetParameterValues</string><string>Reboot</string>`+
+					`</MethodList></cwmp:GetRPCMethodsResponse>`),
+			method:  "GetRPCMethodsResponse",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleGetRPCMethodsResponse,
+			decoded: &GetRPCMethodsResponse{
+				XMLName:    cwmpName("GetRPCMethodsResponse"),
+				MethodList: []string{"GetRPCMethods", "GetParameterValues", "Reboot"},
+			},
+		},
+		{
+			name: "TransferComplete",
+			envelope: testEnvelope("",
+				`<cwmp:TransferComplete><CommandKey>fw-1</CommandKey>`+
+					`<FaultStruct><FaultCode>9010</FaultCode><FaultString>Download failure</FaultString></FaultStruct>`+
+					`<StartTime>2026-01-02T03:04:05Z</StartTime><CompleteTime>2026-01-02T03:05:05Z</CompleteTime>`+
+					`</cwmp:TransferComplete>`),
+			method:  "TransferComplete",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleTransferComplete,
+			decoded: &TransferComplete{
+				XMLName:      cwmpName("TransferComplete"),
+				CommandKey:   "fw-1",
+				FaultStruct:  CWMPFault{FaultCode: 9010, FaultString: "Download failure"},
+				StartTime:    start,
+				CompleteTime: complete,
+			},
+		},
+		{
+			name: "AutonomousTransferComplete",
+			envelope: testEnvelope("",
+				`<cwmp:AutonomousTransferComplete>`+
+					`<AnnounceURL></AnnounceURL><TransferURL>http://files.example.com/fw.bin</TransferURL>`+
+					`<IsDownload>true</IsDownload><FileType>1 Firmware Upgrade Image</FileType>`+
+					`<FileSize>1024</FileSize><TargetFileName>fw.bin</TargetFileName>`+
+					`<FaultStruct><FaultCode>0</FaultCode><FaultString></FaultString></FaultStruct>`+
+					`<StartTime>2026-01-02T03:04:05Z</StartTime><CompleteTime>2026-01-02T03:05:05Z</CompleteTime>`+
+					`</cwmp:AutonomousTransferComplete>`),
+			method:  "AutonomousTransferComplete",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleAutonomousTransferComplete,
+			decoded: &AutonomousTransferComplete{
+				XMLName:        cwmpName("AutonomousTransferComplete"),
+				TransferURL:    "http://files.example.com/fw.bin",
+				IsDownload:     true,
+				FileType:       "1 Firmware Upgrade Image",
+				FileSize:       1024,
+				TargetFileName: "fw.bin",
+				StartTime:      start,
+				CompleteTime:   complete,
+			},
+		},
+		{
+			name: "RequestDownload",
+			envelope: testEnvelope("",
+				`<cwmp:RequestDownload><FileType>3 Vendor Configuration File</FileType>`+
+					`<FileTypeArg><ArgStruct><Name>Version</Name><Value>2</Value></ArgStruct></FileTypeArg>`+
+					`</cwmp:RequestDownload>`),
+			method:  "RequestDownload",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleRequestDownload,
+			decoded: &RequestDownload{
+				XMLName:     cwmpName("RequestDownload"),
+				FileType:    "3 Vendor Configuration File",
+				FileTypeArg: []ArgStruct{{Name: "Version", Value: "2"}},
+			},
+		},
+		{
+			name: "Fault",
+			envelope: testEnvelope(`<cwmp:ID soap:mustUnderstand="1">7</cwmp:ID>`,
+				`<soap:Fault><faultcode>Client</faultcode><faultstring>CWMP fault</faultstring>`+
+					`<detail><cwmp:Fault><FaultCode>9005</FaultCode><FaultString>Invalid parameter name</FaultString>`+
+					`<SetParameterValuesFault><ParameterName>Device.Foo</ParameterName>`+
+					`<FaultCode>9005</FaultCode><FaultString>Invalid parameter name</FaultString></SetParameterValuesFault>`+
+					`</cwmp:Fault></detail></soap:Fault>`),
+			header:  soapRequestHeader{ID: "7"},
+			method:  "Fault",
+			space:   soapEnvelopeNS,
+			handler: (*AcsServer).handleCpeFault,
+			decoded: func() *cpeFault {
+				f := &cpeFault{FaultCode: "Client", FaultString: "CWMP fault"}
+				f.Detail.Fault = &CWMPFault{
+					FaultCode:   9005,
+					FaultString: "Invalid parameter name",
+					SetParameterValuesFault: []SetParameterValuesFault{
+						{ParameterName: "Device.Foo", FaultCode: 9005, FaultString: "Invalid parameter name"},
+					},
+				}
+				return f
+			}(),
+		},
+		{
+			// Only a SOAP fault is a fault, not an element of the same
+			// name in the CWMP namespace
+			name:     "CWMP Fault element",
+			envelope: testEnvelope("", `<cwmp:Fault><FaultCode>9002</FaultCode></cwmp:Fault>`),
+			method:   "Fault",
+			space:    testCwmpNS,
+		},
+		{
+			name: "Kicked",
+			envelope: testEnvelope("",
+				`<cwmp:Kicked><Command>activate</Command><Referer>http://portal.example.com/</Referer>`+
+					`<Arg>plan=gold</Arg><Next>http://portal.example.com/done</Next></cwmp:Kicked>`),
+			method:  "Kicked",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleKicked,
+			decoded: &Kicked{
+				XMLName: cwmpName("Kicked"),
+				Command: "activate",
+				Referer: "http://portal.example.com/",
+				Arg:     "plan=gold",
+				Next:    "http://portal.example.com/done",
+			},
+		},
+		{
+			name:     "empty body",
+			envelope: testEnvelope(`<cwmp:ID soap:mustUnderstand="1">8</cwmp:ID>`, ""),
+			header:   soapRequestHeader{ID: "8"},
+		},
+		{
+			name: "empty body with whitespace",
+			envelope: `<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `">` +
+				"\n  <soap:Body>\n  </soap:Body>\n</soap:Envelope>",
+		},
+		{
+			name:     "unknown method",
+			envelope: testEnvelope("", `<cwmp:X_ACME_Hello><Name>a</Name></cwmp:X_ACME_Hello>`),
+			method:   "X_ACME_Hello",
+			space:    testCwmpNS,
+		},
+		{
+			// Responses to ACS RPCs without a handler of their own
+			name:     "unknown response",
+			envelope: testEnvelope("", `<cwmp:RebootResponse/>`),
+			method:   "RebootResponse",
+			space:    testCwmpNS,
+			handler:  (*AcsServer).handleRPCResponse,
+			decoded:  &RebootResponse{XMLName: cwmpName("RebootResponse")},
+		},
+		{
+			name: "SOAP-ENV and cwmp-1-2 prefixes",
+			envelope: `<SOAP-ENV:Envelope xmlns:SOAP-ENV="` + soapEnvelopeNS + `" ` +
+				`xmlns:SOAP-ENC="http://schemas.xmlsoap.org/soap/encoding/" xmlns:cwmp12="urn:dslforum-org:cwmp-1-2">` +
+				`<SOAP-ENV:Header><cwmp12:ID SOAP-ENV:mustUnderstand="1">42</cwmp12:ID>` +
+				`<cwmp12:NoMoreRequests>1</cwmp12:NoMoreRequests></SOAP-ENV:Header>` +
+				`<SOAP-ENV:Body><cwmp12:SetParameterValuesResponse><Status>0</Status>` +
+				`</cwmp12:SetParameterValuesResponse></SOAP-ENV:Body></SOAP-ENV:Envelope>`,
+			header:  soapRequestHeader{ID: "42", NoMoreRequests: true},
+			method:  "SetParameterValuesResponse",
+			space:   "urn:dslforum-org:cwmp-1-2",
+			handler: (*AcsServer).handleSetParameterValuesResponse,
+			decoded: &SetParameterValuesResponse{XMLName: cwmpName("SetParameterValuesResponse")},
+		},
+		{
+			name: "default namespace body",
+			envelope: `<s:Envelope xmlns:s="` + soapEnvelopeNS + `">` +
+				`<s:Body><GetRPCMethodsResponse xmlns="` + testCwmpNS + `">` +
+				`<MethodList><string>Inform</string></MethodList>` +
+				`</GetRPCMethodsResponse></s:Body></s:Envelope>`,
+			method:  "GetRPCMethodsResponse",
+			space:   testCwmpNS,
+			handler: (*AcsServer).handleGetRPCMethodsResponse,
+			decoded: &GetRPCMethodsResponse{
+				XMLName:    cwmpName("GetRPCMethodsResponse"),
+				MethodList: []string{"Inform"},
+			},
+		},
+		{
+			name: "SOAP-ENV Fault",
+			envelope: `<SOAP-ENV:Envelope xmlns:SOAP-ENV="` + soapEnvelopeNS + `">` +
+				`<SOAP-ENV:Body><SOAP-ENV:Fault><faultcode>Server</faultcode>` +
+				`<faultstring>Internal error</faultstring></SOAP-ENV:Fault></SOAP-ENV:Body></SOAP-ENV:Envelope>`,
+			method:  "Fault",
+			space:   soapEnvelopeNS,
+			handler: (*AcsServer).handleCpeFault,
+			decoded: &cpeFault{FaultCode: "Server", FaultString: "Internal error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := decodeSOAPRequest(strings.NewReader(tt.envelope))
+			if err != nil {
+				t.Fatalf("decodeSOAPRequest: %v", err)
+			}
+			if req.Header != tt.header {
+				t.Errorf("header = %+v, want %+v", req.Header, tt.header)
+			}
+			if req.Method != tt.method || req.Space != tt.space {
+				t.Errorf("method = %q in %q, want %q in %q", req.Method, req.Space, tt.method, tt.space)
+			}
+			if got, want := handlerName(lookupSOAPHandler(req)), handlerName(tt.handler); got != want {
+				t.Errorf("handler = %q, want %q", got, want)
+			}
+
+			if tt.decoded == nil {
+				if req.Method == "" {
+					var v struct{}
+					if err := req.decode(&v); err == nil {
+						t.Error("decode of an empty body succeeded")
+					}
+				}
+				return
+			}
+			got := reflect.New(reflect.TypeOf(tt.decoded).Elem()).Interface()
+			if err := req.decode(got); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.decoded) {
+				t.Errorf("decoded = %+v, want %+v", got, tt.decoded)
+			}
+			if err := req.decode(got); err == nil {
+				t.Error("second decode succeeded")
+			}
+		})
+	}
+}
+
+func TestDecodeSOAPRequestErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope string
+	}{
+		{name: "empty", envelope: ""},
+		{name: "not an envelope", envelope: `<cwmp:Inform xmlns:cwmp="` + testCwmpNS + `"/>`},
+		{name: "no body", envelope: `<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `"><soap:Header/></soap:Envelope>`},
+		{name: "malformed", envelope: `<soap:Envelope xmlns:soap="` + soapEnvelopeNS + `"><soap:Body`},
+		{name: "invalid header", envelope: testEnvelope(`<cwmp:HoldRequests>maybe</cwmp:HoldRequests>`, `<cwmp:Inform/>`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if req, err := decodeSOAPRequest(strings.NewReader(tt.envelope)); err == nil {
+				t.Errorf("decodeSOAPRequest succeeded with method %q", req.Method)
+			}
+		})
+	}
+}
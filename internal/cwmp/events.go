@@ -0,0 +1,74 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// Device lifecycle events emitted by the ACS
+const (
+	DeviceEventIPChanged    = "device.ip_changed"
+	DeviceEventRegistered   = "device.registered"
+	DeviceEventSynchronized = "device.synchronized"
+)
+
+// emitDeviceEvent records a device lifecycle event against the device
+func (acs *AcsServer) emitDeviceEvent(deviceId string, eventType string, details map[string]string) {
+	log.Printf("Device event %s for %s: %v", eventType, deviceId, details)
+
+	if acs.dbH == nil {
+		return
+	}
+	event := db.DeviceEvent{
+		EventCode: eventType,
+		Details:   details,
+	}
+	if err := acs.dbH.AddCwmpDeviceEvent(deviceId, event); err != nil {
+		log.Printf("Error storing event %s for device %s: %v", eventType, deviceId, err)
+	}
+}
+
+// recordInformEvents stores the TR-069 events carried by an Inform
+func (acs *AcsServer) recordInformEvents(deviceId string, informEvents []EventStruct) {
+	if acs.dbH == nil || len(informEvents) == 0 {
+		return
+	}
+	now := time.Now()
+	events := make([]db.DeviceEvent, 0, len(informEvents))
+	for _, e := range informEvents {
+		events = append(events, db.DeviceEvent{
+			EventCode:  e.EventCode,
+			CommandKey: e.CommandKey,
+			Timestamp:  now,
+		})
+	}
+	if err := acs.dbH.AddCwmpDeviceEvents(deviceId, events); err != nil {
+		log.Printf("Error storing Inform events for device %s: %v", deviceId, err)
+	}
+}
+
+// hasEvent reports whether an Inform carries the given event code
+func hasEvent(inform *Inform, eventCode string) bool {
+	for _, event := range inform.Event {
+		if event.EventCode == eventCode {
+			return true
+		}
+	}
+	return false
+}
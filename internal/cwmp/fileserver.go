@@ -0,0 +1,160 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// filesPath is the path the file server serves files under
+const filesPath = "/files/"
+
+// defaultFileURLTTL is how long a signed file URL stays valid when the
+// configuration sets no TTL
+const defaultFileURLTTL = time.Hour
+
+// fileServer serves the files of the file store to devices. Files are only
+// served on URLs signed by the ACS, which expire after a while.
+type fileServer struct {
+	store   db.FileStore
+	baseURL string
+	secret  []byte
+	ttl     time.Duration
+}
+
+// initFileServer sets up the file server over the given store, it is left
+// disabled if the configuration does not enable it
+func (acs *AcsServer) initFileServer(store db.FileStore) error {
+	cfg := acs.config.Protocols.CWMP.FileServer
+	if !cfg.Enabled {
+		return nil
+	}
+
+	baseURL := cfg.BaseURL
+	if baseURL == "" {
+		acsURL, err := url.Parse(acs.config.Protocols.CWMP.URL)
+		if err != nil || acsURL.Host == "" {
+			return fmt.Errorf("file server base URL is not configured and the ACS URL %q is invalid", acs.config.Protocols.CWMP.URL)
+		}
+		baseURL = acsURL.Scheme + "://" + acsURL.Host
+	}
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return fmt.Errorf("failed to generate file URL secret: %w", err)
+		}
+		log.Println("No file server secret configured, file URLs will not survive a restart")
+	}
+	ttl := cfg.URLTTL
+	if ttl <= 0 {
+		ttl = defaultFileURLTTL
+	}
+
+	acs.files = &fileServer{
+		store:   store,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  secret,
+		ttl:     ttl,
+	}
+	log.Printf("File server enabled at %s%s, URLs valid for %s", acs.files.baseURL, filesPath, ttl)
+	return nil
+}
+
+// signedURL returns a URL the file can be downloaded from until it expires
+func (fs *fileServer) signedURL(id string) string {
+	expires := time.Now().Add(fs.ttl).Unix()
+	return fmt.Sprintf("%s%s%s?expires=%d&signature=%s", fs.baseURL, filesPath, url.PathEscape(id), expires, fs.sign(id, expires))
+}
+
+func (fs *fileServer) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, fs.secret)
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the signature and expiry of a file URL
+func (fs *fileServer) verify(id string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid expiry")
+	}
+	if !hmac.Equal([]byte(query.Get("signature")), []byte(fs.sign(id, expires))) {
+		return errors.New("invalid signature")
+	}
+	if time.Now().Unix() > expires {
+		return errors.New("URL expired")
+	}
+	return nil
+}
+
+// serveFile sends a file of the store to a device holding a valid URL
+func (fs *fileServer) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, filesPath)
+	if err := fs.verify(id, r.URL.Query()); err != nil {
+		log.Printf("Rejected download of file %s from %s: %v", id, r.RemoteAddr, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	content, file, err := fs.store.OpenFile(id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening file %s: %v", id, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Length, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	w.Header().Set("Last-Modified", file.UploadDate.UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, err := io.Copy(w, content)
+	if err != nil {
+		log.Printf("Error sending file %s to %s after %d bytes: %v", id, r.RemoteAddr, n, err)
+		return
+	}
+	log.Printf("Sent file %s (%s, %d bytes) to %s", id, file.Name, n, r.RemoteAddr)
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"net"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/geoip"
+)
+
+// loadGeoIP opens the GeoIP database if enrichment is enabled
+func (acs *AcsServer) loadGeoIP() error {
+	cfg := acs.config.Protocols.CWMP.GeoIP
+	if !cfg.Enabled {
+		return nil
+	}
+
+	geo, err := geoip.Open(cfg.DatabaseFile)
+	if err != nil {
+		return err
+	}
+	acs.geo = geo
+	log.Printf("Loaded GeoIP database %s with %d networks", cfg.DatabaseFile, geo.Len())
+	return nil
+}
+
+// enrichDeviceGeo resolves the source address of the device's connection and
+// stores its country, region and ASN on the device record. The lookup is
+// skipped while the source address stays the same.
+func (acs *AcsServer) enrichDeviceGeo(deviceId string, remoteAddr string) {
+	if acs.geo == nil || acs.dbH == nil {
+		return
+	}
+
+	ipAddress := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ipAddress = host
+	}
+
+	device, err := acs.dbH.GetCwmpDeviceByID(deviceId)
+	if err != nil {
+		return
+	}
+	if device.Geo != nil && device.Geo.IPAddress == ipAddress {
+		return
+	}
+
+	geo := &db.DeviceGeo{IPAddress: ipAddress}
+	if record, ok := acs.geo.Lookup(ipAddress); ok {
+		geo.Country = record.Country
+		geo.Region = record.Region
+		geo.ASN = record.ASN
+		geo.ASOrg = record.ASOrg
+	}
+	if err := acs.dbH.UpdateCwmpDeviceGeo(deviceId, geo); err != nil {
+		log.Printf("Error storing GeoIP data of device %s: %v", deviceId, err)
+		return
+	}
+	log.Printf("Device %s located at %s (country: %s, region: %s, ASN: %d)",
+		deviceId, ipAddress, geo.Country, geo.Region, geo.ASN)
+}
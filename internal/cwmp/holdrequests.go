@@ -0,0 +1,66 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import "strings"
+
+// isCpeRequest reports whether a message of the CPE is a request of its own
+// rather than the answer to an ACS RPC or the Inform opening the session
+func isCpeRequest(req *soapRequest) bool {
+	if req.Method == "" || req.Method == "Inform" || strings.HasSuffix(req.Method, "Response") {
+		return false
+	}
+	return !(req.Method == "Fault" && req.Space == soapEnvelopeNS)
+}
+
+// holdingRequests reports whether the CPE must hold its requests: a held
+// RPC is queued in the session or awaits the response of the CPE
+func (s *CwmpSession) holdingRequests() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.holdingRequestsLocked()
+}
+
+func (s *CwmpSession) holdingRequestsLocked() bool {
+	if len(s.heldRPCs) == 0 {
+		return false
+	}
+	if s.State == SessionStateAwaitingResponse && s.heldRPCs[s.CurrentRPC] {
+		return true
+	}
+	for _, rpc := range s.PendingRPCs {
+		if s.heldRPCs[rpc] {
+			return true
+		}
+	}
+	return false
+}
+
+// applyHoldRequests sets the HoldRequests header of an envelope sent to the
+// CPE while the session holds its requests. The header is left out once
+// nothing holds them, which lets the CPE send its requests again.
+func (s *CwmpSession) applyHoldRequests(envelope *SOAPEnvelope) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.HoldRequests = s.holdingRequestsLocked()
+	if !s.HoldRequests {
+		// All held RPCs were answered
+		s.heldRPCs = nil
+		envelope.Header.HoldRequests = nil
+		return
+	}
+	envelope.Header.HoldRequests = &HoldRequestsHeader{MustUnderstand: "1", Value: true}
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// storeInform upserts the identity, attributes and parameters reported in
+// the Inform of a device. With requirePreRegistration unregistered devices
+// are only tracked in the pending queue until they are pre-registered.
+func (acs *AcsServer) storeInform(deviceId string, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+
+	upsert := !acs.requirePreRegistration()
+	if err := acs.dbH.UpdateCwmpDeviceInform(deviceId, deviceInform(inform), upsert); err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error storing the Inform of device %s: %v", deviceId, err)
+		}
+		return
+	}
+
+	// Value changes were stored with their history already, the other
+	// parameters are the values at the time of the Inform
+	if hasEvent(inform, EventValueChange) {
+		return
+	}
+	acs.recordParameterValues(deviceId, inform.ParameterList, db.ParamChangeSourceInform)
+}
+
+// deviceInform extracts the device attributes reported in an Inform
+func deviceInform(inform *Inform) *db.DeviceInform {
+	info := &db.DeviceInform{
+		OUI:          inform.DeviceId.OUI,
+		Manufacturer: inform.DeviceId.Manufacturer,
+		ProductClass: inform.DeviceId.ProductClass,
+		SerialNumber: inform.DeviceId.SerialNumber,
+		CurrentTime:  inform.CurrentTime,
+		Bootstrap:    hasEvent(inform, EventBootstrap),
+	}
+	for _, param := range inform.ParameterList {
+		switch {
+		case strings.HasSuffix(param.Name, ".DeviceInfo.SoftwareVersion"):
+			info.SoftwareVersion = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.HardwareVersion"):
+			info.HardwareVersion = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.ModelName"):
+			info.ModelName = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.ProvisioningCode"):
+			info.ProvisioningCode = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.SpecVersion"):
+			info.SpecVersion = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.UpTime"):
+			if v, err := strconv.Atoi(param.Value); err == nil {
+				info.UpTime = &v
+			}
+		case strings.HasSuffix(param.Name, ".ManagementServer.PeriodicInformEnable"):
+			if v, err := strconv.ParseBool(param.Value); err == nil {
+				info.PeriodicInformEnable = &v
+			}
+		case strings.HasSuffix(param.Name, ".ManagementServer.PeriodicInformInterval"):
+			if v, err := strconv.Atoi(param.Value); err == nil {
+				info.PeriodicInformInterval = &v
+			}
+		}
+	}
+	return info
+}
@@ -0,0 +1,276 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// DeviceEventInformLoop is emitted when a device starts or stops informing
+// in a loop
+const DeviceEventInformLoop = "device.inform_loop"
+
+// AlarmInformLoop is the type of the alarm raised for a looping device
+const AlarmInformLoop = "cwmp.inform_loop"
+
+// informLimitSweep is how often the state of quiet devices is dropped
+const informLimitSweep = 10 * time.Minute
+
+// informRateLimitError refuses an Inform with HTTP 503, the CPE retries
+// after the Retry-After delay
+type informRateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *informRateLimitError) Error() string {
+	return fmt.Sprintf("Inform rate limit exceeded, retry after %s", e.retryAfter)
+}
+
+// retryAfterSeconds returns the Retry-After header value, rounded up
+func (e *informRateLimitError) retryAfterSeconds() string {
+	return strconv.Itoa(int(e.retryAfter.Seconds()) + 1)
+}
+
+// tokenBucket allows rate events per second with bursts of up to burst
+type tokenBucket struct {
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), lastRefill: now}
+}
+
+// take consumes a token, or returns how long until the next one is available
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.lastRefill = now
+}
+
+// full reports whether the bucket refilled completely
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// deviceInforms is the Inform history of a device
+type deviceInforms struct {
+	bucket  *tokenBucket
+	informs []time.Time // within the loop window
+	boots   []time.Time
+	looping bool
+}
+
+// informLimiter applies the Inform rate limits and detects devices informing
+// in a loop
+type informLimiter struct {
+	cfg       config.InformLimitsConfig
+	mutex     sync.Mutex
+	global    *tokenBucket
+	devices   map[string]*deviceInforms
+	lastSweep time.Time
+}
+
+// informCheck is the outcome of an Inform for the limiter
+type informCheck struct {
+	retryAfter  time.Duration // set when the Inform is refused
+	loopChanged bool          // the device started or stopped looping
+	looping     bool
+	informs     int
+	boots       int
+}
+
+func newInformLimiter(cfg config.InformLimitsConfig) *informLimiter {
+	l := &informLimiter{cfg: cfg, devices: map[string]*deviceInforms{}, lastSweep: time.Now()}
+	if cfg.GlobalRate > 0 {
+		l.global = newTokenBucket(cfg.GlobalRate, cfg.GlobalBurst, time.Now())
+	}
+	return l
+}
+
+// inform records an Inform of a device. Refused Informs count towards loop
+// detection, a looping device keeps informing whatever the answer.
+func (l *informLimiter) inform(deviceId string, boot bool, now time.Time) informCheck {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) >= informLimitSweep {
+		l.sweep(now)
+	}
+	d, ok := l.devices[deviceId]
+	if !ok {
+		d = &deviceInforms{}
+		if l.cfg.DeviceRate > 0 {
+			d.bucket = newTokenBucket(l.cfg.DeviceRate, l.cfg.DeviceBurst, now)
+		}
+		l.devices[deviceId] = d
+	}
+
+	var check informCheck
+	if l.cfg.LoopWindow > 0 && (l.cfg.LoopInforms > 0 || l.cfg.LoopBoots > 0) {
+		since := now.Add(-l.cfg.LoopWindow)
+		d.informs = append(recentTimes(d.informs, since), now)
+		d.boots = recentTimes(d.boots, since)
+		if boot {
+			d.boots = append(d.boots, now)
+		}
+		looping := (l.cfg.LoopInforms > 0 && len(d.informs) >= l.cfg.LoopInforms) ||
+			(l.cfg.LoopBoots > 0 && len(d.boots) >= l.cfg.LoopBoots)
+		check.loopChanged = looping != d.looping
+		check.looping, check.informs, check.boots = looping, len(d.informs), len(d.boots)
+		d.looping = looping
+	}
+
+	if d.bucket != nil {
+		if wait, ok := d.bucket.take(now); !ok {
+			check.retryAfter = wait
+			return check
+		}
+	}
+	if l.global != nil {
+		if wait, ok := l.global.take(now); !ok {
+			check.retryAfter = wait
+		}
+	}
+	return check
+}
+
+// sweep drops the devices with a full bucket and no recent Inform
+func (l *informLimiter) sweep(now time.Time) {
+	since := now.Add(-l.cfg.LoopWindow)
+	for id, d := range l.devices {
+		if d.looping || len(recentTimes(d.informs, since)) > 0 {
+			continue
+		}
+		if d.bucket == nil || d.bucket.full(now) {
+			delete(l.devices, id)
+		}
+	}
+	l.lastSweep = now
+}
+
+// recentTimes drops the times before since, times are in ascending order
+func recentTimes(times []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(since) {
+		i++
+	}
+	return times[i:]
+}
+
+// initInformLimiter creates the Inform limiter, nil when no limit and no
+// loop detection is configured
+func (acs *AcsServer) initInformLimiter() {
+	if acs.config == nil {
+		return
+	}
+	cfg := acs.config.Protocols.CWMP.InformLimits
+	loops := cfg.LoopWindow > 0 && (cfg.LoopInforms > 0 || cfg.LoopBoots > 0)
+	if cfg.DeviceRate <= 0 && cfg.GlobalRate <= 0 && !loops {
+		return
+	}
+	acs.informs = newInformLimiter(cfg)
+}
+
+// checkInformRate refuses the Inform of a device which exceeds its own or
+// the global Inform rate, and flags the devices informing in a loop
+func (acs *AcsServer) checkInformRate(deviceId string, inform *Inform) error {
+	if acs.informs == nil {
+		return nil
+	}
+	boot := false
+	for _, event := range inform.Event {
+		if event.EventCode == EventBoot {
+			boot = true
+		}
+	}
+
+	check := acs.informs.inform(deviceId, boot, time.Now())
+	if check.loopChanged {
+		acs.flagInformLoop(deviceId, check)
+	}
+	if check.retryAfter > 0 {
+		log.Printf("Rejecting Inform of device %s, Inform rate limit exceeded", deviceId)
+		return &informRateLimitError{retryAfter: check.retryAfter}
+	}
+	return nil
+}
+
+// flagInformLoop records that a device started or stopped informing in a
+// loop, raising an alarm when it started
+func (acs *AcsServer) flagInformLoop(deviceId string, check informCheck) {
+	window := acs.informs.cfg.LoopWindow
+	details := map[string]string{
+		"informs": strconv.Itoa(check.informs),
+		"boots":   strconv.Itoa(check.boots),
+		"window":  window.String(),
+	}
+	if !check.looping {
+		log.Printf("Device %s is no longer informing in a loop", deviceId)
+		details["state"] = "cleared"
+		if acs.dbH != nil {
+			if err := acs.dbH.UpdateCwmpDeviceInformLoop(deviceId, nil); err != nil {
+				log.Printf("Error clearing the Inform loop flag of device %s: %v", deviceId, err)
+			}
+		}
+		acs.emitDeviceEvent(deviceId, DeviceEventInformLoop, details)
+		return
+	}
+
+	message := fmt.Sprintf("Device %s sent %d Informs, %d after a reboot, within %s", deviceId, check.informs, check.boots, window)
+	log.Printf("Inform loop detected: %s", message)
+	details["state"] = "detected"
+	if acs.dbH != nil {
+		loop := &db.InformLoop{Informs: check.informs, Boots: check.boots, Window: window.String(), DetectedAt: time.Now()}
+		if err := acs.dbH.UpdateCwmpDeviceInformLoop(deviceId, loop); err != nil {
+			log.Printf("Error flagging the Inform loop of device %s: %v", deviceId, err)
+		}
+		alarm := &db.Alarm{
+			Type:     AlarmInformLoop,
+			Severity: db.AlarmSeverityWarning,
+			DeviceID: deviceId,
+			Source:   "cwmpacs",
+			Message:  message,
+		}
+		if err := acs.dbH.InsertAlarm(alarm); err != nil {
+			log.Println("Error storing Inform loop alarm:", err)
+		}
+	}
+	acs.emitDeviceEvent(deviceId, DeviceEventInformLoop, details)
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// informAddress extracts the external IP address and ConnectionRequestURL
+// reported in an Inform. The source address of the connection is used when
+// the CPE does not report its external IP address.
+func informAddress(inform *Inform, remoteAddr string) (string, string) {
+	var ipAddress, connReqURL string
+	for _, param := range inform.ParameterList {
+		switch {
+		case strings.HasSuffix(param.Name, ".ManagementServer.ConnectionRequestURL"):
+			connReqURL = param.Value
+		case strings.HasSuffix(param.Name, ".ExternalIPAddress"):
+			if ipAddress == "" {
+				ipAddress = param.Value
+			}
+		}
+	}
+
+	if ipAddress == "" {
+		if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+			ipAddress = host
+		} else {
+			ipAddress = remoteAddr
+		}
+	}
+	return ipAddress, connReqURL
+}
+
+// trackAddressChange compares the address reported in the Inform with the
+// one stored for the device, records the change in the device's IP history
+// and emits a device.ip_changed event
+func (acs *AcsServer) trackAddressChange(deviceId string, inform *Inform, remoteAddr string) {
+	if acs.dbH == nil {
+		return
+	}
+
+	device, err := acs.dbH.GetCwmpDeviceByID(deviceId)
+	if err != nil {
+		// Unknown device, nothing to compare against yet
+		return
+	}
+
+	ipAddress, connReqURL := informAddress(inform, remoteAddr)
+	if connReqURL == "" {
+		connReqURL = device.ConnectionRequestURL
+	}
+	if ipAddress == device.IPAddress && connReqURL == device.ConnectionRequestURL {
+		if device.IPKey == "" && ipAddress != "" {
+			// Stored before the address key existed, set it without
+			// recording a change
+			if err := acs.dbH.UpdateCwmpDeviceAddress(deviceId, ipAddress, connReqURL); err != nil {
+				log.Printf("Error updating address of device %s: %v", deviceId, err)
+			}
+		}
+		return
+	}
+
+	if err := acs.dbH.UpdateCwmpDeviceAddress(deviceId, ipAddress, connReqURL); err != nil {
+		log.Printf("Error updating address of device %s: %v", deviceId, err)
+		return
+	}
+
+	change := &db.CwmpIPChange{
+		DeviceID:             deviceId,
+		IPAddress:            ipAddress,
+		ConnectionRequestURL: connReqURL,
+		PreviousIPAddress:    device.IPAddress,
+		PreviousURL:          device.ConnectionRequestURL,
+	}
+	if err := acs.dbH.InsertCwmpIPChange(change); err != nil {
+		log.Printf("Error storing IP history of device %s: %v", deviceId, err)
+	}
+
+	acs.emitDeviceEvent(deviceId, DeviceEventIPChanged, map[string]string{
+		"ip_address":                      ipAddress,
+		"previous_ip_address":             device.IPAddress,
+		"connection_request_url":          connReqURL,
+		"previous_connection_request_url": device.ConnectionRequestURL,
+	})
+}
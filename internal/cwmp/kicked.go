@@ -0,0 +1,81 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// DeviceEventKicked is emitted when a kicked CPE started a provisioning flow
+const DeviceEventKicked = "device.kicked"
+
+// handleKicked runs the provisioning flow named by the Command of a CPE
+// kicked to the ACS by a subscriber portal, and returns the URL the
+// subscriber's browser is sent to. The flow is queued in the session and
+// runs once the CPE finished sending its requests.
+func (acs *AcsServer) handleKicked(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing Kicked")
+
+	var kicked Kicked
+	if err := req.decode(&kicked); err != nil {
+		return nil, fmt.Errorf("error parsing Kicked: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if acs.config == nil || !acs.config.Protocols.CWMP.Kicked.Enabled {
+		return nil, &AcsFault{Code: AcsFaultMethodNotSupported, Message: "Kicked is disabled"}
+	}
+	if session == nil || acs.dbH == nil {
+		return nil, &AcsFault{Code: AcsFaultRequestDenied, Message: "Kicked outside of a session"}
+	}
+	cfg := acs.config.Protocols.CWMP.Kicked
+	rlog := logging.ForRequest(session.currentRequest())
+
+	reject := func(code uint32, message string) (*SOAPEnvelope, error) {
+		rlog.Warnf("Rejected Kicked %q of device %s: %s", kicked.Command, session.DeviceId, message)
+		if cfg.FailureURL != "" {
+			response.Body.Content = &KickedResponse{NextURL: cfg.FailureURL}
+			return response, nil
+		}
+		return nil, &AcsFault{Code: code, Message: message}
+	}
+
+	flow := acs.provisioningFlow(kicked.Command)
+	if flow == nil {
+		return reject(AcsFaultInvalidArguments, "unknown command "+kicked.Command)
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		return reject(AcsFaultRequestDenied, "device is not registered")
+	}
+
+	acs.queueProvisioningFlow(session, device, acs.deviceDataModelRoot(device.ID), flow, "superseded by a Kicked command")
+	rlog.Infof("Device %s kicked from %s, running provisioning flow %s", device.ID, kicked.Referer, flow.Name)
+	acs.emitDeviceEvent(device.ID, DeviceEventKicked, map[string]string{
+		"command": kicked.Command,
+		"arg":     kicked.Arg,
+		"referer": kicked.Referer,
+	})
+
+	nextURL := kicked.Next
+	if nextURL == "" {
+		nextURL = cfg.SuccessURL
+	}
+	response.Body.Content = &KickedResponse{NextURL: nextURL}
+	return response, nil
+}
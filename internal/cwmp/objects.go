@@ -0,0 +1,180 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// Device events recording the outcome of AddObject and DeleteObject
+const (
+	DeviceEventObjectAdded   = "device.object_added"
+	DeviceEventObjectDeleted = "device.object_deleted"
+)
+
+// Results of an AddObject or DeleteObject stored in the event details
+const (
+	ObjectResultComplete = "complete"
+	ObjectResultFault    = "fault"
+)
+
+// handleAddObjectResponse records the instance created by the device and
+// queues the discovery of its parameters
+func (acs *AcsServer) handleAddObjectResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing AddObjectResponse")
+
+	var added AddObjectResponse
+	if err := req.decode(&added); err != nil {
+		return nil, fmt.Errorf("error parsing AddObjectResponse: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if session == nil {
+		log.Printf("Added object instance %d", added.InstanceNumber)
+		return nil, nil
+	}
+	session.mutex.RLock()
+	rpc, _ := session.CurrentRPC.(*AddObject)
+	requestID := session.currentRequestID
+	session.mutex.RUnlock()
+	if rpc == nil {
+		log.Printf("Ignoring AddObjectResponse of device %s without a pending AddObject", session.DeviceId)
+		return acs.continueSession(session, response), nil
+	}
+
+	instance := rpc.ObjectName + strconv.FormatUint(uint64(added.InstanceNumber), 10) + "."
+	logging.ForRequest(requestID).Infof("Device %s created %s", session.DeviceId, instance)
+	if acs.dbH != nil {
+		node := db.CwmpDataModelNode{Path: instance, Object: true, Writable: true}
+		if err := acs.dbH.UpsertCwmpDataModel(session.DeviceId, instance, []db.CwmpDataModelNode{node}, false); err != nil {
+			log.Printf("Error storing object %s of device %s: %v", instance, session.DeviceId, err)
+		}
+	}
+
+	// Learn the parameters of the new instance within the same session
+	session.mutex.Lock()
+	for _, next := range []interface{}{
+		&GetParameterNames{ParameterPath: instance},
+		&GetParameterValues{ParameterNames: []string{instance}},
+	} {
+		session.PendingRPCs = append(session.PendingRPCs, next)
+		if requestID != "" && session.rpcRequests != nil {
+			session.rpcRequests[next] = requestID
+		}
+	}
+	session.mutex.Unlock()
+
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventObjectAdded, map[string]string{
+		"object":          rpc.ObjectName,
+		"instance":        instance,
+		"instance_number": strconv.FormatUint(uint64(added.InstanceNumber), 10),
+		"status":          strconv.FormatUint(uint64(added.Status), 10),
+		"result":          ObjectResultComplete,
+		"request_id":      requestID,
+	})
+	acs.publishObject(session.DeviceId, requestID, instance, added.InstanceNumber)
+	return acs.continueSession(session, response), nil
+}
+
+// handleDeleteObjectResponse removes the deleted instance from the stored
+// parameters and data model of the device
+func (acs *AcsServer) handleDeleteObjectResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing DeleteObjectResponse")
+
+	var deleted DeleteObjectResponse
+	if err := req.decode(&deleted); err != nil {
+		return nil, fmt.Errorf("error parsing DeleteObjectResponse: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if session == nil {
+		return nil, nil
+	}
+	session.mutex.RLock()
+	rpc, _ := session.CurrentRPC.(*DeleteObject)
+	requestID := session.currentRequestID
+	session.mutex.RUnlock()
+	if rpc == nil {
+		log.Printf("Ignoring DeleteObjectResponse of device %s without a pending DeleteObject", session.DeviceId)
+		return acs.continueSession(session, response), nil
+	}
+
+	logging.ForRequest(requestID).Infof("Device %s deleted %s", session.DeviceId, rpc.ObjectName)
+	if acs.dbH != nil {
+		if err := acs.dbH.DeleteCwmpParametersByPrefix(session.DeviceId, rpc.ObjectName); err != nil {
+			log.Printf("Error removing parameters of %s of device %s: %v", rpc.ObjectName, session.DeviceId, err)
+		}
+		if err := acs.dbH.DeleteCwmpDataModel(session.DeviceId, rpc.ObjectName); err != nil {
+			log.Printf("Error removing data model of %s of device %s: %v", rpc.ObjectName, session.DeviceId, err)
+		}
+	}
+
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventObjectDeleted, map[string]string{
+		"object":     rpc.ObjectName,
+		"status":     strconv.FormatUint(uint64(deleted.Status), 10),
+		"result":     ObjectResultComplete,
+		"request_id": requestID,
+	})
+	acs.publishObject(session.DeviceId, requestID, rpc.ObjectName, 0)
+	return acs.continueSession(session, response), nil
+}
+
+// failObjectRPC records the fault of the device to an AddObject or
+// DeleteObject
+func (acs *AcsServer) failObjectRPC(session *CwmpSession, fault *CWMPFault) {
+	session.mutex.RLock()
+	current := session.CurrentRPC
+	requestID := session.currentRequestID
+	session.mutex.RUnlock()
+
+	var eventType, object string
+	switch rpc := current.(type) {
+	case *AddObject:
+		eventType, object = DeviceEventObjectAdded, rpc.ObjectName
+	case *DeleteObject:
+		eventType, object = DeviceEventObjectDeleted, rpc.ObjectName
+	default:
+		return
+	}
+	acs.emitDeviceEvent(session.DeviceId, eventType, map[string]string{
+		"object":       object,
+		"result":       ObjectResultFault,
+		"fault_code":   strconv.FormatUint(uint64(fault.FaultCode), 10),
+		"fault_string": fault.FaultString,
+		"request_id":   requestID,
+	})
+}
+
+// publishObject publishes the instance created, or the object deleted when
+// instanceNumber is 0, in answer to the request requestID
+func (acs *AcsServer) publishObject(deviceId string, requestID string, object string, instanceNumber uint32) {
+	if acs.bus == nil {
+		return
+	}
+	acs.publishEvent(&acsbus.Event{
+		Type:           acsbus.EventObject,
+		DeviceID:       deviceId,
+		ObjectName:     object,
+		InstanceNumber: instanceNumber,
+		RequestID:      requestID,
+	})
+}
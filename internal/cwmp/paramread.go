@@ -0,0 +1,70 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import "strconv"
+
+// DeviceEventParametersRead records the answer of the device to a
+// GetParameterValues requested through the API, the values read are
+// stored with the other parameters before it is recorded
+const DeviceEventParametersRead = "device.parameters_read"
+
+// Results of a GetParameterValues stored in the event details
+const (
+	ReadResultComplete = "complete"
+	ReadResultFault    = "fault"
+)
+
+// recordParametersRead records the values the device answered to the
+// GetParameterValues of an operator request, the API waits for them to
+// answer live reads
+func (acs *AcsServer) recordParametersRead(session *CwmpSession, list []ParameterValueStruct) {
+	requestID, ok := session.currentParameterRead()
+	if !ok {
+		return
+	}
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventParametersRead, map[string]string{
+		"result":     ReadResultComplete,
+		"count":      strconv.Itoa(len(list)),
+		"request_id": requestID,
+	})
+}
+
+// failParametersRead records the fault of the device to the
+// GetParameterValues of an operator request
+func (acs *AcsServer) failParametersRead(session *CwmpSession, fault *CWMPFault) {
+	requestID, ok := session.currentParameterRead()
+	if !ok {
+		return
+	}
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventParametersRead, map[string]string{
+		"result":       ReadResultFault,
+		"fault_code":   strconv.FormatUint(uint64(fault.FaultCode), 10),
+		"fault_string": fault.FaultString,
+		"request_id":   requestID,
+	})
+}
+
+// currentParameterRead returns the request of the GetParameterValues
+// command the device is answering, the reads of the ACS itself are not
+// commands
+func (s *CwmpSession) currentParameterRead() (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.CurrentRPC.(*GetParameterValues); !ok || s.currentCommandID == "" || s.currentRequestID == "" {
+		return "", false
+	}
+	return s.currentRequestID, true
+}
@@ -0,0 +1,171 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// requirePreRegistration reports whether only pre-registered devices are
+// registered
+func (acs *AcsServer) requirePreRegistration() bool {
+	return acs.config != nil && acs.config.Protocols.CWMP.RequirePreRegistration
+}
+
+// registerFirstContact registers an unknown device on its first Inform.
+// Pre-registered devices are provisioned with their profile within the same
+// session. Devices without a pre-registration are registered as well unless
+// requirePreRegistration is set, which puts them in the pending queue.
+func (acs *AcsServer) registerFirstContact(session *CwmpSession, inform *Inform, remoteAddr string) {
+	if acs.dbH == nil {
+		return
+	}
+	deviceId := session.DeviceId
+	device, err := acs.dbH.GetCwmpDeviceByID(deviceId)
+	if err == nil {
+		acs.assignDeviceTenant(device)
+		return
+	}
+	// Registering replaces the device document, an error other than an
+	// unknown device must not wipe a registered one
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error loading device %s, not registering it: %v", deviceId, err)
+		return
+	}
+
+	ipAddress, connReqURL := informAddress(inform, remoteAddr)
+	reg, err := acs.dbH.GetCwmpPreRegistration(inform.DeviceId.OUI, inform.DeviceId.SerialNumber)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error loading the pre-registration of device %s: %v", deviceId, err)
+	}
+	if err != nil && acs.requirePreRegistration() {
+		log.Printf("Device %s is not pre-registered, adding it to the pending queue", deviceId)
+		pending := &db.CwmpPendingDevice{
+			ID:           deviceId,
+			OUI:          inform.DeviceId.OUI,
+			SerialNumber: inform.DeviceId.SerialNumber,
+			Manufacturer: inform.DeviceId.Manufacturer,
+			ProductClass: inform.DeviceId.ProductClass,
+			IPAddress:    ipAddress,
+		}
+		if err := acs.dbH.UpsertCwmpPendingDevice(pending); err != nil {
+			log.Printf("Error adding device %s to the pending queue: %v", deviceId, err)
+		}
+		return
+	}
+	if reg == nil {
+		reg = &db.CwmpPreRegistration{}
+	}
+
+	now := time.Now()
+	root := dataModelRoot(inform)
+	device = &db.CwmpDevice{
+		ID:                   deviceId,
+		OUI:                  inform.DeviceId.OUI,
+		ProductClass:         inform.DeviceId.ProductClass,
+		SerialNumber:         inform.DeviceId.SerialNumber,
+		ManufacturerOUI:      inform.DeviceId.OUI,
+		Manufacturer:         inform.DeviceId.Manufacturer,
+		ConnectionRequestURL: connReqURL,
+		IPAddress:            ipAddress,
+		Profile:              reg.Profile,
+		Subscriber:           reg.Subscriber,
+		DataModel:            datamodel.Model(root),
+		DataModelRoot:        root,
+		Tags:                 []string{},
+		Parameters:           map[string]string{},
+		LastInform:           now,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}
+	for _, param := range inform.ParameterList {
+		switch {
+		case strings.HasSuffix(param.Name, ".DeviceInfo.SoftwareVersion"):
+			device.SoftwareVersion = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.HardwareVersion"):
+			device.HardwareVersion = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.ModelName"):
+			device.ModelName = param.Value
+		case strings.HasSuffix(param.Name, ".DeviceInfo.ProvisioningCode"):
+			device.ProvisioningCode = param.Value
+		}
+	}
+	if acs.quota != nil {
+		device.Tenant, _ = acs.quota.TenantOfDevice(device.OUI, device.ProductClass, device.SerialNumber, device.Profile)
+	}
+	if err := acs.dbH.UpsertCwmpDevice(device); err != nil {
+		log.Printf("Error registering device %s: %v", deviceId, err)
+		return
+	}
+	if reg.ID != "" {
+		if err := acs.dbH.MarkCwmpPreRegistrationRegistered(reg.ID, deviceId); err != nil {
+			log.Printf("Error updating pre-registration %s: %v", reg.ID, err)
+		}
+	}
+	// The device may have informed before it was pre-registered
+	if err := acs.dbH.DeleteCwmpPendingDevice(deviceId); err != nil {
+		log.Printf("Error removing device %s from the pending queue: %v", deviceId, err)
+	}
+	log.Printf("Registered device %s (profile %q)", deviceId, reg.Profile)
+	acs.emitDeviceEvent(deviceId, DeviceEventRegistered, map[string]string{"profile": reg.Profile})
+
+	// The BOOTSTRAP synchronization applies the profile as one of its steps
+	if reg.Profile != "" && !acs.isBootstrapSync(inform) {
+		acs.queueProfile(session, reg.Profile)
+	}
+	acs.applyZtpTemplate(session, inform)
+}
+
+// queueProfile queues a SetParameterValues with the parameters of a profile
+func (acs *AcsServer) queueProfile(session *CwmpSession, name string) {
+	rpc, err := acs.profileRPC(name)
+	if err != nil {
+		log.Printf("Error loading profile %s for device %s: %v", name, session.DeviceId, err)
+		return
+	}
+	if rpc == nil {
+		return
+	}
+
+	session.mutex.Lock()
+	session.PendingRPCs = append(session.PendingRPCs, rpc)
+	session.mutex.Unlock()
+	log.Printf("Queued profile %s for device %s: %d parameter(s)", name, session.DeviceId, len(rpc.ParameterList))
+}
+
+// profileRPC builds the SetParameterValues applying a profile, nil if the
+// profile sets no parameter
+func (acs *AcsServer) profileRPC(name string) (*SetParameterValues, error) {
+	profile, err := acs.dbH.GetCwmpProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(profile.Parameters) == 0 {
+		return nil, nil
+	}
+
+	rpc := &SetParameterValues{ParameterKey: "profile:" + profile.Name}
+	for _, p := range profile.Parameters {
+		rpc.ParameterList = append(rpc.ParameterList, ParameterValueStruct{Name: p.Path, Value: p.Value, Type: p.Type})
+	}
+	return rpc, nil
+}
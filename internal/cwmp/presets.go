@@ -0,0 +1,228 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/parser"
+)
+
+// Condition keys matching the tags and the groups of a device
+const (
+	presetTagKey   = "tag"
+	presetGroupKey = "group"
+)
+
+// maxParameterKeyLength is the size of the ParameterKey argument of
+// SetParameterValues
+const maxParameterKeyLength = 32
+
+// presetRun tracks the SetParameterValues applying the presets of a device
+// queued in the current session
+type presetRun struct {
+	rpc     *SetParameterValues
+	presets []string
+}
+
+// applyPresets evaluates the presets against the stored parameters and
+// tags and groups of a registered device which informed, and queues a
+// SetParameterValues with the preset values the device does not have yet.
+// Presets are applied by ascending weight, so that the heaviest one wins.
+func (acs *AcsServer) applyPresets(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	presets, err := acs.dbH.GetCwmpPresets()
+	if err != nil {
+		log.Printf("Error loading presets: %v", err)
+		return
+	}
+	if len(presets) == 0 {
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		return
+	}
+	session.mutex.RLock()
+	pending := session.presets != nil
+	session.mutex.RUnlock()
+	if pending {
+		// The values queued in a previous session were not answered yet
+		return
+	}
+
+	values := acs.presetValues(device.ID, inform)
+	root := dataModelRoot(inform)
+	desired := map[string]ParameterValueStruct{}
+	var paths, applied []string
+	for i := range presets {
+		preset := &presets[i]
+		if !presetMatches(preset, values, device, inform) {
+			continue
+		}
+		applied = append(applied, preset.Name)
+		for _, p := range preset.Parameters {
+			path, value, ok := datamodel.TranslateParam(p.Path, p.Value, root)
+			if !ok {
+				log.Printf("Preset %s parameter %s has no equivalent in the %s data model of device %s", preset.Name, p.Path, datamodel.Model(root), device.ID)
+				continue
+			}
+			if _, seen := desired[path]; !seen {
+				paths = append(paths, path)
+			}
+			desired[path] = ParameterValueStruct{Name: path, Value: value, Type: p.Type}
+		}
+	}
+
+	rpc := &SetParameterValues{ParameterKey: presetParameterKey(applied)}
+	for _, path := range paths {
+		if current, ok := values[path]; ok && current == desired[path].Value {
+			continue
+		}
+		rpc.ParameterList = append(rpc.ParameterList, desired[path])
+	}
+	if len(rpc.ParameterList) == 0 {
+		return
+	}
+
+	session.mutex.Lock()
+	session.presets = &presetRun{rpc: rpc, presets: applied}
+	session.PendingRPCs = append(session.PendingRPCs, rpc)
+	session.mutex.Unlock()
+	log.Printf("Queued %d parameter(s) of preset(s) %s for device %s", len(rpc.ParameterList), strings.Join(applied, ", "), device.ID)
+}
+
+// presetValues returns the values preset conditions are evaluated against:
+// the stored parameters of the device updated with those of the Inform, the
+// TR-181 names of TR-098 parameters and the DeviceID fields of the Inform
+func (acs *AcsServer) presetValues(deviceId string, inform *Inform) map[string]string {
+	values := map[string]string{}
+	params, err := acs.dbH.GetCwmpParametersByDeviceID(deviceId)
+	if err != nil {
+		log.Printf("Error loading parameters of device %s: %v", deviceId, err)
+	}
+	for _, p := range params {
+		values[p.Path] = p.Value
+	}
+	for _, p := range inform.ParameterList {
+		values[p.Name] = p.Value
+	}
+	for path, value := range values {
+		if datamodel.RootOf(path) != datamodel.RootTR098 {
+			continue
+		}
+		if normalized, value, ok := datamodel.Normalize(path, value); ok {
+			if _, exists := values[normalized]; !exists {
+				values[normalized] = value
+			}
+		}
+	}
+	values["DeviceID.Manufacturer"] = inform.DeviceId.Manufacturer
+	values["DeviceID.OUI"] = inform.DeviceId.OUI
+	values["DeviceID.ProductClass"] = inform.DeviceId.ProductClass
+	values["DeviceID.SerialNumber"] = inform.DeviceId.SerialNumber
+	return values
+}
+
+// presetMatches reports whether a preset applies to an Inform of a device.
+// Its condition uses the syntax of search expressions, the tag and group
+// keys match any of the device tags and groups.
+func presetMatches(preset *db.CwmpPreset, values map[string]string, device *db.CwmpDevice, inform *Inform) bool {
+	if len(preset.Events) > 0 {
+		matched := false
+		for _, event := range preset.Events {
+			if hasEvent(inform, event) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if preset.Condition == "" {
+		return true
+	}
+
+	filters, err := parser.ParseConditions(preset.Condition)
+	if err != nil {
+		log.Printf("Skipping preset %s: %v", preset.Name, err)
+		return false
+	}
+	for _, f := range filters {
+		switch f.Key {
+		case presetTagKey:
+			if !anyTagMatches(f, device.Tags) {
+				return false
+			}
+			continue
+		case presetGroupKey:
+			if !anyTagMatches(f, device.Groups) {
+				return false
+			}
+			continue
+		}
+		value, ok := values[f.Key]
+		if !ok || !f.Match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func anyTagMatches(f parser.Filter, tags []string) bool {
+	for _, tag := range tags {
+		if f.Match(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// completePresets records the values of the presets the device applied, so
+// that they are not pushed again on its next Inform
+func (acs *AcsServer) completePresets(session *CwmpSession, fault *CWMPFault) {
+	session.mutex.Lock()
+	run := session.presets
+	if run == nil || session.CurrentRPC != run.rpc {
+		session.mutex.Unlock()
+		return
+	}
+	session.presets = nil
+	session.mutex.Unlock()
+
+	if fault != nil {
+		log.Printf("Device %s failed to apply preset(s) %s: CPE fault %d: %s", session.DeviceId, strings.Join(run.presets, ", "), fault.FaultCode, fault.FaultString)
+		return
+	}
+	if err := acs.dbH.UpsertCwmpParameters(toDbParameters(session.DeviceId, run.rpc.ParameterList)); err != nil {
+		log.Printf("Error storing preset values of device %s: %v", session.DeviceId, err)
+	}
+}
+
+// presetParameterKey returns the ParameterKey naming the applied presets,
+// truncated to the size CPEs store
+func presetParameterKey(presets []string) string {
+	key := "preset:" + strings.Join(presets, ",")
+	if len(key) > maxParameterKeyLength {
+		key = key[:maxParameterKeyLength]
+	}
+	return key
+}
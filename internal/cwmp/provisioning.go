@@ -0,0 +1,278 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// DeviceEventProvisioned is emitted once the provisioning flow of a device
+// finished
+const DeviceEventProvisioned = "device.provisioned"
+
+// provisioningRun tracks the RPCs of the provisioning flow queued in the
+// current session
+type provisioningRun struct {
+	state    *db.DeviceProvisioning
+	actions  map[interface{}]int // queued RPC to the index of its action
+	username string
+	password string
+}
+
+// matchProvisioningFlow returns the first flow configured for the OUI and
+// product class of a device
+func (acs *AcsServer) matchProvisioningFlow(id *DeviceIdStruct) *config.ProvisioningFlow {
+	if acs.config == nil {
+		return nil
+	}
+	flows := acs.config.Protocols.CWMP.Bootstrap.Provisioning
+	for i := range flows {
+		flow := &flows[i]
+		if flow.OUI != "" && !strings.EqualFold(flow.OUI, id.OUI) {
+			continue
+		}
+		if flow.ProductClass != "" && flow.ProductClass != id.ProductClass {
+			continue
+		}
+		return flow
+	}
+	return nil
+}
+
+// startProvisioning queues the actions of the provisioning flow matching a
+// device which informed with "0 BOOTSTRAP", unless it was already
+// provisioned. The progress is recorded on the device document.
+func (acs *AcsServer) startProvisioning(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil || !hasEvent(inform, EventBootstrap) {
+		return
+	}
+	flow := acs.matchProvisioningFlow(&inform.DeviceId)
+	if flow == nil {
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		log.Printf("Skipping provisioning of unregistered device %s", session.DeviceId)
+		return
+	}
+	if device.Provisioning != nil && device.Provisioning.Status == db.SyncStatusComplete {
+		log.Printf("Device %s was provisioned with flow %s, skipping", device.ID, device.Provisioning.Flow)
+		return
+	}
+	acs.queueProvisioningFlow(session, device, dataModelRoot(inform), flow, "superseded by a new BOOTSTRAP")
+}
+
+// provisioningFlow returns the provisioning flow with the given name
+func (acs *AcsServer) provisioningFlow(name string) *config.ProvisioningFlow {
+	if acs.config == nil {
+		return nil
+	}
+	flows := acs.config.Protocols.CWMP.Bootstrap.Provisioning
+	for i := range flows {
+		if flows[i].Name == name {
+			return &flows[i]
+		}
+	}
+	return nil
+}
+
+// queueProvisioningFlow queues the actions of a flow in the session of a
+// device, a flow still running in the session is aborted for reason
+func (acs *AcsServer) queueProvisioningFlow(session *CwmpSession, device *db.CwmpDevice, root string, flow *config.ProvisioningFlow, reason string) {
+	run := &provisioningRun{
+		state: &db.DeviceProvisioning{
+			Flow:      flow.Name,
+			Status:    db.SyncStatusRunning,
+			StartedAt: time.Now(),
+		},
+		actions: map[interface{}]int{},
+	}
+	var rpcs []interface{}
+	for _, action := range flow.Actions {
+		step := db.SyncStep{Name: action.Type, Status: db.SyncStatusPending}
+		rpc, err := acs.provisioningRPC(run, device, root, flow, action)
+		switch {
+		case err != nil:
+			finishSyncStep(&step, err)
+		case rpc == nil:
+			step.Status = db.SyncStatusSkipped
+		default:
+			run.actions[rpc] = len(run.state.Actions)
+			rpcs = append(rpcs, rpc)
+		}
+		run.state.Actions = append(run.state.Actions, step)
+	}
+
+	session.mutex.Lock()
+	previous := session.provisioning
+	session.provisioning = run
+	session.PendingRPCs = append(session.PendingRPCs, rpcs...)
+	session.mutex.Unlock()
+
+	if previous != nil {
+		acs.abortProvisioning(session.DeviceId, previous, reason)
+	}
+	if len(rpcs) == 0 {
+		acs.finishProvisioning(session.DeviceId, run)
+		return
+	}
+	acs.saveProvisioning(session.DeviceId, run)
+	log.Printf("Started provisioning flow %s for device %s: %d action(s) queued", flow.Name, device.ID, len(rpcs))
+}
+
+// provisioningRPC builds the RPC of a provisioning action, nil if the action
+// has nothing to send
+func (acs *AcsServer) provisioningRPC(run *provisioningRun, device *db.CwmpDevice, root string, flow *config.ProvisioningFlow, action config.ProvisioningAction) (interface{}, error) {
+	parameterKey := "provisioning:" + flow.Name + ":" + action.Type
+	switch action.Type {
+	case db.ProvisioningActionPeriodicInform:
+		interval := uint32(action.Interval.Seconds())
+		if interval == 0 {
+			interval = acs.cfg.informInterval
+		}
+		return &SetParameterValues{
+			ParameterList: []ParameterValueStruct{
+				{Name: root + "ManagementServer.PeriodicInformEnable", Value: "true", Type: "xsd:boolean"},
+				{Name: root + "ManagementServer.PeriodicInformInterval", Value: strconv.FormatUint(uint64(interval), 10), Type: "xsd:unsignedInt"},
+			},
+			ParameterKey: parameterKey,
+		}, nil
+
+	case db.ProvisioningActionCredentials:
+		rpc, username, password, err := connRequestCredentialsRPC(device, root, parameterKey)
+		if err != nil {
+			return nil, err
+		}
+		run.username, run.password = username, password
+		return rpc, nil
+
+	case db.ProvisioningActionProfile:
+		if action.Profile == "" {
+			return nil, fmt.Errorf("profile action without a profile")
+		}
+		rpc, err := acs.profileRPC(action.Profile)
+		if rpc == nil {
+			// Keep the interface nil so that an empty profile is skipped
+			return nil, err
+		}
+		return rpc, nil
+
+	case db.ProvisioningActionFirmware:
+		if action.URL == "" {
+			return nil, fmt.Errorf("firmware action without a URL")
+		}
+		transfer := &db.CwmpFileTransfer{
+			DeviceID:       device.ID,
+			CommandKey:     parameterKey + ":" + strconv.FormatInt(time.Now().Unix(), 10),
+			FileType:       FileTypeFirmwareUpgrade,
+			URL:            action.URL,
+			FileSize:       action.FileSize,
+			TargetFileName: action.TargetFileName,
+			DelaySeconds:   int(action.Delay.Seconds()),
+		}
+		if err := acs.dbH.InsertCwmpFileTransfer(transfer); err != nil {
+			return nil, fmt.Errorf("failed to store transfer: %w", err)
+		}
+		return &Download{
+			CommandKey:     transfer.CommandKey,
+			FileType:       transfer.FileType,
+			URL:            transfer.URL,
+			FileSize:       uint32(transfer.FileSize),
+			TargetFileName: transfer.TargetFileName,
+			DelaySeconds:   uint32(transfer.DelaySeconds),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown provisioning action %q", action.Type)
+}
+
+// completeProvisioningAction records the outcome of the provisioning action
+// the CPE answered, and finishes the flow once all its RPCs were answered. A
+// firmware action completes once the device accepted the Download, the
+// transfer itself is tracked with the other file transfers.
+func (acs *AcsServer) completeProvisioningAction(session *CwmpSession, fault *CWMPFault) {
+	session.mutex.Lock()
+	run := session.provisioning
+	if run == nil {
+		session.mutex.Unlock()
+		return
+	}
+	index, ok := run.actions[session.CurrentRPC]
+	if !ok {
+		session.mutex.Unlock()
+		return
+	}
+	delete(run.actions, session.CurrentRPC)
+	done := len(run.actions) == 0
+	if done {
+		session.provisioning = nil
+	}
+	session.mutex.Unlock()
+
+	step := &run.state.Actions[index]
+	var err error
+	if fault != nil {
+		err = fmt.Errorf("CPE fault %d: %s", fault.FaultCode, fault.FaultString)
+	} else if step.Name == db.ProvisioningActionCredentials {
+		err = acs.dbH.UpdateCwmpDeviceConnectionCredentials(session.DeviceId, run.username, run.password)
+	}
+	finishSyncStep(step, err)
+	if err != nil {
+		log.Printf("Provisioning action %s of flow %s failed for device %s: %v", step.Name, run.state.Flow, session.DeviceId, err)
+	}
+
+	if done {
+		acs.finishProvisioning(session.DeviceId, run)
+		return
+	}
+	acs.saveProvisioning(session.DeviceId, run)
+}
+
+// abortProvisioning fails the actions of a flow which are still pending
+func (acs *AcsServer) abortProvisioning(deviceId string, run *provisioningRun, reason string) {
+	for i := range run.state.Actions {
+		if run.state.Actions[i].Status == db.SyncStatusPending {
+			finishSyncStep(&run.state.Actions[i], fmt.Errorf("%s", reason))
+		}
+	}
+	acs.finishProvisioning(deviceId, run)
+}
+
+// finishProvisioning sets the final status of a flow from its actions
+func (acs *AcsServer) finishProvisioning(deviceId string, run *provisioningRun) {
+	now := time.Now()
+	run.state.Status = db.SyncStatusComplete
+	for _, step := range run.state.Actions {
+		if step.Status == db.SyncStatusFailed {
+			run.state.Status = db.SyncStatusFailed
+		}
+	}
+	run.state.CompletedAt = &now
+	log.Printf("Provisioning flow %s for device %s finished with status: %s", run.state.Flow, deviceId, run.state.Status)
+	acs.saveProvisioning(deviceId, run)
+	acs.emitDeviceEvent(deviceId, DeviceEventProvisioned, map[string]string{"flow": run.state.Flow, "status": run.state.Status})
+}
+
+func (acs *AcsServer) saveProvisioning(deviceId string, run *provisioningRun) {
+	if err := acs.dbH.UpdateCwmpDeviceProvisioning(deviceId, run.state); err != nil {
+		log.Printf("Error storing provisioning progress of device %s: %v", deviceId, err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// DeviceEventTransfersReconciled records the outcome of a GetQueuedTransfers
+// or GetAllQueuedTransfers, with the transfers listed by the device
+const DeviceEventTransfersReconciled = "device.transfers_reconciled"
+
+// QueuedTransfer is a transfer listed by the device, with the transfer
+// record it matches. Transfers without a record are orphaned.
+type QueuedTransfer struct {
+	CommandKey     string `json:"command_key"`
+	State          string `json:"state"`
+	IsDownload     *bool  `json:"is_download,omitempty"`
+	FileType       string `json:"file_type,omitempty"`
+	FileSize       uint32 `json:"file_size,omitempty"`
+	TargetFileName string `json:"target_file_name,omitempty"`
+	TransferID     string `json:"transfer_id,omitempty"`
+}
+
+// handleGetQueuedTransfersResponse reconciles the transfers listed by the
+// device with the transfer records
+func (acs *AcsServer) handleGetQueuedTransfersResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetQueuedTransfersResponse")
+
+	var queued GetQueuedTransfersResponse
+	if err := req.decode(&queued); err != nil {
+		return nil, fmt.Errorf("error parsing GetQueuedTransfersResponse: %w", err)
+	}
+	session := acs.getConnSession(r)
+	if session != nil {
+		transfers := make([]QueuedTransfer, 0, len(queued.TransferList))
+		for _, t := range queued.TransferList {
+			transfers = append(transfers, QueuedTransfer{CommandKey: t.CommandKey, State: queuedTransferState(t.State)})
+		}
+		acs.reconcileTransfers(session, transfers)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleGetAllQueuedTransfersResponse reconciles the transfers listed by the
+// device, including those it did not receive from the ACS, with the
+// transfer records
+func (acs *AcsServer) handleGetAllQueuedTransfersResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetAllQueuedTransfersResponse")
+
+	var queued GetAllQueuedTransfersResponse
+	if err := req.decode(&queued); err != nil {
+		return nil, fmt.Errorf("error parsing GetAllQueuedTransfersResponse: %w", err)
+	}
+	session := acs.getConnSession(r)
+	if session != nil {
+		transfers := make([]QueuedTransfer, 0, len(queued.TransferList))
+		for _, t := range queued.TransferList {
+			isDownload := t.IsDownload
+			transfers = append(transfers, QueuedTransfer{
+				CommandKey:     t.CommandKey,
+				State:          queuedTransferState(t.State),
+				IsDownload:     &isDownload,
+				FileType:       t.FileType,
+				FileSize:       t.FileSize,
+				TargetFileName: t.TargetFileName,
+			})
+		}
+		acs.reconcileTransfers(session, transfers)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// reconcileTransfers matches the transfers listed by the device with the
+// unfinished transfer records of the device on their CommandKey. Records in
+// progress which the device no longer lists are stuck, they will not be
+// reported with TransferComplete. Listed transfers without a record are
+// orphaned.
+func (acs *AcsServer) reconcileTransfers(session *CwmpSession, queued []QueuedTransfer) {
+	if acs.dbH == nil {
+		return
+	}
+	requestID := session.currentRequest()
+	rlog := logging.ForRequest(requestID)
+	records, err := acs.dbH.GetUnfinishedCwmpFileTransfers(session.DeviceId)
+	if err != nil {
+		log.Printf("Error loading transfers of device %s: %v", session.DeviceId, err)
+		return
+	}
+
+	matched := map[string]bool{}
+	orphaned := []string{}
+	for i := range queued {
+		q := &queued[i]
+		for _, t := range records {
+			if t.CommandKey != q.CommandKey || matched[t.ID] {
+				continue
+			}
+			matched[t.ID] = true
+			q.TransferID = t.ID
+			if q.State == db.CpeTransferInProgress && t.Status == db.TransferStatusPending {
+				acs.updateTransfer(session.DeviceId, t.CommandKey, db.TransferUpdate{Status: db.TransferStatusInProgress})
+			}
+			acs.setTransferCpeState(&t, q.State)
+			break
+		}
+		if q.TransferID == "" {
+			orphaned = append(orphaned, q.CommandKey)
+		}
+	}
+
+	stuck := []string{}
+	for i := range records {
+		t := &records[i]
+		// Pending transfers may not have been sent to the device yet
+		if matched[t.ID] || t.Status != db.TransferStatusInProgress {
+			continue
+		}
+		stuck = append(stuck, t.ID)
+		acs.setTransferCpeState(t, db.CpeTransferMissing)
+	}
+
+	if len(stuck) > 0 {
+		rlog.Warnf("Device %s no longer lists transfer(s) in progress: %s", session.DeviceId, strings.Join(stuck, ", "))
+	}
+	if len(orphaned) > 0 {
+		rlog.Warnf("Device %s lists transfer(s) without a record: %q", session.DeviceId, orphaned)
+	}
+	rlog.Infof("Reconciled %d queued transfer(s) of device %s", len(queued), session.DeviceId)
+
+	list, err := json.Marshal(queued)
+	if err != nil {
+		log.Printf("Error encoding queued transfers of device %s: %v", session.DeviceId, err)
+	}
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventTransfersReconciled, map[string]string{
+		"queued":     strconv.Itoa(len(queued)),
+		"transfers":  string(list),
+		"stuck":      strings.Join(stuck, ","),
+		"orphaned":   strings.Join(orphaned, ","),
+		"request_id": requestID,
+	})
+}
+
+func (acs *AcsServer) setTransferCpeState(t *db.CwmpFileTransfer, state string) {
+	if err := acs.dbH.SetCwmpFileTransferCpeState(t.ID, state); err != nil {
+		log.Printf("Error updating transfer %s of device %s: %v", t.ID, t.DeviceID, err)
+	}
+}
+
+// queuedTransferState names the state of a queued transfer
+func queuedTransferState(state uint32) string {
+	switch state {
+	case QueuedTransferNotStarted:
+		return db.CpeTransferNotStarted
+	case QueuedTransferInProgress:
+		return db.CpeTransferInProgress
+	case QueuedTransferCompleted:
+		return db.CpeTransferCompleted
+	}
+	return strconv.FormatUint(uint64(state), 10)
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/internal/quota"
+)
+
+// checkDeviceQuota rejects the Inform of an unknown device whose tenant,
+// derived from the device identity and pre-registered profile, already
+// reached its device limit
+func (acs *AcsServer) checkDeviceQuota(deviceId string, id *DeviceIdStruct) error {
+	if acs.quota == nil || acs.dbH == nil {
+		return nil
+	}
+	if _, err := acs.dbH.GetCwmpDeviceByID(deviceId); err == nil {
+		// Already registered devices are never locked out
+		return nil
+	}
+	var profile string
+	if reg, err := acs.dbH.GetCwmpPreRegistration(id.OUI, id.SerialNumber); err == nil {
+		profile = reg.Profile
+	}
+	tenant, ok := acs.quota.TenantOfDevice(id.OUI, id.ProductClass, id.SerialNumber, profile)
+	if !ok {
+		return nil
+	}
+
+	devices, err := acs.dbH.CountCwmpDevicesByTenant(tenant)
+	if err != nil {
+		log.Printf("Error counting devices of tenant %s: %v", tenant, err)
+		return nil
+	}
+	if !acs.quota.AllowDevice(tenant, devices) {
+		log.Printf("Rejecting device %s, tenant %s reached its device quota", deviceId, tenant)
+		return &AcsFault{Code: AcsFaultResourcesExceeded, Message: "Device quota exceeded"}
+	}
+	return nil
+}
+
+// assignDeviceTenant assigns a registered device without a tenant, such as
+// one registered before its tenant was configured, to the tenant it matches
+func (acs *AcsServer) assignDeviceTenant(device *db.CwmpDevice) {
+	if acs.quota == nil || device.Tenant != "" {
+		return
+	}
+	tenant, ok := acs.quota.TenantOfDevice(device.OUI, device.ProductClass, device.SerialNumber, device.Profile)
+	if !ok {
+		return
+	}
+	if err := acs.dbH.AssignCwmpDeviceTenant(device.ID, tenant); err != nil {
+		log.Printf("Error assigning device %s to tenant %s: %v", device.ID, tenant, err)
+		return
+	}
+	log.Printf("Assigned device %s to tenant %s", device.ID, tenant)
+}
+
+// raiseQuotaAlarm stores an alarm for a quota violation
+func (acs *AcsServer) raiseQuotaAlarm(v quota.Violation) {
+	log.Printf("Quota violation by tenant %s: %s", v.Tenant, v.Message)
+	if acs.dbH == nil {
+		return
+	}
+	alarm := &db.Alarm{
+		Type:     v.Type,
+		Severity: db.AlarmSeverityWarning,
+		Tenant:   v.Tenant,
+		Source:   "cwmpacs",
+		Message:  v.Message,
+	}
+	if err := acs.dbH.InsertAlarm(alarm); err != nil {
+		log.Println("Error storing quota alarm:", err)
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// refreshCheckInterval is how often the devices due for a parameter
+// refresh are scheduled
+const refreshCheckInterval = time.Minute
+
+// defaultRefreshChunkSize is the number of parameters read per
+// GetParameterValues when the configuration does not set it
+const defaultRefreshChunkSize = 100
+
+// dataModelRefresh tracks the RPCs of the parameter refresh queued in the
+// current session
+type dataModelRefresh struct {
+	job       *db.CwmpSyncJob
+	root      string
+	startedAt time.Time
+	discover  interface{}          // GetParameterNames, until answered
+	chunks    map[interface{}]bool // GetParameterValues not answered yet
+	writable  map[string]bool
+	values    int
+	failures  []string
+}
+
+// refreshEnabled reports whether devices are periodically refreshed
+func (acs *AcsServer) refreshEnabled() bool {
+	return acs.config != nil && acs.config.Protocols.CWMP.Refresh.Interval > 0
+}
+
+// scheduleRefreshes periodically flags the devices due for a refresh
+func (acs *AcsServer) scheduleRefreshes() {
+	ticker := time.NewTicker(refreshCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		acs.scheduleDueRefreshes()
+	}
+}
+
+// scheduleDueRefreshes flags the devices not refreshed within the refresh
+// interval. A device with an open session is refreshed in it, the others
+// in their next session.
+func (acs *AcsServer) scheduleDueRefreshes() {
+	cfg := acs.config.Protocols.CWMP.Refresh
+	ids, err := acs.dbH.GetCwmpDevicesDueForRefresh(time.Now().Add(-cfg.Interval), int64(cfg.MaxPerRun))
+	if err != nil {
+		log.Printf("Error loading devices due for a parameter refresh: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		if err := acs.dbH.SetCwmpDeviceRefreshDue(id); err != nil {
+			log.Printf("Error scheduling parameter refresh of device %s: %v", id, err)
+			continue
+		}
+		acs.mutex.RLock()
+		session, exists := acs.sessions[id]
+		acs.mutex.RUnlock()
+		if exists && acs.startDueRefresh(session) {
+			continue
+		}
+		if cfg.ConnectionRequest {
+			go acs.sendConnectionRequest(id, "")
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("Scheduled parameter refresh of %d device(s)", len(ids))
+	}
+}
+
+// startDueRefresh starts the parameter refresh of a device flagged as due
+// in its open session, it reports whether the refresh was started
+func (acs *AcsServer) startDueRefresh(session *CwmpSession) bool {
+	if acs.dbH == nil || !acs.refreshEnabled() {
+		return false
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil || !device.RefreshDue {
+		return false
+	}
+
+	root := device.DataModelRoot
+	if root == "" {
+		root = acs.deviceDataModelRoot(device.ID)
+	}
+	rpc := &GetParameterNames{ParameterPath: root}
+	refresh := &dataModelRefresh{
+		job: &db.CwmpSyncJob{
+			DeviceID: device.ID,
+			Trigger:  db.SyncTriggerRefresh,
+			Status:   db.SyncStatusRunning,
+			Steps: []db.SyncStep{
+				{Name: db.SyncStepDiscover, Status: db.SyncStatusPending},
+				{Name: db.SyncStepValues, Status: db.SyncStatusPending},
+			},
+		},
+		root:      root,
+		startedAt: time.Now(),
+		discover:  rpc,
+		chunks:    map[interface{}]bool{},
+		writable:  map[string]bool{},
+	}
+
+	session.mutex.Lock()
+	if session.State == SessionStateClosed || session.refresh != nil {
+		session.mutex.Unlock()
+		return false
+	}
+	session.refresh = refresh
+	session.PendingRPCs = append(session.PendingRPCs, rpc)
+	session.mutex.Unlock()
+
+	if err := acs.dbH.StartCwmpDeviceRefresh(device.ID, refresh.startedAt); err != nil {
+		log.Printf("Error recording parameter refresh of device %s: %v", device.ID, err)
+	}
+	acs.saveSyncJob(refresh.job)
+	log.Printf("Started parameter refresh %s of device %s under %s", refresh.job.ID, device.ID, root)
+	return true
+}
+
+// continueRefresh handles the answer of the device to a refresh RPC. The
+// parameter names it reports are read in chunks, the values it returns are
+// stored; the refresh finishes once all chunks were answered.
+func (acs *AcsServer) continueRefresh(session *CwmpSession, response interface{}, fault *CWMPFault) {
+	session.mutex.Lock()
+	refresh := session.refresh
+	if refresh == nil {
+		session.mutex.Unlock()
+		return
+	}
+	rpc := session.CurrentRPC
+	step := ""
+	switch {
+	case rpc != nil && rpc == refresh.discover:
+		step = db.SyncStepDiscover
+		refresh.discover = nil
+		if names, ok := response.(*GetParameterNamesResponse); ok && fault == nil {
+			for _, chunk := range refresh.valueChunks(names.ParameterList, acs.refreshChunkSize()) {
+				refresh.chunks[chunk] = true
+				session.PendingRPCs = append(session.PendingRPCs, chunk)
+			}
+		}
+	case refresh.chunks[rpc]:
+		step = db.SyncStepValues
+		delete(refresh.chunks, rpc)
+	default:
+		session.mutex.Unlock()
+		return
+	}
+	pending := len(refresh.chunks)
+	done := refresh.discover == nil && pending == 0
+	if done {
+		session.refresh = nil
+	}
+	session.mutex.Unlock()
+
+	if step == db.SyncStepDiscover {
+		finishSyncStep(refresh.job.Step(db.SyncStepDiscover), faultError(fault))
+		if fault != nil {
+			finishSyncStep(refresh.job.Step(db.SyncStepValues), fmt.Errorf("parameter discovery failed"))
+		} else if pending == 0 {
+			refresh.job.Step(db.SyncStepValues).Status = db.SyncStatusSkipped
+		}
+		acs.saveSyncJob(refresh.job)
+	} else {
+		acs.storeRefreshValues(session.DeviceId, refresh, response, fault)
+		if pending == 0 {
+			var err error
+			if len(refresh.failures) > 0 {
+				err = fmt.Errorf("%d chunk(s) failed: %s", len(refresh.failures), strings.Join(refresh.failures, "; "))
+			}
+			finishSyncStep(refresh.job.Step(db.SyncStepValues), err)
+		}
+	}
+
+	if done {
+		acs.finishRefresh(session.DeviceId, refresh)
+	}
+}
+
+// storeRefreshValues stores the parameter values of a refresh chunk
+func (acs *AcsServer) storeRefreshValues(deviceId string, refresh *dataModelRefresh, response interface{}, fault *CWMPFault) {
+	if fault != nil {
+		refresh.failures = append(refresh.failures, faultError(fault).Error())
+		return
+	}
+	values, ok := response.(*GetParameterValuesResponse)
+	if !ok {
+		return
+	}
+	params := toDbParameters(deviceId, values.ParameterList)
+	for i := range params {
+		params[i].Writable = refresh.writable[params[i].Path]
+	}
+	if err := acs.dbH.UpsertCwmpParameters(params); err != nil {
+		refresh.failures = append(refresh.failures, err.Error())
+		return
+	}
+	refresh.values += len(params)
+}
+
+// finishRefresh sets the final status of a refresh. Once all values were
+// read, the stored parameters the device no longer reported are removed.
+func (acs *AcsServer) finishRefresh(deviceId string, refresh *dataModelRefresh) {
+	job := refresh.job
+	now := time.Now()
+	job.Status = db.SyncStatusComplete
+	for _, step := range job.Steps {
+		if step.Status == db.SyncStatusFailed {
+			job.Status = db.SyncStatusFailed
+		}
+	}
+	job.CompletedAt = &now
+
+	if job.Status == db.SyncStatusComplete && refresh.values > 0 {
+		n, err := acs.dbH.DeleteStaleCwmpParameters(deviceId, refresh.root, refresh.startedAt)
+		if err != nil {
+			log.Printf("Error removing stale parameters of device %s: %v", deviceId, err)
+		} else if n > 0 {
+			log.Printf("Removed %d parameter(s) device %s no longer reports", n, deviceId)
+		}
+	}
+	acs.saveSyncJob(job)
+	log.Printf("Parameter refresh %s of device %s finished with status %s: %d value(s) read", job.ID, deviceId, job.Status, refresh.values)
+	acs.emitDeviceEvent(deviceId, DeviceEventSynchronized, map[string]string{"job_id": job.ID, "status": job.Status, "trigger": db.SyncTriggerRefresh})
+}
+
+// valueChunks builds the GetParameterValues reading the parameters of a
+// GetParameterNamesResponse, objects are skipped
+func (r *dataModelRefresh) valueChunks(params []ParameterInfoStruct, size int) []interface{} {
+	var chunks []interface{}
+	var names []string
+	for _, p := range params {
+		if strings.HasSuffix(p.Name, ".") {
+			continue
+		}
+		r.writable[p.Name] = p.Writable
+		names = append(names, p.Name)
+		if len(names) == size {
+			chunks = append(chunks, &GetParameterValues{ParameterNames: names})
+			names = nil
+		}
+	}
+	if len(names) > 0 {
+		chunks = append(chunks, &GetParameterValues{ParameterNames: names})
+	}
+	return chunks
+}
+
+// refreshChunkSize returns the number of parameters read per
+// GetParameterValues
+func (acs *AcsServer) refreshChunkSize() int {
+	if size := acs.config.Protocols.CWMP.Refresh.ChunkSize; size > 0 {
+		return size
+	}
+	return defaultRefreshChunkSize
+}
+
+// faultError converts a CPE fault to an error, nil if there is none
+func faultError(fault *CWMPFault) error {
+	if fault == nil {
+		return nil
+	}
+	return fmt.Errorf("CPE fault %d: %s", fault.FaultCode, fault.FaultString)
+}
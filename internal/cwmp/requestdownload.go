@@ -0,0 +1,131 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/config"
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// handleRequestDownload answers a CPE asking for a file with the Download
+// chosen by the first matching download policy. The Download is queued in
+// the session and tracked with the other file transfers, a firmware image
+// opens a firmware job. A request no policy matches is acknowledged and
+// ignored.
+func (acs *AcsServer) handleRequestDownload(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing RequestDownload")
+
+	var request RequestDownload
+	if err := req.decode(&request); err != nil {
+		return nil, fmt.Errorf("error parsing RequestDownload: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if session == nil || acs.dbH == nil {
+		return nil, &AcsFault{Code: AcsFaultRequestDenied, Message: "RequestDownload outside of a session"}
+	}
+	rlog := logging.ForRequest(session.currentRequest())
+
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		return nil, &AcsFault{Code: AcsFaultRequestDenied, Message: "device is not registered"}
+	}
+	response.Body.Content = &RequestDownloadResponse{}
+
+	policy := acs.matchDownloadPolicy(device, request.FileType)
+	if policy == nil {
+		rlog.Infof("No download policy for %q requested by device %s, ignoring", request.FileType, device.ID)
+		return response, nil
+	}
+	rpc, err := acs.requestedDownloadRPC(device, policy)
+	if err != nil {
+		rlog.Errorf("Error answering RequestDownload of device %s: %v", device.ID, err)
+		return nil, &AcsFault{Code: AcsFaultInternalError, Message: err.Error()}
+	}
+
+	session.mutex.Lock()
+	session.PendingRPCs = append(session.PendingRPCs, rpc)
+	session.mutex.Unlock()
+	rlog.Infof("Queued Download %s of %q requested by device %s", rpc.CommandKey, request.FileType, device.ID)
+	return response, nil
+}
+
+// matchDownloadPolicy returns the first download policy for a file type
+// configured for the OUI and product class of a device
+func (acs *AcsServer) matchDownloadPolicy(device *db.CwmpDevice, fileType string) *config.DownloadPolicy {
+	if acs.config == nil {
+		return nil
+	}
+	policies := acs.config.Protocols.CWMP.RequestDownload
+	for i := range policies {
+		policy := &policies[i]
+		if policy.FileType != fileType {
+			continue
+		}
+		if policy.OUI != "" && !strings.EqualFold(policy.OUI, device.OUI) {
+			continue
+		}
+		if policy.ProductClass != "" && policy.ProductClass != device.ProductClass {
+			continue
+		}
+		return policy
+	}
+	return nil
+}
+
+// requestedDownloadRPC records the transfer of the file offered by a policy
+// and builds its Download
+func (acs *AcsServer) requestedDownloadRPC(device *db.CwmpDevice, policy *config.DownloadPolicy) (*Download, error) {
+	downloadURL := policy.URL
+	if policy.FileID != "" {
+		if acs.files == nil {
+			return nil, fmt.Errorf("file %s cannot be downloaded, the file server is disabled", policy.FileID)
+		}
+		downloadURL = acs.files.signedURL(policy.FileID)
+	}
+	if downloadURL == "" {
+		return nil, fmt.Errorf("download policy for %q without a url or fileId", policy.FileType)
+	}
+
+	transfer := &db.CwmpFileTransfer{
+		DeviceID:        device.ID,
+		CommandKey:      "request-download:" + strconv.FormatInt(time.Now().Unix(), 10),
+		FileType:        policy.FileType,
+		URL:             policy.URL,
+		FileID:          policy.FileID,
+		FileSize:        policy.FileSize,
+		TargetFileName:  policy.TargetFileName,
+		FirmwareVersion: policy.FirmwareVersion,
+		DelaySeconds:    int(policy.Delay.Seconds()),
+	}
+	if err := acs.dbH.InsertCwmpFileTransfer(transfer); err != nil {
+		return nil, fmt.Errorf("failed to store transfer: %w", err)
+	}
+	return &Download{
+		CommandKey:     transfer.CommandKey,
+		FileType:       transfer.FileType,
+		URL:            downloadURL,
+		FileSize:       uint32(transfer.FileSize),
+		TargetFileName: transfer.TargetFileName,
+		DelaySeconds:   uint32(transfer.DelaySeconds),
+	}, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/n4-networks/openusp/pkg/logging"
+)
+
+// acsRPCMethods are the methods the ACS accepts from CPEs, returned in the
+// GetRPCMethodsResponse
+var acsRPCMethods = []string{
+	"Inform",
+	"GetRPCMethods",
+	"TransferComplete",
+	"AutonomousTransferComplete",
+	"ChangeDUStateComplete",
+	"Kicked",
+	"RequestDownload",
+}
+
+// handleGetRPCMethods answers a CPE asking for the methods supported by the
+// ACS
+func (acs *AcsServer) handleGetRPCMethods(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetRPCMethods request")
+
+	version := acs.sessionCwmpVersion(req, acs.getConnSession(r))
+	methods := make([]string, 0, len(acsRPCMethods))
+	for _, method := range acsRPCMethods {
+		if supportsMethod(version, method) {
+			methods = append(methods, method)
+		}
+	}
+	response.Body.Content = &GetRPCMethodsResponse{MethodList: methods}
+	return response, nil
+}
+
+// handleGetRPCMethodsResponse stores the methods advertised by the device
+func (acs *AcsServer) handleGetRPCMethodsResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing GetRPCMethodsResponse")
+
+	var methods GetRPCMethodsResponse
+	if err := req.decode(&methods); err != nil {
+		return nil, fmt.Errorf("error parsing GetRPCMethodsResponse: %w", err)
+	}
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		logging.ForRequest(session.currentRequest()).Infof("Device %s supports %d RPC method(s): %v", session.DeviceId, len(methods.MethodList), methods.MethodList)
+		if acs.dbH != nil {
+			if err := acs.dbH.UpdateCwmpDeviceRPCMethods(session.DeviceId, methods.MethodList); err != nil {
+				log.Printf("Error storing RPC methods of device %s: %v", session.DeviceId, err)
+			}
+		}
+		acs.completeSyncStep(session, &methods, nil)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// discoverRPCMethods queues a GetRPCMethods for a registered device whose
+// supported methods are not known yet, or which informed with BOOTSTRAP
+func (acs *AcsServer) discoverRPCMethods(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId)
+	if err != nil {
+		return
+	}
+	if len(device.RPCMethods) > 0 && !hasEvent(inform, EventBootstrap) {
+		return
+	}
+
+	session.mutex.Lock()
+	session.PendingRPCs = append(session.PendingRPCs, &GetRPCMethods{})
+	session.mutex.Unlock()
+	logging.Debugf("Queued GetRPCMethods for device %s", session.DeviceId)
+}
@@ -0,0 +1,254 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// Session states as stored in the session collection
+const (
+	SessionStateNameNew              = "new"
+	SessionStateNameInform           = "inform"
+	SessionStateNameActive           = "active"
+	SessionStateNameAwaitingResponse = "awaiting_response"
+	SessionStateNameClosed           = "closed"
+)
+
+// Reasons a session was closed for
+const (
+	SessionCloseCompleted   = "completed"
+	SessionCloseAbandoned   = "abandoned"
+	SessionCloseExpired     = "expired"
+	SessionCloseInterrupted = "interrupted"
+)
+
+// sessionJanitorInterval is how often idle sessions are expired
+const sessionJanitorInterval = 10 * time.Second
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionStateNew:
+		return SessionStateNameNew
+	case SessionStateInform:
+		return SessionStateNameInform
+	case SessionStateActive:
+		return SessionStateNameActive
+	case SessionStateAwaitingResponse:
+		return SessionStateNameAwaitingResponse
+	case SessionStateClosed:
+		return SessionStateNameClosed
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// rpcMethodName returns the CWMP method name of a queued RPC
+func rpcMethodName(rpc interface{}) string {
+	if rpc == nil {
+		return ""
+	}
+	name := fmt.Sprintf("%T", rpc)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// persistSession stores a snapshot of the session, so that active and
+// recent sessions can be inspected from the API and CLI
+func (acs *AcsServer) persistSession(session *CwmpSession) {
+	if acs.dbH == nil {
+		return
+	}
+
+	session.mutex.RLock()
+	record := session.record()
+	session.mutex.RUnlock()
+	acs.saveSessionRecord(record)
+}
+
+// record returns the session as stored in the session collection, the
+// caller holds the session mutex
+func (s *CwmpSession) record() *db.CwmpSession {
+	dbSession := &db.CwmpSession{
+		ID:                   s.SessionId,
+		DeviceID:             s.DeviceId,
+		SessionID:            s.SessionId,
+		State:                s.State.String(),
+		CurrentRPCMethod:     rpcMethodName(s.CurrentRPC),
+		PendingRPCs:          []string{},
+		RequestIDs:           append([]string(nil), s.RequestIDs...),
+		LastActivity:         s.LastActivity,
+		ConnectionRequestURL: s.ConnectionRequestURL,
+		CwmpVersion:          cwmpVersionName(s.CwmpVersion),
+		CloseReason:          s.closeReason,
+		CreatedAt:            s.CreatedTime,
+	}
+	for _, rpc := range s.PendingRPCs {
+		dbSession.PendingRPCs = append(dbSession.PendingRPCs, rpcMethodName(rpc))
+	}
+	if s.State == SessionStateClosed {
+		closedAt := s.LastActivity
+		dbSession.ClosedAt = &closedAt
+	} else {
+		dbSession.Cookie = s.cookie
+	}
+	return dbSession
+}
+
+func (acs *AcsServer) saveSessionRecord(record *db.CwmpSession) {
+	if acs.dbH == nil || record.ID == "" {
+		return
+	}
+	if err := acs.dbH.UpsertCwmpSession(record); err != nil {
+		log.Printf("Error storing session %s of device %s: %v", record.SessionID, record.DeviceID, err)
+	}
+}
+
+// sessionTimeout returns how long a session may stay idle
+func (acs *AcsServer) sessionTimeout() time.Duration {
+	return time.Duration(acs.cfg.sessionTimeout) * time.Second
+}
+
+// expireSessions periodically closes the sessions the CPE stopped talking
+// in and forgets the closed ones with nothing queued
+func (acs *AcsServer) expireSessions() {
+	ticker := time.NewTicker(sessionJanitorInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		acs.expireIdleSessions(acs.sessionTimeout())
+	}
+}
+
+// expireIdleSessions closes the sessions idle for longer than timeout. The
+// RPC the device did not answer is failed, RPCs still queued are kept for
+// its next session.
+func (acs *AcsServer) expireIdleSessions(timeout time.Duration) {
+	acs.mutex.RLock()
+	sessions := make([]*CwmpSession, 0, len(acs.sessions))
+	for _, session := range acs.sessions {
+		sessions = append(sessions, session)
+	}
+	acs.mutex.RUnlock()
+
+	for _, session := range sessions {
+		session.mutex.RLock()
+		idle := time.Since(session.LastActivity) > timeout
+		state := session.State
+		session.mutex.RUnlock()
+		if !idle {
+			continue
+		}
+
+		if state == SessionStateClosed {
+			acs.forgetSession(session)
+			continue
+		}
+		log.Printf("Expiring session %s of device %s idle in state %s", session.SessionId, session.DeviceId, state)
+		acs.abortSession(session, SessionCloseExpired)
+	}
+}
+
+// abortSession closes a session the CPE did not end. The RPC the device did
+// not answer is failed, RPCs still queued are kept for its next session.
+func (acs *AcsServer) abortSession(session *CwmpSession, reason string) {
+	session.mutex.RLock()
+	awaiting := session.CurrentRPC != nil
+	session.mutex.RUnlock()
+	if awaiting {
+		acs.failCurrentRPC(session, errSessionDropped)
+	}
+	session.mutex.Lock()
+	session.State = SessionStateClosed
+	session.closeReason = reason
+	session.CurrentRPC = nil
+	session.currentRequestID = ""
+	session.currentCommandID = ""
+	session.mutex.Unlock()
+	acs.persistSession(session)
+	acs.unbindConnSession(session)
+	acs.verifyCredentialRotations(session)
+}
+
+// forgetSession removes a closed session from memory unless RPCs are
+// queued in it for the next session of the device
+func (acs *AcsServer) forgetSession(session *CwmpSession) {
+	acs.mutex.Lock()
+	defer acs.mutex.Unlock()
+	session.mutex.RLock()
+	defer session.mutex.RUnlock()
+
+	if session.State != SessionStateClosed || len(session.PendingRPCs) > 0 || session.bootstrap != nil || session.diagnostic != nil || session.provisioning != nil || session.refresh != nil {
+		return
+	}
+	if acs.sessions[session.DeviceId] == session {
+		delete(acs.sessions, session.DeviceId)
+	}
+}
+
+// restoreSessions reloads the sessions which were open when the ACS
+// stopped. Those still within the session timeout are resumed with their
+// cookie, although the RPC they awaited is lost, the others are closed.
+func (acs *AcsServer) restoreSessions() {
+	records, err := acs.dbH.GetOpenCwmpSessions()
+	if err != nil {
+		log.Printf("Error loading open sessions: %v", err)
+		return
+	}
+
+	restored := 0
+	for i := range records {
+		record := &records[i]
+		if record.Cookie == "" || time.Since(record.LastActivity) > acs.sessionTimeout() {
+			now := time.Now()
+			record.State = SessionStateNameClosed
+			record.CloseReason = SessionCloseInterrupted
+			record.Cookie = ""
+			record.ClosedAt = &now
+			acs.saveSessionRecord(record)
+			continue
+		}
+
+		version, ok := parseCwmpVersion("urn:dslforum-org:" + record.CwmpVersion)
+		if !ok {
+			version = defaultCwmpVersion
+		}
+		session := &CwmpSession{
+			DeviceId:             record.DeviceID,
+			SessionId:            record.SessionID,
+			CreatedTime:          record.CreatedAt,
+			LastActivity:         record.LastActivity,
+			MaxEnvelopes:         1,
+			CwmpVersion:          version,
+			State:                SessionStateActive,
+			PendingRPCs:          make([]interface{}, 0),
+			RequestIDs:           record.RequestIDs,
+			ConnectionRequestURL: record.ConnectionRequestURL,
+			cookie:               record.Cookie,
+		}
+		acs.mutex.Lock()
+		acs.sessions[session.DeviceId] = session
+		acs.connSessions[session.cookie] = session
+		acs.mutex.Unlock()
+		acs.loadQueuedCommands(session)
+		restored++
+	}
+	if len(records) > 0 {
+		log.Printf("Restored %d of %d session(s) open before the restart", restored, len(records))
+	}
+}
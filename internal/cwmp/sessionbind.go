@@ -0,0 +1,186 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultSessionCookie is the cookie carrying the session token when the
+// configuration names none
+const defaultSessionCookie = "CWMPSESSIONID"
+
+// sessionCookieName returns the name of the cookie binding a CPE's requests
+// to its session
+func (acs *AcsServer) sessionCookieName() string {
+	if acs.config != nil && acs.config.Protocols.CWMP.Sessions.CookieName != "" {
+		return acs.config.Protocols.CWMP.Sessions.CookieName
+	}
+	return defaultSessionCookie
+}
+
+// requireSessionCookie reports whether requests following the Inform must
+// present the session cookie, otherwise they are also matched by the CPE
+// connection address
+func (acs *AcsServer) requireSessionCookie() bool {
+	return acs.config != nil && acs.config.Protocols.CWMP.Sessions.RequireCookie
+}
+
+// bindConnSession generates the session cookie and associates it with the
+// session, so that subsequent requests of the session can be matched to
+// the device. A token issued for a previous session of the device is
+// revoked.
+func (acs *AcsServer) bindConnSession(r *http.Request, session *CwmpSession) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Error generating session cookie for device %s: %v", session.DeviceId, err)
+	}
+	token := hex.EncodeToString(secret)
+
+	session.mutex.Lock()
+	previous := session.cookie
+	session.cookie = token
+	session.remoteAddr = r.RemoteAddr
+	session.mutex.Unlock()
+
+	acs.mutex.Lock()
+	if previous != "" {
+		delete(acs.connSessions, previous)
+	}
+	acs.connSessions[token] = session
+	if !acs.requireSessionCookie() {
+		acs.connSessions[r.RemoteAddr] = session
+	}
+	acs.mutex.Unlock()
+}
+
+// setSessionCookie issues the cookie of the session with the InformResponse
+func (acs *AcsServer) setSessionCookie(w http.ResponseWriter, r *http.Request, session *CwmpSession) {
+	session.mutex.RLock()
+	token := session.cookie
+	session.mutex.RUnlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     acs.sessionCookieName(),
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	})
+}
+
+// getConnSession returns the session the request belongs to, from its
+// session cookie or, if cookies are not required, from the CPE connection
+func (acs *AcsServer) getConnSession(r *http.Request) *CwmpSession {
+	acs.mutex.RLock()
+	defer acs.mutex.RUnlock()
+	if cookie, err := r.Cookie(acs.sessionCookieName()); err == nil {
+		if session := acs.connSessions[cookie.Value]; session != nil && session.hasCookie(cookie.Value) {
+			return session
+		}
+	}
+	if acs.requireSessionCookie() {
+		return nil
+	}
+	return acs.connSessions[r.RemoteAddr]
+}
+
+// unbindConnSession revokes the session cookie once the session is over
+func (acs *AcsServer) unbindConnSession(session *CwmpSession) {
+	session.mutex.Lock()
+	token, remoteAddr := session.cookie, session.remoteAddr
+	session.cookie = ""
+	session.remoteAddr = ""
+	session.mutex.Unlock()
+
+	acs.mutex.Lock()
+	defer acs.mutex.Unlock()
+	if token != "" && acs.connSessions[token] == session {
+		delete(acs.connSessions, token)
+	}
+	if remoteAddr != "" && acs.connSessions[remoteAddr] == session {
+		delete(acs.connSessions, remoteAddr)
+	}
+}
+
+// rejectSessionless answers 403 to a request following the Inform which
+// does not belong to a session, when the session cookie is required
+func (acs *AcsServer) rejectSessionless(w http.ResponseWriter, r *http.Request, session *CwmpSession) bool {
+	if session != nil || !acs.requireSessionCookie() {
+		return false
+	}
+	log.Printf("Rejecting request of %s without a valid session cookie", r.RemoteAddr)
+	http.Error(w, "CWMP session cookie required", http.StatusForbidden)
+	return true
+}
+
+// hasCookie reports whether token is the cookie of the current session
+func (s *CwmpSession) hasCookie(token string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return token != "" && s.cookie == token
+}
+
+// isActive reports whether the session is open and the CPE was heard from
+// within the session timeout
+func (s *CwmpSession) isActive(timeout time.Duration) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.State != SessionStateClosed && s.State != SessionStateNew && time.Since(s.LastActivity) < timeout
+}
+
+// checkSessionLimits rejects the Inform opening a session once the ACS or
+// the device reached its maximum of concurrent sessions. The ACS runs at
+// most one session per device, a limit of 1 per device refuses a new
+// Inform while the previous session is still active instead of abandoning
+// it.
+func (acs *AcsServer) checkSessionLimits(deviceId string) error {
+	if acs.config == nil {
+		return nil
+	}
+	limits := acs.config.Protocols.CWMP.Sessions
+	if limits.MaxConcurrent <= 0 && limits.MaxPerDevice <= 0 {
+		return nil
+	}
+	timeout := time.Duration(acs.cfg.sessionTimeout) * time.Second
+
+	acs.mutex.RLock()
+	active, deviceActive := 0, 0
+	for id, session := range acs.sessions {
+		if !session.isActive(timeout) {
+			continue
+		}
+		active++
+		if id == deviceId {
+			deviceActive++
+		}
+	}
+	acs.mutex.RUnlock()
+
+	if limits.MaxPerDevice > 0 && deviceActive >= limits.MaxPerDevice {
+		log.Printf("Rejecting Inform of device %s, its session is still active", deviceId)
+		return &AcsFault{Code: AcsFaultResourcesExceeded, Message: "Device session limit exceeded"}
+	}
+	// A session replacing one of the device does not add to the total
+	if limits.MaxConcurrent > 0 && active-deviceActive >= limits.MaxConcurrent {
+		log.Printf("Rejecting Inform of device %s, %d sessions are active", deviceId, active)
+		return &AcsFault{Code: AcsFaultResourcesExceeded, Message: "Session limit exceeded"}
+	}
+	return nil
+}
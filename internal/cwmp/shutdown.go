@@ -0,0 +1,84 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	// defaultDrainTimeout is how long Stop waits for open sessions to end
+	// unless configured
+	defaultDrainTimeout = 30 * time.Second
+	// drainPollInterval is how often Stop checks whether the sessions ended
+	drainPollInterval = 250 * time.Millisecond
+	// shutdownRetryAfter is the Retry-After of the Informs refused while the
+	// ACS shuts down
+	shutdownRetryAfter = "60"
+)
+
+// errShuttingDown refuses the Informs received while the ACS drains its
+// sessions, the CPE retries with another instance or after the restart
+var errShuttingDown = errors.New("ACS is shutting down")
+
+// drainTimeout returns how long Stop waits for open sessions to end
+func (acs *AcsServer) drainTimeout() time.Duration {
+	if acs.config != nil && acs.config.Protocols.CWMP.DrainTimeout > 0 {
+		return acs.config.Protocols.CWMP.DrainTimeout
+	}
+	return defaultDrainTimeout
+}
+
+// drainSessions stops accepting Informs and waits up to timeout for the open
+// sessions to end. The sessions still open then are interrupted: the RPC
+// awaiting a response is failed so that its command is retried, and the
+// queued commands stay in the outbound queue for the next session.
+func (acs *AcsServer) drainSessions(timeout time.Duration) {
+	acs.draining.Store(true)
+
+	deadline := time.Now().Add(timeout)
+	open := acs.openSessions()
+	if len(open) > 0 {
+		log.Printf("Waiting up to %s for %d open session(s) to end", timeout, len(open))
+	}
+	for len(open) > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+		open = acs.openSessions()
+	}
+
+	for _, session := range open {
+		log.Printf("Interrupting session %s of device %s on shutdown", session.SessionId, session.DeviceId)
+		acs.abortSession(session, SessionCloseInterrupted)
+	}
+}
+
+// openSessions returns the sessions which are not closed yet
+func (acs *AcsServer) openSessions() []*CwmpSession {
+	acs.mutex.RLock()
+	defer acs.mutex.RUnlock()
+
+	var open []*CwmpSession
+	for _, session := range acs.sessions {
+		session.mutex.RLock()
+		closed := session.State == SessionStateClosed
+		session.mutex.RUnlock()
+		if !closed {
+			open = append(open, session)
+		}
+	}
+	return open
+}
@@ -0,0 +1,570 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+)
+
+// simModel is a product line of simulated CPEs
+type simModel struct {
+	manufacturer string
+	oui          string
+	productClass string
+	modelName    string
+	hardware     string
+	versions     []string
+}
+
+// simModels are the product lines of the simulated CPEs by data model
+var simModels = map[string]simModel{
+	datamodel.RootTR181: {
+		manufacturer: "OpenUSP Simulated", oui: "0050C2", productClass: "HGW",
+		modelName: "SimGateway 200", hardware: "2.0", versions: []string{"2.1.0", "2.1.3", "2.2.0"},
+	},
+	datamodel.RootTR098: {
+		manufacturer: "OpenUSP Simulated", oui: "0050C2", productClass: "IGD",
+		modelName: "SimGateway 100", hardware: "1.1", versions: []string{"1.4.2", "1.5.0", "1.5.1"},
+	},
+}
+
+// simRPCMethods are the RPCs the simulated CPEs support
+var simRPCMethods = []string{
+	"GetRPCMethods", "GetParameterNames", "GetParameterValues", "SetParameterValues",
+	"SetParameterAttributes", "AddObject", "DeleteObject", "Reboot", "FactoryReset",
+	"Download", "Upload",
+}
+
+// simParam is a parameter of a simulated CPE
+type simParam struct {
+	value    string
+	typ      string
+	writable bool
+}
+
+// simTransfer is a Download or Upload the CPE reports with a
+// TransferComplete
+type simTransfer struct {
+	commandKey string
+	event      string
+	started    time.Time
+}
+
+// simDevice is a simulated CPE. Its state is only used by the goroutine
+// running its sessions.
+type simDevice struct {
+	id         DeviceIdStruct
+	root       string
+	index      int
+	connReqURL string
+	interval   time.Duration
+	params     map[string]*simParam
+	events     []EventStruct
+	retries    uint32
+	bootTime   time.Time
+	transfer   *simTransfer
+	reboot     *EventStruct // Reboot requested in the session
+	reset      bool         // FactoryReset requested in the session
+	kick       chan struct{}
+	client     *http.Client
+	transport  *http.Transport
+}
+
+func newSimDevice(index int, serial string, root string, connReqURL string, interval time.Duration) *simDevice {
+	model := simModels[root]
+	d := &simDevice{
+		id: DeviceIdStruct{
+			Manufacturer: model.manufacturer,
+			OUI:          model.oui,
+			ProductClass: model.productClass,
+			SerialNumber: serial,
+		},
+		root:       root,
+		index:      index,
+		connReqURL: connReqURL,
+		interval:   interval,
+		kick:       make(chan struct{}, 1),
+	}
+	d.client, d.transport = newSimClient()
+	d.factoryReset()
+	return d
+}
+
+// factoryReset restores the parameters of the CPE, which bootstraps again
+func (d *simDevice) factoryReset() {
+	d.params = simParameters(d.root, d.index, d.id.SerialNumber, d.connReqURL, d.interval)
+	d.bootTime = time.Now()
+	d.events = nil
+	d.addEvent(EventBootstrap, "")
+	d.addEvent(EventBoot, "")
+}
+
+// simParameters builds the parameter tree of a CPE. Values derived from the
+// index make the CPEs differ from one another.
+func simParameters(root string, index int, serial string, connReqURL string, interval time.Duration) map[string]*simParam {
+	model := simModels[root]
+	params := map[string]*simParam{}
+	set := func(path string, value string, typ string, writable bool) {
+		params[root+path] = &simParam{value: value, typ: typ, writable: writable}
+	}
+	str, boolean, unsigned := "xsd:string", "xsd:boolean", "xsd:unsignedInt"
+	mac := func(n int) string {
+		return fmt.Sprintf("02:50:C2:%02X:%02X:%02X", (index>>8)&0xff, index&0xff, n)
+	}
+	wanIP := fmt.Sprintf("100.64.%d.%d", (index/250)%250, index%250+2)
+
+	set("DeviceInfo.Manufacturer", model.manufacturer, str, false)
+	set("DeviceInfo.ManufacturerOUI", model.oui, str, false)
+	set("DeviceInfo.ModelName", model.modelName, str, false)
+	set("DeviceInfo.ProductClass", model.productClass, str, false)
+	set("DeviceInfo.SerialNumber", serial, str, false)
+	set("DeviceInfo.HardwareVersion", model.hardware, str, false)
+	set("DeviceInfo.SoftwareVersion", model.versions[index%len(model.versions)], str, false)
+	set("DeviceInfo.ProvisioningCode", "", str, true)
+	set("DeviceInfo.UpTime", "0", unsigned, false)
+	set("ManagementServer.URL", "", str, true)
+	set("ManagementServer.PeriodicInformEnable", "true", boolean, true)
+	set("ManagementServer.PeriodicInformInterval", strconv.Itoa(int(interval.Seconds())), unsigned, true)
+	set("ManagementServer.ParameterKey", "", str, false)
+	set("ManagementServer.ConnectionRequestURL", connReqURL, str, false)
+	set("ManagementServer.ConnectionRequestUsername", "", str, true)
+	set("ManagementServer.ConnectionRequestPassword", "", str, true)
+	set("Time.Enable", "true", boolean, true)
+	set("Time.NTPServer1", "pool.ntp.org", str, true)
+	set("Time.LocalTimeZone", "UTC", str, true)
+
+	hosts := index%4 + 1
+	if root == datamodel.RootTR098 {
+		wan := "WANDevice.1.WANConnectionDevice.1.WANIPConnection.1."
+		set(wan+"Enable", "true", boolean, true)
+		set(wan+"ConnectionStatus", "Connected", str, false)
+		set(wan+"AddressingType", "DHCP", str, true)
+		set(wan+"ExternalIPAddress", wanIP, str, false)
+		set(wan+"SubnetMask", "255.192.0.0", str, false)
+		set(wan+"DefaultGateway", "100.64.0.1", str, false)
+		set(wan+"MACAddress", mac(0), str, false)
+		for i, band := range []string{"2.4GHz", "5GHz"} {
+			wlan := fmt.Sprintf("LANDevice.1.WLANConfiguration.%d.", i+1)
+			set(wlan+"Enable", "true", boolean, true)
+			set(wlan+"SSID", fmt.Sprintf("Sim-%s-%s", serial, band), str, true)
+			set(wlan+"Channel", []string{"6", "36"}[i], unsigned, true)
+			set(wlan+"AutoChannelEnable", "true", boolean, true)
+			set(wlan+"BeaconType", "11i", str, true)
+			set(wlan+"KeyPassphrase", "", str, true)
+			set(wlan+"SSIDAdvertisementEnabled", "true", boolean, true)
+			set(wlan+"TotalAssociations", strconv.Itoa(hosts/(i+1)), unsigned, false)
+		}
+		set("LANDevice.1.Hosts.HostNumberOfEntries", strconv.Itoa(hosts), unsigned, false)
+		for i := 1; i <= hosts; i++ {
+			host := fmt.Sprintf("LANDevice.1.Hosts.Host.%d.", i)
+			set(host+"HostName", fmt.Sprintf("host-%d", i), str, false)
+			set(host+"IPAddress", fmt.Sprintf("192.168.1.%d", 100+i), str, false)
+			set(host+"MACAddress", mac(i), str, false)
+			set(host+"Active", "true", boolean, false)
+		}
+		return params
+	}
+
+	set("IP.Interface.1.Enable", "true", boolean, true)
+	set("IP.Interface.1.Name", "wan", str, false)
+	set("IP.Interface.1.Status", "Up", str, false)
+	set("IP.Interface.1.IPv4Address.1.IPAddress", wanIP, str, false)
+	set("IP.Interface.1.IPv4Address.1.SubnetMask", "255.192.0.0", str, false)
+	set("IP.Interface.1.IPv4Address.1.AddressingType", "DHCP", str, false)
+	set("Routing.Router.1.IPv4Forwarding.1.GatewayIPAddress", "100.64.0.1", str, false)
+	set("Ethernet.Link.1.MACAddress", mac(0), str, false)
+	for i, band := range []string{"2.4GHz", "5GHz"} {
+		n := i + 1
+		set(fmt.Sprintf("WiFi.Radio.%d.Enable", n), "true", boolean, true)
+		set(fmt.Sprintf("WiFi.Radio.%d.OperatingFrequencyBand", n), band, str, false)
+		set(fmt.Sprintf("WiFi.Radio.%d.Channel", n), []string{"6", "36"}[i], unsigned, true)
+		set(fmt.Sprintf("WiFi.Radio.%d.AutoChannelEnable", n), "true", boolean, true)
+		set(fmt.Sprintf("WiFi.SSID.%d.Enable", n), "true", boolean, true)
+		set(fmt.Sprintf("WiFi.SSID.%d.SSID", n), fmt.Sprintf("Sim-%s-%s", serial, band), str, true)
+		set(fmt.Sprintf("WiFi.SSID.%d.Status", n), "Up", str, false)
+		set(fmt.Sprintf("WiFi.AccessPoint.%d.SSIDAdvertisementEnabled", n), "true", boolean, true)
+		set(fmt.Sprintf("WiFi.AccessPoint.%d.Security.ModeEnabled", n), "WPA2-Personal", str, true)
+		set(fmt.Sprintf("WiFi.AccessPoint.%d.Security.KeyPassphrase", n), "", str, true)
+		set(fmt.Sprintf("WiFi.AccessPoint.%d.AssociatedDeviceNumberOfEntries", n), strconv.Itoa(hosts/n), unsigned, false)
+	}
+	set("Hosts.HostNumberOfEntries", strconv.Itoa(hosts), unsigned, false)
+	for i := 1; i <= hosts; i++ {
+		host := fmt.Sprintf("Hosts.Host.%d.", i)
+		set(host+"HostName", fmt.Sprintf("host-%d", i), str, false)
+		set(host+"IPAddress", fmt.Sprintf("192.168.1.%d", 100+i), str, false)
+		set(host+"PhysAddress", mac(i), str, false)
+		set(host+"Active", "true", boolean, false)
+	}
+	return params
+}
+
+// addEvent records an event for the next Inform, once
+func (d *simDevice) addEvent(code string, commandKey string) {
+	for _, e := range d.events {
+		if e.EventCode == code && e.CommandKey == commandKey {
+			return
+		}
+	}
+	d.events = append(d.events, EventStruct{EventCode: code, CommandKey: commandKey})
+}
+
+// informInterval is the periodic Inform interval set on the CPE, it waits
+// for connection requests only when periodic Informs are disabled
+func (d *simDevice) informInterval() time.Duration {
+	if p := d.params[d.root+"ManagementServer.PeriodicInformEnable"]; p != nil && !isTrue(p.value) {
+		return 24 * time.Hour
+	}
+	if p := d.params[d.root+"ManagementServer.PeriodicInformInterval"]; p != nil {
+		if seconds, err := strconv.Atoi(p.value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return d.interval
+}
+
+// inform builds the Inform opening a session
+func (d *simDevice) inform() *SOAPEnvelope {
+	d.params[d.root+"DeviceInfo.UpTime"].value = strconv.Itoa(int(time.Since(d.bootTime).Seconds()))
+	wanIP := "IP.Interface.1.IPv4Address.1.IPAddress"
+	if d.root == datamodel.RootTR098 {
+		wanIP = "WANDevice.1.WANConnectionDevice.1.WANIPConnection.1.ExternalIPAddress"
+	}
+	inform := &Inform{
+		DeviceId:     d.id,
+		Event:        d.events,
+		MaxEnvelopes: 1,
+		CurrentTime:  time.Now(),
+		RetryCount:   d.retries,
+	}
+	for _, path := range []string{
+		"DeviceInfo.HardwareVersion", "DeviceInfo.SoftwareVersion", "DeviceInfo.ProvisioningCode",
+		"ManagementServer.ConnectionRequestURL", "ManagementServer.ParameterKey", wanIP,
+	} {
+		p := d.params[d.root+path]
+		inform.ParameterList = append(inform.ParameterList, ParameterValueStruct{Name: d.root + path, Value: p.value, Type: p.typ})
+	}
+	return simEnvelope("", inform)
+}
+
+// completeSession carries out the Reboot or FactoryReset of the session,
+// and reports a completed transfer with a new session
+func (d *simDevice) completeSession() {
+	switch {
+	case d.reset:
+		d.reset, d.reboot = false, nil
+		d.factoryReset()
+	case d.reboot != nil:
+		d.bootTime = time.Now()
+		d.addEvent(EventBoot, "")
+		d.addEvent(d.reboot.EventCode, d.reboot.CommandKey)
+		d.reboot = nil
+	}
+	if d.transfer != nil {
+		d.addEvent(EventTransferComplete, "")
+		d.addEvent(d.transfer.event, d.transfer.commandKey)
+	}
+}
+
+// transferComplete reports the completed transfer
+func (d *simDevice) transferComplete() *SOAPEnvelope {
+	return simEnvelope("", &TransferComplete{
+		CommandKey:   d.transfer.commandKey,
+		StartTime:    d.transfer.started,
+		CompleteTime: time.Now(),
+	})
+}
+
+// handleRPC answers an RPC of the ACS
+func (d *simDevice) handleRPC(req *soapRequest) *SOAPEnvelope {
+	id := req.Header.ID
+	var response interface{}
+	var fault *CWMPFault
+	switch req.Method {
+	case "GetRPCMethods":
+		response = &GetRPCMethodsResponse{MethodList: simRPCMethods}
+	case "GetParameterNames":
+		var rpc GetParameterNames
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			response, fault = d.getParameterNames(&rpc)
+		}
+	case "GetParameterValues":
+		var rpc GetParameterValues
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			response, fault = d.getParameterValues(&rpc)
+		}
+	case "SetParameterValues":
+		var rpc SetParameterValues
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			response, fault = d.setParameterValues(&rpc)
+		}
+	case "SetParameterAttributes":
+		response = &SetParameterAttributesResponse{}
+	case "AddObject":
+		var rpc AddObject
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			response, fault = d.addObject(&rpc)
+		}
+	case "DeleteObject":
+		var rpc DeleteObject
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			response, fault = d.deleteObject(&rpc)
+		}
+	case "Reboot":
+		var rpc Reboot
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			d.reboot = &EventStruct{EventCode: "M Reboot", CommandKey: rpc.CommandKey}
+			response = &RebootResponse{}
+		}
+	case "FactoryReset":
+		d.reset = true
+		response = &FactoryResetResponse{}
+	case "Download":
+		var rpc Download
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			d.transfer = &simTransfer{commandKey: rpc.CommandKey, event: EventMDownload, started: time.Now()}
+			if rpc.FileType == FileTypeFirmwareUpgrade {
+				d.reboot = &EventStruct{EventCode: EventMDownload, CommandKey: rpc.CommandKey}
+			}
+			response = &DownloadResponse{Status: 1}
+		}
+	case "Upload":
+		var rpc Upload
+		if fault = decodeSimRPC(req, &rpc); fault == nil {
+			d.transfer = &simTransfer{commandKey: rpc.CommandKey, event: EventMUpload, started: time.Now()}
+			response = &UploadResponse{Status: 1}
+		}
+	default:
+		fault = &CWMPFault{FaultCode: FaultMethodNotSupported, FaultString: "Method not supported: " + req.Method}
+	}
+	if fault != nil {
+		return simFaultEnvelope(id, fault)
+	}
+	return simEnvelope(id, response)
+}
+
+func decodeSimRPC(req *soapRequest, rpc interface{}) *CWMPFault {
+	if err := req.decode(rpc); err != nil {
+		return &CWMPFault{FaultCode: FaultInvalidArguments, FaultString: err.Error()}
+	}
+	return nil
+}
+
+func invalidParameterName(name string) *CWMPFault {
+	return &CWMPFault{FaultCode: FaultInvalidParameterName, FaultString: "Invalid parameter name: " + name}
+}
+
+// paramsUnder returns the sorted paths of the parameters under a partial
+// path, or the path itself if it is a parameter
+func (d *simDevice) paramsUnder(path string) []string {
+	if path == "" {
+		path = d.root
+	}
+	if !strings.HasSuffix(path, ".") {
+		if _, ok := d.params[path]; ok {
+			return []string{path}
+		}
+		return nil
+	}
+	var paths []string
+	for name := range d.params {
+		if strings.HasPrefix(name, path) {
+			paths = append(paths, name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (d *simDevice) getParameterNames(rpc *GetParameterNames) (interface{}, *CWMPFault) {
+	path := rpc.ParameterPath
+	if path == "" {
+		path = d.root
+	}
+	paths := d.paramsUnder(path)
+	if len(paths) == 0 {
+		return nil, invalidParameterName(rpc.ParameterPath)
+	}
+	if !strings.HasSuffix(path, ".") {
+		if rpc.NextLevel {
+			return nil, &CWMPFault{FaultCode: FaultInvalidArguments, FaultString: "NextLevel is true for a parameter"}
+		}
+		return &GetParameterNamesResponse{ParameterList: []ParameterInfoStruct{{Name: path, Writable: d.params[path].writable}}}, nil
+	}
+
+	response := &GetParameterNamesResponse{}
+	seen := map[string]bool{}
+	add := func(name string, writable bool) {
+		if !seen[name] {
+			seen[name] = true
+			response.ParameterList = append(response.ParameterList, ParameterInfoStruct{Name: name, Writable: writable})
+		}
+	}
+	if !rpc.NextLevel {
+		add(path, false)
+	}
+	for _, name := range paths {
+		rest := strings.TrimPrefix(name, path)
+		if rpc.NextLevel {
+			if i := strings.IndexByte(rest, '.'); i >= 0 {
+				add(path+rest[:i+1], false)
+			} else {
+				add(name, d.params[name].writable)
+			}
+			continue
+		}
+		for i := range rest {
+			if rest[i] == '.' {
+				add(path+rest[:i+1], false)
+			}
+		}
+		add(name, d.params[name].writable)
+	}
+	return response, nil
+}
+
+func (d *simDevice) getParameterValues(rpc *GetParameterValues) (interface{}, *CWMPFault) {
+	d.params[d.root+"DeviceInfo.UpTime"].value = strconv.Itoa(int(time.Since(d.bootTime).Seconds()))
+	response := &GetParameterValuesResponse{}
+	for _, name := range rpc.ParameterNames {
+		paths := d.paramsUnder(name)
+		if len(paths) == 0 {
+			return nil, invalidParameterName(name)
+		}
+		for _, path := range paths {
+			p := d.params[path]
+			response.ParameterList = append(response.ParameterList, ParameterValueStruct{Name: path, Value: p.value, Type: p.typ})
+		}
+	}
+	return response, nil
+}
+
+// setParameterValues applies all the values or none, a fault lists the
+// parameters which failed
+func (d *simDevice) setParameterValues(rpc *SetParameterValues) (interface{}, *CWMPFault) {
+	fault := &CWMPFault{FaultCode: FaultInvalidArguments, FaultString: "Invalid arguments"}
+	for _, pv := range rpc.ParameterList {
+		p, ok := d.params[pv.Name]
+		switch {
+		case !ok:
+			fault.SetParameterValuesFault = append(fault.SetParameterValuesFault, SetParameterValuesFault{
+				ParameterName: pv.Name, FaultCode: FaultInvalidParameterName, FaultString: "Invalid parameter name",
+			})
+		case !p.writable:
+			fault.SetParameterValuesFault = append(fault.SetParameterValuesFault, SetParameterValuesFault{
+				ParameterName: pv.Name, FaultCode: FaultAttemptToSetNonWritableParameter, FaultString: "Parameter is not writable",
+			})
+		case !validSimValue(p.typ, pv.Value):
+			fault.SetParameterValuesFault = append(fault.SetParameterValuesFault, SetParameterValuesFault{
+				ParameterName: pv.Name, FaultCode: FaultInvalidParameterValue, FaultString: "Invalid value for " + p.typ,
+			})
+		}
+	}
+	if len(fault.SetParameterValuesFault) > 0 {
+		return nil, fault
+	}
+	for _, pv := range rpc.ParameterList {
+		d.params[pv.Name].value = pv.Value
+	}
+	d.params[d.root+"ManagementServer.ParameterKey"].value = rpc.ParameterKey
+	return &SetParameterValuesResponse{Status: 0}, nil
+}
+
+func validSimValue(typ string, value string) bool {
+	var err error
+	switch typ {
+	case "xsd:boolean":
+		switch value {
+		case "true", "false", "1", "0":
+		default:
+			return false
+		}
+	case "xsd:unsignedInt":
+		_, err = strconv.ParseUint(value, 10, 32)
+	case "xsd:int":
+		_, err = strconv.ParseInt(value, 10, 32)
+	}
+	return err == nil
+}
+
+func isTrue(value string) bool {
+	return value == "true" || value == "1"
+}
+
+// instances returns the instance numbers of a multi-instance object
+func (d *simDevice) instances(object string) []int {
+	seen := map[int]bool{}
+	var numbers []int
+	for name := range d.params {
+		rest := strings.TrimPrefix(name, object)
+		if rest == name {
+			continue
+		}
+		end := strings.IndexByte(rest, '.')
+		if end <= 0 {
+			continue
+		}
+		if n, err := strconv.Atoi(rest[:end]); err == nil && !seen[n] {
+			seen[n] = true
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// addObject creates an instance with the parameters of the first one
+func (d *simDevice) addObject(rpc *AddObject) (interface{}, *CWMPFault) {
+	if !strings.HasSuffix(rpc.ObjectName, ".") {
+		return nil, invalidParameterName(rpc.ObjectName)
+	}
+	numbers := d.instances(rpc.ObjectName)
+	if len(numbers) == 0 {
+		return nil, invalidParameterName(rpc.ObjectName)
+	}
+	instance := numbers[len(numbers)-1] + 1
+	template := fmt.Sprintf("%s%d.", rpc.ObjectName, numbers[0])
+	created := fmt.Sprintf("%s%d.", rpc.ObjectName, instance)
+	for _, name := range d.paramsUnder(template) {
+		p := *d.params[name]
+		d.params[created+strings.TrimPrefix(name, template)] = &p
+	}
+	d.params[d.root+"ManagementServer.ParameterKey"].value = rpc.ParameterKey
+	return &AddObjectResponse{InstanceNumber: uint32(instance), Status: 0}, nil
+}
+
+// deleteObject deletes an instance of a multi-instance object
+func (d *simDevice) deleteObject(rpc *DeleteObject) (interface{}, *CWMPFault) {
+	object := strings.TrimSuffix(rpc.ObjectName, ".")
+	instance := object[strings.LastIndexByte(object, '.')+1:]
+	if _, err := strconv.Atoi(instance); err != nil || object == rpc.ObjectName {
+		return nil, invalidParameterName(rpc.ObjectName)
+	}
+	paths := d.paramsUnder(rpc.ObjectName)
+	if len(paths) == 0 {
+		return nil, invalidParameterName(rpc.ObjectName)
+	}
+	for _, name := range paths {
+		delete(d.params, name)
+	}
+	d.params[d.root+"ManagementServer.ParameterKey"].value = rpc.ParameterKey
+	return &DeleteObjectResponse{Status: 0}, nil
+}
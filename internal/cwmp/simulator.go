@@ -0,0 +1,326 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/datamodel"
+	"github.com/n4-networks/openusp/internal/logging"
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Defaults of the simulated CPEs
+const (
+	simDefaultSerialPrefix = "SIM"
+	simDataModelMixed      = "mixed"
+	// simStartSpread spreads the first Informs of the CPEs
+	simStartSpread = 30 * time.Second
+	// simRetryDelay is how long a CPE waits before retrying a failed
+	// session, randomized by up to the same delay
+	simRetryDelay  = 30 * time.Second
+	simHTTPTimeout = 30 * time.Second
+	// simMaxResponseSize bounds the ACS responses read by a CPE
+	simMaxResponseSize = 4 << 20
+)
+
+// simulator runs simulated CPEs which inform the ACS periodically, answer
+// its RPCs and accept connection requests, as real CPEs would
+type simulator struct {
+	acsURL   string
+	interval time.Duration
+	devices  map[string]*simDevice // by serial number
+	server   *http.Server          // connection requests
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// startSimulation starts the simulated CPEs configured for development
+func (acs *AcsServer) startSimulation() error {
+	cfg := acs.config.Protocols.CWMP.Simulation
+	if cfg.Devices <= 0 {
+		return nil
+	}
+	interval := cfg.InformInterval
+	if interval <= 0 {
+		interval = time.Duration(acs.cfg.informInterval) * time.Second
+	}
+	acsURL := acs.config.Protocols.CWMP.URL
+	if acsURL == "" {
+		acsURL = "http://localhost:" + acs.cfg.httpPort + "/cwmp"
+	}
+
+	sim, err := newSimulator(cfg, acsURL, interval)
+	if err != nil {
+		return err
+	}
+	acs.sim = sim
+	sim.start()
+	log.Printf("Started %d simulated CPE(s) informing %s every %s", len(sim.devices), acsURL, interval)
+	return nil
+}
+
+// stopSimulation stops the simulated CPEs, their open sessions are
+// interrupted
+func (acs *AcsServer) stopSimulation() {
+	if acs.sim != nil {
+		acs.sim.close()
+	}
+}
+
+func newSimulator(cfg config.SimulationConfig, acsURL string, interval time.Duration) (*simulator, error) {
+	prefix := cfg.SerialPrefix
+	if prefix == "" {
+		prefix = simDefaultSerialPrefix
+	}
+	model := strings.ToUpper(cfg.DataModel)
+	switch model {
+	case "", strings.ToUpper(simDataModelMixed):
+		model = simDataModelMixed
+	case datamodel.ModelTR181, datamodel.ModelTR098:
+	default:
+		return nil, fmt.Errorf("invalid simulation data model: %s", cfg.DataModel)
+	}
+
+	sim := &simulator{
+		acsURL:   acsURL,
+		interval: interval,
+		devices:  make(map[string]*simDevice, cfg.Devices),
+		stop:     make(chan struct{}),
+	}
+	for i := 0; i < cfg.Devices; i++ {
+		root := datamodel.RootTR181
+		if model == datamodel.ModelTR098 || (model == simDataModelMixed && i%2 == 1) {
+			root = datamodel.RootTR098
+		}
+		serial := fmt.Sprintf("%s%06d", prefix, i+1)
+		connReqURL := ""
+		if cfg.ConnectionRequestPort > 0 {
+			connReqURL = fmt.Sprintf("http://localhost:%d/%s", cfg.ConnectionRequestPort, serial)
+		}
+		sim.devices[serial] = newSimDevice(i, serial, root, connReqURL, interval)
+	}
+	if cfg.ConnectionRequestPort > 0 {
+		sim.server = &http.Server{
+			Addr:              ":" + strconv.Itoa(cfg.ConnectionRequestPort),
+			Handler:           http.HandlerFunc(sim.handleConnectionRequest),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return sim, nil
+}
+
+func (s *simulator) start() {
+	if s.server != nil {
+		ln, err := net.Listen("tcp", s.server.Addr)
+		if err != nil {
+			log.Printf("Simulated CPEs cannot accept connection requests: %v", err)
+		} else {
+			go s.server.Serve(ln)
+		}
+	}
+	for _, device := range s.devices {
+		s.wg.Add(1)
+		go s.run(device)
+	}
+}
+
+func (s *simulator) close() {
+	close(s.stop)
+	if s.server != nil {
+		s.server.Close()
+	}
+	s.wg.Wait()
+}
+
+// handleConnectionRequest starts a session of the CPE named by the path
+func (s *simulator) handleConnectionRequest(w http.ResponseWriter, r *http.Request) {
+	device := s.devices[strings.Trim(r.URL.Path, "/")]
+	if device == nil {
+		http.NotFound(w, r)
+		return
+	}
+	select {
+	case device.kick <- struct{}{}:
+	default:
+		// A session is already pending
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// run informs the ACS on boot, periodically and on connection requests.
+// The events of a failed session are kept for its retry.
+func (s *simulator) run(device *simDevice) {
+	defer s.wg.Done()
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(simStartSpread))))
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+			if len(device.events) == 0 {
+				device.addEvent(EventPeriodic, "")
+			}
+		case <-device.kick:
+			device.addEvent(EventConnectionRequest, "")
+		}
+
+		wait := device.informInterval()
+		for len(device.events) > 0 {
+			if err := s.session(device); err != nil {
+				logging.Debugf("Session of simulated CPE %s failed: %v", device.id.SerialNumber, err)
+				device.retries++
+				wait = simRetryDelay + time.Duration(rand.Int63n(int64(simRetryDelay)))
+				break
+			}
+			device.retries = 0
+			// Reboots and transfers are reported in a new session
+			device.completeSession()
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	}
+}
+
+// session runs a CWMP session: the Inform, the TransferComplete if a
+// transfer completed, then the RPCs of the ACS until it sends an empty
+// response
+func (s *simulator) session(device *simDevice) error {
+	defer device.transport.CloseIdleConnections()
+	msg, err := s.post(device, device.inform())
+	for err == nil && msg != nil {
+		var body interface{}
+		switch msg.Method {
+		case "InformResponse":
+			device.events = nil
+			if device.transfer != nil {
+				body = device.transferComplete()
+			}
+		case "TransferCompleteResponse":
+			device.transfer = nil
+		case "Fault":
+			return errors.New("ACS answered with a SOAP fault")
+		default:
+			body = device.handleRPC(msg)
+		}
+		msg, err = s.post(device, body)
+	}
+	return err
+}
+
+// post sends a message of the CPE to the ACS, an empty request if body is
+// nil, and returns the message of the ACS or nil once it ends the session
+func (s *simulator) post(device *simDevice, body interface{}) (*soapRequest, error) {
+	var payload []byte
+	if body != nil {
+		data, err := xml.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = append([]byte(xml.Header), data...)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.acsURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml; charset=utf-8")
+		req.Header.Set("SOAPAction", "")
+	}
+	res, err := device.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(res.Body, simMaxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNoContent || (res.StatusCode == http.StatusOK && len(bytes.TrimSpace(data)) == 0):
+		return nil, nil
+	case res.StatusCode == http.StatusOK, res.StatusCode == http.StatusInternalServerError:
+		// Faults of the ACS come with HTTP 500
+		msg, err := decodeSOAPRequest(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ACS message: %w", err)
+		}
+		if msg.Method == "" {
+			return nil, nil
+		}
+		return msg, nil
+	}
+	return nil, fmt.Errorf("ACS answered %s", res.Status)
+}
+
+// newSimClient returns the HTTP client of a CPE, it keeps its session
+// cookie and its own connections
+func newSimClient() (*http.Client, *http.Transport) {
+	jar, _ := cookiejar.New(nil)
+	transport := &http.Transport{MaxIdleConnsPerHost: 1, IdleConnTimeout: simHTTPTimeout}
+	return &http.Client{Jar: jar, Transport: transport, Timeout: simHTTPTimeout}, transport
+}
+
+// simEnvelope wraps a message of a CPE in a SOAP envelope, an answer echoes
+// the cwmp:ID of the ACS request
+func simEnvelope(id string, content interface{}) *SOAPEnvelope {
+	envelope := newSOAPEnvelope()
+	envelope.Header.ID = id
+	envelope.Body.Content = content
+	return envelope
+}
+
+// simFaultEnvelope answers an ACS request with a CWMP fault
+func simFaultEnvelope(id string, fault *CWMPFault) *SOAPEnvelope {
+	envelope := newSOAPEnvelope()
+	envelope.Header.ID = id
+	envelope.Body.Fault = &SOAPFault{
+		FaultCode:   "Client",
+		FaultString: "CWMP fault",
+		Detail:      &FaultDetail{CWMPFault: fault},
+	}
+	return envelope
+}
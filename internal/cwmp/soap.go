@@ -28,15 +28,23 @@ type SOAPEnvelope struct {
 	XsdNS   string   `xml:"xmlns:xsd,attr"`
 	Header  *SOAPHeader `xml:"soap:Header,omitempty"`
 	Body    SOAPBody    `xml:"soap:Body"`
+	session *CwmpSession // session opened by an Inform, its cookie is set with the response
 }
 
 type SOAPHeader struct {
 	ID                string `xml:"cwmp:ID,omitempty"`
-	HoldRequests      bool   `xml:"cwmp:HoldRequests,omitempty"`
+	HoldRequests      *HoldRequestsHeader `xml:"cwmp:HoldRequests,omitempty"`
 	NoMoreRequests    bool   `xml:"cwmp:NoMoreRequests,omitempty"`
 	SessionTimeout    uint32 `xml:"cwmp:SessionTimeout,omitempty"`
 }
 
+// HoldRequestsHeader tells the CPE not to send requests to the ACS, the
+// header must be understood by the CPE
+type HoldRequestsHeader struct {
+	MustUnderstand string `xml:"soap:mustUnderstand,attr"`
+	Value          bool   `xml:",chardata"`
+}
+
 type SOAPBody struct {
 	XMLName   xml.Name    `xml:"soap:Body"`
 	Content   interface{} `xml:",omitempty"`
@@ -56,6 +64,17 @@ type FaultDetail struct {
 type CWMPFault struct {
 	FaultCode   uint32 `xml:"FaultCode"`
 	FaultString string `xml:"FaultString"`
+	// SetParameterValuesFault lists the parameters a SetParameterValues
+	// failed on
+	SetParameterValuesFault []SetParameterValuesFault `xml:"SetParameterValuesFault,omitempty"`
+}
+
+// SetParameterValuesFault is the fault of one parameter of a
+// SetParameterValues
+type SetParameterValuesFault struct {
+	ParameterName string `xml:"ParameterName"`
+	FaultCode     uint32 `xml:"FaultCode"`
+	FaultString   string `xml:"FaultString"`
 }
 
 // TR-069 CWMP Method structures
@@ -76,6 +95,16 @@ type InformResponse struct {
 	MaxEnvelopes uint32   `xml:"MaxEnvelopes"`
 }
 
+// GetRPCMethods method, sent by both the ACS and the CPE
+type GetRPCMethods struct {
+	XMLName xml.Name `xml:"cwmp:GetRPCMethods"`
+}
+
+type GetRPCMethodsResponse struct {
+	XMLName    xml.Name `xml:"cwmp:GetRPCMethodsResponse"`
+	MethodList []string `xml:"MethodList>string"`
+}
+
 // GetParameterValues method
 type GetParameterValues struct {
 	XMLName       xml.Name `xml:"cwmp:GetParameterValues"`
@@ -99,6 +128,16 @@ type SetParameterValuesResponse struct {
 	Status  uint32   `xml:"Status"`
 }
 
+// SetParameterAttributes method
+type SetParameterAttributes struct {
+	XMLName       xml.Name                       `xml:"cwmp:SetParameterAttributes"`
+	ParameterList []SetParameterAttributesStruct `xml:"ParameterList>SetParameterAttributesStruct"`
+}
+
+type SetParameterAttributesResponse struct {
+	XMLName xml.Name `xml:"cwmp:SetParameterAttributesResponse"`
+}
+
 // GetParameterNames method
 type GetParameterNames struct {
 	XMLName       xml.Name `xml:"cwmp:GetParameterNames"`
@@ -195,6 +234,178 @@ type UploadResponse struct {
 	CompleteTime time.Time `xml:"CompleteTime"`
 }
 
+// TransferComplete method, sent by the CPE when a Download or Upload
+// completes. A FaultCode of 0 means the transfer succeeded.
+type TransferComplete struct {
+	XMLName      xml.Name  `xml:"cwmp:TransferComplete"`
+	CommandKey   string    `xml:"CommandKey"`
+	FaultStruct  CWMPFault `xml:"FaultStruct"`
+	StartTime    time.Time `xml:"StartTime"`
+	CompleteTime time.Time `xml:"CompleteTime"`
+}
+
+type TransferCompleteResponse struct {
+	XMLName xml.Name `xml:"cwmp:TransferCompleteResponse"`
+}
+
+// AutonomousTransferComplete method, sent by the CPE when a transfer it
+// was not asked for by the ACS completed
+type AutonomousTransferComplete struct {
+	XMLName        xml.Name  `xml:"cwmp:AutonomousTransferComplete"`
+	AnnounceURL    string    `xml:"AnnounceURL"`
+	TransferURL    string    `xml:"TransferURL"`
+	IsDownload     bool      `xml:"IsDownload"`
+	FileType       string    `xml:"FileType"`
+	FileSize       uint32    `xml:"FileSize"`
+	TargetFileName string    `xml:"TargetFileName"`
+	FaultStruct    CWMPFault `xml:"FaultStruct"`
+	StartTime      time.Time `xml:"StartTime"`
+	CompleteTime   time.Time `xml:"CompleteTime"`
+}
+
+type AutonomousTransferCompleteResponse struct {
+	XMLName xml.Name `xml:"cwmp:AutonomousTransferCompleteResponse"`
+}
+
+// Kicked method, sent by a CPE whose web page was kicked to the ACS by a
+// subscriber portal
+type Kicked struct {
+	XMLName xml.Name `xml:"cwmp:Kicked"`
+	Command string   `xml:"Command"`
+	Referer string   `xml:"Referer"`
+	Arg     string   `xml:"Arg"`
+	Next    string   `xml:"Next"`
+}
+
+type KickedResponse struct {
+	XMLName xml.Name `xml:"cwmp:KickedResponse"`
+	NextURL string   `xml:"NextURL"`
+}
+
+// RequestDownload method, sent by a CPE asking the ACS to start a Download
+// of a file of the given type
+type RequestDownload struct {
+	XMLName     xml.Name    `xml:"cwmp:RequestDownload"`
+	FileType    string      `xml:"FileType"`
+	FileTypeArg []ArgStruct `xml:"FileTypeArg>ArgStruct"`
+}
+
+type ArgStruct struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+type RequestDownloadResponse struct {
+	XMLName xml.Name `xml:"cwmp:RequestDownloadResponse"`
+}
+
+// ChangeDUState method (TR-157), installs, updates or uninstalls deployment
+// units. Operations holds InstallOpStruct, UpdateOpStruct and
+// UninstallOpStruct values.
+type ChangeDUState struct {
+	XMLName    xml.Name      `xml:"cwmp:ChangeDUState"`
+	Operations []interface{} `xml:"Operations>OperationStruct"`
+	CommandKey string        `xml:"CommandKey"`
+}
+
+type InstallOpStruct struct {
+	Type            string `xml:"xsi:type,attr"`
+	URL             string `xml:"URL"`
+	UUID            string `xml:"UUID"`
+	Username        string `xml:"Username"`
+	Password        string `xml:"Password"`
+	ExecutionEnvRef string `xml:"ExecutionEnvRef"`
+}
+
+type UpdateOpStruct struct {
+	Type     string `xml:"xsi:type,attr"`
+	UUID     string `xml:"UUID"`
+	Version  string `xml:"Version"`
+	URL      string `xml:"URL"`
+	Username string `xml:"Username"`
+	Password string `xml:"Password"`
+}
+
+type UninstallOpStruct struct {
+	Type            string `xml:"xsi:type,attr"`
+	UUID            string `xml:"UUID"`
+	Version         string `xml:"Version"`
+	ExecutionEnvRef string `xml:"ExecutionEnvRef"`
+}
+
+type ChangeDUStateResponse struct {
+	XMLName xml.Name `xml:"cwmp:ChangeDUStateResponse"`
+}
+
+// ChangeDUStateComplete method, sent by the CPE once it applied the
+// operations of a ChangeDUState
+type ChangeDUStateComplete struct {
+	XMLName    xml.Name         `xml:"cwmp:ChangeDUStateComplete"`
+	CommandKey string           `xml:"CommandKey"`
+	Results    []OpResultStruct `xml:"Results>OpResultStruct"`
+}
+
+// OpResultStruct is the outcome of a ChangeDUState operation, a FaultCode of
+// 0 means it succeeded
+type OpResultStruct struct {
+	UUID                 string    `xml:"UUID"`
+	DeploymentUnitRef    string    `xml:"DeploymentUnitRef"`
+	Version              string    `xml:"Version"`
+	CurrentState         string    `xml:"CurrentState"`
+	Resolved             bool      `xml:"Resolved"`
+	ExecutionUnitRefList string    `xml:"ExecutionUnitRefList"`
+	StartTime            time.Time `xml:"StartTime"`
+	CompleteTime         time.Time `xml:"CompleteTime"`
+	Fault                CWMPFault `xml:"Fault"`
+}
+
+type ChangeDUStateCompleteResponse struct {
+	XMLName xml.Name `xml:"cwmp:ChangeDUStateCompleteResponse"`
+}
+
+// States of a transfer listed by GetQueuedTransfers and GetAllQueuedTransfers
+const (
+	QueuedTransferNotStarted = 1
+	QueuedTransferInProgress = 2
+	QueuedTransferCompleted  = 3
+)
+
+// GetQueuedTransfers method, lists the transfers requested by the ACS which
+// the CPE has not completed yet
+type GetQueuedTransfers struct {
+	XMLName xml.Name `xml:"cwmp:GetQueuedTransfers"`
+}
+
+type QueuedTransferStruct struct {
+	CommandKey string `xml:"CommandKey"`
+	State      uint32 `xml:"State"`
+}
+
+type GetQueuedTransfersResponse struct {
+	XMLName      xml.Name               `xml:"cwmp:GetQueuedTransfersResponse"`
+	TransferList []QueuedTransferStruct `xml:"TransferList>QueuedTransferStruct"`
+}
+
+// GetAllQueuedTransfers method, also lists the transfers not requested by
+// the ACS
+type GetAllQueuedTransfers struct {
+	XMLName xml.Name `xml:"cwmp:GetAllQueuedTransfers"`
+}
+
+type AllQueuedTransferStruct struct {
+	CommandKey     string `xml:"CommandKey"`
+	State          uint32 `xml:"State"`
+	IsDownload     bool   `xml:"IsDownload"`
+	FileType       string `xml:"FileType"`
+	FileSize       uint32 `xml:"FileSize"`
+	TargetFileName string `xml:"TargetFileName"`
+}
+
+type GetAllQueuedTransfersResponse struct {
+	XMLName      xml.Name                  `xml:"cwmp:GetAllQueuedTransfersResponse"`
+	TransferList []AllQueuedTransferStruct `xml:"TransferList>AllQueuedTransferStruct"`
+}
+
 // Common structures
 type DeviceIdStruct struct {
 	Manufacturer  string `xml:"Manufacturer"`
@@ -214,6 +425,14 @@ type ParameterValueStruct struct {
 	Type  string `xml:"Type,attr,omitempty"`
 }
 
+type SetParameterAttributesStruct struct {
+	Name               string   `xml:"Name"`
+	NotificationChange bool     `xml:"NotificationChange"`
+	Notification       int      `xml:"Notification"`
+	AccessListChange   bool     `xml:"AccessListChange"`
+	AccessList         []string `xml:"AccessList>string"`
+}
+
 type ParameterInfoStruct struct {
 	Name     string `xml:"Name"`
 	Writable bool   `xml:"Writable"`
@@ -235,6 +454,20 @@ const (
 	EventDUStateChangeComplete = "11 DU STATE CHANGE COMPLETE"
 	EventAutonomousDUStateChangeComplete = "12 AUTONOMOUS DU STATE CHANGE COMPLETE"
 	EventWakeUp          = "13 WAKEUP"
+	// Events carrying the CommandKey of the Download or Upload reported by
+	// a 7 TRANSFER COMPLETE
+	EventMDownload = "M Download"
+	EventMUpload   = "M Upload"
+	// EventMChangeDUState carries the CommandKey of the ChangeDUState
+	// reported by a 11 DU STATE CHANGE COMPLETE
+	EventMChangeDUState = "M ChangeDUState"
+)
+
+// TR-069 parameter notification attribute values
+const (
+	NotificationOff     = 0
+	NotificationPassive = 1
+	NotificationActive  = 2
 )
 
 // TR-069 CWMP Fault codes
@@ -259,4 +492,34 @@ const (
 	FaultFileTransferFailureCompleteDownload = 9017
 	FaultFileTransferFailureFileCorrupted = 9018
 	FaultFileTransferFailureFileAuthentication = 9019
-)
\ No newline at end of file
+)
+
+// TR-069 ACS Fault codes, returned to the CPE
+const (
+	AcsFaultMethodNotSupported = 8000
+	AcsFaultRequestDenied      = 8001
+	AcsFaultInternalError      = 8002
+	AcsFaultInvalidArguments   = 8003
+	AcsFaultResourcesExceeded  = 8004
+	AcsFaultRetryRequest       = 8005
+)
+
+// AcsFault is an error reported to the CPE with a specific ACS fault code
+type AcsFault struct {
+	Code    uint32
+	Message string
+}
+
+func (f *AcsFault) Error() string {
+	return f.Message
+}
+
+
+// TR-069 Download file types
+const (
+	FileTypeFirmwareUpgrade = "1 Firmware Upgrade Image"
+	FileTypeWebContent      = "2 Web Content"
+	FileTypeVendorConfig    = "3 Vendor Configuration File"
+	FileTypeToneFile        = "4 Tone File"
+	FileTypeRingerFile      = "5 Ringer File"
+)
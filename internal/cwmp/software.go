@@ -0,0 +1,231 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeviceEventDUStateChanged records the outcome of a ChangeDUState operation
+const DeviceEventDUStateChanged = "device.du_state_changed"
+
+// changeDUStateRPC builds the ChangeDUState of a command
+func changeDUStateRPC(cmd *acsbus.Command) (interface{}, error) {
+	op := cmd.DUOperation
+	if op == nil {
+		return nil, fmt.Errorf("%s command without operation", cmd.Method)
+	}
+	rpc := &ChangeDUState{CommandKey: cmd.CommandKey}
+	switch op.Type {
+	case db.DUOperationInstall:
+		rpc.Operations = append(rpc.Operations, &InstallOpStruct{
+			Type:            "cwmp:InstallOpStruct",
+			URL:             op.URL,
+			UUID:            op.UUID,
+			Username:        op.Username,
+			Password:        op.Password,
+			ExecutionEnvRef: op.ExecutionEnvRef,
+		})
+	case db.DUOperationUpdate:
+		rpc.Operations = append(rpc.Operations, &UpdateOpStruct{
+			Type:     "cwmp:UpdateOpStruct",
+			UUID:     op.UUID,
+			Version:  op.Version,
+			URL:      op.URL,
+			Username: op.Username,
+			Password: op.Password,
+		})
+	case db.DUOperationUninstall:
+		rpc.Operations = append(rpc.Operations, &UninstallOpStruct{
+			Type:            "cwmp:UninstallOpStruct",
+			UUID:            op.UUID,
+			Version:         op.Version,
+			ExecutionEnvRef: op.ExecutionEnvRef,
+		})
+	default:
+		return nil, fmt.Errorf("unsupported deployment unit operation %s", op.Type)
+	}
+	return rpc, nil
+}
+
+// handleChangeDUStateResponse records that the device accepted the
+// operation, it reports the outcome with ChangeDUStateComplete
+func (acs *AcsServer) handleChangeDUStateResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing ChangeDUStateResponse")
+
+	session := acs.getConnSession(r)
+	if session != nil {
+		if commandKey, ok := session.currentDUState(); ok {
+			logging.ForRequest(session.currentRequest()).Infof("Device %s accepted ChangeDUState %q", session.DeviceId, commandKey)
+			acs.startDUOperation(session.DeviceId, commandKey)
+		}
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleChangeDUStateComplete stores the outcome of the operations of a
+// ChangeDUState and acknowledges it
+func (acs *AcsServer) handleChangeDUStateComplete(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing ChangeDUStateComplete")
+
+	var complete ChangeDUStateComplete
+	if err := req.decode(&complete); err != nil {
+		return nil, fmt.Errorf("error parsing ChangeDUStateComplete: %w", err)
+	}
+	if session := acs.getConnSession(r); session != nil {
+		for i := range complete.Results {
+			acs.completeDUOperation(session.DeviceId, complete.CommandKey, &complete.Results[i])
+		}
+	}
+
+	response.Body.Content = &ChangeDUStateCompleteResponse{}
+	return response, nil
+}
+
+// failDUState records the fault of the device to a ChangeDUState
+func (acs *AcsServer) failDUState(session *CwmpSession, fault *CWMPFault) {
+	commandKey, ok := session.currentDUState()
+	if !ok || acs.dbH == nil {
+		return
+	}
+	du, err := acs.dbH.GetCwmpDeploymentUnitByCommandKey(session.DeviceId, commandKey)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	du.OperationStatus = db.DUOperationFailed
+	du.FaultCode = strconv.FormatUint(uint64(fault.FaultCode), 10)
+	du.FaultString = fault.FaultString
+	du.CompletedAt = &now
+	acs.saveDeploymentUnit(du)
+	acs.emitDUStateChanged(du)
+}
+
+// startInformDUStates marks in progress the operations a 11 DU STATE CHANGE
+// COMPLETE Inform reports, the device sends ChangeDUStateComplete for them
+// in the session
+func (acs *AcsServer) startInformDUStates(deviceId string, inform *Inform) {
+	if !hasEvent(inform, EventDUStateChangeComplete) {
+		return
+	}
+	for _, e := range inform.Event {
+		if e.EventCode == EventMChangeDUState && e.CommandKey != "" {
+			acs.startDUOperation(deviceId, e.CommandKey)
+		}
+	}
+}
+
+// startDUOperation marks in progress the pending operation of a CommandKey
+func (acs *AcsServer) startDUOperation(deviceId string, commandKey string) {
+	if acs.dbH == nil {
+		return
+	}
+	du, err := acs.dbH.GetCwmpDeploymentUnitByCommandKey(deviceId, commandKey)
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			log.Printf("Error loading deployment unit %q of device %s: %v", commandKey, deviceId, err)
+		}
+		return
+	}
+	if du.OperationStatus == db.DUOperationPending {
+		du.OperationStatus = db.DUOperationInProgress
+		acs.saveDeploymentUnit(du)
+	}
+}
+
+// completeDUOperation stores the result of an operation. The result is
+// matched with the operation of the CommandKey, or with the deployment unit
+// of its UUID for operations not requested through the ACS.
+func (acs *AcsServer) completeDUOperation(deviceId string, commandKey string, result *OpResultStruct) {
+	if acs.dbH == nil {
+		return
+	}
+	du, err := acs.dbH.GetCwmpDeploymentUnitByCommandKey(deviceId, commandKey)
+	if errors.Is(err, mongo.ErrNoDocuments) && result.UUID != "" {
+		du, err = acs.dbH.GetCwmpDeploymentUnit(deviceId, result.UUID)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		du = &db.CwmpDeploymentUnit{DeviceID: deviceId, CommandKey: commandKey}
+	} else if err != nil {
+		log.Printf("Error loading deployment unit %q of device %s: %v", commandKey, deviceId, err)
+		return
+	}
+
+	du.UUID = result.UUID
+	du.DeploymentUnitRef = result.DeploymentUnitRef
+	du.Version = result.Version
+	du.State = result.CurrentState
+	du.Resolved = result.Resolved
+	du.ExecutionUnitRefs = nil
+	for _, ref := range strings.Split(result.ExecutionUnitRefList, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			du.ExecutionUnitRefs = append(du.ExecutionUnitRefs, ref)
+		}
+	}
+	du.OperationStatus = db.DUOperationCompleted
+	du.FaultCode, du.FaultString = "", ""
+	if result.Fault.FaultCode != 0 {
+		du.OperationStatus = db.DUOperationFailed
+		du.FaultCode = strconv.FormatUint(uint64(result.Fault.FaultCode), 10)
+		du.FaultString = result.Fault.FaultString
+	}
+	completedAt := result.CompleteTime
+	if completedAt.IsZero() {
+		completedAt = time.Now()
+	}
+	du.CompletedAt = &completedAt
+	log.Printf("Deployment unit %s of device %s is %s, %s %s", du.UUID, deviceId, du.State, du.Operation, du.OperationStatus)
+	acs.saveDeploymentUnit(du)
+	acs.emitDUStateChanged(du)
+}
+
+func (acs *AcsServer) saveDeploymentUnit(du *db.CwmpDeploymentUnit) {
+	if err := acs.dbH.UpsertCwmpDeploymentUnit(du); err != nil {
+		log.Printf("Error storing deployment unit %s of device %s: %v", du.UUID, du.DeviceID, err)
+	}
+}
+
+func (acs *AcsServer) emitDUStateChanged(du *db.CwmpDeploymentUnit) {
+	acs.emitDeviceEvent(du.DeviceID, DeviceEventDUStateChanged, map[string]string{
+		"uuid":             du.UUID,
+		"command_key":      du.CommandKey,
+		"operation":        du.Operation,
+		"operation_status": du.OperationStatus,
+		"state":            du.State,
+		"fault_code":       du.FaultCode,
+	})
+}
+
+// currentDUState returns the CommandKey of the ChangeDUState the device is
+// answering
+func (s *CwmpSession) currentDUState() (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if rpc, ok := s.CurrentRPC.(*ChangeDUState); ok {
+		return rpc.CommandKey, true
+	}
+	return "", false
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/n4-networks/openusp/internal/stun"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// startSTUN starts the TR-111 STUN server if it is enabled. The bindings
+// CPEs announce to it are stored as their UDP connection request address,
+// and UDP connection requests are sent from its socket.
+func (acs *AcsServer) startSTUN() error {
+	cfg := acs.config.Protocols.CWMP.STUN
+	if !cfg.Enabled {
+		return nil
+	}
+	server, err := stun.Listen(fmt.Sprintf(":%d", cfg.Port))
+	if err != nil {
+		return fmt.Errorf("failed to start STUN server: %w", err)
+	}
+	server.OnBinding = acs.recordSTUNBinding
+	acs.stun = server
+	acs.connReq.SetUDPSender(server)
+
+	go func() {
+		if err := server.Serve(); err != nil {
+			log.Printf("STUN server stopped: %v", err)
+		}
+	}()
+	log.Printf("STUN server listening on UDP port %d", cfg.Port)
+	return nil
+}
+
+// recordSTUNBinding stores the public address of the binding a CPE keeps
+// open with the STUN server
+func (acs *AcsServer) recordSTUNBinding(binding *stun.Binding) {
+	if acs.dbH == nil || binding.Username == "" {
+		return
+	}
+	deviceId, err := acs.dbH.UpdateCwmpDeviceSTUNBinding(binding.Username, binding.Addr.String())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("STUN binding of unknown username %q from %s", binding.Username, binding.Addr)
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing STUN binding of %q: %v", binding.Username, err)
+		return
+	}
+	if binding.Changed {
+		log.Printf("STUN binding of device %s changed to %s", deviceId, binding.Addr)
+	}
+}
+
+// trackUDPConnRequest stores the TR-111 parameters reported in an Inform:
+// the UDP connection request address, whether the CPE detected a NAT and
+// its STUN username
+func (acs *AcsServer) trackUDPConnRequest(deviceId string, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	var address, stunUsername string
+	var natDetected, reported bool
+	for _, param := range inform.ParameterList {
+		switch {
+		case strings.HasSuffix(param.Name, ".ManagementServer.UDPConnectionRequestAddress"):
+			address, reported = param.Value, true
+		case strings.HasSuffix(param.Name, ".ManagementServer.NATDetected"):
+			natDetected = param.Value == "true" || param.Value == "1"
+		case strings.HasSuffix(param.Name, ".ManagementServer.STUNUsername"):
+			stunUsername = param.Value
+		}
+	}
+	if !reported {
+		return
+	}
+	if err := acs.dbH.UpdateCwmpDeviceUDPConnRequest(deviceId, address, natDetected, stunUsername); err != nil {
+		log.Printf("Error storing UDP connection request address of device %s: %v", deviceId, err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// maxTraceBodySize limits the size of a traced SOAP message
+const maxTraceBodySize = 1 << 20
+
+// traceBuffer keeps the beginning of a request read from the CPE
+type traceBuffer struct {
+	bytes.Buffer
+}
+
+func (tb *traceBuffer) Write(b []byte) (int, error) {
+	if room := maxTraceBodySize - tb.Len(); room > 0 {
+		if len(b) > room {
+			tb.Buffer.Write(b[:room])
+		} else {
+			tb.Buffer.Write(b)
+		}
+	}
+	return len(b), nil
+}
+
+// traceWriter keeps a copy of the response sent to the CPE
+type traceWriter struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (tw *traceWriter) Write(b []byte) (int, error) {
+	if tw.body.Len() < maxTraceBodySize {
+		tw.body.Write(b)
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// setSessionTrace enables tracing of the session if it is enabled for the device
+func (acs *AcsServer) setSessionTrace(session *CwmpSession) {
+	if acs.dbH == nil {
+		return
+	}
+	traced := false
+	if device, err := acs.dbH.GetCwmpDeviceByID(session.DeviceId); err == nil {
+		traced = device.TraceActive()
+	}
+
+	session.mutex.Lock()
+	session.trace = traced
+	session.mutex.Unlock()
+}
+
+// traceExchange stores a SOAP request/response pair of a traced session
+func (acs *AcsServer) traceExchange(session *CwmpSession, request []byte, response []byte) {
+	if session == nil || acs.dbH == nil {
+		return
+	}
+	session.mutex.RLock()
+	traced := session.trace
+	session.mutex.RUnlock()
+	if !traced {
+		return
+	}
+
+	if len(request) > maxTraceBodySize {
+		request = request[:maxTraceBodySize]
+	}
+	trace := &db.CwmpTrace{
+		DeviceID:  session.DeviceId,
+		SessionID: session.SessionId,
+		Request:   string(request),
+		Response:  string(response),
+	}
+	if err := acs.dbH.InsertCwmpTrace(trace); err != nil {
+		log.Printf("Error storing SOAP trace of device %s: %v", session.DeviceId, err)
+	}
+}
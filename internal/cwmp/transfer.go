@@ -0,0 +1,257 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/acsbus"
+	"github.com/n4-networks/openusp/internal/db"
+	"github.com/n4-networks/openusp/pkg/logging"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeviceEventFirmwareUpgraded is emitted when the version a device runs after
+// a firmware upgrade was verified
+const DeviceEventFirmwareUpgraded = "device.firmware_upgraded"
+
+// handleDownloadResponse records whether the device completed the download
+// or will report it with TransferComplete
+func (acs *AcsServer) handleDownloadResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing DownloadResponse")
+
+	var downloaded DownloadResponse
+	if err := req.decode(&downloaded); err != nil {
+		return nil, fmt.Errorf("error parsing DownloadResponse: %w", err)
+	}
+	session := acs.getConnSession(r)
+	if session != nil {
+		acs.recordTransferResponse(session, downloaded.Status, downloaded.StartTime, downloaded.CompleteTime)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleUploadResponse records whether the device completed the upload or
+// will report it with TransferComplete
+func (acs *AcsServer) handleUploadResponse(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing UploadResponse")
+
+	var uploaded UploadResponse
+	if err := req.decode(&uploaded); err != nil {
+		return nil, fmt.Errorf("error parsing UploadResponse: %w", err)
+	}
+	session := acs.getConnSession(r)
+	if session != nil {
+		acs.recordTransferResponse(session, uploaded.Status, uploaded.StartTime, uploaded.CompleteTime)
+	}
+	return acs.continueSession(session, response), nil
+}
+
+// handleTransferComplete completes the transfer of the CommandKey reported
+// by the device and acknowledges it
+func (acs *AcsServer) handleTransferComplete(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing TransferComplete")
+
+	var complete TransferComplete
+	if err := req.decode(&complete); err != nil {
+		return nil, fmt.Errorf("error parsing TransferComplete: %w", err)
+	}
+
+	if session := acs.getConnSession(r); session != nil {
+		update := db.TransferUpdate{
+			Status:       db.TransferStatusCompleted,
+			StartTime:    complete.StartTime,
+			CompleteTime: complete.CompleteTime,
+		}
+		if fault := complete.FaultStruct; fault.FaultCode != 0 {
+			update.Status = db.TransferStatusFailed
+			update.FaultCode = strconv.FormatUint(uint64(fault.FaultCode), 10)
+			update.FaultString = fault.FaultString
+		}
+		log.Printf("Transfer %q of device %s %s", complete.CommandKey, session.DeviceId, update.Status)
+		acs.updateTransfer(session.DeviceId, complete.CommandKey, update)
+		acs.publishTransfer(session.DeviceId, complete.CommandKey, &complete.FaultStruct)
+	}
+
+	response.Body.Content = &TransferCompleteResponse{}
+	return response, nil
+}
+
+// handleAutonomousTransferComplete records a transfer the device ran on its
+// own in the transfer history and acknowledges it
+func (acs *AcsServer) handleAutonomousTransferComplete(req *soapRequest, response *SOAPEnvelope, r *http.Request) (*SOAPEnvelope, error) {
+	logging.Debugf("Processing AutonomousTransferComplete")
+
+	var complete AutonomousTransferComplete
+	if err := req.decode(&complete); err != nil {
+		return nil, fmt.Errorf("error parsing AutonomousTransferComplete: %w", err)
+	}
+
+	if session := acs.getConnSession(r); session != nil && acs.dbH != nil {
+		transfer := &db.CwmpFileTransfer{
+			DeviceID:       session.DeviceId,
+			FileType:       complete.FileType,
+			URL:            complete.TransferURL,
+			FileSize:       int64(complete.FileSize),
+			TargetFileName: complete.TargetFileName,
+			Status:         db.TransferStatusCompleted,
+			StartTime:      complete.StartTime,
+			CompleteTime:   complete.CompleteTime,
+			Upload:         !complete.IsDownload,
+			AnnounceURL:    complete.AnnounceURL,
+		}
+		if fault := complete.FaultStruct; fault.FaultCode != 0 {
+			transfer.Status = db.TransferStatusFailed
+			transfer.FaultCode = strconv.FormatUint(uint64(fault.FaultCode), 10)
+			transfer.FaultString = fault.FaultString
+		}
+		log.Printf("Autonomous transfer of %s %q by device %s %s", complete.FileType, complete.TransferURL, session.DeviceId, transfer.Status)
+		if err := acs.dbH.InsertCwmpAutonomousTransfer(transfer); err != nil {
+			log.Printf("Error storing autonomous transfer of device %s: %v", session.DeviceId, err)
+		}
+	}
+
+	response.Body.Content = &AutonomousTransferCompleteResponse{}
+	return response, nil
+}
+
+// recordTransferResponse updates the transfer of the Download or Upload the
+// device answered. A status of 0 means the transfer completed, 1 that it
+// will be reported with TransferComplete.
+func (acs *AcsServer) recordTransferResponse(session *CwmpSession, status uint32, start, complete time.Time) {
+	commandKey, ok := session.currentTransfer()
+	if !ok {
+		return
+	}
+	update := db.TransferUpdate{Status: db.TransferStatusInProgress, StartTime: start}
+	if status == 0 {
+		update.Status = db.TransferStatusCompleted
+		update.CompleteTime = complete
+	}
+	logging.ForRequest(session.currentRequest()).Infof("Transfer %q of device %s %s", commandKey, session.DeviceId, update.Status)
+	acs.updateTransfer(session.DeviceId, commandKey, update)
+	if status == 0 {
+		acs.publishTransfer(session.DeviceId, commandKey, nil)
+	}
+}
+
+// failTransfer records the fault of the device to a Download or Upload
+func (acs *AcsServer) failTransfer(session *CwmpSession, fault *CWMPFault) {
+	commandKey, ok := session.currentTransfer()
+	if !ok {
+		return
+	}
+	acs.updateTransfer(session.DeviceId, commandKey, db.TransferUpdate{
+		Status:       db.TransferStatusFailed,
+		CompleteTime: time.Now(),
+		FaultCode:    strconv.FormatUint(uint64(fault.FaultCode), 10),
+		FaultString:  fault.FaultString,
+	})
+	acs.publishTransfer(session.DeviceId, commandKey, fault)
+}
+
+// startInformTransfers marks in progress the transfers a 7 TRANSFER COMPLETE
+// Inform reports, the device sends TransferComplete for them in the session
+func (acs *AcsServer) startInformTransfers(deviceId string, inform *Inform) {
+	if !hasEvent(inform, EventTransferComplete) {
+		return
+	}
+	for _, e := range inform.Event {
+		if (e.EventCode == EventMDownload || e.EventCode == EventMUpload) && e.CommandKey != "" {
+			acs.updateTransfer(deviceId, e.CommandKey, db.TransferUpdate{Status: db.TransferStatusInProgress})
+		}
+	}
+}
+
+// verifyFirmwareJobs records the software version a booting device reports
+// in its firmware jobs, verifying the upgrades it installed
+func (acs *AcsServer) verifyFirmwareJobs(deviceId string, inform *Inform) {
+	if acs.dbH == nil || !(hasEvent(inform, EventBoot) || hasEvent(inform, EventMDownload)) {
+		return
+	}
+	var version string
+	for _, param := range inform.ParameterList {
+		if strings.HasSuffix(param.Name, ".DeviceInfo.SoftwareVersion") {
+			version = param.Value
+		}
+	}
+	if version == "" {
+		return
+	}
+
+	jobs, err := acs.dbH.RecordFirmwareVersion(deviceId, version)
+	if err != nil {
+		log.Printf("Error verifying firmware jobs of device %s: %v", deviceId, err)
+	}
+	for _, job := range jobs {
+		log.Printf("Firmware job %s of device %s: %s, running %s", job.ID, deviceId, job.Status, version)
+		acs.emitDeviceEvent(deviceId, DeviceEventFirmwareUpgraded, map[string]string{
+			"job_id":  job.ID,
+			"status":  job.Status,
+			"version": version,
+		})
+	}
+}
+
+func (acs *AcsServer) updateTransfer(deviceId string, commandKey string, update db.TransferUpdate) {
+	if acs.dbH == nil {
+		return
+	}
+	_, err := acs.dbH.UpdateCwmpFileTransfer(deviceId, commandKey, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Transfers not requested through the API are not tracked
+		logging.Debugf("No pending transfer %q for device %s", commandKey, deviceId)
+	} else if err != nil {
+		log.Printf("Error updating transfer %q of device %s: %v", commandKey, deviceId, err)
+	}
+}
+
+// publishTransfer publishes the completion of a transfer, fault is nil or
+// has a FaultCode of 0 if it succeeded
+func (acs *AcsServer) publishTransfer(deviceId string, commandKey string, fault *CWMPFault) {
+	if acs.bus == nil {
+		return
+	}
+	event := &acsbus.Event{
+		Type:       acsbus.EventTransfer,
+		DeviceID:   deviceId,
+		CommandKey: commandKey,
+	}
+	if fault != nil {
+		event.FaultCode = fault.FaultCode
+		event.FaultString = fault.FaultString
+	}
+	acs.publishEvent(event)
+}
+
+// currentTransfer returns the CommandKey of the Download or Upload the
+// device is answering
+func (s *CwmpSession) currentTransfer() (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	switch rpc := s.CurrentRPC.(type) {
+	case *Download:
+		return rpc.CommandKey, true
+	case *Upload:
+		return rpc.CommandKey, true
+	}
+	return "", false
+}
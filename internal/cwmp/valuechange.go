@@ -0,0 +1,100 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/n4-networks/openusp/internal/db"
+)
+
+// DeviceEventParametersChanged is emitted when a "4 VALUE CHANGE" Inform
+// reports parameters whose value differs from the stored one
+const DeviceEventParametersChanged = "device.parameters_changed"
+
+// recordValueChanges stores the parameters of a "4 VALUE CHANGE" Inform and
+// emits an event for the ones whose value differs from the stored one
+func (acs *AcsServer) recordValueChanges(deviceId string, inform *Inform) {
+	if !hasEvent(inform, EventValueChange) {
+		return
+	}
+	changes := acs.recordParameterValues(deviceId, inform.ParameterList, db.ParamChangeSourceValueChange)
+	if len(changes) == 0 {
+		return
+	}
+
+	changed := make([]string, 0, len(changes))
+	for _, c := range changes {
+		changed = append(changed, c.Path)
+	}
+	acs.emitDeviceEvent(deviceId, DeviceEventParametersChanged, map[string]string{
+		"count": strconv.Itoa(len(changes)),
+		"paths": strings.Join(changed, ","),
+	})
+}
+
+// recordParameterValues diffs values reported by the device against the
+// stored ones, stores the reported values and appends the changed ones to the
+// parameter history. It returns the recorded changes.
+func (acs *AcsServer) recordParameterValues(deviceId string, list []ParameterValueStruct, source string) []db.CwmpParameterChange {
+	if acs.dbH == nil || len(list) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(list))
+	for _, p := range list {
+		paths = append(paths, p.Name)
+	}
+	stored, err := acs.dbH.GetCwmpParametersByPath(deviceId, paths)
+	if err != nil {
+		log.Printf("Error loading parameters of device %s: %v", deviceId, err)
+		return nil
+	}
+	current := make(map[string]string, len(stored))
+	for _, p := range stored {
+		current[p.Path] = p.Value
+	}
+
+	now := time.Now()
+	var changes []db.CwmpParameterChange
+	for _, p := range list {
+		old, known := current[p.Name]
+		if known && old == p.Value {
+			continue
+		}
+		changes = append(changes, db.CwmpParameterChange{
+			DeviceID:  deviceId,
+			Path:      p.Name,
+			OldValue:  old,
+			NewValue:  p.Value,
+			Type:      p.Type,
+			Source:    source,
+			New:       !known,
+			ChangedAt: now,
+		})
+	}
+
+	if err := acs.dbH.UpdateCwmpParameterValues(toDbParameters(deviceId, list)); err != nil {
+		log.Printf("Error storing parameters of device %s: %v", deviceId, err)
+		return nil
+	}
+	if err := acs.dbH.InsertCwmpParameterChanges(changes); err != nil {
+		log.Printf("Error storing parameter history of device %s: %v", deviceId, err)
+	}
+	return changes
+}
@@ -0,0 +1,100 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cwmpNamespacePrefix is the namespace of the CWMP elements without its
+// version, urn:dslforum-org:cwmp-1-0 through cwmp-1-4
+const cwmpNamespacePrefix = "urn:dslforum-org:cwmp-1-"
+
+// CWMP versions are the minor version of the namespace: 0 for cwmp-1-0 up
+// to maxCwmpVersion. Sessions whose version is unknown use
+// defaultCwmpVersion.
+const (
+	defaultCwmpVersion = 2
+	maxCwmpVersion     = 4
+)
+
+// minCwmpVersions lists the methods introduced after cwmp-1-0 with the
+// version which introduced them, whether the CPE or the ACS calls them
+var minCwmpVersions = map[string]int{
+	"GetAllQueuedTransfers":           1,
+	"AutonomousTransferComplete":      1,
+	"ChangeDUState":                   2,
+	"ChangeDUStateComplete":           2,
+	"AutonomousDUStateChangeComplete": 2,
+}
+
+// parseCwmpVersion returns the version of a CWMP namespace, newer versions
+// than the ACS supports are answered with the latest it supports
+func parseCwmpVersion(namespace string) (int, bool) {
+	if !strings.HasPrefix(namespace, cwmpNamespacePrefix) {
+		return 0, false
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(namespace, cwmpNamespacePrefix))
+	if err != nil || v < 0 {
+		return 0, false
+	}
+	if v > maxCwmpVersion {
+		v = maxCwmpVersion
+	}
+	return v, true
+}
+
+// cwmpNamespace returns the namespace of a CWMP version
+func cwmpNamespace(version int) string {
+	return cwmpNamespacePrefix + strconv.Itoa(version)
+}
+
+// cwmpVersionName names a CWMP version as in its namespace, e.g. cwmp-1-2
+func cwmpVersionName(version int) string {
+	return fmt.Sprintf("cwmp-1-%d", version)
+}
+
+// supportsMethod reports whether a method exists in a CWMP version
+func supportsMethod(version int, method string) bool {
+	return version >= minCwmpVersions[method]
+}
+
+// supportsSessionTimeout reports whether the SessionTimeout header exists
+// in a CWMP version, it was introduced with cwmp-1-2
+func supportsSessionTimeout(version int) bool {
+	return version >= 2
+}
+
+// cwmpVersion returns the CWMP version negotiated for the session
+func (s *CwmpSession) cwmpVersion() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.CwmpVersion
+}
+
+// sessionCwmpVersion returns the version a message of the CPE is answered
+// with: the version of the message itself if it is in a CWMP namespace,
+// otherwise the version of the session it belongs to
+func (acs *AcsServer) sessionCwmpVersion(req *soapRequest, session *CwmpSession) int {
+	if v, ok := parseCwmpVersion(req.Space); ok {
+		return v
+	}
+	if session != nil {
+		return session.cwmpVersion()
+	}
+	return defaultCwmpVersion
+}
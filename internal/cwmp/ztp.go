@@ -0,0 +1,98 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cwmp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/n4-networks/openusp/internal/db"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DeviceEventZtpApplied is emitted when a zero-touch provisioning template
+// was queued for a device
+const DeviceEventZtpApplied = "device.ztp_applied"
+
+// applyZtpTemplate queues the parameters and downloads of the zero-touch
+// provisioning template matching a device which just appeared. A device is
+// only considered once, whether a template matched it or not.
+func (acs *AcsServer) applyZtpTemplate(session *CwmpSession, inform *Inform) {
+	if acs.dbH == nil {
+		return
+	}
+	id := inform.DeviceId
+	template, err := acs.dbH.MatchZtpTemplate(db.ZtpProtocolCwmp, id.OUI, id.ProductClass, id.SerialNumber)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		log.Printf("Error matching ZTP template for device %s: %v", session.DeviceId, err)
+		return
+	}
+
+	seen := &db.ZtpDevice{DeviceID: session.DeviceId, Protocol: db.ZtpProtocolCwmp}
+	if template != nil {
+		seen.Template = template.Name
+	}
+	first, err := acs.dbH.MarkZtpDevice(seen)
+	if err != nil {
+		log.Printf("Error recording ZTP of device %s: %v", session.DeviceId, err)
+		return
+	}
+	if !first || template == nil {
+		return
+	}
+
+	var rpcs []interface{}
+	if len(template.Parameters) > 0 {
+		rpc := &SetParameterValues{ParameterKey: "ztp:" + template.Name}
+		for _, p := range template.Parameters {
+			rpc.ParameterList = append(rpc.ParameterList, ParameterValueStruct{Name: p.Path, Value: p.Value, Type: p.Type})
+		}
+		rpcs = append(rpcs, rpc)
+	}
+	for i, d := range template.Downloads {
+		transfer := &db.CwmpFileTransfer{
+			DeviceID:       session.DeviceId,
+			CommandKey:     fmt.Sprintf("ztp:%s:%d", template.Name, i),
+			FileType:       d.FileType,
+			URL:            d.URL,
+			Username:       d.Username,
+			Password:       d.Password,
+			FileSize:       d.FileSize,
+			TargetFileName: d.TargetFileName,
+			DelaySeconds:   d.DelaySeconds,
+		}
+		if err := acs.dbH.InsertCwmpFileTransfer(transfer); err != nil {
+			log.Printf("Error storing ZTP download %s of device %s: %v", d.URL, session.DeviceId, err)
+			continue
+		}
+		rpcs = append(rpcs, &Download{
+			CommandKey:     transfer.CommandKey,
+			FileType:       transfer.FileType,
+			URL:            transfer.URL,
+			Username:       transfer.Username,
+			Password:       transfer.Password,
+			FileSize:       uint32(transfer.FileSize),
+			TargetFileName: transfer.TargetFileName,
+			DelaySeconds:   uint32(transfer.DelaySeconds),
+		})
+	}
+
+	session.mutex.Lock()
+	session.PendingRPCs = append(session.PendingRPCs, rpcs...)
+	session.mutex.Unlock()
+	log.Printf("Applied ZTP template %s to device %s: %d RPC(s) queued", template.Name, session.DeviceId, len(rpcs))
+	acs.emitDeviceEvent(session.DeviceId, DeviceEventZtpApplied, map[string]string{"template": template.Name})
+}
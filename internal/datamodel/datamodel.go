@@ -0,0 +1,210 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package datamodel translates parameter paths between the TR-098
+// (InternetGatewayDevice.) and TR-181 (Device.) data models, so that the
+// features built on a few well known parameters, such as WiFi and WAN
+// status, work with devices reporting either root.
+package datamodel
+
+import (
+	"strings"
+)
+
+// Data model roots
+const (
+	RootTR181 = "Device."
+	RootTR098 = "InternetGatewayDevice."
+)
+
+// Data model names, recorded on the devices
+const (
+	ModelTR181 = "TR-181"
+	ModelTR098 = "TR-098"
+)
+
+const (
+	lanDevice     = RootTR098 + "LANDevice.1."
+	wlanConfig    = lanDevice + "WLANConfiguration.{i}."
+	wanConnection = RootTR098 + "WANDevice.1.WANConnectionDevice.1.WANIPConnection.1."
+)
+
+// mapping is a TR-181 path and its TR-098 equivalent. A path ending with a
+// dot maps a whole object, {i} stands for the same instance number in both.
+// values maps the TR-098 values of an enumeration to the TR-181 ones, one to
+// one so that it translates both ways.
+type mapping struct {
+	tr181  string
+	tr098  string
+	values map[string]string
+}
+
+// mappings is ordered so that parameters match before the objects they
+// belong to. A TR-098 WLANConfiguration combines the TR-181 WiFi SSID, Radio
+// and AccessPoint of the same instance, the first IP interface of TR-181 is
+// taken as the WAN one.
+var mappings = []mapping{
+	{tr181: "Device.WiFi.Radio.{i}.Channel", tr098: wlanConfig + "Channel"},
+	{tr181: "Device.WiFi.Radio.{i}.AutoChannelEnable", tr098: wlanConfig + "AutoChannelEnable"},
+	{tr181: "Device.WiFi.Radio.{i}.OperatingStandards", tr098: wlanConfig + "Standard"},
+	{tr181: "Device.WiFi.AccessPoint.{i}.SSIDAdvertisementEnabled", tr098: wlanConfig + "SSIDAdvertisementEnabled"},
+	{tr181: "Device.WiFi.AccessPoint.{i}.AssociatedDeviceNumberOfEntries", tr098: wlanConfig + "TotalAssociations"},
+	{tr181: "Device.WiFi.AccessPoint.{i}.Security.KeyPassphrase", tr098: wlanConfig + "KeyPassphrase"},
+	{tr181: "Device.WiFi.AccessPoint.{i}.Security.ModeEnabled", tr098: wlanConfig + "BeaconType", values: map[string]string{
+		"None":      "None",
+		"Basic":     "WEP-64",
+		"WPA":       "WPA-Personal",
+		"11i":       "WPA2-Personal",
+		"WPAand11i": "WPA-WPA2-Personal",
+	}},
+	{tr181: "Device.WiFi.SSID.{i}.", tr098: wlanConfig},
+	{tr181: "Device.IP.Interface.1.Status", tr098: wanConnection + "ConnectionStatus", values: map[string]string{
+		"Unconfigured": "NotPresent",
+		"Connecting":   "Dormant",
+		"Connected":    "Up",
+		"Disconnected": "Down",
+	}},
+	{tr181: "Device.IP.Interface.1.LastChange", tr098: wanConnection + "Uptime"},
+	{tr181: "Device.IP.Interface.1.IPv4Address.1.IPAddress", tr098: wanConnection + "ExternalIPAddress"},
+	{tr181: "Device.IP.Interface.1.IPv4Address.1.SubnetMask", tr098: wanConnection + "SubnetMask"},
+	{tr181: "Device.IP.Interface.1.IPv4Address.1.AddressingType", tr098: wanConnection + "AddressingType"},
+	{tr181: "Device.Routing.Router.1.IPv4Forwarding.1.GatewayIPAddress", tr098: wanConnection + "DefaultGateway"},
+	{tr181: "Device.Ethernet.Link.1.MACAddress", tr098: wanConnection + "MACAddress"},
+	{tr181: "Device.Hosts.Host.{i}.PhysAddress", tr098: lanDevice + "Hosts.Host.{i}.MACAddress"},
+	{tr181: "Device.Hosts.Host.{i}.", tr098: lanDevice + "Hosts.Host.{i}."},
+}
+
+// sharedObjects are identical in both data models but for the root
+var sharedObjects = []string{"DeviceInfo.", "ManagementServer.", "Time.", "UserInterface."}
+
+// Model returns the name of the data model under root
+func Model(root string) string {
+	if root == RootTR098 {
+		return ModelTR098
+	}
+	return ModelTR181
+}
+
+// RootOf returns the data model root of a path, empty if it has none
+func RootOf(path string) string {
+	switch {
+	case strings.HasPrefix(path, RootTR098):
+		return RootTR098
+	case strings.HasPrefix(path, RootTR181):
+		return RootTR181
+	}
+	return ""
+}
+
+// DetectRoot returns the data model root of the parameters reported by a
+// device, TR-181 unless one of them is under InternetGatewayDevice.
+func DetectRoot(paths []string) string {
+	for _, path := range paths {
+		if RootOf(path) == RootTR098 {
+			return RootTR098
+		}
+	}
+	return RootTR181
+}
+
+// Translate converts a path of either data model to the data model under
+// root, ok is false when the path has no known equivalent
+func Translate(path string, root string) (string, bool) {
+	translated, _, ok := TranslateParam(path, "", root)
+	return translated, ok
+}
+
+// Normalize converts a parameter of either data model, with its value, to
+// TR-181
+func Normalize(path string, value string) (string, string, bool) {
+	return TranslateParam(path, value, RootTR181)
+}
+
+// TranslateParam converts a parameter path and value of either data model
+// to the data model under root, translating the enumerations which differ
+func TranslateParam(path string, value string, root string) (string, string, bool) {
+	from := RootOf(path)
+	if from == "" {
+		return path, value, false
+	}
+	if from == root {
+		return path, value, true
+	}
+
+	for _, m := range mappings {
+		src, dst := m.tr181, m.tr098
+		if from == RootTR098 {
+			src, dst = m.tr098, m.tr181
+		}
+		instance, tail, ok := matchPattern(src, path)
+		if !ok {
+			continue
+		}
+		return strings.Replace(dst, "{i}", instance, 1) + tail, m.translateValue(value, from), true
+	}
+
+	rest := strings.TrimPrefix(path, from)
+	for _, object := range sharedObjects {
+		if strings.HasPrefix(rest, object) {
+			return root + rest, value, true
+		}
+	}
+	return path, value, false
+}
+
+// translateValue converts a value of the data model under from
+func (m *mapping) translateValue(value string, from string) string {
+	if from == RootTR098 {
+		if v, ok := m.values[value]; ok {
+			return v
+		}
+		return value
+	}
+	for tr098, tr181 := range m.values {
+		if tr181 == value {
+			return tr098
+		}
+	}
+	return value
+}
+
+// matchPattern matches path against a mapping pattern, returning the
+// instance number standing for {i} and, for an object pattern, the rest of
+// the path under it
+func matchPattern(pattern string, path string) (string, string, bool) {
+	instance := ""
+	if before, after, found := strings.Cut(pattern, "{i}"); found {
+		if !strings.HasPrefix(path, before) {
+			return "", "", false
+		}
+		rest := path[len(before):]
+		end := strings.IndexByte(rest, '.')
+		if end <= 0 || strings.Trim(rest[:end], "0123456789") != "" {
+			return "", "", false
+		}
+		instance = rest[:end]
+		pattern = before + instance + after
+	}
+
+	if strings.HasSuffix(pattern, ".") {
+		if !strings.HasPrefix(path, pattern) {
+			return "", "", false
+		}
+		return instance, path[len(pattern):], true
+	}
+	if path != pattern {
+		return "", "", false
+	}
+	return instance, "", true
+}
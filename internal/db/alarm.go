@@ -0,0 +1,84 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Alarm severities
+const (
+	AlarmSeverityWarning  = "warning"
+	AlarmSeverityCritical = "critical"
+)
+
+// Alarm is an operational condition raised by one of the OpenUSP services,
+// optionally about a single device
+type Alarm struct {
+	ID       string    `bson:"_id" json:"id"`
+	Type     string    `bson:"type" json:"type"`
+	Severity string    `bson:"severity" json:"severity"`
+	Tenant   string    `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	DeviceID string    `bson:"device_id,omitempty" json:"device_id,omitempty"`
+	Source   string    `bson:"source" json:"source"`
+	Message  string    `bson:"message" json:"message"`
+	RaisedAt time.Time `bson:"raised_at" json:"raised_at"`
+}
+
+// InsertAlarm stores a new alarm
+func (c *CwmpDb) InsertAlarm(alarm *Alarm) error {
+	if c.alarmColl == nil {
+		return errors.New("Alarm collection not initialized")
+	}
+
+	if alarm.ID == "" {
+		alarm.ID = primitive.NewObjectID().Hex()
+	}
+	if alarm.RaisedAt.IsZero() {
+		alarm.RaisedAt = time.Now()
+	}
+	_, err := c.alarmColl.InsertOne(context.Background(), alarm)
+	return err
+}
+
+// GetAlarms returns the alarms matching filter, newest first
+func (c *CwmpDb) GetAlarms(filter bson.M, limit int64) ([]Alarm, error) {
+	if c.alarmColl == nil {
+		return nil, errors.New("Alarm collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "raised_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.alarmColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	alarms := []Alarm{}
+	if err = cursor.All(ctx, &alarms); err != nil {
+		return nil, err
+	}
+	return alarms, nil
+}
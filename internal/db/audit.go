@@ -0,0 +1,94 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Outcomes of an audited operation
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+)
+
+// AuditEntry records a mutating API operation: who requested what, on which
+// device, and how it ended
+type AuditEntry struct {
+	ID        string    `bson:"_id" json:"id"`
+	Timestamp time.Time `bson:"timestamp" json:"timestamp"`
+	User      string    `bson:"user,omitempty" json:"user,omitempty"`
+	SourceIP  string    `bson:"source_ip" json:"source_ip"`
+	RequestID string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	// Action is the method and route template, such as
+	// POST /cwmp/device/{deviceId}/reboot
+	Action   string            `bson:"action" json:"action"`
+	Method   string            `bson:"method" json:"method"`
+	Path     string            `bson:"path" json:"path"`
+	DeviceID string            `bson:"device_id,omitempty" json:"device_id,omitempty"`
+	Params   map[string]string `bson:"params,omitempty" json:"params,omitempty"`
+	// Payload is the request body, with credentials redacted
+	Payload    string `bson:"payload,omitempty" json:"payload,omitempty"`
+	Status     int    `bson:"status" json:"status"`
+	Outcome    string `bson:"outcome" json:"outcome"`
+	Error      string `bson:"error,omitempty" json:"error,omitempty"`
+	DurationMs int64  `bson:"duration_ms" json:"duration_ms"`
+}
+
+// InsertAuditEntry stores an audit entry
+func (c *CwmpDb) InsertAuditEntry(entry *AuditEntry) error {
+	if c.auditColl == nil {
+		return errors.New("Audit collection not initialized")
+	}
+
+	if entry.ID == "" {
+		entry.ID = primitive.NewObjectID().Hex()
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	_, err := c.auditColl.InsertOne(context.Background(), entry)
+	return err
+}
+
+// GetAuditEntries returns the audit entries matching filter, newest first
+func (c *CwmpDb) GetAuditEntries(filter bson.M, limit int64) ([]AuditEntry, error) {
+	if c.auditColl == nil {
+		return nil, errors.New("Audit collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.auditColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	entries := []AuditEntry{}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
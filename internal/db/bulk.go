@@ -0,0 +1,107 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrBulkPreviewUnusable is returned when a preview has expired, was already
+// confirmed or belongs to another operation
+var ErrBulkPreviewUnusable = errors.New("bulk preview has expired, was already confirmed or is for another operation")
+
+// PlannedRPC is an RPC a bulk operation will send to a device
+type PlannedRPC struct {
+	Method    string            `bson:"method" json:"method"`
+	Arguments map[string]string `bson:"arguments,omitempty" json:"arguments,omitempty"`
+}
+
+// BulkPlannedDevice is a target device of a bulk operation with its planned RPCs
+type BulkPlannedDevice struct {
+	DeviceID string       `bson:"device_id" json:"device_id"`
+	RPCs     []PlannedRPC `bson:"rpcs" json:"rpcs"`
+}
+
+// BulkPreview is the resolved plan of a bulk operation produced by a dry run.
+// The operation is only executed once the preview is confirmed.
+type BulkPreview struct {
+	ID          string              `bson:"_id" json:"preview_id"`
+	Operation   string              `bson:"operation" json:"operation"`
+	Devices     []BulkPlannedDevice `bson:"devices" json:"devices"`
+	DeviceCount int                 `bson:"device_count" json:"device_count"`
+	CreatedBy   string              `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt   time.Time           `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time           `bson:"expires_at" json:"expires_at"`
+	ConfirmedAt *time.Time          `bson:"confirmed_at,omitempty" json:"confirmed_at,omitempty"`
+}
+
+// InsertBulkPreview stores a new bulk preview
+func (c *CwmpDb) InsertBulkPreview(preview *BulkPreview) error {
+	if c.bulkPreviewColl == nil {
+		return errors.New("Bulk preview collection not initialized")
+	}
+
+	if preview.ID == "" {
+		preview.ID = primitive.NewObjectID().Hex()
+	}
+	preview.DeviceCount = len(preview.Devices)
+	_, err := c.bulkPreviewColl.InsertOne(context.Background(), preview)
+	return err
+}
+
+// GetBulkPreview returns a bulk preview by ID
+func (c *CwmpDb) GetBulkPreview(id string) (*BulkPreview, error) {
+	if c.bulkPreviewColl == nil {
+		return nil, errors.New("Bulk preview collection not initialized")
+	}
+
+	var preview BulkPreview
+	if err := c.bulkPreviewColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&preview); err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
+
+// ConfirmBulkPreview marks an unexpired preview of operation as confirmed. A
+// preview can only be confirmed once.
+func (c *CwmpDb) ConfirmBulkPreview(id string, operation string) (*BulkPreview, error) {
+	if c.bulkPreviewColl == nil {
+		return nil, errors.New("Bulk preview collection not initialized")
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"_id":          id,
+		"operation":    operation,
+		"confirmed_at": bson.M{"$exists": false},
+		"expires_at":   bson.M{"$gt": now},
+	}
+	res, err := c.bulkPreviewColl.UpdateOne(context.Background(), filter, bson.M{"$set": bson.M{"confirmed_at": now}})
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := c.GetBulkPreview(id); err != nil {
+			return nil, err
+		}
+		return nil, ErrBulkPreviewUnusable
+	}
+	return c.GetBulkPreview(id)
+}
@@ -0,0 +1,217 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bulk job states
+const (
+	BulkJobRunning   = "running"
+	BulkJobCompleted = "completed"
+)
+
+// Bulk job result states. A result is pending until the RPCs of its device
+// were handed to the controller, it is then submitted, or failed if the
+// controller could not take them.
+const (
+	BulkResultPending   = "pending"
+	BulkResultSubmitted = "submitted"
+	BulkResultFailed    = "failed"
+)
+
+// BulkJob is the execution of a confirmed bulk preview. The counters are
+// updated as the results of its devices are settled.
+type BulkJob struct {
+	ID          string     `bson:"_id" json:"id"`
+	PreviewID   string     `bson:"preview_id" json:"preview_id"`
+	Operation   string     `bson:"operation" json:"operation"`
+	Status      string     `bson:"status" json:"status"`
+	Concurrency int        `bson:"concurrency" json:"concurrency"`
+	DeviceCount int        `bson:"device_count" json:"device_count"`
+	Submitted   int        `bson:"submitted" json:"submitted"`
+	Failed      int        `bson:"failed" json:"failed"`
+	CreatedBy   string     `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// BulkJobResult is the outcome of a bulk job on one device. The progress
+// of the submitted RPCs is tracked by the tasks TaskIDs.
+type BulkJobResult struct {
+	ID        string       `bson:"_id" json:"-"`
+	JobID     string       `bson:"job_id" json:"job_id"`
+	DeviceID  string       `bson:"device_id" json:"device_id"`
+	RPCs      []PlannedRPC `bson:"rpcs" json:"rpcs"`
+	Status    string       `bson:"status" json:"status"`
+	RequestID string       `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	TaskIDs   []string     `bson:"task_ids,omitempty" json:"task_ids,omitempty"`
+	Error     string       `bson:"error,omitempty" json:"error,omitempty"`
+	UpdatedAt time.Time    `bson:"updated_at" json:"updated_at"`
+}
+
+// InsertBulkJob stores a new running job with a pending result for each
+// device of the preview
+func (c *CwmpDb) InsertBulkJob(job *BulkJob, preview *BulkPreview) error {
+	if c.bulkJobColl == nil || c.bulkJobResultColl == nil {
+		return errors.New("Bulk job collection not initialized")
+	}
+
+	now := time.Now()
+	job.ID = primitive.NewObjectID().Hex()
+	job.PreviewID = preview.ID
+	job.Operation = preview.Operation
+	job.Status = BulkJobRunning
+	job.DeviceCount = len(preview.Devices)
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	ctx := context.Background()
+	if len(preview.Devices) > 0 {
+		results := make([]interface{}, 0, len(preview.Devices))
+		for _, d := range preview.Devices {
+			results = append(results, &BulkJobResult{
+				ID:        job.ID + ":" + d.DeviceID,
+				JobID:     job.ID,
+				DeviceID:  d.DeviceID,
+				RPCs:      d.RPCs,
+				Status:    BulkResultPending,
+				UpdatedAt: now,
+			})
+		}
+		if _, err := c.bulkJobResultColl.InsertMany(ctx, results, options.InsertMany().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+	_, err := c.bulkJobColl.InsertOne(ctx, job)
+	return err
+}
+
+// GetBulkJob returns a bulk job by ID
+func (c *CwmpDb) GetBulkJob(id string) (*BulkJob, error) {
+	if c.bulkJobColl == nil {
+		return nil, errors.New("Bulk job collection not initialized")
+	}
+
+	var job BulkJob
+	if err := c.bulkJobColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetBulkJobs returns the bulk jobs matching filter, newest first
+func (c *CwmpDb) GetBulkJobs(filter bson.M, limit int64) ([]BulkJob, error) {
+	if c.bulkJobColl == nil {
+		return nil, errors.New("Bulk job collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.bulkJobColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []BulkJob{}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetBulkJobResults returns the device results matching filter, ordered by
+// device ID
+func (c *CwmpDb) GetBulkJobResults(filter bson.M, limit int64) ([]BulkJobResult, error) {
+	if c.bulkJobResultColl == nil {
+		return nil, errors.New("Bulk job result collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "device_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.bulkJobResultColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	results := []BulkJobResult{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// SettleBulkJobResult stores the outcome of a pending device result and
+// counts it in its job. A result which was settled already is left as is.
+func (c *CwmpDb) SettleBulkJobResult(result *BulkJobResult) error {
+	if c.bulkJobColl == nil || c.bulkJobResultColl == nil {
+		return errors.New("Bulk job collection not initialized")
+	}
+
+	ctx := context.Background()
+	result.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"status":     result.Status,
+		"request_id": result.RequestID,
+		"task_ids":   result.TaskIDs,
+		"error":      result.Error,
+		"updated_at": result.UpdatedAt,
+	}}
+	res, err := c.bulkJobResultColl.UpdateOne(ctx, bson.M{"_id": result.ID, "status": BulkResultPending}, update)
+	if err != nil || res.ModifiedCount == 0 {
+		return err
+	}
+
+	counter := "submitted"
+	if result.Status == BulkResultFailed {
+		counter = "failed"
+	}
+	_, err = c.bulkJobColl.UpdateOne(ctx, bson.M{"_id": result.JobID}, bson.M{
+		"$inc": bson.M{counter: 1},
+		"$set": bson.M{"updated_at": result.UpdatedAt},
+	})
+	return err
+}
+
+// CompleteBulkJob marks a job whose device results are all settled as
+// completed
+func (c *CwmpDb) CompleteBulkJob(id string) (*BulkJob, error) {
+	if c.bulkJobColl == nil {
+		return nil, errors.New("Bulk job collection not initialized")
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"status": BulkJobCompleted, "updated_at": now, "completed_at": now}}
+	if _, err := c.bulkJobColl.UpdateOne(context.Background(), bson.M{"_id": id}, update); err != nil {
+		return nil, err
+	}
+	return c.GetBulkJob(id)
+}
@@ -0,0 +1,286 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Firmware campaign states. A pending campaign is activated when its window
+// opens, it is done once every device is settled or the window closed, and
+// aborted when too many devices failed.
+const (
+	CampaignPending = "pending"
+	CampaignActive  = "active"
+	CampaignPaused  = "paused"
+	CampaignDone    = "done"
+	CampaignAborted = "aborted"
+)
+
+// States of a device of a firmware campaign
+const (
+	CampaignDevicePending    = "pending"
+	CampaignDeviceInProgress = "in_progress"
+	CampaignDeviceSucceeded  = "succeeded"
+	CampaignDeviceFailed     = "failed"
+)
+
+// CampaignCounts counts the devices of a campaign by state
+type CampaignCounts struct {
+	Total      int `bson:"total" json:"total"`
+	Pending    int `bson:"pending" json:"pending"`
+	InProgress int `bson:"in_progress" json:"in_progress"`
+	Succeeded  int `bson:"succeeded" json:"succeeded"`
+	Failed     int `bson:"failed" json:"failed"`
+}
+
+// FirmwareCampaign upgrades the devices of a group to an image of the
+// firmware catalog, at most Concurrency devices at a time within its window
+type FirmwareCampaign struct {
+	ID            string     `bson:"_id" json:"id"`
+	Name          string     `bson:"name" json:"name"`
+	Group         string     `bson:"group" json:"group"`
+	FirmwareID    string     `bson:"firmware_id" json:"firmware_id"`
+	TargetVersion string     `bson:"target_version" json:"target_version"`
+	WindowStart   *time.Time `bson:"window_start,omitempty" json:"window_start,omitempty"`
+	WindowEnd     *time.Time `bson:"window_end,omitempty" json:"window_end,omitempty"`
+	Concurrency   int        `bson:"concurrency" json:"concurrency"`
+	// AbortThreshold is the percentage of failed devices which aborts the
+	// campaign, 0 never aborts it
+	AbortThreshold int            `bson:"abort_threshold" json:"abort_threshold"`
+	Status         string         `bson:"status" json:"status"`
+	StatusReason   string         `bson:"status_reason,omitempty" json:"status_reason,omitempty"`
+	Counts         CampaignCounts `bson:"counts" json:"counts"`
+	CreatedBy      string         `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt      time.Time      `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time      `bson:"updated_at" json:"updated_at"`
+	StartedAt      *time.Time     `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt    *time.Time     `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// CampaignDevice is the upgrade of a device by a firmware campaign
+type CampaignDevice struct {
+	ID            string     `bson:"_id" json:"-"`
+	CampaignID    string     `bson:"campaign_id" json:"campaign_id"`
+	DeviceID      string     `bson:"device_id" json:"device_id"`
+	Status        string     `bson:"status" json:"status"`
+	TransferID    string     `bson:"transfer_id,omitempty" json:"transfer_id,omitempty"`
+	FirmwareJobID string     `bson:"firmware_job_id,omitempty" json:"firmware_job_id,omitempty"`
+	Error         string     `bson:"error,omitempty" json:"error,omitempty"`
+	StartedAt     *time.Time `bson:"started_at,omitempty" json:"started_at,omitempty"`
+	CompletedAt   *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	UpdatedAt     time.Time  `bson:"updated_at" json:"updated_at"`
+}
+
+// InsertFirmwareCampaign stores a new pending campaign
+func (c *CwmpDb) InsertFirmwareCampaign(campaign *FirmwareCampaign) error {
+	if c.campaignColl == nil {
+		return errors.New("Firmware campaign collection not initialized")
+	}
+
+	now := time.Now()
+	campaign.ID = primitive.NewObjectID().Hex()
+	campaign.Status = CampaignPending
+	campaign.CreatedAt = now
+	campaign.UpdatedAt = now
+	_, err := c.campaignColl.InsertOne(context.Background(), campaign)
+	return err
+}
+
+// GetFirmwareCampaign returns a firmware campaign by ID
+func (c *CwmpDb) GetFirmwareCampaign(id string) (*FirmwareCampaign, error) {
+	if c.campaignColl == nil {
+		return nil, errors.New("Firmware campaign collection not initialized")
+	}
+
+	var campaign FirmwareCampaign
+	if err := c.campaignColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&campaign); err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// GetFirmwareCampaigns returns the campaigns matching filter, newest first
+func (c *CwmpDb) GetFirmwareCampaigns(filter bson.M, limit int64) ([]FirmwareCampaign, error) {
+	if c.campaignColl == nil {
+		return nil, errors.New("Firmware campaign collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.campaignColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	campaigns := []FirmwareCampaign{}
+	if err = cursor.All(ctx, &campaigns); err != nil {
+		return nil, err
+	}
+	return campaigns, nil
+}
+
+// UpdateFirmwareCampaign sets fields of a campaign which is in one of the
+// given states. It returns mongo.ErrNoDocuments if the campaign is missing
+// or in another state.
+func (c *CwmpDb) UpdateFirmwareCampaign(id string, states []string, set bson.M) (*FirmwareCampaign, error) {
+	if c.campaignColl == nil {
+		return nil, errors.New("Firmware campaign collection not initialized")
+	}
+
+	set["updated_at"] = time.Now()
+	filter := bson.M{"_id": id, "status": bson.M{"$in": states}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var campaign FirmwareCampaign
+	if err := c.campaignColl.FindOneAndUpdate(context.Background(), filter, bson.M{"$set": set}, opts).Decode(&campaign); err != nil {
+		return nil, err
+	}
+	return &campaign, nil
+}
+
+// DeleteFirmwareCampaign removes a campaign in one of the given states with
+// its devices
+func (c *CwmpDb) DeleteFirmwareCampaign(id string, states []string) error {
+	if c.campaignColl == nil || c.campaignDeviceColl == nil {
+		return errors.New("Firmware campaign collection not initialized")
+	}
+
+	ctx := context.Background()
+	res, err := c.campaignColl.DeleteOne(ctx, bson.M{"_id": id, "status": bson.M{"$in": states}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = c.campaignDeviceColl.DeleteMany(ctx, bson.M{"campaign_id": id})
+	return err
+}
+
+// InsertCampaignDevices adds pending devices to a campaign, devices it
+// already has are kept as they are
+func (c *CwmpDb) InsertCampaignDevices(campaignID string, deviceIDs []string) error {
+	if c.campaignDeviceColl == nil {
+		return errors.New("Firmware campaign device collection not initialized")
+	}
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	devices := make([]interface{}, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		devices = append(devices, &CampaignDevice{
+			ID:         campaignID + ":" + id,
+			CampaignID: campaignID,
+			DeviceID:   id,
+			Status:     CampaignDevicePending,
+			UpdatedAt:  now,
+		})
+	}
+	_, err := c.campaignDeviceColl.InsertMany(context.Background(), devices, options.InsertMany().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	}
+	return err
+}
+
+// GetCampaignDevices returns the campaign devices matching filter, in the
+// order they were started
+func (c *CwmpDb) GetCampaignDevices(filter bson.M, limit int64) ([]CampaignDevice, error) {
+	if c.campaignDeviceColl == nil {
+		return nil, errors.New("Firmware campaign device collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "started_at", Value: 1}, {Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.campaignDeviceColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []CampaignDevice{}
+	if err = cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// SaveCampaignDevice stores the state of a campaign device
+func (c *CwmpDb) SaveCampaignDevice(device *CampaignDevice) error {
+	if c.campaignDeviceColl == nil {
+		return errors.New("Firmware campaign device collection not initialized")
+	}
+
+	device.UpdatedAt = time.Now()
+	_, err := c.campaignDeviceColl.ReplaceOne(context.Background(), bson.M{"_id": device.ID}, device)
+	return err
+}
+
+// CountCampaignDevices counts the devices of a campaign by state
+func (c *CwmpDb) CountCampaignDevices(campaignID string) (CampaignCounts, error) {
+	var counts CampaignCounts
+	if c.campaignDeviceColl == nil {
+		return counts, errors.New("Firmware campaign device collection not initialized")
+	}
+
+	ctx := context.Background()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"campaign_id": campaignID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	}
+	cursor, err := c.campaignDeviceColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return counts, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Status string `bson:"_id"`
+		Count  int    `bson:"count"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return counts, err
+	}
+	for _, g := range groups {
+		counts.Total += g.Count
+		switch g.Status {
+		case CampaignDevicePending:
+			counts.Pending = g.Count
+		case CampaignDeviceInProgress:
+			counts.InProgress = g.Count
+		case CampaignDeviceSucceeded:
+			counts.Succeeded = g.Count
+		case CampaignDeviceFailed:
+			counts.Failed = g.Count
+		}
+	}
+	return counts, nil
+}
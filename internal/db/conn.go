@@ -22,20 +22,33 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/n4-networks/openusp/pkg/config"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type dbCfg struct {
 	serverAddr string
+	uri        string
 	name       string
 	userName   string
 	passwd     string
-	timeout    int // in minute
+	timeout    time.Duration
+	eventTTL   time.Duration
+	historyTTL time.Duration
 }
 
 var cfg dbCfg
 
+// commandMonitor observes the commands of the clients connected afterwards
+var commandMonitor *event.CommandMonitor
+
+// SetCommandMonitor sets the monitor of the commands sent by the clients
+// connected from now on, e.g. to time the queries
+func SetCommandMonitor(monitor *event.CommandMonitor) {
+	commandMonitor = monitor
+}
+
 func readConfigFromYAML() error {
 	// Try to load configuration from YAML files
 	// First try service-specific configs, then fall back to generic config
@@ -44,22 +57,27 @@ func readConfigFromYAML() error {
 		log.Printf("Failed to load YAML configuration: %v", err)
 		return err
 	}
+	applyConfig(yamlConfig)
+	return nil
+}
 
-	// Map YAML config to legacy dbCfg struct
+// applyConfig maps the database section of the YAML config to dbCfg
+func applyConfig(yamlConfig *config.Config) {
 	cfg.serverAddr = fmt.Sprintf("%s:%d", yamlConfig.Database.Host, yamlConfig.Database.Port)
 	cfg.userName = yamlConfig.Database.Username
 	cfg.passwd = yamlConfig.Database.Password
 	cfg.name = yamlConfig.Database.Name
+	cfg.uri = yamlConfig.Database.URI
 	
-	// Convert timeout from duration to minutes (legacy format)
 	if yamlConfig.Database.Pool.Timeout > 0 {
-		cfg.timeout = int(yamlConfig.Database.Pool.Timeout.Minutes())
+		cfg.timeout = yamlConfig.Database.Pool.Timeout
 	} else {
-		cfg.timeout = 3 // Default 3 minutes
+		cfg.timeout = 3 * time.Minute
 	}
+	cfg.eventTTL = yamlConfig.Database.EventRetention
+	cfg.historyTTL = yamlConfig.Database.ParamHistoryRetention
 
-	log.Printf("DB Config params: %+v\n", cfg)
-	return nil
+	log.Printf("DB Config params: addr=%s name=%s timeout=%v", cfg.serverAddr, cfg.name, cfg.timeout)
 }
 
 // tryLoadConfig attempts to load configuration from various sources
@@ -86,13 +104,33 @@ func Connect() (*mongo.Client, error) {
 	if err := readConfigFromYAML(); err != nil {
 		return nil, err
 	}
-	cred := options.Credential{Username: cfg.userName, Password: cfg.passwd}
-	client, err := mongo.NewClient(options.Client().ApplyURI("mongodb://" + cfg.serverAddr).SetAuth(cred))
+	return connect()
+}
+
+// ConnectWithConfig connects to the database of the given service config
+func ConnectWithConfig(yamlConfig *config.Config) (*mongo.Client, error) {
+	applyConfig(yamlConfig)
+	return connect()
+}
+
+func connect() (*mongo.Client, error) {
+	opts := options.Client()
+	if cfg.uri != "" {
+		opts.ApplyURI(cfg.uri)
+	} else {
+		opts.ApplyURI("mongodb://" + cfg.serverAddr)
+		if cfg.userName != "" {
+			opts.SetAuth(options.Credential{Username: cfg.userName, Password: cfg.passwd})
+		}
+	}
+	if commandMonitor != nil {
+		opts.SetMonitor(commandMonitor)
+	}
+	client, err := mongo.NewClient(opts)
 	if err != nil {
 		return nil, err
 	}
-	timeout := time.Duration(cfg.timeout) * time.Minute
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
+	ctx, _ := context.WithTimeout(context.Background(), cfg.timeout)
 	if err = client.Connect(ctx); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,166 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Credential rotation states. A rotation is requested by the API, pushed once
+// the ACS queued the SetParameterValues carrying the new credentials and
+// applied once the device accepted it. A connection request with the new
+// credentials then commits them to the device record, a UDP connection
+// request is verifying until the device informs. A rotation which cannot be
+// verified is rolled back by pushing the previous credentials again.
+const (
+	CredRotationRequested  = "requested"
+	CredRotationPushed     = "pushed"
+	CredRotationApplied    = "applied"
+	CredRotationVerifying  = "verifying"
+	CredRotationCommitted  = "committed"
+	CredRotationRolledBack = "rolled_back"
+	CredRotationFailed     = "failed"
+)
+
+// activeCredRotationStates are the states of rotations which are not finished
+var activeCredRotationStates = []string{CredRotationRequested, CredRotationPushed, CredRotationApplied, CredRotationVerifying}
+
+// CredentialRotation tracks the replacement of the connection request
+// credentials of a device. The passwords are never returned by the API.
+type CredentialRotation struct {
+	ID               string     `bson:"_id" json:"id"`
+	DeviceID         string     `bson:"device_id" json:"device_id"`
+	Status           string     `bson:"status" json:"status"`
+	Username         string     `bson:"username,omitempty" json:"username,omitempty"`
+	Password         string     `bson:"password,omitempty" json:"-"`
+	PreviousUsername string     `bson:"previous_username,omitempty" json:"previous_username,omitempty"`
+	PreviousPassword string     `bson:"previous_password,omitempty" json:"-"`
+	Transport        string     `bson:"transport,omitempty" json:"transport,omitempty"` // of the verification connection request
+	Error            string     `bson:"error,omitempty" json:"error,omitempty"`
+	RequestID        string     `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	CreatedAt        time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time  `bson:"updated_at" json:"updated_at"`
+	CompletedAt      *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// InsertCredentialRotation stores a requested rotation
+func (c *CwmpDb) InsertCredentialRotation(rotation *CredentialRotation) error {
+	if c.credRotationColl == nil {
+		return errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	now := time.Now()
+	rotation.ID = primitive.NewObjectID().Hex()
+	rotation.Status = CredRotationRequested
+	rotation.CreatedAt = now
+	rotation.UpdatedAt = now
+	_, err := c.credRotationColl.InsertOne(context.Background(), rotation)
+	return err
+}
+
+// UpdateCredentialRotation replaces a rotation
+func (c *CwmpDb) UpdateCredentialRotation(rotation *CredentialRotation) error {
+	if c.credRotationColl == nil {
+		return errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	rotation.UpdatedAt = time.Now()
+	_, err := c.credRotationColl.ReplaceOne(context.Background(), bson.M{"_id": rotation.ID}, rotation)
+	return err
+}
+
+// TransitionCredentialRotation moves a rotation from one state to another
+// and returns it. mongo.ErrNoDocuments is returned if the rotation is not in
+// the from state, another routine then moved it already.
+func (c *CwmpDb) TransitionCredentialRotation(id string, from string, to string, detail string) (*CredentialRotation, error) {
+	if c.credRotationColl == nil {
+		return nil, errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	now := time.Now()
+	set := bson.M{"status": to, "updated_at": now}
+	if detail != "" {
+		set["error"] = detail
+	}
+	switch to {
+	case CredRotationCommitted, CredRotationRolledBack, CredRotationFailed:
+		set["completed_at"] = now
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var rotation CredentialRotation
+	err := c.credRotationColl.FindOneAndUpdate(context.Background(), bson.M{"_id": id, "status": from}, bson.M{"$set": set}, opts).Decode(&rotation)
+	if err != nil {
+		return nil, err
+	}
+	return &rotation, nil
+}
+
+// GetCredentialRotation returns a rotation by ID
+func (c *CwmpDb) GetCredentialRotation(id string) (*CredentialRotation, error) {
+	if c.credRotationColl == nil {
+		return nil, errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	var rotation CredentialRotation
+	if err := c.credRotationColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&rotation); err != nil {
+		return nil, err
+	}
+	return &rotation, nil
+}
+
+// GetActiveCredentialRotation returns the unfinished rotation of a device
+func (c *CwmpDb) GetActiveCredentialRotation(deviceID string) (*CredentialRotation, error) {
+	if c.credRotationColl == nil {
+		return nil, errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	filter := bson.M{"device_id": deviceID, "status": bson.M{"$in": activeCredRotationStates}}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var rotation CredentialRotation
+	if err := c.credRotationColl.FindOne(context.Background(), filter, opts).Decode(&rotation); err != nil {
+		return nil, err
+	}
+	return &rotation, nil
+}
+
+// GetCredentialRotations returns the rotations matching filter, newest first
+func (c *CwmpDb) GetCredentialRotations(filter bson.M, limit int64) ([]CredentialRotation, error) {
+	if c.credRotationColl == nil {
+		return nil, errors.New("CWMP credential rotation collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.credRotationColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rotations := []CredentialRotation{}
+	if err = cursor.All(ctx, &rotations); err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
@@ -0,0 +1,246 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// RollupDateFormat is the format of a daily rollup's ID
+const RollupDateFormat = "2006-01-02"
+
+// maxRollupFaults is the number of fault codes kept in a daily rollup
+const maxRollupFaults = 10
+
+// FirmwareAdoption holds the number of devices running a software version
+// and the change against the previous day
+type FirmwareAdoption struct {
+	SoftwareVersion string `bson:"software_version" json:"software_version"`
+	Devices         int64  `bson:"devices" json:"devices"`
+	Delta           int64  `bson:"delta" json:"delta"`
+}
+
+// FaultCount holds the number of occurrences of a fault code
+type FaultCount struct {
+	FaultCode string `bson:"fault_code" json:"fault_code"`
+	Count     int64  `bson:"count" json:"count"`
+}
+
+// CwmpDailyRollup holds the precomputed statistics of a single day (UTC)
+type CwmpDailyRollup struct {
+	ID           string             `bson:"_id" json:"date"`
+	Date         time.Time          `bson:"date" json:"-"`
+	Informs      int64              `bson:"informs" json:"informs"`
+	NewDevices   int64              `bson:"new_devices" json:"new_devices"`
+	TotalDevices int64              `bson:"total_devices" json:"total_devices"`
+	Firmware     []FirmwareAdoption `bson:"firmware" json:"firmware"`
+	TopFaults    []FaultCount       `bson:"top_faults" json:"top_faults"`
+	GeneratedAt  time.Time          `bson:"generated_at" json:"generated_at"`
+}
+
+// BuildCwmpDailyRollup aggregates the statistics of the day containing t.
+// Firmware adoption reflects the software versions known at aggregation time
+// of the devices registered by the end of the day.
+func (c *CwmpDb) BuildCwmpDailyRollup(t time.Time) (*CwmpDailyRollup, error) {
+	if c.cwmpDeviceColl == nil || c.cwmpEventColl == nil || c.cwmpFileColl == nil || c.cwmpStatsColl == nil {
+		return nil, errors.New("CWMP collections not initialized")
+	}
+
+	ctx := context.Background()
+	start := t.UTC().Truncate(24 * time.Hour)
+	end := start.Add(24 * time.Hour)
+	rollup := &CwmpDailyRollup{
+		ID:          start.Format(RollupDateFormat),
+		Date:        start,
+		GeneratedAt: time.Now(),
+	}
+
+	// Informs are recorded as TR-069 events ("0 BOOTSTRAP", "2 PERIODIC", ...),
+	// all events of one Inform share the device and timestamp
+	informs, err := c.countAggregate(ctx, c.cwmpEventColl, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"timestamp":  bson.M{"$gte": start, "$lt": end},
+			"event_code": bson.M{"$regex": "^[0-9]+ "},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"device_id": "$device_id", "timestamp": "$timestamp"},
+		}}},
+		{{Key: "$count", Value: "count"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	rollup.Informs = informs
+
+	createdFilter := bson.M{"created_at": bson.M{"$gte": start, "$lt": end}}
+	if rollup.NewDevices, err = c.cwmpDeviceColl.CountDocuments(ctx, createdFilter); err != nil {
+		return nil, err
+	}
+	registeredFilter := bson.M{"created_at": bson.M{"$lt": end}}
+	if rollup.TotalDevices, err = c.cwmpDeviceColl.CountDocuments(ctx, registeredFilter); err != nil {
+		return nil, err
+	}
+
+	if rollup.Firmware, err = c.aggregateFirmware(ctx, registeredFilter, start); err != nil {
+		return nil, err
+	}
+	if rollup.TopFaults, err = c.aggregateFaults(ctx, start, end); err != nil {
+		return nil, err
+	}
+	return rollup, nil
+}
+
+func (c *CwmpDb) countAggregate(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) (int64, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var res []struct {
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].Count, nil
+}
+
+func (c *CwmpDb) aggregateFirmware(ctx context.Context, filter bson.M, day time.Time) ([]FirmwareAdoption, error) {
+	cursor, err := c.cwmpDeviceColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$software_version", "devices": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "devices", Value: -1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var res []struct {
+		Version string `bson:"_id"`
+		Devices int64  `bson:"devices"`
+	}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	// Deltas are relative to the previous day's rollup, if there is one
+	prevCounts := make(map[string]int64)
+	if prev, err := c.GetCwmpDailyRollup(day.Add(-24 * time.Hour).Format(RollupDateFormat)); err == nil {
+		for _, fw := range prev.Firmware {
+			prevCounts[fw.SoftwareVersion] = fw.Devices
+		}
+	}
+
+	firmware := []FirmwareAdoption{}
+	for _, r := range res {
+		firmware = append(firmware, FirmwareAdoption{
+			SoftwareVersion: r.Version,
+			Devices:         r.Devices,
+			Delta:           r.Devices - prevCounts[r.Version],
+		})
+		delete(prevCounts, r.Version)
+	}
+	for version, devices := range prevCounts {
+		firmware = append(firmware, FirmwareAdoption{SoftwareVersion: version, Delta: -devices})
+	}
+	return firmware, nil
+}
+
+func (c *CwmpDb) aggregateFaults(ctx context.Context, start time.Time, end time.Time) ([]FaultCount, error) {
+	cursor, err := c.cwmpFileColl.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"complete_time": bson.M{"$gte": start, "$lt": end},
+			"fault_code":    bson.M{"$nin": bson.A{"", "0"}},
+		}}},
+		{{Key: "$group", Value: bson.M{"_id": "$fault_code", "count": bson.M{"$sum": 1}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "count", Value: -1}}}},
+		{{Key: "$limit", Value: maxRollupFaults}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var res []struct {
+		FaultCode string `bson:"_id"`
+		Count     int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+
+	faults := []FaultCount{}
+	for _, r := range res {
+		faults = append(faults, FaultCount{FaultCode: r.FaultCode, Count: r.Count})
+	}
+	return faults, nil
+}
+
+// UpsertCwmpDailyRollup stores a daily rollup, replacing an earlier one of the same day
+func (c *CwmpDb) UpsertCwmpDailyRollup(rollup *CwmpDailyRollup) error {
+	if c.cwmpStatsColl == nil {
+		return errors.New("CWMP analytics collection not initialized")
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpStatsColl.ReplaceOne(context.Background(), bson.M{"_id": rollup.ID}, rollup, opts)
+	return err
+}
+
+// GetCwmpDailyRollup returns the rollup of a day given as YYYY-MM-DD
+func (c *CwmpDb) GetCwmpDailyRollup(date string) (*CwmpDailyRollup, error) {
+	if c.cwmpStatsColl == nil {
+		return nil, errors.New("CWMP analytics collection not initialized")
+	}
+
+	var rollup CwmpDailyRollup
+	if err := c.cwmpStatsColl.FindOne(context.Background(), bson.M{"_id": date}).Decode(&rollup); err != nil {
+		return nil, err
+	}
+	return &rollup, nil
+}
+
+// GetCwmpDailyRollups returns the rollups between from and to, oldest first
+func (c *CwmpDb) GetCwmpDailyRollups(from time.Time, to time.Time) ([]CwmpDailyRollup, error) {
+	if c.cwmpStatsColl == nil {
+		return nil, errors.New("CWMP analytics collection not initialized")
+	}
+
+	ctx := context.Background()
+	filter := bson.M{"date": bson.M{"$gte": from.UTC().Truncate(24 * time.Hour), "$lte": to}}
+	opts := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+	cursor, err := c.cwmpStatsColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rollups := []CwmpDailyRollup{}
+	if err = cursor.All(ctx, &rollups); err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
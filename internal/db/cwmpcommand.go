@@ -0,0 +1,252 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a queued command. A command is queued until it is sent to the
+// device, delivered until the device answers it, and expires if the device
+// does not connect before its TTL. A command which failed is queued again
+// until it runs out of attempts, it is then failed.
+const (
+	CommandStatusQueued    = "queued"
+	CommandStatusDelivered = "delivered"
+	CommandStatusAnswered  = "answered"
+	CommandStatusExpired   = "expired"
+	CommandStatusFailed    = "failed"
+)
+
+// Outcomes of the parameters of a SetParameterValues command
+const (
+	ParameterStatusApplied = "applied"
+	ParameterStatusFailed  = "failed"
+)
+
+// ParameterResult is the outcome of one parameter of a SetParameterValues
+// command
+type ParameterResult struct {
+	Path        string `bson:"path" json:"path"`
+	Status      string `bson:"status" json:"status"`
+	FaultCode   string `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString string `bson:"fault_string,omitempty" json:"fault_string,omitempty"`
+}
+
+// CommandAttempt is a failed delivery of a command
+type CommandAttempt struct {
+	Attempt     int       `bson:"attempt" json:"attempt"`
+	FaultCode   string    `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString string    `bson:"fault_string" json:"fault_string"`
+	At          time.Time `bson:"at" json:"at"`
+}
+
+// CwmpCommand is an RPC requested for a device, held until the device opens
+// a session
+type CwmpCommand struct {
+	ID        string `bson:"_id" json:"id"`
+	DeviceID  string `bson:"device_id" json:"device_id"`
+	Method    string `bson:"method" json:"method"`
+	RequestID string `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	// Request is the command as received on the ACS bus, in JSON. It is not
+	// returned by the API as it may carry transfer credentials.
+	Request     string     `bson:"request" json:"-"`
+	Status      string     `bson:"status" json:"status"`
+	FaultCode   string     `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString string     `bson:"fault_string,omitempty" json:"fault_string,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	ExpiresAt   time.Time  `bson:"expires_at" json:"expires_at"`
+	DeliveredAt *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+	AnsweredAt  *time.Time `bson:"answered_at,omitempty" json:"answered_at,omitempty"`
+	// Attempts lists the failed deliveries of the command, a retried
+	// command is not sent before NextAttemptAt
+	Attempts      []CommandAttempt `bson:"attempts,omitempty" json:"attempts,omitempty"`
+	NextAttemptAt *time.Time       `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	// ParameterResults is the outcome of each parameter of an answered
+	// SetParameterValues command
+	ParameterResults []ParameterResult `bson:"parameter_results,omitempty" json:"parameter_results,omitempty"`
+}
+
+// InsertCwmpCommand queues a command
+func (c *CwmpDb) InsertCwmpCommand(cmd *CwmpCommand) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	cmd.ID = primitive.NewObjectID().Hex()
+	cmd.CreatedAt = time.Now()
+	cmd.Status = CommandStatusQueued
+	_, err := c.cwmpCommandColl.InsertOne(context.Background(), cmd)
+	return err
+}
+
+// GetCwmpCommand returns a command by ID
+func (c *CwmpDb) GetCwmpCommand(id string) (*CwmpCommand, error) {
+	if c.cwmpCommandColl == nil {
+		return nil, errors.New("CWMP command collection not initialized")
+	}
+
+	var cmd CwmpCommand
+	if err := c.cwmpCommandColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&cmd); err != nil {
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+// GetCwmpCommands returns the commands matching filter, newest first
+func (c *CwmpDb) GetCwmpCommands(filter bson.M, limit int64) ([]CwmpCommand, error) {
+	if c.cwmpCommandColl == nil {
+		return nil, errors.New("CWMP command collection not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return c.findCwmpCommands(filter, opts)
+}
+
+// GetQueuedCwmpCommands returns the unexpired queued commands of a device in
+// the order they were queued
+func (c *CwmpDb) GetQueuedCwmpCommands(deviceID string) ([]CwmpCommand, error) {
+	if c.cwmpCommandColl == nil {
+		return nil, errors.New("CWMP command collection not initialized")
+	}
+
+	now := time.Now()
+	filter := bson.M{
+		"device_id":  deviceID,
+		"status":     CommandStatusQueued,
+		"expires_at": bson.M{"$gt": now},
+		"$or": bson.A{
+			bson.M{"next_attempt_at": bson.M{"$exists": false}},
+			bson.M{"next_attempt_at": bson.M{"$lte": now}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	return c.findCwmpCommands(filter, opts)
+}
+
+// MarkCwmpCommandDelivered records that a command was sent to the device
+func (c *CwmpDb) MarkCwmpCommandDelivered(id string) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"status": CommandStatusDelivered, "delivered_at": time.Now()}}
+	_, err := c.cwmpCommandColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// MarkCwmpCommandAnswered records the answer of the device to a command,
+// with the fault it answered with if any
+func (c *CwmpDb) MarkCwmpCommandAnswered(id string, faultCode string, faultString string) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	set := bson.M{"status": CommandStatusAnswered, "answered_at": time.Now()}
+	if faultCode != "" {
+		set["fault_code"] = faultCode
+		set["fault_string"] = faultString
+	}
+	_, err := c.cwmpCommandColl.UpdateOne(context.Background(), bson.M{"_id": id}, bson.M{"$set": set})
+	return err
+}
+
+// SetCwmpCommandParameterResults stores the outcome of each parameter of a
+// SetParameterValues command
+func (c *CwmpDb) SetCwmpCommandParameterResults(id string, results []ParameterResult) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"parameter_results": results}}
+	_, err := c.cwmpCommandColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// RetryCwmpCommand records a failed attempt of a command and queues it
+// again, it is not sent before next
+func (c *CwmpDb) RetryCwmpCommand(id string, attempt CommandAttempt, next time.Time) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	update := bson.M{
+		"$set":  bson.M{"status": CommandStatusQueued, "next_attempt_at": next},
+		"$push": bson.M{"attempts": attempt},
+	}
+	_, err := c.cwmpCommandColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// FailCwmpCommand records the last failed attempt of a command which ran out
+// of attempts
+func (c *CwmpDb) FailCwmpCommand(id string, attempt CommandAttempt) error {
+	if c.cwmpCommandColl == nil {
+		return errors.New("CWMP command collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":       CommandStatusFailed,
+			"fault_code":   attempt.FaultCode,
+			"fault_string": attempt.FaultString,
+			"answered_at":  attempt.At,
+		},
+		"$unset": bson.M{"next_attempt_at": ""},
+		"$push":  bson.M{"attempts": attempt},
+	}
+	_, err := c.cwmpCommandColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// ExpireCwmpCommands expires the queued commands whose TTL elapsed and
+// returns how many were expired
+func (c *CwmpDb) ExpireCwmpCommands() (int64, error) {
+	if c.cwmpCommandColl == nil {
+		return 0, errors.New("CWMP command collection not initialized")
+	}
+
+	filter := bson.M{"status": CommandStatusQueued, "expires_at": bson.M{"$lte": time.Now()}}
+	update := bson.M{"$set": bson.M{"status": CommandStatusExpired}}
+	res, err := c.cwmpCommandColl.UpdateMany(context.Background(), filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+func (c *CwmpDb) findCwmpCommands(filter bson.M, opts *options.FindOptions) ([]CwmpCommand, error) {
+	ctx := context.Background()
+	cursor, err := c.cwmpCommandColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	cmds := []CwmpCommand{}
+	if err = cursor.All(ctx, &cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
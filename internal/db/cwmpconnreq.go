@@ -0,0 +1,233 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Outcomes of a connection request
+const (
+	ConnReqDelivered   = "delivered"
+	ConnReqAuthFailed  = "auth_failed"
+	ConnReqTimeout     = "timeout"
+	ConnReqUnreachable = "unreachable"
+	ConnReqFailed      = "failed"
+	// ConnReqSent is the outcome of UDP connection requests, which the
+	// device does not acknowledge
+	ConnReqSent = "sent"
+)
+
+// Connection request transports
+const (
+	ConnReqTransportHTTP = "http"
+	ConnReqTransportUDP  = "udp"
+)
+
+// ConnRequestOutcome is the result of the last connection request sent to a
+// device
+type ConnRequestOutcome struct {
+	// ID identifies the connection request in the connection request
+	// history, see GetCwmpConnRequest
+	ID         string    `bson:"_id,omitempty" json:"id,omitempty"`
+	Status     string    `bson:"status" json:"status"`
+	Transport  string    `bson:"transport,omitempty" json:"transport,omitempty"`
+	HTTPStatus int       `bson:"http_status,omitempty" json:"http_status,omitempty"`
+	Attempts   int       `bson:"attempts" json:"attempts"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	URL        string    `bson:"url" json:"url"`
+	RequestID  string    `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	SentAt     time.Time `bson:"sent_at" json:"sent_at"`
+	DurationMs int64     `bson:"duration_ms" json:"duration_ms"`
+}
+
+// Delivered reports whether the device acknowledged the connection request
+func (o *ConnRequestOutcome) Delivered() bool {
+	return o.Status == ConnReqDelivered
+}
+
+// CwmpConnRequest is a connection request of the connection request history
+// with whether and when the device informed afterwards
+type CwmpConnRequest struct {
+	ConnRequestOutcome `bson:",inline"`
+	DeviceID           string     `bson:"device_id" json:"device_id"`
+	Informed           bool       `bson:"informed" json:"informed"`
+	InformedAt         *time.Time `bson:"informed_at,omitempty" json:"informed_at,omitempty"`
+	// InformDelayMs is the time from sending the request to the Inform
+	InformDelayMs int64 `bson:"inform_delay_ms,omitempty" json:"inform_delay_ms,omitempty"`
+}
+
+// createCwmpConnRequestIndexes creates the connection request indexes. The
+// history is kept as long as the device events.
+func (c *CwmpDb) createCwmpConnRequestIndexes(ctx context.Context) error {
+	retention := cfg.eventTTL
+	if retention <= 0 {
+		retention = DefaultEventRetention
+	}
+
+	connReqIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "informed", Value: 1}, {Key: "sent_at", Value: -1}},
+		},
+	}
+	if _, err := c.cwmpConnReqColl.Indexes().CreateMany(ctx, connReqIndexes); err != nil {
+		return err
+	}
+
+	ttlIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "sent_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	}
+	if _, err := c.cwmpConnReqColl.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+		// An existing TTL index with a different retention has to be changed by hand
+		log.Printf("Warning: could not create CWMP connection request TTL index: %v", err)
+	}
+	return nil
+}
+
+// InsertCwmpConnRequest adds the outcome of a connection request to the
+// connection request history and sets its ID
+func (c *CwmpDb) InsertCwmpConnRequest(deviceID string, outcome *ConnRequestOutcome) error {
+	if c.cwmpConnReqColl == nil {
+		return errors.New("CWMP connection request collection not initialized")
+	}
+
+	outcome.ID = primitive.NewObjectID().Hex()
+	record := &CwmpConnRequest{ConnRequestOutcome: *outcome, DeviceID: deviceID}
+	_, err := c.cwmpConnReqColl.InsertOne(context.Background(), record)
+	return err
+}
+
+// GetCwmpConnRequest returns a connection request of the history
+func (c *CwmpDb) GetCwmpConnRequest(id string) (*CwmpConnRequest, error) {
+	if c.cwmpConnReqColl == nil {
+		return nil, errors.New("CWMP connection request collection not initialized")
+	}
+
+	var record CwmpConnRequest
+	if err := c.cwmpConnReqColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// SetCwmpConnRequestsInformed marks the connection requests the device
+// accepted since the given time, and has not informed for yet, as answered
+// by an Inform at informedAt. It returns the number of requests marked.
+func (c *CwmpDb) SetCwmpConnRequestsInformed(deviceID string, since time.Time, informedAt time.Time) (int, error) {
+	if c.cwmpConnReqColl == nil {
+		return 0, errors.New("CWMP connection request collection not initialized")
+	}
+
+	ctx := context.Background()
+	filter := bson.M{
+		"device_id": deviceID,
+		"informed":  false,
+		"status":    bson.M{"$in": bson.A{ConnReqDelivered, ConnReqSent}},
+		"sent_at":   bson.M{"$gte": since},
+	}
+	cursor, err := c.cwmpConnReqColl.Find(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	var records []CwmpConnRequest
+	if err := cursor.All(ctx, &records); err != nil {
+		return 0, err
+	}
+
+	marked := 0
+	for _, record := range records {
+		update := bson.M{"$set": bson.M{
+			"informed":        true,
+			"informed_at":     informedAt,
+			"inform_delay_ms": informedAt.Sub(record.SentAt).Milliseconds(),
+		}}
+		res, err := c.cwmpConnReqColl.UpdateOne(ctx, bson.M{"_id": record.ID, "informed": false}, update)
+		if err != nil {
+			return marked, err
+		}
+		marked += int(res.ModifiedCount)
+	}
+	return marked, nil
+}
+
+// UpdateCwmpDeviceConnRequest stores the outcome of the last connection
+// request sent to a device
+func (c *CwmpDb) UpdateCwmpDeviceConnRequest(deviceID string, outcome *ConnRequestOutcome) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"last_connection_request": outcome,
+			"updated_at":              time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
+
+// UpdateCwmpDeviceUDPConnRequest stores the TR-111 parameters a device
+// reported in an Inform
+func (c *CwmpDb) UpdateCwmpDeviceUDPConnRequest(deviceID string, address string, natDetected bool, stunUsername string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	set := bson.M{
+		"udp_connection_request_address": address,
+		"nat_detected":                   natDetected,
+		"updated_at":                     time.Now(),
+	}
+	if stunUsername != "" {
+		set["stun_username"] = stunUsername
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, bson.M{"$set": set})
+	return err
+}
+
+// UpdateCwmpDeviceSTUNBinding stores the address of the NAT binding a
+// device announced to the STUN server under its STUNUsername, or its
+// connection request username if it reported no STUNUsername. It returns
+// the ID of the device.
+func (c *CwmpDb) UpdateCwmpDeviceSTUNBinding(username string, address string) (string, error) {
+	if c.cwmpDeviceColl == nil {
+		return "", errors.New("CWMP device collection not initialized")
+	}
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"stun_username": username},
+		bson.M{"stun_username": bson.M{"$exists": false}, "connection_request_username": username},
+	}}
+	update := bson.M{"$set": bson.M{
+		"udp_connection_request_address": address,
+		"nat_detected":                   true,
+		"updated_at":                     time.Now(),
+	}}
+	var device CwmpDevice
+	if err := c.cwmpDeviceColl.FindOneAndUpdate(context.Background(), filter, update).Decode(&device); err != nil {
+		return "", err
+	}
+	return device.ID, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CwmpDataModelNode is an object or parameter of the data model supported by
+// a device, as reported in GetParameterNamesResponse
+type CwmpDataModelNode struct {
+	DeviceID  string    `bson:"device_id" json:"device_id"`
+	Path      string    `bson:"path" json:"path"`
+	Object    bool      `bson:"object" json:"object"`
+	Writable  bool      `bson:"writable" json:"writable"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// UpsertCwmpDataModel stores the data model nodes reported by a device under
+// root. If the nodes are the full subtree of root, the nodes of the subtree
+// the device no longer reports are removed.
+func (c *CwmpDb) UpsertCwmpDataModel(deviceID string, root string, nodes []CwmpDataModelNode, subtree bool) error {
+	if c.cwmpDataModelColl == nil {
+		return errors.New("CWMP data model collection not initialized")
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	if len(nodes) > 0 {
+		operations := make([]mongo.WriteModel, 0, len(nodes))
+		for _, node := range nodes {
+			node.DeviceID = deviceID
+			node.UpdatedAt = now
+			operations = append(operations, mongo.NewReplaceOneModel().
+				SetFilter(bson.M{"device_id": deviceID, "path": node.Path}).
+				SetReplacement(node).
+				SetUpsert(true))
+		}
+		if _, err := c.cwmpDataModelColl.BulkWrite(ctx, operations); err != nil {
+			return err
+		}
+	}
+
+	if !subtree {
+		return nil
+	}
+	filter := bson.M{
+		"device_id":  deviceID,
+		"path":       bson.M{"$regex": "^" + regexp.QuoteMeta(root)},
+		"updated_at": bson.M{"$lt": now},
+	}
+	_, err := c.cwmpDataModelColl.DeleteMany(ctx, filter)
+	return err
+}
+
+// GetCwmpDataModel returns the data model nodes of a device under prefix,
+// only its direct children if nextLevel is set, ordered by path
+func (c *CwmpDb) GetCwmpDataModel(deviceID string, prefix string, nextLevel bool) ([]CwmpDataModelNode, error) {
+	if c.cwmpDataModelColl == nil {
+		return nil, errors.New("CWMP data model collection not initialized")
+	}
+
+	pattern := "^" + regexp.QuoteMeta(prefix)
+	if nextLevel {
+		pattern += `[^.]+\.?$`
+	}
+	filter := bson.M{"device_id": deviceID, "path": bson.M{"$regex": pattern}}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+	cursor, err := c.cwmpDataModelColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	nodes := []CwmpDataModelNode{}
+	if err = cursor.All(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// DeleteCwmpDataModel removes the data model nodes of a device under prefix,
+// e.g. those of a deleted object instance
+func (c *CwmpDb) DeleteCwmpDataModel(deviceID string, prefix string) error {
+	if c.cwmpDataModelColl == nil {
+		return errors.New("CWMP data model collection not initialized")
+	}
+
+	filter := bson.M{"device_id": deviceID, "path": bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+	_, err := c.cwmpDataModelColl.DeleteMany(context.Background(), filter)
+	return err
+}
+
+// UpdateCwmpDeviceDataModel records the data model a device reports its
+// parameters in
+func (c *CwmpDb) UpdateCwmpDeviceDataModel(deviceID string, model string, root string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"data_model":      model,
+			"data_model_root": root,
+			"updated_at":      time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
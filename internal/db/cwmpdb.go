@@ -17,10 +17,13 @@ package db
 import (
 	"context"
 	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
@@ -29,6 +32,39 @@ const (
 	CwmpSessionCollection  = "cwmpsessions"
 	CwmpParameterCollection = "cwmpparams"
 	CwmpFileTransferCollection = "cwmpfiles"
+	CwmpDiagnosticsCollection = "cwmpdiagnostics"
+	CwmpIPHistoryCollection = "cwmpiphistory"
+	CwmpAnalyticsCollection = "cwmpanalytics"
+	CwmpEventCollection     = "cwmpevents"
+	FirmwareCompatCollection = "firmwarecompat"
+	BulkPreviewCollection   = "bulkpreviews"
+	BulkJobCollection       = "bulkjobs"
+	BulkJobResultCollection = "bulkjobresults"
+	CwmpPreRegCollection    = "cwmpprereg"
+	CwmpPendingCollection   = "cwmppending"
+	CwmpProfileCollection   = "cwmpprofiles"
+	CwmpSyncJobCollection   = "cwmpsyncjobs"
+	CwmpSnapshotCollection  = "cwmpsnapshots"
+	CwmpTraceCollection     = "cwmptraces"
+	CwmpDataModelCollection = "cwmpdatamodel"
+	CwmpCommandCollection   = "cwmpcommands"
+	CwmpSoftwareCollection  = "cwmpsoftware"
+	CwmpParamChangeCollection = "cwmpparamchanges"
+	ZtpTemplateCollection   = "ztptemplates"
+	ZtpDeviceCollection     = "ztpdevices"
+	FirmwareJobCollection   = "firmwarejobs"
+	CredRotationCollection  = "credrotations"
+	CwmpPresetCollection    = "cwmppresets"
+	CwmpTaskCollection      = "cwmptasks"
+	WebhookCollection       = "webhooks"
+	WebhookDeliveryCollection = "webhookdeliveries"
+	AuditCollection         = "auditlog"
+	GroupCollection         = "devicegroups"
+	AlarmCollection         = "alarms"
+	CwmpConnRequestCollection = "cwmpconnrequests"
+	FirmwareImageCollection = "firmwareimages"
+	CampaignCollection      = "firmwarecampaigns"
+	CampaignDeviceCollection = "firmwarecampaigndevices"
 )
 
 // CwmpDevice represents a TR-069 device in the database
@@ -49,6 +85,11 @@ type CwmpDevice struct {
 	ConnectionRequestURL string         `bson:"connection_request_url" json:"connection_request_url"`
 	ConnectionRequestUsername string    `bson:"connection_request_username" json:"connection_request_username"`
 	ConnectionRequestPassword string    `bson:"connection_request_password" json:"connection_request_password"`
+	// TR-111: address connection requests are sent to over UDP when the
+	// device is behind a NAT
+	UDPConnectionRequestAddress string  `bson:"udp_connection_request_address,omitempty" json:"udp_connection_request_address,omitempty"`
+	NATDetected       bool              `bson:"nat_detected,omitempty" json:"nat_detected,omitempty"`
+	STUNUsername      string            `bson:"stun_username,omitempty" json:"stun_username,omitempty"`
 	PeriodicInformEnable bool           `bson:"periodic_inform_enable" json:"periodic_inform_enable"`
 	PeriodicInformInterval int          `bson:"periodic_inform_interval" json:"periodic_inform_interval"`
 	LastInform        time.Time         `bson:"last_inform" json:"last_inform"`
@@ -56,9 +97,25 @@ type CwmpDevice struct {
 	CurrentTime       time.Time         `bson:"current_time" json:"current_time"`
 	UpTime           int               `bson:"up_time" json:"up_time"`
 	IPAddress        string            `bson:"ip_address" json:"ip_address"`
+	IPKey            string            `bson:"ip_key,omitempty" json:"-"`
+	Geo              *DeviceGeo        `bson:"geo,omitempty" json:"geo,omitempty"`
 	Tags             []string          `bson:"tags" json:"tags"`
+	Groups           []string          `bson:"groups,omitempty" json:"groups,omitempty"` // synced from the device groups
+	Profile          string            `bson:"profile,omitempty" json:"profile,omitempty"`
+	Tenant           string            `bson:"tenant,omitempty" json:"tenant,omitempty"`
+	Subscriber       map[string]string `bson:"subscriber,omitempty" json:"subscriber,omitempty"`
+	TraceUntil       *time.Time        `bson:"trace_until,omitempty" json:"trace_until,omitempty"`
+	LastConnectionRequest *ConnRequestOutcome `bson:"last_connection_request,omitempty" json:"last_connection_request,omitempty"`
+	RPCMethods       []string          `bson:"rpc_methods,omitempty" json:"rpc_methods,omitempty"` // advertised in GetRPCMethodsResponse
+	DataModel        string            `bson:"data_model,omitempty" json:"data_model,omitempty"`           // TR-098 or TR-181
+	DataModelRoot    string            `bson:"data_model_root,omitempty" json:"data_model_root,omitempty"` // InternetGatewayDevice. or Device.
+	Provisioning     *DeviceProvisioning `bson:"provisioning,omitempty" json:"provisioning,omitempty"`
+	InformLoop       *InformLoop       `bson:"inform_loop,omitempty" json:"inform_loop,omitempty"` // set while the device informs in a loop
+	RefreshDue       bool              `bson:"refresh_due,omitempty" json:"refresh_due,omitempty"` // full parameter refresh waiting for the next session
+	LastRefresh      *time.Time        `bson:"last_refresh,omitempty" json:"last_refresh,omitempty"`
+	ArchivedAt       *time.Time        `bson:"archived_at,omitempty" json:"archived_at,omitempty"` // soft-deleted, cleared by the next Inform
 	Parameters       map[string]string `bson:"parameters" json:"parameters"`
-	Events           []DeviceEvent     `bson:"events" json:"events"`
+	Events           []DeviceEvent     `bson:"events" json:"events"` // most recent events only, see CwmpEventCollection
 	CreatedAt        time.Time         `bson:"created_at" json:"created_at"`
 	UpdatedAt        time.Time         `bson:"updated_at" json:"updated_at"`
 }
@@ -71,8 +128,13 @@ type CwmpSession struct {
 	State             string    `bson:"state" json:"state"`
 	CurrentRPCMethod  string    `bson:"current_rpc_method" json:"current_rpc_method"`
 	PendingRPCs       []string  `bson:"pending_rpcs" json:"pending_rpcs"`
+	RequestIDs        []string  `bson:"request_ids,omitempty" json:"request_ids,omitempty"`
 	LastActivity      time.Time `bson:"last_activity" json:"last_activity"`
 	ConnectionRequestURL string `bson:"connection_request_url" json:"connection_request_url"`
+	CwmpVersion       string    `bson:"cwmp_version,omitempty" json:"cwmp_version,omitempty"`
+	CloseReason       string     `bson:"close_reason,omitempty" json:"close_reason,omitempty"`
+	ClosedAt          *time.Time `bson:"closed_at,omitempty" json:"closed_at,omitempty"`
+	Cookie            string     `bson:"cookie,omitempty" json:"-"` // lets an open session survive an ACS restart
 	CreatedAt         time.Time `bson:"created_at" json:"created_at"`
 }
 
@@ -101,19 +163,33 @@ type CwmpFileTransfer struct {
 	DelaySeconds int       `bson:"delay_seconds" json:"delay_seconds"`
 	SuccessURL   string    `bson:"success_url" json:"success_url"`
 	FailureURL   string    `bson:"failure_url" json:"failure_url"`
+	// FirmwareVersion is the version a firmware image is expected to install
+	FirmwareVersion string `bson:"firmware_version,omitempty" json:"firmware_version,omitempty"`
+	// FileID is the file of the file store the transfer downloads
+	FileID string `bson:"file_id,omitempty" json:"file_id,omitempty"`
 	Status       string    `bson:"status" json:"status"`
 	StartTime    time.Time `bson:"start_time" json:"start_time"`
 	CompleteTime time.Time `bson:"complete_time" json:"complete_time"`
 	FaultCode    string    `bson:"fault_code" json:"fault_code"`
 	FaultString  string    `bson:"fault_string" json:"fault_string"`
 	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+	// CpeState is the state of the transfer in the queue of the device, as
+	// last reported by GetQueuedTransfers
+	CpeState     string     `bson:"cpe_state,omitempty" json:"cpe_state,omitempty"`
+	CheckedAt    *time.Time `bson:"checked_at,omitempty" json:"checked_at,omitempty"`
+	// Autonomous is set for the transfers a device ran on its own and
+	// reported with AutonomousTransferComplete
+	Autonomous   bool       `bson:"autonomous,omitempty" json:"autonomous,omitempty"`
+	Upload       bool       `bson:"upload,omitempty" json:"upload,omitempty"`
+	AnnounceURL  string     `bson:"announce_url,omitempty" json:"announce_url,omitempty"`
 }
 
 // DeviceEvent represents an event from a TR-069 device
 type DeviceEvent struct {
-	EventCode  string    `bson:"event_code" json:"event_code"`
-	CommandKey string    `bson:"command_key" json:"command_key"`
-	Timestamp  time.Time `bson:"timestamp" json:"timestamp"`
+	EventCode  string            `bson:"event_code" json:"event_code"`
+	CommandKey string            `bson:"command_key" json:"command_key"`
+	Details    map[string]string `bson:"details,omitempty" json:"details,omitempty"`
+	Timestamp  time.Time         `bson:"timestamp" json:"timestamp"`
 }
 
 // CwmpDb extends UspDb with TR-069 specific collections
@@ -123,6 +199,40 @@ type CwmpDb struct {
 	cwmpSessionColl  *mongo.Collection
 	cwmpParamColl    *mongo.Collection
 	cwmpFileColl     *mongo.Collection
+	cwmpDiagColl     *mongo.Collection
+	cwmpIPHistColl   *mongo.Collection
+	cwmpStatsColl    *mongo.Collection
+	cwmpEventColl    *mongo.Collection
+	firmwareCompatColl *mongo.Collection
+	bulkPreviewColl  *mongo.Collection
+	bulkJobColl      *mongo.Collection
+	bulkJobResultColl *mongo.Collection
+	cwmpPreRegColl   *mongo.Collection
+	cwmpPendingColl  *mongo.Collection
+	cwmpProfileColl  *mongo.Collection
+	cwmpSyncJobColl  *mongo.Collection
+	cwmpSnapshotColl *mongo.Collection
+	cwmpTraceColl    *mongo.Collection
+	cwmpDataModelColl *mongo.Collection
+	cwmpCommandColl  *mongo.Collection
+	cwmpSoftwareColl *mongo.Collection
+	cwmpParamChangeColl *mongo.Collection
+	ztpTemplateColl  *mongo.Collection
+	ztpDeviceColl    *mongo.Collection
+	firmwareJobColl  *mongo.Collection
+	credRotationColl *mongo.Collection
+	cwmpPresetColl   *mongo.Collection
+	cwmpTaskColl     *mongo.Collection
+	webhookColl      *mongo.Collection
+	webhookDeliveryColl *mongo.Collection
+	auditColl        *mongo.Collection
+	groupColl        *mongo.Collection
+	fileBucket       *gridfs.Bucket
+	alarmColl        *mongo.Collection
+	cwmpConnReqColl  *mongo.Collection
+	firmwareImageColl *mongo.Collection
+	campaignColl     *mongo.Collection
+	campaignDeviceColl *mongo.Collection
 }
 
 // InitCwmp initializes CWMP collections and creates indexes
@@ -141,6 +251,44 @@ func (c *CwmpDb) InitCwmp(client *mongo.Client) error {
 	c.cwmpSessionColl = client.Database(dbName).Collection(CwmpSessionCollection)
 	c.cwmpParamColl = client.Database(dbName).Collection(CwmpParameterCollection)
 	c.cwmpFileColl = client.Database(dbName).Collection(CwmpFileTransferCollection)
+	c.cwmpDiagColl = client.Database(dbName).Collection(CwmpDiagnosticsCollection)
+	c.cwmpIPHistColl = client.Database(dbName).Collection(CwmpIPHistoryCollection)
+	c.cwmpStatsColl = client.Database(dbName).Collection(CwmpAnalyticsCollection)
+	c.cwmpEventColl = client.Database(dbName).Collection(CwmpEventCollection)
+	c.firmwareCompatColl = client.Database(dbName).Collection(FirmwareCompatCollection)
+	c.bulkPreviewColl = client.Database(dbName).Collection(BulkPreviewCollection)
+	c.bulkJobColl = client.Database(dbName).Collection(BulkJobCollection)
+	c.bulkJobResultColl = client.Database(dbName).Collection(BulkJobResultCollection)
+	c.cwmpPreRegColl = client.Database(dbName).Collection(CwmpPreRegCollection)
+	c.cwmpPendingColl = client.Database(dbName).Collection(CwmpPendingCollection)
+	c.cwmpProfileColl = client.Database(dbName).Collection(CwmpProfileCollection)
+	c.cwmpSyncJobColl = client.Database(dbName).Collection(CwmpSyncJobCollection)
+	c.cwmpSnapshotColl = client.Database(dbName).Collection(CwmpSnapshotCollection)
+	c.cwmpTraceColl = client.Database(dbName).Collection(CwmpTraceCollection)
+	c.cwmpDataModelColl = client.Database(dbName).Collection(CwmpDataModelCollection)
+	c.cwmpCommandColl = client.Database(dbName).Collection(CwmpCommandCollection)
+	c.cwmpSoftwareColl = client.Database(dbName).Collection(CwmpSoftwareCollection)
+	c.cwmpParamChangeColl = client.Database(dbName).Collection(CwmpParamChangeCollection)
+	c.ztpTemplateColl = client.Database(dbName).Collection(ZtpTemplateCollection)
+	c.ztpDeviceColl = client.Database(dbName).Collection(ZtpDeviceCollection)
+	c.firmwareJobColl = client.Database(dbName).Collection(FirmwareJobCollection)
+	c.credRotationColl = client.Database(dbName).Collection(CredRotationCollection)
+	c.cwmpPresetColl = client.Database(dbName).Collection(CwmpPresetCollection)
+	c.cwmpTaskColl = client.Database(dbName).Collection(CwmpTaskCollection)
+	c.webhookColl = client.Database(dbName).Collection(WebhookCollection)
+	c.webhookDeliveryColl = client.Database(dbName).Collection(WebhookDeliveryCollection)
+	c.auditColl = client.Database(dbName).Collection(AuditCollection)
+	c.groupColl = client.Database(dbName).Collection(GroupCollection)
+	fileBucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(FileBucket))
+	if err != nil {
+		return err
+	}
+	c.fileBucket = fileBucket
+	c.alarmColl = client.Database(dbName).Collection(AlarmCollection)
+	c.cwmpConnReqColl = client.Database(dbName).Collection(CwmpConnRequestCollection)
+	c.firmwareImageColl = client.Database(dbName).Collection(FirmwareImageCollection)
+	c.campaignColl = client.Database(dbName).Collection(CampaignCollection)
+	c.campaignDeviceColl = client.Database(dbName).Collection(CampaignDeviceCollection)
 
 	// Create indexes for better performance
 	return c.createCwmpIndexes()
@@ -162,12 +310,36 @@ func (c *CwmpDb) createCwmpIndexes() error {
 		{
 			Keys: bson.D{{Key: "ip_address", Value: 1}},
 		},
+		{
+			Keys: bson.D{{Key: "ip_key", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "geo.country", Value: 1}, {Key: "geo.region", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "geo.asn", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "stun_username", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "tenant", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "connection_request_username", Value: 1}},
+		},
 		{
 			Keys: bson.D{{Key: "manufacturer", Value: 1}},
 		},
 		{
 			Keys: bson.D{{Key: "model_name", Value: 1}},
 		},
+		{
+			Keys: bson.D{{Key: "tags", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "groups", Value: 1}},
+		},
 	}
 
 	// Session collection indexes  
@@ -217,10 +389,238 @@ func (c *CwmpDb) createCwmpIndexes() error {
 		},
 	}
 
+	// Diagnostics collection indexes
+	diagIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "created_at", Value: -1}},
+		},
+	}
+
+	// IP history collection indexes
+	ipHistIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "changed_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "ip_address", Value: 1}},
+		},
+	}
+
+	// Analytics collection indexes
+	statsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "date", Value: -1}},
+		},
+	}
+
+	// Firmware compatibility collection indexes
+	firmwareCompatIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "manufacturer", Value: 1}, {Key: "model_name", Value: 1}},
+		},
+	}
+
+	// An image is registered once per vendor, product class and version
+	firmwareImageIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "vendor", Value: 1}, {Key: "product_class", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	campaignIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+	campaignDeviceIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "campaign_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}},
+		},
+	}
+
+	// Bulk preview collection indexes, previews are removed a day after they expire
+	bulkPreviewIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60),
+		},
+	}
+
+	// Bulk job collection indexes, a device has one result per job
+	bulkJobIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	}
+	bulkJobResultIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "job_id", Value: 1}, {Key: "device_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "job_id", Value: 1}, {Key: "status", Value: 1}},
+		},
+	}
+
+	// Pre-registration and pending device collection indexes
+	preRegIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+	pendingIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "last_seen", Value: -1}},
+		},
+	}
+
+	// Sync job and parameter snapshot collection indexes
+	syncJobIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	}
+	snapshotIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+
+	// Data model collection indexes
+	dataModelIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "device_id", Value: 1}, {Key: "path", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+	}
+
+	// Command queue indexes
+	commandIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "request_id", Value: 1}},
+		},
+	}
+
+	// Task indexes
+	taskIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+	}
+
+	softwareIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "uuid", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "command_key", Value: 1}},
+		},
+	}
+
+	paramChangeIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "changed_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "path", Value: 1}, {Key: "changed_at", Value: -1}},
+		},
+	}
+
+	ztpDeviceIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "template", Value: 1}, {Key: "applied_at", Value: -1}},
+		},
+	}
+
+	// Firmware job collection indexes
+	firmwareJobIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "transfer_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	}
+
+	// Credential rotation collection indexes
+	credRotationIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}},
+		},
+	}
+
+	// Webhook delivery indexes, pending deliveries are retried when due
+	webhookDeliveryIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}},
+		},
+	}
+
+	// Audit collection indexes
+	auditIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "timestamp", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "user", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+	}
+
+	// Alarm collection indexes
+	alarmIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "tenant", Value: 1}, {Key: "raised_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "type", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "raised_at", Value: -1}},
+		},
+	}
+
 	// Create indexes
 	if _, err := c.cwmpDeviceColl.Indexes().CreateMany(ctx, deviceIndexes); err != nil {
 		return err
 	}
+	c.backfillCwmpIPKeys(ctx)
 	if _, err := c.cwmpSessionColl.Indexes().CreateMany(ctx, sessionIndexes); err != nil {
 		return err
 	}
@@ -230,6 +630,91 @@ func (c *CwmpDb) createCwmpIndexes() error {
 	if _, err := c.cwmpFileColl.Indexes().CreateMany(ctx, fileIndexes); err != nil {
 		return err
 	}
+	if _, err := c.cwmpDiagColl.Indexes().CreateMany(ctx, diagIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpIPHistColl.Indexes().CreateMany(ctx, ipHistIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpStatsColl.Indexes().CreateMany(ctx, statsIndexes); err != nil {
+		return err
+	}
+	if _, err := c.alarmColl.Indexes().CreateMany(ctx, alarmIndexes); err != nil {
+		return err
+	}
+	if _, err := c.firmwareCompatColl.Indexes().CreateMany(ctx, firmwareCompatIndexes); err != nil {
+		return err
+	}
+	if _, err := c.firmwareImageColl.Indexes().CreateMany(ctx, firmwareImageIndexes); err != nil {
+		return err
+	}
+	if _, err := c.campaignColl.Indexes().CreateMany(ctx, campaignIndexes); err != nil {
+		return err
+	}
+	if _, err := c.campaignDeviceColl.Indexes().CreateMany(ctx, campaignDeviceIndexes); err != nil {
+		return err
+	}
+	if _, err := c.bulkPreviewColl.Indexes().CreateMany(ctx, bulkPreviewIndexes); err != nil {
+		return err
+	}
+	if _, err := c.bulkJobColl.Indexes().CreateMany(ctx, bulkJobIndexes); err != nil {
+		return err
+	}
+	if _, err := c.bulkJobResultColl.Indexes().CreateMany(ctx, bulkJobResultIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpPreRegColl.Indexes().CreateMany(ctx, preRegIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpPendingColl.Indexes().CreateMany(ctx, pendingIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpSyncJobColl.Indexes().CreateMany(ctx, syncJobIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpSnapshotColl.Indexes().CreateMany(ctx, snapshotIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpDataModelColl.Indexes().CreateMany(ctx, dataModelIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpCommandColl.Indexes().CreateMany(ctx, commandIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpTaskColl.Indexes().CreateMany(ctx, taskIndexes); err != nil {
+		return err
+	}
+	if _, err := c.webhookDeliveryColl.Indexes().CreateMany(ctx, webhookDeliveryIndexes); err != nil {
+		return err
+	}
+	if _, err := c.auditColl.Indexes().CreateMany(ctx, auditIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpSoftwareColl.Indexes().CreateMany(ctx, softwareIndexes); err != nil {
+		return err
+	}
+	if _, err := c.cwmpParamChangeColl.Indexes().CreateMany(ctx, paramChangeIndexes); err != nil {
+		return err
+	}
+	c.createCwmpParamHistoryTTLIndex(ctx)
+	if _, err := c.ztpDeviceColl.Indexes().CreateMany(ctx, ztpDeviceIndexes); err != nil {
+		return err
+	}
+	if _, err := c.firmwareJobColl.Indexes().CreateMany(ctx, firmwareJobIndexes); err != nil {
+		return err
+	}
+	if _, err := c.credRotationColl.Indexes().CreateMany(ctx, credRotationIndexes); err != nil {
+		return err
+	}
+	if err := c.createCwmpEventIndexes(ctx); err != nil {
+		return err
+	}
+	if err := c.createCwmpConnRequestIndexes(ctx); err != nil {
+		return err
+	}
+	if err := c.createCwmpTraceCollection(ctx); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -248,6 +733,75 @@ func (c *CwmpDb) DeleteCwmpCollection(collName string) error {
 		err = c.cwmpParamColl.Drop(ctx)
 	case CwmpFileTransferCollection:
 		err = c.cwmpFileColl.Drop(ctx)
+	case CwmpDiagnosticsCollection:
+		err = c.cwmpDiagColl.Drop(ctx)
+	case CwmpIPHistoryCollection:
+		err = c.cwmpIPHistColl.Drop(ctx)
+	case CwmpAnalyticsCollection:
+		err = c.cwmpStatsColl.Drop(ctx)
+	case CwmpEventCollection:
+		err = c.cwmpEventColl.Drop(ctx)
+	case FirmwareCompatCollection:
+		err = c.firmwareCompatColl.Drop(ctx)
+	case BulkPreviewCollection:
+		err = c.bulkPreviewColl.Drop(ctx)
+	case BulkJobCollection:
+		err = c.bulkJobColl.Drop(ctx)
+	case BulkJobResultCollection:
+		err = c.bulkJobResultColl.Drop(ctx)
+	case CwmpPreRegCollection:
+		err = c.cwmpPreRegColl.Drop(ctx)
+	case CwmpPendingCollection:
+		err = c.cwmpPendingColl.Drop(ctx)
+	case CwmpProfileCollection:
+		err = c.cwmpProfileColl.Drop(ctx)
+	case CwmpSyncJobCollection:
+		err = c.cwmpSyncJobColl.Drop(ctx)
+	case CwmpSnapshotCollection:
+		err = c.cwmpSnapshotColl.Drop(ctx)
+	case CwmpDataModelCollection:
+		err = c.cwmpDataModelColl.Drop(ctx)
+	case CwmpCommandCollection:
+		err = c.cwmpCommandColl.Drop(ctx)
+	case CwmpSoftwareCollection:
+		err = c.cwmpSoftwareColl.Drop(ctx)
+	case CwmpParamChangeCollection:
+		err = c.cwmpParamChangeColl.Drop(ctx)
+	case ZtpTemplateCollection:
+		err = c.ztpTemplateColl.Drop(ctx)
+	case ZtpDeviceCollection:
+		err = c.ztpDeviceColl.Drop(ctx)
+	case FirmwareJobCollection:
+		err = c.firmwareJobColl.Drop(ctx)
+	case CredRotationCollection:
+		err = c.credRotationColl.Drop(ctx)
+	case CwmpPresetCollection:
+		err = c.cwmpPresetColl.Drop(ctx)
+	case CwmpTaskCollection:
+		err = c.cwmpTaskColl.Drop(ctx)
+	case WebhookCollection:
+		err = c.webhookColl.Drop(ctx)
+	case WebhookDeliveryCollection:
+		err = c.webhookDeliveryColl.Drop(ctx)
+	case GroupCollection:
+		err = c.groupColl.Drop(ctx)
+	case FileBucket:
+		err = c.fileBucket.Drop()
+	case CwmpTraceCollection:
+		// Recreate it capped, inserts would otherwise create a regular collection
+		if err = c.cwmpTraceColl.Drop(ctx); err == nil {
+			err = c.createCwmpTraceCollection(ctx)
+		}
+	case AlarmCollection:
+		err = c.alarmColl.Drop(ctx)
+	case CwmpConnRequestCollection:
+		err = c.cwmpConnReqColl.Drop(ctx)
+	case FirmwareImageCollection:
+		err = c.firmwareImageColl.Drop(ctx)
+	case CampaignCollection:
+		err = c.campaignColl.Drop(ctx)
+	case CampaignDeviceCollection:
+		err = c.campaignDeviceColl.Drop(ctx)
 	default:
 		err = errors.New("Invalid CWMP collection name: " + collName)
 	}
@@ -379,6 +933,60 @@ func (c *CwmpDb) GetCwmpParametersByPath(deviceID string, paths []string) ([]Cwm
 	return parameters, nil
 }
 
+// GetCwmpParametersByName retrieves the parameters of a device named by
+// names, a partial path ending with a dot naming the parameters under it
+func (c *CwmpDb) GetCwmpParametersByName(deviceID string, names []string) ([]CwmpParameter, error) {
+	if c.cwmpParamColl == nil {
+		return nil, errors.New("CWMP parameter collection not initialized")
+	}
+
+	var paths []string
+	var or bson.A
+	for _, name := range names {
+		if strings.HasSuffix(name, ".") {
+			or = append(or, bson.M{"path": bson.M{"$regex": "^" + regexp.QuoteMeta(name)}})
+		} else {
+			paths = append(paths, name)
+		}
+	}
+	if len(paths) > 0 {
+		or = append(or, bson.M{"path": bson.M{"$in": paths}})
+	}
+	if len(or) == 0 {
+		return nil, nil
+	}
+
+	ctx := context.Background()
+	filter := bson.M{"device_id": deviceID, "$or": or}
+	opts := options.Find().SetSort(bson.D{{Key: "path", Value: 1}})
+	cursor, err := c.cwmpParamColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var parameters []CwmpParameter
+	if err = cursor.All(ctx, &parameters); err != nil {
+		return nil, err
+	}
+	return parameters, nil
+}
+
+// DeleteCwmpParametersByPrefix removes the parameters of a device under
+// prefix, e.g. those of a deleted object instance
+func (c *CwmpDb) DeleteCwmpParametersByPrefix(deviceID string, prefix string) error {
+	if c.cwmpParamColl == nil {
+		return errors.New("CWMP parameter collection not initialized")
+	}
+
+	filter := bson.M{
+		"device_id": deviceID,
+		"path":      bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+	}
+	_, err := c.cwmpParamColl.DeleteMany(context.Background(), filter)
+	return err
+}
+
 // UpsertCwmpDevice inserts or updates a CWMP device
 func (c *CwmpDb) UpsertCwmpDevice(device *CwmpDevice) error {
 	if c.cwmpDeviceColl == nil {
@@ -387,6 +995,7 @@ func (c *CwmpDb) UpsertCwmpDevice(device *CwmpDevice) error {
 
 	ctx := context.Background()
 	device.UpdatedAt = time.Now()
+	device.IPKey = IPKey(device.IPAddress)
 	
 	opts := options.Replace().SetUpsert(true)
 	_, err := c.cwmpDeviceColl.ReplaceOne(ctx, bson.M{"_id": device.ID}, device, opts)
@@ -394,6 +1003,22 @@ func (c *CwmpDb) UpsertCwmpDevice(device *CwmpDevice) error {
 	return err
 }
 
+// UpdateCwmpDeviceRPCMethods stores the RPC methods supported by a device
+func (c *CwmpDb) UpdateCwmpDeviceRPCMethods(deviceID string, methods []string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"rpc_methods": methods,
+			"updated_at":  time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
+
 // UpsertCwmpParameters inserts or updates CWMP parameters
 func (c *CwmpDb) UpsertCwmpParameters(parameters []CwmpParameter) error {
 	if c.cwmpParamColl == nil {
@@ -430,4 +1055,92 @@ func (c *CwmpDb) UpsertCwmpParameters(parameters []CwmpParameter) error {
 
 	_, err := c.cwmpParamColl.BulkWrite(ctx, operations)
 	return err
-}
\ No newline at end of file
+}
+
+// CountCwmpDevices returns the number of devices matching filter
+func (c *CwmpDb) CountCwmpDevices(filter bson.M) (int64, error) {
+	if c.cwmpDeviceColl == nil {
+		return 0, errors.New("CWMP device collection not initialized")
+	}
+
+	return c.cwmpDeviceColl.CountDocuments(context.Background(), filter)
+}
+
+// CountCwmpDevicesByTenant returns the number of devices of a tenant
+func (c *CwmpDb) CountCwmpDevicesByTenant(tenant string) (int64, error) {
+	if c.cwmpDeviceColl == nil {
+		return 0, errors.New("CWMP device collection not initialized")
+	}
+
+	return c.cwmpDeviceColl.CountDocuments(context.Background(), bson.M{"tenant": tenant})
+}
+
+// SetCwmpDeviceTenant assigns a device to a tenant, an empty tenant leaves
+// the device unassigned
+func (c *CwmpDb) SetCwmpDeviceTenant(deviceID string, tenant string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"tenant": tenant, "updated_at": time.Now()}}
+	if tenant == "" {
+		update = bson.M{"$unset": bson.M{"tenant": ""}, "$set": bson.M{"updated_at": time.Now()}}
+	}
+	res, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// AssignCwmpDeviceTenant assigns a device which has no tenant yet, the
+// assignment of the other devices is kept
+func (c *CwmpDb) AssignCwmpDeviceTenant(deviceID string, tenant string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	filter := bson.M{"_id": deviceID, "tenant": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"tenant": tenant, "updated_at": time.Now()}}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), filter, update)
+	return err
+}
+
+// CwmpParametersVersion returns the number of stored parameters of a device
+// among paths, all of them if paths is empty, and when the latest of them was
+// updated. Together they change whenever the parameters change.
+func (c *CwmpDb) CwmpParametersVersion(deviceID string, paths []string) (int64, time.Time, error) {
+	if c.cwmpParamColl == nil {
+		return 0, time.Time{}, errors.New("CWMP parameter collection not initialized")
+	}
+
+	match := bson.M{"device_id": deviceID}
+	if len(paths) > 0 {
+		match["path"] = bson.M{"$in": paths}
+	}
+	ctx := context.Background()
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{"_id": nil, "count": bson.M{"$sum": 1}, "latest": bson.M{"$max": "$last_update"}}}},
+	}
+	cursor, err := c.cwmpParamColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		Count  int64     `bson:"count"`
+		Latest time.Time `bson:"latest"`
+	}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return 0, time.Time{}, err
+	}
+	if len(groups) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return groups[0].Count, groups[0].Latest, nil
+}
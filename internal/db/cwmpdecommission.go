@@ -0,0 +1,121 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CwmpDeviceDeletion reports the removal of a device, the documents deleted
+// with it are counted by collection
+type CwmpDeviceDeletion struct {
+	DeviceID   string           `json:"device_id"`
+	Archived   bool             `json:"archived"`
+	ArchivedAt *time.Time       `json:"archived_at,omitempty"`
+	Deleted    map[string]int64 `json:"deleted,omitempty"`
+}
+
+// deviceDataColls returns the collections holding the parameters, sessions,
+// transfers and history of devices by device_id. The audit log, the capped
+// traces and the daily rollups are kept.
+func (c *CwmpDb) deviceDataColls() map[string]*mongo.Collection {
+	return map[string]*mongo.Collection{
+		CwmpParameterCollection:    c.cwmpParamColl,
+		CwmpSessionCollection:      c.cwmpSessionColl,
+		CwmpFileTransferCollection: c.cwmpFileColl,
+		CwmpDiagnosticsCollection:  c.cwmpDiagColl,
+		CwmpIPHistoryCollection:    c.cwmpIPHistColl,
+		CwmpEventCollection:        c.cwmpEventColl,
+		CwmpSyncJobCollection:      c.cwmpSyncJobColl,
+		CwmpSnapshotCollection:     c.cwmpSnapshotColl,
+		CwmpDataModelCollection:    c.cwmpDataModelColl,
+		CwmpCommandCollection:      c.cwmpCommandColl,
+		CwmpSoftwareCollection:     c.cwmpSoftwareColl,
+		CwmpParamChangeCollection:  c.cwmpParamChangeColl,
+		CwmpTaskCollection:         c.cwmpTaskColl,
+		ZtpDeviceCollection:        c.ztpDeviceColl,
+		FirmwareJobCollection:      c.firmwareJobColl,
+		CredRotationCollection:     c.credRotationColl,
+		AlarmCollection:            c.alarmColl,
+		CwmpConnRequestCollection:  c.cwmpConnReqColl,
+	}
+}
+
+// DeleteCwmpDevice removes a device with its parameters, sessions, transfers
+// and history. The device is removed first, a collection which cannot be
+// cleaned up does not stop the others.
+func (c *CwmpDb) DeleteCwmpDevice(deviceID string) (*CwmpDeviceDeletion, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	res, err := c.cwmpDeviceColl.DeleteOne(ctx, bson.M{"_id": deviceID})
+	if err != nil {
+		return nil, err
+	}
+	if res.DeletedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	deletion := &CwmpDeviceDeletion{DeviceID: deviceID, Deleted: map[string]int64{CwmpDeviceCollection: 1}}
+	var errs []error
+	for name, coll := range c.deviceDataColls() {
+		if coll == nil {
+			continue
+		}
+		res, err := coll.DeleteMany(ctx, bson.M{"device_id": deviceID})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if res.DeletedCount > 0 {
+			deletion.Deleted[name] = res.DeletedCount
+		}
+	}
+	if c.cwmpPendingColl != nil {
+		if res, err := c.cwmpPendingColl.DeleteOne(ctx, bson.M{"_id": deviceID}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", CwmpPendingCollection, err))
+		} else if res.DeletedCount > 0 {
+			deletion.Deleted[CwmpPendingCollection] = res.DeletedCount
+		}
+	}
+	return deletion, errors.Join(errs...)
+}
+
+// ArchiveCwmpDevice soft-deletes a device, it keeps its data but is left
+// out of the device listings until it informs again
+func (c *CwmpDb) ArchiveCwmpDevice(deviceID string) (*CwmpDeviceDeletion, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"archived_at": now, "updated_at": now}}
+	res, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &CwmpDeviceDeletion{DeviceID: deviceID, Archived: true, ArchivedAt: &now}, nil
+}
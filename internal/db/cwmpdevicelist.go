@@ -0,0 +1,145 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceListOptions selects the page of a device listing
+type DeviceListOptions struct {
+	SortBy     string   // document field, devices are ordered by ID if empty
+	Descending bool     // sort order of SortBy
+	Offset     int64    // number of devices skipped
+	Limit      int64    // maximum number of devices returned, all if zero
+	Fields     []string // document fields returned, all if empty
+}
+
+// ListCwmpDevices returns a page of the devices matching filter and the
+// number of devices matching filter
+func (c *CwmpDb) ListCwmpDevices(filter bson.M, opts DeviceListOptions) ([]CwmpDevice, int64, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, 0, errors.New("CWMP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	total, err := c.cwmpDeviceColl.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cursor, err := c.cwmpDeviceColl.Find(ctx, filter, deviceFindOptions(opts))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []CwmpDevice{}
+	if err = cursor.All(ctx, &devices); err != nil {
+		return nil, 0, err
+	}
+	return devices, total, nil
+}
+
+// EachCwmpDevice calls fn with each device matching filter, reading them
+// from a cursor, until ctx is done or fn returns an error
+func (c *CwmpDb) EachCwmpDevice(ctx context.Context, filter bson.M, opts DeviceListOptions, fn func(*CwmpDevice) error) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	cursor, err := c.cwmpDeviceColl.Find(ctx, filter, deviceFindOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var device CwmpDevice
+		if err := cursor.Decode(&device); err != nil {
+			return err
+		}
+		if err := fn(&device); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func deviceFindOptions(opts DeviceListOptions) *options.FindOptions {
+	order := 1
+	if opts.Descending {
+		order = -1
+	}
+	// Devices with the same sort value stay in a stable order across pages
+	sort := bson.D{{Key: "_id", Value: order}}
+	if opts.SortBy != "" && opts.SortBy != "_id" {
+		sort = bson.D{{Key: opts.SortBy, Value: order}, {Key: "_id", Value: 1}}
+	}
+	findOpts := options.Find().SetSort(sort).SetSkip(opts.Offset)
+	if opts.Limit > 0 {
+		findOpts.SetLimit(opts.Limit)
+	}
+	if len(opts.Fields) > 0 {
+		projection := bson.M{}
+		for _, field := range opts.Fields {
+			projection[field] = 1
+		}
+		findOpts.SetProjection(projection)
+	}
+	return findOpts
+}
+
+// DistinctCwmpDeviceValues returns the distinct string values of a device
+// field among the devices matching filter
+func (c *CwmpDb) DistinctCwmpDeviceValues(field string, filter bson.M) ([]string, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	values, err := c.cwmpDeviceColl.Distinct(context.Background(), field, filter)
+	if err != nil {
+		return nil, err
+	}
+	return distinctStrings(values), nil
+}
+
+// CwmpParameterDeviceIDs returns the IDs of the devices having a parameter
+// matching filter
+func (c *CwmpDb) CwmpParameterDeviceIDs(filter bson.M) ([]string, error) {
+	if c.cwmpParamColl == nil {
+		return nil, errors.New("CWMP parameter collection not initialized")
+	}
+
+	ids, err := c.cwmpParamColl.Distinct(context.Background(), "device_id", filter)
+	if err != nil {
+		return nil, err
+	}
+	return distinctStrings(ids), nil
+}
+
+func distinctStrings(values []interface{}) []string {
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		if str, ok := value.(string); ok {
+			strs = append(strs, str)
+		}
+	}
+	return strs
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Diagnostics job states
+const (
+	DiagnosticStatusRequested = "requested"
+	DiagnosticStatusComplete  = "complete"
+	DiagnosticStatusError     = "error"
+)
+
+// CwmpDiagnostic represents a diagnostics job run on a TR-069 device
+type CwmpDiagnostic struct {
+	ID             string            `bson:"_id" json:"id"`
+	DeviceID       string            `bson:"device_id" json:"device_id"`
+	DiagnosticType string            `bson:"diagnostic_type" json:"diagnostic_type"`
+	ObjectPath     string            `bson:"object_path" json:"object_path"`
+	Parameters     map[string]string `bson:"parameters,omitempty" json:"parameters,omitempty"`
+	Status         string            `bson:"status" json:"status"`
+	Results        map[string]string `bson:"results,omitempty" json:"results,omitempty"`
+	CreatedAt      time.Time         `bson:"created_at" json:"created_at"`
+	CompletedAt    time.Time         `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// InsertCwmpDiagnostic stores a new diagnostics job
+func (c *CwmpDb) InsertCwmpDiagnostic(diag *CwmpDiagnostic) error {
+	if c.cwmpDiagColl == nil {
+		return errors.New("CWMP diagnostics collection not initialized")
+	}
+
+	if diag.ID == "" {
+		diag.ID = primitive.NewObjectID().Hex()
+	}
+	if diag.Status == "" {
+		diag.Status = DiagnosticStatusRequested
+	}
+	diag.CreatedAt = time.Now()
+
+	_, err := c.cwmpDiagColl.InsertOne(context.Background(), diag)
+	return err
+}
+
+// GetCwmpDiagnostic returns a diagnostics job
+func (c *CwmpDb) GetCwmpDiagnostic(id string) (*CwmpDiagnostic, error) {
+	if c.cwmpDiagColl == nil {
+		return nil, errors.New("CWMP diagnostics collection not initialized")
+	}
+
+	var diag CwmpDiagnostic
+	if err := c.cwmpDiagColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&diag); err != nil {
+		return nil, err
+	}
+	return &diag, nil
+}
+
+// GetPendingCwmpDiagnostic returns the most recent diagnostics job of a device
+// which is still waiting for its results
+func (c *CwmpDb) GetPendingCwmpDiagnostic(deviceID string) (*CwmpDiagnostic, error) {
+	if c.cwmpDiagColl == nil {
+		return nil, errors.New("CWMP diagnostics collection not initialized")
+	}
+
+	filter := bson.M{
+		"device_id": deviceID,
+		"status":    DiagnosticStatusRequested,
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	var diag CwmpDiagnostic
+	if err := c.cwmpDiagColl.FindOne(context.Background(), filter, opts).Decode(&diag); err != nil {
+		return nil, err
+	}
+	return &diag, nil
+}
+
+// CompleteCwmpDiagnostic stores the results of a diagnostics job and closes it
+func (c *CwmpDb) CompleteCwmpDiagnostic(id string, status string, results map[string]string) error {
+	if c.cwmpDiagColl == nil {
+		return errors.New("CWMP diagnostics collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":       status,
+			"results":      results,
+			"completed_at": time.Now(),
+		},
+	}
+	_, err := c.cwmpDiagColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// GetCwmpDiagnostics returns the diagnostics jobs matching filter, newest first
+func (c *CwmpDb) GetCwmpDiagnostics(filter bson.M, limit int64) ([]CwmpDiagnostic, error) {
+	if c.cwmpDiagColl == nil {
+		return nil, errors.New("CWMP diagnostics collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpDiagColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	diags := []CwmpDiagnostic{}
+	if err = cursor.All(ctx, &diags); err != nil {
+		return nil, err
+	}
+	return diags, nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// RecentEventsCacheSize is the number of events kept on the device document
+	RecentEventsCacheSize = 20
+	// DefaultEventRetention is used when database.eventRetention is not configured
+	DefaultEventRetention = 30 * 24 * time.Hour
+)
+
+// CwmpEvent is a device event stored in the CWMP event collection
+type CwmpEvent struct {
+	ID          string `bson:"_id" json:"id"`
+	DeviceID    string `bson:"device_id" json:"device_id"`
+	DeviceEvent `bson:",inline"`
+}
+
+// createCwmpEventIndexes creates the event indexes, including the TTL index
+// that expires events after the configured retention
+func (c *CwmpDb) createCwmpEventIndexes(ctx context.Context) error {
+	retention := cfg.eventTTL
+	if retention <= 0 {
+		retention = DefaultEventRetention
+	}
+
+	eventIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "event_code", Value: 1}, {Key: "timestamp", Value: -1}},
+		},
+	}
+	if _, err := c.cwmpEventColl.Indexes().CreateMany(ctx, eventIndexes); err != nil {
+		return err
+	}
+
+	ttlIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	}
+	if _, err := c.cwmpEventColl.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+		// An existing TTL index with a different retention has to be changed by hand
+		log.Printf("Warning: could not create CWMP event TTL index: %v", err)
+	}
+	return nil
+}
+
+// AddCwmpDeviceEvent records an event for the device and refreshes the
+// recent events cache on the device document
+func (c *CwmpDb) AddCwmpDeviceEvent(deviceID string, event DeviceEvent) error {
+	return c.AddCwmpDeviceEvents(deviceID, []DeviceEvent{event})
+}
+
+// AddCwmpDeviceEvents records several events for the device, such as the
+// events carried by a single Inform
+func (c *CwmpDb) AddCwmpDeviceEvents(deviceID string, events []DeviceEvent) error {
+	if c.cwmpDeviceColl == nil || c.cwmpEventColl == nil {
+		return errors.New("CWMP event collection not initialized")
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	docs := make([]interface{}, 0, len(events))
+	for i := range events {
+		if events[i].Timestamp.IsZero() {
+			events[i].Timestamp = now
+		}
+		docs = append(docs, CwmpEvent{
+			ID:          primitive.NewObjectID().Hex(),
+			DeviceID:    deviceID,
+			DeviceEvent: events[i],
+		})
+	}
+	if _, err := c.cwmpEventColl.InsertMany(ctx, docs); err != nil {
+		return err
+	}
+
+	update := bson.M{"$push": bson.M{"events": bson.M{
+		"$each":  events,
+		"$slice": -RecentEventsCacheSize,
+	}}}
+	_, err := c.cwmpDeviceColl.UpdateOne(ctx, bson.M{"_id": deviceID}, update)
+	return err
+}
+
+// GetCwmpDeviceEvents returns the events matching filter, newest first
+func (c *CwmpDb) GetCwmpDeviceEvents(filter bson.M, limit int64) ([]CwmpEvent, error) {
+	if c.cwmpEventColl == nil {
+		return nil, errors.New("CWMP event collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpEventColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []CwmpEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// GetCwmpDeviceEventsAfter returns the events stored after the event afterID
+// in the order they were stored. Event IDs are ordered by creation, an ID
+// built from a time returns the events stored after that time.
+func (c *CwmpDb) GetCwmpDeviceEventsAfter(afterID string, limit int64) ([]CwmpEvent, error) {
+	if c.cwmpEventColl == nil {
+		return nil, errors.New("CWMP event collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpEventColl.Find(ctx, bson.M{"_id": bson.M{"$gt": afterID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []CwmpEvent{}
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
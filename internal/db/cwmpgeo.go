@@ -0,0 +1,47 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DeviceGeo holds the GeoIP data resolved for a device's source address
+type DeviceGeo struct {
+	IPAddress  string    `bson:"ip_address" json:"ip_address"`
+	Country    string    `bson:"country,omitempty" json:"country,omitempty"`
+	Region     string    `bson:"region,omitempty" json:"region,omitempty"`
+	ASN        uint32    `bson:"asn,omitempty" json:"asn,omitempty"`
+	ASOrg      string    `bson:"as_org,omitempty" json:"as_org,omitempty"`
+	ResolvedAt time.Time `bson:"resolved_at" json:"resolved_at"`
+}
+
+// UpdateCwmpDeviceGeo stores the GeoIP data of an existing device
+func (c *CwmpDb) UpdateCwmpDeviceGeo(deviceID string, geo *DeviceGeo) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	if geo.ResolvedAt.IsZero() {
+		geo.ResolvedAt = time.Now()
+	}
+	update := bson.M{"$set": bson.M{"geo": geo, "updated_at": time.Now()}}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
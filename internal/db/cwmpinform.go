@@ -0,0 +1,110 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceInform holds the device attributes reported in an Inform. Empty
+// strings and nil values are not reported and keep the stored value.
+type DeviceInform struct {
+	OUI                    string
+	Manufacturer           string
+	ProductClass           string
+	SerialNumber           string
+	SoftwareVersion        string
+	HardwareVersion        string
+	ModelName              string
+	ProvisioningCode       string
+	SpecVersion            string
+	PeriodicInformEnable   *bool
+	PeriodicInformInterval *int
+	UpTime                 *int
+	CurrentTime            time.Time
+	Bootstrap              bool
+}
+
+// UpdateCwmpDeviceInform stores the attributes of a device reported in an
+// Inform and its last Inform time. An archived device which informs is
+// restored. With upsert an unknown device is created, otherwise
+// mongo.ErrNoDocuments is returned for it.
+func (c *CwmpDb) UpdateCwmpDeviceInform(deviceID string, inform *DeviceInform, upsert bool) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"oui":              inform.OUI,
+		"manufacturer_oui": inform.OUI,
+		"manufacturer":     inform.Manufacturer,
+		"product_class":    inform.ProductClass,
+		"serial_number":    inform.SerialNumber,
+		"last_inform":      now,
+		"updated_at":       now,
+	}
+	for field, value := range map[string]string{
+		"software_version":  inform.SoftwareVersion,
+		"hardware_version":  inform.HardwareVersion,
+		"model_name":        inform.ModelName,
+		"provisioning_code": inform.ProvisioningCode,
+		"spec_version":      inform.SpecVersion,
+	} {
+		if value != "" {
+			set[field] = value
+		}
+	}
+	if inform.PeriodicInformEnable != nil {
+		set["periodic_inform_enable"] = *inform.PeriodicInformEnable
+	}
+	if inform.PeriodicInformInterval != nil {
+		set["periodic_inform_interval"] = *inform.PeriodicInformInterval
+	}
+	if inform.UpTime != nil {
+		set["up_time"] = *inform.UpTime
+	}
+	if !inform.CurrentTime.IsZero() {
+		set["current_time"] = inform.CurrentTime
+	}
+	if inform.Bootstrap {
+		set["last_bootstrap"] = now
+	}
+
+	update := bson.M{"$set": set, "$unset": bson.M{"archived_at": ""}}
+	opts := options.Update()
+	if upsert {
+		update["$setOnInsert"] = bson.M{
+			"created_at": now,
+			"tags":       bson.A{},
+			"parameters": bson.M{},
+		}
+		opts.SetUpsert(true)
+	}
+	res, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update, opts)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 && res.UpsertedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
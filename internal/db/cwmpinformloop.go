@@ -0,0 +1,52 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// InformLoop flags a device which informs far more often than expected,
+// usually because it is stuck in a reboot loop
+type InformLoop struct {
+	Informs    int       `bson:"informs" json:"informs"`
+	Boots      int       `bson:"boots" json:"boots"`
+	Window     string    `bson:"window" json:"window"`
+	DetectedAt time.Time `bson:"detected_at" json:"detected_at"`
+}
+
+// UpdateCwmpDeviceInformLoop flags a device as informing in a loop, a nil
+// loop clears the flag
+func (c *CwmpDb) UpdateCwmpDeviceInformLoop(deviceID string, loop *InformLoop) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{"inform_loop": loop, "updated_at": time.Now()},
+	}
+	if loop == nil {
+		update = bson.M{
+			"$unset": bson.M{"inform_loop": ""},
+			"$set":   bson.M{"updated_at": time.Now()},
+		}
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
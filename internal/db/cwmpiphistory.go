@@ -0,0 +1,123 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CwmpIPChange records a change of a device's external address between sessions
+type CwmpIPChange struct {
+	DeviceID             string    `bson:"device_id" json:"device_id"`
+	IPAddress            string    `bson:"ip_address" json:"ip_address"`
+	ConnectionRequestURL string    `bson:"connection_request_url" json:"connection_request_url"`
+	PreviousIPAddress    string    `bson:"previous_ip_address" json:"previous_ip_address"`
+	PreviousURL          string    `bson:"previous_connection_request_url" json:"previous_connection_request_url"`
+	ChangedAt            time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// InsertCwmpIPChange appends an entry to the device's address history
+func (c *CwmpDb) InsertCwmpIPChange(change *CwmpIPChange) error {
+	if c.cwmpIPHistColl == nil {
+		return errors.New("CWMP IP history collection not initialized")
+	}
+
+	if change.ChangedAt.IsZero() {
+		change.ChangedAt = time.Now()
+	}
+	_, err := c.cwmpIPHistColl.InsertOne(context.Background(), change)
+	return err
+}
+
+// GetCwmpIPHistory returns the address history of a device, newest first
+func (c *CwmpDb) GetCwmpIPHistory(deviceID string) ([]CwmpIPChange, error) {
+	if c.cwmpIPHistColl == nil {
+		return nil, errors.New("CWMP IP history collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "changed_at", Value: -1}})
+	cursor, err := c.cwmpIPHistColl.Find(ctx, bson.M{"device_id": deviceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var history []CwmpIPChange
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// backfillCwmpIPKeys sets the ip_key of devices stored before it existed so
+// they are matched by the address filters and sorted by address
+func (c *CwmpDb) backfillCwmpIPKeys(ctx context.Context) {
+	filter := bson.M{
+		"ip_address": bson.M{"$nin": bson.A{nil, ""}},
+		"ip_key":     bson.M{"$exists": false},
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 1, "ip_address": 1})
+	cursor, err := c.cwmpDeviceColl.Find(ctx, filter, opts)
+	if err != nil {
+		log.Printf("Warning: could not backfill CWMP device IP keys: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var device struct {
+			ID        string `bson:"_id"`
+			IPAddress string `bson:"ip_address"`
+		}
+		if err := cursor.Decode(&device); err != nil {
+			continue
+		}
+		update := bson.M{"$set": bson.M{"ip_key": IPKey(device.IPAddress)}}
+		if _, err := c.cwmpDeviceColl.UpdateOne(ctx, bson.M{"_id": device.ID}, update); err != nil {
+			log.Printf("Warning: could not backfill IP key of device %s: %v", device.ID, err)
+			continue
+		}
+		count++
+	}
+	if count > 0 {
+		log.Printf("Backfilled the IP key of %d CWMP device(s)", count)
+	}
+}
+
+// UpdateCwmpDeviceAddress updates the address fields of an existing device
+func (c *CwmpDb) UpdateCwmpDeviceAddress(deviceID string, ipAddress string, connReqURL string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"ip_address":             ipAddress,
+			"ip_key":                 IPKey(ipAddress),
+			"connection_request_url": connReqURL,
+			"updated_at":             time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DefaultParamHistoryRetention is used when database.paramHistoryRetention
+// is not configured
+const DefaultParamHistoryRetention = 90 * 24 * time.Hour
+
+// Sources of a parameter change
+const (
+	ParamChangeSourceValueChange        = "value_change"
+	ParamChangeSourceInform             = "inform"
+	ParamChangeSourceGetParameterValues = "get_parameter_values"
+)
+
+// CwmpParameterChange records a change of a parameter value reported by a
+// device
+type CwmpParameterChange struct {
+	DeviceID  string    `bson:"device_id" json:"device_id"`
+	Path      string    `bson:"path" json:"path"`
+	OldValue  string    `bson:"old_value" json:"old_value"`
+	NewValue  string    `bson:"new_value" json:"new_value"`
+	Type      string    `bson:"type,omitempty" json:"type,omitempty"`
+	Source    string    `bson:"source" json:"source"`
+	New       bool      `bson:"new,omitempty" json:"new,omitempty"` // the parameter was not stored before
+	ChangedAt time.Time `bson:"changed_at" json:"changed_at"`
+}
+
+// createCwmpParamHistoryTTLIndex creates the TTL index that expires parameter
+// history entries after the configured retention
+func (c *CwmpDb) createCwmpParamHistoryTTLIndex(ctx context.Context) {
+	retention := cfg.historyTTL
+	if retention <= 0 {
+		retention = DefaultParamHistoryRetention
+	}
+
+	ttlIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "changed_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(retention.Seconds())),
+	}
+	if _, err := c.cwmpParamChangeColl.Indexes().CreateOne(ctx, ttlIndex); err != nil {
+		// An existing TTL index with a different retention has to be changed by hand
+		log.Printf("Warning: could not create CWMP parameter history TTL index: %v", err)
+	}
+}
+
+// InsertCwmpParameterChanges appends entries to the parameter history
+func (c *CwmpDb) InsertCwmpParameterChanges(changes []CwmpParameterChange) error {
+	if c.cwmpParamChangeColl == nil {
+		return errors.New("CWMP parameter change collection not initialized")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(changes))
+	for i := range changes {
+		if changes[i].ChangedAt.IsZero() {
+			changes[i].ChangedAt = time.Now()
+		}
+		docs = append(docs, changes[i])
+	}
+	_, err := c.cwmpParamChangeColl.InsertMany(context.Background(), docs)
+	return err
+}
+
+// GetCwmpParameterChanges returns the parameter history matching filter,
+// newest first
+func (c *CwmpDb) GetCwmpParameterChanges(filter bson.M, limit int64) ([]CwmpParameterChange, error) {
+	if c.cwmpParamChangeColl == nil {
+		return nil, errors.New("CWMP parameter change collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "changed_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpParamChangeColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	changes := []CwmpParameterChange{}
+	if err = cursor.All(ctx, &changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// UpdateCwmpParameterValues stores new values of existing or new parameters
+// without resetting their other attributes
+func (c *CwmpDb) UpdateCwmpParameterValues(parameters []CwmpParameter) error {
+	if c.cwmpParamColl == nil {
+		return errors.New("CWMP parameter collection not initialized")
+	}
+	if len(parameters) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	operations := make([]mongo.WriteModel, 0, len(parameters))
+	for _, param := range parameters {
+		set := bson.M{"value": param.Value, "last_update": now}
+		if param.Type != "" {
+			set["type"] = param.Type
+		}
+		operations = append(operations, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"device_id": param.DeviceID, "path": param.Path}).
+			SetUpdate(bson.M{"$set": set}).
+			SetUpsert(true))
+	}
+	_, err := c.cwmpParamColl.BulkWrite(context.Background(), operations)
+	return err
+}
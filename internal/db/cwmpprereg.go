@@ -0,0 +1,222 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Pre-registration states
+const (
+	PreRegStatusExpected   = "expected"
+	PreRegStatusRegistered = "registered"
+)
+
+// CwmpPreRegistration is a device expected to connect to the ACS, imported
+// before its first Inform
+type CwmpPreRegistration struct {
+	ID           string            `bson:"_id" json:"id"`
+	OUI          string            `bson:"oui" json:"oui"`
+	SerialNumber string            `bson:"serial_number" json:"serial_number"`
+	ProductClass string            `bson:"product_class,omitempty" json:"product_class,omitempty"`
+	Profile      string            `bson:"profile,omitempty" json:"profile,omitempty"`
+	Subscriber   map[string]string `bson:"subscriber,omitempty" json:"subscriber,omitempty"`
+	Status       string            `bson:"status" json:"status"`
+	DeviceID     string            `bson:"device_id,omitempty" json:"device_id,omitempty"`
+	CreatedAt    time.Time         `bson:"created_at" json:"created_at"`
+	RegisteredAt *time.Time        `bson:"registered_at,omitempty" json:"registered_at,omitempty"`
+}
+
+// CwmpPendingDevice is a device which informed without being pre-registered
+type CwmpPendingDevice struct {
+	ID           string    `bson:"_id" json:"device_id"`
+	OUI          string    `bson:"oui" json:"oui"`
+	SerialNumber string    `bson:"serial_number" json:"serial_number"`
+	Manufacturer string    `bson:"manufacturer" json:"manufacturer"`
+	ProductClass string    `bson:"product_class" json:"product_class"`
+	IPAddress    string    `bson:"ip_address" json:"ip_address"`
+	InformCount  int64     `bson:"inform_count" json:"inform_count"`
+	FirstSeen    time.Time `bson:"first_seen" json:"first_seen"`
+	LastSeen     time.Time `bson:"last_seen" json:"last_seen"`
+}
+
+// PreRegistrationID returns the ID of the pre-registration of a device
+func PreRegistrationID(oui, serialNumber string) string {
+	return oui + ":" + serialNumber
+}
+
+// UpsertCwmpPreRegistrations stores pre-registrations, replacing existing
+// entries of the same OUI and serial number which did not register yet
+func (c *CwmpDb) UpsertCwmpPreRegistrations(regs []CwmpPreRegistration) error {
+	if c.cwmpPreRegColl == nil {
+		return errors.New("CWMP pre-registration collection not initialized")
+	}
+	if len(regs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	var operations []mongo.WriteModel
+	for i := range regs {
+		reg := &regs[i]
+		reg.ID = PreRegistrationID(reg.OUI, reg.SerialNumber)
+		reg.Status = PreRegStatusExpected
+		reg.CreatedAt = now
+		filter := bson.M{"_id": reg.ID, "status": bson.M{"$ne": PreRegStatusRegistered}}
+		operations = append(operations, mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(reg).SetUpsert(true))
+	}
+
+	_, err := c.cwmpPreRegColl.BulkWrite(context.Background(), operations, options.BulkWrite().SetOrdered(false))
+	if mongo.IsDuplicateKeyError(err) {
+		// Entries of already registered devices are left untouched
+		return nil
+	}
+	return err
+}
+
+// GetCwmpPreRegistration returns the pending pre-registration of a device
+func (c *CwmpDb) GetCwmpPreRegistration(oui, serialNumber string) (*CwmpPreRegistration, error) {
+	if c.cwmpPreRegColl == nil {
+		return nil, errors.New("CWMP pre-registration collection not initialized")
+	}
+
+	filter := bson.M{"_id": PreRegistrationID(oui, serialNumber), "status": PreRegStatusExpected}
+	var reg CwmpPreRegistration
+	if err := c.cwmpPreRegColl.FindOne(context.Background(), filter).Decode(&reg); err != nil {
+		return nil, err
+	}
+	return &reg, nil
+}
+
+// GetCwmpPreRegistrations returns the pre-registrations matching filter
+func (c *CwmpDb) GetCwmpPreRegistrations(filter bson.M, limit int64) ([]CwmpPreRegistration, error) {
+	if c.cwmpPreRegColl == nil {
+		return nil, errors.New("CWMP pre-registration collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpPreRegColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	regs := []CwmpPreRegistration{}
+	if err = cursor.All(ctx, &regs); err != nil {
+		return nil, err
+	}
+	return regs, nil
+}
+
+// MarkCwmpPreRegistrationRegistered links a pre-registration to the device
+// created on its first contact
+func (c *CwmpDb) MarkCwmpPreRegistrationRegistered(id string, deviceID string) error {
+	if c.cwmpPreRegColl == nil {
+		return errors.New("CWMP pre-registration collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{
+		"status":        PreRegStatusRegistered,
+		"device_id":     deviceID,
+		"registered_at": time.Now(),
+	}}
+	_, err := c.cwmpPreRegColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// DeleteCwmpPreRegistration removes a pre-registration
+func (c *CwmpDb) DeleteCwmpPreRegistration(id string) error {
+	if c.cwmpPreRegColl == nil {
+		return errors.New("CWMP pre-registration collection not initialized")
+	}
+
+	res, err := c.cwmpPreRegColl.DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// UpsertCwmpPendingDevice adds an unexpected device to the pending queue or
+// refreshes its entry
+func (c *CwmpDb) UpsertCwmpPendingDevice(pending *CwmpPendingDevice) error {
+	if c.cwmpPendingColl == nil {
+		return errors.New("CWMP pending device collection not initialized")
+	}
+
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"oui":           pending.OUI,
+			"serial_number": pending.SerialNumber,
+			"manufacturer":  pending.Manufacturer,
+			"product_class": pending.ProductClass,
+			"ip_address":    pending.IPAddress,
+			"last_seen":     now,
+		},
+		"$setOnInsert": bson.M{"first_seen": now},
+		"$inc":         bson.M{"inform_count": 1},
+	}
+	opts := options.Update().SetUpsert(true)
+	_, err := c.cwmpPendingColl.UpdateOne(context.Background(), bson.M{"_id": pending.ID}, update, opts)
+	return err
+}
+
+// GetCwmpPendingDevices returns the pending queue, most recently seen first
+func (c *CwmpDb) GetCwmpPendingDevices(limit int64) ([]CwmpPendingDevice, error) {
+	if c.cwmpPendingColl == nil {
+		return nil, errors.New("CWMP pending device collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "last_seen", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpPendingColl.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	pending := []CwmpPendingDevice{}
+	if err = cursor.All(ctx, &pending); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// DeleteCwmpPendingDevice removes a device from the pending queue
+func (c *CwmpDb) DeleteCwmpPendingDevice(deviceID string) error {
+	if c.cwmpPendingColl == nil {
+		return errors.New("CWMP pending device collection not initialized")
+	}
+
+	_, err := c.cwmpPendingColl.DeleteOne(context.Background(), bson.M{"_id": deviceID})
+	return err
+}
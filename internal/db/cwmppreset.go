@@ -0,0 +1,101 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CwmpPreset is a set of parameter values the ACS keeps applied to the
+// devices matching its condition. Presets are evaluated on every Inform,
+// or only on the Informs carrying one of Events; on conflicting parameters
+// the preset with the highest weight wins.
+type CwmpPreset struct {
+	Name        string             `bson:"_id" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Condition   string             `bson:"condition,omitempty" json:"condition,omitempty"`
+	Events      []string           `bson:"events,omitempty" json:"events,omitempty"`
+	Weight      int                `bson:"weight" json:"weight"`
+	Parameters  []ProfileParameter `bson:"parameters" json:"parameters"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// UpsertCwmpPreset creates or replaces a preset
+func (c *CwmpDb) UpsertCwmpPreset(preset *CwmpPreset) error {
+	if c.cwmpPresetColl == nil {
+		return errors.New("CWMP preset collection not initialized")
+	}
+
+	preset.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpPresetColl.ReplaceOne(context.Background(), bson.M{"_id": preset.Name}, preset, opts)
+	return err
+}
+
+// GetCwmpPreset returns a preset by name
+func (c *CwmpDb) GetCwmpPreset(name string) (*CwmpPreset, error) {
+	if c.cwmpPresetColl == nil {
+		return nil, errors.New("CWMP preset collection not initialized")
+	}
+
+	var preset CwmpPreset
+	if err := c.cwmpPresetColl.FindOne(context.Background(), bson.M{"_id": name}).Decode(&preset); err != nil {
+		return nil, err
+	}
+	return &preset, nil
+}
+
+// GetCwmpPresets returns all presets by ascending weight, then name
+func (c *CwmpDb) GetCwmpPresets() ([]CwmpPreset, error) {
+	if c.cwmpPresetColl == nil {
+		return nil, errors.New("CWMP preset collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "weight", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := c.cwmpPresetColl.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	presets := []CwmpPreset{}
+	if err = cursor.All(ctx, &presets); err != nil {
+		return nil, err
+	}
+	return presets, nil
+}
+
+// DeleteCwmpPreset removes a preset
+func (c *CwmpDb) DeleteCwmpPreset(name string) error {
+	if c.cwmpPresetColl == nil {
+		return errors.New("CWMP preset collection not initialized")
+	}
+
+	res, err := c.cwmpPresetColl.DeleteOne(context.Background(), bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProfileParameter is a parameter value set by a provisioning profile
+type ProfileParameter struct {
+	Path  string `bson:"path" json:"path"`
+	Value string `bson:"value" json:"value"`
+	Type  string `bson:"type,omitempty" json:"type,omitempty"`
+}
+
+// CwmpProfile is a named set of parameter values applied to devices
+type CwmpProfile struct {
+	Name        string             `bson:"_id" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Parameters  []ProfileParameter `bson:"parameters" json:"parameters"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// UpsertCwmpProfile creates or replaces a profile
+func (c *CwmpDb) UpsertCwmpProfile(profile *CwmpProfile) error {
+	if c.cwmpProfileColl == nil {
+		return errors.New("CWMP profile collection not initialized")
+	}
+
+	profile.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpProfileColl.ReplaceOne(context.Background(), bson.M{"_id": profile.Name}, profile, opts)
+	return err
+}
+
+// GetCwmpProfile returns a profile by name
+func (c *CwmpDb) GetCwmpProfile(name string) (*CwmpProfile, error) {
+	if c.cwmpProfileColl == nil {
+		return nil, errors.New("CWMP profile collection not initialized")
+	}
+
+	var profile CwmpProfile
+	if err := c.cwmpProfileColl.FindOne(context.Background(), bson.M{"_id": name}).Decode(&profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetCwmpProfiles returns all profiles ordered by name
+func (c *CwmpDb) GetCwmpProfiles() ([]CwmpProfile, error) {
+	if c.cwmpProfileColl == nil {
+		return nil, errors.New("CWMP profile collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := c.cwmpProfileColl.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	profiles := []CwmpProfile{}
+	if err = cursor.All(ctx, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// DeleteCwmpProfile removes a profile
+func (c *CwmpDb) DeleteCwmpProfile(name string) error {
+	if c.cwmpProfileColl == nil {
+		return errors.New("CWMP profile collection not initialized")
+	}
+
+	res, err := c.cwmpProfileColl.DeleteOne(context.Background(), bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
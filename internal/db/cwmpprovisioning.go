@@ -0,0 +1,57 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Provisioning actions, in the order a flow usually lists them
+const (
+	ProvisioningActionPeriodicInform = "periodicInform"
+	ProvisioningActionCredentials    = "credentials"
+	ProvisioningActionProfile        = "profile"
+	ProvisioningActionFirmware       = "firmware"
+)
+
+// DeviceProvisioning records the progress of the provisioning flow applied
+// to a device on its first BOOTSTRAP. Actions use the sync job states.
+type DeviceProvisioning struct {
+	Flow        string     `bson:"flow" json:"flow"`
+	Status      string     `bson:"status" json:"status"`
+	Actions     []SyncStep `bson:"actions" json:"actions"`
+	StartedAt   time.Time  `bson:"started_at" json:"started_at"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// UpdateCwmpDeviceProvisioning stores the provisioning progress of a device
+func (c *CwmpDb) UpdateCwmpDeviceProvisioning(deviceID string, provisioning *DeviceProvisioning) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"provisioning": provisioning,
+			"updated_at":   time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
@@ -0,0 +1,123 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SyncTriggerRefresh is the trigger of the periodic full refresh of the
+// parameters of a device, run as a sync job with the steps below
+const SyncTriggerRefresh = "refresh"
+
+// Steps of the periodic parameter refresh
+const (
+	SyncStepDiscover = "discover" // GetParameterNames of the whole tree
+	SyncStepValues   = "values"   // GetParameterValues of all parameters, in chunks
+)
+
+// GetCwmpDevicesDueForRefresh returns the IDs of the devices whose
+// parameters were last refreshed before the given time, or never, and
+// which are not already waiting for a refresh. The least recently
+// refreshed devices come first.
+func (c *CwmpDb) GetCwmpDevicesDueForRefresh(before time.Time, limit int64) ([]string, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	filter := bson.M{
+		"refresh_due": bson.M{"$ne": true},
+		"$or": bson.A{
+			bson.M{"last_refresh": bson.M{"$exists": false}},
+			bson.M{"last_refresh": bson.M{"$lt": before}},
+		},
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "last_refresh", Value: 1}}).
+		SetProjection(bson.M{"_id": 1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpDeviceColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var devices []struct {
+		ID string `bson:"_id"`
+	}
+	if err = cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(devices))
+	for _, d := range devices {
+		ids = append(ids, d.ID)
+	}
+	return ids, nil
+}
+
+// SetCwmpDeviceRefreshDue marks a device as waiting for a parameter refresh
+func (c *CwmpDb) SetCwmpDeviceRefreshDue(deviceID string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"refresh_due": true, "updated_at": time.Now()}}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
+
+// StartCwmpDeviceRefresh clears the refresh due flag of a device and
+// records when its refresh started
+func (c *CwmpDb) StartCwmpDeviceRefresh(deviceID string, startedAt time.Time) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set":   bson.M{"last_refresh": startedAt, "updated_at": time.Now()},
+		"$unset": bson.M{"refresh_due": ""},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
+
+// DeleteStaleCwmpParameters removes the parameters of a device under prefix
+// which were not updated since the given time, i.e. those a full refresh
+// did not report anymore
+func (c *CwmpDb) DeleteStaleCwmpParameters(deviceID string, prefix string, before time.Time) (int64, error) {
+	if c.cwmpParamColl == nil {
+		return 0, errors.New("CWMP parameter collection not initialized")
+	}
+
+	filter := bson.M{
+		"device_id":   deviceID,
+		"path":        bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)},
+		"last_update": bson.M{"$lt": before},
+	}
+	res, err := c.cwmpParamColl.DeleteMany(context.Background(), filter)
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UpsertCwmpSession stores the current state of a CWMP session
+func (c *CwmpDb) UpsertCwmpSession(session *CwmpSession) error {
+	if c.cwmpSessionColl == nil {
+		return errors.New("CWMP session collection not initialized")
+	}
+
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpSessionColl.ReplaceOne(context.Background(), bson.M{"_id": session.ID}, session, opts)
+	return err
+}
+
+// GetCwmpSessions returns the sessions matching filter, most recently active first
+func (c *CwmpDb) GetCwmpSessions(filter bson.M, limit int64) ([]CwmpSession, error) {
+	if c.cwmpSessionColl == nil {
+		return nil, errors.New("CWMP session collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "last_activity", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpSessionColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	sessions := []CwmpSession{}
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetOpenCwmpSessions returns the sessions which were not closed, they were
+// interrupted if the ACS restarted
+func (c *CwmpDb) GetOpenCwmpSessions() ([]CwmpSession, error) {
+	return c.GetCwmpSessions(bson.M{"state": bson.M{"$ne": "closed"}}, 0)
+}
@@ -0,0 +1,135 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Operations of ChangeDUState on a deployment unit
+const (
+	DUOperationInstall   = "install"
+	DUOperationUpdate    = "update"
+	DUOperationUninstall = "uninstall"
+)
+
+// States of the last operation on a deployment unit. An operation is pending
+// until the device accepts the ChangeDUState, in progress until it sends
+// ChangeDUStateComplete.
+const (
+	DUOperationPending    = "pending"
+	DUOperationInProgress = "in_progress"
+	DUOperationCompleted  = "completed"
+	DUOperationFailed     = "failed"
+)
+
+// CwmpDeploymentUnit is a software module (TR-157 deployment unit) of a
+// device, with the outcome of the last operation requested on it
+type CwmpDeploymentUnit struct {
+	ID                string   `bson:"_id" json:"id"`
+	DeviceID          string   `bson:"device_id" json:"device_id"`
+	UUID              string   `bson:"uuid,omitempty" json:"uuid,omitempty"`
+	URL               string   `bson:"url,omitempty" json:"url,omitempty"`
+	Version           string   `bson:"version,omitempty" json:"version,omitempty"`
+	ExecutionEnvRef   string   `bson:"execution_env_ref,omitempty" json:"execution_env_ref,omitempty"`
+	DeploymentUnitRef string   `bson:"deployment_unit_ref,omitempty" json:"deployment_unit_ref,omitempty"`
+	ExecutionUnitRefs []string `bson:"execution_unit_refs,omitempty" json:"execution_unit_refs,omitempty"`
+	// State is the state reported by the device: Installed, Uninstalled or
+	// Failed
+	State           string     `bson:"state,omitempty" json:"state,omitempty"`
+	Resolved        bool       `bson:"resolved" json:"resolved"`
+	Operation       string     `bson:"operation" json:"operation"`
+	OperationStatus string     `bson:"operation_status" json:"operation_status"`
+	CommandKey      string     `bson:"command_key" json:"command_key"`
+	FaultCode       string     `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString     string     `bson:"fault_string,omitempty" json:"fault_string,omitempty"`
+	CreatedAt       time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time  `bson:"updated_at" json:"updated_at"`
+	CompletedAt     *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// UpsertCwmpDeploymentUnit stores a deployment unit, creating it on first use
+func (c *CwmpDb) UpsertCwmpDeploymentUnit(du *CwmpDeploymentUnit) error {
+	if c.cwmpSoftwareColl == nil {
+		return errors.New("CWMP software collection not initialized")
+	}
+
+	now := time.Now()
+	if du.ID == "" {
+		du.ID = primitive.NewObjectID().Hex()
+		du.CreatedAt = now
+	}
+	du.UpdatedAt = now
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpSoftwareColl.ReplaceOne(context.Background(), bson.M{"_id": du.ID}, du, opts)
+	return err
+}
+
+// GetCwmpDeploymentUnits returns the deployment units of a device, newest
+// first
+func (c *CwmpDb) GetCwmpDeploymentUnits(deviceID string) ([]CwmpDeploymentUnit, error) {
+	if c.cwmpSoftwareColl == nil {
+		return nil, errors.New("CWMP software collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := c.cwmpSoftwareColl.Find(ctx, bson.M{"device_id": deviceID}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	dus := []CwmpDeploymentUnit{}
+	if err = cursor.All(ctx, &dus); err != nil {
+		return nil, err
+	}
+	return dus, nil
+}
+
+// GetCwmpDeploymentUnit returns the deployment unit of a device with the
+// given UUID
+func (c *CwmpDb) GetCwmpDeploymentUnit(deviceID string, uuid string) (*CwmpDeploymentUnit, error) {
+	return c.findCwmpDeploymentUnit(bson.M{"device_id": deviceID, "uuid": uuid})
+}
+
+// GetCwmpDeploymentUnitByCommandKey returns the deployment unit whose
+// unfinished operation has the given CommandKey
+func (c *CwmpDb) GetCwmpDeploymentUnitByCommandKey(deviceID string, commandKey string) (*CwmpDeploymentUnit, error) {
+	return c.findCwmpDeploymentUnit(bson.M{
+		"device_id":        deviceID,
+		"command_key":      commandKey,
+		"operation_status": bson.M{"$in": []string{DUOperationPending, DUOperationInProgress}},
+	})
+}
+
+func (c *CwmpDb) findCwmpDeploymentUnit(filter bson.M) (*CwmpDeploymentUnit, error) {
+	if c.cwmpSoftwareColl == nil {
+		return nil, errors.New("CWMP software collection not initialized")
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	var du CwmpDeploymentUnit
+	if err := c.cwmpSoftwareColl.FindOne(context.Background(), filter, opts).Decode(&du); err != nil {
+		return nil, err
+	}
+	return &du, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Steps of the BOOTSTRAP synchronization workflow, in execution order
+const (
+	SyncStepCrawl         = "crawl"
+	SyncStepProfile       = "profile"
+	SyncStepCredentials   = "credentials"
+	SyncStepNotifications = "notifications"
+	SyncStepSnapshot      = "snapshot"
+)
+
+// Sync job and step states
+const (
+	SyncStatusPending  = "pending"
+	SyncStatusRunning  = "running"
+	SyncStatusComplete = "complete"
+	SyncStatusFailed   = "failed"
+	SyncStatusSkipped  = "skipped"
+)
+
+// SyncStep is one step of a sync job
+type SyncStep struct {
+	Name        string     `bson:"name" json:"name"`
+	Status      string     `bson:"status" json:"status"`
+	Error       string     `bson:"error,omitempty" json:"error,omitempty"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// CwmpSyncJob tracks the full synchronization of a device run on BOOTSTRAP
+type CwmpSyncJob struct {
+	ID          string     `bson:"_id" json:"id"`
+	DeviceID    string     `bson:"device_id" json:"device_id"`
+	Trigger     string     `bson:"trigger" json:"trigger"`
+	Status      string     `bson:"status" json:"status"`
+	Steps       []SyncStep `bson:"steps" json:"steps"`
+	SnapshotID  string     `bson:"snapshot_id,omitempty" json:"snapshot_id,omitempty"`
+	RequestID   string     `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+	CompletedAt *time.Time `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// SnapshotParameter is a parameter value captured in a snapshot
+type SnapshotParameter struct {
+	Path  string `bson:"path" json:"path"`
+	Value string `bson:"value" json:"value"`
+	Type  string `bson:"type,omitempty" json:"type,omitempty"`
+}
+
+// CwmpParameterSnapshot is the parameter tree of a device at a point in time
+type CwmpParameterSnapshot struct {
+	ID         string              `bson:"_id" json:"id"`
+	DeviceID   string              `bson:"device_id" json:"device_id"`
+	JobID      string              `bson:"job_id,omitempty" json:"job_id,omitempty"`
+	Parameters []SnapshotParameter `bson:"parameters" json:"parameters"`
+	CreatedAt  time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// Step returns the step of the job with the given name
+func (j *CwmpSyncJob) Step(name string) *SyncStep {
+	for i := range j.Steps {
+		if j.Steps[i].Name == name {
+			return &j.Steps[i]
+		}
+	}
+	return nil
+}
+
+// UpsertCwmpSyncJob stores a sync job, creating it on first use
+func (c *CwmpDb) UpsertCwmpSyncJob(job *CwmpSyncJob) error {
+	if c.cwmpSyncJobColl == nil {
+		return errors.New("CWMP sync job collection not initialized")
+	}
+
+	now := time.Now()
+	if job.ID == "" {
+		job.ID = primitive.NewObjectID().Hex()
+		job.CreatedAt = now
+	}
+	job.UpdatedAt = now
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.cwmpSyncJobColl.ReplaceOne(context.Background(), bson.M{"_id": job.ID}, job, opts)
+	return err
+}
+
+// GetCwmpSyncJob returns a sync job by ID
+func (c *CwmpDb) GetCwmpSyncJob(id string) (*CwmpSyncJob, error) {
+	if c.cwmpSyncJobColl == nil {
+		return nil, errors.New("CWMP sync job collection not initialized")
+	}
+
+	var job CwmpSyncJob
+	if err := c.cwmpSyncJobColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetCwmpSyncJobs returns the sync jobs matching filter, newest first
+func (c *CwmpDb) GetCwmpSyncJobs(filter bson.M, limit int64) ([]CwmpSyncJob, error) {
+	if c.cwmpSyncJobColl == nil {
+		return nil, errors.New("CWMP sync job collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpSyncJobColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []CwmpSyncJob{}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// InsertCwmpParameterSnapshot stores a parameter snapshot
+func (c *CwmpDb) InsertCwmpParameterSnapshot(snapshot *CwmpParameterSnapshot) error {
+	if c.cwmpSnapshotColl == nil {
+		return errors.New("CWMP snapshot collection not initialized")
+	}
+
+	snapshot.ID = primitive.NewObjectID().Hex()
+	snapshot.CreatedAt = time.Now()
+	_, err := c.cwmpSnapshotColl.InsertOne(context.Background(), snapshot)
+	return err
+}
+
+// GetLatestCwmpParameterSnapshot returns the most recent snapshot of a device
+func (c *CwmpDb) GetLatestCwmpParameterSnapshot(deviceID string) (*CwmpParameterSnapshot, error) {
+	if c.cwmpSnapshotColl == nil {
+		return nil, errors.New("CWMP snapshot collection not initialized")
+	}
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var snapshot CwmpParameterSnapshot
+	if err := c.cwmpSnapshotColl.FindOne(context.Background(), bson.M{"device_id": deviceID}, opts).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// UpdateCwmpDeviceConnectionCredentials stores the connection request
+// credentials provisioned on a device
+func (c *CwmpDb) UpdateCwmpDeviceConnectionCredentials(deviceID string, username string, password string) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"connection_request_username": username,
+			"connection_request_password": password,
+			"updated_at":                  time.Now(),
+		},
+	}
+	_, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	return err
+}
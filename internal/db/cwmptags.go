@@ -0,0 +1,95 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TagCount is the number of devices carrying a tag
+type TagCount struct {
+	Tag   string `bson:"_id" json:"tag"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+// AddCwmpDeviceTags adds tags to a device and returns its tags
+func (c *CwmpDb) AddCwmpDeviceTags(deviceID string, tags []string) ([]string, error) {
+	return c.updateCwmpDeviceTags(deviceID, bson.M{"$addToSet": bson.M{"tags": bson.M{"$each": tags}}})
+}
+
+// RemoveCwmpDeviceTags removes tags from a device and returns its tags
+func (c *CwmpDb) RemoveCwmpDeviceTags(deviceID string, tags []string) ([]string, error) {
+	return c.updateCwmpDeviceTags(deviceID, bson.M{"$pullAll": bson.M{"tags": tags}})
+}
+
+func (c *CwmpDb) updateCwmpDeviceTags(deviceID string, update bson.M) ([]string, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	// Devices stored without tags hold null, which cannot be updated as a set
+	empty := bson.M{"$set": bson.M{"tags": bson.A{}}}
+	if _, err := c.cwmpDeviceColl.UpdateOne(ctx, bson.M{"_id": deviceID, "tags": nil}, empty); err != nil {
+		return nil, err
+	}
+
+	update["$set"] = bson.M{"updated_at": time.Now()}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"tags": 1})
+	var device struct {
+		Tags []string `bson:"tags"`
+	}
+	if err := c.cwmpDeviceColl.FindOneAndUpdate(ctx, bson.M{"_id": deviceID}, update, opts).Decode(&device); err != nil {
+		return nil, err
+	}
+	if device.Tags == nil {
+		device.Tags = []string{}
+	}
+	return device.Tags, nil
+}
+
+// GetCwmpTagCounts returns the tags of the devices matching filter with the
+// number of devices carrying each of them, most used first
+func (c *CwmpDb) GetCwmpTagCounts(filter bson.M) ([]TagCount, error) {
+	if c.cwmpDeviceColl == nil {
+		return nil, errors.New("CWMP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	pipeline := []bson.M{
+		{"$match": filter},
+		{"$unwind": "$tags"},
+		{"$group": bson.M{"_id": "$tags", "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+	}
+	cursor, err := c.cwmpDeviceColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []TagCount{}
+	if err = cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
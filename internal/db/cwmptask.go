@@ -0,0 +1,193 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a task. A task is queued until its command is sent to the
+// device, sent until the device answers it, then completed or faulted. It
+// expires if the device does not connect before the TTL of the command.
+const (
+	TaskStatusQueued    = "queued"
+	TaskStatusSent      = "sent"
+	TaskStatusCompleted = "completed"
+	TaskStatusFaulted   = "faulted"
+	TaskStatusExpired   = "expired"
+)
+
+// CwmpTask tracks an operation requested through the API for a device. It
+// follows the queued command with the same request ID, device and method.
+type CwmpTask struct {
+	ID          string    `bson:"_id" json:"id"`
+	DeviceID    string    `bson:"device_id" json:"device_id"`
+	Method      string    `bson:"method" json:"method"`
+	RequestID   string    `bson:"request_id" json:"request_id"`
+	CommandID   string    `bson:"command_id,omitempty" json:"command_id,omitempty"`
+	Status      string    `bson:"status" json:"status"`
+	FaultCode   string    `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString string    `bson:"fault_string,omitempty" json:"fault_string,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// IsFinal reports whether the task no longer changes
+func (t *CwmpTask) IsFinal() bool {
+	switch t.Status {
+	case TaskStatusCompleted, TaskStatusFaulted, TaskStatusExpired:
+		return true
+	}
+	return false
+}
+
+// InsertCwmpTask stores a queued task
+func (c *CwmpDb) InsertCwmpTask(task *CwmpTask) error {
+	if c.cwmpTaskColl == nil {
+		return errors.New("CWMP task collection not initialized")
+	}
+
+	task.ID = primitive.NewObjectID().Hex()
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	task.Status = TaskStatusQueued
+	_, err := c.cwmpTaskColl.InsertOne(context.Background(), task)
+	return err
+}
+
+// GetCwmpTask returns a task by ID, updated from its command
+func (c *CwmpDb) GetCwmpTask(id string) (*CwmpTask, error) {
+	if c.cwmpTaskColl == nil {
+		return nil, errors.New("CWMP task collection not initialized")
+	}
+
+	var task CwmpTask
+	if err := c.cwmpTaskColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&task); err != nil {
+		return nil, err
+	}
+	if err := c.refreshCwmpTask(&task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+// GetCwmpTasks returns the tasks matching filter, newest first. The tasks
+// which are not final are updated from their commands before the query, so
+// that filtering on status sees their current state.
+func (c *CwmpDb) GetCwmpTasks(filter bson.M, limit int64) ([]CwmpTask, error) {
+	if c.cwmpTaskColl == nil {
+		return nil, errors.New("CWMP task collection not initialized")
+	}
+
+	pending := bson.M{"status": bson.M{"$in": bson.A{TaskStatusQueued, TaskStatusSent}}}
+	for key, value := range filter {
+		if key != "status" {
+			pending[key] = value
+		}
+	}
+	tasks, err := c.findCwmpTasks(pending, options.Find())
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		if err := c.refreshCwmpTask(&tasks[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return c.findCwmpTasks(filter, opts)
+}
+
+// refreshCwmpTask updates a task which is not final from the state of its
+// command and stores it if it changed
+func (c *CwmpDb) refreshCwmpTask(task *CwmpTask) error {
+	if task.IsFinal() || c.cwmpCommandColl == nil {
+		return nil
+	}
+
+	filter := bson.M{"request_id": task.RequestID, "device_id": task.DeviceID, "method": task.Method}
+	opts := options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	var cmd CwmpCommand
+	if err := c.cwmpCommandColl.FindOne(context.Background(), filter, opts).Decode(&cmd); err != nil {
+		if err == mongo.ErrNoDocuments {
+			// The ACS has not queued the command yet
+			return nil
+		}
+		return err
+	}
+
+	status := taskStatus(&cmd)
+	if status == task.Status && cmd.ID == task.CommandID {
+		return nil
+	}
+	task.CommandID = cmd.ID
+	task.Status = status
+	task.FaultCode = cmd.FaultCode
+	task.FaultString = cmd.FaultString
+	task.UpdatedAt = time.Now()
+	update := bson.M{"$set": bson.M{
+		"command_id":   task.CommandID,
+		"status":       task.Status,
+		"fault_code":   task.FaultCode,
+		"fault_string": task.FaultString,
+		"updated_at":   task.UpdatedAt,
+	}}
+	_, err := c.cwmpTaskColl.UpdateOne(context.Background(), bson.M{"_id": task.ID}, update)
+	return err
+}
+
+// taskStatus maps the status of a command to the status of its task
+func taskStatus(cmd *CwmpCommand) string {
+	switch cmd.Status {
+	case CommandStatusDelivered:
+		return TaskStatusSent
+	case CommandStatusAnswered:
+		if cmd.FaultCode != "" {
+			return TaskStatusFaulted
+		}
+		return TaskStatusCompleted
+	case CommandStatusFailed:
+		return TaskStatusFaulted
+	case CommandStatusExpired:
+		return TaskStatusExpired
+	}
+	return TaskStatusQueued
+}
+
+func (c *CwmpDb) findCwmpTasks(filter bson.M, opts *options.FindOptions) ([]CwmpTask, error) {
+	ctx := context.Background()
+	cursor, err := c.cwmpTaskColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	tasks := []CwmpTask{}
+	if err = cursor.All(ctx, &tasks); err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Timeline entry kinds
+const (
+	TimelineKindEvent     = "event"
+	TimelineKindJob       = "job"
+	TimelineKindParameter = "parameter"
+	TimelineKindTransfer  = "transfer"
+	TimelineKindAlarm     = "alarm"
+)
+
+// TimelineEntry is one item of a device timeline
+type TimelineEntry struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Kind      string      `json:"kind"`
+	Summary   string      `json:"summary"`
+	Ref       string      `json:"ref,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+// GetCwmpDeviceTimeline merges the events, diagnostics jobs, parameter
+// updates, file transfers and alarms of a device into one feed, newest
+// first. Only entries older than before are returned, so the timestamp of
+// the last entry is the cursor of the next page.
+func (c *CwmpDb) GetCwmpDeviceTimeline(deviceID string, before time.Time, limit int) ([]TimelineEntry, error) {
+	olderThan := bson.M{"$lt": before}
+	entries := []TimelineEntry{}
+
+	events, err := c.GetCwmpDeviceEvents(bson.M{"device_id": deviceID, "timestamp": olderThan}, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range events {
+		summary := e.EventCode
+		if e.CommandKey != "" {
+			summary += " (" + e.CommandKey + ")"
+		}
+		entries = append(entries, TimelineEntry{Timestamp: e.Timestamp, Kind: TimelineKindEvent,
+			Summary: summary, Ref: e.ID, Details: e.Details})
+	}
+
+	// A finished job shows up both when it was requested and when it completed
+	jobFilter := bson.M{"device_id": deviceID, "$or": bson.A{
+		bson.M{"created_at": olderThan},
+		bson.M{"completed_at": olderThan},
+	}}
+	jobs, err := c.GetCwmpDiagnostics(jobFilter, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs {
+		if j.CreatedAt.Before(before) {
+			entries = append(entries, TimelineEntry{Timestamp: j.CreatedAt, Kind: TimelineKindJob,
+				Summary: j.DiagnosticType + " requested", Ref: j.ID})
+		}
+		if !j.CompletedAt.IsZero() && j.CompletedAt.Before(before) {
+			entries = append(entries, TimelineEntry{Timestamp: j.CompletedAt, Kind: TimelineKindJob,
+				Summary: j.DiagnosticType + " " + j.Status, Ref: j.ID, Details: j.Results})
+		}
+	}
+
+	params, err := c.GetCwmpParametersByDeviceID(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range params {
+		if p.LastUpdate.Before(before) {
+			entries = append(entries, TimelineEntry{Timestamp: p.LastUpdate, Kind: TimelineKindParameter,
+				Summary: p.Path + " = " + p.Value, Ref: p.Path})
+		}
+	}
+
+	transfers, err := c.GetCwmpFileTransfers(bson.M{"device_id": deviceID, "created_at": olderThan}, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range transfers {
+		summary := fmt.Sprintf("%s %s", t.FileType, t.Status)
+		if t.FaultCode != "" {
+			summary += fmt.Sprintf(" (fault %s: %s)", t.FaultCode, t.FaultString)
+		}
+		entries = append(entries, TimelineEntry{Timestamp: t.CreatedAt, Kind: TimelineKindTransfer,
+			Summary: summary, Ref: t.ID, Details: map[string]string{"command_key": t.CommandKey, "url": t.URL}})
+	}
+
+	alarms, err := c.GetAlarms(bson.M{"device_id": deviceID, "raised_at": olderThan}, int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range alarms {
+		entries = append(entries, TimelineEntry{Timestamp: a.RaisedAt, Kind: TimelineKindAlarm,
+			Summary: a.Severity + ": " + a.Message, Ref: a.ID})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.After(entries[j].Timestamp)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CwmpTraceCollectionSize is the size of the capped collection holding SOAP
+// traces, the oldest traces are overwritten once it is full
+const CwmpTraceCollectionSize = 64 << 20
+
+// mongoNamespaceExists is the error code returned when creating an existing collection
+const mongoNamespaceExists = 48
+
+// CwmpTrace is a SOAP request/response pair exchanged with a traced device
+type CwmpTrace struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	DeviceID  string             `bson:"device_id" json:"device_id"`
+	SessionID string             `bson:"session_id" json:"session_id"`
+	Request   string             `bson:"request" json:"request"`
+	Response  string             `bson:"response" json:"response"`
+	Timestamp time.Time          `bson:"timestamp" json:"timestamp"`
+}
+
+// TraceActive reports whether SOAP tracing is enabled for the device
+func (d *CwmpDevice) TraceActive() bool {
+	return d.TraceUntil != nil && time.Now().Before(*d.TraceUntil)
+}
+
+// createCwmpTraceCollection creates the capped trace collection, which
+// lets the API tail new traces as the ACS stores them
+func (c *CwmpDb) createCwmpTraceCollection(ctx context.Context) error {
+	opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(CwmpTraceCollectionSize)
+	err := c.cwmpTraceColl.Database().CreateCollection(ctx, CwmpTraceCollection, opts)
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == mongoNamespaceExists) {
+		return err
+	}
+
+	index := mongo.IndexModel{Keys: bson.D{{Key: "device_id", Value: 1}, {Key: "_id", Value: 1}}}
+	_, err = c.cwmpTraceColl.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// SetCwmpDeviceTrace enables SOAP tracing of a device until the given time,
+// or disables it if until is nil
+func (c *CwmpDb) SetCwmpDeviceTrace(deviceID string, until *time.Time) error {
+	if c.cwmpDeviceColl == nil {
+		return errors.New("CWMP device collection not initialized")
+	}
+
+	update := bson.M{"$unset": bson.M{"trace_until": ""}}
+	if until != nil {
+		update = bson.M{"$set": bson.M{"trace_until": *until}}
+	}
+	res, err := c.cwmpDeviceColl.UpdateOne(context.Background(), bson.M{"_id": deviceID}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// InsertCwmpTrace stores a SOAP exchange of a traced device
+func (c *CwmpDb) InsertCwmpTrace(trace *CwmpTrace) error {
+	if c.cwmpTraceColl == nil {
+		return errors.New("CWMP trace collection not initialized")
+	}
+
+	trace.ID = primitive.NewObjectID()
+	trace.Timestamp = time.Now()
+	_, err := c.cwmpTraceColl.InsertOne(context.Background(), trace)
+	return err
+}
+
+// WatchCwmpTraces calls fn with each trace of a device stored from now on,
+// until ctx is done or fn returns an error
+func (c *CwmpDb) WatchCwmpTraces(ctx context.Context, deviceID string, fn func(*CwmpTrace) error) error {
+	if c.cwmpTraceColl == nil {
+		return errors.New("CWMP trace collection not initialized")
+	}
+
+	lastID := primitive.NewObjectIDFromTimestamp(time.Now())
+	opts := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(time.Second)
+	for {
+		filter := bson.M{"device_id": deviceID, "_id": bson.M{"$gt": lastID}}
+		cursor, err := c.cwmpTraceColl.Find(ctx, filter, opts)
+		if err != nil {
+			return err
+		}
+		for cursor.Next(ctx) {
+			var trace CwmpTrace
+			if err = cursor.Decode(&trace); err == nil {
+				err = fn(&trace)
+			}
+			if err != nil {
+				cursor.Close(context.Background())
+				return err
+			}
+			lastID = trace.ID
+		}
+		err = cursor.Err()
+		cursor.Close(context.Background())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+
+		// A tailable cursor is closed by the server when nothing matched yet
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// File transfer states. A transfer is pending until the device answers the
+// Download or Upload, in progress until it sends TransferComplete.
+const (
+	TransferStatusPending    = "pending"
+	TransferStatusInProgress = "in_progress"
+	TransferStatusCompleted  = "completed"
+	TransferStatusFailed     = "failed"
+)
+
+// States of a transfer in the queue of the device. A transfer the ACS
+// tracks as in progress but which the device no longer lists is missing.
+const (
+	CpeTransferNotStarted = "not_started"
+	CpeTransferInProgress = "in_progress"
+	CpeTransferCompleted  = "completed"
+	CpeTransferMissing    = "missing"
+)
+
+// TransferUpdate is a change of state of a file transfer, zero times and an
+// empty fault are left unchanged
+type TransferUpdate struct {
+	Status       string
+	StartTime    time.Time
+	CompleteTime time.Time
+	FaultCode    string
+	FaultString  string
+}
+
+// InsertCwmpFileTransfer stores a new file transfer, opening the firmware
+// job of firmware downloads
+func (c *CwmpDb) InsertCwmpFileTransfer(transfer *CwmpFileTransfer) error {
+	if c.cwmpFileColl == nil {
+		return errors.New("CWMP file transfer collection not initialized")
+	}
+
+	transfer.ID = primitive.NewObjectID().Hex()
+	transfer.CreatedAt = time.Now()
+	if transfer.Status == "" {
+		transfer.Status = TransferStatusPending
+	}
+	if _, err := c.cwmpFileColl.InsertOne(context.Background(), transfer); err != nil {
+		return err
+	}
+	if transfer.FileType == FileTypeFirmwareImage {
+		return c.openFirmwareJob(transfer)
+	}
+	return nil
+}
+
+// InsertCwmpAutonomousTransfer records a transfer a device ran on its own,
+// it is stored finished and opens no firmware job
+func (c *CwmpDb) InsertCwmpAutonomousTransfer(transfer *CwmpFileTransfer) error {
+	if c.cwmpFileColl == nil {
+		return errors.New("CWMP file transfer collection not initialized")
+	}
+
+	transfer.ID = primitive.NewObjectID().Hex()
+	transfer.CreatedAt = time.Now()
+	transfer.Autonomous = true
+	_, err := c.cwmpFileColl.InsertOne(context.Background(), transfer)
+	return err
+}
+
+// GetCwmpFileTransfer returns a file transfer by ID
+func (c *CwmpDb) GetCwmpFileTransfer(id string) (*CwmpFileTransfer, error) {
+	if c.cwmpFileColl == nil {
+		return nil, errors.New("CWMP file transfer collection not initialized")
+	}
+
+	var transfer CwmpFileTransfer
+	if err := c.cwmpFileColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&transfer); err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+// UpdateCwmpFileTransfer applies an update to the latest unfinished transfer
+// of a device with the given command key and returns the updated transfer,
+// advancing its firmware job. It returns mongo.ErrNoDocuments if there is no
+// such transfer.
+func (c *CwmpDb) UpdateCwmpFileTransfer(deviceID string, commandKey string, update TransferUpdate) (*CwmpFileTransfer, error) {
+	if c.cwmpFileColl == nil {
+		return nil, errors.New("CWMP file transfer collection not initialized")
+	}
+
+	set := bson.M{"status": update.Status}
+	if !update.StartTime.IsZero() {
+		set["start_time"] = update.StartTime
+	}
+	if !update.CompleteTime.IsZero() {
+		set["complete_time"] = update.CompleteTime
+	}
+	if update.FaultCode != "" {
+		set["fault_code"] = update.FaultCode
+		set["fault_string"] = update.FaultString
+	}
+	filter := bson.M{
+		"device_id":   deviceID,
+		"command_key": commandKey,
+		"status":      bson.M{"$in": []string{TransferStatusPending, TransferStatusInProgress}},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetReturnDocument(options.After)
+
+	var transfer CwmpFileTransfer
+	err := c.cwmpFileColl.FindOneAndUpdate(context.Background(), filter, bson.M{"$set": set}, opts).Decode(&transfer)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.FileType == FileTypeFirmwareImage {
+		if err := c.advanceFirmwareJob(&transfer); err != nil {
+			return &transfer, err
+		}
+	}
+	return &transfer, nil
+}
+
+// GetUnfinishedCwmpFileTransfers returns the pending and in progress
+// transfers of a device, newest first
+func (c *CwmpDb) GetUnfinishedCwmpFileTransfers(deviceID string) ([]CwmpFileTransfer, error) {
+	filter := bson.M{
+		"device_id": deviceID,
+		"status":    bson.M{"$in": []string{TransferStatusPending, TransferStatusInProgress}},
+	}
+	return c.GetCwmpFileTransfers(filter, 0)
+}
+
+// SetCwmpFileTransferCpeState records the state of a transfer reported by
+// the device
+func (c *CwmpDb) SetCwmpFileTransferCpeState(id string, state string) error {
+	if c.cwmpFileColl == nil {
+		return errors.New("CWMP file transfer collection not initialized")
+	}
+
+	update := bson.M{"$set": bson.M{"cpe_state": state, "checked_at": time.Now()}}
+	_, err := c.cwmpFileColl.UpdateOne(context.Background(), bson.M{"_id": id}, update)
+	return err
+}
+
+// GetCwmpFileTransfers returns the file transfers matching filter, newest first
+func (c *CwmpDb) GetCwmpFileTransfers(filter bson.M, limit int64) ([]CwmpFileTransfer, error) {
+	if c.cwmpFileColl == nil {
+		return nil, errors.New("CWMP file transfer collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.cwmpFileColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	transfers := []CwmpFileTransfer{}
+	if err = cursor.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
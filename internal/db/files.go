@@ -0,0 +1,152 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileBucket is the GridFS bucket of the files served to devices
+const FileBucket = "files"
+
+// FileStore stores the firmware images and configuration files served to
+// devices. CwmpDb implements it with GridFS, other blob stores can be
+// plugged into the ACS file server.
+type FileStore interface {
+	PutFile(name string, contentType string, content io.Reader) (*StoredFile, error)
+	GetFile(id string) (*StoredFile, error)
+	OpenFile(id string) (io.ReadCloser, *StoredFile, error)
+	GetFiles() ([]StoredFile, error)
+	DeleteFile(id string) error
+}
+
+// FileMetadata is the metadata stored with a file
+type FileMetadata struct {
+	ContentType string `bson:"content_type" json:"content_type"`
+	SHA256      string `bson:"sha256,omitempty" json:"sha256,omitempty"`
+}
+
+// StoredFile describes a file of the file store
+type StoredFile struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	Name         string             `bson:"filename" json:"name"`
+	Length       int64              `bson:"length" json:"length"`
+	UploadDate   time.Time          `bson:"uploadDate" json:"upload_date"`
+	FileMetadata `bson:"metadata"`
+}
+
+// PutFile stores a file in GridFS along with its SHA-256 digest
+func (c *CwmpDb) PutFile(name string, contentType string, content io.Reader) (*StoredFile, error) {
+	if c.fileBucket == nil {
+		return nil, errors.New("File bucket not initialized")
+	}
+
+	digest := sha256.New()
+	opts := options.GridFSUpload().SetMetadata(FileMetadata{ContentType: contentType})
+	id, err := c.fileBucket.UploadFromStream(name, io.TeeReader(content, digest), opts)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{"metadata.sha256": hex.EncodeToString(digest.Sum(nil))}}
+	if _, err := c.fileBucket.GetFilesCollection().UpdateOne(context.Background(), bson.M{"_id": id}, update); err != nil {
+		return nil, err
+	}
+	return c.GetFile(id.Hex())
+}
+
+// GetFile returns the description of a file, mongo.ErrNoDocuments if there
+// is no such file
+func (c *CwmpDb) GetFile(id string) (*StoredFile, error) {
+	if c.fileBucket == nil {
+		return nil, errors.New("File bucket not initialized")
+	}
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var file StoredFile
+	if err := c.fileBucket.GetFilesCollection().FindOne(context.Background(), bson.M{"_id": oid}).Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// OpenFile opens the content of a file for reading
+func (c *CwmpDb) OpenFile(id string) (io.ReadCloser, *StoredFile, error) {
+	file, err := c.GetFile(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream, err := c.fileBucket.OpenDownloadStream(file.ID)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, nil, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return stream, file, nil
+}
+
+// GetFiles returns all files ordered by name
+func (c *CwmpDb) GetFiles() ([]StoredFile, error) {
+	if c.fileBucket == nil {
+		return nil, errors.New("File bucket not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "filename", Value: 1}, {Key: "uploadDate", Value: -1}})
+	cursor, err := c.fileBucket.GetFilesCollection().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	files := []StoredFile{}
+	if err = cursor.All(ctx, &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// DeleteFile removes a file and its content
+func (c *CwmpDb) DeleteFile(id string) error {
+	if c.fileBucket == nil {
+		return errors.New("File bucket not initialized")
+	}
+
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+	if err := c.fileBucket.Delete(oid); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return mongo.ErrNoDocuments
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrFirmwareIncompatible is returned when a firmware version is not allowed
+// on a device by the compatibility matrix
+var ErrFirmwareIncompatible = errors.New("firmware is not compatible with device")
+
+// FirmwareCompatRule lists the firmware versions allowed on a device model
+// and hardware version. An empty hardware version applies to all hardware
+// versions of the model without a more specific rule.
+type FirmwareCompatRule struct {
+	ID              string    `bson:"_id" json:"id"`
+	Manufacturer    string    `bson:"manufacturer" json:"manufacturer"`
+	ModelName       string    `bson:"model_name" json:"model_name"`
+	HardwareVersion string    `bson:"hardware_version" json:"hardware_version"`
+	AllowedVersions []string  `bson:"allowed_versions" json:"allowed_versions"`
+	Notes           string    `bson:"notes,omitempty" json:"notes,omitempty"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// FirmwareCompatRuleID returns the ID of the rule for a model and hardware version
+func FirmwareCompatRuleID(manufacturer, modelName, hardwareVersion string) string {
+	return strings.Join([]string{manufacturer, modelName, hardwareVersion}, ":")
+}
+
+// Allows reports whether version matches one of the allowed versions, which
+// may be shell patterns such as "3.2.*"
+func (r *FirmwareCompatRule) Allows(version string) bool {
+	for _, allowed := range r.AllowedVersions {
+		if ok, _ := path.Match(allowed, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// UpsertFirmwareCompatRule creates or replaces a compatibility rule
+func (c *CwmpDb) UpsertFirmwareCompatRule(rule *FirmwareCompatRule) error {
+	if c.firmwareCompatColl == nil {
+		return errors.New("Firmware compatibility collection not initialized")
+	}
+
+	rule.ID = FirmwareCompatRuleID(rule.Manufacturer, rule.ModelName, rule.HardwareVersion)
+	rule.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.firmwareCompatColl.ReplaceOne(context.Background(), bson.M{"_id": rule.ID}, rule, opts)
+	return err
+}
+
+// GetFirmwareCompatRules returns the compatibility rules matching filter
+func (c *CwmpDb) GetFirmwareCompatRules(filter bson.M) ([]FirmwareCompatRule, error) {
+	if c.firmwareCompatColl == nil {
+		return nil, errors.New("Firmware compatibility collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := c.firmwareCompatColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	rules := []FirmwareCompatRule{}
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// DeleteFirmwareCompatRule removes a compatibility rule
+func (c *CwmpDb) DeleteFirmwareCompatRule(id string) error {
+	if c.firmwareCompatColl == nil {
+		return errors.New("Firmware compatibility collection not initialized")
+	}
+
+	res, err := c.firmwareCompatColl.DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// CheckFirmwareCompat verifies that version may be installed on device. Models
+// without a rule in the matrix are not restricted.
+func (c *CwmpDb) CheckFirmwareCompat(device *CwmpDevice, version string) error {
+	filter := bson.M{
+		"manufacturer":     device.Manufacturer,
+		"model_name":       device.ModelName,
+		"hardware_version": bson.M{"$in": []string{device.HardwareVersion, ""}},
+	}
+	rules, err := c.GetFirmwareCompatRules(filter)
+	if err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+
+	// A rule for the device's hardware version takes precedence over the
+	// model wide rule
+	rule := &rules[0]
+	for i := range rules {
+		if rules[i].HardwareVersion != "" {
+			rule = &rules[i]
+		}
+	}
+	if version == "" {
+		return fmt.Errorf("%w: firmware version is required for %s %s", ErrFirmwareIncompatible,
+			device.Manufacturer, device.ModelName)
+	}
+	if !rule.Allows(version) {
+		return fmt.Errorf("%w: %s is not allowed on %s %s hardware %s (allowed: %s)", ErrFirmwareIncompatible,
+			version, device.Manufacturer, device.ModelName, device.HardwareVersion,
+			strings.Join(rule.AllowedVersions, ", "))
+	}
+	return nil
+}
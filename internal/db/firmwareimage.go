@@ -0,0 +1,114 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrFirmwareImageExists is returned when the catalog already has an image
+// of the same vendor, product class and version
+var ErrFirmwareImageExists = errors.New("firmware image already registered")
+
+// FirmwareImage is an entry of the firmware catalog. The image is either
+// stored in the file store or downloaded from an external URL.
+type FirmwareImage struct {
+	ID           string    `bson:"_id" json:"id"`
+	Vendor       string    `bson:"vendor" json:"vendor"`
+	ProductClass string    `bson:"product_class" json:"product_class"`
+	Version      string    `bson:"version" json:"version"`
+	URL          string    `bson:"url,omitempty" json:"url,omitempty"`
+	FileID       string    `bson:"file_id,omitempty" json:"file_id,omitempty"`
+	FileName     string    `bson:"file_name,omitempty" json:"file_name,omitempty"`
+	FileSize     int64     `bson:"file_size,omitempty" json:"file_size,omitempty"`
+	SHA256       string    `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	ReleaseNotes string    `bson:"release_notes,omitempty" json:"release_notes,omitempty"`
+	CreatedBy    string    `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt    time.Time `bson:"created_at" json:"created_at"`
+}
+
+// InsertFirmwareImage adds an image to the firmware catalog
+func (c *CwmpDb) InsertFirmwareImage(image *FirmwareImage) error {
+	if c.firmwareImageColl == nil {
+		return errors.New("Firmware image collection not initialized")
+	}
+
+	image.ID = primitive.NewObjectID().Hex()
+	image.CreatedAt = time.Now()
+	_, err := c.firmwareImageColl.InsertOne(context.Background(), image)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrFirmwareImageExists
+	}
+	return err
+}
+
+// GetFirmwareImage returns an image of the firmware catalog
+func (c *CwmpDb) GetFirmwareImage(id string) (*FirmwareImage, error) {
+	if c.firmwareImageColl == nil {
+		return nil, errors.New("Firmware image collection not initialized")
+	}
+
+	var image FirmwareImage
+	if err := c.firmwareImageColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+// GetFirmwareImages returns the images of the firmware catalog matching
+// filter, newest first
+func (c *CwmpDb) GetFirmwareImages(filter bson.M, limit int64) ([]FirmwareImage, error) {
+	if c.firmwareImageColl == nil {
+		return nil, errors.New("Firmware image collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.firmwareImageColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	images := []FirmwareImage{}
+	if err = cursor.All(ctx, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// DeleteFirmwareImage removes an image from the firmware catalog and returns
+// it, its stored file is left to the caller
+func (c *CwmpDb) DeleteFirmwareImage(id string) (*FirmwareImage, error) {
+	if c.firmwareImageColl == nil {
+		return nil, errors.New("Firmware image collection not initialized")
+	}
+
+	var image FirmwareImage
+	if err := c.firmwareImageColl.FindOneAndDelete(context.Background(), bson.M{"_id": id}).Decode(&image); err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
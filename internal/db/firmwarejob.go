@@ -0,0 +1,236 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// FileTypeFirmwareImage is the file type of firmware downloads, each of
+// them is tracked by a firmware job
+const FileTypeFirmwareImage = "1 Firmware Upgrade Image"
+
+// Firmware job states. A job is issued with its Download, accepted when the
+// device will report the transfer with TransferComplete and transferred once
+// the image is installed. The version the device reports when it boots next
+// then verifies it or leaves it in version_mismatch.
+const (
+	FirmwareJobIssued      = "issued"
+	FirmwareJobAccepted    = "accepted"
+	FirmwareJobTransferred = "transferred"
+	FirmwareJobVerified    = "verified"
+	FirmwareJobMismatch    = "version_mismatch"
+	FirmwareJobFailed      = "failed"
+)
+
+// FirmwareJobTransition is a change of state of a firmware job
+type FirmwareJobTransition struct {
+	Status string    `bson:"status" json:"status"`
+	Detail string    `bson:"detail,omitempty" json:"detail,omitempty"`
+	At     time.Time `bson:"at" json:"at"`
+}
+
+// FirmwareJob tracks the upgrade of a device from the Download to the
+// verification of the version it runs afterwards
+type FirmwareJob struct {
+	ID              string                  `bson:"_id" json:"id"`
+	DeviceID        string                  `bson:"device_id" json:"device_id"`
+	TransferID      string                  `bson:"transfer_id" json:"transfer_id"`
+	CommandKey      string                  `bson:"command_key" json:"command_key"`
+	URL             string                  `bson:"url" json:"url"`
+	FromVersion     string                  `bson:"from_version,omitempty" json:"from_version,omitempty"`
+	TargetVersion   string                  `bson:"target_version,omitempty" json:"target_version,omitempty"`
+	ReportedVersion string                  `bson:"reported_version,omitempty" json:"reported_version,omitempty"`
+	Status          string                  `bson:"status" json:"status"`
+	FaultCode       string                  `bson:"fault_code,omitempty" json:"fault_code,omitempty"`
+	FaultString     string                  `bson:"fault_string,omitempty" json:"fault_string,omitempty"`
+	Transitions     []FirmwareJobTransition `bson:"transitions" json:"transitions"`
+	CreatedAt       time.Time               `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time               `bson:"updated_at" json:"updated_at"`
+	CompletedAt     *time.Time              `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+// activeFirmwareJobStates are the states of jobs which are not finished
+var activeFirmwareJobStates = []string{FirmwareJobIssued, FirmwareJobAccepted, FirmwareJobTransferred}
+
+// transition moves the job to a new state
+func (j *FirmwareJob) transition(status string, detail string) {
+	now := time.Now()
+	j.Status = status
+	j.UpdatedAt = now
+	j.Transitions = append(j.Transitions, FirmwareJobTransition{Status: status, Detail: detail, At: now})
+	switch status {
+	case FirmwareJobVerified, FirmwareJobMismatch, FirmwareJobFailed:
+		j.CompletedAt = &now
+	}
+}
+
+// verify checks the reported version against the target version, or that
+// the version changed if the job has no target version
+func (j *FirmwareJob) verify() {
+	ok := j.ReportedVersion != j.FromVersion
+	if j.TargetVersion != "" {
+		ok = j.ReportedVersion == j.TargetVersion
+	}
+	if ok {
+		j.transition(FirmwareJobVerified, "device runs "+j.ReportedVersion)
+	} else {
+		j.transition(FirmwareJobMismatch, "device runs "+j.ReportedVersion)
+	}
+}
+
+// openFirmwareJob starts tracking the firmware upgrade of a transfer
+func (c *CwmpDb) openFirmwareJob(transfer *CwmpFileTransfer) error {
+	if c.firmwareJobColl == nil {
+		return errors.New("Firmware job collection not initialized")
+	}
+
+	job := &FirmwareJob{
+		ID:            primitive.NewObjectID().Hex(),
+		DeviceID:      transfer.DeviceID,
+		TransferID:    transfer.ID,
+		CommandKey:    transfer.CommandKey,
+		URL:           transfer.URL,
+		TargetVersion: transfer.FirmwareVersion,
+		CreatedAt:     transfer.CreatedAt,
+	}
+	if device, err := c.GetCwmpDeviceByID(transfer.DeviceID); err == nil {
+		job.FromVersion = device.SoftwareVersion
+	}
+	job.transition(FirmwareJobIssued, "Download "+transfer.CommandKey)
+	_, err := c.firmwareJobColl.InsertOne(context.Background(), job)
+	return err
+}
+
+// advanceFirmwareJob moves the job of a firmware transfer along with the
+// state of the transfer
+func (c *CwmpDb) advanceFirmwareJob(transfer *CwmpFileTransfer) error {
+	if c.firmwareJobColl == nil {
+		return errors.New("Firmware job collection not initialized")
+	}
+
+	filter := bson.M{"transfer_id": transfer.ID, "status": bson.M{"$in": activeFirmwareJobStates}}
+	var job FirmwareJob
+	err := c.firmwareJobColl.FindOne(context.Background(), filter).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch transfer.Status {
+	case TransferStatusInProgress:
+		if job.Status != FirmwareJobIssued {
+			return nil
+		}
+		job.transition(FirmwareJobAccepted, "download in progress")
+	case TransferStatusCompleted:
+		if job.Status == FirmwareJobTransferred {
+			return nil
+		}
+		job.transition(FirmwareJobTransferred, "transfer completed")
+		// The device may have reported its new version in the Inform of the
+		// session it sends TransferComplete in
+		if job.ReportedVersion != "" {
+			job.verify()
+		}
+	case TransferStatusFailed:
+		job.FaultCode = transfer.FaultCode
+		job.FaultString = transfer.FaultString
+		job.transition(FirmwareJobFailed, transfer.FaultString)
+	default:
+		return nil
+	}
+	return c.saveFirmwareJob(&job)
+}
+
+// RecordFirmwareVersion records the software version a device reported when
+// it booted in its accepted and transferred firmware jobs, verifying the
+// transferred ones. It returns the jobs which were verified.
+func (c *CwmpDb) RecordFirmwareVersion(deviceID string, version string) ([]FirmwareJob, error) {
+	filter := bson.M{
+		"device_id": deviceID,
+		"status":    bson.M{"$in": []string{FirmwareJobAccepted, FirmwareJobTransferred}},
+	}
+	jobs, err := c.GetFirmwareJobs(filter, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	finished := []FirmwareJob{}
+	for i := range jobs {
+		job := &jobs[i]
+		job.ReportedVersion = version
+		job.UpdatedAt = time.Now()
+		if job.Status == FirmwareJobTransferred {
+			job.verify()
+			finished = append(finished, *job)
+		}
+		if err := c.saveFirmwareJob(job); err != nil {
+			return finished, err
+		}
+	}
+	return finished, nil
+}
+
+func (c *CwmpDb) saveFirmwareJob(job *FirmwareJob) error {
+	_, err := c.firmwareJobColl.ReplaceOne(context.Background(), bson.M{"_id": job.ID}, job)
+	return err
+}
+
+// GetFirmwareJob returns a firmware job by ID
+func (c *CwmpDb) GetFirmwareJob(id string) (*FirmwareJob, error) {
+	if c.firmwareJobColl == nil {
+		return nil, errors.New("Firmware job collection not initialized")
+	}
+
+	var job FirmwareJob
+	if err := c.firmwareJobColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetFirmwareJobs returns the firmware jobs matching filter, newest first
+func (c *CwmpDb) GetFirmwareJobs(filter bson.M, limit int64) ([]FirmwareJob, error) {
+	if c.firmwareJobColl == nil {
+		return nil, errors.New("Firmware job collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.firmwareJobColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []FirmwareJob{}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeviceGroup is a named set of devices, either the devices listed in
+// DeviceIDs or those matching the device search Filter. The groups of a
+// device are kept in its groups field by SyncDeviceGroupMembers, so that
+// the ACS can match them without evaluating the filters.
+type DeviceGroup struct {
+	Name        string     `bson:"_id" json:"name"`
+	Description string     `bson:"description,omitempty" json:"description,omitempty"`
+	DeviceIDs   []string   `bson:"device_ids,omitempty" json:"device_ids,omitempty"`
+	Filter      bson.M     `bson:"filter,omitempty" json:"filter,omitempty"`
+	MemberCount int64      `bson:"member_count" json:"member_count"`
+	CreatedAt   time.Time  `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time  `bson:"updated_at" json:"updated_at"`
+	SyncedAt    *time.Time `bson:"synced_at,omitempty" json:"synced_at,omitempty"`
+}
+
+// UpsertDeviceGroup creates or replaces a group, keeping its creation time
+// and member count
+func (c *CwmpDb) UpsertDeviceGroup(group *DeviceGroup) error {
+	if c.groupColl == nil {
+		return errors.New("Device group collection not initialized")
+	}
+
+	now := time.Now()
+	group.UpdatedAt = now
+	set := bson.M{
+		"description": group.Description,
+		"device_ids":  group.DeviceIDs,
+		"filter":      group.Filter,
+		"updated_at":  now,
+	}
+	update := bson.M{"$set": set, "$setOnInsert": bson.M{"created_at": now, "member_count": 0}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	return c.groupColl.FindOneAndUpdate(context.Background(), bson.M{"_id": group.Name}, update, opts).Decode(group)
+}
+
+// GetDeviceGroup returns a group by name
+func (c *CwmpDb) GetDeviceGroup(name string) (*DeviceGroup, error) {
+	if c.groupColl == nil {
+		return nil, errors.New("Device group collection not initialized")
+	}
+
+	var group DeviceGroup
+	if err := c.groupColl.FindOne(context.Background(), bson.M{"_id": name}).Decode(&group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// GetDeviceGroups returns all groups by name
+func (c *CwmpDb) GetDeviceGroups() ([]DeviceGroup, error) {
+	if c.groupColl == nil {
+		return nil, errors.New("Device group collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := c.groupColl.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []DeviceGroup{}
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// DeleteDeviceGroup removes a group and drops it from its members
+func (c *CwmpDb) DeleteDeviceGroup(name string) error {
+	if c.groupColl == nil || c.cwmpDeviceColl == nil {
+		return errors.New("Device group collection not initialized")
+	}
+
+	ctx := context.Background()
+	res, err := c.groupColl.DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = c.cwmpDeviceColl.UpdateMany(ctx, bson.M{"groups": name}, bson.M{"$pull": bson.M{"groups": name}})
+	return err
+}
+
+// SyncDeviceGroupMembers makes the devices matching filter, and only them,
+// carry the group in their groups field, and stores the member count
+func (c *CwmpDb) SyncDeviceGroupMembers(name string, filter bson.M) (int64, error) {
+	if c.groupColl == nil || c.cwmpDeviceColl == nil {
+		return 0, errors.New("Device group collection not initialized")
+	}
+
+	ctx := context.Background()
+	joined := bson.M{"$and": bson.A{filter, bson.M{"groups": bson.M{"$ne": name}}}}
+	if _, err := c.cwmpDeviceColl.UpdateMany(ctx, joined, bson.M{"$addToSet": bson.M{"groups": name}}); err != nil {
+		return 0, err
+	}
+	left := bson.M{"groups": name, "$nor": bson.A{filter}}
+	if _, err := c.cwmpDeviceColl.UpdateMany(ctx, left, bson.M{"$pull": bson.M{"groups": name}}); err != nil {
+		return 0, err
+	}
+
+	count, err := c.cwmpDeviceColl.CountDocuments(ctx, bson.M{"groups": name})
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	update := bson.M{"$set": bson.M{"member_count": count, "synced_at": now}}
+	_, err = c.groupColl.UpdateOne(ctx, bson.M{"_id": name}, update)
+	return count, err
+}
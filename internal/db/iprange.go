@@ -0,0 +1,66 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// IPKey converts an IP address into a fixed length hex string of its 16 byte
+// form. IPv4 addresses are stored IPv4-mapped, so keys of both families
+// compare in address order and can be range queried on an index.
+func IPKey(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	return hex.EncodeToString(parsed.To16())
+}
+
+// IPRangeFilter returns a filter on ip_key matching addresses in [from, to]
+func IPRangeFilter(from string, to string) (bson.M, error) {
+	fromKey, toKey := IPKey(from), IPKey(to)
+	if fromKey == "" || toKey == "" {
+		return nil, errors.New("invalid IP address range: " + from + "-" + to)
+	}
+	if fromKey > toKey {
+		return nil, errors.New("IP range start is after its end: " + from + "-" + to)
+	}
+	return bson.M{"$gte": fromKey, "$lte": toKey}, nil
+}
+
+// IPCidrFilter returns a filter on ip_key matching addresses in the subnet
+func IPCidrFilter(cidr string) (bson.M, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	first := ipNet.IP.To16()
+	last := make(net.IP, net.IPv6len)
+	mask := ipNet.Mask
+	if len(mask) == net.IPv4len {
+		// Align IPv4 mask with the IPv4-mapped form
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	for i := range first {
+		last[i] = first[i] | ^mask[i]
+	}
+	return IPRangeFilter(first.String(), last.String())
+}
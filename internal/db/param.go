@@ -121,6 +121,20 @@ func (u *UspDb) GetParamObjByValue(epId string, path string, name string, value
 	return params, nil
 }
 
+// GetParamsByPaths returns the params of all the endpoints at one of paths
+func (u *UspDb) GetParamsByPaths(paths []string) ([]*Param, error) {
+	filter := bson.M{"path": bson.M{"$in": paths}}
+	cur, err := u.paramColl.Find(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	var params []*Param
+	if err := cur.All(context.Background(), &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
 func (u *UspDb) GetAllEndpoints() ([]string, error) {
 
 	filter := bson.D{}
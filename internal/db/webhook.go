@@ -0,0 +1,226 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// States of a webhook delivery. A delivery is pending until the endpoint
+// accepts it, and dead once it ran out of attempts.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryDead      = "dead"
+)
+
+// Webhook is an endpoint notified of the events matching its filter. The
+// secret signs the deliveries and is never returned by the API.
+type Webhook struct {
+	ID        string    `bson:"_id" json:"id"`
+	URL       string    `bson:"url" json:"url"`
+	Secret    string    `bson:"secret" json:"-"`
+	Types     []string  `bson:"types,omitempty" json:"types,omitempty"`
+	DeviceIDs []string  `bson:"device_ids,omitempty" json:"device_ids,omitempty"`
+	Enabled   bool      `bson:"enabled" json:"enabled"`
+	CreatedAt time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
+}
+
+// WebhookDelivery is an event sent, or to be sent, to a webhook
+type WebhookDelivery struct {
+	ID        string `bson:"_id" json:"id"`
+	WebhookID string `bson:"webhook_id" json:"webhook_id"`
+	EventID   string `bson:"event_id" json:"event_id"`
+	EventType string `bson:"event_type" json:"event_type"`
+	DeviceID  string `bson:"device_id,omitempty" json:"device_id,omitempty"`
+	// Payload is the JSON body posted to the webhook
+	Payload       string     `bson:"payload" json:"payload"`
+	Status        string     `bson:"status" json:"status"`
+	Attempts      int        `bson:"attempts" json:"attempts"`
+	LastStatus    int        `bson:"last_status,omitempty" json:"last_status,omitempty"`
+	LastError     string     `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time  `bson:"created_at" json:"created_at"`
+	DeliveredAt   *time.Time `bson:"delivered_at,omitempty" json:"delivered_at,omitempty"`
+}
+
+// InsertWebhook stores a new webhook
+func (c *CwmpDb) InsertWebhook(hook *Webhook) error {
+	if c.webhookColl == nil {
+		return errors.New("Webhook collection not initialized")
+	}
+
+	hook.ID = primitive.NewObjectID().Hex()
+	hook.CreatedAt = time.Now()
+	hook.UpdatedAt = hook.CreatedAt
+	_, err := c.webhookColl.InsertOne(context.Background(), hook)
+	return err
+}
+
+// UpdateWebhook replaces a webhook
+func (c *CwmpDb) UpdateWebhook(hook *Webhook) error {
+	if c.webhookColl == nil {
+		return errors.New("Webhook collection not initialized")
+	}
+
+	hook.UpdatedAt = time.Now()
+	res, err := c.webhookColl.ReplaceOne(context.Background(), bson.M{"_id": hook.ID}, hook)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetWebhook returns a webhook by ID
+func (c *CwmpDb) GetWebhook(id string) (*Webhook, error) {
+	if c.webhookColl == nil {
+		return nil, errors.New("Webhook collection not initialized")
+	}
+
+	var hook Webhook
+	if err := c.webhookColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&hook); err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// GetWebhooks returns the webhooks matching filter, oldest first
+func (c *CwmpDb) GetWebhooks(filter bson.M) ([]Webhook, error) {
+	if c.webhookColl == nil {
+		return nil, errors.New("Webhook collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}})
+	cursor, err := c.webhookColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	hooks := []Webhook{}
+	if err = cursor.All(ctx, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// DeleteWebhook removes a webhook and its deliveries
+func (c *CwmpDb) DeleteWebhook(id string) error {
+	if c.webhookColl == nil || c.webhookDeliveryColl == nil {
+		return errors.New("Webhook collection not initialized")
+	}
+
+	ctx := context.Background()
+	res, err := c.webhookColl.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	_, err = c.webhookDeliveryColl.DeleteMany(ctx, bson.M{"webhook_id": id})
+	return err
+}
+
+// InsertWebhookDelivery stores a pending delivery
+func (c *CwmpDb) InsertWebhookDelivery(d *WebhookDelivery) error {
+	if c.webhookDeliveryColl == nil {
+		return errors.New("Webhook delivery collection not initialized")
+	}
+
+	d.ID = primitive.NewObjectID().Hex()
+	d.CreatedAt = time.Now()
+	d.Status = WebhookDeliveryPending
+	_, err := c.webhookDeliveryColl.InsertOne(context.Background(), d)
+	return err
+}
+
+// UpdateWebhookDelivery stores the outcome of an attempt of a delivery
+func (c *CwmpDb) UpdateWebhookDelivery(d *WebhookDelivery) error {
+	if c.webhookDeliveryColl == nil {
+		return errors.New("Webhook delivery collection not initialized")
+	}
+
+	_, err := c.webhookDeliveryColl.ReplaceOne(context.Background(), bson.M{"_id": d.ID}, d)
+	return err
+}
+
+// GetWebhookDelivery returns a delivery by ID
+func (c *CwmpDb) GetWebhookDelivery(id string) (*WebhookDelivery, error) {
+	if c.webhookDeliveryColl == nil {
+		return nil, errors.New("Webhook delivery collection not initialized")
+	}
+
+	var d WebhookDelivery
+	if err := c.webhookDeliveryColl.FindOne(context.Background(), bson.M{"_id": id}).Decode(&d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// GetWebhookDeliveries returns the deliveries matching filter, newest first
+func (c *CwmpDb) GetWebhookDeliveries(filter bson.M, limit int64) ([]WebhookDelivery, error) {
+	if c.webhookDeliveryColl == nil {
+		return nil, errors.New("Webhook delivery collection not initialized")
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return c.findWebhookDeliveries(filter, opts)
+}
+
+// GetDueWebhookDeliveries returns the pending deliveries whose next attempt
+// is due, oldest first
+func (c *CwmpDb) GetDueWebhookDeliveries(limit int64) ([]WebhookDelivery, error) {
+	if c.webhookDeliveryColl == nil {
+		return nil, errors.New("Webhook delivery collection not initialized")
+	}
+
+	filter := bson.M{"status": WebhookDeliveryPending, "next_attempt_at": bson.M{"$lte": time.Now()}}
+	opts := options.Find().SetSort(bson.D{{Key: "next_attempt_at", Value: 1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	return c.findWebhookDeliveries(filter, opts)
+}
+
+func (c *CwmpDb) findWebhookDeliveries(filter bson.M, opts *options.FindOptions) ([]WebhookDelivery, error) {
+	ctx := context.Background()
+	cursor, err := c.webhookDeliveryColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	deliveries := []WebhookDelivery{}
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,232 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package db
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Protocols a zero-touch provisioning template applies to, an empty
+// protocol applies to both
+const (
+	ZtpProtocolCwmp = "cwmp"
+	ZtpProtocolUsp  = "usp"
+)
+
+// ZtpFileTypeFirmware is the default file type of template downloads
+const ZtpFileTypeFirmware = FileTypeFirmwareImage
+
+// ZtpDownload is a file a template makes the device download
+type ZtpDownload struct {
+	FileType       string `bson:"file_type" json:"file_type"`
+	URL            string `bson:"url" json:"url"`
+	Username       string `bson:"username,omitempty" json:"username,omitempty"`
+	Password       string `bson:"password,omitempty" json:"password,omitempty"`
+	FileSize       int64  `bson:"file_size,omitempty" json:"file_size,omitempty"`
+	TargetFileName string `bson:"target_file_name,omitempty" json:"target_file_name,omitempty"`
+	DelaySeconds   int    `bson:"delay_seconds,omitempty" json:"delay_seconds,omitempty"`
+}
+
+// ZtpTemplate describes the parameters and downloads applied to the devices
+// it matches when they first appear. Empty match fields match any device,
+// the serial number range is inclusive and may be open on either side.
+type ZtpTemplate struct {
+	Name         string             `bson:"_id" json:"name"`
+	Description  string             `bson:"description,omitempty" json:"description,omitempty"`
+	Protocol     string             `bson:"protocol,omitempty" json:"protocol,omitempty"`
+	OUI          string             `bson:"oui,omitempty" json:"oui,omitempty"`
+	ProductClass string             `bson:"product_class,omitempty" json:"product_class,omitempty"`
+	SerialFrom   string             `bson:"serial_from,omitempty" json:"serial_from,omitempty"`
+	SerialTo     string             `bson:"serial_to,omitempty" json:"serial_to,omitempty"`
+	Priority     int                `bson:"priority" json:"priority"`
+	Parameters   []ProfileParameter `bson:"parameters" json:"parameters"`
+	Downloads    []ZtpDownload      `bson:"downloads" json:"downloads"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// ZtpDevice records that a device was seen by zero-touch provisioning and
+// the template applied to it, if any matched
+type ZtpDevice struct {
+	ID        string    `bson:"_id" json:"-"`
+	DeviceID  string    `bson:"device_id" json:"device_id"`
+	Protocol  string    `bson:"protocol" json:"protocol"`
+	Template  string    `bson:"template,omitempty" json:"template,omitempty"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+// CompareSerials orders serial numbers, the shorter first so that numeric
+// serials compare as numbers
+func CompareSerials(a, b string) int {
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Matches reports whether the template applies to a device
+func (t *ZtpTemplate) Matches(protocol, oui, productClass, serialNumber string) bool {
+	if t.Protocol != "" && t.Protocol != protocol {
+		return false
+	}
+	if t.OUI != "" && !strings.EqualFold(t.OUI, oui) {
+		return false
+	}
+	if t.ProductClass != "" && t.ProductClass != productClass {
+		return false
+	}
+	if t.SerialFrom != "" && CompareSerials(serialNumber, t.SerialFrom) < 0 {
+		return false
+	}
+	if t.SerialTo != "" && CompareSerials(serialNumber, t.SerialTo) > 0 {
+		return false
+	}
+	return true
+}
+
+// UpsertZtpTemplate creates or replaces a template
+func (c *CwmpDb) UpsertZtpTemplate(template *ZtpTemplate) error {
+	if c.ztpTemplateColl == nil {
+		return errors.New("ZTP template collection not initialized")
+	}
+
+	template.UpdatedAt = time.Now()
+	opts := options.Replace().SetUpsert(true)
+	_, err := c.ztpTemplateColl.ReplaceOne(context.Background(), bson.M{"_id": template.Name}, template, opts)
+	return err
+}
+
+// GetZtpTemplate returns a template by name
+func (c *CwmpDb) GetZtpTemplate(name string) (*ZtpTemplate, error) {
+	if c.ztpTemplateColl == nil {
+		return nil, errors.New("ZTP template collection not initialized")
+	}
+
+	var template ZtpTemplate
+	if err := c.ztpTemplateColl.FindOne(context.Background(), bson.M{"_id": name}).Decode(&template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetZtpTemplates returns the templates matching filter ordered by name
+func (c *CwmpDb) GetZtpTemplates(filter bson.M) ([]ZtpTemplate, error) {
+	if c.ztpTemplateColl == nil {
+		return nil, errors.New("ZTP template collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := c.ztpTemplateColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := []ZtpTemplate{}
+	if err = cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// DeleteZtpTemplate removes a template
+func (c *CwmpDb) DeleteZtpTemplate(name string) error {
+	if c.ztpTemplateColl == nil {
+		return errors.New("ZTP template collection not initialized")
+	}
+
+	res, err := c.ztpTemplateColl.DeleteOne(context.Background(), bson.M{"_id": name})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// MatchZtpTemplate returns the template applying to a device: the matching
+// template with the highest priority, the first by name on a tie. It
+// returns mongo.ErrNoDocuments if none matches.
+func (c *CwmpDb) MatchZtpTemplate(protocol, oui, productClass, serialNumber string) (*ZtpTemplate, error) {
+	templates, err := c.GetZtpTemplates(bson.M{
+		"protocol":      bson.M{"$in": bson.A{protocol, "", nil}},
+		"product_class": bson.M{"$in": bson.A{productClass, "", nil}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Templates are sorted by name, keep that order among equal priorities
+	sort.SliceStable(templates, func(i, j int) bool { return templates[i].Priority > templates[j].Priority })
+	for i := range templates {
+		if templates[i].Matches(protocol, oui, productClass, serialNumber) {
+			return &templates[i], nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+// MarkZtpDevice records that a device was seen by zero-touch provisioning.
+// It returns false if the device was seen before, templates only apply when
+// a device first appears.
+func (c *CwmpDb) MarkZtpDevice(device *ZtpDevice) (bool, error) {
+	if c.ztpDeviceColl == nil {
+		return false, errors.New("ZTP device collection not initialized")
+	}
+
+	device.ID = device.Protocol + ":" + device.DeviceID
+	device.AppliedAt = time.Now()
+	_, err := c.ztpDeviceColl.InsertOne(context.Background(), device)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetZtpDevices returns the devices seen by zero-touch provisioning matching
+// filter, most recent first
+func (c *CwmpDb) GetZtpDevices(filter bson.M, limit int64) ([]ZtpDevice, error) {
+	if c.ztpDeviceColl == nil {
+		return nil, errors.New("ZTP device collection not initialized")
+	}
+
+	ctx := context.Background()
+	opts := options.Find().SetSort(bson.D{{Key: "applied_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := c.ztpDeviceColl.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	devices := []ZtpDevice{}
+	if err = cursor.All(ctx, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
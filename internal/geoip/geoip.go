@@ -0,0 +1,227 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package geoip resolves IP addresses to their location and autonomous
+// system using a CSV database of its own format with the columns:
+//
+//	network,country,region,asn,as_org
+//
+// e.g. "100.64.0.0/10,US,CA,64512,Example ISP". MaxMind GeoLite2 CSV files
+// split locations and ASNs into separate files keyed by geoname ID and have
+// to be joined into this format first.
+package geoip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Record holds the location data of a network
+type Record struct {
+	Country string
+	Region  string
+	ASN     uint32
+	ASOrg   string
+}
+
+type ipRange struct {
+	first  net.IP
+	last   net.IP
+	record Record
+}
+
+// DB is an in-memory GeoIP database. Nested networks are flattened into
+// disjoint ranges sorted by address, each holding the record of the most
+// specific network covering it.
+type DB struct {
+	ranges   []ipRange
+	networks int
+}
+
+// Open loads a GeoIP database from a CSV file
+func Open(path string) (*DB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+
+	db := &DB{}
+	for line := 1; ; line++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 || (line == 1 && fields[0] == "network") {
+			continue
+		}
+		r, err := parseRange(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		db.ranges = append(db.ranges, r)
+	}
+
+	db.networks = len(db.ranges)
+	db.ranges = flatten(db.ranges)
+	return db, nil
+}
+
+// flatten turns networks, which are either nested or disjoint, into
+// disjoint ranges. Networks are sorted by start with enclosing networks
+// first, a stack holds the networks enclosing the current one.
+func flatten(networks []ipRange) []ipRange {
+	sort.SliceStable(networks, func(i, j int) bool {
+		if c := bytes.Compare(networks[i].first, networks[j].first); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(networks[i].last, networks[j].last) > 0
+	})
+
+	var ranges []ipRange
+	emit := func(first, last net.IP, record Record) {
+		if first != nil && last != nil && bytes.Compare(first, last) <= 0 {
+			ranges = append(ranges, ipRange{first: first, last: last, record: record})
+		}
+	}
+
+	// next is the first address not yet emitted, nil once the end of the
+	// address space was reached
+	var next net.IP
+	var stack []ipRange
+	closeUntil := func(addr net.IP) {
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if addr != nil && bytes.Compare(top.last, addr) >= 0 {
+				return
+			}
+			emit(next, top.last, top.record)
+			if next != nil && bytes.Compare(next, top.last) <= 0 {
+				next = nextIP(top.last)
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for _, r := range networks {
+		closeUntil(r.first)
+		if len(stack) > 0 {
+			emit(next, prevIP(r.first), stack[len(stack)-1].record)
+		}
+		next = r.first
+		stack = append(stack, r)
+	}
+	closeUntil(nil)
+	return ranges
+}
+
+// nextIP returns ip+1, nil on overflow
+func nextIP(ip net.IP) net.IP {
+	n := make(net.IP, len(ip))
+	copy(n, ip)
+	for i := len(n) - 1; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return n
+		}
+	}
+	return nil
+}
+
+// prevIP returns ip-1, nil on underflow
+func prevIP(ip net.IP) net.IP {
+	p := make(net.IP, len(ip))
+	copy(p, ip)
+	for i := len(p) - 1; i >= 0; i-- {
+		p[i]--
+		if p[i] != 0xff {
+			return p
+		}
+	}
+	return nil
+}
+
+func parseRange(fields []string) (ipRange, error) {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return ipRange{}, err
+	}
+
+	first := ipNet.IP.To16()
+	mask := ipNet.Mask
+	if len(mask) == net.IPv4len {
+		mask = append(net.CIDRMask(96, 128)[:12], mask...)
+	}
+	last := make(net.IP, net.IPv6len)
+	for i := range first {
+		last[i] = first[i] | ^mask[i]
+	}
+
+	r := ipRange{first: first, last: last}
+	if len(fields) > 1 {
+		r.record.Country = strings.TrimSpace(fields[1])
+	}
+	if len(fields) > 2 {
+		r.record.Region = strings.TrimSpace(fields[2])
+	}
+	if len(fields) > 3 && strings.TrimSpace(fields[3]) != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(fields[3]), "AS"), 10, 32)
+		if err != nil {
+			return ipRange{}, fmt.Errorf("invalid ASN %q", fields[3])
+		}
+		r.record.ASN = uint32(asn)
+	}
+	if len(fields) > 4 {
+		r.record.ASOrg = strings.TrimSpace(fields[4])
+	}
+	return r, nil
+}
+
+// Len returns the number of networks in the database
+func (db *DB) Len() int {
+	return db.networks
+}
+
+// Lookup returns the record of the most specific network containing ip
+func (db *DB) Lookup(ip string) (*Record, bool) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, false
+	}
+	addr = addr.To16()
+
+	// The ranges are disjoint, only the last one starting at or before addr
+	// can contain it
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].first, addr) > 0
+	})
+	if i == 0 || bytes.Compare(addr, db.ranges[i-1].last) > 0 {
+		return nil, false
+	}
+	rec := db.ranges[i-1].record
+	return &rec, true
+}
@@ -0,0 +1,336 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SegmentKind is the kind of a path expression segment
+type SegmentKind int
+
+// Segments of a TR-369 path expression
+const (
+	SegmentName     SegmentKind = iota // object or parameter name
+	SegmentInstance                    // instance number, e.g. 1
+	SegmentWildcard                    // all instances, *
+	SegmentSearch                      // instances matching a search expression, [Alias=="lan"]
+)
+
+// Filter operators of search expressions
+const (
+	OpEqual        = "=="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpGreater      = ">"
+	OpLessEqual    = "<="
+	OpGreaterEqual = ">="
+)
+
+// filterOps is ordered so that two character operators match before < and >
+var filterOps = []string{OpEqual, OpNotEqual, OpLessEqual, OpGreaterEqual, OpLess, OpGreater}
+
+// Filter is one condition of a search expression, Key is relative to the
+// instance
+type Filter struct {
+	Key    string
+	Op     string
+	Value  string
+	Quoted bool // string values are quoted, numbers and booleans are not
+}
+
+// Segment is one element of a path expression
+type Segment struct {
+	Kind    SegmentKind
+	Name    string   // name or instance number
+	Filters []Filter // conditions of a search segment, all must match
+}
+
+// PathExpr is a parsed TR-369 path: an object path (ending with a dot) or a
+// parameter path, possibly with wildcards and search expressions in place of
+// instance numbers
+type PathExpr struct {
+	Segments []Segment
+	// Param is the trailing parameter name, empty for an object path
+	Param string
+}
+
+// ParsePathExpr parses a path such as Device.WiFi.SSID.*.SSID or
+// Device.IP.Interface.[Alias=="lan"&&Enable==true].
+func ParsePathExpr(path string) (*PathExpr, error) {
+	parts, err := splitPath(path)
+	if err != nil {
+		return nil, err
+	}
+	expr := &PathExpr{}
+	last := len(parts) - 1
+	for i, part := range parts {
+		switch {
+		case part == "" && i == last && i > 0:
+			// Trailing dot of an object path
+		case part == "":
+			return nil, fmt.Errorf("invalid path %q: empty segment", path)
+		case part == "*":
+			expr.Segments = append(expr.Segments, Segment{Kind: SegmentWildcard})
+		case part[0] == '[':
+			filters, err := parseSearch(part[1 : len(part)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", path, err)
+			}
+			expr.Segments = append(expr.Segments, Segment{Kind: SegmentSearch, Filters: filters})
+		case isInstanceNumber(part):
+			expr.Segments = append(expr.Segments, Segment{Kind: SegmentInstance, Name: part})
+		case isName(part):
+			if i == last {
+				expr.Param = part
+			} else {
+				expr.Segments = append(expr.Segments, Segment{Kind: SegmentName, Name: part})
+			}
+		default:
+			return nil, fmt.Errorf("invalid path %q: invalid segment %q", path, part)
+		}
+	}
+	if len(expr.Segments) == 0 || expr.Segments[0].Kind != SegmentName {
+		return nil, fmt.Errorf("invalid path %q: must start with an object name", path)
+	}
+	return expr, nil
+}
+
+// IsSearch reports whether the path has wildcards or search expressions,
+// i.e. may resolve to several objects
+func (e *PathExpr) IsSearch() bool {
+	for _, s := range e.Segments {
+		if s.Kind == SegmentWildcard || s.Kind == SegmentSearch {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the path in its canonical form, as sent in USP requests
+func (e *PathExpr) String() string {
+	var b strings.Builder
+	for _, s := range e.Segments {
+		b.WriteString(s.String())
+		b.WriteByte('.')
+	}
+	b.WriteString(e.Param)
+	return b.String()
+}
+
+func (s Segment) String() string {
+	switch s.Kind {
+	case SegmentWildcard:
+		return "*"
+	case SegmentSearch:
+		conds := make([]string, 0, len(s.Filters))
+		for _, f := range s.Filters {
+			value := f.Value
+			if f.Quoted {
+				value = `"` + value + `"`
+			}
+			conds = append(conds, f.Key+f.Op+value)
+		}
+		return "[" + strings.Join(conds, "&&") + "]"
+	}
+	return s.Name
+}
+
+// Match reports whether the parameter values of an instance, keyed by path
+// relative to the instance, satisfy all the conditions of a search segment
+func (s Segment) Match(values map[string]string) bool {
+	for _, f := range s.Filters {
+		value, ok := values[f.Key]
+		if !ok || !f.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the parameters a search segment needs the values of
+func (s Segment) Keys() []string {
+	keys := make([]string, 0, len(s.Filters))
+	for _, f := range s.Filters {
+		keys = append(keys, f.Key)
+	}
+	return keys
+}
+
+// ParseConditions parses conditions joined by && with the syntax of search
+// expressions, e.g. DeviceInfo.ModelName=="HGW" && UpTime>3600
+func ParseConditions(expr string) ([]Filter, error) {
+	return parseSearch(expr)
+}
+
+// Match reports whether a value satisfies the condition
+func (f Filter) Match(value string) bool {
+	return f.match(value)
+}
+
+// match compares numerically if both values are numbers, as strings otherwise
+func (f Filter) match(value string) bool {
+	cmp := strings.Compare(value, f.Value)
+	if a, err := strconv.ParseFloat(value, 64); err == nil {
+		if b, err := strconv.ParseFloat(f.Value, 64); err == nil {
+			switch {
+			case a < b:
+				cmp = -1
+			case a > b:
+				cmp = 1
+			default:
+				cmp = 0
+			}
+		}
+	}
+	switch f.Op {
+	case OpEqual:
+		return cmp == 0
+	case OpNotEqual:
+		return cmp != 0
+	case OpLess:
+		return cmp < 0
+	case OpGreater:
+		return cmp > 0
+	case OpLessEqual:
+		return cmp <= 0
+	case OpGreaterEqual:
+		return cmp >= 0
+	}
+	return false
+}
+
+// splitPath splits a path on the dots outside of search expressions
+func splitPath(path string) ([]string, error) {
+	var parts []string
+	start, depth, quoted := 0, 0, false
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '"' && depth > 0:
+			quoted = !quoted
+		case quoted:
+		case c == '[':
+			if depth > 0 || i != start {
+				return nil, fmt.Errorf("invalid path %q: unexpected [", path)
+			}
+			depth++
+		case c == ']':
+			if depth == 0 || (i+1 < len(path) && path[i+1] != '.') {
+				return nil, fmt.Errorf("invalid path %q: unexpected ]", path)
+			}
+			depth--
+		case c == '.' && depth == 0:
+			parts = append(parts, path[start:i])
+			start = i + 1
+		}
+	}
+	if depth > 0 || quoted {
+		return nil, fmt.Errorf("invalid path %q: unterminated search expression", path)
+	}
+	return append(parts, path[start:]), nil
+}
+
+// parseSearch parses the conditions of a search expression joined by &&
+func parseSearch(search string) ([]Filter, error) {
+	var filters []Filter
+	for _, cond := range splitConditions(search) {
+		cond = strings.TrimSpace(cond)
+		f, err := parseFilter(cond)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// splitConditions splits on the && outside of quoted values
+func splitConditions(search string) []string {
+	var conds []string
+	start, quoted := 0, false
+	for i := 0; i < len(search); i++ {
+		switch {
+		case search[i] == '"':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(search[i:], "&&"):
+			conds = append(conds, search[start:i])
+			start = i + 2
+			i++
+		}
+	}
+	return append(conds, search[start:])
+}
+
+// parseFilter parses a condition, the operator is the first one in cond
+func parseFilter(cond string) (Filter, error) {
+	for i := 0; i < len(cond); i++ {
+		var op string
+		for _, o := range filterOps {
+			if strings.HasPrefix(cond[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			continue
+		}
+		key := strings.TrimSpace(cond[:i])
+		value := strings.TrimSpace(cond[i+len(op):])
+		if key == "" || !isRelativePath(key) {
+			return Filter{}, fmt.Errorf("invalid search key in %q", cond)
+		}
+		quoted := len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"'
+		if quoted {
+			value = value[1 : len(value)-1]
+		}
+		if strings.Contains(value, `"`) || (!quoted && (value == "" || strings.Contains(value, " "))) {
+			return Filter{}, fmt.Errorf("invalid search value in %q", cond)
+		}
+		return Filter{Key: key, Op: op, Value: value, Quoted: quoted}, nil
+	}
+	return Filter{}, fmt.Errorf("invalid search condition %q", cond)
+}
+
+func isInstanceNumber(s string) bool {
+	n, err := strconv.ParseUint(s, 10, 32)
+	return err == nil && n > 0
+}
+
+// isName checks an object or parameter name: a letter or underscore
+// followed by letters, digits, underscores and hyphens
+func isName(s string) bool {
+	for i, c := range s {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c >= '0' && c <= '9'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isRelativePath checks a search key, a parameter name possibly prefixed by
+// sub-object names and instance numbers
+func isRelativePath(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if !isName(part) && !isInstanceNumber(part) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,284 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quota enforces the per-tenant limits on devices, API request rate
+// and concurrent jobs configured in the tenants section of the configuration.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Violation types
+const (
+	ViolationDevices = "quota.devices"
+	ViolationRate    = "quota.request_rate"
+	ViolationJobs    = "quota.concurrent_jobs"
+)
+
+// ErrUnknownTenant is returned for a tenant which is not configured
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// alarmHoldOff is the minimum time between two violations of the same type
+// reported for a tenant
+const alarmHoldOff = time.Minute
+
+// Violation describes a tenant exceeding one of its limits
+type Violation struct {
+	Tenant  string
+	Type    string
+	Message string
+}
+
+// Status holds the current usage of a tenant against its limits
+type Status struct {
+	Tenant            string  `json:"tenant"`
+	Devices           int64   `json:"devices"`
+	MaxDevices        int     `json:"max_devices"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	Burst             int     `json:"burst"`
+	ActiveJobs        int     `json:"active_jobs"`
+	MaxConcurrentJobs int     `json:"max_concurrent_jobs"`
+	Violations        uint64  `json:"violations"`
+}
+
+type tenant struct {
+	cfg        config.TenantConfig
+	tokens     float64
+	lastRefill time.Time
+	activeJobs int
+	violations uint64
+	lastAlarm  map[string]time.Time
+}
+
+// Manager tracks the usage of all configured tenants
+type Manager struct {
+	tenants    map[string]*tenant
+	userTenant map[string]string
+	ouiTenant  map[string]string
+	rules      []deviceRule
+	mutex      sync.Mutex
+	// OnViolation is called when a tenant exceeds a limit, at most once per
+	// violation type and tenant within alarmHoldOff
+	OnViolation func(v Violation)
+}
+
+// NewManager creates a quota manager for the configured tenants
+func NewManager(tenants []config.TenantConfig) *Manager {
+	m := &Manager{
+		tenants:    make(map[string]*tenant),
+		userTenant: make(map[string]string),
+		ouiTenant:  make(map[string]string),
+	}
+	for _, t := range tenants {
+		m.tenants[t.Name] = &tenant{
+			cfg:        t,
+			tokens:     float64(burst(t.Quota)),
+			lastRefill: time.Now(),
+			lastAlarm:  make(map[string]time.Time),
+		}
+		for _, u := range t.Users {
+			m.userTenant[u] = t.Name
+		}
+		for _, oui := range t.OUIs {
+			m.ouiTenant[strings.ToUpper(oui)] = t.Name
+		}
+		for _, rule := range t.Rules {
+			m.rules = append(m.rules, deviceRule{tenant: t.Name, rule: rule})
+		}
+	}
+	return m
+}
+
+// deviceRule is a device assignment rule of a tenant
+type deviceRule struct {
+	tenant string
+	rule   config.TenantRule
+}
+
+func (r deviceRule) matches(oui, productClass, serialNumber, profile string) bool {
+	return (r.rule.OUI == "" || strings.EqualFold(r.rule.OUI, oui)) &&
+		(r.rule.ProductClass == "" || r.rule.ProductClass == productClass) &&
+		strings.HasPrefix(serialNumber, r.rule.SerialNumberPrefix) &&
+		(r.rule.Profile == "" || r.rule.Profile == profile)
+}
+
+func burst(q config.QuotaConfig) int {
+	if q.Burst > 0 {
+		return q.Burst
+	}
+	if q.RequestsPerSecond >= 1 {
+		return int(q.RequestsPerSecond)
+	}
+	return 1
+}
+
+// Tenants returns the names of the configured tenants
+func (m *Manager) Tenants() []string {
+	var names []string
+	for name := range m.tenants {
+		names = append(names, name)
+	}
+	return names
+}
+
+// TenantOfUser returns the tenant an API user belongs to
+func (m *Manager) TenantOfUser(user string) (string, bool) {
+	name, ok := m.userTenant[user]
+	return name, ok
+}
+
+// TenantOfOUI returns the tenant devices with the given OUI belong to
+func (m *Manager) TenantOfOUI(oui string) (string, bool) {
+	name, ok := m.ouiTenant[strings.ToUpper(oui)]
+	return name, ok
+}
+
+// TenantOfDevice returns the tenant a device belongs to, the tenant of the
+// first rule matching the device or else of its OUI
+func (m *Manager) TenantOfDevice(oui, productClass, serialNumber, profile string) (string, bool) {
+	for _, r := range m.rules {
+		if r.matches(oui, productClass, serialNumber, profile) {
+			return r.tenant, true
+		}
+	}
+	return m.TenantOfOUI(oui)
+}
+
+// HasTenant reports whether a tenant is configured
+func (m *Manager) HasTenant(name string) bool {
+	_, ok := m.tenants[name]
+	return ok
+}
+
+// AllowRequest consumes a token of the tenant's request rate bucket and
+// reports whether the request is within the limit
+func (m *Manager) AllowRequest(name string) bool {
+	m.mutex.Lock()
+	t, ok := m.tenants[name]
+	if !ok || t.cfg.Quota.RequestsPerSecond <= 0 {
+		m.mutex.Unlock()
+		return true
+	}
+
+	now := time.Now()
+	maxTokens := float64(burst(t.cfg.Quota))
+	t.tokens += now.Sub(t.lastRefill).Seconds() * t.cfg.Quota.RequestsPerSecond
+	if t.tokens > maxTokens {
+		t.tokens = maxTokens
+	}
+	t.lastRefill = now
+	if t.tokens >= 1 {
+		t.tokens--
+		m.mutex.Unlock()
+		return true
+	}
+	v := m.violation(t, ViolationRate,
+		fmt.Sprintf("request rate above %.2f/s", t.cfg.Quota.RequestsPerSecond))
+	m.mutex.Unlock()
+
+	m.report(v)
+	return false
+}
+
+// AcquireJob reserves a concurrent job slot of the tenant. The returned
+// function releases the slot and must be called once the job is done.
+func (m *Manager) AcquireJob(name string) (func(), bool) {
+	m.mutex.Lock()
+	t, ok := m.tenants[name]
+	if !ok || t.cfg.Quota.MaxConcurrentJobs <= 0 {
+		m.mutex.Unlock()
+		return func() {}, true
+	}
+	if t.activeJobs >= t.cfg.Quota.MaxConcurrentJobs {
+		v := m.violation(t, ViolationJobs,
+			fmt.Sprintf("concurrent jobs limit of %d reached", t.cfg.Quota.MaxConcurrentJobs))
+		m.mutex.Unlock()
+		m.report(v)
+		return nil, false
+	}
+	t.activeJobs++
+	m.mutex.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mutex.Lock()
+			t.activeJobs--
+			m.mutex.Unlock()
+		})
+	}, true
+}
+
+// AllowDevice reports whether a tenant owning devices devices may register
+// another one
+func (m *Manager) AllowDevice(name string, devices int64) bool {
+	m.mutex.Lock()
+	t, ok := m.tenants[name]
+	if !ok || t.cfg.Quota.MaxDevices <= 0 || devices < int64(t.cfg.Quota.MaxDevices) {
+		m.mutex.Unlock()
+		return true
+	}
+	v := m.violation(t, ViolationDevices,
+		fmt.Sprintf("device limit of %d reached", t.cfg.Quota.MaxDevices))
+	m.mutex.Unlock()
+
+	m.report(v)
+	return false
+}
+
+// Status returns the usage of a tenant, devices being its current device count
+func (m *Manager) Status(name string, devices int64) (*Status, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t, ok := m.tenants[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, name)
+	}
+	return &Status{
+		Tenant:            name,
+		Devices:           devices,
+		MaxDevices:        t.cfg.Quota.MaxDevices,
+		RequestsPerSecond: t.cfg.Quota.RequestsPerSecond,
+		Burst:             burst(t.cfg.Quota),
+		ActiveJobs:        t.activeJobs,
+		MaxConcurrentJobs: t.cfg.Quota.MaxConcurrentJobs,
+		Violations:        t.violations,
+	}, nil
+}
+
+// violation counts a violation and returns it if it should be reported,
+// must be called with the mutex held
+func (m *Manager) violation(t *tenant, vType string, msg string) *Violation {
+	t.violations++
+	now := time.Now()
+	if now.Sub(t.lastAlarm[vType]) < alarmHoldOff {
+		return nil
+	}
+	t.lastAlarm[vType] = now
+	return &Violation{Tenant: t.cfg.Name, Type: vType, Message: msg}
+}
+
+func (m *Manager) report(v *Violation) {
+	if v != nil && m.OnViolation != nil {
+		m.OnViolation(*v)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package stun implements the STUN server of TR-111 Annex G. CPEs behind a
+// NAT send it Binding Requests to learn and keep open the public address
+// their UDP connection requests are received on. Both RFC 3489 and RFC 5389
+// messages are answered.
+package stun
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+)
+
+// Message types
+const (
+	typeBindingRequest  = 0x0001
+	typeBindingResponse = 0x0101
+)
+
+// Attribute types, the last two are the TR-111 extensions
+const (
+	attrMappedAddress            = 0x0001
+	attrUsername                 = 0x0006
+	attrXorMappedAddress         = 0x0020
+	attrConnectionRequestBinding = 0xC001
+	attrBindingChange            = 0xC002
+)
+
+// magicCookie identifies RFC 5389 messages
+const magicCookie = 0x2112A442
+
+const headerSize = 20
+
+// Binding is the NAT binding a CPE announced in a Binding Request with the
+// CONNECTION-REQUEST-BINDING attribute
+type Binding struct {
+	Username string       // the STUNUsername of the CPE
+	Addr     *net.UDPAddr // public address of the binding
+	Changed  bool         // the CPE reported a BINDING-CHANGE
+}
+
+// Server answers STUN Binding Requests on a UDP socket
+type Server struct {
+	conn *net.UDPConn
+	// OnBinding is called for the Binding Requests announcing a connection
+	// request binding
+	OnBinding func(*Binding)
+}
+
+type message struct {
+	typ           uint16
+	cookie        bool
+	transactionID []byte // 16 bytes, including the cookie of RFC 5389 messages
+	username      string
+	crBinding     bool
+	bindingChange bool
+}
+
+// Listen opens the UDP socket of the server
+func Listen(addr string) (*Server, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{conn: conn}, nil
+}
+
+// Serve answers Binding Requests until the server is closed
+func (s *Server) Serve() error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		msg, err := parseMessage(buf[:n])
+		if err != nil || msg.typ != typeBindingRequest {
+			continue
+		}
+		if _, err := s.conn.WriteToUDP(bindingResponse(msg, addr), addr); err != nil {
+			log.Printf("Error answering STUN Binding Request of %s: %v", addr, err)
+		}
+		if msg.crBinding && s.OnBinding != nil {
+			s.OnBinding(&Binding{Username: msg.username, Addr: addr, Changed: msg.bindingChange})
+		}
+	}
+}
+
+// WriteToUDP sends a datagram from the server socket, through which the NAT
+// binding of a CPE was opened
+func (s *Server) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	return s.conn.WriteToUDP(b, addr)
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	return s.conn.Close()
+}
+
+// parseMessage decodes the header and the attributes of a STUN message
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < headerSize {
+		return nil, errors.New("short STUN message")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if headerSize+length > len(b) {
+		return nil, errors.New("truncated STUN message")
+	}
+	msg := &message{
+		typ:           binary.BigEndian.Uint16(b[0:2]),
+		cookie:        binary.BigEndian.Uint32(b[4:8]) == magicCookie,
+		transactionID: b[4:20],
+	}
+
+	attrs := b[headerSize : headerSize+length]
+	for len(attrs) >= 4 {
+		typ := binary.BigEndian.Uint16(attrs[0:2])
+		size := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+size > len(attrs) {
+			return nil, errors.New("truncated STUN attribute")
+		}
+		value := attrs[4 : 4+size]
+		switch typ {
+		case attrUsername:
+			msg.username = string(value)
+		case attrConnectionRequestBinding:
+			msg.crBinding = true
+		case attrBindingChange:
+			msg.bindingChange = true
+		}
+		// Attributes are padded to a multiple of 4 bytes
+		next := 4 + (size+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	return msg, nil
+}
+
+// bindingResponse builds the Binding Response to a request from addr, with
+// a MAPPED-ADDRESS and, for RFC 5389 clients, an XOR-MAPPED-ADDRESS
+func bindingResponse(req *message, addr *net.UDPAddr) []byte {
+	attrs := addressAttr(attrMappedAddress, addr.IP, addr.Port, nil)
+	if req.cookie {
+		attrs = append(attrs, addressAttr(attrXorMappedAddress, addr.IP, addr.Port, req.transactionID)...)
+	}
+
+	b := make([]byte, headerSize, headerSize+len(attrs))
+	binary.BigEndian.PutUint16(b[0:2], typeBindingResponse)
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(attrs)))
+	copy(b[4:20], req.transactionID)
+	return append(b, attrs...)
+}
+
+// addressAttr encodes an address attribute, XORed with the magic cookie and
+// transaction ID if xorKey is set
+func addressAttr(typ uint16, ip net.IP, port int, xorKey []byte) []byte {
+	family, addr := byte(0x01), ip.To4()
+	if addr == nil {
+		family, addr = 0x02, ip.To16()
+	}
+	addr = append([]byte(nil), addr...)
+	if xorKey != nil {
+		port ^= magicCookie >> 16
+		for i := range addr {
+			addr[i] ^= xorKey[i]
+		}
+	}
+
+	b := make([]byte, 8, 8+len(addr))
+	binary.BigEndian.PutUint16(b[0:2], typ)
+	binary.BigEndian.PutUint16(b[2:4], uint16(4+len(addr)))
+	b[5] = family
+	binary.BigEndian.PutUint16(b[6:8], uint16(port))
+	return append(b, addr...)
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"regexp"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -31,6 +32,12 @@ type Config struct {
 	Protocols  ProtocolsConfig  `yaml:"protocols"`
 	Security   SecurityConfig   `yaml:"security"`
 	Logging    LoggingConfig    `yaml:"logging"`
+	Debug      DebugConfig      `yaml:"debug,omitempty"`
+	Analytics  AnalyticsConfig  `yaml:"analytics"`
+	Webhooks   WebhookConfig    `yaml:"webhooks,omitempty"`
+	Bulk       BulkConfig       `yaml:"bulk,omitempty"`
+	Tenants    []TenantConfig   `yaml:"tenants,omitempty"`
+	CLI        CLIConfig        `yaml:"cli,omitempty"`
 }
 
 // ServiceConfig contains service-specific configuration
@@ -55,13 +62,27 @@ type DatabaseConfig struct {
 		MaxConnections int           `yaml:"maxConnections"`
 		Timeout        time.Duration `yaml:"timeout"`
 	} `yaml:"pool"`
+	// EventRetention is how long CWMP device events are kept
+	EventRetention time.Duration `yaml:"eventRetention,omitempty"`
+	// ParamHistoryRetention is how long CWMP parameter history is kept
+	ParamHistoryRetention time.Duration `yaml:"paramHistoryRetention,omitempty"`
 }
 
 // MessageBusConfig contains message bus configuration
 type MessageBusConfig struct {
-	STOMP StompConfig `yaml:"stomp"`
-	MQTT  MqttConfig  `yaml:"mqtt"`
-	COAP  CoapConfig  `yaml:"coap"`
+	STOMP StompConfig  `yaml:"stomp"`
+	MQTT  MqttConfig   `yaml:"mqtt"`
+	COAP  CoapConfig   `yaml:"coap"`
+	ACS   AcsBusConfig `yaml:"acs"`
+}
+
+// AcsBusConfig contains the configuration of the bus carrying CWMP events
+// from the ACS to the controller and RPC commands back to the ACS
+type AcsBusConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	Transport    string `yaml:"transport"` // stomp or mqtt
+	EventTopic   string `yaml:"eventTopic,omitempty"`
+	CommandTopic string `yaml:"commandTopic,omitempty"`
 }
 
 // StompConfig contains STOMP protocol configuration
@@ -118,8 +139,27 @@ type HTTPConfig struct {
 	Port      int    `yaml:"port"`
 	TLSPort   int    `yaml:"tlsPort"`
 	EnableTLS bool   `yaml:"enableTLS"`
-	CertFile  string `yaml:"certFile,omitempty"`
-	KeyFile   string `yaml:"keyFile,omitempty"`
+	// CertFile, KeyFile and ClientCAFile default to the ones of security.tls
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	// ClientAuth is none, optional or require, client certificates are
+	// verified against ClientCAFile
+	ClientAuth   string            `yaml:"clientAuth,omitempty"`
+	ClientCAFile string            `yaml:"clientCAFile,omitempty"`
+	Compression  CompressionConfig `yaml:"compression,omitempty"`
+	// ShutdownTimeout is how long the API server drains the requests in
+	// flight and the background work when it stops
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout,omitempty"`
+}
+
+// CompressionConfig contains the compression of the HTTP responses of at
+// least MinSize bytes. Level is the gzip level, zstd is offered as well when
+// Zstd is set.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	MinSize int  `yaml:"minSize,omitempty"`
+	Level   int  `yaml:"level,omitempty"`
+	Zstd    bool `yaml:"zstd,omitempty"`
 }
 
 // GRPCConfig contains gRPC server configuration
@@ -145,21 +185,289 @@ type WebSocketConfig struct {
 
 // CWMPConfig contains CWMP/TR-069 configuration
 type CWMPConfig struct {
-	Enabled  bool   `yaml:"enabled"`
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	TLSPort  int    `yaml:"tlsPort"`
-	URL      string `yaml:"url"`
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Enabled        bool             `yaml:"enabled"`
+	Host           string           `yaml:"host"`
+	Port           int              `yaml:"port"`
+	TLSPort        int              `yaml:"tlsPort"`
+	EnableTLS      bool             `yaml:"enableTLS"`
+	CertFile       string           `yaml:"certFile,omitempty"` // defaults to security.tls
+	KeyFile        string           `yaml:"keyFile,omitempty"`
+	ClientCert     ClientCertConfig `yaml:"clientCert"`
+	URL            string           `yaml:"url"`
+	Username       string           `yaml:"username"`
+	Password       string           `yaml:"password"`
+	SessionTimeout time.Duration    `yaml:"sessionTimeout,omitempty"`
+	InformInterval time.Duration    `yaml:"informInterval,omitempty"`
+	GeoIP          GeoIPConfig      `yaml:"geoip"`
+	Bootstrap      BootstrapConfig  `yaml:"bootstrap"`
+	// RequirePreRegistration only registers pre-registered devices, the
+	// others are put in the pending queue
+	RequirePreRegistration bool `yaml:"requirePreRegistration"`
+	// ConnectionRequest configures the connection requests sent to CPEs
+	ConnectionRequest ConnectionRequestConfig `yaml:"connectionRequest"`
+	// CommandTTL is how long a command waits for an offline device to
+	// connect before it expires, unless the command sets its own TTL
+	CommandTTL time.Duration `yaml:"commandTTL,omitempty"`
+	// RPCRetry configures the retries of commands which failed
+	RPCRetry RPCRetryConfig `yaml:"rpcRetry"`
+	// Refresh configures the periodic refresh of the full parameter tree
+	// of the devices
+	Refresh DataModelRefreshConfig `yaml:"refresh"`
+	// Sessions configures session cookies and concurrent session limits
+	Sessions SessionLimitsConfig `yaml:"sessions"`
+	// InformLimits configures the Inform rate limits and the detection of
+	// devices informing in a loop
+	InformLimits InformLimitsConfig `yaml:"informLimits"`
+	// MaxRequestSize is the largest SOAP request in bytes accepted from a
+	// CPE, larger ones are refused with HTTP 413
+	MaxRequestSize int64 `yaml:"maxRequestSize,omitempty"`
+	// DrainTimeout is how long the ACS waits for open sessions to end when
+	// it stops, new Informs are refused meanwhile
+	DrainTimeout time.Duration `yaml:"drainTimeout,omitempty"`
+	// Kicked configures the provisioning run for CPEs kicked to the ACS by
+	// a subscriber portal
+	Kicked KickedConfig `yaml:"kicked"`
+	// RequestDownload lists the files offered to CPEs which send a
+	// RequestDownload, the first policy matching the request applies
+	RequestDownload []DownloadPolicy `yaml:"requestDownload"`
+	// HTTPMode is serve, redirect or off. With TLS enabled the plain HTTP
+	// listener keeps serving CPEs, redirects them to the TLS port or is
+	// disabled.
+	HTTPMode string `yaml:"httpMode,omitempty"`
+	// FileServer configures the built-in server of firmware images and
+	// configuration files
+	FileServer FileServerConfig `yaml:"fileServer"`
+	// STUN configures the TR-111 STUN server used by CPEs behind a NAT
+	STUN STUNConfig `yaml:"stun"`
+	// Simulation runs simulated CPEs against the ACS, for development,
+	// demos and load tests
+	Simulation SimulationConfig `yaml:"simulation"`
+}
+
+// ClientCertConfig contains the settings of the mutual TLS authentication of
+// CPEs. Mode is none, optional or require; Identity names how the CN and SANs
+// of a certificate map to the device: serialNumber, oui-serialNumber,
+// oui-productClass-serialNumber or deviceId.
+type ClientCertConfig struct {
+	Mode       string `yaml:"mode"`
+	CACertFile string `yaml:"caCertFile"`
+	Identity   string `yaml:"identity"`
+}
+
+// STUNConfig contains the settings of the STUN server of the ACS
+type STUNConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Port    int  `yaml:"port"`
+}
+
+// SimulationConfig contains the settings of the simulated CPEs, none run
+// unless Devices is set. DataModel is TR-181, TR-098 or mixed. The CPEs
+// answer connection requests on ConnectionRequestPort unless it is 0.
+type SimulationConfig struct {
+	Devices               int           `yaml:"devices"`
+	DataModel             string        `yaml:"dataModel,omitempty"`
+	SerialPrefix          string        `yaml:"serialPrefix,omitempty"`
+	InformInterval        time.Duration `yaml:"informInterval,omitempty"` // defaults to the ACS inform interval
+	ConnectionRequestPort int           `yaml:"connectionRequestPort"`
+}
+
+// FileServerConfig contains the settings of the file server of the ACS.
+// Downloads of stored files carry URLs signed with Secret under BaseURL,
+// which stay valid for URLTTL.
+type FileServerConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	BaseURL string        `yaml:"baseURL"` // defaults to the scheme and host of the ACS URL
+	Secret  string        `yaml:"secret"`
+	URLTTL  time.Duration `yaml:"urlTTL"`
+}
+
+// RPCRetryConfig contains the settings of the retries of queued commands the
+// device answered with one of FaultCodes or did not answer before its
+// session dropped. A failed command is queued again after an exponential
+// backoff, until it was attempted MaxAttempts times.
+type RPCRetryConfig struct {
+	MaxAttempts int           `yaml:"maxAttempts"` // 1 disables retries
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"maxBackoff"`
+	FaultCodes  []uint32      `yaml:"faultCodes"`
+}
+
+// DataModelRefreshConfig contains the settings of the periodic refresh of
+// the parameters of the devices: their tree is walked with GetParameterNames,
+// then read with GetParameterValues of ChunkSize parameters each. At most
+// MaxPerRun devices are scheduled per minute; they are refreshed in their
+// next session, right away if ConnectionRequest is set. An Interval of 0
+// disables the refresh.
+type DataModelRefreshConfig struct {
+	Interval          time.Duration `yaml:"interval"`
+	ChunkSize         int           `yaml:"chunkSize"`
+	MaxPerRun         int           `yaml:"maxPerRun"`
+	ConnectionRequest bool          `yaml:"connectionRequest"`
+}
+
+// SessionLimitsConfig contains the settings binding CWMP sessions to their
+// HTTP cookie and limiting the sessions the ACS runs at once, 0 disables a
+// limit
+type SessionLimitsConfig struct {
+	CookieName    string `yaml:"cookieName"`
+	RequireCookie bool   `yaml:"requireCookie"`
+	MaxConcurrent int    `yaml:"maxConcurrent"`
+	MaxPerDevice  int    `yaml:"maxPerDevice"`
+}
+
+// InformLimitsConfig contains the token buckets limiting the Informs the ACS
+// accepts from each device and from all devices, in Informs per second; a
+// rate of 0 disables a limit. A device sending LoopInforms Informs, or
+// LoopBoots BOOT Informs, within LoopWindow is flagged as looping.
+type InformLimitsConfig struct {
+	DeviceRate  float64       `yaml:"deviceRate"`
+	DeviceBurst int           `yaml:"deviceBurst"`
+	GlobalRate  float64       `yaml:"globalRate"`
+	GlobalBurst int           `yaml:"globalBurst"`
+	LoopWindow  time.Duration `yaml:"loopWindow"`
+	LoopInforms int           `yaml:"loopInforms"`
+	LoopBoots   int           `yaml:"loopBoots"`
+}
+
+// ConnectionRequestConfig contains the settings of the connection request
+// client, a failed request is retried with exponential backoff
+type ConnectionRequestConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+	Retries int           `yaml:"retries"`
+	Backoff time.Duration `yaml:"backoff"`
+}
+
+// BootstrapConfig contains the settings of the synchronization run on
+// "0 BOOTSTRAP" Informs
+type BootstrapConfig struct {
+	Enabled              bool     `yaml:"enabled"`
+	ProvisionCredentials bool     `yaml:"provisionCredentials"`
+	NotifyParameters     []string `yaml:"notifyParameters"`
+	// Provisioning lists the flows applied on the first BOOTSTRAP of a
+	// device, the first flow matching the device applies
+	Provisioning []ProvisioningFlow `yaml:"provisioning"`
+}
+
+// KickedConfig contains the handling of the Kicked RPC. The Command sent by
+// the CPE names the provisioning flow to run, the subscriber is then sent to
+// the Next URL of the CPE or to SuccessURL, to FailureURL if the command is
+// rejected.
+type KickedConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	SuccessURL string `yaml:"successURL"`
+	FailureURL string `yaml:"failureURL"`
+}
+
+// DownloadPolicy selects the file offered to the devices of an OUI and
+// product class which request a file of FileType, empty fields match any
+// device. The file is either downloaded from URL or served by the file
+// server when FileID is set.
+type DownloadPolicy struct {
+	FileType        string        `yaml:"fileType"`
+	OUI             string        `yaml:"oui"`
+	ProductClass    string        `yaml:"productClass"`
+	URL             string        `yaml:"url,omitempty"`
+	FileID          string        `yaml:"fileId,omitempty"`
+	FileSize        int64         `yaml:"fileSize,omitempty"`
+	TargetFileName  string        `yaml:"targetFileName,omitempty"`
+	FirmwareVersion string        `yaml:"firmwareVersion,omitempty"`
+	Delay           time.Duration `yaml:"delay,omitempty"`
+}
+
+// ProvisioningFlow is the ordered sequence of actions applied to the
+// devices of an OUI and product class, empty fields match any device
+type ProvisioningFlow struct {
+	Name         string               `yaml:"name"`
+	OUI          string               `yaml:"oui"`
+	ProductClass string               `yaml:"productClass"`
+	Actions      []ProvisioningAction `yaml:"actions"`
+}
+
+// ProvisioningAction is one step of a provisioning flow. Its type is one of
+// periodicInform, credentials, profile or firmware, the other fields apply
+// to the type named in their comment.
+type ProvisioningAction struct {
+	Type           string        `yaml:"type"`
+	Interval       time.Duration `yaml:"interval,omitempty"`       // periodicInform, defaults to informInterval
+	Profile        string        `yaml:"profile,omitempty"`        // profile
+	URL            string        `yaml:"url,omitempty"`            // firmware
+	FileSize       int64         `yaml:"fileSize,omitempty"`       // firmware
+	TargetFileName string        `yaml:"targetFileName,omitempty"` // firmware
+	Delay          time.Duration `yaml:"delay,omitempty"`          // firmware, how long the device waits to download
+}
+
+// GeoIPConfig contains GeoIP enrichment configuration
+type GeoIPConfig struct {
+	Enabled      bool   `yaml:"enabled"`
+	DatabaseFile string `yaml:"databaseFile"`
+}
+
+// AnalyticsConfig contains the settings of the daily aggregation jobs
+type AnalyticsConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Interval     time.Duration `yaml:"interval"`
+	BackfillDays int           `yaml:"backfillDays"`
+}
+
+// WebhookConfig contains the delivery settings of the webhooks. A delivery
+// which fails is retried with exponential backoff until it runs out of
+// attempts, it is then dead-lettered.
+type WebhookConfig struct {
+	MaxAttempts int           `yaml:"maxAttempts"`
+	Backoff     time.Duration `yaml:"backoff"`
+	MaxBackoff  time.Duration `yaml:"maxBackoff"`
+	Timeout     time.Duration `yaml:"timeout"`
+}
+
+// BulkConfig contains the settings of the bulk jobs. Concurrency bounds the
+// devices a job submits RPCs for at the same time.
+type BulkConfig struct {
+	Concurrency int `yaml:"concurrency"`
+}
+
+// CLIConfig contains the settings of the interactive CLI
+type CLIConfig struct {
+	AliasFile string            `yaml:"aliasFile"`
+	Aliases   map[string]string `yaml:"aliases,omitempty"`
+	// Output is the default output format, table, json or csv
+	Output string `yaml:"output,omitempty"`
+}
+
+// TenantConfig maps API users and devices to a tenant and its quota. A
+// device belongs to the tenant of the first matching rule, or else of its OUI.
+type TenantConfig struct {
+	Name  string       `yaml:"name"`
+	Users []string     `yaml:"users"`
+	OUIs  []string     `yaml:"ouis"`
+	Rules []TenantRule `yaml:"rules,omitempty"`
+	Quota QuotaConfig  `yaml:"quota"`
+}
+
+// TenantRule matches the devices assigned to a tenant, empty fields match
+// any device
+type TenantRule struct {
+	OUI                string `yaml:"oui,omitempty"`
+	ProductClass       string `yaml:"productClass,omitempty"`
+	SerialNumberPrefix string `yaml:"serialNumberPrefix,omitempty"`
+	Profile            string `yaml:"profile,omitempty"`
+}
+
+// QuotaConfig contains the limits of a tenant, zero means unlimited
+type QuotaConfig struct {
+	MaxDevices        int     `yaml:"maxDevices"`
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+	MaxConcurrentJobs int     `yaml:"maxConcurrentJobs"`
 }
 
 // SecurityConfig contains security-related configuration
 type SecurityConfig struct {
-	Auth  AuthConfig  `yaml:"auth"`
-	TLS   TLSConfig   `yaml:"tls"`
-	USP   USPConfig   `yaml:"usp"`
-	Cache CacheConfig `yaml:"cache"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rateLimit,omitempty"`
+	CORS      CORSConfig      `yaml:"cors,omitempty"`
+	TLS       TLSConfig       `yaml:"tls"`
+	USP       USPConfig       `yaml:"usp"`
+	Cache     CacheConfig     `yaml:"cache"`
 }
 
 // AuthConfig contains authentication configuration
@@ -168,6 +476,56 @@ type AuthConfig struct {
 	Username string `yaml:"username"`
 	Password string `yaml:"password"`
 	Token    string `yaml:"token,omitempty"`
+	// Users and APIKeys authenticate in addition to Username, the tenants
+	// they belong to are listed in the tenants section
+	Users   []UserConfig   `yaml:"users,omitempty"`
+	APIKeys []APIKeyConfig `yaml:"apiKeys,omitempty"`
+	// MaxFailedLogins failed logins within FailureWindow lock a client IP or
+	// user out for LockoutDuration
+	MaxFailedLogins int           `yaml:"maxFailedLogins,omitempty"`
+	FailureWindow   time.Duration `yaml:"failureWindow,omitempty"`
+	LockoutDuration time.Duration `yaml:"lockoutDuration,omitempty"`
+}
+
+// UserConfig is an API user, the password may be a bcrypt hash
+type UserConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// APIKeyConfig is an API key sent in the X-API-Key header, the key may be
+// given as sha256:<hex digest>. Requests made with the key are attributed to
+// the user Name.
+type APIKeyConfig struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+// RateLimitConfig contains the API request rate limits of each client IP
+// and authenticated user, a zero rate means unlimited
+type RateLimitConfig struct {
+	Enabled bool      `yaml:"enabled"`
+	PerIP   RateLimit `yaml:"perIP"`
+	PerUser RateLimit `yaml:"perUser"`
+}
+
+// RateLimit is a token bucket refilled at RequestsPerSecond and holding up
+// to Burst requests
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requestsPerSecond"`
+	Burst             int     `yaml:"burst"`
+}
+
+// CORSConfig contains the cross-origin requests the API accepts from
+// browsers, "*" allows any origin
+type CORSConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	AllowedOrigins   []string      `yaml:"allowedOrigins"`
+	AllowedMethods   []string      `yaml:"allowedMethods,omitempty"`
+	AllowedHeaders   []string      `yaml:"allowedHeaders,omitempty"`
+	ExposedHeaders   []string      `yaml:"exposedHeaders,omitempty"`
+	AllowCredentials bool          `yaml:"allowCredentials,omitempty"`
+	MaxAge           time.Duration `yaml:"maxAge,omitempty"`
 }
 
 // TLSConfig contains TLS configuration
@@ -205,6 +563,17 @@ type LoggingConfig struct {
 	MaxBackups int    `yaml:"maxBackups,omitempty"`
 	MaxAge     int    `yaml:"maxAge,omitempty"`
 	Compress   bool   `yaml:"compress,omitempty"`
+	// Modules overrides the level per module, e.g. acs.session: debug
+	Modules map[string]string `yaml:"modules,omitempty"`
+}
+
+// DebugConfig enables the pprof and runtime statistics endpoints, served on
+// their own address behind basic authentication
+type DebugConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Address  string `yaml:"address,omitempty"`
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -220,7 +589,7 @@ func LoadConfig(configPath string) (*Config, error) {
 	}
 
 	// Expand environment variables in config
-	expanded := os.ExpandEnv(string(data))
+	expanded := expandEnv(string(data))
 
 	var config Config
 	if err := yaml.Unmarshal([]byte(expanded), &config); err != nil {
@@ -230,6 +599,21 @@ func LoadConfig(configPath string) (*Config, error) {
 	return &config, nil
 }
 
+// envVarPattern matches ${VAR} and ${VAR:default} references
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+
+// expandEnv substitutes environment variables in the configuration, a set
+// variable takes precedence over the default given as ${VAR:default}
+func expandEnv(data string) string {
+	return envVarPattern.ReplaceAllStringFunc(data, func(ref string) string {
+		m := envVarPattern.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(m[1]); ok {
+			return value
+		}
+		return m[2]
+	})
+}
+
 // findConfigFile tries to locate the configuration file
 func findConfigFile() string {
 	// Priority order for config file locations
@@ -0,0 +1,138 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package debugserver exposes the pprof profiles and runtime statistics of a
+// service on a separate, authenticated port
+package debugserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// DefaultAddress is where the debug server listens if no address is set,
+// on the loopback interface only
+const DefaultAddress = "127.0.0.1:6060"
+
+var startTime = time.Now()
+
+// RuntimeStats is the document served on /debug/runtime
+type RuntimeStats struct {
+	Service       string    `json:"service"`
+	GoVersion     string    `json:"go_version"`
+	StartTime     time.Time `json:"start_time"`
+	Uptime        string    `json:"uptime"`
+	NumCPU        int       `json:"num_cpu"`
+	GOMAXPROCS    int       `json:"gomaxprocs"`
+	NumGoroutine  int       `json:"num_goroutine"`
+	HeapAlloc     uint64    `json:"heap_alloc_bytes"`
+	HeapInuse     uint64    `json:"heap_inuse_bytes"`
+	HeapObjects   uint64    `json:"heap_objects"`
+	Sys           uint64    `json:"sys_bytes"`
+	TotalAlloc    uint64    `json:"total_alloc_bytes"`
+	NumGC         uint32    `json:"num_gc"`
+	LastGC        time.Time `json:"last_gc,omitempty"`
+	PauseTotalNs  uint64    `json:"gc_pause_total_ns"`
+	GCCPUFraction float64   `json:"gc_cpu_fraction"`
+}
+
+// Start serves the debug endpoints of a service in the background if they
+// are enabled. Credentials are required, the profiles reveal the internals
+// of the service.
+func Start(cfg config.DebugConfig, service string) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Username == "" || cfg.Password == "" {
+		return errors.New("debug server requires a username and password")
+	}
+	addr := cfg.Address
+	if addr == "" {
+		addr = DefaultAddress
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/debug/runtime", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(runtimeStats(service))
+	})
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           basicAuth(cfg.Username, cfg.Password, mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("Starting debug server of %s at %s", service, addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error serving debug endpoints: %v", err)
+		}
+	}()
+	return nil
+}
+
+// basicAuth rejects the requests without the debug credentials
+func basicAuth(username string, password string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(u), []byte(username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(p), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="debug"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func runtimeStats(service string) *RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	stats := &RuntimeStats{
+		Service:       service,
+		GoVersion:     runtime.Version(),
+		StartTime:     startTime,
+		Uptime:        time.Since(startTime).Round(time.Second).String(),
+		NumCPU:        runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumGoroutine:  runtime.NumGoroutine(),
+		HeapAlloc:     m.HeapAlloc,
+		HeapInuse:     m.HeapInuse,
+		HeapObjects:   m.HeapObjects,
+		Sys:           m.Sys,
+		TotalAlloc:    m.TotalAlloc,
+		NumGC:         m.NumGC,
+		PauseTotalNs:  m.PauseTotalNs,
+		GCCPUFraction: m.GCCPUFraction,
+	}
+	if m.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(m.LastGC))
+	}
+	return stats
+}
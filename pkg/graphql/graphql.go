@@ -0,0 +1,481 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package graphql executes GraphQL queries against a schema of resolvers.
+// It implements the subset the API needs: queries with aliases, arguments,
+// variables, fragments and the @include and @skip directives. Mutations,
+// subscriptions and introspection other than __typename are not supported.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxDepth bounds the nesting of the queries of a schema without
+// MaxDepth
+const DefaultMaxDepth = 8
+
+// Schema is the root query type of the API
+type Schema struct {
+	Query    *Object
+	MaxDepth int
+}
+
+// Object is a type whose fields are selected by the queries
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Field is a field of an object. Type is the object returned by Resolve,
+// alone or as a slice, or nil for a leaf value marshaled as JSON. Args lists
+// the arguments the field accepts. The objects of a slice are resolved
+// through pointers to its elements.
+type Field struct {
+	Type    *Object
+	Args    []string
+	Resolve func(p ResolveParams) (interface{}, error)
+}
+
+// ResolveParams are the object a field is resolved on and the arguments
+// of the field, with the variables substituted
+type ResolveParams struct {
+	Context context.Context
+	Source  interface{}
+	Args    map[string]interface{}
+}
+
+// Request is a GraphQL request as posted over HTTP
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Error is an error of the request or of a field, Path locating the field
+// in the response
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Result is the response to a request. Data is missing when the request
+// could not be executed.
+type Result struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Executed tells whether the request was valid and executed, possibly with
+// field errors
+func (r *Result) Executed() bool {
+	return r.Data != nil
+}
+
+// orderedMap keeps the fields of a response in the order of the query
+type orderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Execute runs a query. Syntax and validation errors return a result
+// without data, field errors null the field and are listed with the data.
+func (s *Schema) Execute(ctx context.Context, req Request) *Result {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+	if op.kind != "query" {
+		return &Result{Errors: []*Error{{Message: fmt.Sprintf("%s operations are not supported", op.kind)}}}
+	}
+	vars, err := coerceVariables(op, req.Variables)
+	if err != nil {
+		return &Result{Errors: []*Error{toError(err)}}
+	}
+
+	e := &executor{schema: s, doc: doc, vars: vars, ctx: ctx}
+	maxDepth := s.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if errs := e.validate(s.Query, op.selections, 1, maxDepth, map[string]bool{}); len(errs) > 0 {
+		return &Result{Errors: errs}
+	}
+	data := e.executeObject(s.Query, nil, op.selections, nil)
+	return &Result{Data: data, Errors: e.errors}
+}
+
+func toError(err error) *Error {
+	if gqlErr, ok := err.(*Error); ok {
+		return gqlErr
+	}
+	return &Error{Message: err.Error()}
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, &Error{Message: "operationName is required with several operations"}
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation %q", name)}
+}
+
+func coerceVariables(op *operation, values map[string]interface{}) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+	for _, def := range op.vars {
+		value, ok := values[def.name]
+		switch {
+		case ok:
+			vars[def.name] = value
+		case def.hasDefault:
+			vars[def.name] = def.defaultVal
+		}
+		if def.nonNull && vars[def.name] == nil {
+			return nil, &Error{Message: fmt.Sprintf("Variable $%s is required", def.name)}
+		}
+	}
+	return vars, nil
+}
+
+type executor struct {
+	schema *Schema
+	doc    *document
+	vars   map[string]interface{}
+	ctx    context.Context
+	errors []*Error
+}
+
+// validate checks the fields, arguments, variables and fragments of a
+// selection set on t, and the depth of the query
+func (e *executor) validate(t *Object, sels []selection, depth int, maxDepth int, spreading map[string]bool) []*Error {
+	if depth > maxDepth {
+		return []*Error{{Message: fmt.Sprintf("The query is nested deeper than %d levels", maxDepth)}}
+	}
+	var errs []*Error
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			errs = append(errs, e.validateField(t, sel, depth, maxDepth, spreading)...)
+		case *fragmentSpread:
+			frag, ok := e.doc.fragments[sel.name]
+			if !ok {
+				errs = append(errs, &Error{Message: fmt.Sprintf("Unknown fragment %q", sel.name)})
+				continue
+			}
+			if spreading[sel.name] {
+				errs = append(errs, &Error{Message: fmt.Sprintf("Fragment %q spreads itself", sel.name)})
+				continue
+			}
+			spreading[sel.name] = true
+			errs = append(errs, e.validateDirectives(sel.directives)...)
+			errs = append(errs, e.validateFragment(t, frag.typeCond, frag.selections, depth, maxDepth, spreading)...)
+			delete(spreading, sel.name)
+		case *inlineFragment:
+			errs = append(errs, e.validateDirectives(sel.directives)...)
+			errs = append(errs, e.validateFragment(t, sel.typeCond, sel.selections, depth, maxDepth, spreading)...)
+		}
+	}
+	return errs
+}
+
+func (e *executor) validateFragment(t *Object, typeCond string, sels []selection, depth int, maxDepth int, spreading map[string]bool) []*Error {
+	if typeCond != "" && typeCond != t.Name {
+		return []*Error{{Message: fmt.Sprintf("Fragment on %s cannot be spread on %s", typeCond, t.Name)}}
+	}
+	return e.validate(t, sels, depth, maxDepth, spreading)
+}
+
+func (e *executor) validateField(t *Object, f *field, depth int, maxDepth int, spreading map[string]bool) []*Error {
+	errs := e.validateDirectives(f.directives)
+	if f.name == "__typename" {
+		return errs
+	}
+	def, ok := t.Fields[f.name]
+	if !ok {
+		return append(errs, &Error{Message: fmt.Sprintf("Cannot query field %q on type %s", f.name, t.Name)})
+	}
+	for name, value := range f.args {
+		if !contains(def.Args, name) {
+			errs = append(errs, &Error{Message: fmt.Sprintf("Unknown argument %q on field %s.%s", name, t.Name, f.name)})
+		}
+		errs = append(errs, e.validateValue(value)...)
+	}
+	switch {
+	case def.Type == nil && len(f.selections) > 0:
+		errs = append(errs, &Error{Message: fmt.Sprintf("Field %s.%s has no subfields", t.Name, f.name)})
+	case def.Type != nil && len(f.selections) == 0:
+		errs = append(errs, &Error{Message: fmt.Sprintf("Field %s.%s of type %s must have a selection of subfields", t.Name, f.name, def.Type.Name)})
+	case def.Type != nil:
+		errs = append(errs, e.validate(def.Type, f.selections, depth+1, maxDepth, spreading)...)
+	}
+	return errs
+}
+
+func (e *executor) validateDirectives(dirs []*directive) []*Error {
+	var errs []*Error
+	for _, dir := range dirs {
+		if dir.name != "include" && dir.name != "skip" {
+			errs = append(errs, &Error{Message: fmt.Sprintf("Unknown directive @%s", dir.name)})
+			continue
+		}
+		if _, ok := e.value(dir.args["if"]).(bool); !ok {
+			errs = append(errs, &Error{Message: fmt.Sprintf("Directive @%s needs a boolean if argument", dir.name)})
+		}
+	}
+	return errs
+}
+
+// validateValue checks that the variables of a value are defined
+func (e *executor) validateValue(value interface{}) []*Error {
+	var errs []*Error
+	switch v := value.(type) {
+	case variable:
+		if _, ok := e.vars[string(v)]; !ok {
+			errs = append(errs, &Error{Message: fmt.Sprintf("Variable $%s is not defined", v)})
+		}
+	case []interface{}:
+		for _, item := range v {
+			errs = append(errs, e.validateValue(item)...)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			errs = append(errs, e.validateValue(item)...)
+		}
+	}
+	return errs
+}
+
+// value substitutes the variables of an argument value and turns the enum
+// values into strings
+func (e *executor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case variable:
+		return e.vars[string(v)]
+	case enumValue:
+		return string(v)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.value(item)
+		}
+		return list
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			obj[key] = e.value(item)
+		}
+		return obj
+	}
+	return value
+}
+
+// included applies the @include and @skip directives
+func (e *executor) included(dirs []*directive) bool {
+	for _, dir := range dirs {
+		cond, _ := e.value(dir.args["if"]).(bool)
+		if dir.name == "include" && !cond || dir.name == "skip" && cond {
+			return false
+		}
+	}
+	return true
+}
+
+// collectFields returns the fields of a selection set by response key,
+// merging the fields of the same key, in the order of the query
+func (e *executor) collectFields(sels []selection, keys *[]string, fields map[string][]*field) {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			key := sel.alias
+			if key == "" {
+				key = sel.name
+			}
+			if _, ok := fields[key]; !ok {
+				*keys = append(*keys, key)
+			}
+			fields[key] = append(fields[key], sel)
+		case *fragmentSpread:
+			if e.included(sel.directives) {
+				e.collectFields(e.doc.fragments[sel.name].selections, keys, fields)
+			}
+		case *inlineFragment:
+			if e.included(sel.directives) {
+				e.collectFields(sel.selections, keys, fields)
+			}
+		}
+	}
+}
+
+func (e *executor) executeObject(t *Object, source interface{}, sels []selection, path []interface{}) *orderedMap {
+	var keys []string
+	fields := map[string][]*field{}
+	e.collectFields(sels, &keys, fields)
+
+	result := &orderedMap{values: make(map[string]interface{}, len(keys))}
+	for _, key := range keys {
+		f := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if f.name == "__typename" {
+			result.set(key, t.Name)
+			continue
+		}
+		result.set(key, e.executeField(t.Fields[f.name], source, fields[key], fieldPath))
+	}
+	return result
+}
+
+func (e *executor) executeField(def *Field, source interface{}, fields []*field, path []interface{}) interface{} {
+	args := map[string]interface{}{}
+	for name, value := range fields[0].args {
+		args[name] = e.value(value)
+	}
+	value, err := def.Resolve(ResolveParams{Context: e.ctx, Source: source, Args: args})
+	if err != nil {
+		e.errors = append(e.errors, &Error{Message: err.Error(), Path: path})
+		return nil
+	}
+	if def.Type == nil || value == nil {
+		return value
+	}
+
+	var sels []selection
+	for _, f := range fields {
+		sels = append(sels, f.selections...)
+	}
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		return e.executeObject(def.Type, value, sels, path)
+	}
+	list := make([]interface{}, rv.Len())
+	for i := range list {
+		item := rv.Index(i)
+		if item.Kind() == reflect.Ptr && item.IsNil() {
+			continue
+		}
+		if item.Kind() != reflect.Ptr && item.CanAddr() {
+			item = item.Addr()
+		}
+		list[i] = e.executeObject(def.Type, item.Interface(), sels, append(append([]interface{}{}, path...), i))
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the types of the schema, with the arguments of their
+// fields
+func (s *Schema) String() string {
+	var types []*Object
+	seen := map[*Object]bool{}
+	var walk func(t *Object)
+	walk = func(t *Object) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		types = append(types, t)
+		for _, f := range t.Fields {
+			if f.Type != nil {
+				walk(f.Type)
+			}
+		}
+	}
+	walk(s.Query)
+	sort.Slice(types[1:], func(i, j int) bool { return types[i+1].Name < types[j+1].Name })
+
+	var sb strings.Builder
+	for _, t := range types {
+		names := make([]string, 0, len(t.Fields))
+		for name := range t.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(&sb, "type %s {\n", t.Name)
+		for _, name := range names {
+			f := t.Fields[name]
+			sb.WriteString("  " + name)
+			if len(f.Args) > 0 {
+				sb.WriteString("(" + strings.Join(f.Args, ", ") + ")")
+			}
+			if f.Type != nil {
+				sb.WriteString(": " + f.Type.Name)
+			}
+			sb.WriteString("\n")
+		}
+		sb.WriteString("}\n\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,90 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// RequestIDHeader is the HTTP header carrying the request ID of an operator
+// action, RequestIDMetadataKey the gRPC metadata key it is forwarded under
+const (
+	RequestIDHeader      = "X-Request-ID"
+	RequestIDMetadataKey = "x-request-id"
+)
+
+type requestIDKey struct{}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%016x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// WithRequestID returns a copy of ctx carrying a request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, empty if there is none
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Logger logs records tagged with the request ID of an operator action
+type Logger struct {
+	requestID string
+}
+
+// ForRequest returns a logger tagging its records with a request ID
+func ForRequest(id string) Logger {
+	return Logger{requestID: id}
+}
+
+// FromContext returns a logger tagging its records with the request ID of ctx
+func FromContext(ctx context.Context) Logger {
+	return ForRequest(RequestID(ctx))
+}
+
+// Debugf logs a debug record for the module of the caller
+func (l Logger) Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, l.requestID, format, args...)
+}
+
+// Infof logs an info record for the module of the caller
+func (l Logger) Infof(format string, args ...interface{}) {
+	logf(LevelInfo, l.requestID, format, args...)
+}
+
+// Warnf logs a warning record for the module of the caller
+func (l Logger) Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, l.requestID, format, args...)
+}
+
+// Errorf logs an error record for the module of the caller
+func (l Logger) Errorf(format string, args ...interface{}) {
+	logf(LevelError, l.requestID, format, args...)
+}
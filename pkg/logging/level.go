@@ -0,0 +1,245 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log record
+type Level int
+
+// Log levels, in increasing severity
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, an empty name is info
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %s", name)
+}
+
+// packageModules names the module of the packages whose directory does not
+// match the service name
+var packageModules = map[string]string{
+	"cwmp": "acs",
+}
+
+// record is a log record in the JSON format, the field names are stable
+type record struct {
+	Time      string `json:"time"`
+	Level     string `json:"level"`
+	Module    string `json:"module"`
+	Caller    string `json:"caller"`
+	RequestID string `json:"request_id,omitempty"`
+	Message   string `json:"msg"`
+}
+
+// handler filters log records by module level and writes them in the
+// configured format
+type handler struct {
+	mu      sync.Mutex
+	out     io.Writer // nil until Setup, records then go to the standard logger
+	json    bool
+	level   Level
+	modules map[string]Level
+}
+
+var std = &handler{level: LevelInfo}
+
+// enabled reports whether records of a level are logged for a module. The
+// most specific module override applies, acs.session before acs.
+func (h *handler) enabled(module string, level Level) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for name := module; name != ""; {
+		if min, ok := h.modules[name]; ok {
+			return level >= min
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return level >= h.level
+}
+
+func (h *handler) emit(level Level, module string, caller string, requestID string, msg string) {
+	msg = strings.TrimSuffix(msg, "\n")
+	text := msg
+	if requestID != "" {
+		text = "[" + requestID + "] " + msg
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.out == nil {
+		log.Print(text)
+		return
+	}
+	now := time.Now()
+	if h.json {
+		line, _ := json.Marshal(record{
+			Time:      now.Format(time.RFC3339Nano),
+			Level:     level.String(),
+			Module:    module,
+			Caller:    caller,
+			RequestID: requestID,
+			Message:   msg,
+		})
+		h.out.Write(append(line, '\n'))
+		return
+	}
+	fmt.Fprintf(h.out, "%s %-5s %s: %s\n", now.Format("2006/01/02 15:04:05"), strings.ToUpper(level.String()), caller, text)
+}
+
+// moduleOf names the module of a source file after its package and file,
+// internal/cwmp/session.go logs as acs.session
+func moduleOf(path string) string {
+	pkg := filepath.Base(filepath.Dir(path))
+	if name, ok := packageModules[pkg]; ok {
+		pkg = name
+	}
+	return pkg + "." + strings.TrimSuffix(filepath.Base(path), ".go")
+}
+
+func logf(level Level, requestID string, format string, args ...interface{}) {
+	module, caller := "", ""
+	if _, file, line, ok := runtime.Caller(2); ok {
+		module = moduleOf(file)
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+	if !std.enabled(module, level) {
+		return
+	}
+	std.emit(level, module, caller, requestID, fmt.Sprintf(format, args...))
+}
+
+// Debugf logs a debug record for the module of the caller
+func Debugf(format string, args ...interface{}) {
+	logf(LevelDebug, "", format, args...)
+}
+
+// Infof logs an info record for the module of the caller
+func Infof(format string, args ...interface{}) {
+	logf(LevelInfo, "", format, args...)
+}
+
+// Warnf logs a warning record for the module of the caller
+func Warnf(format string, args ...interface{}) {
+	logf(LevelWarn, "", format, args...)
+}
+
+// Errorf logs an error record for the module of the caller
+func Errorf(format string, args ...interface{}) {
+	logf(LevelError, "", format, args...)
+}
+
+// stdBridge receives the lines of the standard logger, configured with
+// log.Llongfile, and turns them into records so that the log.Printf calls
+// are filtered and formatted like the leveled ones. Their level is guessed
+// from the message.
+type stdBridge struct{}
+
+func (stdBridge) Write(p []byte) (int, error) {
+	line := strings.TrimPrefix(string(p), log.Prefix())
+	module, caller, msg := "", "", line
+	// path/to/file.go:123: message
+	if i := strings.Index(line, ".go:"); i >= 0 {
+		if j := strings.Index(line[i:], ": "); j >= 0 {
+			file := line[:i+3]
+			module = moduleOf(file)
+			caller = filepath.Base(file) + line[i+3:i+j]
+			msg = line[i+j+2:]
+		}
+	}
+	level := guessLevel(msg)
+	if std.enabled(module, level) {
+		std.emit(level, module, caller, "", msg)
+	}
+	return len(p), nil
+}
+
+func guessLevel(msg string) Level {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.HasPrefix(lower, "error"), strings.HasPrefix(lower, "failed"), strings.HasPrefix(lower, "fatal"):
+		return LevelError
+	case strings.HasPrefix(lower, "warning"), strings.HasPrefix(lower, "warn:"):
+		return LevelWarn
+	case strings.HasPrefix(lower, "debug"):
+		return LevelDebug
+	}
+	return LevelInfo
+}
+
+// configure applies the level and format settings to the shared handler
+func (h *handler) configure(out io.Writer, format string, level string, modules map[string]string) error {
+	defaultLevel, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	moduleLevels := make(map[string]Level, len(modules))
+	for name, l := range modules {
+		if moduleLevels[name], err = ParseLevel(l); err != nil {
+			return fmt.Errorf("module %s: %w", name, err)
+		}
+	}
+
+	h.mu.Lock()
+	h.out = out
+	h.json = format == "json"
+	h.level = defaultLevel
+	h.modules = moduleLevels
+	h.mu.Unlock()
+
+	log.SetFlags(log.Llongfile)
+	log.SetOutput(stdBridge{})
+	return nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging configures the logger shared by the OpenUSP services:
+// output, text or JSON format, and levels per module. A module is named
+// after the package and file logging, e.g. acs.session or db.conn.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/n4-networks/openusp/pkg/config"
+)
+
+// Outputs selectable with logging.output
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+)
+
+// Setup directs the logs to the output of the logging config, in its format
+// and filtered by its levels. The file output rotates logging.file according
+// to maxSize, maxBackups, maxAge and compress. The log.Printf calls go
+// through the same levels and format.
+func Setup(cfg config.LoggingConfig) error {
+	var out io.Writer
+	switch cfg.Output {
+	case OutputStdout, "":
+		out = os.Stdout
+	case OutputStderr:
+		out = os.Stderr
+	case OutputFile:
+		if cfg.File == "" {
+			return errors.New("logging output is file but no file is configured")
+		}
+		file := &RotatingFile{
+			Filename:   cfg.File,
+			MaxSize:    cfg.MaxSize,
+			MaxBackups: cfg.MaxBackups,
+			MaxAge:     cfg.MaxAge,
+			Compress:   cfg.Compress,
+		}
+		if err := file.open(); err != nil {
+			return err
+		}
+		out = file
+	default:
+		return fmt.Errorf("unknown logging output: %s", cfg.Output)
+	}
+	return std.configure(out, cfg.Format, cfg.Level, cfg.Modules)
+}
@@ -0,0 +1,201 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp added to rotated files, it sorts in
+// chronological order
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+const megabyte = 1024 * 1024
+
+// RotatingFile is an io.Writer to a file which is rotated once it reaches
+// MaxSize. Rotated files are renamed with a timestamp, optionally compressed,
+// and removed when there are more than MaxBackups of them or they are older
+// than MaxAge.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int // megabytes, 0 disables rotation
+	MaxBackups int // 0 keeps all rotated files
+	MaxAge     int // days, 0 keeps all rotated files
+	Compress   bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	// cleanupMu serializes the compression and removal of rotated files
+	cleanupMu sync.Mutex
+}
+
+// Write writes p to the file, rotating it first if p would exceed MaxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > int64(r.MaxSize)*megabyte {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.openLocked()
+}
+
+func (r *RotatingFile) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(r.Filename), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(r.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp and starts a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	ext := filepath.Ext(r.Filename)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.Filename, ext), time.Now().Format(backupTimeFormat), ext)
+	if err := os.Rename(r.Filename, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.openLocked(); err != nil {
+		return err
+	}
+	go r.cleanup(backup)
+	return nil
+}
+
+// cleanup compresses the file just rotated and removes the rotated files
+// exceeding MaxBackups or MaxAge
+func (r *RotatingFile) cleanup(backup string) {
+	r.cleanupMu.Lock()
+	defer r.cleanupMu.Unlock()
+
+	if r.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "Error compressing log file %s: %v\n", backup, err)
+		}
+	}
+	if r.MaxBackups == 0 && r.MaxAge == 0 {
+		return
+	}
+
+	ext := filepath.Ext(r.Filename)
+	prefix := strings.TrimSuffix(r.Filename, ext) + "-"
+	matches, err := filepath.Glob(prefix + "*")
+	if err != nil {
+		return
+	}
+	backups := matches[:0]
+	for _, name := range matches {
+		stamp := strings.TrimPrefix(name, prefix)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)]); err == nil {
+			backups = append(backups, name)
+		}
+	}
+	// Newest first, the timestamps sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	cutoff := time.Now().AddDate(0, 0, -r.MaxAge)
+	for i, name := range backups {
+		expired := r.MaxBackups > 0 && i >= r.MaxBackups
+		if r.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && info.ModTime().Before(cutoff) {
+				expired = true
+			}
+		}
+		if expired {
+			os.Remove(name)
+		}
+	}
+}
+
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
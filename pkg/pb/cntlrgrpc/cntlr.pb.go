@@ -1,6 +1,20 @@
+// Copyright 2023 N4-Networks.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
-// 	protoc-gen-go v1.28.1
+// 	protoc-gen-go v1.31.0
 // 	protoc        v3.13.0
 // source: cntlr.proto
 
@@ -606,7 +620,6 @@ type OperateResData struct {
 	MsgId     string `protobuf:"bytes,3,opt,name=msgId,proto3" json:"msgId,omitempty"`
 	Cmd       string `protobuf:"bytes,4,opt,name=cmd,proto3" json:"cmd,omitempty"`
 	// Types that are assignable to Resp:
-	//
 	//	*OperateResData_Path
 	//	*OperateResData_Args
 	//	*OperateResData_ErrMsg
@@ -967,6 +980,53 @@ func (x *InfoData) GetVersion() string {
 	return ""
 }
 
+type AgentsData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	AgentIds []string `protobuf:"bytes,1,rep,name=agentIds,proto3" json:"agentIds,omitempty"`
+}
+
+func (x *AgentsData) Reset() {
+	*x = AgentsData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[13]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *AgentsData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentsData) ProtoMessage() {}
+
+func (x *AgentsData) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[13]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentsData.ProtoReflect.Descriptor instead.
+func (*AgentsData) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *AgentsData) GetAgentIds() []string {
+	if x != nil {
+		return x.AgentIds
+	}
+	return nil
+}
+
 type None struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -976,7 +1036,7 @@ type None struct {
 func (x *None) Reset() {
 	*x = None{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_cntlr_proto_msgTypes[13]
+		mi := &file_cntlr_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -989,7 +1049,7 @@ func (x *None) String() string {
 func (*None) ProtoMessage() {}
 
 func (x *None) ProtoReflect() protoreflect.Message {
-	mi := &file_cntlr_proto_msgTypes[13]
+	mi := &file_cntlr_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1002,7 +1062,378 @@ func (x *None) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use None.ProtoReflect.Descriptor instead.
 func (*None) Descriptor() ([]byte, []int) {
-	return file_cntlr_proto_rawDescGZIP(), []int{13}
+	return file_cntlr_proto_rawDescGZIP(), []int{14}
+}
+
+type CwmpSetParamsReqData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DeviceId string                        `protobuf:"bytes,1,opt,name=deviceId,proto3" json:"deviceId,omitempty"`
+	Params   []*CwmpSetParamsReqData_Param `protobuf:"bytes,2,rep,name=params,proto3" json:"params,omitempty"`
+	ParamKey string                        `protobuf:"bytes,3,opt,name=paramKey,proto3" json:"paramKey,omitempty"`
+}
+
+func (x *CwmpSetParamsReqData) Reset() {
+	*x = CwmpSetParamsReqData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[15]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpSetParamsReqData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpSetParamsReqData) ProtoMessage() {}
+
+func (x *CwmpSetParamsReqData) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[15]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpSetParamsReqData.ProtoReflect.Descriptor instead.
+func (*CwmpSetParamsReqData) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *CwmpSetParamsReqData) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *CwmpSetParamsReqData) GetParams() []*CwmpSetParamsReqData_Param {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *CwmpSetParamsReqData) GetParamKey() string {
+	if x != nil {
+		return x.ParamKey
+	}
+	return ""
+}
+
+type CwmpRebootReqData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DeviceId string `protobuf:"bytes,1,opt,name=deviceId,proto3" json:"deviceId,omitempty"`
+	CmdKey   string `protobuf:"bytes,2,opt,name=cmdKey,proto3" json:"cmdKey,omitempty"`
+}
+
+func (x *CwmpRebootReqData) Reset() {
+	*x = CwmpRebootReqData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[16]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpRebootReqData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpRebootReqData) ProtoMessage() {}
+
+func (x *CwmpRebootReqData) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[16]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpRebootReqData.ProtoReflect.Descriptor instead.
+func (*CwmpRebootReqData) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *CwmpRebootReqData) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *CwmpRebootReqData) GetCmdKey() string {
+	if x != nil {
+		return x.CmdKey
+	}
+	return ""
+}
+
+type CwmpFactoryResetReqData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DeviceId string `protobuf:"bytes,1,opt,name=deviceId,proto3" json:"deviceId,omitempty"`
+}
+
+func (x *CwmpFactoryResetReqData) Reset() {
+	*x = CwmpFactoryResetReqData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpFactoryResetReqData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpFactoryResetReqData) ProtoMessage() {}
+
+func (x *CwmpFactoryResetReqData) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpFactoryResetReqData.ProtoReflect.Descriptor instead.
+func (*CwmpFactoryResetReqData) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CwmpFactoryResetReqData) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+type CwmpTransferReqData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DeviceId       string `protobuf:"bytes,1,opt,name=deviceId,proto3" json:"deviceId,omitempty"`
+	CmdKey         string `protobuf:"bytes,2,opt,name=cmdKey,proto3" json:"cmdKey,omitempty"`
+	FileType       string `protobuf:"bytes,3,opt,name=fileType,proto3" json:"fileType,omitempty"`
+	Url            string `protobuf:"bytes,4,opt,name=url,proto3" json:"url,omitempty"`
+	FileId         string `protobuf:"bytes,5,opt,name=fileId,proto3" json:"fileId,omitempty"`
+	Username       string `protobuf:"bytes,6,opt,name=username,proto3" json:"username,omitempty"`
+	Password       string `protobuf:"bytes,7,opt,name=password,proto3" json:"password,omitempty"`
+	FileSize       uint32 `protobuf:"varint,8,opt,name=fileSize,proto3" json:"fileSize,omitempty"`
+	TargetFileName string `protobuf:"bytes,9,opt,name=targetFileName,proto3" json:"targetFileName,omitempty"`
+	DelaySeconds   uint32 `protobuf:"varint,10,opt,name=delaySeconds,proto3" json:"delaySeconds,omitempty"`
+	SuccessUrl     string `protobuf:"bytes,11,opt,name=successUrl,proto3" json:"successUrl,omitempty"`
+	FailureUrl     string `protobuf:"bytes,12,opt,name=failureUrl,proto3" json:"failureUrl,omitempty"`
+}
+
+func (x *CwmpTransferReqData) Reset() {
+	*x = CwmpTransferReqData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpTransferReqData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpTransferReqData) ProtoMessage() {}
+
+func (x *CwmpTransferReqData) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpTransferReqData.ProtoReflect.Descriptor instead.
+func (*CwmpTransferReqData) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *CwmpTransferReqData) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetCmdKey() string {
+	if x != nil {
+		return x.CmdKey
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetFileType() string {
+	if x != nil {
+		return x.FileType
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetFileId() string {
+	if x != nil {
+		return x.FileId
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetUsername() string {
+	if x != nil {
+		return x.Username
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetPassword() string {
+	if x != nil {
+		return x.Password
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetFileSize() uint32 {
+	if x != nil {
+		return x.FileSize
+	}
+	return 0
+}
+
+func (x *CwmpTransferReqData) GetTargetFileName() string {
+	if x != nil {
+		return x.TargetFileName
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetDelaySeconds() uint32 {
+	if x != nil {
+		return x.DelaySeconds
+	}
+	return 0
+}
+
+func (x *CwmpTransferReqData) GetSuccessUrl() string {
+	if x != nil {
+		return x.SuccessUrl
+	}
+	return ""
+}
+
+func (x *CwmpTransferReqData) GetFailureUrl() string {
+	if x != nil {
+		return x.FailureUrl
+	}
+	return ""
+}
+
+type CwmpCmdResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	IsSuccess bool   `protobuf:"varint,1,opt,name=isSuccess,proto3" json:"isSuccess,omitempty"`
+	ErrMsg    string `protobuf:"bytes,2,opt,name=errMsg,proto3" json:"errMsg,omitempty"`
+	DeviceId  string `protobuf:"bytes,3,opt,name=deviceId,proto3" json:"deviceId,omitempty"`
+	Method    string `protobuf:"bytes,4,opt,name=method,proto3" json:"method,omitempty"`
+}
+
+func (x *CwmpCmdResult) Reset() {
+	*x = CwmpCmdResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpCmdResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpCmdResult) ProtoMessage() {}
+
+func (x *CwmpCmdResult) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpCmdResult.ProtoReflect.Descriptor instead.
+func (*CwmpCmdResult) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *CwmpCmdResult) GetIsSuccess() bool {
+	if x != nil {
+		return x.IsSuccess
+	}
+	return false
+}
+
+func (x *CwmpCmdResult) GetErrMsg() string {
+	if x != nil {
+		return x.ErrMsg
+	}
+	return ""
+}
+
+func (x *CwmpCmdResult) GetDeviceId() string {
+	if x != nil {
+		return x.DeviceId
+	}
+	return ""
+}
+
+func (x *CwmpCmdResult) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
 }
 
 type SetParamResData_Param struct {
@@ -1017,7 +1448,7 @@ type SetParamResData_Param struct {
 func (x *SetParamResData_Param) Reset() {
 	*x = SetParamResData_Param{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_cntlr_proto_msgTypes[14]
+		mi := &file_cntlr_proto_msgTypes[20]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1030,7 +1461,7 @@ func (x *SetParamResData_Param) String() string {
 func (*SetParamResData_Param) ProtoMessage() {}
 
 func (x *SetParamResData_Param) ProtoReflect() protoreflect.Message {
-	mi := &file_cntlr_proto_msgTypes[14]
+	mi := &file_cntlr_proto_msgTypes[20]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1072,7 +1503,7 @@ type AddInstanceReqData_Object struct {
 func (x *AddInstanceReqData_Object) Reset() {
 	*x = AddInstanceReqData_Object{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_cntlr_proto_msgTypes[15]
+		mi := &file_cntlr_proto_msgTypes[21]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1085,7 +1516,7 @@ func (x *AddInstanceReqData_Object) String() string {
 func (*AddInstanceReqData_Object) ProtoMessage() {}
 
 func (x *AddInstanceReqData_Object) ProtoReflect() protoreflect.Message {
-	mi := &file_cntlr_proto_msgTypes[15]
+	mi := &file_cntlr_proto_msgTypes[21]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1127,7 +1558,7 @@ type AddInstanceResData_Instance struct {
 func (x *AddInstanceResData_Instance) Reset() {
 	*x = AddInstanceResData_Instance{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_cntlr_proto_msgTypes[17]
+		mi := &file_cntlr_proto_msgTypes[23]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1140,7 +1571,7 @@ func (x *AddInstanceResData_Instance) String() string {
 func (*AddInstanceResData_Instance) ProtoMessage() {}
 
 func (x *AddInstanceResData_Instance) ProtoReflect() protoreflect.Message {
-	mi := &file_cntlr_proto_msgTypes[17]
+	mi := &file_cntlr_proto_msgTypes[23]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1181,7 +1612,7 @@ type OperateResData_OutputArgs struct {
 func (x *OperateResData_OutputArgs) Reset() {
 	*x = OperateResData_OutputArgs{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_cntlr_proto_msgTypes[20]
+		mi := &file_cntlr_proto_msgTypes[26]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -1194,7 +1625,7 @@ func (x *OperateResData_OutputArgs) String() string {
 func (*OperateResData_OutputArgs) ProtoMessage() {}
 
 func (x *OperateResData_OutputArgs) ProtoReflect() protoreflect.Message {
-	mi := &file_cntlr_proto_msgTypes[20]
+	mi := &file_cntlr_proto_msgTypes[26]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1217,6 +1648,69 @@ func (x *OperateResData_OutputArgs) GetOutputs() map[string]string {
 	return nil
 }
 
+type CwmpSetParamsReqData_Param struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Type  string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+}
+
+func (x *CwmpSetParamsReqData_Param) Reset() {
+	*x = CwmpSetParamsReqData_Param{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_cntlr_proto_msgTypes[28]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CwmpSetParamsReqData_Param) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CwmpSetParamsReqData_Param) ProtoMessage() {}
+
+func (x *CwmpSetParamsReqData_Param) ProtoReflect() protoreflect.Message {
+	mi := &file_cntlr_proto_msgTypes[28]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CwmpSetParamsReqData_Param.ProtoReflect.Descriptor instead.
+func (*CwmpSetParamsReqData_Param) Descriptor() ([]byte, []int) {
+	return file_cntlr_proto_rawDescGZIP(), []int{15, 0}
+}
+
+func (x *CwmpSetParamsReqData_Param) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CwmpSetParamsReqData_Param) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *CwmpSetParamsReqData_Param) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
 var File_cntlr_proto protoreflect.FileDescriptor
 
 var file_cntlr_proto_rawDesc = []byte{
@@ -1365,56 +1859,139 @@ var file_cntlr_proto_rawDesc = []byte{
 	0x61, 0x67, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61,
 	0x67, 0x65, 0x6e, 0x74, 0x49, 0x64, 0x22, 0x24, 0x0a, 0x08, 0x49, 0x6e, 0x66, 0x6f, 0x44, 0x61,
 	0x74, 0x61, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x06, 0x0a, 0x04,
-	0x4e, 0x6f, 0x6e, 0x65, 0x32, 0xc7, 0x05, 0x0a, 0x04, 0x47, 0x72, 0x70, 0x63, 0x12, 0x41, 0x0a,
-	0x0b, 0x47, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x12, 0x1a, 0x2e, 0x63,
+	0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x28, 0x0a, 0x0a,
+	0x41, 0x67, 0x65, 0x6e, 0x74, 0x73, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x67,
+	0x65, 0x6e, 0x74, 0x49, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x08, 0x61, 0x67,
+	0x65, 0x6e, 0x74, 0x49, 0x64, 0x73, 0x22, 0x06, 0x0a, 0x04, 0x4e, 0x6f, 0x6e, 0x65, 0x22, 0xd4,
+	0x01, 0x0a, 0x14, 0x43, 0x77, 0x6d, 0x70, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73,
+	0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x49, 0x64, 0x12, 0x3d, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x02, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x25, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e,
+	0x43, 0x77, 0x6d, 0x70, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x65, 0x71,
+	0x44, 0x61, 0x74, 0x61, 0x2e, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61,
+	0x6d, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x4b, 0x65, 0x79, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x4b, 0x65, 0x79, 0x1a, 0x45,
+	0x0a, 0x05, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x74, 0x79, 0x70, 0x65, 0x22, 0x47, 0x0a, 0x11, 0x43, 0x77, 0x6d, 0x70, 0x52, 0x65, 0x62,
+	0x6f, 0x6f, 0x74, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6d, 0x64, 0x4b, 0x65, 0x79,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6d, 0x64, 0x4b, 0x65, 0x79, 0x22, 0x35,
+	0x0a, 0x17, 0x43, 0x77, 0x6d, 0x70, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73,
+	0x65, 0x74, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x49, 0x64, 0x22, 0xef, 0x02, 0x0a, 0x13, 0x43, 0x77, 0x6d, 0x70, 0x54, 0x72,
+	0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a,
+	0x08, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x08, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6d, 0x64,
+	0x4b, 0x65, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6d, 0x64, 0x4b, 0x65,
+	0x79, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x54, 0x79, 0x70, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x75, 0x72, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x75, 0x72, 0x6c, 0x12,
+	0x16, 0x0a, 0x06, 0x66, 0x69, 0x6c, 0x65, 0x49, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x06, 0x66, 0x69, 0x6c, 0x65, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e,
+	0x61, 0x6d, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x75, 0x73, 0x65, 0x72, 0x6e,
+	0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x70, 0x61, 0x73, 0x73, 0x77, 0x6f, 0x72, 0x64, 0x12,
+	0x1a, 0x0a, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x26, 0x0a, 0x0e, 0x74,
+	0x61, 0x72, 0x67, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x09, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0e, 0x74, 0x61, 0x72, 0x67, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x4e,
+	0x61, 0x6d, 0x65, 0x12, 0x22, 0x0a, 0x0c, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x53, 0x65, 0x63, 0x6f,
+	0x6e, 0x64, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x64, 0x65, 0x6c, 0x61, 0x79,
+	0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x73, 0x75, 0x63, 0x63, 0x65,
+	0x73, 0x73, 0x55, 0x72, 0x6c, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x73, 0x75, 0x63,
+	0x63, 0x65, 0x73, 0x73, 0x55, 0x72, 0x6c, 0x12, 0x1e, 0x0a, 0x0a, 0x66, 0x61, 0x69, 0x6c, 0x75,
+	0x72, 0x65, 0x55, 0x72, 0x6c, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x66, 0x61, 0x69,
+	0x6c, 0x75, 0x72, 0x65, 0x55, 0x72, 0x6c, 0x22, 0x79, 0x0a, 0x0d, 0x43, 0x77, 0x6d, 0x70, 0x43,
+	0x6d, 0x64, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x73, 0x53, 0x75,
+	0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x69, 0x73, 0x53,
+	0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x65, 0x72, 0x72, 0x4d, 0x73, 0x67,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x65, 0x72, 0x72, 0x4d, 0x73, 0x67, 0x12, 0x1a,
+	0x0a, 0x08, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x6d, 0x65,
+	0x74, 0x68, 0x6f, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6d, 0x65, 0x74, 0x68,
+	0x6f, 0x64, 0x32, 0x8d, 0x09, 0x0a, 0x04, 0x47, 0x72, 0x70, 0x63, 0x12, 0x41, 0x0a, 0x0b, 0x47,
+	0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x12, 0x1a, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52,
+	0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
+	0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x47,
+	0x0a, 0x0b, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x12, 0x1a, 0x2e,
+	0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72,
+	0x61, 0x6d, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x1a, 0x2e, 0x63, 0x6e, 0x74, 0x72,
+	0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65,
+	0x73, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x49, 0x0a, 0x0f, 0x47, 0x65, 0x74, 0x49, 0x6e,
+	0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x12, 0x1e, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e,
+	0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74,
+	0x22, 0x00, 0x12, 0x50, 0x0a, 0x0e, 0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63,
+	0x65, 0x52, 0x65, 0x71, 0x12, 0x1d, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63,
+	0x2e, 0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x44,
+	0x61, 0x74, 0x61, 0x1a, 0x1d, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e,
+	0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73, 0x44, 0x61,
+	0x74, 0x61, 0x22, 0x00, 0x12, 0x44, 0x0a, 0x0a, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x65, 0x52,
+	0x65, 0x71, 0x12, 0x19, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x4f,
+	0x70, 0x65, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x19, 0x2e,
+	0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74,
+	0x65, 0x52, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x49, 0x0a, 0x0f, 0x47, 0x65,
+	0x74, 0x44, 0x61, 0x74, 0x61, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x12, 0x1e, 0x2e,
+	0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x44, 0x61, 0x74,
+	0x61, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e,
+	0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73,
+	0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x4d, 0x0a, 0x11, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49,
+	0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x12, 0x20, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49, 0x6e, 0x73,
+	0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63,
+	0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75,
+	0x6c, 0x74, 0x22, 0x00, 0x12, 0x43, 0x0a, 0x0c, 0x47, 0x65, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74,
+	0x4d, 0x73, 0x67, 0x73, 0x12, 0x1b, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63,
+	0x2e, 0x47, 0x65, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x4d, 0x73, 0x67, 0x73, 0x44, 0x61, 0x74,
+	0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65,
+	0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x07, 0x47, 0x65, 0x74,
+	0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0f, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63,
+	0x2e, 0x4e, 0x6f, 0x6e, 0x65, 0x1a, 0x13, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70,
+	0x63, 0x2e, 0x49, 0x6e, 0x66, 0x6f, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x35, 0x0a, 0x09,
+	0x47, 0x65, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x0f, 0x2e, 0x63, 0x6e, 0x74, 0x72,
+	0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x6e, 0x65, 0x1a, 0x15, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x73, 0x44, 0x61, 0x74,
+	0x61, 0x22, 0x00, 0x12, 0x4f, 0x0a, 0x10, 0x43, 0x77, 0x6d, 0x70, 0x53, 0x65, 0x74, 0x50, 0x61,
+	0x72, 0x61, 0x6d, 0x73, 0x52, 0x65, 0x71, 0x12, 0x1f, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67,
+	0x72, 0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x73, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x18, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c,
+	0x67, 0x72, 0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x43, 0x6d, 0x64, 0x52, 0x65, 0x73, 0x75,
+	0x6c, 0x74, 0x22, 0x00, 0x12, 0x49, 0x0a, 0x0d, 0x43, 0x77, 0x6d, 0x70, 0x52, 0x65, 0x62, 0x6f,
+	0x6f, 0x74, 0x52, 0x65, 0x71, 0x12, 0x1c, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70,
+	0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x52, 0x65, 0x62, 0x6f, 0x6f, 0x74, 0x52, 0x65, 0x71, 0x44,
+	0x61, 0x74, 0x61, 0x1a, 0x18, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e,
+	0x43, 0x77, 0x6d, 0x70, 0x43, 0x6d, 0x64, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12,
+	0x55, 0x0a, 0x13, 0x43, 0x77, 0x6d, 0x70, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65,
+	0x73, 0x65, 0x74, 0x52, 0x65, 0x71, 0x12, 0x22, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
+	0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65,
+	0x73, 0x65, 0x74, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x18, 0x2e, 0x63, 0x6e, 0x74,
+	0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x43, 0x6d, 0x64, 0x52, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x4d, 0x0a, 0x0f, 0x43, 0x77, 0x6d, 0x70, 0x44, 0x6f,
+	0x77, 0x6e, 0x6c, 0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x12, 0x1e, 0x2e, 0x63, 0x6e, 0x74, 0x72,
+	0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66,
+	0x65, 0x72, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x18, 0x2e, 0x63, 0x6e, 0x74, 0x72,
+	0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x43, 0x6d, 0x64, 0x52, 0x65, 0x73,
+	0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x4b, 0x0a, 0x0d, 0x43, 0x77, 0x6d, 0x70, 0x55, 0x70, 0x6c,
+	0x6f, 0x61, 0x64, 0x52, 0x65, 0x71, 0x12, 0x1e, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
+	0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x52,
+	0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x18, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
+	0x70, 0x63, 0x2e, 0x43, 0x77, 0x6d, 0x70, 0x43, 0x6d, 0x64, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74,
+	0x22, 0x00, 0x12, 0x3e, 0x0a, 0x06, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x1a, 0x2e, 0x63,
 	0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61,
 	0x6d, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c,
 	0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00,
-	0x12, 0x47, 0x0a, 0x0b, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x12,
-	0x1a, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x65, 0x74, 0x50,
-	0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x1a, 0x2e, 0x63, 0x6e,
-	0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x53, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d,
-	0x52, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x49, 0x0a, 0x0f, 0x47, 0x65, 0x74,
-	0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x12, 0x1e, 0x2e, 0x63,
-	0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x49, 0x6e, 0x73, 0x74,
-	0x61, 0x6e, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63,
-	0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75,
-	0x6c, 0x74, 0x22, 0x00, 0x12, 0x50, 0x0a, 0x0e, 0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61,
-	0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x12, 0x1d, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
-	0x70, 0x63, 0x2e, 0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65,
-	0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x1d, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70,
-	0x63, 0x2e, 0x41, 0x64, 0x64, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x73,
-	0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x44, 0x0a, 0x0a, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74,
-	0x65, 0x52, 0x65, 0x71, 0x12, 0x19, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63,
-	0x2e, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a,
-	0x19, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x4f, 0x70, 0x65, 0x72,
-	0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x49, 0x0a, 0x0f,
-	0x47, 0x65, 0x74, 0x44, 0x61, 0x74, 0x61, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x12,
-	0x1e, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x44,
-	0x61, 0x74, 0x61, 0x6d, 0x6f, 0x64, 0x65, 0x6c, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a,
-	0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52,
-	0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x4d, 0x0a, 0x11, 0x44, 0x65, 0x6c, 0x65, 0x74,
-	0x65, 0x49, 0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x12, 0x20, 0x2e, 0x63,
-	0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x49,
-	0x6e, 0x73, 0x74, 0x61, 0x6e, 0x63, 0x65, 0x52, 0x65, 0x71, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x14,
-	0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e, 0x52, 0x65, 0x71, 0x52, 0x65,
-	0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x43, 0x0a, 0x0c, 0x47, 0x65, 0x74, 0x41, 0x67, 0x65,
-	0x6e, 0x74, 0x4d, 0x73, 0x67, 0x73, 0x12, 0x1b, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
-	0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x41, 0x67, 0x65, 0x6e, 0x74, 0x4d, 0x73, 0x67, 0x73, 0x44,
-	0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63, 0x2e,
-	0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x07, 0x47,
-	0x65, 0x74, 0x49, 0x6e, 0x66, 0x6f, 0x12, 0x0f, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72,
-	0x70, 0x63, 0x2e, 0x4e, 0x6f, 0x6e, 0x65, 0x1a, 0x13, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67,
-	0x72, 0x70, 0x63, 0x2e, 0x49, 0x6e, 0x66, 0x6f, 0x44, 0x61, 0x74, 0x61, 0x22, 0x00, 0x12, 0x3e,
-	0x0a, 0x06, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x12, 0x1a, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c,
-	0x67, 0x72, 0x70, 0x63, 0x2e, 0x47, 0x65, 0x74, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x52, 0x65, 0x71,
-	0x44, 0x61, 0x74, 0x61, 0x1a, 0x14, 0x2e, 0x63, 0x6e, 0x74, 0x72, 0x6c, 0x67, 0x72, 0x70, 0x63,
-	0x2e, 0x52, 0x65, 0x71, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0x00, 0x30, 0x01, 0x42, 0x31,
-	0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x34, 0x2d,
-	0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x73, 0x2f, 0x6f, 0x70, 0x65, 0x6e, 0x75, 0x73, 0x70,
-	0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x2f, 0x63, 0x6e, 0x74, 0x6c, 0x72, 0x67, 0x72, 0x70,
-	0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x30, 0x01, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d,
+	0x2f, 0x6e, 0x34, 0x2d, 0x6e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x73, 0x2f, 0x6f, 0x70, 0x65,
+	0x6e, 0x75, 0x73, 0x70, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x2f, 0x63, 0x6e, 0x74, 0x6c,
+	0x72, 0x67, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -1429,7 +2006,7 @@ func file_cntlr_proto_rawDescGZIP() []byte {
 	return file_cntlr_proto_rawDescData
 }
 
-var file_cntlr_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_cntlr_proto_msgTypes = make([]protoimpl.MessageInfo, 29)
 var file_cntlr_proto_goTypes = []interface{}{
 	(*SetParamReqData)(nil),             // 0: cntrlgrpc.SetParamReqData
 	(*SetParamResData)(nil),             // 1: cntrlgrpc.SetParamResData
@@ -1444,50 +2021,70 @@ var file_cntlr_proto_goTypes = []interface{}{
 	(*DeleteInstanceReqData)(nil),       // 10: cntrlgrpc.DeleteInstanceReqData
 	(*GetAgentMsgsData)(nil),            // 11: cntrlgrpc.GetAgentMsgsData
 	(*InfoData)(nil),                    // 12: cntrlgrpc.InfoData
-	(*None)(nil),                        // 13: cntrlgrpc.None
-	(*SetParamResData_Param)(nil),       // 14: cntrlgrpc.SetParamResData.Param
-	(*AddInstanceReqData_Object)(nil),   // 15: cntrlgrpc.AddInstanceReqData.Object
-	nil,                                 // 16: cntrlgrpc.AddInstanceReqData.Object.ParamsEntry
-	(*AddInstanceResData_Instance)(nil), // 17: cntrlgrpc.AddInstanceResData.Instance
-	nil,                                 // 18: cntrlgrpc.AddInstanceResData.Instance.UniqueKeysEntry
-	nil,                                 // 19: cntrlgrpc.OperateReqData.InputsEntry
-	(*OperateResData_OutputArgs)(nil),   // 20: cntrlgrpc.OperateResData.OutputArgs
-	nil,                                 // 21: cntrlgrpc.OperateResData.OutputArgs.OutputsEntry
+	(*AgentsData)(nil),                  // 13: cntrlgrpc.AgentsData
+	(*None)(nil),                        // 14: cntrlgrpc.None
+	(*CwmpSetParamsReqData)(nil),        // 15: cntrlgrpc.CwmpSetParamsReqData
+	(*CwmpRebootReqData)(nil),           // 16: cntrlgrpc.CwmpRebootReqData
+	(*CwmpFactoryResetReqData)(nil),     // 17: cntrlgrpc.CwmpFactoryResetReqData
+	(*CwmpTransferReqData)(nil),         // 18: cntrlgrpc.CwmpTransferReqData
+	(*CwmpCmdResult)(nil),               // 19: cntrlgrpc.CwmpCmdResult
+	(*SetParamResData_Param)(nil),       // 20: cntrlgrpc.SetParamResData.Param
+	(*AddInstanceReqData_Object)(nil),   // 21: cntrlgrpc.AddInstanceReqData.Object
+	nil,                                 // 22: cntrlgrpc.AddInstanceReqData.Object.ParamsEntry
+	(*AddInstanceResData_Instance)(nil), // 23: cntrlgrpc.AddInstanceResData.Instance
+	nil,                                 // 24: cntrlgrpc.AddInstanceResData.Instance.UniqueKeysEntry
+	nil,                                 // 25: cntrlgrpc.OperateReqData.InputsEntry
+	(*OperateResData_OutputArgs)(nil),   // 26: cntrlgrpc.OperateResData.OutputArgs
+	nil,                                 // 27: cntrlgrpc.OperateResData.OutputArgs.OutputsEntry
+	(*CwmpSetParamsReqData_Param)(nil),  // 28: cntrlgrpc.CwmpSetParamsReqData.Param
 }
 var file_cntlr_proto_depIdxs = []int32{
-	14, // 0: cntrlgrpc.SetParamResData.paramSet:type_name -> cntrlgrpc.SetParamResData.Param
-	15, // 1: cntrlgrpc.AddInstanceReqData.objs:type_name -> cntrlgrpc.AddInstanceReqData.Object
-	17, // 2: cntrlgrpc.AddInstanceResData.inst:type_name -> cntrlgrpc.AddInstanceResData.Instance
-	19, // 3: cntrlgrpc.OperateReqData.inputs:type_name -> cntrlgrpc.OperateReqData.InputsEntry
-	20, // 4: cntrlgrpc.OperateResData.args:type_name -> cntrlgrpc.OperateResData.OutputArgs
-	16, // 5: cntrlgrpc.AddInstanceReqData.Object.params:type_name -> cntrlgrpc.AddInstanceReqData.Object.ParamsEntry
-	18, // 6: cntrlgrpc.AddInstanceResData.Instance.uniqueKeys:type_name -> cntrlgrpc.AddInstanceResData.Instance.UniqueKeysEntry
-	21, // 7: cntrlgrpc.OperateResData.OutputArgs.outputs:type_name -> cntrlgrpc.OperateResData.OutputArgs.OutputsEntry
-	3,  // 8: cntrlgrpc.Grpc.GetParamReq:input_type -> cntrlgrpc.GetParamReqData
-	0,  // 9: cntrlgrpc.Grpc.SetParamReq:input_type -> cntrlgrpc.SetParamReqData
-	4,  // 10: cntrlgrpc.Grpc.GetInstancesReq:input_type -> cntrlgrpc.GetInstancesReqData
-	5,  // 11: cntrlgrpc.Grpc.AddInstanceReq:input_type -> cntrlgrpc.AddInstanceReqData
-	7,  // 12: cntrlgrpc.Grpc.OperateReq:input_type -> cntrlgrpc.OperateReqData
-	9,  // 13: cntrlgrpc.Grpc.GetDatamodelReq:input_type -> cntrlgrpc.GetDatamodelReqData
-	10, // 14: cntrlgrpc.Grpc.DeleteInstanceReq:input_type -> cntrlgrpc.DeleteInstanceReqData
-	11, // 15: cntrlgrpc.Grpc.GetAgentMsgs:input_type -> cntrlgrpc.GetAgentMsgsData
-	13, // 16: cntrlgrpc.Grpc.GetInfo:input_type -> cntrlgrpc.None
-	3,  // 17: cntrlgrpc.Grpc.Stream:input_type -> cntrlgrpc.GetParamReqData
-	2,  // 18: cntrlgrpc.Grpc.GetParamReq:output_type -> cntrlgrpc.ReqResult
-	1,  // 19: cntrlgrpc.Grpc.SetParamReq:output_type -> cntrlgrpc.SetParamResData
-	2,  // 20: cntrlgrpc.Grpc.GetInstancesReq:output_type -> cntrlgrpc.ReqResult
-	6,  // 21: cntrlgrpc.Grpc.AddInstanceReq:output_type -> cntrlgrpc.AddInstanceResData
-	8,  // 22: cntrlgrpc.Grpc.OperateReq:output_type -> cntrlgrpc.OperateResData
-	2,  // 23: cntrlgrpc.Grpc.GetDatamodelReq:output_type -> cntrlgrpc.ReqResult
-	2,  // 24: cntrlgrpc.Grpc.DeleteInstanceReq:output_type -> cntrlgrpc.ReqResult
-	2,  // 25: cntrlgrpc.Grpc.GetAgentMsgs:output_type -> cntrlgrpc.ReqResult
-	12, // 26: cntrlgrpc.Grpc.GetInfo:output_type -> cntrlgrpc.InfoData
-	2,  // 27: cntrlgrpc.Grpc.Stream:output_type -> cntrlgrpc.ReqResult
-	18, // [18:28] is the sub-list for method output_type
-	8,  // [8:18] is the sub-list for method input_type
-	8,  // [8:8] is the sub-list for extension type_name
-	8,  // [8:8] is the sub-list for extension extendee
-	0,  // [0:8] is the sub-list for field type_name
+	20, // 0: cntrlgrpc.SetParamResData.paramSet:type_name -> cntrlgrpc.SetParamResData.Param
+	21, // 1: cntrlgrpc.AddInstanceReqData.objs:type_name -> cntrlgrpc.AddInstanceReqData.Object
+	23, // 2: cntrlgrpc.AddInstanceResData.inst:type_name -> cntrlgrpc.AddInstanceResData.Instance
+	25, // 3: cntrlgrpc.OperateReqData.inputs:type_name -> cntrlgrpc.OperateReqData.InputsEntry
+	26, // 4: cntrlgrpc.OperateResData.args:type_name -> cntrlgrpc.OperateResData.OutputArgs
+	28, // 5: cntrlgrpc.CwmpSetParamsReqData.params:type_name -> cntrlgrpc.CwmpSetParamsReqData.Param
+	22, // 6: cntrlgrpc.AddInstanceReqData.Object.params:type_name -> cntrlgrpc.AddInstanceReqData.Object.ParamsEntry
+	24, // 7: cntrlgrpc.AddInstanceResData.Instance.uniqueKeys:type_name -> cntrlgrpc.AddInstanceResData.Instance.UniqueKeysEntry
+	27, // 8: cntrlgrpc.OperateResData.OutputArgs.outputs:type_name -> cntrlgrpc.OperateResData.OutputArgs.OutputsEntry
+	3,  // 9: cntrlgrpc.Grpc.GetParamReq:input_type -> cntrlgrpc.GetParamReqData
+	0,  // 10: cntrlgrpc.Grpc.SetParamReq:input_type -> cntrlgrpc.SetParamReqData
+	4,  // 11: cntrlgrpc.Grpc.GetInstancesReq:input_type -> cntrlgrpc.GetInstancesReqData
+	5,  // 12: cntrlgrpc.Grpc.AddInstanceReq:input_type -> cntrlgrpc.AddInstanceReqData
+	7,  // 13: cntrlgrpc.Grpc.OperateReq:input_type -> cntrlgrpc.OperateReqData
+	9,  // 14: cntrlgrpc.Grpc.GetDatamodelReq:input_type -> cntrlgrpc.GetDatamodelReqData
+	10, // 15: cntrlgrpc.Grpc.DeleteInstanceReq:input_type -> cntrlgrpc.DeleteInstanceReqData
+	11, // 16: cntrlgrpc.Grpc.GetAgentMsgs:input_type -> cntrlgrpc.GetAgentMsgsData
+	14, // 17: cntrlgrpc.Grpc.GetInfo:input_type -> cntrlgrpc.None
+	14, // 18: cntrlgrpc.Grpc.GetAgents:input_type -> cntrlgrpc.None
+	15, // 19: cntrlgrpc.Grpc.CwmpSetParamsReq:input_type -> cntrlgrpc.CwmpSetParamsReqData
+	16, // 20: cntrlgrpc.Grpc.CwmpRebootReq:input_type -> cntrlgrpc.CwmpRebootReqData
+	17, // 21: cntrlgrpc.Grpc.CwmpFactoryResetReq:input_type -> cntrlgrpc.CwmpFactoryResetReqData
+	18, // 22: cntrlgrpc.Grpc.CwmpDownloadReq:input_type -> cntrlgrpc.CwmpTransferReqData
+	18, // 23: cntrlgrpc.Grpc.CwmpUploadReq:input_type -> cntrlgrpc.CwmpTransferReqData
+	3,  // 24: cntrlgrpc.Grpc.Stream:input_type -> cntrlgrpc.GetParamReqData
+	2,  // 25: cntrlgrpc.Grpc.GetParamReq:output_type -> cntrlgrpc.ReqResult
+	1,  // 26: cntrlgrpc.Grpc.SetParamReq:output_type -> cntrlgrpc.SetParamResData
+	2,  // 27: cntrlgrpc.Grpc.GetInstancesReq:output_type -> cntrlgrpc.ReqResult
+	6,  // 28: cntrlgrpc.Grpc.AddInstanceReq:output_type -> cntrlgrpc.AddInstanceResData
+	8,  // 29: cntrlgrpc.Grpc.OperateReq:output_type -> cntrlgrpc.OperateResData
+	2,  // 30: cntrlgrpc.Grpc.GetDatamodelReq:output_type -> cntrlgrpc.ReqResult
+	2,  // 31: cntrlgrpc.Grpc.DeleteInstanceReq:output_type -> cntrlgrpc.ReqResult
+	2,  // 32: cntrlgrpc.Grpc.GetAgentMsgs:output_type -> cntrlgrpc.ReqResult
+	12, // 33: cntrlgrpc.Grpc.GetInfo:output_type -> cntrlgrpc.InfoData
+	13, // 34: cntrlgrpc.Grpc.GetAgents:output_type -> cntrlgrpc.AgentsData
+	19, // 35: cntrlgrpc.Grpc.CwmpSetParamsReq:output_type -> cntrlgrpc.CwmpCmdResult
+	19, // 36: cntrlgrpc.Grpc.CwmpRebootReq:output_type -> cntrlgrpc.CwmpCmdResult
+	19, // 37: cntrlgrpc.Grpc.CwmpFactoryResetReq:output_type -> cntrlgrpc.CwmpCmdResult
+	19, // 38: cntrlgrpc.Grpc.CwmpDownloadReq:output_type -> cntrlgrpc.CwmpCmdResult
+	19, // 39: cntrlgrpc.Grpc.CwmpUploadReq:output_type -> cntrlgrpc.CwmpCmdResult
+	2,  // 40: cntrlgrpc.Grpc.Stream:output_type -> cntrlgrpc.ReqResult
+	25, // [25:41] is the sub-list for method output_type
+	9,  // [9:25] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
 }
 
 func init() { file_cntlr_proto_init() }
@@ -1653,7 +2250,7 @@ func file_cntlr_proto_init() {
 			}
 		}
 		file_cntlr_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*None); i {
+			switch v := v.(*AgentsData); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1665,7 +2262,7 @@ func file_cntlr_proto_init() {
 			}
 		}
 		file_cntlr_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*SetParamResData_Param); i {
+			switch v := v.(*None); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1677,7 +2274,19 @@ func file_cntlr_proto_init() {
 			}
 		}
 		file_cntlr_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*AddInstanceReqData_Object); i {
+			switch v := v.(*CwmpSetParamsReqData); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CwmpRebootReqData); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1689,7 +2298,31 @@ func file_cntlr_proto_init() {
 			}
 		}
 		file_cntlr_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*AddInstanceResData_Instance); i {
+			switch v := v.(*CwmpFactoryResetReqData); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CwmpTransferReqData); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CwmpCmdResult); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1701,6 +2334,42 @@ func file_cntlr_proto_init() {
 			}
 		}
 		file_cntlr_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SetParamResData_Param); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[21].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddInstanceReqData_Object); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[23].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AddInstanceResData_Instance); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_cntlr_proto_msgTypes[26].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*OperateResData_OutputArgs); i {
 			case 0:
 				return &v.state
@@ -1712,6 +2381,18 @@ func file_cntlr_proto_init() {
 				return nil
 			}
 		}
+		file_cntlr_proto_msgTypes[28].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CwmpSetParamsReqData_Param); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	file_cntlr_proto_msgTypes[8].OneofWrappers = []interface{}{
 		(*OperateResData_Path)(nil),
@@ -1724,7 +2405,7 @@ func file_cntlr_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_cntlr_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   22,
+			NumMessages:   29,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
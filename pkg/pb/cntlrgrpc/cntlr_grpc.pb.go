@@ -31,6 +31,12 @@ type GrpcClient interface {
 	DeleteInstanceReq(ctx context.Context, in *DeleteInstanceReqData, opts ...grpc.CallOption) (*ReqResult, error)
 	GetAgentMsgs(ctx context.Context, in *GetAgentMsgsData, opts ...grpc.CallOption) (*ReqResult, error)
 	GetInfo(ctx context.Context, in *None, opts ...grpc.CallOption) (*InfoData, error)
+	GetAgents(ctx context.Context, in *None, opts ...grpc.CallOption) (*AgentsData, error)
+	CwmpSetParamsReq(ctx context.Context, in *CwmpSetParamsReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error)
+	CwmpRebootReq(ctx context.Context, in *CwmpRebootReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error)
+	CwmpFactoryResetReq(ctx context.Context, in *CwmpFactoryResetReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error)
+	CwmpDownloadReq(ctx context.Context, in *CwmpTransferReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error)
+	CwmpUploadReq(ctx context.Context, in *CwmpTransferReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error)
 	Stream(ctx context.Context, in *GetParamReqData, opts ...grpc.CallOption) (Grpc_StreamClient, error)
 }
 
@@ -123,6 +129,60 @@ func (c *grpcClient) GetInfo(ctx context.Context, in *None, opts ...grpc.CallOpt
 	return out, nil
 }
 
+func (c *grpcClient) GetAgents(ctx context.Context, in *None, opts ...grpc.CallOption) (*AgentsData, error) {
+	out := new(AgentsData)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/GetAgents", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *grpcClient) CwmpSetParamsReq(ctx context.Context, in *CwmpSetParamsReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error) {
+	out := new(CwmpCmdResult)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/CwmpSetParamsReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *grpcClient) CwmpRebootReq(ctx context.Context, in *CwmpRebootReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error) {
+	out := new(CwmpCmdResult)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/CwmpRebootReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *grpcClient) CwmpFactoryResetReq(ctx context.Context, in *CwmpFactoryResetReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error) {
+	out := new(CwmpCmdResult)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/CwmpFactoryResetReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *grpcClient) CwmpDownloadReq(ctx context.Context, in *CwmpTransferReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error) {
+	out := new(CwmpCmdResult)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/CwmpDownloadReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *grpcClient) CwmpUploadReq(ctx context.Context, in *CwmpTransferReqData, opts ...grpc.CallOption) (*CwmpCmdResult, error) {
+	out := new(CwmpCmdResult)
+	err := c.cc.Invoke(ctx, "/cntrlgrpc.Grpc/CwmpUploadReq", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *grpcClient) Stream(ctx context.Context, in *GetParamReqData, opts ...grpc.CallOption) (Grpc_StreamClient, error) {
 	stream, err := c.cc.NewStream(ctx, &Grpc_ServiceDesc.Streams[0], "/cntrlgrpc.Grpc/Stream", opts...)
 	if err != nil {
@@ -168,6 +228,12 @@ type GrpcServer interface {
 	DeleteInstanceReq(context.Context, *DeleteInstanceReqData) (*ReqResult, error)
 	GetAgentMsgs(context.Context, *GetAgentMsgsData) (*ReqResult, error)
 	GetInfo(context.Context, *None) (*InfoData, error)
+	GetAgents(context.Context, *None) (*AgentsData, error)
+	CwmpSetParamsReq(context.Context, *CwmpSetParamsReqData) (*CwmpCmdResult, error)
+	CwmpRebootReq(context.Context, *CwmpRebootReqData) (*CwmpCmdResult, error)
+	CwmpFactoryResetReq(context.Context, *CwmpFactoryResetReqData) (*CwmpCmdResult, error)
+	CwmpDownloadReq(context.Context, *CwmpTransferReqData) (*CwmpCmdResult, error)
+	CwmpUploadReq(context.Context, *CwmpTransferReqData) (*CwmpCmdResult, error)
 	Stream(*GetParamReqData, Grpc_StreamServer) error
 	mustEmbedUnimplementedGrpcServer()
 }
@@ -203,6 +269,24 @@ func (UnimplementedGrpcServer) GetAgentMsgs(context.Context, *GetAgentMsgsData)
 func (UnimplementedGrpcServer) GetInfo(context.Context, *None) (*InfoData, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInfo not implemented")
 }
+func (UnimplementedGrpcServer) GetAgents(context.Context, *None) (*AgentsData, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgents not implemented")
+}
+func (UnimplementedGrpcServer) CwmpSetParamsReq(context.Context, *CwmpSetParamsReqData) (*CwmpCmdResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CwmpSetParamsReq not implemented")
+}
+func (UnimplementedGrpcServer) CwmpRebootReq(context.Context, *CwmpRebootReqData) (*CwmpCmdResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CwmpRebootReq not implemented")
+}
+func (UnimplementedGrpcServer) CwmpFactoryResetReq(context.Context, *CwmpFactoryResetReqData) (*CwmpCmdResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CwmpFactoryResetReq not implemented")
+}
+func (UnimplementedGrpcServer) CwmpDownloadReq(context.Context, *CwmpTransferReqData) (*CwmpCmdResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CwmpDownloadReq not implemented")
+}
+func (UnimplementedGrpcServer) CwmpUploadReq(context.Context, *CwmpTransferReqData) (*CwmpCmdResult, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CwmpUploadReq not implemented")
+}
 func (UnimplementedGrpcServer) Stream(*GetParamReqData, Grpc_StreamServer) error {
 	return status.Errorf(codes.Unimplemented, "method Stream not implemented")
 }
@@ -381,6 +465,114 @@ func _Grpc_GetInfo_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Grpc_GetAgents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(None)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).GetAgents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/GetAgents",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).GetAgents(ctx, req.(*None))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Grpc_CwmpSetParamsReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CwmpSetParamsReqData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).CwmpSetParamsReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/CwmpSetParamsReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).CwmpSetParamsReq(ctx, req.(*CwmpSetParamsReqData))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Grpc_CwmpRebootReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CwmpRebootReqData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).CwmpRebootReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/CwmpRebootReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).CwmpRebootReq(ctx, req.(*CwmpRebootReqData))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Grpc_CwmpFactoryResetReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CwmpFactoryResetReqData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).CwmpFactoryResetReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/CwmpFactoryResetReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).CwmpFactoryResetReq(ctx, req.(*CwmpFactoryResetReqData))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Grpc_CwmpDownloadReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CwmpTransferReqData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).CwmpDownloadReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/CwmpDownloadReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).CwmpDownloadReq(ctx, req.(*CwmpTransferReqData))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Grpc_CwmpUploadReq_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CwmpTransferReqData)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GrpcServer).CwmpUploadReq(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/cntrlgrpc.Grpc/CwmpUploadReq",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GrpcServer).CwmpUploadReq(ctx, req.(*CwmpTransferReqData))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _Grpc_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(GetParamReqData)
 	if err := stream.RecvMsg(m); err != nil {
@@ -445,6 +637,30 @@ var Grpc_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetInfo",
 			Handler:    _Grpc_GetInfo_Handler,
 		},
+		{
+			MethodName: "GetAgents",
+			Handler:    _Grpc_GetAgents_Handler,
+		},
+		{
+			MethodName: "CwmpSetParamsReq",
+			Handler:    _Grpc_CwmpSetParamsReq_Handler,
+		},
+		{
+			MethodName: "CwmpRebootReq",
+			Handler:    _Grpc_CwmpRebootReq_Handler,
+		},
+		{
+			MethodName: "CwmpFactoryResetReq",
+			Handler:    _Grpc_CwmpFactoryResetReq_Handler,
+		},
+		{
+			MethodName: "CwmpDownloadReq",
+			Handler:    _Grpc_CwmpDownloadReq_Handler,
+		},
+		{
+			MethodName: "CwmpUploadReq",
+			Handler:    _Grpc_CwmpUploadReq_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -0,0 +1,156 @@
+// OpenUSP dashboard: a hash-routed single page using the REST API of the
+// server it is served from, which also handles authentication.
+'use strict';
+
+const view = document.getElementById('view');
+const errorBox = document.getElementById('error');
+
+// Base of the REST API version the dashboard is written against
+const apiBase = '/api/v1';
+
+async function api(path) {
+  const res = await fetch(apiBase + path, {headers: {Accept: 'application/json'}});
+  const body = await res.json().catch(() => null);
+  if (!res.ok) {
+    throw new Error((body && (body.detail || body.title)) || res.statusText);
+  }
+  return body;
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  Object.entries(attrs || {}).forEach(([k, v]) => {
+    if (k.startsWith('on')) {
+      node.addEventListener(k.slice(2), v);
+    } else {
+      node.setAttribute(k, v);
+    }
+  });
+  children.flat().forEach(c => node.append(c instanceof Node ? c : String(c ?? '')));
+  return node;
+}
+
+function table(columns, rows, onClick) {
+  return el('table', {},
+    el('thead', {}, el('tr', {}, columns.map(c => el('th', {}, c.title)))),
+    el('tbody', {}, rows.map(row => el('tr', onClick ? {class: 'link', onclick: () => onClick(row)} : {},
+      columns.map(c => el('td', {}, c.render ? c.render(row) : row[c.key]))))));
+}
+
+function status(value) {
+  return el('span', {class: value}, value);
+}
+
+function deviceLink(id) {
+  return '#/device/' + encodeURIComponent(id);
+}
+
+async function showDevices() {
+  const search = el('input', {type: 'search', placeholder: 'Search serial, manufacturer, IP...'});
+  const list = el('div');
+  view.replaceChildren(el('h2', {}, 'Devices'), search, list);
+
+  const page = (await api('/cwmp/devices/?limit=1000&sort_by=serial_number')) || {devices: [], total: 0};
+  const devices = page.devices;
+  const render = () => {
+    const q = search.value.toLowerCase();
+    const matches = devices.filter(d => !q || [d.device_id, d.serial_number, d.manufacturer,
+      d.product_class, d.software_version, d.ip_address].some(v => (v || '').toLowerCase().includes(q)));
+    list.replaceChildren(el('p', {}, matches.length + ' of ' + page.total + ' devices'), table([
+      {title: 'Serial', key: 'serial_number'},
+      {title: 'Manufacturer', key: 'manufacturer'},
+      {title: 'Product class', key: 'product_class'},
+      {title: 'Software', key: 'software_version'},
+      {title: 'IP address', key: 'ip_address'},
+      {title: 'Last inform', key: 'last_inform_time'},
+      {title: 'Status', render: d => status(d.is_online ? 'online' : 'offline')},
+    ], matches, d => { location.hash = deviceLink(d.device_id); }));
+  };
+  search.addEventListener('input', render);
+  render();
+}
+
+async function showDevice(id) {
+  const path = '/cwmp/device/' + encodeURIComponent(id);
+  const [device, params, timeline, jobs] = await Promise.all([
+    api(path),
+    api(path + '/params'),
+    api(path + '/timeline?limit=100'),
+    api('/cwmp/sync-jobs/?device_id=' + encodeURIComponent(id) + '&limit=10'),
+  ]);
+
+  view.replaceChildren(
+    el('h2', {}, device.manufacturer + ' ' + device.product_class + ' ' + device.serial_number),
+    table([{title: 'Field', key: 'field'}, {title: 'Value', key: 'value'}], [
+      {field: 'Device ID', value: device.device_id},
+      {field: 'Status', value: status(device.is_online ? 'online' : 'offline')},
+      {field: 'Software version', value: device.software_version},
+      {field: 'Hardware version', value: device.hardware_version},
+      {field: 'IP address', value: device.ip_address},
+      {field: 'Last inform', value: device.last_inform_time},
+    ]),
+    el('h2', {}, 'Sync jobs'),
+    syncJobTable(jobs || []),
+    el('h2', {}, 'Timeline'),
+    table([
+      {title: 'Time', key: 'timestamp'},
+      {title: 'Kind', key: 'kind'},
+      {title: 'Summary', key: 'summary'},
+    ], timeline.entries || []),
+    el('h2', {}, 'Parameters (' + params.count + ')'),
+    table([
+      {title: 'Name', key: 'Name'},
+      {title: 'Value', key: 'Value'},
+      {title: 'Type', key: 'Type'},
+    ], (params.parameters || []).sort((a, b) => a.Name.localeCompare(b.Name))),
+  );
+}
+
+function syncJobTable(jobs) {
+  return table([
+    {title: 'Created', key: 'created_at'},
+    {title: 'Device', render: j => el('a', {href: deviceLink(j.device_id)}, j.device_id)},
+    {title: 'Trigger', key: 'trigger'},
+    {title: 'Status', render: j => status(j.status)},
+    {title: 'Steps', render: j => j.steps.map(s => s.name + ': ' + s.status).join(', ')},
+  ], jobs);
+}
+
+async function showJobs() {
+  const [jobs, transfers] = await Promise.all([
+    api('/cwmp/sync-jobs/?limit=50'),
+    api('/cwmp/transfers/?limit=50'),
+  ]);
+  view.replaceChildren(
+    el('h2', {}, 'Sync jobs'),
+    syncJobTable(jobs || []),
+    el('h2', {}, 'File transfers'),
+    table([
+      {title: 'Created', key: 'created_at'},
+      {title: 'Device', render: t => el('a', {href: deviceLink(t.device_id)}, t.device_id)},
+      {title: 'File type', key: 'file_type'},
+      {title: 'Command key', key: 'command_key'},
+      {title: 'Status', render: t => status(t.status)},
+    ], transfers || []),
+  );
+}
+
+async function route() {
+  errorBox.hidden = true;
+  const hash = location.hash || '#/devices';
+  try {
+    if (hash.startsWith('#/device/')) {
+      await showDevice(decodeURIComponent(hash.slice('#/device/'.length)));
+    } else if (hash === '#/jobs') {
+      await showJobs();
+    } else {
+      await showDevices();
+    }
+  } catch (err) {
+    errorBox.textContent = err.message;
+    errorBox.hidden = false;
+  }
+}
+
+window.addEventListener('hashchange', route);
+route();